@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// workload describes the key-value pairs generated by the fill and bench commands.
+type workload struct {
+	keyPattern string // "seq", "random" or a format string containing %d (ex: "user:%d")
+	valueSizes string // "fixed:N", "random:MIN-MAX" or "zipf:MAX"
+	workers    int
+}
+
+func defaultWorkload() *workload {
+	return &workload{keyPattern: "seq", valueSizes: "fixed:25", workers: 1}
+}
+
+// parseWorkload parses options of the form "name=value" (keys, values and workers).
+func parseWorkload(options []string) (*workload, error) {
+	wl := defaultWorkload()
+	for _, option := range options {
+		name, value, ok := strings.Cut(option, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid option %q, expected name=value", option)
+		}
+		switch name {
+		case "keys":
+			if value != "seq" && value != "random" && strings.Contains(fmt.Sprintf(value, 0), "%!") {
+				return nil, fmt.Errorf("invalid key pattern %q, expected seq, random or a format string containing %%d", value)
+			}
+			wl.keyPattern = value
+		case "values":
+			wl.valueSizes = value
+		case "workers":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid number of workers: %q", value)
+			}
+			wl.workers = n
+		default:
+			return nil, fmt.Errorf("unknown option %q", name)
+		}
+	}
+	if _, err := wl.valueSizeGenerator(rand.New(rand.NewSource(0))); err != nil {
+		return nil, err
+	}
+	return wl, nil
+}
+
+func (wl *workload) key(i int) []byte {
+	switch {
+	case wl.keyPattern == "seq":
+		return []byte(strconv.Itoa(i))
+	case wl.keyPattern == "random":
+		return tridb.MustNewRandID(8).Hex()
+	default:
+		return []byte(fmt.Sprintf(wl.keyPattern, i))
+	}
+}
+
+// valueSizeGenerator returns a function generating value sizes with the configured distribution.
+func (wl *workload) valueSizeGenerator(rng *rand.Rand) (func() int, error) {
+	kind, params, _ := strings.Cut(wl.valueSizes, ":")
+	switch kind {
+	case "fixed":
+		size, err := strconv.Atoi(params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixed value size: %w", err)
+		} else if size < 0 {
+			return nil, fmt.Errorf("invalid fixed value size: %d", size)
+		}
+		return func() int { return size }, nil
+	case "random":
+		minStr, maxStr, _ := strings.Cut(params, "-")
+		min, err1 := strconv.Atoi(minStr)
+		max, err2 := strconv.Atoi(maxStr)
+		if err1 != nil || err2 != nil || min < 0 || max < min {
+			return nil, fmt.Errorf("invalid random value size range: %q", params)
+		}
+		return func() int { return min + rng.Intn(max-min+1) }, nil
+	case "zipf":
+		max, err := strconv.ParseUint(params, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zipfian max value size: %w", err)
+		}
+		zipf := rand.NewZipf(rng, 1.1, 1, max)
+		return func() int { return int(zipf.Uint64()) }, nil
+	default:
+		return nil, fmt.Errorf("unknown value size distribution %q", kind)
+	}
+}
+
+func randomValue(rng *rand.Rand, size int) []byte {
+	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	v := make([]byte, size)
+	for i := range v {
+		v[i] = charset[rng.Intn(len(charset))]
+	}
+	return v
+}
+
+// latencyBuckets is the number of buckets of the latency histogram of benchmarks:
+// 8 buckets per power of two nanoseconds, so that percentiles are within 12.5% of the measured latencies.
+const latencyBuckets = 62 * 8
+
+// latencyBucket returns the index of the histogram bucket holding d.
+func latencyBucket(d time.Duration) int {
+	n := uint64(max(d, 0))
+	e := bits.Len64(n)
+	if e <= 3 {
+		return int(n)
+	}
+	return (e-3)*8 + int(n>>(e-4))&7
+}
+
+// bucketLatency returns the lower bound of the histogram bucket i.
+func bucketLatency(i int) time.Duration {
+	if i < 8 {
+		return time.Duration(i)
+	}
+	return time.Duration(8+i%8) << (i/8 - 1)
+}
+
+// benchStats collects progress and latencies of a running benchmark.
+type benchStats struct {
+	f           *tridb.File
+	syncs       int // number of syncs of the file before the benchmark
+	rows, bytes atomic.Int64
+	latencies   [latencyBuckets]atomic.Int64 // histogram of transaction latencies
+}
+
+func newBenchStats(f *tridb.File) *benchStats {
+	return &benchStats{f: f, syncs: f.Stats().SyncLatency.Syncs}
+}
+
+func (s *benchStats) record(latency time.Duration, size int) {
+	s.rows.Add(1)
+	s.bytes.Add(int64(size))
+	s.latencies[latencyBucket(latency)].Add(1)
+}
+
+func (s *benchStats) percentile(p float64) time.Duration {
+	var counts [latencyBuckets]int64
+	total := int64(0)
+	for i := range s.latencies {
+		counts[i] = s.latencies[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(float64(total-1) * p)
+	for i, n := range counts {
+		if rank -= n; rank < 0 {
+			return bucketLatency(i)
+		}
+	}
+	return bucketLatency(latencyBuckets - 1)
+}
+
+func (s *benchStats) print(total int, elapsed time.Duration) {
+	rows := s.rows.Load()
+	syncs := s.f.Stats().SyncLatency.Syncs - s.syncs
+	fmt.Printf("\r%d/%d rows, %d syncs, %.1f MB, %.f rows/s, p99 %s    ",
+		rows, total, syncs, float64(s.bytes.Load())/1e6, float64(rows)/elapsed.Seconds(), s.percentile(0.99))
+}
+
+// runBench writes num key-value pairs (one transaction per row) and prints a live progress display.
+func runBench(f *tridb.File, num int, wl *workload) error {
+	start := time.Now()
+	stats := newBenchStats(f)
+	next := atomic.Int64{}
+	errs := make(chan error, wl.workers)
+	wg := sync.WaitGroup{}
+	for w := 0; w < wl.workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			valueSize, _ := wl.valueSizeGenerator(rng)
+			for i := int(next.Add(1)) - 1; i < num; i = int(next.Add(1)) - 1 {
+				key, value := wl.key(i), randomValue(rng, valueSize())
+				txStart := time.Now()
+				err := f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+					w.Set(key, value)
+					return nil
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				stats.record(time.Since(txStart), len(key)+len(value))
+			}
+		}(time.Now().UnixNano() + int64(w))
+	}
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stats.print(num, time.Since(start))
+		case err := <-errs:
+			fmt.Println()
+			return err
+		case <-done:
+			stats.print(num, time.Since(start))
+			fmt.Printf("\ndone in %s (p50 %s, p99 %s)\n", time.Since(start), stats.percentile(0.5), stats.percentile(0.99))
+			return nil
+		}
+	}
+}
+
+// runFill writes num key-value pairs in a single transaction.
+func runFill(f *tridb.File, num int, wl *workload) error {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	valueSize, _ := wl.valueSizeGenerator(rng)
+	size := 0
+	return f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		for i := 0; i < num; i++ {
+			key, value := wl.key(i), randomValue(rng, valueSize())
+			size += len(key) + len(value)
+			w.Set(key, value)
+			if (i+1)%100_000 == 0 {
+				fmt.Printf("\rstaged %d/%d rows (%.1f MB)", i+1, num, float64(size)/1e6)
+			}
+		}
+		fmt.Printf("\rstaged %d/%d rows (%.1f MB), writing...\n", num, num, float64(size)/1e6)
+		return nil
+	})
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// Exit codes of the non-interactive subcommands.
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitNotFound  = 3 // get: the key doesn't exist
+	exitCorrupted = 4 // verify: corruptions were found
+)
+
+const cliUsage = `usage:
+  tridb <database file>                            interactive mode
+  tridb serve [flags] <database file>              serve the database (see tridb serve -h)
+  tridb get [flags] <database file> <key>          print the value of a key (exit code 3 if not found)
+  tridb set [flags] <database file> <key> <value>  set a key ("-" reads the value from stdin)
+  tridb delete [flags] <database file> <key>       delete a key
+  tridb dump [flags] <database file>               print all key-value pairs (see tridb.File.Dump)
+  tridb import [flags] <database file>             bulk load key-value pairs from stdin (see tridb.File.BulkLoad)
+  tridb compact [flags] <database file>            compact the file
+  tridb verify [flags] <database file>             verify the file (exit code 4 if corrupted)
+
+Flags of subcommands:
+`
+
+// subcommand runs a non-interactive subcommand with its positional arguments, it returns the exit code.
+type subcommand struct {
+	args     []string // names of the positional arguments
+	readOnly bool     // the file is opened read-only (it can be used while another process writes it)
+	run      func(f *tridb.File, c *cliConfig, args []string) int
+}
+
+var subcommands = map[string]*subcommand{
+	"get":     {args: []string{"file", "key"}, readOnly: true, run: runGet},
+	"set":     {args: []string{"file", "key", "value"}, run: runSet},
+	"delete":  {args: []string{"file", "key"}, run: runDelete},
+	"dump":    {args: []string{"file"}, readOnly: true, run: runDump},
+	"import":  {args: []string{"file"}, run: runImport},
+	"compact": {args: []string{"file"}, run: runCompact},
+	"verify":  {args: []string{"file"}, readOnly: true, run: runVerify},
+}
+
+// cliConfig holds the flags shared by subcommands.
+type cliConfig struct {
+	json        bool // output JSON instead of text
+	base64      bool // keys and values are base64-encoded in JSON output (for binary data)
+	input       tridb.ExportFormat
+	lockTimeout time.Duration
+	out         io.Writer
+}
+
+// runSubcommand runs the given subcommand, flags may be placed before or after positional arguments.
+func runSubcommand(name string, args []string) int {
+	cmd := subcommands[name]
+	c := &cliConfig{out: os.Stdout}
+	format, input := "", ""
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), cliUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&format, "format", "text", "output format: text or json")
+	fs.BoolVar(&c.base64, "base64", false, "encode keys and values in base64 in JSON output (and decode them on import)")
+	fs.StringVar(&input, "input", "jsonl", "input format of import: jsonl or csv (see tridb.File.ExportTo)")
+	fs.DurationVar(&c.lockTimeout, "lock-timeout", 0, "how long to wait for another process writing to the file to close it")
+	var positional []string
+	for {
+		if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		} else if err != nil {
+			return exitUsage
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional, args = append(positional, fs.Arg(0)), fs.Args()[1:]
+	}
+	switch {
+	case len(positional) != len(cmd.args):
+		fmt.Fprintf(os.Stderr, "tridb %s: expected %d argument(s): %s\n", name, len(cmd.args), strings.Join(cmd.args, ", "))
+		return exitUsage
+	case format != "text" && format != "json":
+		fmt.Fprintf(os.Stderr, "tridb %s: unknown output format: %q\n", name, format)
+		return exitUsage
+	case input != "jsonl" && input != "csv":
+		fmt.Fprintf(os.Stderr, "tridb %s: unknown input format: %q\n", name, input)
+		return exitUsage
+	}
+	c.json = format == "json"
+	if c.input = tridb.ExportJSONL; input == "csv" {
+		c.input = tridb.ExportCSV
+	}
+	if c.base64 {
+		c.input |= tridb.ExportBase64
+	}
+
+	opts := []tridb.Option{tridb.WithLockTimeout(c.lockTimeout)}
+	if cmd.readOnly {
+		opts = append(opts, tridb.WithReadOnly())
+	}
+	f, err := tridb.Open(positional[0], 1<<20, opts...)
+	if err != nil {
+		return exitWithError(name, err)
+	}
+	code := cmd.run(f, c, positional[1:])
+	if err := f.Close(); err != nil && code == exitOK {
+		return exitWithError(name, fmt.Errorf("close file: %w", err))
+	}
+	return code
+}
+
+// exitWithError prints the given error to stderr and returns the matching exit code.
+func exitWithError(name string, err error) int {
+	fmt.Fprintf(os.Stderr, "tridb %s: %v\n", name, err)
+	return exitError
+}
+
+// encode returns the given key or value as a JSON string (base64-encoded if configured).
+func (c *cliConfig) encode(b []byte) string {
+	if c.base64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
+
+// writeJSON writes v as a line of JSON.
+func (c *cliConfig) writeJSON(v any) {
+	enc := json.NewEncoder(c.out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func runGet(f *tridb.File, c *cliConfig, args []string) int {
+	key := []byte(args[0])
+	var value []byte
+	err := f.Read(func(r *tridb.Reader) (err error) {
+		value, err = r.GetExisting(key)
+		return err
+	})
+	found := !errors.Is(err, tridb.ErrKeyNotFound)
+	if err != nil && found {
+		return exitWithError("get", err)
+	}
+	if c.json {
+		record := struct {
+			Key   string  `json:"key"`
+			Value *string `json:"value"`
+			Found bool    `json:"found"`
+		}{Key: c.encode(key), Found: found}
+		if found {
+			v := c.encode(value)
+			record.Value = &v
+		}
+		c.writeJSON(record)
+	} else if found {
+		c.out.Write(value) // verbatim, for pipes and command substitutions
+	}
+	if !found {
+		return exitNotFound
+	}
+	return exitOK
+}
+
+func runSet(f *tridb.File, c *cliConfig, args []string) int {
+	key, value := []byte(args[0]), []byte(args[1])
+	if args[1] == "-" {
+		var err error
+		if value, err = io.ReadAll(os.Stdin); err != nil {
+			return exitWithError("set", fmt.Errorf("read value: %w", err))
+		}
+	}
+	err := f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		w.Set(key, value)
+		return nil
+	})
+	if err != nil {
+		return exitWithError("set", err)
+	}
+	if c.json {
+		c.writeJSON(map[string]any{"key": c.encode(key), "size": len(value)})
+	}
+	return exitOK
+}
+
+func runDelete(f *tridb.File, c *cliConfig, args []string) int {
+	key := []byte(args[0])
+	existed := false
+	err := f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		existed = r.Has(key)
+		w.Delete(key)
+		return nil
+	})
+	if err != nil {
+		return exitWithError("delete", err)
+	}
+	if c.json {
+		c.writeJSON(map[string]any{"key": c.encode(key), "existed": existed})
+	}
+	return exitOK
+}
+
+func runDump(f *tridb.File, c *cliConfig, args []string) int {
+	var err error
+	switch {
+	case c.json && c.base64:
+		err = f.ExportTo(c.out, tridb.ExportJSONL|tridb.ExportBase64)
+	case c.json:
+		err = f.ExportTo(c.out, tridb.ExportJSONL)
+	default:
+		err = f.Dump(c.out)
+	}
+	if err != nil {
+		return exitWithError("dump", err)
+	}
+	return exitOK
+}
+
+func runImport(f *tridb.File, c *cliConfig, args []string) int {
+	start := time.Now()
+	n, err := f.BulkLoad(os.Stdin, c.input, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tridb import: loaded %d key-value pairs before failing\n", n)
+		return exitWithError("import", err)
+	}
+	if c.json {
+		c.writeJSON(map[string]any{"loaded": n, "seconds": time.Since(start).Seconds()})
+	} else {
+		fmt.Fprintf(c.out, "loaded %d key-value pairs in %s\n", n, time.Since(start))
+	}
+	return exitOK
+}
+
+func runCompact(f *tridb.File, c *cliConfig, args []string) int {
+	start, before := time.Now(), f.Stats().FileSize
+	if err := f.Compact(); err != nil {
+		return exitWithError("compact", err)
+	}
+	after, elapsed := f.Stats().FileSize, time.Since(start)
+	if c.json {
+		c.writeJSON(map[string]any{"before": before, "after": after, "seconds": elapsed.Seconds()})
+	} else {
+		fmt.Fprintf(c.out, "compacted %d bytes to %d bytes in %s\n", before, after, elapsed)
+	}
+	return exitOK
+}
+
+func runVerify(f *tridb.File, c *cliConfig, args []string) int {
+	start := time.Now()
+	report, err := f.Verify(nil)
+	if err != nil {
+		return exitWithError("verify", err)
+	}
+	if c.json {
+		type problem struct {
+			Offset int    `json:"offset"`
+			Key    string `json:"key"`
+			Error  string `json:"error"`
+		}
+		problems := []problem{}
+		for _, p := range report.Problems {
+			problems = append(problems, problem{p.Offset, c.encode(p.Key), p.Err.Error()})
+		}
+		c.writeJSON(map[string]any{
+			"ok": report.OK(), "rows": report.Rows, "live_rows": report.LiveRows,
+			"file_size": report.FileSize, "dead_bytes": report.DeadBytes,
+			"checksum_errors": report.ChecksumErrors, "first_corruption": report.FirstCorruption,
+			"problems": problems,
+		})
+	} else {
+		printVerifyReport(c.out, report, time.Since(start))
+	}
+	if !report.OK() {
+		return exitCorrupted
+	}
+	return exitOK
+}
+
+// printVerifyReport writes a human-readable verification report.
+func printVerifyReport(w io.Writer, report *tridb.VerifyReport, elapsed time.Duration) {
+	fmt.Fprintf(w, "verified %d rows (%d live) in %s\n", report.Rows, report.LiveRows, elapsed)
+	fmt.Fprintf(w, "dead bytes: %d of %d (%.1f%%)\n", report.DeadBytes, report.FileSize, 100*report.DeadRatio)
+	if report.OK() {
+		fmt.Fprintln(w, "no corruption found")
+		return
+	}
+	fmt.Fprintf(w, "first corruption at offset %d, %d checksum error(s)\n", report.FirstCorruption, report.ChecksumErrors)
+	for _, problem := range report.Problems {
+		fmt.Fprintf(w, "  offset %d %q: %v\n", problem.Offset, problem.Key, problem.Err)
+	}
+}
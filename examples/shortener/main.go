@@ -0,0 +1,85 @@
+// Command shortener is a URL shortener web app storing its links in a tridb file.
+//
+// Links can expire (see Writer.SetWithTTL), their IDs are random (see RandID),
+// the database is backed up incrementally (see File.BackupSince) and exposed to admins over HTTP (see package tridbhttp).
+//
+// Usage:
+//
+//	go run ./examples/shortener -addr :8080 -db links.tridb -backup links.backup.tridb -admin-token secret
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	dbPath := flag.String("db", "links.tridb", "path of the database file")
+	baseURL := flag.String("base-url", "http://localhost:8080", "base URL of the short links")
+	backupPath := flag.String("backup", "", "path of the backup file (empty disables backups)")
+	backupInterval := flag.Duration("backup-interval", time.Minute, "time between two incremental backups")
+	adminToken := flag.String("admin-token", "", "token required by the admin API (empty disables it)")
+	flag.Parse()
+
+	f, err := tridb.Open(*dbPath, 1024)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	// Back up the rows written since the previous backup, and compact the file (expired links are removed)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(*backupInterval)
+		defer ticker.Stop()
+		offset := 0
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if *backupPath != "" {
+				var err error
+				if offset, err = backup(f, *backupPath, offset); err != nil {
+					log.Println("backup:", err)
+				}
+			}
+			if stats := f.Stats(); stats.Rows > 2*stats.Keys {
+				if err := f.Compact(); err != nil {
+					log.Println("compact:", err)
+				}
+			}
+		}
+	}()
+
+	srv := &http.Server{Addr: *addr, Handler: newShortener(f, *baseURL, *adminToken)}
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	log.Printf("serving %q on %s", f.Path(), *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Println(err)
+	}
+	close(stop)
+	<-done
+	if *backupPath != "" {
+		if _, err := backup(f, *backupPath, 0); err != nil {
+			log.Println("backup:", err)
+		}
+	}
+}
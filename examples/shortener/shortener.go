@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+	"github.com/ejuju/tridb/pkg/tridbhttp"
+)
+
+// Links are stored under "links/<id>" (with an expiration time if they were created with a TTL),
+// their number of visits under "clicks/<id>".
+const (
+	linkPrefix  = "links/"
+	clickPrefix = "clicks/"
+)
+
+// idLength is the number of random bytes of link IDs.
+const idLength = 6
+
+// shortener serves the shortener web app:
+//
+//	POST   /links             creates a link (form values "url" and optional "ttl", for example "24h")
+//	GET    /links             lists the links (JSON)
+//	GET    /links/{id}        returns the link and its number of visits (JSON)
+//	DELETE /links/{id}        deletes the link
+//	GET    /{id}              redirects to the link
+//	       /admin/...         raw access to the database (see package tridbhttp), requires the admin token
+type shortener struct {
+	f       *tridb.File
+	baseURL string // prepended to the IDs of created links
+	mux     *http.ServeMux
+}
+
+// link is the JSON representation of a link.
+type link struct {
+	ID       string `json:"id"`
+	ShortURL string `json:"short_url"`
+	URL      string `json:"url"`
+	Clicks   int64  `json:"clicks"`
+}
+
+func newShortener(f *tridb.File, baseURL, adminToken string) *shortener {
+	s := &shortener{f: f, baseURL: strings.TrimSuffix(baseURL, "/"), mux: http.NewServeMux()}
+	s.mux.HandleFunc("/links", s.serveLinks)
+	s.mux.HandleFunc("/links/", s.serveLink)
+	s.mux.HandleFunc("/", s.serveRedirect)
+	if adminToken != "" {
+		s.mux.Handle("/admin/", http.StripPrefix("/admin", tridbhttp.NewHandler(f, tridbhttp.WithToken(adminToken))))
+	}
+	return s
+}
+
+func (s *shortener) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+
+func (s *shortener) serveLinks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.createLink(w, r)
+	case http.MethodGet:
+		links := []*link{}
+		err := s.f.Read(func(tr *tridb.Reader) error {
+			_, err := tr.Walk([]byte(linkPrefix), func(key []byte) error {
+				l, err := s.getLink(tr, string(key[len(linkPrefix):]))
+				if l != nil {
+					links = append(links, l)
+				}
+				return err
+			})
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, links)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *shortener) createLink(w http.ResponseWriter, r *http.Request) {
+	target, err := url.Parse(r.FormValue("url"))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if v := r.FormValue("ttl"); v != "" {
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Pick an unused ID (random IDs rarely collide, the transaction makes the check atomic)
+	var id string
+	err = s.f.ReadWrite(func(tr *tridb.Reader, tw *tridb.Writer) error {
+		for id == "" || tr.Has([]byte(linkPrefix+id)) {
+			rid, err := tridb.NewRandID(idLength)
+			if err != nil {
+				return fmt.Errorf("generate id: %w", err)
+			}
+			id = base64.RawURLEncoding.EncodeToString(rid)
+		}
+		if ttl > 0 {
+			tw.SetWithTTL([]byte(linkPrefix+id), []byte(target.String()), ttl)
+		} else {
+			tw.Set([]byte(linkPrefix+id), []byte(target.String()))
+		}
+		tw.Delete([]byte(clickPrefix + id)) // left over by an expired link
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var l *link
+	_ = s.f.Read(func(tr *tridb.Reader) (err error) {
+		l, err = s.getLink(tr, id)
+		return err
+	})
+	writeJSON(w, http.StatusCreated, l)
+}
+
+func (s *shortener) serveLink(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/links/")
+	switch r.Method {
+	case http.MethodGet:
+		var l *link
+		err := s.f.Read(func(tr *tridb.Reader) (err error) {
+			l, err = s.getLink(tr, id)
+			return err
+		})
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case l == nil:
+			http.NotFound(w, r)
+		default:
+			writeJSON(w, http.StatusOK, l)
+		}
+	case http.MethodDelete:
+		err := s.f.ReadWrite(func(tr *tridb.Reader, tw *tridb.Writer) error {
+			tw.Delete([]byte(linkPrefix + id))
+			tw.Delete([]byte(clickPrefix + id))
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *shortener) serveRedirect(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/")
+	if r.Method != http.MethodGet || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Count the visit in the same transaction (expired links are not found)
+	var target []byte
+	err := s.f.ReadWrite(func(tr *tridb.Reader, tw *tridb.Writer) (err error) {
+		target, err = tr.Get([]byte(linkPrefix + id))
+		if err != nil || target == nil {
+			return err
+		}
+		clicks, _, err := tr.GetInt64([]byte(clickPrefix + id))
+		if err != nil {
+			return err
+		}
+		tw.SetInt64([]byte(clickPrefix+id), clicks+1)
+		return nil
+	})
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case target == nil:
+		http.NotFound(w, r)
+	default:
+		http.Redirect(w, r, string(target), http.StatusFound)
+	}
+}
+
+// getLink returns the link with the given ID (nil if not found or expired).
+func (s *shortener) getLink(tr *tridb.Reader, id string) (*link, error) {
+	target, err := tr.Get([]byte(linkPrefix + id))
+	if err != nil || target == nil {
+		return nil, err
+	}
+	clicks, _, err := tr.GetInt64([]byte(clickPrefix + id))
+	if err != nil {
+		return nil, err
+	}
+	return &link{ID: id, ShortURL: s.baseURL + "/" + id, URL: string(target), Clicks: clicks}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// backup appends the rows written since the given offset to the backup file and returns the offset of the next backup.
+// The backup file is rewritten from scratch after a compaction (offsets are reset).
+func backup(f *tridb.File, fpath string, offset int) (int, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dst, err := os.OpenFile(fpath, flags, 0666)
+	if err != nil {
+		return offset, err
+	}
+	next, err := f.BackupSince(offset, dst)
+	if errors.Is(err, tridb.ErrBackupOffset) {
+		dst.Close()
+		return backup(f, fpath, 0)
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return next, err
+}
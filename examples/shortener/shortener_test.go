@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+func TestShortener(t *testing.T) {
+	dir := t.TempDir()
+	f, err := tridb.Open(filepath.Join(dir, "links.tridb"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	srv := httptest.NewServer(newShortener(f, "https://sho.rt", "secret"))
+	defer srv.Close()
+	client := srv.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+
+	do := func(method, path string, form url.Values, header ...string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+	create := func(target, ttl string) link {
+		t.Helper()
+		resp, body := do(http.MethodPost, "/links", url.Values{"url": {target}, "ttl": {ttl}})
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("got status %d (%s)", resp.StatusCode, body)
+		}
+		var l link
+		if err := json.Unmarshal([]byte(body), &l); err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	getLink := func(id string) (l link, status int) {
+		t.Helper()
+		resp, body := do(http.MethodGet, "/links/"+id, nil)
+		if resp.StatusCode == http.StatusOK {
+			if err := json.Unmarshal([]byte(body), &l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return l, resp.StatusCode
+	}
+
+	// Create and follow links
+	l := create("https://example.com/a", "")
+	if l.URL != "https://example.com/a" || l.ShortURL != "https://sho.rt/"+l.ID || len(l.ID) != 8 {
+		t.Fatalf("got link %+v", l)
+	}
+	for i := 0; i < 3; i++ {
+		resp, _ := do(http.MethodGet, "/"+l.ID, nil)
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != l.URL {
+			t.Fatalf("got status %d and location %q", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+	if got, _ := getLink(l.ID); got.Clicks != 3 {
+		t.Fatalf("got %d clicks instead of 3", got.Clicks)
+	}
+	if resp, _ := do(http.MethodPost, "/links", url.Values{"url": {"javascript:alert(1)"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %d for invalid url", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodGet, "/missing", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got status %d for missing link", resp.StatusCode)
+	}
+
+	// Links expire
+	expiring := create("https://example.com/b", "50ms")
+	if _, status := getLink(expiring.ID); status != http.StatusOK {
+		t.Fatalf("got status %d before expiration", status)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if resp, _ := do(http.MethodGet, "/"+expiring.ID, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got status %d after expiration", resp.StatusCode)
+	}
+
+	// List and delete links
+	deleted := create("https://example.com/c", "")
+	if resp, _ := do(http.MethodDelete, "/links/"+deleted.ID, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("got status %d when deleting", resp.StatusCode)
+	}
+	_, body := do(http.MethodGet, "/links", nil)
+	var links []link
+	if err := json.Unmarshal([]byte(body), &links); err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].ID != l.ID {
+		t.Fatalf("got links %+v", links)
+	}
+
+	// Admin API
+	if resp, _ := do(http.MethodGet, "/admin/count", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got status %d without token", resp.StatusCode)
+	}
+	resp, body := do(http.MethodGet, "/admin/keys/links/"+l.ID, nil, "Authorization", "Bearer secret")
+	if resp.StatusCode != http.StatusOK || body != l.URL {
+		t.Fatalf("got status %d and body %q", resp.StatusCode, body)
+	}
+
+	// Incremental backups survive compactions
+	backupPath := filepath.Join(dir, "links.backup.tridb")
+	offset, err := backup(f, backupPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := create("https://example.com/d", "")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	offset, err = backup(f, backupPath, offset+1) // past the end of the compacted file
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := tridb.OpenReadOnly(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	_ = restored.Read(func(r *tridb.Reader) error {
+		for _, want := range []link{l, later} {
+			if got, err := r.Get([]byte(linkPrefix + want.ID)); err != nil || string(got) != want.URL {
+				t.Fatalf("got %q (%v) from backup instead of %q", got, err, want.URL)
+			}
+		}
+		if r.Has([]byte(linkPrefix + deleted.ID)) {
+			t.Fatal("deleted link found in backup")
+		}
+		return nil
+	})
+	if fi := f.Stats(); offset != fi.FileSize {
+		t.Fatalf("got backup offset %d instead of %d", offset, fi.FileSize)
+	}
+}
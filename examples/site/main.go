@@ -0,0 +1,63 @@
+// Command site serves a static website stored in a tridb file (see package tridbfs).
+//
+// The files of a local directory can be imported first, their content type is guessed from their extension.
+//
+// Usage:
+//
+//	go run ./examples/site -addr :8080 -db site.tridb -import ./public
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+	"github.com/ejuju/tridb/pkg/tridbfs"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	dbPath := flag.String("db", "site.tridb", "path of the database file")
+	importDir := flag.String("import", "", "local directory to import before serving (empty imports nothing)")
+	flag.Parse()
+
+	f, err := tridb.Open(*dbPath, 1024)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	site := tridbfs.New(f, "site/")
+
+	if *importDir != "" {
+		n, err := importFiles(site, os.DirFS(*importDir))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("imported %d files from %q", n, *importDir)
+	}
+
+	log.Printf("serving %q on %s", f.Path(), *addr)
+	log.Fatal(http.ListenAndServe(*addr, site.FileServer()))
+}
+
+// importFiles copies the regular files of src to the site and returns the number of files copied.
+func importFiles(site *tridbfs.FS, src fs.FS) (int, error) {
+	n := 0
+	err := fs.WalkDir(src, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := fs.ReadFile(src, name)
+		if err != nil {
+			return err
+		}
+		n++
+		return site.WriteFile(name, data, mime.TypeByExtension(path.Ext(name)))
+	})
+	return n, err
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
-	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
@@ -11,7 +14,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ejuju/tridb/pkg/console"
 	"github.com/ejuju/tridb/pkg/tridb"
+	"github.com/ejuju/tridb/pkg/tridbhttp"
 )
 
 func main() {
@@ -19,9 +24,18 @@ func main() {
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
 	if len(os.Args) <= 1 {
-		fmt.Println("missing database file path")
+		fmt.Print(cliUsage)
+		os.Exit(exitUsage)
+	}
+	if os.Args[1] == "serve" {
+		if err := runServe(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatal(err)
+		}
 		return
 	}
+	if _, ok := subcommands[os.Args[1]]; ok {
+		os.Exit(runSubcommand(os.Args[1], os.Args[2:]))
+	}
 
 	start := time.Now()
 	f, err := tridb.Open(os.Args[1], 100_000_000)
@@ -34,18 +48,14 @@ func main() {
 	fmt.Printf("Loaded %q in %s\nType a command and press enter: ", f.Path(), time.Since(start))
 
 	go func() {
-		bufs := bufio.NewScanner(os.Stdin)
-		for bufs.Scan() {
-			handleCommand(f, bufs.Text())
-			fmt.Print("\n? ")
-		}
-		if err := bufs.Err(); err != nil {
+		err := console.New(f, console.WithCommands(cliCommands()...)).Run(os.Stdin, os.Stdout)
+		if err != nil {
 			panic(err)
 		}
 	}()
 
 	<-interrupt
-	err = f.Close()
+	err = f.CloseWithTimeout(10 * time.Second)
 	if err != nil {
 		log.Println(err)
 		return
@@ -53,249 +63,120 @@ func main() {
 	log.Println("goodbye!")
 }
 
-func handleCommand(f *tridb.File, line string) {
-	parts := strings.Split(line, " ")
-	keyword := parts[0]
-
-	// Find and exec command
-	for _, cmd := range commands {
-		isMatch := false
-		for _, kw := range cmd.keywords {
-			if kw == keyword {
-				isMatch = true
-			}
-		}
-		if isMatch {
-			var args []string
-			if len(cmd.args) > 0 {
-				if len(parts)-1 != len(cmd.args) {
-					fmt.Printf("%q needs %d argument(s): %s\n", keyword, len(cmd.args), strings.Join(cmd.args, ", "))
+// cliCommands returns the commands of the interactive console specific to the CLI (see console.DefaultCommands).
+func cliCommands() []*console.Command {
+	return []*console.Command{
+		{
+			Keywords: []string{"verify"},
+			Desc:     "verify every row of the file and cross-check the keydir (like fsck)",
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				start := time.Now()
+				report, err := f.Verify(nil)
+				if err != nil {
+					fmt.Fprintln(w, err)
 					return
 				}
-				args = parts[1:]
-			}
-			cmd.do(f, args...)
-			return
-		}
-	}
-
-	fmt.Printf("\nCommand not found: %q\n", keyword)
-	printAvailableCommands(commands)
-}
-
-func printAvailableCommands(commands []*command) {
-	fmt.Println("Available commands:")
-	for _, cmd := range commands {
-		fmt.Printf("> \033[033m%-15s\033[0m \033[2m%s\033[0m\n", cmd.keywords[0], cmd.desc)
-	}
-}
-
-type command struct {
-	desc     string
-	keywords []string
-	args     []string
-	do       func(f *tridb.File, args ...string)
-}
-
-var commands = []*command{
-	{
-		keywords: []string{"compact"},
-		desc:     "removes deleted key-value pairs and re-writes rows in lexicographical order",
-		do: func(f *tridb.File, args ...string) {
-			start := time.Now()
-			err := f.Compact()
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-			fmt.Printf("compacted in %s\n", time.Since(start))
+				printVerifyReport(w, report, time.Since(start))
+			},
 		},
-	},
-	{
-		keywords: []string{"set", "+"},
-		desc:     "set a key-value pair in the database",
-		args:     []string{"key", "value"},
-		do: func(f *tridb.File, args ...string) {
-			key, value := []byte(args[0]), []byte(args[1])
-			err := f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
-				w.Set(key, value)
-				return nil
-			})
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-			fmt.Printf("%q is now %q\n", key, value)
-		},
-	},
-	{
-		keywords: []string{"delete", "-"},
-		desc:     "delete a key-value pair from the database",
-		args:     []string{"key"},
-		do: func(f *tridb.File, args ...string) {
-			key := []byte(args[0])
-			err := f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
-				w.Delete(key)
-				return nil
-			})
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-			fmt.Printf("deleted %q\n", key)
+		{
+			Keywords: []string{"serve"},
+			Desc:     "serve the database over HTTP in the background (see package tridbhttp)",
+			Args:     []string{"address"},
+			Options:  []string{"token=<bearer token>"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				var opts []tridbhttp.Option
+				for _, arg := range args[1:] {
+					token, ok := strings.CutPrefix(arg, "token=")
+					if !ok {
+						fmt.Fprintf(w, "unknown option: %q\n", arg)
+						return
+					}
+					opts = append(opts, tridbhttp.WithToken(token))
+				}
+				go func() {
+					err := http.ListenAndServe(args[0], tridbhttp.NewHandler(f, opts...))
+					if err != nil {
+						fmt.Fprintln(w, err)
+					}
+				}()
+				fmt.Fprintf(w, "serving on %s\n", args[0])
+			},
 		},
-	},
-	{
-		keywords: []string{"get"},
-		desc:     "get the value associated with a given key",
-		args:     []string{"key"},
-		do: func(f *tridb.File, args ...string) {
-			key := []byte(args[0])
-			_ = f.Read(func(r *tridb.Reader) error {
-				value, err := r.Get(key)
+		{
+			Keywords: []string{"fill"},
+			Desc:     "fill the database with the given number of key-value pairs (in a single transaction)",
+			Args:     []string{"number"},
+			Options:  workloadOptions,
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				start := time.Now()
+				num, err := strconv.Atoi(args[0])
 				if err != nil {
-					fmt.Println(err)
-					return nil
-				}
-				if value == nil {
-					fmt.Printf("%q not found\n", key)
-					return nil
+					fmt.Fprintln(w, err)
+					return
 				}
-				fmt.Printf("%q = %q\n", key, value)
-				return nil
-			})
-		},
-	},
-	{
-		keywords: []string{"has", "?"},
-		desc:     "reports whether a key exists",
-		args:     []string{"key"},
-		do: func(f *tridb.File, args ...string) {
-			key := []byte(args[0])
-			_ = f.Read(func(r *tridb.Reader) error {
-				fmt.Println(r.Has(key))
-				return nil
-			})
-		},
-	},
-	{
-		keywords: []string{"count"},
-		desc:     "reports the number of unique keys",
-		do: func(f *tridb.File, args ...string) {
-			_ = f.Read(func(r *tridb.Reader) error {
-				fmt.Println(r.Count())
-				return nil
-			})
-		},
-	},
-	{
-		keywords: []string{"all"},
-		desc:     "show all unique keys",
-		do: func(f *tridb.File, args ...string) {
-			_ = f.Read(func(r *tridb.Reader) error {
-				for rr := r.Oldest(); rr != nil; rr = rr.Next() {
-					fmt.Printf("%q\n", rr.Key())
+				wl, err := parseWorkload(args[1:])
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
 				}
-				return nil
-			})
-		},
-	},
-	{
-		keywords: []string{"tail"},
-		desc:     "show the last 10 key-value pairs",
-		do: func(f *tridb.File, args ...string) {
-			_ = f.Read(func(r *tridb.Reader) error {
-				i := 0
-				for rr := r.Latest(); rr != nil; rr = rr.Previous() {
-					if i >= 10 {
-						break
-					}
-					i++
-					v, err := rr.Value()
-					if err != nil {
-						fmt.Println(err)
-						return nil
-					}
-					fmt.Printf("%q = %q\n", rr.Key(), v)
+				err = runFill(f, num, wl)
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
 				}
-				return nil
-			})
+				elapsed := time.Since(start)
+				fmt.Fprintf(w, "added %d rows in %s\n", num, elapsed)
+			},
 		},
-	},
-	{
-		keywords: []string{"head"},
-		desc:     "show the first 10 key-value pairs",
-		do: func(f *tridb.File, args ...string) {
-			_ = f.Read(func(r *tridb.Reader) error {
-				i := 0
-				for rr := r.Oldest(); rr != nil; rr = rr.Next() {
-					if i >= 10 {
-						break
-					}
-					i++
-					v, err := rr.Value()
-					if err != nil {
-						fmt.Println(err)
-						return nil
-					}
-					fmt.Printf("%q = %q\n", rr.Key(), v)
+		{
+			Keywords: []string{"bench"},
+			Desc:     "run quick benchmark (one transaction per row)",
+			Args:     []string{"number of rows"},
+			Options:  workloadOptions,
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				num, err := strconv.Atoi(args[0])
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
 				}
-				return nil
-			})
-		},
-	},
-	{
-		keywords: []string{"fill"},
-		desc:     "fill the database with the given number of key-value pairs",
-		args:     []string{"number"},
-		do: func(f *tridb.File, args ...string) {
-			start := time.Now()
-			num, err := strconv.Atoi(args[0])
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-			err = f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
-				for i := 0; i < num; i++ {
-					key := []byte(strconv.Itoa(i))
-					value := []byte(time.Now().Format(time.RFC3339))
-					w.Set(key, value)
+				wl, err := parseWorkload(args[1:])
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
 				}
-				return nil
-			})
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-			elapsed := time.Since(start)
-			fmt.Printf("added %d rows in %s\n", num, elapsed)
-		},
-	},
-	{
-		keywords: []string{"bench"},
-		desc:     "run quick benchmark",
-		args:     []string{"number of rows"},
-		do: func(f *tridb.File, args ...string) {
-			start := time.Now()
-			num, err := strconv.Atoi(args[0])
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-			for i := 0; i < num; i++ {
-				err = f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
-					key := tridb.MustNewRandID(8).Hex()
-					value := []byte(time.Now().Format(time.RFC3339))
-					w.Set(key, value)
-					return nil
-				})
+				err = runBench(f, num, wl)
 				if err != nil {
-					fmt.Println(err)
+					fmt.Fprintln(w, err)
 					return
 				}
-			}
-			elapsed := time.Since(start)
-			fmt.Printf("added %d rows in %s (%.f rows per second)\n", num, elapsed, float64(num)/elapsed.Seconds())
+			},
+		},
+		{
+			Keywords: []string{"console"},
+			Desc:     "serve this console over a Unix domain socket or TCP in the background (see package console)",
+			Args:     []string{"network", "address"},
+			Options:  []string{"password=<password>"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				opts := []console.Option{console.WithCommands(cliCommands()...)}
+				for _, arg := range args[2:] {
+					password, ok := strings.CutPrefix(arg, "password=")
+					if !ok {
+						fmt.Fprintf(w, "unknown option: %q\n", arg)
+						return
+					}
+					opts = append(opts, console.WithPassword(password))
+				}
+				go func() {
+					err := console.New(f, opts...).ListenAndServe(args[0], args[1])
+					if err != nil {
+						fmt.Fprintln(w, err)
+					}
+				}()
+				fmt.Fprintf(w, "console listening on %s %s\n", args[0], args[1])
+			},
 		},
-	},
+	}
 }
+
+var workloadOptions = []string{"keys=seq|random|prefix:%d", "values=fixed:N|random:MIN-MAX|zipf:MAX", "workers=N"}
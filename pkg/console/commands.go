@@ -0,0 +1,335 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// DefaultCommands returns the commands of consoles (see WithCommands to add more).
+func DefaultCommands() []*Command {
+	return []*Command{
+		{
+			Keywords: []string{"compact"},
+			Desc:     "removes deleted key-value pairs and re-writes rows in lexicographical order",
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				start := time.Now()
+				err := f.Compact()
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
+				}
+				fmt.Fprintf(w, "compacted in %s\n", time.Since(start))
+			},
+		},
+		{
+			Keywords: []string{"stats"},
+			Desc:     "show statistics about the file, by content type for keys set with one (and by key prefix)",
+			Options:  []string{"prefixes=<depth>", "top=<number of prefixes>"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				depth, top := 0, 0
+				for _, arg := range args {
+					name, v, _ := strings.Cut(arg, "=")
+					n, err := strconv.Atoi(v)
+					if err != nil || (name != "prefixes" && name != "top") {
+						fmt.Fprintf(w, "invalid option: %q\n", arg)
+						return
+					}
+					if name == "prefixes" {
+						depth = n
+					} else {
+						top = n
+					}
+				}
+				if top > 0 && depth == 0 {
+					depth = 1
+				}
+				stats := f.Stats()
+				fmt.Fprintf(w, "%d keys (%d expiring), %d rows, %d bytes (%s format)\n", stats.Keys, stats.ExpiringKeys, stats.Rows, stats.FileSize, f.Format().Name())
+				fmt.Fprintf(w, "%d live bytes (%d bytes per key on average), %d dead bytes\n", stats.LiveBytes, stats.AverageRowSize, stats.DeadBytes)
+				if estimate := f.EstimateCompaction(); estimate.Duration > 0 {
+					fmt.Fprintf(w, "compaction would reclaim %d bytes (%.1f%%) in about %s\n", estimate.Reclaimed, 100*f.GarbageRatio(), estimate.Duration)
+				} else {
+					fmt.Fprintf(w, "compaction would reclaim %d bytes (%.1f%%)\n", estimate.Reclaimed, 100*f.GarbageRatio())
+				}
+				contentTypes := make([]string, 0, len(stats.ContentTypes))
+				for contentType := range stats.ContentTypes {
+					contentTypes = append(contentTypes, contentType)
+				}
+				sort.Strings(contentTypes)
+				for _, contentType := range contentTypes {
+					s := stats.ContentTypes[contentType]
+					fmt.Fprintf(w, "  %-30s %d keys, %d bytes\n", contentType, s.Keys, s.Bytes)
+				}
+				if depth > 0 {
+					printPrefixStats(f, w, depth, top)
+				}
+			},
+		},
+		{
+			Keywords: []string{"estimate-compression"},
+			Desc:     "estimate the compression ratio of the values (to decide whether to enable compression)",
+			Options:  []string{"sample=<number of values>"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				sampleN := 1000
+				for _, arg := range args {
+					v, ok := strings.CutPrefix(arg, "sample=")
+					n, err := strconv.Atoi(v)
+					if !ok || err != nil {
+						fmt.Fprintf(w, "invalid option: %q\n", arg)
+						return
+					}
+					sampleN = n
+				}
+				ratio, err := f.EstimateCompression(tridb.CompressionDeflate, sampleN)
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
+				}
+				fmt.Fprintf(w, "deflate: compressed values would take %.1f%% of their size\n", 100*ratio)
+			},
+		},
+		{
+			Keywords: []string{"set", "+"},
+			Desc:     "set a key-value pair in the database",
+			Args:     []string{"key", "value"},
+			Options:  []string{"type=<content type>"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				key, value := []byte(args[0]), []byte(args[1])
+				contentType := ""
+				for _, arg := range args[2:] {
+					var ok bool
+					if contentType, ok = strings.CutPrefix(arg, "type="); !ok {
+						fmt.Fprintf(w, "unknown option: %q\n", arg)
+						return
+					}
+				}
+				err := f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+					w.SetWithContentType(key, value, contentType)
+					return nil
+				})
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
+				}
+				fmt.Fprintf(w, "%q is now %q\n", key, value)
+			},
+		},
+		{
+			Keywords: []string{"delete", "-"},
+			Desc:     "delete a key-value pair from the database",
+			Args:     []string{"key"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				key := []byte(args[0])
+				err := f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+					w.Delete(key)
+					return nil
+				})
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
+				}
+				fmt.Fprintf(w, "deleted %q\n", key)
+			},
+		},
+		{
+			Keywords: []string{"get"},
+			Desc:     "get the value associated with a given key",
+			Args:     []string{"key"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				key := []byte(args[0])
+				_ = f.Read(func(r *tridb.Reader) error {
+					value, err := r.Get(key)
+					if err != nil {
+						fmt.Fprintln(w, err)
+						return nil
+					}
+					if value == nil {
+						fmt.Fprintf(w, "%q not found\n", key)
+						return nil
+					}
+					fmt.Fprintf(w, "%q = %q\n", key, value)
+					return nil
+				})
+			},
+		},
+		{
+			Keywords: []string{"has", "?"},
+			Desc:     "reports whether a key exists",
+			Args:     []string{"key"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				key := []byte(args[0])
+				_ = f.Read(func(r *tridb.Reader) error {
+					fmt.Fprintln(w, r.Has(key))
+					return nil
+				})
+			},
+		},
+		{
+			Keywords: []string{"count"},
+			Desc:     "reports the number of unique keys",
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				_ = f.Read(func(r *tridb.Reader) error {
+					fmt.Fprintln(w, r.Count())
+					return nil
+				})
+			},
+		},
+		{
+			Keywords: []string{"all"},
+			Desc:     "show all unique keys",
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				_ = f.Read(func(r *tridb.Reader) error {
+					for rr := r.Oldest(); rr != nil; rr = rr.Next() {
+						fmt.Fprintf(w, "%q\n", rr.Key())
+					}
+					return nil
+				})
+			},
+		},
+		{
+			Keywords: []string{"tail"},
+			Desc:     "show the last 10 key-value pairs",
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				_ = f.Read(func(r *tridb.Reader) error {
+					i := 0
+					for rr := r.Latest(); rr != nil; rr = rr.Previous() {
+						if i >= 10 {
+							break
+						}
+						i++
+						v, err := rr.Value()
+						if err != nil {
+							fmt.Fprintln(w, err)
+							return nil
+						}
+						fmt.Fprintf(w, "%q = %q\n", rr.Key(), v)
+					}
+					return nil
+				})
+			},
+		},
+		{
+			Keywords: []string{"head"},
+			Desc:     "show the first 10 key-value pairs",
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				_ = f.Read(func(r *tridb.Reader) error {
+					i := 0
+					for rr := r.Oldest(); rr != nil; rr = rr.Next() {
+						if i >= 10 {
+							break
+						}
+						i++
+						v, err := rr.Value()
+						if err != nil {
+							fmt.Fprintln(w, err)
+							return nil
+						}
+						fmt.Fprintf(w, "%q = %q\n", rr.Key(), v)
+					}
+					return nil
+				})
+			},
+		},
+		{
+			Keywords: []string{"query"},
+			Desc:     "show the JSON values matching conditions (query prefix=user: where .age>30 select .name,.email)",
+			Options:  []string{"prefix=<prefix>", "limit=<number>", "where <.path><op><value> [and ...]", "select <.path>,..."},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				q, err := parseJSONQuery(args)
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
+				}
+				stats, err := q.run(f, func(key []byte, projection any) error {
+					encoded, err := json.Marshal(projection)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(w, "%q = %s\n", key, encoded)
+					return nil
+				})
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
+				}
+				fmt.Fprintf(w, "%d match(es) among %d key(s)", stats.Matches, stats.Visited)
+				if stats.NotJSON > 0 {
+					fmt.Fprintf(w, ", %d value(s) aren't JSON", stats.NotJSON)
+				}
+				fmt.Fprintln(w)
+			},
+		},
+		{
+			Keywords: []string{"dump"},
+			Desc:     "write all key-value pairs to a text file (or to the console with \"-\")",
+			Args:     []string{"path"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				out := w
+				if args[0] != "-" {
+					file, err := os.Create(args[0])
+					if err != nil {
+						fmt.Fprintln(w, err)
+						return
+					}
+					defer file.Close()
+					out = file
+				}
+				err := f.Dump(out)
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
+				}
+			},
+		},
+		{
+			Keywords: []string{"load"},
+			Desc:     "load key-value pairs from a dump file",
+			Args:     []string{"path"},
+			Do: func(f *tridb.File, w io.Writer, args ...string) {
+				file, err := os.Open(args[0])
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
+				}
+				defer file.Close()
+				n, err := f.Load(file)
+				if err != nil {
+					fmt.Fprintln(w, err)
+					return
+				}
+				fmt.Fprintf(w, "loaded %d key-value pairs\n", n)
+			},
+		},
+	}
+}
+
+// printPrefixStats prints the prefix tree of the given depth, or its top prefixes if top > 0.
+func printPrefixStats(f *tridb.File, w io.Writer, depth, top int) {
+	var tree []tridb.PrefixStats
+	err := f.Read(func(r *tridb.Reader) (err error) {
+		tree, err = r.PrefixStats(depth)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return
+	}
+	if top > 0 {
+		for _, s := range tridb.TopPrefixes(tree, top) {
+			fmt.Fprintf(w, "  %-30s %d keys, %d bytes\n", s.Prefix, s.Keys, s.Bytes)
+		}
+		return
+	}
+	for _, s := range tree[1:] {
+		indent := strings.Repeat("  ", tridb.PrefixDepth(s.Prefix)-1)
+		fmt.Fprintf(w, "  %-30s %d keys, %d bytes\n", indent+s.Prefix, s.Keys, s.Bytes)
+	}
+}
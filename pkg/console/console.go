@@ -0,0 +1,234 @@
+// Package console provides the interactive command loop of the tridb CLI (get, set, stats, compact, ...),
+// so that applications embedding tridb get the same console against their live database,
+// on a terminal or attached over a Unix domain socket or TCP:
+//
+//	c := console.New(f)
+//	go c.ListenAndServe("unix", "/run/app/tridb.sock") // then: nc -U /run/app/tridb.sock
+//
+// Commands are read one per line, their arguments are separated by spaces.
+package console
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// ErrServerClosed is returned by Console.Serve after the console is closed.
+var ErrServerClosed = errors.New("console: server closed")
+
+// Command is a command of the console.
+type Command struct {
+	Keywords []string // names of the command (the first one is listed in the help)
+	Desc     string
+	Args     []string // names of the required arguments
+	Options  []string // optional "name=value" arguments following the required ones
+	Do       func(f *tridb.File, w io.Writer, args ...string)
+}
+
+// Console executes commands against a database file.
+type Console struct {
+	f        *tridb.File
+	commands []*Command
+	password string
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// Option configures a console.
+type Option func(*Console)
+
+// WithCommands adds the given commands to the default ones (see DefaultCommands).
+func WithCommands(commands ...*Command) Option {
+	return func(c *Console) { c.commands = append(c.commands, commands...) }
+}
+
+// WithPassword requires remote sessions to send the given password as their first line (see Serve).
+func WithPassword(password string) Option { return func(c *Console) { c.password = password } }
+
+// New returns a console for the given file.
+func New(f *tridb.File, opts ...Option) *Console {
+	c := &Console{
+		f:         f,
+		commands:  DefaultCommands(),
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Exec executes the given command line and writes its output to w.
+func (c *Console) Exec(w io.Writer, line string) {
+	parts := strings.Split(line, " ")
+	keyword := parts[0]
+
+	// Find and exec command
+	for _, cmd := range c.commands {
+		isMatch := false
+		for _, kw := range cmd.Keywords {
+			if kw == keyword {
+				isMatch = true
+			}
+		}
+		if isMatch {
+			var args []string
+			if len(cmd.Args) > 0 || len(cmd.Options) > 0 {
+				numArgs := len(parts) - 1
+				if numArgs < len(cmd.Args) || (numArgs > len(cmd.Args) && len(cmd.Options) == 0) {
+					fmt.Fprintf(w, "%q needs %d argument(s): %s\n", keyword, len(cmd.Args), strings.Join(cmd.Args, ", "))
+					return
+				}
+				args = parts[1:]
+			}
+			cmd.Do(c.f, w, args...)
+			return
+		}
+	}
+
+	fmt.Fprintf(w, "\nCommand not found: %q\n", keyword)
+	c.printAvailableCommands(w)
+}
+
+func (c *Console) printAvailableCommands(w io.Writer) {
+	fmt.Fprintln(w, "Available commands:")
+	for _, cmd := range c.commands {
+		fmt.Fprintf(w, "> \033[033m%-15s\033[0m \033[2m%s\033[0m\n", cmd.Keywords[0], cmd.Desc)
+		if len(cmd.Options) > 0 {
+			fmt.Fprintf(w, "  %-15s \033[2moptions: %s\033[0m\n", "", strings.Join(cmd.Options, " "))
+		}
+	}
+}
+
+// Run executes the command lines read from r until its end, writing their output and a prompt to w.
+func (c *Console) Run(r io.Reader, w io.Writer) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		c.Exec(w, strings.TrimSuffix(s.Text(), "\r"))
+		fmt.Fprint(w, "\n? ")
+	}
+	return s.Err()
+}
+
+// ListenAndServe listens on the given network ("unix" or "tcp") and address and serves sessions (see Serve).
+// Unix domain sockets are only accessible by the owner of the process.
+//
+// Note: sessions can read and write the whole database, TCP consoles should only listen on trusted interfaces
+// or require a password (see WithPassword).
+func (c *Console) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0600); err != nil {
+			l.Close()
+			return fmt.Errorf("restrict socket permissions: %w", err)
+		}
+	}
+	return c.Serve(l)
+}
+
+// Serve accepts connections on the given listener until the console is closed (ErrServerClosed is then returned).
+// Each connection is a session running in its own goroutine (see Run).
+func (c *Console) Serve(l net.Listener) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	c.listeners[l] = struct{}{}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.listeners, l)
+		c.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			c.mu.Lock()
+			closed := c.closed
+			c.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		c.conns[conn] = struct{}{}
+		c.wg.Add(1)
+		c.mu.Unlock()
+		go c.serveConn(conn)
+	}
+}
+
+// Close stops the listeners, closes the sessions and waits for their in-flight commands.
+// It doesn't close the database file.
+func (c *Console) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	var err error
+	for l := range c.listeners {
+		err = errors.Join(err, l.Close())
+	}
+	for conn := range c.conns {
+		conn.Close()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+	return err
+}
+
+func (c *Console) serveConn(conn net.Conn) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.conns, conn)
+		c.mu.Unlock()
+		conn.Close()
+		c.wg.Done()
+	}()
+	r := bufio.NewReader(conn)
+	if c.password != "" {
+		fmt.Fprint(conn, "Password: ")
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if subtle.ConstantTimeCompare([]byte(password), []byte(c.password)) != 1 {
+			fmt.Fprintln(conn, "invalid password")
+			return
+		}
+	}
+	fmt.Fprintf(conn, "Connected to %q\nType a command and press enter: ", c.f.Path())
+	c.Run(r, conn)
+}
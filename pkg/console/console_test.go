@@ -0,0 +1,75 @@
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+func TestConsole(t *testing.T) {
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hello := &Command{
+		Keywords: []string{"hello"},
+		Do:       func(f *tridb.File, w io.Writer, args ...string) { io.WriteString(w, "hello!\n") },
+	}
+	c := New(f, WithCommands(hello))
+
+	out := &bytes.Buffer{}
+	if err := c.Run(strings.NewReader("set a 1\r\nget a\nhello\ncount\nunknown\n"), out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"a" is now "1"`, `"a" = "1"`, "hello!", "? 1\n", `Command not found: "unknown"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in output:\n%s", want, out)
+		}
+	}
+
+	// Attach over a Unix domain socket
+	c = New(f, WithPassword("secret"))
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "console.sock"))
+	if err != nil {
+		t.Skip(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- c.Serve(l) }()
+	session := func(lines ...string) string {
+		t.Helper()
+		conn, err := net.Dial("unix", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for _, line := range lines {
+			io.WriteString(conn, line+"\n")
+		}
+		conn.(*net.UnixConn).CloseWrite()
+		b, err := io.ReadAll(bufio.NewReader(conn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := session("wrong", "get a"); !strings.Contains(got, "invalid password") || strings.Contains(got, `"a" = "1"`) {
+		t.Fatalf("got output %q with a wrong password", got)
+	}
+	if got := session("secret", "set b 2", "get a"); !strings.Contains(got, `"b" is now "2"`) || !strings.Contains(got, `"a" = "1"`) {
+		t.Fatalf("got output %q", got)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("got error %v instead of %v", err, ErrServerClosed)
+	}
+}
@@ -0,0 +1,249 @@
+package console
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// jsonQuery filters and projects the values of a prefix as JSON documents, it is parsed from the arguments of the query command:
+//
+//	query prefix=user: where .age>30 and .active=true select .name,.email
+//
+// Paths start with a dot and name object fields (or array indexes) separated by dots, "." is the whole value.
+// Conditions compare a path with a JSON literal (or a bare string) using =, !=, >, >=, < or <=,
+// numbers are compared as numbers and strings lexicographically.
+type jsonQuery struct {
+	prefix []byte
+	where  []jsonCondition // all must match
+	fields []string        // selected paths (the whole value if empty)
+	limit  int             // maximum number of matches (0 means no limit)
+}
+
+type jsonCondition struct {
+	path  string
+	op    string
+	value any
+}
+
+// jsonOperators are sorted so that two-character operators are matched first.
+var jsonOperators = []string{"!=", ">=", "<=", "=", ">", "<"}
+
+func parseJSONQuery(args []string) (*jsonQuery, error) {
+	q := &jsonQuery{}
+	clause := ""
+	for _, arg := range args {
+		switch {
+		case arg == "":
+			continue
+		case arg == "where" || arg == "select":
+			clause = arg
+		case strings.HasPrefix(arg, "prefix="):
+			q.prefix = []byte(strings.TrimPrefix(arg, "prefix="))
+		case strings.HasPrefix(arg, "limit="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "limit="))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid limit: %q", arg)
+			}
+			q.limit = n
+		case clause == "where" && arg == "and":
+			continue
+		case clause == "where":
+			cond, err := parseJSONCondition(arg)
+			if err != nil {
+				return nil, err
+			}
+			q.where = append(q.where, cond)
+		case clause == "select":
+			for _, path := range strings.Split(arg, ",") {
+				if !strings.HasPrefix(path, ".") {
+					return nil, fmt.Errorf("invalid path: %q (paths start with a dot)", path)
+				}
+				q.fields = append(q.fields, path)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected argument: %q", arg)
+		}
+	}
+	return q, nil
+}
+
+func parseJSONCondition(arg string) (jsonCondition, error) {
+	i, op := -1, ""
+	for _, candidate := range jsonOperators {
+		if j := strings.Index(arg, candidate); j > 0 && (i == -1 || j < i || (j == i && len(candidate) > len(op))) {
+			i, op = j, candidate
+		}
+	}
+	if i == -1 || !strings.HasPrefix(arg, ".") {
+		return jsonCondition{}, fmt.Errorf("invalid condition: %q (expected .path<operator>value)", arg)
+	}
+	cond := jsonCondition{path: arg[:i], op: op}
+	literal := arg[i+len(op):]
+	if err := decodeJSON([]byte(literal), &cond.value); err != nil {
+		cond.value = literal // bare string
+	}
+	return cond, nil
+}
+
+// decodeJSON decodes the given JSON document, keeping numbers as json.Number.
+func decodeJSON(data []byte, v *any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// lookupJSON returns the value at the given path of the given document (false if there's none).
+func lookupJSON(doc any, path string) (any, bool) {
+	if path == "." {
+		return doc, true
+	}
+	for _, name := range strings.Split(path[1:], ".") {
+		switch v := doc.(type) {
+		case map[string]any:
+			field, ok := v[name]
+			if !ok {
+				return nil, false
+			}
+			doc = field
+		case []any:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// match reports whether the given document satisfies the condition.
+func (cond jsonCondition) match(doc any) bool {
+	v, ok := lookupJSON(doc, cond.path)
+	if !ok {
+		return false
+	}
+	cmp, ok := compareJSON(v, cond.value)
+	switch cond.op {
+	case "=":
+		return ok && cmp == 0
+	case "!=":
+		return !ok || cmp != 0
+	case ">":
+		return ok && cmp > 0
+	case ">=":
+		return ok && cmp >= 0
+	case "<":
+		return ok && cmp < 0
+	default: // "<="
+		return ok && cmp <= 0
+	}
+}
+
+// compareJSON compares two JSON values, it reports false if they aren't comparable.
+// Values other than numbers and strings are only equal or not.
+func compareJSON(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		x, errX := a.Float64()
+		y, errY := b.Float64()
+		if errX != nil || errY != nil {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0, true
+	}
+	return 0, false
+}
+
+// project returns the selected fields of the given document (or the document itself if none is selected).
+func (q *jsonQuery) project(doc any) any {
+	if len(q.fields) == 0 {
+		return doc
+	}
+	projection := make(map[string]any, len(q.fields))
+	for _, path := range q.fields {
+		v, ok := lookupJSON(doc, path)
+		if !ok {
+			continue
+		}
+		if name := strings.TrimPrefix(path, "."); name != "" {
+			projection[name] = v
+		} else {
+			projection[path] = v
+		}
+	}
+	return projection
+}
+
+// queryStats reports the outcome of a query.
+type queryStats struct {
+	Visited, Matches, NotJSON int
+}
+
+// run calls do with the key and the projected value of each matching key-value pair.
+// Values that aren't JSON documents are skipped.
+func (q *jsonQuery) run(f *tridb.File, do func(key []byte, projection any) error) (queryStats, error) {
+	stats := queryStats{}
+	errLimit := errors.New("limit reached")
+	err := f.Read(func(r *tridb.Reader) error {
+		var err error
+		stats.Visited, err = r.WalkWithValue(q.prefix, func(key, value []byte) error {
+			var doc any
+			if err := decodeJSON(value, &doc); err != nil {
+				stats.NotJSON++
+				return nil
+			}
+			for _, cond := range q.where {
+				if !cond.match(doc) {
+					return nil
+				}
+			}
+			stats.Matches++
+			if err := do(key, q.project(doc)); err != nil {
+				return err
+			}
+			if q.limit > 0 && stats.Matches == q.limit {
+				return errLimit
+			}
+			return nil
+		})
+		return err
+	})
+	if errors.Is(err, errLimit) {
+		err = nil
+	}
+	return stats, err
+}
@@ -0,0 +1,158 @@
+package fidx
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Promotion thresholds of an AdaptiveIndex.
+const (
+	AdaptivePromoteAfter = 2  // Number of walks of a prefix before its keys are kept in a trie.
+	AdaptiveMaxPromoted  = 64 // Maximum number of prefixes kept in tries (the least recently walked one is demoted).
+	adaptiveMaxTracked   = 1024
+)
+
+// AdaptiveIndex is a hash table (see LHTIndex) that lazily builds tries for the prefixes that are walked:
+// point lookups and writes of keys outside of walked prefixes cost a hash table operation (and its memory),
+// walks within a promoted prefix don't collect and sort keys.
+//
+// A walk promotes the common prefix of its start and end (the whole keyspace for unbounded walks)
+// once it was walked AdaptivePromoteAfter times.
+//
+// Like other keydirs, writes must not be concurrent with other operations, but walks may be concurrent.
+type AdaptiveIndex struct {
+	*LHTIndex
+	mu       sync.Mutex        // guards the promotion state (walks may be concurrent)
+	walks    map[string]int    // number of walks by prefix not promoted yet
+	promoted []*promotedPrefix // disjoint prefixes (no prefix starts with another one)
+	clock    int               // number of walks (see promotedPrefix.lastWalk)
+}
+
+// promotedPrefix holds the keys of a walked prefix in lexicographical order.
+type promotedPrefix struct {
+	prefix   []byte
+	root     trieNode // shares the rows of the hash table
+	lastWalk int
+}
+
+// NewAdaptiveIndex returns an adaptive index over the given (empty) hash table.
+func NewAdaptiveIndex(idx *LHTIndex) *AdaptiveIndex {
+	return &AdaptiveIndex{LHTIndex: idx, walks: map[string]int{}}
+}
+
+func (idx *AdaptiveIndex) Put(key []byte, p Position) *RowInfo {
+	count := idx.Count
+	row := idx.LHTIndex.Put(key, p)
+	if idx.Count == count {
+		return row // existing key, the tries share its row
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if pp := idx.promotedFor(key); pp != nil {
+		pp.root.insert(row)
+	}
+	return row
+}
+
+func (idx *AdaptiveIndex) Delete(key []byte) *RowInfo {
+	row := idx.LHTIndex.Delete(key)
+	if row == nil {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if pp := idx.promotedFor(key); pp != nil {
+		pp.root.remove(key)
+	}
+	return row
+}
+
+// WalkRange walks the trie of the promoted prefix holding the range if any,
+// otherwise it collects and sorts the keys in the range (see LHTIndex.WalkRange).
+func (idx *AdaptiveIndex) WalkRange(start, end []byte, reverse bool, do func(row *RowInfo) error) error {
+	idx.mu.Lock()
+	idx.clock++
+	prefix := start[:commonPrefixLength(start, end)]
+	pp := idx.promotedFor(prefix)
+	if pp == nil {
+		idx.walks[string(prefix)]++
+		if idx.walks[string(prefix)] < AdaptivePromoteAfter {
+			idx.mu.Unlock()
+			return idx.LHTIndex.WalkRange(start, end, reverse, do)
+		}
+		pp = idx.promote(prefix)
+	}
+	pp.lastWalk = idx.clock
+	idx.mu.Unlock()
+	return pp.root.walkRange(make([]byte, 0, 256), start, end, reverse, do)
+}
+
+// CountPrefix returns the number of keys starting with the given prefix,
+// in O(len(prefix)) within a promoted prefix, otherwise by scanning all keys.
+func (idx *AdaptiveIndex) CountPrefix(prefix []byte) int {
+	idx.mu.Lock()
+	pp := idx.promotedFor(prefix)
+	idx.mu.Unlock()
+	if pp != nil {
+		return pp.root.countPrefix(prefix)
+	}
+	count := 0
+	for row := idx.Oldest; row != nil; row = row.Next {
+		if bytes.HasPrefix(row.Key, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// Promoted returns the prefixes whose keys are kept in tries.
+func (idx *AdaptiveIndex) Promoted() [][]byte {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	prefixes := make([][]byte, len(idx.promoted))
+	for i, pp := range idx.promoted {
+		prefixes[i] = pp.prefix
+	}
+	return prefixes
+}
+
+// promotedFor returns the promoted prefix of the given key (or nil).
+func (idx *AdaptiveIndex) promotedFor(key []byte) *promotedPrefix {
+	for _, pp := range idx.promoted {
+		if bytes.HasPrefix(key, pp.prefix) {
+			return pp
+		}
+	}
+	return nil
+}
+
+// promote builds the trie of the given prefix, replacing the promoted prefixes it holds.
+func (idx *AdaptiveIndex) promote(prefix []byte) *promotedPrefix {
+	pp := &promotedPrefix{prefix: bytes.Clone(prefix)}
+	for row := idx.Oldest; row != nil; row = row.Next {
+		if bytes.HasPrefix(row.Key, prefix) {
+			pp.root.insert(row)
+		}
+	}
+	promoted := idx.promoted[:0]
+	for _, other := range idx.promoted {
+		if !bytes.HasPrefix(other.prefix, prefix) {
+			promoted = append(promoted, other)
+		}
+	}
+	if len(promoted) == AdaptiveMaxPromoted {
+		lru := 0
+		for i, other := range promoted {
+			if other.lastWalk < promoted[lru].lastWalk {
+				lru = i
+			}
+		}
+		promoted = append(promoted[:lru], promoted[lru+1:]...)
+	}
+	idx.promoted = append(promoted, pp)
+	delete(idx.walks, string(prefix))
+	if len(idx.walks) > adaptiveMaxTracked {
+		clear(idx.walks) // forget prefixes walked once in a while
+	}
+	return pp
+}
@@ -0,0 +1,45 @@
+package fidx
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAdaptiveIndex(t *testing.T) {
+	idx := NewAdaptiveIndex(NewLHTIndex(7))
+	for _, key := range []string{"users/2", "users/1", "orders/1", "users/3", "user"} {
+		idx.Put([]byte(key), Position{})
+	}
+	walkPrefix := func(prefix string) [][]byte {
+		t.Helper()
+		return walkKeys(t, idx, []byte(prefix), PrefixEnd([]byte(prefix)), false)
+	}
+	want := [][]byte{[]byte("users/1"), []byte("users/2"), []byte("users/3")}
+
+	// Prefixes are promoted once walked enough times, writes then update their trie.
+	for i := 0; i < AdaptivePromoteAfter; i++ {
+		if len(idx.Promoted()) != 0 {
+			t.Fatalf("got promoted prefixes %q after %d walks", idx.Promoted(), i)
+		}
+		assertOrder(t, walkPrefix("users/"), want)
+	}
+	if got := idx.Promoted(); len(got) != 1 || !bytes.Equal(got[0], []byte("users")) {
+		t.Fatalf("got promoted prefixes %q", got)
+	}
+	idx.Put([]byte("users/0"), Position{})
+	idx.Delete([]byte("users/2"))
+	idx.Put([]byte("orders/2"), Position{})
+	want = [][]byte{[]byte("users/0"), []byte("users/1"), []byte("users/3")}
+	assertOrder(t, walkPrefix("users/"), want)
+	if got := idx.CountPrefix([]byte("users/")); got != 3 {
+		t.Fatalf("got count %d instead of 3", got)
+	}
+
+	// Walking a parent prefix replaces the promoted prefixes it holds.
+	for i := 0; i < AdaptivePromoteAfter; i++ {
+		assertOrder(t, walkPrefix(""), [][]byte{[]byte("orders/1"), []byte("orders/2"), []byte("user"), want[0], want[1], want[2]})
+	}
+	if got := idx.Promoted(); len(got) != 1 || len(got[0]) != 0 {
+		t.Fatalf("got promoted prefixes %q", got)
+	}
+}
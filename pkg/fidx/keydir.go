@@ -0,0 +1,107 @@
+package fidx
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Keydir maps keys to the position of their latest row in a file.
+// Keys are also linked in chronological order (by creation time).
+type Keydir interface {
+	Put(key []byte, p Position) *RowInfo // returns the (new or updated) row info
+	Delete(key []byte) *RowInfo          // returns the deleted row info (or nil)
+	Get(key []byte) *RowInfo
+	Chronological() *List
+
+	// WalkRange calls do for each key in [start, end) in lexicographical order (or reverse order).
+	// A nil start or end means the range is unbounded on that side.
+	// Walking stops when do returns an error, the error is then returned.
+	WalkRange(start, end []byte, reverse bool, do func(row *RowInfo) error) error
+}
+
+// PrefixCounter is implemented by keydirs able to count keys by prefix without walking them.
+type PrefixCounter interface {
+	CountPrefix(prefix []byte) int
+}
+
+// KeySelector is implemented by keydirs able to find keys by rank (in lexicographical order) without walking them.
+type KeySelector interface {
+	Nth(i int) *RowInfo // returns the row of the key of rank i (starting at 0), or nil if out of range
+}
+
+// List is a doubly linked list of rows ordered by key creation time.
+type List struct {
+	Count          int
+	Oldest, Latest *RowInfo
+}
+
+// Chronological returns the list itself, it allows types embedding a list to implement Keydir.
+func (l *List) Chronological() *List { return l }
+
+func (l *List) append(row *RowInfo) {
+	l.Count++
+	row.Previous = l.Latest
+	if l.Oldest == nil || l.Latest == nil {
+		l.Oldest = row
+	} else {
+		l.Latest.Next = row
+	}
+	l.Latest = row
+}
+
+func (l *List) unlink(row *RowInfo) {
+	l.Count--
+	if row.Previous == nil {
+		l.Oldest = row.Next
+	} else {
+		row.Previous.Next = row.Next
+	}
+	if row.Next == nil {
+		l.Latest = row.Previous
+	} else {
+		row.Next.Previous = row.Previous
+	}
+}
+
+// PrefixEnd returns the smallest key greater than all keys starting with the given prefix,
+// or nil if there is none (empty prefix or prefix only made of 0xFF bytes).
+// It can be used as the end of a range walk.
+func PrefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func inRange(key, start, end []byte) bool {
+	return (start == nil || bytes.Compare(key, start) >= 0) && (end == nil || bytes.Compare(key, end) < 0)
+}
+
+// walkSorted calls do for the given rows sorted lexicographically by key.
+func walkSorted(rows []*RowInfo, reverse bool, do func(row *RowInfo) error) error {
+	sort.Slice(rows, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(rows[i].Key, rows[j].Key) > 0
+		}
+		return bytes.Compare(rows[i].Key, rows[j].Key) < 0
+	})
+	for _, row := range rows {
+		if err := do(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy inserts all keys of src into dst (in chronological order).
+func Copy(dst, src Keydir) {
+	for row := src.Chronological().Oldest; row != nil; row = row.Next {
+		copied := dst.Put(row.Key, row.Position)
+		copied.Timestamp, copied.ValueHash, copied.ExpiresAt = row.Timestamp, row.ValueHash, row.ExpiresAt
+		copied.ContentType = row.ContentType
+	}
+}
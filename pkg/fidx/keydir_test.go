@@ -0,0 +1,145 @@
+package fidx
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestKeydirs(t *testing.T) {
+	keydirs := map[string]func() Keydir{
+		"lht":  func() Keydir { return NewLHTIndex(7) },
+		"trie": func() Keydir { return NewTrieIndex() },
+		"adaptive": func() Keydir {
+			// Walk prefixes until they are promoted before writing keys.
+			idx := NewAdaptiveIndex(NewLHTIndex(7))
+			for i := 0; i < AdaptivePromoteAfter; i++ {
+				_ = idx.WalkRange([]byte("b"), PrefixEnd([]byte("b")), false, func(*RowInfo) error { return nil })
+				_ = idx.WalkRange([]byte("a\x00"), PrefixEnd([]byte("a\x00")), false, func(*RowInfo) error { return nil })
+			}
+			return idx
+		},
+	}
+	for name, newKeydir := range keydirs {
+		t.Run(name, func(t *testing.T) {
+			idx := newKeydir()
+			want := map[string]int{}
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < 5000; i++ {
+				key := make([]byte, 1+rng.Intn(4))
+				for j := range key {
+					key[j] = "abc\x00\xff"[rng.Intn(5)]
+				}
+				if rng.Intn(3) == 0 {
+					idx.Delete(key)
+					delete(want, string(key))
+				} else {
+					idx.Put(key, Position{i, 1})
+					want[string(key)] = i
+				}
+			}
+
+			if got := idx.Chronological().Count; got != len(want) {
+				t.Fatalf("got count %d instead of %d", got, len(want))
+			}
+			for key, offset := range want {
+				row := idx.Get([]byte(key))
+				if row == nil || row.Position.Offset() != offset {
+					t.Fatalf("got row %v for key %q instead of offset %d", row, key, offset)
+				}
+			}
+
+			var wantKeys [][]byte
+			for key := range want {
+				wantKeys = append(wantKeys, []byte(key))
+			}
+			sort.Slice(wantKeys, func(i, j int) bool { return bytes.Compare(wantKeys[i], wantKeys[j]) < 0 })
+			assertOrder(t, walkKeys(t, idx, nil, nil, false), wantKeys)
+
+			// Prefix walk
+			var wantPrefixed [][]byte
+			for _, key := range wantKeys {
+				if bytes.HasPrefix(key, []byte("b")) {
+					wantPrefixed = append([][]byte{key}, wantPrefixed...)
+				}
+			}
+			assertOrder(t, walkKeys(t, idx, []byte("b"), PrefixEnd([]byte("b")), true), wantPrefixed)
+
+			// Prefix count
+			if counter, ok := idx.(PrefixCounter); ok {
+				for _, prefix := range []string{"", "a", "b\x00", "ca\xff", "abcab"} {
+					want := 0
+					for _, key := range wantKeys {
+						if bytes.HasPrefix(key, []byte(prefix)) {
+							want++
+						}
+					}
+					if got := counter.CountPrefix([]byte(prefix)); got != want {
+						t.Fatalf("got count %d instead of %d for prefix %q", got, want, prefix)
+					}
+				}
+			}
+		})
+	}
+}
+
+func walkKeys(t *testing.T, idx Keydir, start, end []byte, reverse bool) [][]byte {
+	t.Helper()
+	var keys [][]byte
+	err := idx.WalkRange(start, end, reverse, func(row *RowInfo) error {
+		keys = append(keys, row.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct{ prefix, want []byte }{
+		{[]byte("abc"), []byte("abd")},
+		{[]byte{'a', 0xFF}, []byte("b")},
+		{[]byte{0xFF}, nil},
+		{nil, nil},
+	}
+	for _, test := range tests {
+		if got := PrefixEnd(test.prefix); !bytes.Equal(got, test.want) {
+			t.Fatalf("got prefix end %q instead of %q for %q", got, test.want, test.prefix)
+		}
+	}
+}
+
+func TestTrieBinaryKeys(t *testing.T) {
+	idx := NewTrieIndex()
+	var want [][]byte
+	for c := 0; c < 256; c++ {
+		want = append(want, []byte{byte(c)}, []byte{byte(c), 0}, []byte{byte(c), 0xFF})
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		idx.Put(want[i], Position{i, 1})
+	}
+	assertOrder(t, walkKeys(t, idx, nil, nil, false), want)
+	if got := idx.CountPrefix([]byte{0xFF}); got != 3 {
+		t.Fatalf("got count %d instead of 3 for prefix 0xFF", got)
+	}
+}
+
+func TestTrieNth(t *testing.T) {
+	idx := NewTrieIndex()
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "ba", "c"}
+	for _, i := range rand.Perm(len(keys)) {
+		idx.Put([]byte(keys[i]), Position{i, 1})
+	}
+	idx.Delete([]byte("ab"))
+	keys = append(keys[:2], keys[3:]...)
+	for i, key := range keys {
+		if row := idx.Nth(i); row == nil || string(row.Key) != key {
+			t.Fatalf("got %v instead of %q at rank %d", row, key, i)
+		}
+	}
+	if row := idx.Nth(len(keys)); row != nil {
+		t.Fatalf("got %q out of range", row.Key)
+	}
+}
@@ -1,10 +1,17 @@
 package fidx
 
-import "bytes"
+import (
+	"bytes"
+	"hash/maphash"
+)
 
 type RowInfo struct {
 	Key            []byte   // user-defined key
 	Position       Position // position in file
+	Timestamp      int64    // write time in Unix nanoseconds (0 if unknown)
+	ValueHash      uint64   // hash of the value (0 if unknown)
+	ExpiresAt      int64    // expiration time in Unix nanoseconds (0 if the key doesn't expire)
+	ContentType    string   // media type of the value (empty if unknown)
 	Next, Previous *RowInfo // neighbouring rows in chronological order
 	nextInBucket   *RowInfo // internal state for hashtable
 }
@@ -16,28 +23,62 @@ func (p Position) Size() int   { return p[1] }
 // LHTIndex is an ordered map implementation based on a linked hash table.
 // Insertion order is maintained based on when keys where created.
 type LHTIndex struct {
-	Count          int
-	Oldest, Latest *RowInfo
-	buckets        []*RowInfo
+	List
+	buckets []*RowInfo
+	hash    HashFunc
 }
 
+// HashFunc hashes keys to select their bucket in a LHTIndex.
+type HashFunc func(key []byte) uint64
+
+// MaxLoadFactor is the mean number of keys per bucket above which a LHTIndex doubles its number of buckets,
+// the initial number of buckets is thus only a hint: lookups stay fast when it's too small.
+const MaxLoadFactor = 1
+
+// NewLHTIndex returns a hash table with the given number of buckets, using HashFNV1a.
 func NewLHTIndex(numBuckets int) *LHTIndex {
-	return &LHTIndex{buckets: make([]*RowInfo, numBuckets)}
+	return NewLHTIndexWithHash(numBuckets, HashFNV1a)
+}
+
+// NewLHTIndexWithHash returns a hash table with the given number of buckets and hash function.
+//
+// Use a seeded hash (see NewSeededHash) when keys are chosen by untrusted users:
+// with a fixed hash, they can craft keys falling in the same bucket and make lookups linear.
+func NewLHTIndexWithHash(numBuckets int, hash HashFunc) *LHTIndex {
+	return &LHTIndex{buckets: make([]*RowInfo, max(numBuckets, 1)), hash: hash}
+}
+
+// HashFNV1a is the 64-bit FNV-1a hash (fast but not resistant to crafted collisions).
+func HashFNV1a(key []byte) uint64 {
+	const offset, prime = uint64(14695981039346656037), uint64(1099511628211) // fnv-1a constants
+	hash := offset
+	for _, char := range key {
+		hash *= prime
+		hash ^= uint64(char)
+	}
+	return hash
 }
 
-func (idx *LHTIndex) Put(key []byte, p Position) {
-	bucketIndex := idx.hashFNV1aIndex(key)
+// NewSeededHash returns a hash function seeded with a random value (see hash/maphash),
+// bucket collisions can't be predicted without knowing the seed.
+// The seed is not persisted: keys are hashed differently every time the keydir is rebuilt.
+func NewSeededHash() HashFunc {
+	seed := maphash.MakeSeed()
+	return func(key []byte) uint64 { return maphash.Bytes(seed, key) }
+}
+
+func (idx *LHTIndex) Put(key []byte, p Position) *RowInfo {
+	bucketIndex := idx.bucketIndex(key)
 	root := idx.buckets[bucketIndex]
 	var previousInBucket *RowInfo
 	for row := root; row != nil; row, previousInBucket = row.nextInBucket, row {
 		if bytes.Equal(row.Key, key) {
 			row.Position = p
-			return
+			return row
 		}
 	}
 
 	// Append new row to bucket
-	idx.Count++
 	row := &RowInfo{Key: key, Position: p}
 	if previousInBucket == nil {
 		idx.buckets[bucketIndex] = row
@@ -45,54 +86,45 @@ func (idx *LHTIndex) Put(key []byte, p Position) {
 		previousInBucket.nextInBucket = row
 	}
 
-	// Add to end of chronological order
-	appendChronologically(idx, row)
+	// Add to end of chronological order (and increment count)
+	idx.append(row)
+	if idx.Count > MaxLoadFactor*len(idx.buckets) {
+		idx.resize(2 * len(idx.buckets))
+	}
+	return row
+}
+
+// resize rehashes the keys into the given number of buckets.
+func (idx *LHTIndex) resize(numBuckets int) {
+	idx.buckets = make([]*RowInfo, numBuckets)
+	for row := idx.Oldest; row != nil; row = row.Next {
+		i := idx.bucketIndex(row.Key)
+		row.nextInBucket = idx.buckets[i]
+		idx.buckets[i] = row
+	}
 }
 
-func (idx *LHTIndex) Delete(key []byte) {
-	bucketIndex := idx.hashFNV1aIndex(key)
+func (idx *LHTIndex) Delete(key []byte) *RowInfo {
+	bucketIndex := idx.bucketIndex(key)
 	root := idx.buckets[bucketIndex]
 	var previousInBucket *RowInfo
 	for row := root; row != nil; row, previousInBucket = row.nextInBucket, row {
 		if bytes.Equal(row.Key, key) {
-			// Delete in bucket and decrement count
-			idx.Count--
+			// Delete in bucket and chronological order (and decrement count)
 			if previousInBucket == nil {
 				idx.buckets[bucketIndex] = row.nextInBucket
 			} else {
 				previousInBucket.nextInBucket = row.nextInBucket
 			}
-			unlinkChronologically(idx, row)
-			return
+			idx.unlink(row)
+			return row
 		}
 	}
-}
-
-func unlinkChronologically(idx *LHTIndex, row *RowInfo) {
-	if row.Previous == nil {
-		idx.Oldest = row.Next
-	} else {
-		row.Previous.Next = row.Next
-	}
-	if row.Next == nil {
-		idx.Latest = row.Previous
-	} else {
-		row.Next.Previous = row.Previous
-	}
-}
-
-func appendChronologically(idx *LHTIndex, row *RowInfo) {
-	row.Previous = idx.Latest
-	if idx.Oldest == nil || idx.Latest == nil {
-		idx.Oldest = row
-	} else {
-		idx.Latest.Next = row
-	}
-	idx.Latest = row
+	return nil
 }
 
 func (idx *LHTIndex) Get(key []byte) *RowInfo {
-	root := idx.buckets[idx.hashFNV1aIndex(key)]
+	root := idx.buckets[idx.bucketIndex(key)]
 	for row := root; row != nil; row = row.nextInBucket {
 		if bytes.Equal(row.Key, key) {
 			return row
@@ -101,13 +133,46 @@ func (idx *LHTIndex) Get(key []byte) *RowInfo {
 	return nil
 }
 
-func (idx *LHTIndex) hashFNV1aIndex(key []byte) int {
-	const offset, prime = uint64(14695981039346656037), uint64(1099511628211) // fnv-1a constants
-	hash := offset
-	for _, char := range key {
-		hash *= prime
-		hash ^= uint64(char)
+// WalkRange collects and sorts the keys in the given range before walking them,
+// use a TrieIndex when lexicographical walks are frequent.
+func (idx *LHTIndex) WalkRange(start, end []byte, reverse bool, do func(row *RowInfo) error) error {
+	var rows []*RowInfo
+	for row := idx.Oldest; row != nil; row = row.Next {
+		if inRange(row.Key, start, end) {
+			rows = append(rows, row)
+		}
+	}
+	return walkSorted(rows, reverse, do)
+}
+
+func (idx *LHTIndex) bucketIndex(key []byte) int {
+	return int(idx.hash(key) % uint64(len(idx.buckets)))
+}
+
+// BucketStats holds statistics about the distribution of keys in the buckets of a LHTIndex.
+// A maximum depth much higher than the mean depth reveals skew (for example, crafted collisions).
+type BucketStats struct {
+	Buckets   int     // Number of buckets.
+	Empty     int     // Number of buckets without keys.
+	MaxDepth  int     // Number of keys in the fullest bucket.
+	MeanDepth float64 // Mean number of keys in non-empty buckets.
+}
+
+// BucketStats walks the buckets and returns their statistics.
+func (idx *LHTIndex) BucketStats() BucketStats {
+	stats := BucketStats{Buckets: len(idx.buckets)}
+	for _, root := range idx.buckets {
+		depth := 0
+		for row := root; row != nil; row = row.nextInBucket {
+			depth++
+		}
+		if depth == 0 {
+			stats.Empty++
+		}
+		stats.MaxDepth = max(stats.MaxDepth, depth)
+	}
+	if used := stats.Buckets - stats.Empty; used > 0 {
+		stats.MeanDepth = float64(idx.Count) / float64(used)
 	}
-	index := int(hash % uint64(len(idx.buckets)))
-	return index
+	return stats
 }
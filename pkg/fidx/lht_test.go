@@ -2,6 +2,8 @@ package fidx
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"testing"
 )
 
@@ -90,3 +92,95 @@ func assertOrder(t *testing.T, gotKeys, wantKeys [][]byte) {
 		}
 	}
 }
+
+func TestBucketStats(t *testing.T) {
+	// A constant hash puts all keys in the same bucket.
+	idx := NewLHTIndexWithHash(4, func(key []byte) uint64 { return 0 })
+	for _, key := range []string{"a", "b", "c"} {
+		idx.Put([]byte(key), Position{})
+	}
+	want := BucketStats{Buckets: 4, Empty: 3, MaxDepth: 3, MeanDepth: 3}
+	if got := idx.BucketStats(); got != want {
+		t.Fatalf("got %+v instead of %+v", got, want)
+	}
+
+	// Buckets are added as keys are (64 buckets grow to 1024 for 1000 keys).
+	idx = NewLHTIndexWithHash(64, NewSeededHash())
+	for i := 0; i < 1000; i++ {
+		idx.Put([]byte{byte(i), byte(i >> 8)}, Position{i})
+	}
+	if row := idx.Get([]byte{42, 0}); row == nil || row.Position.Offset() != 42 {
+		t.Fatalf("got row %+v for key 42", row)
+	}
+	stats := idx.BucketStats()
+	if stats.Buckets != 1024 {
+		t.Fatalf("got %d buckets instead of 1024", stats.Buckets)
+	}
+	if stats.Empty > stats.Buckets/2 || stats.MaxDepth > 10 {
+		t.Fatalf("skewed buckets with a seeded hash: %+v", stats)
+	}
+}
+
+func TestLHTIndexResize(t *testing.T) {
+	idx := NewLHTIndex(1)
+	const n = 10_000
+	for i := 0; i < n; i++ {
+		idx.Put(binary.BigEndian.AppendUint32(nil, uint32(i)), Position{i})
+	}
+	for i := 0; i < n; i += 2 {
+		idx.Delete(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	}
+	for i := 0; i < n; i++ {
+		row := idx.Get(binary.BigEndian.AppendUint32(nil, uint32(i)))
+		if (row == nil) != (i%2 == 0) || (row != nil && row.Position.Offset() != i) {
+			t.Fatalf("got row %+v for key %d", row, i)
+		}
+	}
+	if stats := idx.BucketStats(); stats.Buckets < n/MaxLoadFactor || float64(n/2)/float64(stats.Buckets) > MaxLoadFactor {
+		t.Fatalf("buckets didn't grow: %+v", stats)
+	}
+	if idx.Oldest.Position.Offset() != 1 || idx.Latest.Position.Offset() != n-1 {
+		t.Fatalf("got chronological order from %d to %d", idx.Oldest.Position.Offset(), idx.Latest.Position.Offset())
+	}
+}
+
+func BenchmarkLHTIndex(b *testing.B) {
+	hashes := map[string]HashFunc{"fnv1a": HashFNV1a, "seeded": NewSeededHash()}
+	for _, n := range []int{100_000, 1_000_000, 10_000_000} {
+		var keys [][]byte // generated by the first sub-benchmark run (others may be filtered out)
+		genKeys := func() [][]byte {
+			for i := len(keys); i < n; i++ {
+				keys = append(keys, []byte(fmt.Sprintf("key-%d", i)))
+			}
+			return keys
+		}
+		for _, name := range []string{"fnv1a", "seeded"} {
+			for _, numBuckets := range []int{1024, n / MaxLoadFactor} {
+				b.Run(fmt.Sprintf("keys=%d/hash=%s/buckets=%d/put", n, name, numBuckets), func(b *testing.B) {
+					keys := genKeys()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						idx := NewLHTIndexWithHash(numBuckets, hashes[name])
+						for j, key := range keys {
+							idx.Put(key, Position{j})
+						}
+					}
+					b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/key")
+				})
+			}
+			b.Run(fmt.Sprintf("keys=%d/hash=%s/get", n, name), func(b *testing.B) {
+				keys := genKeys()
+				idx := NewLHTIndexWithHash(1024, hashes[name])
+				for j, key := range keys {
+					idx.Put(key, Position{j})
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if idx.Get(keys[i%n]) == nil {
+						b.Fatal("key not found")
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package fidx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+)
+
+// Keydir snapshot format:
+//
+//	magic (8 bytes) | version (1 byte) | number of keys (uvarint) | entries... | CRC-32C (4 bytes)
+//
+// Entries are sorted lexicographically and keys are front-coded (prefix shared with the previous key is omitted):
+//
+//	shared prefix length | suffix length | suffix | chronological rank | offset | size | timestamp | expiration |
+//	content type length | content type
+//
+// All integers of an entry are uvarints, except the timestamp and expiration (varints).
+const (
+	snapshotMagic   = "TRIDBIDX"
+	SnapshotVersion = 4
+)
+
+var (
+	ErrSnapshotVersion   = errors.New("unsupported keydir snapshot version")
+	ErrSnapshotCorrupted = errors.New("corrupted keydir snapshot")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// EncodeSnapshot writes a compact binary snapshot of the keydir to w.
+func EncodeSnapshot(w io.Writer, idx Keydir) error {
+	// Rank rows in chronological order
+	ranks := make(map[*RowInfo]uint64, idx.Chronological().Count)
+	for row := idx.Chronological().Oldest; row != nil; row = row.Next {
+		ranks[row] = uint64(len(ranks))
+	}
+
+	checksum := crc32.New(castagnoli)
+	bufw := bufio.NewWriter(io.MultiWriter(w, checksum))
+	buf := append([]byte(snapshotMagic), SnapshotVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(ranks)))
+	if _, err := bufw.Write(buf); err != nil {
+		return err
+	}
+	buf = buf[:0]
+	var previousKey []byte
+	err := idx.WalkRange(nil, nil, false, func(row *RowInfo) error {
+		shared := commonPrefixLength(previousKey, row.Key)
+		buf = binary.AppendUvarint(buf, uint64(shared))
+		buf = binary.AppendUvarint(buf, uint64(len(row.Key)-shared))
+		buf = append(buf, row.Key[shared:]...)
+		buf = binary.AppendUvarint(buf, ranks[row])
+		buf = binary.AppendUvarint(buf, uint64(row.Position.Offset()))
+		buf = binary.AppendUvarint(buf, uint64(row.Position.Size()))
+		buf = binary.AppendVarint(buf, row.Timestamp)
+		buf = binary.AppendVarint(buf, row.ExpiresAt)
+		buf = binary.AppendUvarint(buf, uint64(len(row.ContentType)))
+		buf = append(buf, row.ContentType...)
+		previousKey = row.Key
+		_, err := bufw.Write(buf)
+		buf = buf[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := bufw.Flush(); err != nil {
+		return err
+	}
+	_, err = w.Write(binary.BigEndian.AppendUint32(nil, checksum.Sum32()))
+	return err
+}
+
+// DecodeSnapshot reads a snapshot written by EncodeSnapshot and inserts its keys into idx
+// (in their original chronological order).
+// The keydir is left untouched if the snapshot is invalid.
+func DecodeSnapshot(r io.Reader, idx Keydir) error {
+	checksum := crc32.New(castagnoli)
+	bufr := bufio.NewReader(r)
+	src := &byteTeeReader{r: bufr, w: checksum}
+
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return fmt.Errorf("%w: read header: %w", ErrSnapshotCorrupted, err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return fmt.Errorf("%w: bad magic", ErrSnapshotCorrupted)
+	}
+	if header[len(snapshotMagic)] != SnapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, header[len(snapshotMagic)])
+	}
+
+	count, err := binary.ReadUvarint(src)
+	if err != nil {
+		return fmt.Errorf("%w: read count: %w", ErrSnapshotCorrupted, err)
+	}
+	type entry struct {
+		key         []byte
+		rank        uint64
+		p           Position
+		timestamp   int64
+		expiresAt   int64
+		contentType string
+	}
+	var entries []entry
+	var previousKey []byte
+	contentTypes := map[string]string{}
+	for i := uint64(0); i < count; i++ {
+		var fields [5]uint64
+		for j := range fields {
+			if j == 2 {
+				// Read key suffix before rank, offset and size
+				if fields[0] > uint64(len(previousKey)) || fields[1] > 1<<16 {
+					return fmt.Errorf("%w: invalid key length at entry %d", ErrSnapshotCorrupted, i)
+				}
+				key := make([]byte, fields[0]+fields[1])
+				copy(key, previousKey[:fields[0]])
+				if _, err := io.ReadFull(src, key[fields[0]:]); err != nil {
+					return fmt.Errorf("%w: read key: %w", ErrSnapshotCorrupted, err)
+				}
+				previousKey = key
+			}
+			fields[j], err = binary.ReadUvarint(src)
+			if err != nil {
+				return fmt.Errorf("%w: read entry %d: %w", ErrSnapshotCorrupted, i, err)
+			}
+		}
+		var times [2]int64
+		for j := range times {
+			times[j], err = binary.ReadVarint(src)
+			if err != nil {
+				return fmt.Errorf("%w: read entry %d: %w", ErrSnapshotCorrupted, i, err)
+			}
+		}
+		contentTypeLength, err := binary.ReadUvarint(src)
+		if err != nil {
+			return fmt.Errorf("%w: read entry %d: %w", ErrSnapshotCorrupted, i, err)
+		} else if contentTypeLength > math.MaxUint8 {
+			return fmt.Errorf("%w: invalid content type length at entry %d", ErrSnapshotCorrupted, i)
+		}
+		contentType := make([]byte, contentTypeLength)
+		if _, err := io.ReadFull(src, contentType); err != nil {
+			return fmt.Errorf("%w: read content type: %w", ErrSnapshotCorrupted, err)
+		}
+		if _, ok := contentTypes[string(contentType)]; !ok {
+			contentTypes[string(contentType)] = string(contentType) // share strings between entries
+		}
+		entries = append(entries, entry{
+			contentType: contentTypes[string(contentType)],
+			key:         previousKey,
+			rank:        fields[2],
+			p:           Position{int(fields[3]), int(fields[4])},
+			timestamp:   times[0],
+			expiresAt:   times[1],
+		})
+	}
+
+	sum := checksum.Sum32()
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(bufr, trailer); err != nil {
+		return fmt.Errorf("%w: read checksum: %w", ErrSnapshotCorrupted, err)
+	}
+	if binary.BigEndian.Uint32(trailer) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupted)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].rank < entries[j].rank })
+	for i, e := range entries {
+		if e.rank != uint64(i) {
+			return fmt.Errorf("%w: invalid chronological rank %d", ErrSnapshotCorrupted, e.rank)
+		}
+	}
+	for _, e := range entries {
+		row := idx.Put(e.key, e.p)
+		row.Timestamp, row.ExpiresAt, row.ContentType = e.timestamp, e.expiresAt, e.contentType
+	}
+	return nil
+}
+
+// byteTeeReader is like io.TeeReader but also implements io.ByteReader (needed to read uvarints).
+type byteTeeReader struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func (t *byteTeeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.w.Write(p[:n])
+	return n, err
+}
+
+func (t *byteTeeReader) ReadByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err == nil {
+		t.w.Write([]byte{b})
+	}
+	return b, err
+}
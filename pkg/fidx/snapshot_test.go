@@ -0,0 +1,54 @@
+package fidx
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	idx := NewTrieIndex()
+	keys := [][]byte{[]byte("user:2"), []byte("user:10"), []byte("a"), []byte("user:1")}
+	for i, key := range keys {
+		idx.Put(key, Position{i * 10, 10})
+	}
+	idx.Get([]byte("a")).ContentType = "application/json"
+
+	buf := &bytes.Buffer{}
+	if err := EncodeSnapshot(buf, idx); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	got := NewLHTIndex(3)
+	if err := DecodeSnapshot(bytes.NewReader(encoded), got); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		assertInsertion(t, got, key, Position{i * 10, 10})
+	}
+	var gotKeys [][]byte
+	for row := got.Oldest; row != nil; row = row.Next {
+		gotKeys = append(gotKeys, row.Key)
+	}
+	assertOrder(t, gotKeys, keys)
+	if contentType := got.Get([]byte("a")).ContentType; contentType != "application/json" {
+		t.Fatalf("got content type %q", contentType)
+	}
+
+	// Detect corruption
+	corrupted := bytes.Clone(encoded)
+	corrupted[len(corrupted)/2] ^= 0xFF
+	if err := DecodeSnapshot(bytes.NewReader(corrupted), NewTrieIndex()); !errors.Is(err, ErrSnapshotCorrupted) {
+		t.Fatalf("got error %v instead of %v", err, ErrSnapshotCorrupted)
+	}
+
+	// Empty keydir
+	buf.Reset()
+	if err := EncodeSnapshot(buf, NewTrieIndex()); err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeSnapshot(buf, NewTrieIndex()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,235 @@
+package fidx
+
+import (
+	"bytes"
+	"sort"
+)
+
+// TrieIndex is an ordered map implementation based on a radix trie (compressed prefix tree).
+// Keys are kept in lexicographical order (for prefix and range walks)
+// and are also linked in chronological order.
+//
+// Keys are binary-safe: edges are labeled with arbitrary bytes (children are sorted by their first byte),
+// there is no restricted charset.
+type TrieIndex struct {
+	List
+	root trieNode
+}
+
+type trieNode struct {
+	label    []byte      // edge label (from parent)
+	children []*trieNode // sorted by first label byte
+	row      *RowInfo    // non-nil if a key ends at this node
+	count    int         // number of keys in the subtree (see CountPrefix)
+}
+
+func NewTrieIndex() *TrieIndex { return &TrieIndex{} }
+
+// childIndex returns the index of the child whose label starts with c,
+// or the index at which such a child should be inserted.
+func (n *trieNode) childIndex(c byte) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].label[0] >= c })
+	return i, i < len(n.children) && n.children[i].label[0] == c
+}
+
+func commonPrefixLength(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func (idx *TrieIndex) Put(key []byte, p Position) *RowInfo {
+	if row := idx.Get(key); row != nil {
+		row.Position = p
+		return row
+	}
+	row := &RowInfo{Key: key, Position: p}
+	idx.root.insert(row)
+	idx.append(row)
+	return row
+}
+
+// insert adds the row of a new key to the subtree of the root node.
+func (root *trieNode) insert(row *RowInfo) {
+	// The key is new: each node on its path gets one more key in its subtree.
+	n, rest := root, row.Key
+	n.count++
+	for len(rest) > 0 {
+		i, found := n.childIndex(rest[0])
+		if !found {
+			child := &trieNode{label: bytes.Clone(rest), count: 1}
+			n.children = append(n.children, nil)
+			copy(n.children[i+1:], n.children[i:])
+			n.children[i] = child
+			n, rest = child, nil
+			break
+		}
+
+		child := n.children[i]
+		common := commonPrefixLength(child.label, rest)
+		if common < len(child.label) {
+			// Split child edge
+			mid := &trieNode{label: child.label[:common:common], children: []*trieNode{child}, count: child.count}
+			child.label = child.label[common:]
+			n.children[i] = mid
+			child = mid
+		}
+		child.count++
+		n, rest = child, rest[common:]
+	}
+	n.row = row
+}
+
+func (idx *TrieIndex) Get(key []byte) *RowInfo { return idx.root.get(key) }
+
+// get returns the row of the given key in the subtree of the root node (or nil).
+func (root *trieNode) get(key []byte) *RowInfo {
+	n, rest := root, key
+	for len(rest) > 0 {
+		i, found := n.childIndex(rest[0])
+		if !found || !bytes.HasPrefix(rest, n.children[i].label) {
+			return nil
+		}
+		n, rest = n.children[i], rest[len(n.children[i].label):]
+	}
+	return n.row
+}
+
+func (idx *TrieIndex) Delete(key []byte) *RowInfo {
+	deleted := idx.root.remove(key)
+	if deleted != nil {
+		idx.unlink(deleted)
+	}
+	return deleted
+}
+
+// remove removes the given key from the subtree of the root node and returns its row (or nil).
+func (root *trieNode) remove(key []byte) *RowInfo {
+	// Find node and keep track of the path (to remove empty nodes afterwards)
+	path := []*trieNode{root}
+	n, rest := root, key
+	for len(rest) > 0 {
+		i, found := n.childIndex(rest[0])
+		if !found || !bytes.HasPrefix(rest, n.children[i].label) {
+			return nil
+		}
+		n, rest = n.children[i], rest[len(n.children[i].label):]
+		path = append(path, n)
+	}
+	deleted := n.row
+	if deleted == nil {
+		return nil
+	}
+	n.row = nil
+	for _, node := range path {
+		node.count--
+	}
+
+	// Remove node if it has no children, then merge nodes that have a single child and no row.
+	for i := len(path) - 1; i > 0; i-- {
+		node, parent := path[i], path[i-1]
+		if node.row != nil || len(node.children) > 1 {
+			break
+		}
+		if len(node.children) == 0 {
+			j, _ := parent.childIndex(node.label[0])
+			parent.children = append(parent.children[:j], parent.children[j+1:]...)
+			continue
+		}
+		child := node.children[0]
+		label := make([]byte, 0, len(node.label)+len(child.label))
+		node.label = append(append(label, node.label...), child.label...)
+		node.row, node.children = child.row, child.children
+		break
+	}
+	return deleted
+}
+
+// CountPrefix returns the number of keys starting with the given prefix, in O(len(prefix)).
+func (idx *TrieIndex) CountPrefix(prefix []byte) int { return idx.root.countPrefix(prefix) }
+
+// countPrefix returns the number of keys starting with the given prefix in the subtree of the root node.
+func (root *trieNode) countPrefix(prefix []byte) int {
+	n, rest := root, prefix
+	for len(rest) > 0 {
+		i, found := n.childIndex(rest[0])
+		if !found {
+			return 0
+		}
+		child := n.children[i]
+		common := commonPrefixLength(child.label, rest)
+		if common == len(rest) {
+			return child.count // the prefix ends on the edge to child
+		}
+		if common < len(child.label) {
+			return 0
+		}
+		n, rest = child, rest[common:]
+	}
+	return n.count
+}
+
+// Nth returns the row of the key of rank i in lexicographical order (or nil if out of range),
+// in O(key depth) by descending into the subtree holding it thanks to the subtree counts.
+func (idx *TrieIndex) Nth(i int) *RowInfo {
+	if i < 0 || i >= idx.root.count {
+		return nil
+	}
+	n := &idx.root
+	for {
+		if n.row != nil {
+			if i == 0 {
+				return n.row
+			}
+			i-- // keys ending at a node come before the keys of its children
+		}
+		for _, child := range n.children {
+			if i < child.count {
+				n = child
+				break
+			}
+			i -= child.count
+		}
+	}
+}
+
+func (idx *TrieIndex) WalkRange(start, end []byte, reverse bool, do func(row *RowInfo) error) error {
+	// Paths of siblings share the same buffer (a path is only used while walking its subtree),
+	// so that walks don't allocate per node.
+	return idx.root.walkRange(make([]byte, 0, 256), start, end, reverse, do)
+}
+
+// walkRange walks the subtree of keys starting with the given path (the full key of the node).
+func (n *trieNode) walkRange(path, start, end []byte, reverse bool, do func(row *RowInfo) error) error {
+	// All keys in this subtree start with path, skip subtree if they are all out of range.
+	if end != nil && bytes.Compare(path, end) >= 0 {
+		return nil
+	}
+	if start != nil && bytes.Compare(path, start) < 0 && !bytes.HasPrefix(start, path) {
+		return nil
+	}
+
+	if !reverse && n.row != nil && inRange(n.row.Key, start, end) {
+		if err := do(n.row); err != nil {
+			return err
+		}
+	}
+	for i := range n.children {
+		child := n.children[i]
+		if reverse {
+			child = n.children[len(n.children)-1-i]
+		}
+		err := child.walkRange(append(path, child.label...), start, end, reverse, do)
+		if err != nil {
+			return err
+		}
+	}
+	if reverse && n.row != nil && inRange(n.row.Key, start, end) {
+		if err := do(n.row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
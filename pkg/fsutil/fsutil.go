@@ -0,0 +1,23 @@
+// Package fsutil provides durable file system operations that behave the same across platforms.
+package fsutil
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Rename renames oldpath to newpath (replacing it if it exists) and syncs the directory of newpath,
+// so that the rename survives a crash (on most file systems, a rename is only durable once its directory is synced).
+//
+// On Windows, files can't be renamed while open: the rename is retried for a while
+// in case another process (for example an antivirus or an indexer) briefly holds one of them,
+// the caller must close its own handles first (see CanRenameOpenFiles).
+func Rename(oldpath, newpath string) error {
+	if err := rename(oldpath, newpath); err != nil {
+		return err
+	}
+	if err := SyncDir(filepath.Dir(newpath)); err != nil {
+		return fmt.Errorf("sync directory: %w", err)
+	}
+	return nil
+}
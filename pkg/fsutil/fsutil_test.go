@@ -0,0 +1,32 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRename(t *testing.T) {
+	dir := t.TempDir()
+	oldpath, newpath := filepath.Join(dir, "new.tmp"), filepath.Join(dir, "file")
+	for path, content := range map[string]string{oldpath: "new", newpath: "old"} {
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Rename(oldpath, newpath); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(newpath); err != nil || string(content) != "new" {
+		t.Fatalf("got content %q (%v)", content, err)
+	}
+	if _, err := os.Stat(oldpath); !os.IsNotExist(err) {
+		t.Fatalf("the renamed file still exists: %v", err)
+	}
+	if err := Rename(oldpath, newpath); !os.IsNotExist(err) {
+		t.Fatalf("got error %v when renaming a missing file", err)
+	}
+	if err := SyncDir(filepath.Join(dir, "missing")); err == nil && CanRenameOpenFiles {
+		t.Fatal("expected an error when syncing a missing directory")
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package fsutil
+
+import "os"
+
+// CanRenameOpenFiles reports whether files can be renamed (or replaced) while they are open.
+const CanRenameOpenFiles = true
+
+func rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+// SyncDir syncs the given directory, so that the files created, renamed or removed in it are durable.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
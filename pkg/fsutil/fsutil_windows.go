@@ -0,0 +1,41 @@
+//go:build windows
+
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// CanRenameOpenFiles reports whether files can be renamed (or replaced) while they are open.
+const CanRenameOpenFiles = false
+
+// Rename attempts and the delay between them (doubled after each attempt).
+const (
+	renameAttempts = 10
+	renameDelay    = 10 * time.Millisecond
+)
+
+const (
+	errorAccessDenied     syscall.Errno = 5
+	errorSharingViolation syscall.Errno = 32
+)
+
+// rename retries renames failing because a file is open.
+func rename(oldpath, newpath string) (err error) {
+	delay := renameDelay
+	for i := 0; i < renameAttempts; i++ {
+		err = os.Rename(oldpath, newpath)
+		if !errors.Is(err, errorAccessDenied) && !errors.Is(err, errorSharingViolation) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return err
+}
+
+// SyncDir does nothing: directories can't be synced on Windows, NTFS journals renames.
+func SyncDir(dir string) error { return nil }
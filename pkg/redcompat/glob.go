@@ -0,0 +1,90 @@
+package redcompat
+
+// matchGlob reports whether s matches the given glob-style pattern (like Redis KEYS and SCAN MATCH):
+// a star matches any sequence of bytes, a question mark matches any byte, brackets match one of the listed bytes
+// ([abc], [^abc] for any other byte, [a-z] for a range) and a backslash escapes the next byte.
+//
+// Stars backtrack to the last star only, so that matching takes O(len(pattern) * len(s)).
+func matchGlob(pattern, s []byte) bool {
+	p, i := 0, 0
+	star, starI := -1, 0 // position of the last star in the pattern, and where it started matching in s
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			star, starI = p, i
+			p++
+			continue
+		}
+		if p < len(pattern) {
+			if matched, next := matchByte(pattern, p, s[i]); matched {
+				p, i = next, i+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		// Let the last star match one more byte
+		starI++
+		p, i = star+1, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchByte matches c against the pattern element (other than a star) at position p.
+// It reports whether it matched and the position of the next pattern element.
+func matchByte(pattern []byte, p int, c byte) (bool, int) {
+	switch pattern[p] {
+	case '?':
+		return true, p + 1
+	case '[':
+		return matchClass(pattern, p+1, c)
+	case '\\':
+		if p+1 < len(pattern) {
+			return pattern[p+1] == c, p + 2
+		}
+	}
+	return pattern[p] == c, p + 1
+}
+
+// matchClass matches c against the class starting at position p (after the opening bracket).
+// An unterminated class ends with the pattern.
+func matchClass(pattern []byte, p int, c byte) (bool, int) {
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+	matched := false
+	for ; p < len(pattern) && pattern[p] != ']'; p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			matched = matched || pattern[p] == c
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			p += 2
+		default:
+			matched = matched || pattern[p] == c
+		}
+	}
+	if p < len(pattern) {
+		p++ // closing bracket
+	}
+	return matched != negate, p
+}
+
+// literalPrefix returns the bytes every key matching the given pattern starts with.
+func literalPrefix(pattern []byte) []byte {
+	for i, c := range pattern {
+		if c == '*' || c == '?' || c == '[' || c == '\\' {
+			return pattern[:i]
+		}
+	}
+	return pattern
+}
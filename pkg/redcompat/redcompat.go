@@ -0,0 +1,517 @@
+// Package redcompat serves a tridb database file over the Redis protocol (RESP),
+// so that existing Redis clients can be used without a custom driver.
+//
+// Supported commands:
+//
+//	GET key
+//	SET key value [EX seconds | PX milliseconds] [NX | XX]
+//	DEL key [key ...]
+//	EXISTS key [key ...]
+//	KEYS pattern
+//	SCAN cursor [MATCH pattern] [COUNT count]
+//	DBSIZE
+//	PING [message], AUTH [username] password, QUIT
+//
+// Patterns are glob-style (like Redis), keys are visited in lexicographical order.
+package redcompat
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// DefaultMaxValueSize is the maximum size of bulk strings sent by clients when none is configured.
+const DefaultMaxValueSize = 32 << 20
+
+// ErrServerClosed is returned by Server.Serve after the server is closed.
+var ErrServerClosed = errors.New("redcompat: server closed")
+
+// maxCursors is the maximum number of pending SCAN cursors per connection (the oldest ones are dropped).
+const maxCursors = 1024
+
+// Server serves a database file to Redis clients.
+type Server struct {
+	f            *tridb.File
+	password     string
+	maxValueSize int
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// Option configures a server.
+type Option func(*Server)
+
+// WithPassword requires clients to authenticate with the given password (AUTH command).
+func WithPassword(password string) Option { return func(s *Server) { s.password = password } }
+
+// WithMaxValueSize limits the size of bulk strings sent by clients (larger ones close the connection).
+func WithMaxValueSize(size int) Option { return func(s *Server) { s.maxValueSize = size } }
+
+// NewServer returns a server for the given file.
+func NewServer(f *tridb.File, opts ...Option) *Server {
+	s := &Server{
+		f:            f,
+		maxValueSize: DefaultMaxValueSize,
+		listeners:    map[net.Listener]struct{}{},
+		conns:        map[net.Conn]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ListenAndServe listens on the given TCP address and serves clients (see Serve).
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the given listener until the server is closed (ErrServerClosed is then returned).
+// Each connection is served in its own goroutine.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners, closes the connections and waits for their in-flight commands.
+// It doesn't close the database file.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		err = errors.Join(err, l.Close())
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// conn holds the state of a client connection.
+type conn struct {
+	s             *Server
+	w             *bufio.Writer
+	authenticated bool
+	cursors       map[uint64][]byte // next key of pending SCAN cursors
+	nextCursor    uint64
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		nc.Close()
+		s.wg.Done()
+	}()
+	r := bufio.NewReader(nc)
+	c := &conn{s: s, w: bufio.NewWriter(nc), authenticated: s.password == "", cursors: map[uint64][]byte{}}
+	for {
+		args, err := readCommand(r, s.maxValueSize)
+		if errors.Is(err, errProtocol) {
+			writeError(c.w, "ERR "+err.Error())
+			c.w.Flush()
+			return
+		} else if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := c.exec(args)
+		// Flush once the pipelined commands are handled
+		if r.Buffered() == 0 || quit {
+			if c.w.Flush() != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// exec executes the given command and writes its reply, it reports whether the connection must be closed.
+func (c *conn) exec(args [][]byte) (quit bool) {
+	name, raw := strings.ToUpper(string(args[0])), args[0]
+	args = args[1:]
+	switch {
+	case name == "QUIT":
+		writeSimpleString(c.w, "OK")
+		return true
+	case name == "AUTH":
+		c.auth(args)
+		return false
+	case !c.authenticated:
+		writeError(c.w, "NOAUTH Authentication required.")
+		return false
+	}
+
+	var err error
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			err = wrongArity(name)
+		} else if len(args) == 1 {
+			writeBulkString(c.w, args[0])
+		} else {
+			writeSimpleString(c.w, "PONG")
+		}
+	case "GET":
+		err = c.get(args)
+	case "SET":
+		err = c.set(args)
+	case "DEL":
+		err = c.del(args)
+	case "EXISTS":
+		err = c.exists(args)
+	case "KEYS":
+		err = c.keys(args)
+	case "SCAN":
+		err = c.scan(args)
+	case "DBSIZE":
+		if len(args) != 0 {
+			err = wrongArity(name)
+			break
+		}
+		count := 0
+		err = c.s.f.Read(func(r *tridb.Reader) error { count = r.Count(); return nil })
+		if err == nil {
+			writeInteger(c.w, count)
+		}
+	default:
+		err = fmt.Errorf("ERR unknown command '%s'", truncate(raw))
+	}
+	if err != nil {
+		writeError(c.w, errorReply(err))
+	}
+	return false
+}
+
+// wrongArity returns the error replied to commands with the wrong number of arguments.
+func wrongArity(name string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+var errSyntax = errors.New("ERR syntax error")
+
+// errorReply returns the error reply for the given error (with an error code).
+func errorReply(err error) string {
+	switch msg := err.Error(); {
+	case errors.Is(err, tridb.ErrReadOnly):
+		return "READONLY " + msg
+	case strings.HasPrefix(msg, "ERR "):
+		return msg
+	default:
+		return "ERR " + msg
+	}
+}
+
+func (c *conn) auth(args [][]byte) {
+	var password []byte
+	switch len(args) {
+	case 1:
+		password = args[0]
+	case 2:
+		if string(args[0]) != "default" {
+			writeError(c.w, "WRONGPASS invalid username-password pair or user is disabled.")
+			return
+		}
+		password = args[1]
+	default:
+		writeError(c.w, errorReply(wrongArity("AUTH")))
+		return
+	}
+	if c.s.password == "" {
+		writeError(c.w, "ERR AUTH called without any password configured for the default user.")
+		return
+	}
+	if subtle.ConstantTimeCompare(password, []byte(c.s.password)) != 1 {
+		writeError(c.w, "WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	c.authenticated = true
+	writeSimpleString(c.w, "OK")
+}
+
+func (c *conn) get(args [][]byte) error {
+	if len(args) != 1 {
+		return wrongArity("GET")
+	}
+	var value []byte
+	err := c.s.f.Read(func(r *tridb.Reader) (err error) {
+		value, err = r.Get(args[0])
+		if value == nil && err == nil && r.Has(args[0]) {
+			value = []byte{} // empty value (not a null reply)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	writeBulkString(c.w, value)
+	return nil
+}
+
+func (c *conn) set(args [][]byte) error {
+	if len(args) < 2 {
+		return wrongArity("SET")
+	}
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	var onlyIfMissing, onlyIfExists bool
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i])); {
+		case option == "NX" && !onlyIfExists:
+			onlyIfMissing = true
+		case option == "XX" && !onlyIfMissing:
+			onlyIfExists = true
+		case (option == "EX" || option == "PX") && ttl == 0 && i+1 < len(args):
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return errors.New("ERR value is not an integer or out of range")
+			}
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > int64(time.Duration(1<<62)/unit) {
+				return errors.New("ERR invalid expire time in 'set' command")
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			return errSyntax
+		}
+	}
+
+	written := false
+	err := c.s.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		if (onlyIfMissing && r.Has(key)) || (onlyIfExists && !r.Has(key)) {
+			return nil
+		}
+		if ttl > 0 {
+			w.SetWithTTL(key, value, ttl)
+		} else {
+			w.Set(key, value)
+		}
+		written = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !written {
+		writeBulkString(c.w, nil)
+		return nil
+	}
+	writeSimpleString(c.w, "OK")
+	return nil
+}
+
+func (c *conn) del(args [][]byte) error {
+	if len(args) == 0 {
+		return wrongArity("DEL")
+	}
+	deleted := 0
+	err := c.s.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		seen := map[string]bool{}
+		for _, key := range args {
+			if !seen[string(key)] && r.Has(key) {
+				w.Delete(key)
+				deleted++
+			}
+			seen[string(key)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	writeInteger(c.w, deleted)
+	return nil
+}
+
+func (c *conn) exists(args [][]byte) error {
+	if len(args) == 0 {
+		return wrongArity("EXISTS")
+	}
+	count := 0
+	_ = c.s.f.Read(func(r *tridb.Reader) error {
+		for _, key := range args {
+			count += boolToInt(r.Has(key))
+		}
+		return nil
+	})
+	writeInteger(c.w, count)
+	return nil
+}
+
+func (c *conn) keys(args [][]byte) error {
+	if len(args) != 1 {
+		return wrongArity("KEYS")
+	}
+	pattern := args[0]
+	keys := [][]byte{}
+	err := c.s.f.Read(func(r *tridb.Reader) error {
+		_, err := r.Walk(literalPrefix(pattern), func(key []byte) error {
+			if matchGlob(pattern, key) {
+				keys = append(keys, bytes.Clone(key))
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	writeArray(c.w, keys) // written once the read lock is released (clients may be slow)
+	return nil
+}
+
+func (c *conn) scan(args [][]byte) error {
+	if len(args) == 0 {
+		return wrongArity("SCAN")
+	}
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return errors.New("ERR invalid cursor")
+	}
+	var pattern []byte
+	count := 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errSyntax
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return errors.New("ERR value is not an integer or out of range")
+			} else if count < 1 {
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	// Cursors are resolved to the key where the previous page ended.
+	prefix := literalPrefix(pattern)
+	start := prefix
+	if cursor != 0 {
+		next, ok := c.cursors[cursor]
+		if !ok {
+			return errors.New("ERR invalid cursor")
+		}
+		delete(c.cursors, cursor)
+		if bytes.Compare(next, start) > 0 {
+			start = next
+		}
+	}
+	keys := [][]byte{}
+	var next []byte
+	visited := 0
+	err = c.s.f.Read(func(r *tridb.Reader) error {
+		return r.WalkRange(start, fidx.PrefixEnd(prefix), func(key []byte) error {
+			if visited == count {
+				next = bytes.Clone(key)
+				return tridb.ErrBreak
+			}
+			visited++
+			if pattern == nil || matchGlob(pattern, key) {
+				keys = append(keys, bytes.Clone(key))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	nextCursor := uint64(0)
+	if next != nil {
+		c.nextCursor++
+		nextCursor = c.nextCursor
+		c.cursors[nextCursor] = next
+		if len(c.cursors) > maxCursors {
+			for id := range c.cursors {
+				if id <= nextCursor-maxCursors {
+					delete(c.cursors, id) // abandoned scan
+				}
+			}
+		}
+	}
+	c.w.WriteString("*2\r\n")
+	writeBulkString(c.w, []byte(strconv.FormatUint(nextCursor, 10)))
+	writeArray(c.w, keys)
+	return nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
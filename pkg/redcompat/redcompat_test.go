@@ -0,0 +1,200 @@
+package redcompat
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// client is a minimal RESP client (replies are decoded as string, int, nil, error or []any).
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *client) do(args ...string) any {
+	c.t.Helper()
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, cmd); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+func (c *client) read() any {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return errors.New(line[1:])
+	case ':':
+		n, _ := strconv.Atoi(line[1:])
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(b[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := []any{}
+		for i := 0; i < n; i++ {
+			items = append(items, c.read())
+		}
+		return items
+	}
+	c.t.Fatalf("unexpected reply: %q", line)
+	return nil
+}
+
+func (c *client) expect(want any, args ...string) {
+	c.t.Helper()
+	got := c.do(args...)
+	if err, ok := got.(error); ok {
+		got = "-" + strings.SplitN(err.Error(), " ", 2)[0] // error code
+	}
+	if !reflect.DeepEqual(got, want) {
+		c.t.Fatalf("%q: got %#v instead of %#v", args, got, want)
+	}
+}
+
+func TestServer(t *testing.T) {
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(f, WithPassword("secret"))
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	c := dial(t, l.Addr().String())
+	c.expect("-NOAUTH", "GET", "a")
+	c.expect("-WRONGPASS", "AUTH", "wrong")
+	c.expect("OK", "AUTH", "default", "secret")
+	c.expect("PONG", "PING")
+
+	c.expect("OK", "SET", "users/1", "alice")
+	c.expect("OK", "set", "users/2", "bob", "EX", "60")
+	c.expect("OK", "SET", "users/3", "", "PX", "60000", "NX")
+	c.expect(nil, "SET", "users/3", "carol", "NX")
+	c.expect(nil, "SET", "other", "x", "XX")
+	c.expect("-ERR", "SET", "other", "x", "EX", "0")
+	c.expect("-ERR", "SET", "other", "x", "NX", "XX")
+	c.expect("alice", "GET", "users/1")
+	c.expect("", "GET", "users/3")
+	c.expect(nil, "GET", "other")
+	c.expect(2, "EXISTS", "users/1", "users/2", "other")
+	c.expect(3, "DBSIZE")
+	c.expect([]any{"users/1", "users/3"}, "KEYS", "users/[13]")
+	c.expect([]any{"users/1", "users/2", "users/3"}, "KEYS", "*")
+
+	// Scan pages
+	c.expect([]any{"1", []any{"users/1", "users/2"}}, "SCAN", "0", "COUNT", "2")
+	c.expect([]any{"0", []any{"users/3"}}, "SCAN", "1", "COUNT", "2")
+	c.expect("-ERR", "SCAN", "1")
+	c.expect([]any{"0", []any{"users/2"}}, "SCAN", "0", "MATCH", "users/2*")
+
+	c.expect(2, "DEL", "users/1", "users/1", "users/2", "other")
+	c.expect(1, "DBSIZE")
+	c.expect("-ERR", "UNKNOWN")
+	c.expect("-ERR", "GET")
+
+	// Pipelined and inline commands
+	if _, err := io.WriteString(c.conn, "PING\r\nGET users/3\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.read(); got != "PONG" {
+		t.Fatalf("got %#v", got)
+	}
+	if got := c.read(); got != "" {
+		t.Fatalf("got %#v", got)
+	}
+
+	// Protocol errors close the connection
+	if _, err := io.WriteString(c.conn, "*1\r\n$x\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.read().(error); !ok || !strings.Contains(got.Error(), "Protocol error") {
+		t.Fatalf("got %#v", got)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("got error %v instead of EOF", err)
+	}
+
+	c = dial(t, l.Addr().String())
+	c.expect("OK", "QUIT")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("got error %v instead of %v", err, ErrServerClosed)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, test := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abcd", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"users/*", "users/1", true},
+	} {
+		if got := matchGlob([]byte(test.pattern), []byte(test.s)); got != test.want {
+			t.Errorf("%q matching %q: got %v", test.pattern, test.s, got)
+		}
+	}
+	if prefix := literalPrefix([]byte("users/*/name")); string(prefix) != "users/" {
+		t.Fatalf("got prefix %q", prefix)
+	}
+}
@@ -0,0 +1,124 @@
+package redcompat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxArgs is the maximum number of arguments of a command.
+const maxArgs = 1 << 20
+
+// errProtocol is returned when a client doesn't speak RESP, the connection is then closed.
+var errProtocol = errors.New("Protocol error")
+
+// readCommand reads a command: an array of bulk strings, or an inline command (ex: "GET key" from telnet).
+// An empty command (blank line) has no arguments.
+func readCommand(r *bufio.Reader, maxBulkLength int) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		fields := bytes.Fields(line)
+		args := make([][]byte, len(fields))
+		for i, field := range fields {
+			args[i] = bytes.Clone(field)
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	args := make([][]byte, 0, min(n, 64))
+	for len(args) < n {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, orUnexpectedEOF(err)
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errProtocol, truncate(line))
+		}
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, orUnexpectedEOF(err)
+		}
+		if !bytes.HasSuffix(arg, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: missing CRLF after bulk string", errProtocol)
+		}
+		args = append(args, arg[:length])
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by CRLF (or LF), without the terminator.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("%w: too big inline request", errProtocol)
+	} else if err != nil {
+		if len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return bytes.TrimSuffix(line[:len(line)-1], []byte("\r")), nil
+}
+
+func orUnexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// truncate shortens the given line for error messages.
+func truncate(line []byte) []byte {
+	if len(line) > 32 {
+		return line[:32]
+	}
+	return line
+}
+
+// Replies
+
+func writeSimpleString(w *bufio.Writer, s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+// writeError writes an error reply, msg starts with the error code (ex: "ERR syntax error").
+func writeError(w *bufio.Writer, msg string) {
+	w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + "\r\n")
+}
+
+func writeInteger(w *bufio.Writer, n int) {
+	w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeBulkString writes a bulk string, or a null bulk string if b is nil.
+func writeBulkString(w *bufio.Writer, b []byte) {
+	if b == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func writeArray(w *bufio.Writer, items [][]byte) {
+	w.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, item := range items {
+		writeBulkString(w, item)
+	}
+}
@@ -0,0 +1,22 @@
+package tridb
+
+import "errors"
+
+// maxAliasHops is the maximum number of aliases followed when resolving a value.
+const maxAliasHops = 16
+
+// ErrAliasCycle is returned when resolving an alias that refers to itself (directly or not)
+// or whose chain is too long.
+var ErrAliasCycle = errors.New("alias cycle")
+
+// Alias sets the alias key as a reference to the target key:
+// reading the alias returns the current value of the target (or nil if the target doesn't exist).
+//
+// Aliases are lightweight rows that don't duplicate the target value,
+// they can be overwritten and deleted like any other key.
+func (w *Writer) Alias(aliasKey, targetKey []byte) {
+	if !w.checkKey(aliasKey) || !w.checkKey(targetKey) {
+		return
+	}
+	w.stage(&Row{Key: aliasKey, Value: targetKey, IsAlias: true})
+}
@@ -0,0 +1,47 @@
+package tridb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAlias(t *testing.T) {
+	f := openTestFile(t)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("user/1"), []byte("alice"))
+		w.Alias([]byte("email/alice@example.com"), []byte("user/1"))
+		w.Alias([]byte("nickname/al"), []byte("email/alice@example.com"))
+		w.Alias([]byte("loop/a"), []byte("loop/b"))
+		w.Alias([]byte("loop/b"), []byte("loop/a"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "email/alice@example.com", "alice")
+	assertValue(t, f, "nickname/al", "alice")
+
+	// Aliases follow the target value and persist across compaction.
+	mustSet(t, f, "user/1", "alice2")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "nickname/al", "alice2")
+
+	_ = f.Read(func(r *Reader) error {
+		if _, err := r.Get([]byte("loop/a")); !errors.Is(err, ErrAliasCycle) {
+			t.Fatalf("got error %v instead of %v", err, ErrAliasCycle)
+		}
+		return nil
+	})
+
+	// Dangling aliases resolve to nil.
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("user/1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "email/alice@example.com", "")
+}
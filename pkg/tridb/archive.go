@@ -0,0 +1,71 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// archiveRows appends the rows among the first end bytes of the file that are superseded, deleted
+// or missing from the compacted keydirs to the archive file (see WithCompactionArchive).
+// It is called by compactions once the rows of the source are written (without holding the lock).
+func (f *File) archiveRows(end int, cleanIdx, cleanSys fidx.Keydir) (err error) {
+	archive, err := os.OpenFile(f.opts.ArchivePath, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer archive.Close()
+	size, err := archive.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("seek archive: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			archive.Truncate(size) // drop the rows of the failed archival, they would be archived again
+		}
+	}()
+
+	// Archives are datafiles (of the same format), check the header of existing ones
+	header := newFileHeader(f.format).encode()
+	if size > 0 {
+		got := make([]byte, len(header))
+		if _, err := archive.ReadAt(got, 0); err != nil && err != io.EOF {
+			return fmt.Errorf("read archive header: %w", err)
+		} else if !bytes.Equal(got, header) {
+			return fmt.Errorf("%w: archive %q has another format", ErrBadFileFormat, f.opts.ArchivePath)
+		}
+	}
+
+	bufw := bufio.NewWriterSize(&throttledWriter{w: archive, limiter: f.newRateLimiter()}, f.opts.WriteBufferSize)
+	offset := int(size)
+	if size == 0 {
+		if offset, err = f.writeHeader(bufw, nil); err != nil {
+			return fmt.Errorf("archive: %w", err)
+		}
+	}
+	_, _, err = f.copyRows(bufw, offset, 0, end, fidx.NewTrieIndex(), fidx.NewTrieIndex(), nil, func(row *Row, p fidx.Position) bool {
+		idx, clean := f.idx, cleanIdx
+		if IsReservedKey(row.Key) {
+			idx, clean = f.sys, cleanSys
+		}
+		key := f.indexKey(row.Key)
+		f.mu.RLock()
+		current := idx.Get(key)
+		f.mu.RUnlock()
+		return current == nil || current.Position != p || clean.Get(key) == nil
+	})
+	if err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+	if err := bufw.Flush(); err != nil {
+		return fmt.Errorf("write to archive: %w", err)
+	}
+	if err := archive.Sync(); err != nil {
+		return fmt.Errorf("sync archive: %w", err)
+	}
+	return nil
+}
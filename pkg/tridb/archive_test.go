@@ -0,0 +1,69 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompactionArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.tridb")
+	f := openTestFile(t, WithCompactionArchive(path))
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "a", "2")
+	mustSet(t, f, "b", "1")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("b"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "a", "2")
+	assertValue(t, f, "b", "")
+
+	// Archives accumulate the rows dropped by successive compactions
+	mustSet(t, f, "a", "3")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := Open(path, 10, WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	_ = archive.Read(func(r *Reader) error {
+		for key, want := range map[string][]string{"a": {"1", "2"}, "b": {"1", ""}} {
+			changes, err := r.History([]byte(key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != len(want) {
+				t.Fatalf("got %d archived rows for %q instead of %d", len(changes), key, len(want))
+			}
+			for i, c := range changes {
+				if string(c.Value) != want[i] || c.IsDeleted != (want[i] == "") {
+					t.Fatalf("got archived row %+v for %q instead of %q", c, key, want[i])
+				}
+			}
+		}
+		return nil
+	})
+
+	// Archives in another format are rejected
+	other := filepath.Join(t.TempDir(), "other.tridb")
+	if err := os.WriteFile(other, []byte("not a datafile"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f = openTestFile(t, WithCompactionArchive(other))
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "a", "2")
+	if err := f.Compact(); !errors.Is(err, ErrBadFileFormat) {
+		t.Fatalf("got error %v instead of %v", err, ErrBadFileFormat)
+	}
+	assertValue(t, f, "a", "2")
+}
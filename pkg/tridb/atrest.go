@@ -0,0 +1,119 @@
+package tridb
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Rows encrypted at rest (see WithEncryption) are encoded as: op (1 byte), length of the sealed row (4 bytes)
+// and sealed row (random nonce, binary-encoded row encrypted with AES-GCM and authentication tag).
+//
+// Merge rows have their own op so that compaction finds the rows to collapse without decrypting all rows.
+const (
+	opEncrypted         byte = '!'
+	opEncryptedMerge    byte = '?'
+	encryptedHeaderSize      = 1 + 4
+	encryptedOverhead        = 12 + 16 // AES-GCM nonce and tag
+)
+
+// ErrEncryptedFile is returned when opening a file encrypted at rest without encryption key (see WithEncryption).
+var ErrEncryptedFile = errors.New("file is encrypted")
+
+// encryptedFormat is the binary format with rows encrypted at rest.
+// Rows that aren't encrypted (written before encryption was enabled) are decoded as binary rows.
+type encryptedFormat struct{ aead cipher.AEAD }
+
+func newEncryptedFormat(key []byte) (encryptedFormat, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return encryptedFormat{}, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return encryptedFormat{aead: aead}, nil
+}
+
+func (encryptedFormat) Name() string { return "binary-encrypted" }
+
+func (format encryptedFormat) Encode(row *Row) ([]byte, error) {
+	encoded, err := row.Encode()
+	if err != nil {
+		return nil, err
+	}
+	sealed := sealValue(format.aead, encoded, nil)
+	if len(sealed) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d", ErrValueTooLong, len(row.Value))
+	}
+	op := opEncrypted
+	if row.IsMerge {
+		op = opEncryptedMerge
+	}
+	out := make([]byte, 0, encryptedHeaderSize+len(sealed))
+	out = append(out, op)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(sealed)))
+	return append(out, sealed...), nil
+}
+
+func (format encryptedFormat) DecodeFrom(r io.Reader, row *Row) (int, error) {
+	header := [encryptedHeaderSize]byte{}
+	n, err := io.ReadFull(r, header[:1])
+	if err != nil {
+		return n, fmt.Errorf("read header: %w", err)
+	}
+	if !isEncryptedOp(header[0]) {
+		m, err := row.DecodeFrom(io.MultiReader(bytes.NewReader(header[:1]), r))
+		return m, err
+	}
+	m, err := io.ReadFull(r, header[1:])
+	n += m
+	if err != nil {
+		return n, fmt.Errorf("read header: %w", orUnexpectedEOF(err))
+	}
+	sealed, m, err := readFull(r, int(binary.BigEndian.Uint32(header[1:])))
+	n += m
+	if err != nil {
+		return n, fmt.Errorf("read sealed row: %w", orUnexpectedEOF(err))
+	}
+	encoded, err := openValue(format.aead, sealed, nil)
+	if err != nil {
+		return n, fmt.Errorf("decrypt row: %w", err)
+	}
+	decoded := Row{}
+	if err := decoded.decodeInPlace(encoded); err != nil {
+		return n, fmt.Errorf("decode decrypted row: %w", err)
+	}
+	*row = decoded
+	return n, nil
+}
+
+func isEncryptedOp(op byte) bool { return op == opEncrypted || op == opEncryptedMerge }
+
+// orUnexpectedEOF turns io.EOF into io.ErrUnexpectedEOF (for reads in the middle of a row).
+func orUnexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// isBinaryFormat reports whether the format encodes rows and batch frame headers in binary.
+func isBinaryFormat(format Format) bool {
+	_, encrypted := format.(encryptedFormat)
+	_, binary := format.(binaryFormat)
+	return binary || encrypted
+}
+
+// encryptEncodedRow re-encodes a row written before encryption was enabled (see File.Compact).
+func (f *File) encryptEncodedRow(encodedRow []byte) ([]byte, error) {
+	if _, ok := f.format.(encryptedFormat); !ok || isEncryptedOp(encodedRow[0]) {
+		return encodedRow, nil
+	}
+	row := &Row{}
+	if _, err := row.DecodeFrom(bytes.NewReader(encodedRow)); err != nil {
+		return nil, fmt.Errorf("decode row: %w", err)
+	}
+	return f.format.Encode(row)
+}
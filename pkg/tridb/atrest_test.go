@@ -0,0 +1,80 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptionAtRest(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	key := bytes.Repeat([]byte{7}, 32)
+	assertContains := func(content string, want bool) {
+		t.Helper()
+		raw, err := os.ReadFile(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(raw, []byte(content)) != want {
+			t.Fatalf("got %q in file: %v instead of %v", content, !want, want)
+		}
+	}
+
+	// Rows written before encryption is enabled are encrypted on compaction.
+	f, err := Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "plain-key", "plain-value")
+	f.Close()
+	f, err = Open(fpath, 10, WithEncryption(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { f.Close() }()
+	mustSet(t, f, "secret-key", "secret-value")
+	b := f.Batch()
+	b.Set([]byte("batch-key"), []byte("batch-value"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	assertContains("secret-value", false)
+	assertContains("batch-value", false)
+	assertContains("plain-value", true)
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertContains("plain-value", false)
+	f.Close()
+
+	if _, err := Open(fpath, 10); !errors.Is(err, ErrEncryptedFile) {
+		t.Fatalf("got error %v instead of %v", err, ErrEncryptedFile)
+	}
+	if _, err := Open(fpath, 10, WithEncryption(bytes.Repeat([]byte{8}, 32))); err == nil {
+		t.Fatal("expected error with the wrong key")
+	}
+	f, err = Open(fpath, 10, WithEncryption(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "plain-key", "plain-value")
+	assertValue(t, f, "secret-key", "secret-value")
+	assertValue(t, f, "batch-key", "batch-value")
+
+	// Merge rows are collapsed by compaction.
+	f.SetMergeOperator(appendMerge)
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Merge([]byte("secret-key"), []byte("+1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	f.SetMergeOperator(nil)
+	assertValue(t, f, "secret-key", "secret-value+1")
+}
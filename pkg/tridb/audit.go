@@ -0,0 +1,118 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// SetActor records the given actor (for example, a user ID) in the rows staged afterwards,
+// so that History can tell who changed a key. Actors are limited to MaxActorLength bytes.
+func (w *Writer) SetActor(actor string) {
+	if len(actor) > MaxActorLength && w.err == nil {
+		w.err = fmt.Errorf("actor too long: %d", len(actor))
+	}
+	w.actor = actor
+}
+
+// Change is a write of a key retained in the file (see Reader.History).
+type Change struct {
+	Offset    int       // Offset of the row in the file.
+	Time      time.Time // Write time (zero if unknown, see WithTimestamps).
+	Actor     string    // Who wrote the row (empty if unknown, see Writer.SetActor).
+	IsDeleted bool
+	IsAlias   bool   // The row made the key an alias (see Writer.Alias).
+	IsMerge   bool   // The value is a merge operand (see Writer.Merge).
+	Value     []byte // Value as written (target key for aliases, nil if encrypted with a destroyed key).
+}
+
+// History calls do for each write of the given key retained in the file (see Reader.History).
+func (f *File) History(key []byte, do func(c Change) error) error {
+	return f.Read(func(r *Reader) error {
+		changes, err := r.History(key)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if err := do(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// History returns the writes of the given key retained in the file, from the oldest to the latest.
+// Compactions only retain the latest write of each key, unless configured otherwise (see WithKeepVersions and WithHistoryRetention).
+//
+// Note: History scans the whole file, it is meant for admin tooling, not hot paths.
+func (r *Reader) History(key []byte) ([]Change, error) {
+	r.checkDeadline()
+	f := r.f
+	var changes []Change
+	src := bufio.NewReader(io.NewSectionReader(r.ra, 0, int64(r.size)))
+	_, _, err := f.scanRows(src, 0, -1, func(row *Row, p fidx.Position) {
+		if !bytes.Equal(row.Key, key) {
+			return
+		}
+		if row.valueRef.Size() != 0 && f.resolveValueRef(r.ra, row) != nil {
+			row.Value = nil // see WithValueDedup
+		}
+		c := Change{Offset: p.Offset(), Actor: row.Actor, IsDeleted: row.IsDeleted, IsAlias: row.IsAlias, IsMerge: row.IsMerge, Value: bytes.Clone(row.Value)}
+		if row.Timestamp != 0 {
+			c.Time = time.Unix(0, row.Timestamp)
+		}
+		if row.IsSealed || row.Compression != NoCompression {
+			c.Value, _ = f.plainValue(row)
+		}
+		changes = append(changes, c)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan rows: %w", err)
+	}
+	return changes, nil
+}
+
+// WalkDeleted calls do for each key starting with the given prefix whose latest row retained in the file is a delete,
+// in lexicographical order, so that accidental deletions can be audited and restored (see History) before being compacted away.
+// Walking stops when do returns an error, the error is then returned (unless it is ErrBreak).
+// It reports the number of keys visited (do calls).
+//
+// Note: like History, WalkDeleted scans the whole file.
+func (r *Reader) WalkDeleted(prefix []byte, do func(key []byte) error) (int, error) {
+	r.checkDeadline()
+	deleted := map[string]bool{} // by key, whether its latest row is a delete
+	src := bufio.NewReader(io.NewSectionReader(r.ra, 0, int64(r.size)))
+	_, _, err := r.f.scanRows(src, 0, -1, func(row *Row, p fidx.Position) {
+		if bytes.HasPrefix(row.Key, prefix) && !bytes.HasPrefix(row.Key, []byte(ReservedPrefix)) {
+			deleted[string(row.Key)] = row.IsDeleted
+		}
+	})
+	if err != nil {
+		return 0, fmt.Errorf("scan rows: %w", err)
+	}
+	keys := make([]string, 0, len(deleted))
+	for key, isDeleted := range deleted {
+		if isDeleted {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	visited := 0
+	for _, key := range keys {
+		visited++
+		if err := do([]byte(key)); errors.Is(err, ErrBreak) {
+			break
+		} else if err != nil {
+			return visited, err
+		}
+	}
+	return visited, nil
+}
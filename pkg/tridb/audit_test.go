@@ -0,0 +1,86 @@
+package tridb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	f := openTestFile(t, WithFormat(TextEncoding))
+	for _, actor := range []string{"alice", "bob"} {
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			w.SetActor(actor)
+			w.Set([]byte("a"), []byte(actor+"'s value"))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustSet(t, f, "b", "other key")
+	b := f.Batch()
+	b.SetActor("carol")
+	b.Delete([]byte("a"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Change
+	if err := f.History([]byte("a"), func(c Change) error { got = append(got, c); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Actor != "alice" || string(got[1].Value) != "bob's value" || got[2].Actor != "carol" || !got[2].IsDeleted {
+		t.Fatalf("unexpected history: %+v", got)
+	}
+	if got[0].Time.IsZero() || got[1].Offset <= got[0].Offset {
+		t.Fatalf("unexpected history: %+v", got)
+	}
+}
+
+func TestWalkDeleted(t *testing.T) {
+	f := openTestFile(t)
+	for _, key := range []string{"user/c", "user/a", "user/b", "other"} {
+		mustSet(t, f, key, "v")
+	}
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("user/c"))
+		w.Delete([]byte("user/a"))
+		w.Delete([]byte("user/b"))
+		w.Delete([]byte("other"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "user/b", "restored")
+
+	_ = f.Read(func(r *Reader) error {
+		var got []string
+		n, err := r.WalkDeleted([]byte("user/"), func(key []byte) error {
+			got = append(got, string(key))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 || strings.Join(got, ",") != "user/a,user/c" {
+			t.Fatalf("got %d deleted keys: %q", n, got)
+		}
+		n, err = r.WalkDeleted(nil, func(key []byte) error { return ErrBreak })
+		if n != 1 || err != nil {
+			t.Fatalf("got %d keys and error %v after breaking", n, err)
+		}
+		return nil
+	})
+
+	// Tombstones are dropped by compactions
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Read(func(r *Reader) error {
+		if n, err := r.WalkDeleted(nil, func(key []byte) error { return nil }); n != 0 || err != nil {
+			t.Fatalf("got %d deleted keys (%v) after compaction", n, err)
+		}
+		return nil
+	})
+}
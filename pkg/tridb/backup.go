@@ -0,0 +1,38 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrBackupOffset is returned when an incremental backup starts after the end of the file
+// (for example, because the file was compacted since the previous backup).
+var ErrBackupOffset = errors.New("invalid backup offset")
+
+// BackupSince copies the rows appended after the given offset to dst and reports the offset to use for
+// the next incremental backup. A full backup is made with offset 0.
+//
+// The lock is only held to get the current end of the file, rows are copied from a dedicated read handle.
+// Offsets are reset by compactions, a full backup (or a new archive base) is then required.
+//
+// For log shipping, each increment can be archived as a segment (see ArchiveSegmentName and RestoreToTime).
+func (f *File) BackupSince(offset int, dst io.Writer) (int, error) {
+	f.mu.RLock()
+	end := f.woffset
+	h, err := f.opts.Storage(f.fpath, StorageReadOnly)
+	f.mu.RUnlock()
+	if err != nil {
+		return offset, fmt.Errorf("open read handle: %w", err)
+	}
+	defer h.Close()
+	if offset < 0 || offset > end {
+		return offset, fmt.Errorf("%w: %d (file size is %d)", ErrBackupOffset, offset, end)
+	}
+
+	n, err := f.copyThrottled(dst, io.NewSectionReader(h, int64(offset), int64(end-offset)))
+	if err != nil {
+		return offset + n, fmt.Errorf("copy rows: %w", err)
+	}
+	return end, nil
+}
@@ -0,0 +1,310 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Batch frames group rows that must be applied atomically, they are encoded as (with the binary format):
+// op (1 byte), number of rows (4 bytes), length of rows (4 bytes), CRC-32C of rows (4 bytes) and rows.
+//
+// A frame that is truncated (or whose checksum doesn't match) at the end of the file
+// was torn by a crash during commit and is discarded when opening the file.
+const (
+	opBatch         byte = '#'
+	batchHeaderSize      = 1 + 4 + 4 + 4
+)
+
+// ErrBatchClosed is returned when using a batch that was already committed or rolled back.
+var ErrBatchClosed = errors.New("batch closed")
+
+// errTornBatch is returned when decoding a batch frame truncated by the end of the file.
+var errTornBatch = errors.New("torn batch")
+
+// Batch holds write operations that are committed atomically:
+// either all rows are applied (to the file and the keydir) or none.
+//
+// Unlike ReadWrite, the file is not locked while staging operations, only during Commit.
+type Batch struct {
+	Writer
+	f      *File
+	closed bool
+}
+
+// Batch returns a new empty batch.
+func (f *File) Batch() *Batch {
+	return &Batch{f: f, Writer: *f.newWriter()}
+}
+
+// Rollback discards the staged operations.
+func (b *Batch) Rollback() error {
+	if b.closed {
+		return ErrBatchClosed
+	}
+	b.closed, b.rows, b.pendingBytes = true, nil, 0
+	return nil
+}
+
+// Commit writes all staged rows in a single batch frame and applies them to the keydir.
+//
+// If the frame cannot be written and synced, the file is truncated back to its previous size,
+// the keydir is left untouched and the error is returned.
+func (b *Batch) Commit() (err error) {
+	if b.closed {
+		return ErrBatchClosed
+	}
+	b.closed = true
+	f := b.f
+	durable := 0 // commit that must be synced before returning (see SyncGroup)
+	defer func() {
+		if err == nil && durable > 0 {
+			err = f.waitDurable(durable)
+		}
+	}()
+	waitStart := time.Now()
+	f.freeze.RLock()
+	defer f.freeze.RUnlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lockWaited(true, waitStart)
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	if b.err != nil {
+		return abort(b.err)
+	}
+	if err := f.resolveDeferred(&b.Writer); err != nil {
+		return abort(err)
+	}
+	if f.opts.CoalesceWrites {
+		b.rows = f.coalesceRows(b.rows)
+	}
+	if f.opts.PreCommitHook != nil {
+		err := f.opts.PreCommitHook(&b.Writer)
+		if err != nil {
+			return abort(fmt.Errorf("pre-commit hook: %w", err))
+		}
+	}
+	if err := f.checkConditions(&b.Writer); err != nil {
+		return abort(err)
+	}
+	if err := f.checkMergeOperator(b.rows); err != nil {
+		return abort(err)
+	}
+	if err := f.checkFrozen(b.rows); err != nil {
+		return abort(err)
+	}
+	if err := f.checkOps(&b.Writer); err != nil {
+		return abort(err)
+	}
+	if len(b.ops) > 0 {
+		return abort(errCustomOpsInBatch)
+	}
+	if err := f.collapseMerges(b.rows); err != nil {
+		return abort(err)
+	}
+
+	rows := f.changedRows(b.rows)
+	if len(rows) == 0 {
+		return nil
+	}
+	quotaDeltas, err := f.checkQuotas(rows)
+	if err != nil {
+		return abort(err)
+	}
+	if err := f.checkFileSize(&b.Writer, rows, batchHeaderSize+b.pendingBytes); err != nil {
+		return abort(err)
+	}
+	durable, err = f.writeBatch(rows, quotaDeltas, b.deferSync)
+	return err
+}
+
+// changedRows returns the given rows without those that wouldn't change the database state
+// (if enabled, see WithSkipUnchangedWrites).
+func (f *File) changedRows(rows []*Row) []*Row {
+	if !f.opts.SkipUnchangedWrites {
+		return rows
+	}
+	changed := make([]*Row, 0, len(rows))
+	for _, row := range rows {
+		if !f.isUnchanged(row) {
+			changed = append(changed, row)
+		}
+	}
+	return changed
+}
+
+// writeBatch writes and syncs the given rows in a single batch frame and applies them to the keydir.
+// It reports the commit that must be synced before returning (see SyncGroup).
+// It must be called with the write lock held.
+// If deferSync is set, the frame is not synced (see Writer.NoSync).
+func (f *File) writeBatch(rows []*Row, quotaDeltas []int, deferSync bool) (durable int, err error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	frame, err := encodeBatch(f.format, rows)
+	if err != nil {
+		return 0, fmt.Errorf("encode batch: %w", err)
+	}
+	startOffset := f.woffset
+	_, err = f.store.Append(frame.encoded)
+	if err == nil && deferSync {
+		f.dirty = true
+	} else if err == nil {
+		err = f.sync()
+	}
+	if err != nil {
+		err = fmt.Errorf("write batch: %w", err)
+		if truncErr := f.store.Truncate(int64(startOffset)); truncErr != nil {
+			// The torn frame will be discarded on next open, but further writes would follow it.
+			f.fail(fmt.Errorf("%w: %w: %w", ErrFileCorruption, err, truncErr))
+		}
+		return 0, err
+	}
+
+	// Update memstate
+	f.tail.Write(frame.encoded)
+	offset := startOffset + frame.headerSize
+	for i, row := range rows {
+		f.applyRow(row, fidx.Position{offset, frame.sizes[i]}, f.idx, f.sys)
+		offset += frame.sizes[i]
+	}
+	f.woffset = offset
+	f.updateMapping()
+	f.numRows += len(rows)
+	f.applyQuotas(quotaDeltas)
+	f.commits++
+	f.appended.notify()
+	if f.opts.Metrics != nil {
+		f.opts.Metrics.Written(len(rows), f.woffset-startOffset)
+	}
+	if f.opts.Sync == SyncGroup && !deferSync {
+		durable = f.commits
+	}
+	f.notify(rows)
+	f.afterCommit(rows)
+	return durable, f.record(rows)
+}
+
+// batchFrame is an encoded batch frame.
+type batchFrame struct {
+	encoded    []byte
+	headerSize int
+	sizes      []int // encoded size of each row
+}
+
+// encodeBatch returns the batch frame holding the given rows.
+//
+// With text formats, the frame header is a line: "#<number of rows> <length of rows> <hex CRC-32C>".
+func encodeBatch(format Format, rows []*Row) (*batchFrame, error) {
+	frame := &batchFrame{sizes: make([]int, len(rows))}
+	var body []byte
+	for i, row := range rows {
+		encoded, err := format.Encode(row)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, encoded...)
+		frame.sizes[i] = len(encoded)
+	}
+	if len(rows) > math.MaxUint32 || len(body) > math.MaxUint32 {
+		return nil, fmt.Errorf("batch too large: %d rows (%d bytes)", len(rows), len(body))
+	}
+	checksum := crc32.Checksum(body, castagnoli)
+	if isBinaryFormat(format) {
+		frame.encoded = append(frame.encoded, opBatch)
+		frame.encoded = binary.BigEndian.AppendUint32(frame.encoded, uint32(len(rows)))
+		frame.encoded = binary.BigEndian.AppendUint32(frame.encoded, uint32(len(body)))
+		frame.encoded = binary.BigEndian.AppendUint32(frame.encoded, checksum)
+	} else {
+		frame.encoded = fmt.Appendf(frame.encoded, "%c%d %d %08x\n", opBatch, len(rows), len(body), checksum)
+	}
+	frame.headerSize = len(frame.encoded)
+	frame.encoded = append(frame.encoded, body...)
+	return frame, nil
+}
+
+// batchRow is a row decoded from a batch frame, with its position relative to the start of the frame.
+type batchRow struct {
+	row      Row
+	position fidx.Position
+}
+
+// decodeBatchFrom decodes a batch frame from the given reader.
+// It reports the decoded rows, the number of bytes read and an eventual error
+// (errTornBatch if the frame is the incomplete last one of the reader).
+func decodeBatchFrom(format Format, r *bufio.Reader) ([]batchRow, int, error) {
+	numRows, length, checksum, n, err := decodeBatchHeader(format, r)
+	if err != nil {
+		return nil, n, err
+	}
+	body, m, err := readFull(r, int(length))
+	headerSize := n
+	n += m
+	if errors.Is(err, ErrRowTooLarge) {
+		return nil, n, err
+	} else if err != nil {
+		return nil, n, fmt.Errorf("%w: read rows: %w", errTornBatch, err)
+	}
+	if crc32.Checksum(body, castagnoli) != checksum {
+		if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+			return nil, n, fmt.Errorf("%w: checksum mismatch", errTornBatch)
+		}
+		return nil, n, errors.New("checksum mismatch")
+	}
+
+	if int(numRows) > len(body)/tombstoneHeaderSize {
+		return nil, n, fmt.Errorf("%d rows can't fit in %d bytes", numRows, len(body)) // rows take at least 2 bytes
+	}
+	rows := make([]batchRow, numRows)
+	src := bytes.NewReader(body)
+	offset := headerSize
+	for i := range rows {
+		size, err := format.DecodeFrom(src, &rows[i].row)
+		if err != nil {
+			return nil, n, fmt.Errorf("decode row %d: %w", i, err)
+		}
+		rows[i].position = fidx.Position{offset, size}
+		offset += size
+	}
+	if src.Len() != 0 {
+		return nil, n, fmt.Errorf("%d trailing bytes after %d rows", src.Len(), numRows)
+	}
+	return rows, n, nil
+}
+
+// decodeBatchHeader decodes a batch frame header (see encodeBatch).
+// It reports the number of rows, their length and checksum, and the number of bytes read.
+func decodeBatchHeader(format Format, r *bufio.Reader) (numRows, length, checksum uint32, n int, err error) {
+	if isBinaryFormat(format) {
+		header := [batchHeaderSize]byte{}
+		n, err = io.ReadFull(r, header[:])
+		if err != nil {
+			return 0, 0, 0, n, fmt.Errorf("%w: read header: %w", errTornBatch, err)
+		}
+		return binary.BigEndian.Uint32(header[1:]), binary.BigEndian.Uint32(header[5:]), binary.BigEndian.Uint32(header[9:]), n, nil
+	}
+
+	tr := &textReader{r: r}
+	line, err := tr.readUntil('\n', 2*(len(strconv.Itoa(math.MaxUint32))+1)+8+1)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, 0, 0, tr.n, fmt.Errorf("%w: read header: %w", errTornBatch, err)
+	} else if err != nil {
+		return 0, 0, 0, tr.n, fmt.Errorf("read header: %w", err)
+	}
+	_, err = fmt.Sscanf(string(line), "#%d %d %08x", &numRows, &length, &checksum)
+	if err != nil {
+		return 0, 0, 0, tr.n, fmt.Errorf("parse header %q: %w", line, err)
+	}
+	return numRows, length, checksum, tr.n, nil
+}
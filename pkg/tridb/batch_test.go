@@ -0,0 +1,105 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { f.Close() }()
+
+	b := f.Batch()
+	b.Set([]byte("a"), []byte("1"))
+	b.Set([]byte("b"), []byte("2"))
+	b.Delete([]byte("a"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(); !errors.Is(err, ErrBatchClosed) {
+		t.Fatalf("got error %v instead of %v", err, ErrBatchClosed)
+	}
+	assertValue(t, f, "a", "")
+	assertValue(t, f, "b", "2")
+	if got := f.Seq(); got != 3 {
+		t.Fatalf("got seq %d instead of 3", got)
+	}
+
+	b = f.Batch()
+	b.Set([]byte("c"), []byte("3"))
+	if err := b.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "c", "")
+
+	// Simulate a crash in the middle of a commit by appending a truncated frame.
+	stat, err := os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := encodeBatch(BinaryEncoding, []*Row{{Key: []byte("d"), Value: []byte("4")}, {Key: []byte("e"), Value: []byte("5")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(frame.encoded[:len(frame.encoded)-1]); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+
+	// The torn batch is discarded when re-opening the file.
+	f.Close()
+	if f, err = Open(fpath, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := f.Recoveries(); len(got) != 1 || got[0].Offset != int(stat.Size()) || got[0].Action != RecoveryTruncate {
+		t.Fatalf("got recoveries %+v", got)
+	}
+	assertValue(t, f, "b", "2")
+	assertValue(t, f, "d", "")
+	mustSet(t, f, "e", "5")
+	f.Close()
+	if f, err = Open(fpath, 1); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "e", "5")
+
+	// History can be replayed in the middle of a batch.
+	err = f.ReadAt(1, func(r *Reader) error {
+		if !r.Has([]byte("a")) || r.Has([]byte("b")) {
+			t.Fatal("unexpected state at sequence 1")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchChecks(t *testing.T) {
+	f := openTestFile(t)
+	for _, test := range []struct {
+		stage func(b *Batch)
+		want  error
+	}{
+		{func(b *Batch) { b.Merge([]byte("a"), []byte("1")) }, ErrNoMergeOperator},
+		{func(b *Batch) { b.AppendOp(MinCustomOp, nil) }, ErrUnknownOp},
+	} {
+		b := f.Batch()
+		b.Set([]byte("b"), []byte("2"))
+		test.stage(b)
+		if err := b.Commit(); !errors.Is(err, test.want) || !errors.Is(err, ErrTxnAborted) {
+			t.Fatalf("got error %v instead of %v", err, test.want)
+		}
+	}
+	assertValue(t, f, "b", "")
+}
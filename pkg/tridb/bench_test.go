@@ -0,0 +1,132 @@
+package tridb
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const benchNumKeys = 10_000
+
+var benchValue = make([]byte, 100)
+
+func benchKey(i int) []byte { return []byte(fmt.Sprintf("key/%08d", i)) }
+
+// openBenchFile opens a file holding benchNumKeys keys.
+func openBenchFile(b *testing.B, opts ...Option) *File {
+	b.Helper()
+	f, err := Open(filepath.Join(b.TempDir(), "bench.tridb"), benchNumKeys, opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { f.Close() })
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.NoSync()
+		for i := 0; i < benchNumKeys; i++ {
+			w.Set(benchKey(i), benchValue)
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return f
+}
+
+func benchGet(f *File, key []byte) error {
+	return f.Read(func(r *Reader) error {
+		_, err := r.GetExisting(key)
+		return err
+	})
+}
+
+func BenchmarkOpen(b *testing.B) {
+	for _, size := range []int{DefaultBufferSize, 64 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			f := openBenchFile(b)
+			fpath := f.fpath
+			if err := f.Close(); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(f.Stats().FileSize))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				os.Remove(fpath + CleanShutdownFileExtension) // replay the file
+				f, err := Open(fpath, benchNumKeys, WithBufferSizes(size, 0))
+				if err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	f := openBenchFile(b)
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := benchGet(f, benchKey(i%benchNumKeys)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("random", func(b *testing.B) {
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < b.N; i++ {
+			if err := benchGet(f, benchKey(rng.Intn(benchNumKeys))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSet(b *testing.B) {
+	f := openBenchFile(b, WithSync(SyncNever, 0))
+	b.SetBytes(int64(len(benchValue)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			w.Set(benchKey(i%benchNumKeys), benchValue)
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	for _, keydir := range []KeydirType{KeydirHash, KeydirTrie} {
+		b.Run(string(keydir), func(b *testing.B) {
+			f := openBenchFile(b, WithKeydir(keydir))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := f.Read(func(r *Reader) error {
+					_, err := r.Walk([]byte("key/"), func(key []byte) error { return nil })
+					return err
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCompact(b *testing.B) {
+	for _, size := range []int{DefaultBufferSize, 1 << 20} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			f := openBenchFile(b, WithBufferSizes(size, size))
+			b.SetBytes(int64(f.Stats().FileSize))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := f.Compact(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Bucket rows carry their namespace in their key, in the reserved keyspace:
+// the key of a row is "\x00tridb/bucket/" + bucket name + "\x00" + key.
+// Bucket keys are thus indexed separately (see ReservedPrefix) and never collide with other keys.
+const bucketKeyPrefix = "bucket/"
+
+// ErrInvalidBucketName is returned when using a bucket with an empty name or a name containing a null byte.
+var ErrInvalidBucketName = errors.New("invalid bucket name")
+
+// Bucket is a handle on an isolated key namespace of the file.
+// Keys of a bucket are not visible to the readers of the file nor to other buckets.
+//
+// Values of bucket keys are returned as written: read transforms, aliases, expiration,
+// prefix encryption, indexes and watches only apply to the keys of the file.
+type Bucket struct {
+	f      *File
+	name   string
+	prefix []byte // key prefix of the bucket rows
+}
+
+// Bucket returns a handle on the bucket with the given name (buckets don't need to be created).
+func (f *File) Bucket(name string) *Bucket {
+	return &Bucket{f: f, name: name, prefix: reservedKey(bucketKeyPrefix + name + "\x00")}
+}
+
+// Name returns the name of the bucket.
+func (b *Bucket) Name() string { return b.name }
+
+func (b *Bucket) check() error {
+	if b.name == "" || bytes.IndexByte([]byte(b.name), 0) >= 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidBucketName, b.name)
+	}
+	return nil
+}
+
+func (b *Bucket) rowKey(key []byte) []byte { return append(bytes.Clone(b.prefix), key...) }
+
+// Set sets the key of the bucket to the given value.
+func (b *Bucket) Set(key, value []byte) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.f.ReadWrite(func(r *Reader, w *Writer) error {
+		key := b.rowKey(key)
+		if value, ok := w.applySetHooks(key, value); ok {
+			w.stage(&Row{Key: key, Value: value})
+		}
+		return nil
+	})
+}
+
+// Delete deletes the key of the bucket.
+func (b *Bucket) Delete(key []byte) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.stage(&Row{IsDeleted: true, Key: b.rowKey(key)})
+		return nil
+	})
+}
+
+// Get returns the value of the key of the bucket (or nil if it doesn't exist).
+func (b *Bucket) Get(key []byte) ([]byte, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	var value []byte
+	err := b.f.Read(func(r *Reader) error {
+		rowInfo := r.sys.Get(b.rowKey(key))
+		if rowInfo == nil {
+			return nil
+		}
+		row, err := r.f.readAndDecodeRow(r.ra, rowInfo.Position)
+		if err != nil {
+			return err
+		}
+		value = row.Value
+		return nil
+	})
+	return value, err
+}
+
+// Walk calls do for each key of the bucket starting with the given prefix, in lexicographical order.
+// Walking stops when do returns an error, the error is then returned.
+func (b *Bucket) Walk(prefix []byte, do func(key, value []byte) error) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	start := b.rowKey(prefix)
+	return b.f.Read(func(r *Reader) error {
+		return r.sys.WalkRange(start, fidx.PrefixEnd(start), false, func(rowInfo *fidx.RowInfo) error {
+			row, err := r.f.readAndDecodeRow(r.ra, rowInfo.Position)
+			if err != nil {
+				return err
+			}
+			return do(rowInfo.Key[len(b.prefix):], row.Value)
+		})
+	})
+}
+
+// Count returns the number of keys in the bucket.
+func (b *Bucket) Count() (int, error) {
+	if err := b.check(); err != nil {
+		return 0, err
+	}
+	count := 0
+	err := b.f.Read(func(r *Reader) error {
+		return r.sys.WalkRange(b.prefix, fidx.PrefixEnd(b.prefix), false, func(*fidx.RowInfo) error {
+			count++
+			return nil
+		})
+	})
+	return count, err
+}
@@ -0,0 +1,49 @@
+package tridb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	f := openTestFile(t)
+	users, orders := f.Bucket("users"), f.Bucket("orders")
+	mustSet(t, f, "1", "file")
+	for _, err := range []error{users.Set([]byte("1"), []byte("alice")), users.Set([]byte("2"), []byte("bob")), orders.Set([]byte("1"), []byte("order"))} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := users.Delete([]byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := users.Get([]byte("1")); err != nil || string(v) != "alice" {
+		t.Fatalf("got %q (%v) instead of %q", v, err, "alice")
+	}
+	if v, err := orders.Get([]byte("1")); err != nil || string(v) != "order" {
+		t.Fatalf("got %q (%v) instead of %q", v, err, "order")
+	}
+	assertValue(t, f, "1", "file")
+	_ = f.Read(func(r *Reader) error {
+		if n := r.Count(); n != 1 {
+			t.Fatalf("file has %d keys instead of 1", n)
+		}
+		return nil
+	})
+
+	var keys []string
+	err := users.Walk(nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil || len(keys) != 1 || keys[0] != "1" {
+		t.Fatalf("got keys %q (%v)", keys, err)
+	}
+	if n, err := orders.Count(); err != nil || n != 1 {
+		t.Fatalf("got count %d (%v) instead of 1", n, err)
+	}
+	if err := f.Bucket("in\x00valid").Set(nil, nil); !errors.Is(err, ErrInvalidBucketName) {
+		t.Fatalf("got error %v instead of %v", err, ErrInvalidBucketName)
+	}
+}
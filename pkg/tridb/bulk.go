@@ -0,0 +1,69 @@
+package tridb
+
+import (
+	"fmt"
+	"io"
+)
+
+// DefaultBulkBatchSize is the number of key-value pairs committed together by File.BulkLoad when none is configured.
+const DefaultBulkBatchSize = 10_000
+
+// BulkOptions configures File.BulkLoad.
+type BulkOptions struct {
+	// BatchSize is the number of key-value pairs committed together (defaults to DefaultBulkBatchSize).
+	BatchSize int
+	// Progress is called after each committed batch with the number of key-value pairs loaded so far.
+	Progress func(loaded int)
+}
+
+// BulkLoad sets the key-value pairs read from src in the given format (see File.ExportTo),
+// committing them by batches that are not synced: the file is synced once at the end.
+// It reports the number of loaded key-value pairs.
+//
+// Unlike ImportFrom, the input is streamed (it doesn't have to fit in memory) and the load is not atomic:
+// when it fails (ex: invalid line), the batches committed before are kept, the returned count tells where to resume.
+// Other transactions committed during the load are synced as usual (see WithSync).
+func (f *File) BulkLoad(src io.Reader, format ExportFormat, opts *BulkOptions) (int, error) {
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBulkBatchSize
+	}
+	loaded := 0
+	keys, values := make([][]byte, 0, batchSize), make([][]byte, 0, batchSize)
+	commit := func() error {
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			w.NoSync()
+			for i, key := range keys {
+				w.Set(key, values[i])
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("commit batch after %d key-value pairs: %w", loaded, err)
+		}
+		loaded += len(keys)
+		keys, values = keys[:0], values[:0]
+		if opts.Progress != nil {
+			opts.Progress(loaded)
+		}
+		return nil
+	}
+
+	err := scanExported(src, format, func(line int, key, value []byte) error {
+		keys, values = append(keys, key), append(values, value)
+		if len(keys) < batchSize {
+			return nil
+		}
+		return commit()
+	})
+	if err == nil && len(keys) > 0 {
+		err = commit()
+	}
+	if syncErr := f.Flush(); err == nil {
+		err = syncErr // committed batches are synced even if the load failed
+	}
+	return loaded, err
+}
@@ -0,0 +1,47 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBulkLoad(t *testing.T) {
+	f := openTestFile(t)
+	input := &strings.Builder{}
+	for i := 0; i < 25; i++ {
+		fmt.Fprintf(input, "{\"key\":\"k%02d\",\"value\":\"v%d\"}\n", i, i)
+	}
+	syncs := f.Stats().SyncLatency.Samples
+	var progress []int
+	n, err := f.BulkLoad(strings.NewReader(input.String()), ExportJSONL, &BulkOptions{
+		BatchSize: 10,
+		Progress:  func(loaded int) { progress = append(progress, loaded) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 25 || !reflect.DeepEqual(progress, []int{10, 20, 25}) {
+		t.Fatalf("loaded %d key-value pairs with progress %v", n, progress)
+	}
+	if got := f.Stats().SyncLatency.Samples - syncs; got != 1 {
+		t.Fatalf("got %d syncs instead of 1", got)
+	}
+	assertValue(t, f, "k00", "v0")
+	assertValue(t, f, "k24", "v24")
+
+	// Batches committed before an invalid line are kept.
+	input.Reset()
+	for i := 0; i < 15; i++ {
+		fmt.Fprintf(input, "{\"key\":\"bad%02d\",\"value\":\"v\"}\n", i)
+	}
+	input.WriteString("not json\n")
+	n, err = f.BulkLoad(strings.NewReader(input.String()), ExportJSONL, &BulkOptions{BatchSize: 10})
+	if !errors.Is(err, ErrBadImport) || n != 10 {
+		t.Fatalf("got %d loaded key-value pairs and error %v", n, err)
+	}
+	assertValue(t, f, "bad09", "v")
+	assertValue(t, f, "bad10", "")
+}
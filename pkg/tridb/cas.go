@@ -0,0 +1,113 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ejuju/tridb/pkg/tridbid"
+)
+
+// ErrConditionFailed is returned when committing a transaction whose conditional write doesn't hold.
+var ErrConditionFailed = errors.New("write condition failed")
+
+// writeCondition is evaluated against the committed state of the file on commit.
+type writeCondition struct {
+	key      []byte
+	expected []byte // expected current value (if the key must exist)
+	absent   bool   // whether the key must not exist
+}
+
+// SetIfAbsent is like Set but the transaction fails with ErrConditionFailed on commit if the key exists.
+func (w *Writer) SetIfAbsent(key, value []byte) {
+	if w.checkKey(key) {
+		w.conditions = append(w.conditions, writeCondition{key: key, absent: true})
+		w.Set(key, value)
+	}
+}
+
+// newKeyAttempts is the number of keys generated by SetNew before giving up.
+const newKeyAttempts = 8
+
+// SetNew sets a new key made of the given prefix and a ULID (see tridbid.NewKey) and returns it,
+// keys set with the same prefix thus sort chronologically.
+// The ULID is generated again while the key exists or is staged, and the transaction fails
+// with ErrConditionFailed on commit if it exists then (in batches, existing keys are only checked on commit).
+func (w *Writer) SetNew(prefix, value []byte) []byte {
+	for i := 0; i < newKeyAttempts; i++ {
+		id, err := tridbid.NewULID()
+		if err != nil {
+			if w.err == nil {
+				w.err = fmt.Errorf("generate ID: %w", err)
+			}
+			return nil
+		}
+		key := append(bytes.Clone(prefix), id.String()...)
+		if w.isStaged(key) || (w.committed != nil && w.committed.Has(key)) {
+			continue
+		}
+		w.SetIfAbsent(key, value)
+		return key
+	}
+	if w.err == nil {
+		w.err = fmt.Errorf("%w: no new key with prefix %q after %d attempts", ErrConditionFailed, prefix, newKeyAttempts)
+	}
+	return nil
+}
+
+// isStaged reports whether a row of the given key is staged.
+func (w *Writer) isStaged(key []byte) bool {
+	for _, row := range w.rows {
+		if bytes.Equal(row.Key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetIf is like Set but the transaction fails with ErrConditionFailed on commit
+// unless the key exists with the expected value.
+func (w *Writer) SetIf(key, value, expected []byte) {
+	if w.checkKey(key) {
+		w.conditions = append(w.conditions, writeCondition{key: key, expected: expected})
+		w.Set(key, value)
+	}
+}
+
+// DeleteIf is like Delete but the transaction fails with ErrConditionFailed on commit
+// unless the key exists with the expected value.
+func (w *Writer) DeleteIf(key, expected []byte) {
+	if w.checkKey(key) {
+		w.conditions = append(w.conditions, writeCondition{key: key, expected: expected})
+		w.Delete(key)
+	}
+}
+
+// checkConditions evaluates the conditions of the given writer against the committed state (not the staged rows).
+// It must be called with the write lock held.
+func (f *File) checkConditions(w *Writer) error {
+	if len(w.conditions) == 0 {
+		return nil
+	}
+	r := f.newReader()
+	for _, cond := range w.conditions {
+		rowInfo := r.get(cond.key)
+		if cond.absent {
+			if rowInfo != nil {
+				return fmt.Errorf("%w: %q exists", ErrConditionFailed, cond.key)
+			}
+			continue
+		}
+		if rowInfo == nil {
+			return fmt.Errorf("%w: %q doesn't exist", ErrConditionFailed, cond.key)
+		}
+		current, err := r.readValue(cond.key, rowInfo.Position)
+		if err != nil {
+			return fmt.Errorf("read %q: %w", cond.key, err)
+		}
+		if !bytes.Equal(current, cond.expected) {
+			return fmt.Errorf("%w: %q has changed", ErrConditionFailed, cond.key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	f := openTestFile(t)
+	commit := func(stage func(w *Writer)) error {
+		return f.ReadWrite(func(r *Reader, w *Writer) error {
+			stage(w)
+			return nil
+		})
+	}
+	if err := commit(func(w *Writer) { w.SetIfAbsent([]byte("a"), []byte("1")) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := commit(func(w *Writer) { w.SetIfAbsent([]byte("a"), []byte("2")) }); !errors.Is(err, ErrConditionFailed) {
+		t.Fatalf("got error %v instead of %v", err, ErrConditionFailed)
+	}
+	if err := commit(func(w *Writer) { w.SetIf([]byte("a"), []byte("2"), []byte("1")) }); err != nil {
+		t.Fatal(err)
+	}
+	err := commit(func(w *Writer) {
+		w.Set([]byte("b"), []byte("aborted"))
+		w.DeleteIf([]byte("a"), []byte("1"))
+	})
+	if !errors.Is(err, ErrConditionFailed) {
+		t.Fatalf("got error %v instead of %v", err, ErrConditionFailed)
+	}
+	assertValue(t, f, "a", "2")
+	assertValue(t, f, "b", "")
+
+	// Optimistic concurrency: only one of the concurrent batches wins.
+	wg, wins := sync.WaitGroup{}, make(chan struct{}, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := f.Batch()
+			b.SetIf([]byte("a"), []byte("3"), []byte("2"))
+			if b.Commit() == nil {
+				wins <- struct{}{}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(wins) != 1 {
+		t.Fatalf("%d batches committed instead of 1", len(wins))
+	}
+}
+
+func TestSetNew(t *testing.T) {
+	f := openTestFile(t, WithKeydir(KeydirTrie))
+	var keys [][]byte
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		for i := 0; i < 100; i++ {
+			keys = append(keys, w.SetNew([]byte("events/"), []byte{byte(i)}))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := f.Batch()
+	keys = append(keys, b.SetNew([]byte("events/"), []byte{100}))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys are walked in the order they were generated
+	i := 0
+	_ = f.Read(func(r *Reader) error {
+		_, err := r.WalkWithValue([]byte("events/"), func(key, value []byte) error {
+			if !bytes.Equal(key, keys[i]) || value[0] != byte(i) {
+				t.Fatalf("got %q = %d instead of %q = %d", key, value[0], keys[i], i)
+			}
+			i++
+			return nil
+		})
+		return err
+	})
+	if i != len(keys) {
+		t.Fatalf("walked %d keys instead of %d", i, len(keys))
+	}
+}
@@ -0,0 +1,51 @@
+package tridb
+
+import "fmt"
+
+// RowPosition is the position of a row in the file.
+type RowPosition struct {
+	Offset int // Offset of the row, rows with a larger offset were written later.
+	Size   int // Size of the encoded row.
+}
+
+// Operations of the rows visited by Reader.WalkChronological.
+const (
+	OpSet   = opSet   // The value of the key was set (including aliases, see Writer.Alias).
+	OpMerge = opMerge // An operand was merged into the value of the key (see Writer.Merge).
+)
+
+// WalkChronological calls do for each key in the order keys were created (oldest first),
+// with the position and the operation of the row holding its current value (see Walk for errors).
+// Each row is read from the file to get its operation (and its key, see WithHashedKeys).
+//
+// Note: keys keep their place when they are overwritten, the offset of their row tells when they last changed.
+func (r *Reader) WalkChronological(do func(key []byte, pos RowPosition, op byte) error) error {
+	r.checkDeadline()
+	idx := r.idx
+	for rowInfo := idx.Chronological().Oldest; rowInfo != nil; rowInfo = rowInfo.Next {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		if !r.expired(rowInfo) {
+			row, err := r.f.readAndDecodeRow(r.ra, rowInfo.Position)
+			if err != nil {
+				return fmt.Errorf("read row at offset %d: %w", rowInfo.Position.Offset(), err)
+			}
+			op := OpSet
+			if row.IsMerge {
+				op = OpMerge
+			}
+			if err := do(row.Key, RowPosition{Offset: rowInfo.Position.Offset(), Size: rowInfo.Position.Size()}, op); err != nil {
+				return ignoreBreak(err)
+			}
+		}
+		if r.checkDeadline(); r.idx != idx {
+			// The reader detached from the lock (see WithMaxReadDuration), resume on its snapshot.
+			idx = r.idx
+			if rowInfo = idx.Get(rowInfo.Key); rowInfo == nil {
+				return nil
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package tridb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWalkChronological(t *testing.T) {
+	f := openTestFile(t)
+	f.SetMergeOperator(appendMerge)
+	mustSet(t, f, "b", "1")
+	mustSet(t, f, "a", "2")
+	mustSet(t, f, "c", "3")
+	mustSet(t, f, "b", "4")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("c"))
+		w.Merge([]byte("a"), []byte("5"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	var ops []byte
+	offsets := map[string]int{}
+	err = f.Read(func(r *Reader) error {
+		return r.WalkChronological(func(key []byte, pos RowPosition, op byte) error {
+			keys, ops, offsets[string(key)] = append(keys, string(key)), append(ops, op), pos.Offset
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "a"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got keys %q instead of %q", keys, want)
+	}
+	if want := []byte{OpSet, OpMerge}; !reflect.DeepEqual(ops, want) {
+		t.Fatalf("got ops %q instead of %q", ops, want)
+	}
+	if offsets["a"] <= offsets["b"] {
+		t.Fatalf("got offsets %v, the merge into a was written last", offsets)
+	}
+}
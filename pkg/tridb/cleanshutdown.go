@@ -0,0 +1,145 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+	"github.com/ejuju/tridb/pkg/fsutil"
+)
+
+// CleanShutdownFileExtension is added to the path of a database file to get the path of its clean shutdown marker.
+//
+// The marker is written by Close and holds the keydirs, so that the next Open doesn't replay the whole file.
+// Bytes appended since the last checkpoint (the end of the file when it was opened) are hashed as they are written,
+// Open then only verifies the hash of these bytes (in O(tail)) before trusting the marker.
+// Files with hashed keys (see WithHashedKeys) or encrypted at rest (see WithEncryption) don't use markers.
+const CleanShutdownFileExtension = ".clean"
+
+// Clean shutdown marker format:
+//
+//	tridb-clean 1 <file size> <number of rows> <checkpoint> <FNV-1a 64 hash of the bytes after the checkpoint> <keydir snapshot length>
+//
+// followed by the keydir snapshot and the reserved keydir snapshot (see fidx.EncodeSnapshot).
+const cleanShutdownHeader = "tridb-clean 1"
+
+// cleanShutdownMarker holds the fields of a clean shutdown marker header.
+type cleanShutdownMarker struct {
+	size, numRows, checkpoint int
+	hash                      uint64
+	snapshotLength            int
+}
+
+func (f *File) usesCleanShutdownMarker() bool {
+	return f.opts.KeySecret == nil && f.opts.KeyCollation == nil && f.opts.EncryptionKey == nil && len(f.opts.CustomOps) == 0
+}
+
+// resetCheckpoint marks the current end of the file as verified.
+func (f *File) resetCheckpoint() {
+	f.checkpoint, f.tail = f.woffset, fnv.New64a()
+}
+
+// writeCleanShutdownMarker atomically writes the clean shutdown marker of the synced file.
+func (f *File) writeCleanShutdownMarker() error {
+	snapshots, idxLength, err := f.encodeKeydirs()
+	if err != nil {
+		return err
+	}
+	content := fmt.Appendf(nil, "%s %d %d %d %016x %d\n", cleanShutdownHeader, f.woffset, f.numRows, f.checkpoint, f.tail.Sum64(), idxLength)
+	err = writeFileAtomic(f.fpath+CleanShutdownFileExtension, append(content, snapshots...))
+	if err != nil {
+		return fmt.Errorf("write clean shutdown marker: %w", err)
+	}
+	return nil
+}
+
+// encodeKeydirs returns the snapshot of the keydir followed by the snapshot of the reserved keydir
+// (see fidx.EncodeSnapshot), and the length of the first one.
+func (f *File) encodeKeydirs() ([]byte, int, error) {
+	snapshots := &bytes.Buffer{}
+	if err := fidx.EncodeSnapshot(snapshots, f.idx); err != nil {
+		return nil, 0, fmt.Errorf("encode keydir: %w", err)
+	}
+	idxLength := snapshots.Len()
+	if err := fidx.EncodeSnapshot(snapshots, f.sys); err != nil {
+		return nil, 0, fmt.Errorf("encode reserved keydir: %w", err)
+	}
+	return snapshots.Bytes(), idxLength, nil
+}
+
+// decodeKeydirs decodes the keydirs encoded by encodeKeydirs, it reports false if they are invalid.
+func (f *File) decodeKeydirs(snapshots []byte, idxLength int) (idx, sys fidx.Keydir, ok bool) {
+	if idxLength > len(snapshots) {
+		return nil, nil, false
+	}
+	idx, sys = f.newKeydir(), fidx.NewTrieIndex()
+	if fidx.DecodeSnapshot(bytes.NewReader(snapshots[:idxLength]), idx) != nil {
+		return nil, nil, false
+	}
+	if fidx.DecodeSnapshot(bytes.NewReader(snapshots[idxLength:]), sys) != nil {
+		return nil, nil, false
+	}
+	return idx, sys, true
+}
+
+// writeFileAtomic writes the file through a temporary file renamed over it.
+func writeFileAtomic(fpath string, content []byte) error {
+	tmpPath := fpath + ".tmp"
+	err := os.WriteFile(tmpPath, content, 0o666)
+	if err == nil {
+		err = fsutil.Rename(tmpPath, fpath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// loadCleanShutdownMarker loads the keydirs from the clean shutdown marker (if any),
+// it reports false if there is no valid marker for the current content of the file.
+func (f *File) loadCleanShutdownMarker() bool {
+	content, err := os.ReadFile(f.fpath + CleanShutdownFileExtension)
+	if err != nil {
+		return false
+	}
+	header, snapshots, ok := bytes.Cut(content, []byte("\n"))
+	m := cleanShutdownMarker{}
+	if !ok {
+		return false
+	}
+	_, err = fmt.Sscanf(string(header), cleanShutdownHeader+" %d %d %d %016x %d", &m.size, &m.numRows, &m.checkpoint, &m.hash, &m.snapshotLength)
+	if err != nil || m.checkpoint > m.size {
+		return false
+	}
+
+	// Verify the tail written since the last checkpoint.
+	size, err := f.store.Size()
+	if err != nil || size != int64(m.size) {
+		return false
+	}
+	h := fnv.New64a()
+	if _, err := io.Copy(h, io.NewSectionReader(f.store, int64(m.checkpoint), int64(m.size-m.checkpoint))); err != nil || h.Sum64() != m.hash {
+		return false
+	}
+
+	idx, sys, ok := f.decodeKeydirs(snapshots, m.snapshotLength)
+	if !ok {
+		return false
+	}
+	f.idx, f.sys, f.woffset, f.numRows = idx, sys, m.size, m.numRows
+	f.countKeys()
+	return true
+}
+
+// removeCleanShutdownMarker removes the marker before the file is written to (it would be stale otherwise).
+func (f *File) removeCleanShutdownMarker() error {
+	err := os.Remove(f.fpath + CleanShutdownFileExtension)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove clean shutdown marker: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package tridb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanShutdownMarker(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	reopen := func() *File {
+		t.Helper()
+		f, err := Open(fpath, 10, WithFormat(TextEncoding))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	// Replace the first occurrence of old in the file (keeping its size).
+	tamper := func(old, new string) {
+		t.Helper()
+		content, err := os.ReadFile(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(fpath, bytes.Replace(content, []byte(old), []byte(new), 1), 0o666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	hasKey := func(f *File, key string) (ok bool) {
+		_ = f.Read(func(r *Reader) error { ok = r.Has([]byte(key)); return nil })
+		return ok
+	}
+
+	// The first session writes "a", the second one writes "b" after its checkpoint.
+	f := reopen()
+	mustSet(t, f, "a", "1")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f = reopen()
+	if _, err := os.Stat(fpath + CleanShutdownFileExtension); !os.IsNotExist(err) {
+		t.Fatalf("marker should be removed when opening the file for writing: %v", err)
+	}
+	mustSet(t, f, "b", "2")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fpath + CleanShutdownFileExtension); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bytes before the checkpoint are not verified again: the keydir comes from the marker.
+	tamper("1 1 a 1", "1 1 c 1")
+	f = reopen()
+	if !hasKey(f, "a") || hasKey(f, "c") || f.Stats().Rows != 2 {
+		t.Fatal("keydir should be loaded from the marker")
+	}
+	assertValue(t, f, "b", "2")
+	mustSet(t, f, "e", "3")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bytes after the checkpoint are verified: the file is replayed.
+	tamper("1 1 e 3", "1 1 d 3")
+	f = reopen()
+	defer f.Close()
+	if !hasKey(f, "c") || !hasKey(f, "d") || hasKey(f, "a") || hasKey(f, "e") {
+		t.Fatal("file should be replayed when its tail doesn't match the marker")
+	}
+}
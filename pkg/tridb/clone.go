@@ -0,0 +1,84 @@
+package tridb
+
+import (
+	"bufio"
+	"fmt"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Clone writes an independent compacted copy of the file (live rows only) to the given path
+// and opens it with the same options (except that the clone is always writable).
+// Readers are not blocked while the copy is written, writers only while the rows they committed
+// in the meantime are copied (compactions wait for the copy).
+//
+// It fails if a file already exists at the given path.
+func (f *File) Clone(fpath string) (*File, error) {
+	err := f.writeClone(fpath)
+	if err != nil {
+		return nil, err
+	}
+	opts := f.opts
+	opts.ReadOnly = false
+	return OpenWithOptions(fpath, &opts)
+}
+
+// CloneTo is an alias of Clone.
+func (f *File) CloneTo(newPath string) (*File, error) { return f.Clone(newPath) }
+
+// writeClone writes the compacted copy to the storage of the given path (see WithStorage), removing it on failure.
+func (f *File) writeClone(fpath string) (err error) {
+	f.compacting.Lock() // the file isn't replaced while it is copied
+	defer f.compacting.Unlock()
+
+	dst, err := f.opts.Storage(fpath, StorageCreate)
+	if err != nil {
+		return fmt.Errorf("create clone: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			dst.Remove()
+			dst.Close()
+		}
+	}()
+
+	// Copy the rows committed so far, the keydirs are visited in chunks (see writeCompacted)
+	f.mu.RLock()
+	if err := f.Err(); err != nil {
+		f.mu.RUnlock()
+		return err
+	}
+	src := compactionSource{idx: f.idx, sys: f.sys, end: f.woffset, lock: f.mu.RLocker(), now: time.Now().UnixNano()}
+	f.mu.RUnlock()
+	idx, sys := f.newKeydir(), fidx.NewTrieIndex()
+	bufw := bufio.NewWriterSize(storageWriter{dst}, f.opts.WriteBufferSize)
+	offset, err := f.writeCompacted(bufw, src, idx, sys, nil, compactionProgress{}, nil)
+	if err != nil {
+		return err
+	}
+
+	// Block writers to copy the rows committed since
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if offset, _, err = f.copyCommittedRows(bufw, offset, src.end, f.woffset, idx, sys, nil); err != nil {
+		return fmt.Errorf("catch up: %w", err)
+	}
+	if _, err := f.writeCompactedOps(bufw, offset, nil); err != nil {
+		return err
+	}
+	if err := bufw.Flush(); err != nil {
+		return fmt.Errorf("write clone: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		return fmt.Errorf("sync clone: %w", err)
+	}
+	if kr := f.keyring; kr != nil {
+		kr.mu.RLock()
+		defer kr.mu.RUnlock()
+		if err := kr.save(fpath + KeyringFileExtension); err != nil {
+			return fmt.Errorf("copy keyring: %w", err)
+		}
+	}
+	return dst.Close()
+}
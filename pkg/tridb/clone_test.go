@@ -0,0 +1,67 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "a", "2")
+	mustSet(t, f, "b", "3")
+
+	fpath := filepath.Join(t.TempDir(), "clone.tridb")
+	clone, err := f.Clone(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+	if got := clone.Stats().Rows; got != 2 {
+		t.Fatalf("got %d rows instead of 2 (clone should be compacted)", got)
+	}
+
+	// Clone and original are independent
+	mustSet(t, clone, "a", "cloned")
+	assertValue(t, clone, "a", "cloned")
+	assertValue(t, clone, "b", "3")
+	assertValue(t, f, "a", "2")
+
+	if _, err := f.Clone(fpath); err == nil {
+		t.Fatal("expected error when cloning to an existing file")
+	}
+	assertValue(t, clone, "b", "3")
+}
+
+func TestCloneStorage(t *testing.T) {
+	memory, fpath := NewMemoryStorage(), filepath.Join(t.TempDir(), "clone.tridb")
+	errFailing, failing := errors.New("failing storage"), true
+	f := openTestFile(t, WithStorage(func(path string, mode StorageMode) (Storage, error) {
+		s, err := memory(path, mode)
+		if err != nil || path != fpath || !failing {
+			return s, err
+		}
+		return failingStorage{Storage: s, sync: errFailing}, nil
+	}))
+	mustSet(t, f, "a", "1")
+
+	// Failed clones are removed from the storage
+	if _, err := f.Clone(fpath); !errors.Is(err, errFailing) {
+		t.Fatalf("got error %v instead of %v", err, errFailing)
+	}
+	failing = false
+	clone, err := f.Clone(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+	assertValue(t, clone, "a", "1")
+	if _, err := os.Stat(fpath); !os.IsNotExist(err) {
+		t.Fatalf("the clone was written to disk: %v", err)
+	}
+	if _, err := f.Clone(fpath); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("got error %v instead of %v", err, os.ErrExist)
+	}
+}
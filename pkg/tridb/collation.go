@@ -0,0 +1,19 @@
+package tridb
+
+import "bytes"
+
+// CaseFolding is a key collation making lookups case-insensitive (see WithKeyCollation).
+func CaseFolding(key []byte) []byte { return bytes.ToLower(key) }
+
+// collate returns the given user key normalized with the key collation (see WithKeyCollation).
+func (f *File) collate(key []byte) []byte {
+	if f.opts.KeyCollation == nil || key == nil || IsReservedKey(key) {
+		return key
+	}
+	return f.opts.KeyCollation(key)
+}
+
+// sameKey reports whether the given user keys are the same key once collated.
+func (f *File) sameKey(a, b []byte) bool {
+	return bytes.Equal(a, b) || (f.opts.KeyCollation != nil && bytes.Equal(f.collate(a), f.collate(b)))
+}
@@ -0,0 +1,64 @@
+package tridb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyCollation(t *testing.T) {
+	f := openTestFile(t, WithKeyCollation(CaseFolding), WithKeydir(KeydirTrie))
+	mustSet(t, f, "Users/Alice", "1")
+	mustSet(t, f, "users/bob", "2")
+	mustSet(t, f, "USERS/ALICE", "3")
+	assertValue(t, f, "users/alice", "3")
+	assertValue(t, f, "Users/Bob", "2")
+
+	err := f.Read(func(r *Reader) error {
+		var keys []string
+		if _, err := r.Walk([]byte("USERS/"), func(key []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		}); err != nil {
+			return err
+		}
+		if want := []string{"USERS/ALICE", "users/bob"}; !reflect.DeepEqual(keys, want) {
+			t.Fatalf("got keys %q instead of %q", keys, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Rename([]byte("users/bob"), []byte("Users/Bob"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "users/bob", "2")
+	_ = f.Read(func(r *Reader) error {
+		if got := string(r.Latest().Key()); got != "Users/Bob" {
+			t.Fatalf("got latest key %q", got)
+		}
+		return nil
+	})
+}
+
+func TestKeyCollationCountPrefix(t *testing.T) {
+	f := openTestFile(t, WithKeyCollation(CaseFolding), WithKeydir(KeydirTrie))
+	mustSet(t, f, "user:Alice", "1")
+	mustSet(t, f, "User:bob", "2")
+	mustSet(t, f, "other", "3")
+	_ = f.Read(func(r *Reader) error {
+		n, err := r.CountPrefix([]byte("USER:"))
+		if n != 2 || err != nil {
+			t.Fatalf("counted %d keys (%v) instead of 2", n, err)
+		}
+		return nil
+	})
+}
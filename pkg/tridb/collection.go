@@ -0,0 +1,128 @@
+package tridb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Codec encodes the values of a collection (see Collection).
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	ContentType() string // Content type of the encoded values (see Writer.SetWithContentType).
+}
+
+// Codecs of collections.
+var (
+	JSONCodec Codec = jsonCodec{}
+	GobCodec  Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) ContentType() string                { return "application/json" }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+// CollectionIDLength is the number of random bytes of the IDs of inserted values (see Collection.Insert).
+const CollectionIDLength = 16
+
+// Collection stores values of type T under the keys starting with its prefix, followed by their ID.
+type Collection[T any] struct {
+	f      *File
+	prefix string
+	codec  Codec
+}
+
+// NewCollection returns the collection of the values stored under the given prefix,
+// encoded with the given codec (JSONCodec if nil).
+func NewCollection[T any](f *File, prefix string, codec Codec) *Collection[T] {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	return &Collection[T]{f: f, prefix: prefix, codec: codec}
+}
+
+func (c *Collection[T]) key(id string) []byte { return []byte(c.prefix + id) }
+
+// Put sets the value of the given ID.
+func (c *Collection[T]) Put(id string, v T) error {
+	value, err := c.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %q: %w", id, err)
+	}
+	return c.f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetWithContentType(c.key(id), value, c.codec.ContentType())
+		return nil
+	})
+}
+
+// Insert stores the given value under a new random ID (see RandID) and returns the ID.
+func (c *Collection[T]) Insert(v T) (string, error) {
+	rid, err := NewRandID(CollectionIDLength)
+	if err != nil {
+		return "", fmt.Errorf("generate ID: %w", err)
+	}
+	id := string(rid.Hex())
+	return id, c.Put(id, v)
+}
+
+// Get returns the value of the given ID, it reports false if there's none.
+func (c *Collection[T]) Get(id string) (T, bool, error) {
+	var v T
+	var value []byte
+	err := c.f.Read(func(r *Reader) (err error) {
+		value, err = r.GetExisting(c.key(id))
+		return err
+	})
+	if errors.Is(err, ErrKeyNotFound) {
+		return v, false, nil
+	} else if err != nil {
+		return v, false, err
+	}
+	if err := c.codec.Unmarshal(value, &v); err != nil {
+		return v, false, fmt.Errorf("decode %q: %w", id, err)
+	}
+	return v, true, nil
+}
+
+// Delete deletes the value of the given ID.
+func (c *Collection[T]) Delete(id string) error {
+	return c.f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete(c.key(id))
+		return nil
+	})
+}
+
+// Walk calls do with each value whose ID starts with the given prefix, in lexicographical order of IDs
+// (see Reader.Walk for errors).
+func (c *Collection[T]) Walk(prefix string, do func(id string, v T) error) error {
+	return c.f.Read(func(r *Reader) error {
+		_, err := r.WalkWithValue(c.key(prefix), func(key, value []byte) error {
+			id := string(key[len(c.prefix):])
+			var v T
+			if err := c.codec.Unmarshal(value, &v); err != nil {
+				return fmt.Errorf("decode %q: %w", id, err)
+			}
+			return do(id, v)
+		})
+		return err
+	})
+}
@@ -0,0 +1,43 @@
+package tridb
+
+import "testing"
+
+func TestCollection(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+	for _, codec := range []Codec{JSONCodec, GobCodec} {
+		f := openTestFile(t)
+		users := NewCollection[user](f, "user:", codec)
+		if err := users.Put("ann", user{"Ann", 41}); err != nil {
+			t.Fatal(err)
+		}
+		id, err := users.Insert(user{"Bob", 25})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustSet(t, f, "other", "not a user")
+
+		if u, ok, err := users.Get(id); err != nil || !ok || u.Name != "Bob" {
+			t.Fatalf("got %+v (found: %v, error: %v) for the inserted user", u, ok, err)
+		}
+		if _, ok, err := users.Get("missing"); err != nil || ok {
+			t.Fatalf("got found %v (error: %v) for a missing user", ok, err)
+		}
+		got := map[string]user{}
+		err = users.Walk("", func(id string, u user) error {
+			got[id] = u
+			return nil
+		})
+		if err != nil || len(got) != 2 || got["ann"].Age != 41 || got[id].Age != 25 {
+			t.Fatalf("got %v (error: %v) when walking the collection", got, err)
+		}
+		if err := users.Delete("ann"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := users.Get("ann"); err != nil || ok {
+			t.Fatalf("got found %v (error: %v) for a deleted user", ok, err)
+		}
+	}
+}
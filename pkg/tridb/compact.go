@@ -0,0 +1,396 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Number of rows buffered between two compaction stages.
+const compactionBufferSize = 256
+
+// Number of bytes written to the compacting file between two checkpoints (see File.Compact).
+var compactionCheckpointSize = 64 << 20
+
+// compactionJob holds a row going through the compaction pipeline.
+type compactionJob struct {
+	row         *fidx.RowInfo
+	seq         int         // number of source rows visited up to this one
+	dst         fidx.Keydir // keydir in which the row is indexed once written
+	encoded     []byte
+	err         error
+	transformed bool
+	done        chan struct{} // closed once the encoding stage is done with the row
+	dropped     bool          // dropped by the compaction filter (see WithCompactionFilter)
+	valueRow    *Row          // decoded row if its value is deduplicated (see WithValueDedup)
+	digest      valueDigest   // digest of the deduplicated value
+}
+
+// compactionProgress is the position of a compaction in the source and destination files.
+type compactionProgress struct {
+	rows   int // number of source rows visited (in the order of writeCompacted)
+	offset int // size of the destination file
+}
+
+// compactionSource holds the rows copied by writeCompacted.
+type compactionSource struct {
+	idx, sys fidx.Keydir // keydirs of the rows
+	end      int         // size of the file when the source was taken, later rows are skipped
+	lock     sync.Locker // held while visiting the keydirs (nil if they can't change)
+	now      int64       // rows expired at this time (in Unix nanoseconds) are dropped
+}
+
+// writeCompacted writes the rows of the source to w (in chronological order) and indexes them in idx
+// (or sys for keys in the reserved keyspace). It reports the size of the destination file.
+// Rows are written in the given format, or in the format of the file if nil (see WithFormatUpgrade).
+//
+// Only rows of the source before its end offset are written. If the source has a lock, its keydirs
+// are visited in chunks with the lock held, so writers can append rows (after end) in between (see File.Compact).
+//
+// The first rows visited before the given progress are skipped (they were already written to w).
+// If checkpoint is not nil, it is called regularly with the current progress once rows are written.
+//
+// Rows go through a pipeline of three stages connected by bounded channels:
+// read, (re)encode and write. The encoding stage runs on multiple goroutines
+// so CPU-heavy transforms don't serialize with disk I/O.
+func (f *File) writeCompacted(w io.Writer, src compactionSource, idx, sys fidx.Keydir, format Format, resume compactionProgress, checkpoint func(compactionProgress) error) (int, error) {
+	rewrite := f.opts.ReadTransform != nil && f.opts.TransformOnCompact
+	dedup := f.opts.DedupThreshold > 0 && !rewrite // transformed values depend on their key
+	encodeQueue := make(chan *compactionJob, compactionBufferSize)
+	writeQueue := make(chan *compactionJob, compactionBufferSize)
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	// Read stage
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(writeQueue)
+		defer close(encodeQueue)
+		seq := 0
+		for _, keydirs := range [...]struct{ from, to fidx.Keydir }{{src.sys, sys}, {src.idx, idx}} {
+			var last *fidx.RowInfo // last visited row
+			for exhausted := false; !exhausted; {
+				var chunk []*compactionJob
+				if src.lock != nil {
+					src.lock.Lock()
+				}
+				r := f.newReader() // used to drop expired rows
+				r.now = src.now
+				row := keydirs.from.Chronological().Oldest
+				if last != nil {
+					row = last.Next // still linked to the following rows if it was deleted since
+				}
+				for ; row != nil && len(chunk) < compactionBufferSize; row = row.Next {
+					last = row
+					seq++
+					if seq <= resume.rows || row.Position.Offset() >= src.end || keydirs.from.Get(row.Key) != row {
+						continue // already written, written after end or deleted since the previous chunk
+					}
+					if keydirs.to == sys && isSeqKey(row.Key) {
+						continue // written again after the other rows (see writeSeq)
+					}
+					if keydirs.to == idx && r.expired(row) {
+						continue
+					}
+					info := *row // copied since writers may update it once unlocked
+					chunk = append(chunk, &compactionJob{row: &info, seq: seq, dst: keydirs.to})
+				}
+				exhausted = row == nil
+				if src.lock != nil {
+					src.lock.Unlock()
+				}
+				for _, job := range chunk {
+					if !f.readCompactionJob(job, encodeQueue, writeQueue, stop) {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	// Encode stage
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range encodeQueue {
+				if job.dst == idx {
+					// Deduplicated rows reference rows of the source, their values are written again (or referenced, see below).
+					var row *Row
+					job.encoded, row, job.err = f.inlineEncodedRow(f.store, job.encoded, dedup)
+					if job.err != nil {
+						job.err = fmt.Errorf("inline row %q: %w", job.row.Key, job.err)
+					} else if dedup {
+						if digest, ok := f.digestValue(row); ok {
+							job.valueRow, job.digest = row, digest
+						}
+					}
+				}
+				if job.dst == idx && job.err == nil && isMergeOp(job.encoded[0]) {
+					job.encoded, job.err = f.collapseEncodedRow(job.encoded)
+					job.transformed = true
+					if job.err != nil {
+						job.err = fmt.Errorf("collapse row %q: %w", job.row.Key, job.err)
+					}
+				}
+				if f.opts.CompactionFilter != nil && job.dst == idx && job.err == nil {
+					var changed bool
+					job.encoded, job.dropped, changed, job.err = f.filterEncodedRow(job.encoded)
+					if changed {
+						job.transformed, job.valueRow = true, nil // the digest is stale
+					}
+					if job.err != nil {
+						job.err = fmt.Errorf("filter row %q: %w", job.row.Key, job.err)
+					} else if job.dropped {
+						close(job.done)
+						continue
+					}
+				}
+				if rewrite && job.dst == idx && job.err == nil {
+					job.encoded, job.err = f.transformEncodedRow(job.encoded)
+					job.transformed = true
+					if job.err != nil {
+						job.err = fmt.Errorf("transform row %q: %w", job.row.Key, job.err)
+					}
+				} else if f.opts.Compression != NoCompression && job.dst == idx && job.err == nil {
+					var compressed bool
+					job.encoded, compressed, job.err = f.compressEncodedRow(job.encoded)
+					job.transformed = job.transformed || compressed
+					if job.err != nil {
+						job.err = fmt.Errorf("compress row %q: %w", job.row.Key, job.err)
+					}
+				}
+				if job.dst == idx && job.err == nil {
+					job.encoded, job.err = f.encryptEncodedRow(job.encoded)
+				}
+				if format != nil && job.err == nil {
+					job.encoded, job.err = f.reencodeRow(job.encoded, format)
+					if job.err != nil {
+						job.err = fmt.Errorf("upgrade row %q: %w", job.row.Key, job.err)
+					}
+				}
+				close(job.done)
+			}
+		}()
+	}
+
+	// Write stage
+	written, lastCheckpoint := resume.offset, resume.offset
+	var err error
+	if resume.offset == 0 {
+		written, err = f.writeHeader(w, format)
+		lastCheckpoint = written
+	}
+	values := map[valueDigest]fidx.Position{} // rows of the destination holding deduplicated values
+	for job := range writeQueue {
+		<-job.done
+		if job.err != nil {
+			err = job.err
+			break
+		}
+		if job.dropped {
+			continue
+		}
+		encoded, target, referenced := job.encoded, fidx.Position{}, false
+		if job.valueRow != nil {
+			target, referenced = values[job.digest]
+		}
+		if referenced {
+			if encoded, err = f.encodeValueRef(job.valueRow, target, format); err != nil {
+				err = fmt.Errorf("encode row %q: %w", job.row.Key, err)
+				break
+			}
+		}
+		var n int
+		n, err = w.Write(encoded)
+		written += n
+		if err != nil {
+			err = fmt.Errorf("write to new file: %w", err)
+			break
+		}
+		if checkpoint != nil && written-lastCheckpoint >= compactionCheckpointSize {
+			if err = checkpoint(compactionProgress{rows: job.seq, offset: written}); err != nil {
+				err = fmt.Errorf("checkpoint: %w", err)
+				break
+			}
+			lastCheckpoint = written
+		}
+		if job.valueRow != nil && !referenced {
+			values[job.digest] = fidx.Position{written - n, n}
+		}
+		rowInfo := job.dst.Put(job.row.Key, fidx.Position{written - n, n})
+		rowInfo.Timestamp, rowInfo.ValueHash, rowInfo.ExpiresAt = job.row.Timestamp, job.row.ValueHash, job.row.ExpiresAt
+		rowInfo.ContentType = job.row.ContentType
+		if job.transformed {
+			rowInfo.ValueHash = 0 // unknown
+		}
+	}
+	close(stop)
+	for range writeQueue {
+		// Drain remaining jobs so the read stage can exit.
+	}
+	wg.Wait()
+	return written, err
+}
+
+// readCompactionJob reads the row of the given job and queues it to the encoding and write stages of writeCompacted.
+// It reports false if the read stage must stop.
+func (f *File) readCompactionJob(job *compactionJob, encodeQueue, writeQueue chan<- *compactionJob, stop <-chan struct{}) bool {
+	job.encoded, job.done = make([]byte, job.row.Position.Size()), make(chan struct{})
+	_, err := f.store.ReadAt(job.encoded, int64(job.row.Position.Offset()))
+	if err != nil {
+		job.err = fmt.Errorf("read row: %w", err)
+		close(job.done)
+	} else {
+		select {
+		case encodeQueue <- job:
+		case <-stop:
+			return false
+		}
+	}
+	select {
+	case writeQueue <- job:
+	case <-stop:
+		return false
+	}
+	return err == nil
+}
+
+// copyCommittedRows writes the rows of the file in [from, to) (committed during a compaction) to w,
+// whose size is the given offset, and applies them to the given keydirs.
+// It reports the size of w and the number of rows written.
+func (f *File) copyCommittedRows(w io.Writer, offset, from, to int, idx, sys fidx.Keydir, format Format) (int, int, error) {
+	return f.copyRows(w, offset, from, to, idx, sys, format, nil)
+}
+
+// copyRows is like copyCommittedRows but only copies the rows for which keep reports true (all rows if nil).
+// Merge rows are collapsed and deduplicated values inlined since the rows they are based on are not in w.
+func (f *File) copyRows(w io.Writer, offset, from, to int, idx, sys fidx.Keydir, format Format, keep func(row *Row, p fidx.Position) bool) (int, int, error) {
+	if format == nil {
+		format = f.format
+	}
+	var err error
+	numRows := 0
+	src := bufio.NewReaderSize(io.NewSectionReader(f.store, int64(from), int64(to-from)), f.opts.ReadBufferSize)
+	end, _, scanErr := f.scanRows(src, from, -1, func(row *Row, p fidx.Position) {
+		if err != nil || (keep != nil && !keep(row, p)) {
+			return
+		}
+		if row.valueRef.Size() != 0 {
+			if err = f.resolveValueRef(f.store, row); err != nil {
+				return
+			}
+		}
+		if row.IsMerge {
+			var value []byte
+			if value, err = f.mergedValue(f.store, row); err != nil {
+				err = fmt.Errorf("collapse row %q: %w", row.Key, err)
+				return
+			}
+			f.setResolvedValue(row, value)
+		}
+		var encoded []byte
+		if encoded, err = format.Encode(row); err != nil {
+			err = fmt.Errorf("encode row %q: %w", row.Key, err)
+			return
+		}
+		var n int
+		n, err = w.Write(encoded)
+		offset += n
+		if err != nil {
+			err = fmt.Errorf("write to new file: %w", err)
+			return
+		}
+		f.applyRow(row, fidx.Position{offset - n, n}, idx, sys)
+		numRows++
+	})
+	switch {
+	case scanErr != nil:
+		return offset, numRows, scanErr
+	case err != nil:
+		return offset, numRows, err
+	case end != to:
+		return offset, numRows, fmt.Errorf("%w: %d bytes left after the last row", ErrFileCorruption, to-end)
+	}
+	return offset, numRows, nil
+}
+
+// The compaction manifest holds the progress of the compaction of a file of a given size (see File.Compact):
+//
+//	tridb-compaction 1 <source size> <visited rows> <compacting file size>
+const (
+	compactionManifestExtension = CompactingFileExtension + ".manifest"
+	compactionManifestHeader    = "tridb-compaction 1"
+)
+
+// saveCompactionManifest atomically replaces the compaction manifest (in the storage of the file, see WithStorage).
+func (f *File) saveCompactionManifest(sourceSize int, p compactionProgress) error {
+	tmp, err := f.opts.Storage(f.fpath+compactionManifestExtension+".tmp", StorageReadWrite)
+	if err != nil {
+		return err
+	}
+	err = tmp.Truncate(0)
+	if err == nil {
+		_, err = tmp.Append(fmt.Appendf(nil, "%s %d %d %d\n", compactionManifestHeader, sourceSize, p.rows, p.offset))
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Rename(f.fpath + compactionManifestExtension)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// loadCompactionProgress returns the progress of an interrupted compaction of the file (if it can be resumed),
+// the compacting file may hold rows written after the last checkpoint (they are truncated by File.Compact).
+// Otherwise, leftover compaction files are removed.
+// It must be called with the write lock held.
+func (f *File) loadCompactionProgress() (compactionProgress, error) {
+	p, sourceSize := compactionProgress{}, 0
+	content, err := f.readStorage(f.fpath + compactionManifestExtension)
+	if err == nil {
+		_, err = fmt.Sscanf(string(content), compactionManifestHeader+" %d %d %d\n", &sourceSize, &p.rows, &p.offset)
+	}
+	if err == nil && sourceSize == f.woffset {
+		size, err := f.storageSize(f.fpath + CompactingFileExtension)
+		if err == nil && size >= int64(p.offset) {
+			return p, nil
+		}
+	}
+	if err := f.EnsureNoCompactingFile(); err != nil {
+		return compactionProgress{}, fmt.Errorf("ensure no compacting file: %w", err)
+	}
+	return compactionProgress{}, nil
+}
+
+// upgradeFormat returns the format compactions must rewrite the file in (see WithFormatUpgrade),
+// or nil if the file already uses it.
+func (f *File) upgradeFormat() Format {
+	format := f.opts.UpgradeFormat
+	if format == nil || f.opts.EncryptionKey != nil || format.Name() == f.format.Name() {
+		return nil
+	}
+	if format == BinaryEncoding && f.opts.CompactTombstones {
+		format = binaryFormat{compactTombstones: true}
+	}
+	return format
+}
+
+// reencodeRow decodes the row encoded in the format of the file and encodes it in the given format.
+func (f *File) reencodeRow(encodedRow []byte, format Format) ([]byte, error) {
+	row := &Row{}
+	if _, err := f.format.DecodeFrom(bytes.NewReader(encodedRow), row); err != nil {
+		return nil, fmt.Errorf("decode row: %w", err)
+	}
+	return format.Encode(row)
+}
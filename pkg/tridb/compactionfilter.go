@@ -0,0 +1,38 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// CompactionFilter reports whether a compaction keeps the given key-value pair (see WithCompactionFilter),
+// and the value to write instead (nil keeps the value as is).
+// Values of keys whose encryption key was destroyed are nil (see File.Shred).
+type CompactionFilter func(key, value []byte) (keep bool, newValue []byte)
+
+// filterEncodedRow applies the compaction filter to the given row (see File.Compact),
+// it reports whether the row is dropped and whether its value was rewritten.
+func (f *File) filterEncodedRow(encodedRow []byte) ([]byte, bool, bool, error) {
+	row := &Row{}
+	if _, err := f.format.DecodeFrom(bytes.NewReader(encodedRow), row); err != nil {
+		return nil, false, false, fmt.Errorf("decode row: %w", err)
+	}
+	if row.IsAlias {
+		return encodedRow, false, false, nil
+	}
+	value, err := f.plainValue(row)
+	if err != nil && !errors.Is(err, ErrShredded) {
+		return nil, false, false, err
+	}
+	keep, newValue := f.opts.CompactionFilter(row.Key, value)
+	if !keep {
+		return nil, true, false, nil
+	}
+	if newValue == nil {
+		return encodedRow, false, false, nil
+	}
+	f.setResolvedValue(row, newValue)
+	encoded, err := f.format.Encode(row)
+	return encoded, false, true, err
+}
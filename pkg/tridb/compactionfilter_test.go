@@ -0,0 +1,30 @@
+package tridb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompactionFilter(t *testing.T) {
+	f := openTestFile(t, WithCompactionFilter(func(key, value []byte) (bool, []byte) {
+		switch {
+		case bytes.HasPrefix(key, []byte("session/")):
+			return false, nil
+		case bytes.Equal(value, []byte("v1")):
+			return true, []byte("v2")
+		}
+		return true, nil
+	}))
+	mustSet(t, f, "session/1", "data")
+	mustSet(t, f, "user/1", "v1")
+	mustSet(t, f, "user/2", "other")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "session/1", "")
+	assertValue(t, f, "user/1", "v2")
+	assertValue(t, f, "user/2", "other")
+	if n := f.Stats().Keys; n != 2 {
+		t.Fatalf("got %d keys instead of 2", n)
+	}
+}
@@ -0,0 +1,158 @@
+package tridb
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Compression identifies the codec of compressed values (see WithCompression).
+type Compression byte
+
+// Available compression codecs.
+const (
+	NoCompression      Compression = 0
+	CompressionDeflate Compression = 1 // DEFLATE (RFC 1951) at the default compression level.
+)
+
+// DefaultCompressionThreshold is the minimum size of compressed values (see WithCompression).
+const DefaultCompressionThreshold = 256
+
+// flateWriters holds DEFLATE compressors (allocating one is expensive).
+var flateWriters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return w
+}}
+
+// compressRow compresses the row value with the given codec if it is at least threshold bytes long
+// and if compression actually makes it smaller. Values in the reserved keyspace are never compressed.
+func compressRow(row *Row, codec Compression, threshold int) {
+	if codec == NoCompression || row.IsDeleted || row.IsAlias || len(row.Value) < threshold || IsReservedKey(row.Key) {
+		return
+	}
+	buf := &bytes.Buffer{}
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(row.Value); err != nil || w.Close() != nil {
+		return // in-memory writes don't fail
+	}
+	if buf.Len() < len(row.Value) {
+		row.Value, row.Compression = buf.Bytes(), codec
+	}
+}
+
+// decompressValue returns the decompressed value of a row compressed with the given codec.
+func decompressValue(codec Compression, value []byte) ([]byte, error) {
+	switch codec {
+	case NoCompression:
+		return value, nil
+	case CompressionDeflate:
+		r := flate.NewReader(bytes.NewReader(value))
+		defer r.Close()
+		decompressed, err := io.ReadAll(io.LimitReader(r, MaxValueLength+1))
+		if err != nil {
+			return nil, fmt.Errorf("decompress value: %w", err)
+		}
+		if len(decompressed) > MaxValueLength {
+			return nil, fmt.Errorf("decompress value: %w", ErrValueTooLong)
+		}
+		return decompressed, nil
+	}
+	return nil, fmt.Errorf("%w: compression codec %d", ErrUnknownAttribute, codec)
+}
+
+// compressRow compresses the row value according to the file options (see WithCompression).
+func (f *File) compressRow(row *Row) {
+	compressRow(row, f.opts.Compression, f.opts.CompressionThreshold)
+}
+
+// plainValue returns the decrypted and decompressed value of the given row.
+func (f *File) plainValue(row *Row) ([]byte, error) {
+	value := row.Value
+	if row.IsSealed {
+		var err error
+		if value, err = f.keyring.openRow(row); err != nil {
+			return nil, err
+		}
+	}
+	return decompressValue(row.Compression, value)
+}
+
+// compressEncodedRow re-encodes the given row with its value compressed (see File.Compact),
+// it reports whether the row changed.
+func (f *File) compressEncodedRow(encodedRow []byte) ([]byte, bool, error) {
+	row := &Row{}
+	_, err := f.format.DecodeFrom(bytes.NewReader(encodedRow), row)
+	if err != nil {
+		return nil, false, fmt.Errorf("decode row: %w", err)
+	}
+	if row.IsSealed || row.Compression != NoCompression {
+		return encodedRow, false, nil // encrypted values don't compress
+	}
+	f.compressRow(row)
+	if row.Compression == NoCompression {
+		return encodedRow, false, nil
+	}
+	if row.Checksum != 0 {
+		row.Checksum = row.computeChecksum()
+	}
+	encoded, err := f.format.Encode(row)
+	return encoded, err == nil, err
+}
+
+// EstimateCompression compresses a random sample of sampleN live values (all values if sampleN is not positive)
+// with the given codec and reports the ratio of their compressed size to their size (1 means no savings).
+// Values are compressed as with WithCompression (smaller values than the configured threshold are left uncompressed),
+// so that operators can check whether enabling compression is worth it before compacting the file.
+func (f *File) EstimateCompression(codec Compression, sampleN int) (float64, error) {
+	threshold := f.opts.CompressionThreshold
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	var original, compressed int
+	err := f.Read(func(r *Reader) error {
+		// Reservoir sampling of the live keys
+		var sample []*fidx.RowInfo
+		seen := 0
+		for row := r.idx.Chronological().Oldest; row != nil; row = row.Next {
+			if r.expired(row) {
+				continue
+			}
+			seen++
+			if sampleN <= 0 || len(sample) < sampleN {
+				sample = append(sample, row)
+			} else if i := rand.Intn(seen); i < sampleN {
+				sample[i] = row
+			}
+		}
+
+		for _, rowInfo := range sample {
+			row, err := r.readRow(rowInfo.Key, rowInfo.Position)
+			if err != nil {
+				return err
+			}
+			if row.IsAlias {
+				continue // aliases hold the target key
+			}
+			value, err := f.storedValue(r.ra, row)
+			if err != nil {
+				return err
+			}
+			row = &Row{Key: rowInfo.Key, Value: value}
+			compressRow(row, codec, threshold)
+			original += len(value)
+			compressed += len(row.Value)
+		}
+		return nil
+	})
+	if err != nil || original == 0 {
+		return 1, err
+	}
+	return float64(compressed) / float64(original), nil
+}
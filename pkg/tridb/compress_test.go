@@ -0,0 +1,95 @@
+package tridb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	value := strings.Repeat(`{"name":"tridb","tags":["a","b"]},`, 100)
+	f, err := Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "uncompressed", value)
+	f.Close()
+
+	f, err = Open(fpath, 10, WithCompression(CompressionDeflate, 0), WithRowChecksums(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { f.Close() }()
+	mustSet(t, f, "compressed", value)
+	mustSet(t, f, "small", "tiny")
+	err = f.History([]byte("compressed"), func(c Change) error {
+		if string(c.Value) != value {
+			t.Fatalf("got history value of length %d instead of %d", len(c.Value), len(value))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"uncompressed", "compressed"} {
+		assertValue(t, f, key, value)
+	}
+	assertValue(t, f, "small", "tiny")
+
+	// Existing rows are compressed on compaction, values remain readable without the option.
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > int64(len(value)) {
+		t.Fatalf("got file size %d for two compressed values of %d bytes", info.Size(), len(value))
+	}
+	f.Close()
+	f, err = Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"uncompressed", "compressed"} {
+		assertValue(t, f, key, value)
+	}
+	_ = f.Read(func(r *Reader) error {
+		got, err := r.GetAppend(nil, []byte("compressed"))
+		if err != nil || string(got) != value {
+			t.Fatalf("got value of length %d (%v) instead of %d", len(got), err, len(value))
+		}
+		return nil
+	})
+}
+
+func TestEstimateCompression(t *testing.T) {
+	f := openTestFile(t)
+	if ratio, err := f.EstimateCompression(CompressionDeflate, 10); err != nil || ratio != 1 {
+		t.Fatalf("got ratio %v (%v) for an empty file", ratio, err)
+	}
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		for i := 0; i < 100; i++ {
+			w.Set(fmt.Appendf(nil, "json/%d", i), []byte(strings.Repeat(`{"name":"tridb","tags":["a","b"]},`, 50)))
+		}
+		w.Set([]byte("small"), []byte("tiny"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ratio, err := f.EstimateCompression(CompressionDeflate, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ratio <= 0 || ratio > 0.2 {
+		t.Fatalf("got ratio %v for repetitive values", ratio)
+	}
+	if ratio, err := f.EstimateCompression(NoCompression, 0); err != nil || ratio != 1 {
+		t.Fatalf("got ratio %v (%v) without codec", ratio, err)
+	}
+}
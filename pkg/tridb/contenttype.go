@@ -0,0 +1,86 @@
+package tridb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// ContentTypeStats holds statistics about the keys with a given content type (see Stats.ContentTypes).
+type ContentTypeStats struct {
+	Keys  int // Number of keys (including expired keys not yet removed by compaction).
+	Bytes int // Size of the rows holding the current value of these keys.
+}
+
+// contentTypeCount holds the statistics of a content type, along with its name
+// (shared by the keydir entries with this content type).
+type contentTypeCount struct {
+	name string
+	ContentTypeStats
+}
+
+// SetWithContentType is like Set but records the media type of the value (for example, "application/json").
+// Content types are limited to MaxContentTypeLength bytes, statistics are available in Stats.ContentTypes.
+// Merge rows keep the content type of the value they are merged with (see Writer.Merge).
+func (w *Writer) SetWithContentType(key, value []byte, contentType string) {
+	if len(contentType) > MaxContentTypeLength && w.err == nil {
+		w.err = fmt.Errorf("content type too long: %d", len(contentType))
+	}
+	if value, ok := w.beforeSet(key, value); ok && w.checkValue(value) {
+		w.stage(&Row{Key: key, Value: value, ContentType: contentType})
+	}
+}
+
+// ContentType returns the content type of the value associated with the given key
+// (empty if the key is not found or was set without content type).
+func (r *Reader) ContentType(key []byte) string {
+	if rowInfo := r.get(key); rowInfo != nil {
+		return rowInfo.ContentType
+	}
+	return ""
+}
+
+// countContentType adds (or removes, with a negative delta) the given keydir entry to the content type statistics.
+// Added entries share the name of their content type.
+func (f *File) countContentType(rowInfo *fidx.RowInfo, delta int) {
+	if rowInfo.ContentType == "" {
+		return
+	}
+	count := f.contentTypes[rowInfo.ContentType]
+	if count == nil {
+		count = &contentTypeCount{name: rowInfo.ContentType}
+		f.contentTypes[rowInfo.ContentType] = count
+	}
+	rowInfo.ContentType = count.name
+	count.Keys += delta
+	count.Bytes += delta * rowInfo.Position.Size()
+	if count.Keys == 0 {
+		delete(f.contentTypes, count.name)
+	}
+}
+
+// countKeys recomputes the statistics derived from the keydir
+// (after it was replaced, for example by a compaction).
+func (f *File) countKeys() {
+	f.expiring, f.contentTypes, f.liveBytes = 0, map[string]*contentTypeCount{}, 0
+	for row := f.idx.Chronological().Oldest; row != nil; row = row.Next {
+		f.expiring += boolToInt(row.ExpiresAt != 0)
+		f.countContentType(row, 1)
+		f.liveBytes += row.Position.Size()
+	}
+	f.expiries.reset(f.idx)
+	for row := f.sys.Chronological().Oldest; row != nil; row = row.Next {
+		f.liveBytes += row.Position.Size()
+	}
+}
+
+// sortedContentTypes returns the content types of the given statistics in lexicographical order.
+func sortedContentTypes(stats map[string]ContentTypeStats) []string {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,100 @@
+package tridb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContentTypeStats(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SetMergeOperator(appendMerge)
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetWithContentType([]byte("a"), []byte(`{}`), "application/json")
+		w.SetWithContentType([]byte("b"), []byte(`[]`), "application/json")
+		w.SetWithContentType([]byte("c"), []byte("\x89PNG"), "image/png")
+		w.SetWithContentType([]byte("d"), []byte("\x89PNG"), "image/png")
+		w.Set([]byte("e"), []byte("plain"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetWithContentType([]byte("b"), []byte("\x89PNG"), "image/png") // overwritten with another content type
+		w.Delete([]byte("c"))
+		w.Set([]byte("d"), []byte("plain"))
+		w.Merge([]byte("a"), []byte(" "))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizeOf := func(key string) int { return f.idx.Get([]byte(key)).Position.Size() }
+	want := map[string]ContentTypeStats{
+		"application/json": {Keys: 1, Bytes: sizeOf("a")},
+		"image/png":        {Keys: 1, Bytes: sizeOf("b")},
+	}
+	assertContentTypes := func(f *File) {
+		t.Helper()
+		if got := f.Stats().ContentTypes; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got content types %v instead of %v", got, want)
+		}
+	}
+	assertContentTypes(f)
+	_ = f.Read(func(r *Reader) error {
+		if got := r.ContentType([]byte("a")); got != "application/json" {
+			t.Fatalf("merged key has content type %q", got)
+		}
+		if got := r.ContentType([]byte("d")); got != "" {
+			t.Fatalf("key set without content type has content type %q", got)
+		}
+		return nil
+	})
+
+	// Statistics are restored when reopening the file (with and without clean shutdown marker)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, removeMarker := range []bool{false, true} {
+		if removeMarker {
+			os.Remove(fpath + CleanShutdownFileExtension)
+		}
+		f, err = Open(fpath, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.SetMergeOperator(appendMerge)
+		assertContentTypes(f)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// And after compaction
+	f, err = Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.SetMergeOperator(appendMerge)
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	want["application/json"] = ContentTypeStats{Keys: 1, Bytes: sizeOf("a")}
+	want["image/png"] = ContentTypeStats{Keys: 1, Bytes: sizeOf("b")}
+	assertContentTypes(f)
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetWithContentType([]byte("x"), nil, strings.Repeat("x", MaxContentTypeLength+1))
+		return nil
+	})
+	if err == nil {
+		t.Fatal("content type too long should abort the transaction")
+	}
+}
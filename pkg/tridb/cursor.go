@@ -0,0 +1,48 @@
+package tridb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Cursors are stored in the reserved keyspace.
+const cursorKeyPrefix = "cursor/"
+
+// SaveCursor persists the offset of a named cursor (for example, the progress of a log consumer).
+func (f *File) SaveCursor(name string, offset int) error {
+	return f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SaveCursor(name, offset)
+		return nil
+	})
+}
+
+// LoadCursor returns the offset of a named cursor, or 0 if the cursor was never saved.
+func (f *File) LoadCursor(name string) (int, error) {
+	offset := 0
+	err := f.Read(func(r *Reader) (err error) {
+		offset, err = r.LoadCursor(name)
+		return err
+	})
+	return offset, err
+}
+
+// SaveCursor persists the offset of a named cursor as part of the transaction,
+// so that consumers can atomically save their progress along with the writes it produced.
+func (w *Writer) SaveCursor(name string, offset int) {
+	w.setReserved(cursorKeyPrefix+name, binary.BigEndian.AppendUint64(nil, uint64(offset)))
+}
+
+// DeleteCursor removes a named cursor.
+func (w *Writer) DeleteCursor(name string) { w.deleteReserved(cursorKeyPrefix + name) }
+
+// LoadCursor returns the offset of a named cursor, or 0 if the cursor was never saved.
+func (r *Reader) LoadCursor(name string) (int, error) {
+	v, err := r.getReserved(cursorKeyPrefix + name)
+	if err != nil || v == nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, fmt.Errorf("invalid cursor value of length %d", len(v))
+	}
+	return int(binary.BigEndian.Uint64(v)), nil
+}
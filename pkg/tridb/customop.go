@@ -0,0 +1,181 @@
+package tridb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// Rows of custom ops (see WithCustomOp) are written outside of the row format as: op (1 byte),
+// length of the payload (4 bytes, big-endian) and payload (sealed like rows in files encrypted at rest).
+//
+// Custom ops are at least MinCustomOp so that they can't be mistaken for rows or batch frames:
+// files can be read without their handlers (see WithUnknownOpsSkipped).
+const (
+	MinCustomOp        byte = 0xE0
+	customOpHeaderSize      = 1 + 4
+)
+
+// ErrUnknownOp is returned when reading a row with an op that isn't handled by the file.
+var ErrUnknownOp = errors.New("unknown op")
+
+var errCustomOpsInBatch = errors.New("custom ops can't be written in batches")
+
+// OpHandler persists the state of a higher-level feature as rows of a custom op (see WithCustomOp).
+// Its functions are called with the file lock held and must not use the file.
+type OpHandler struct {
+	// Apply applies a row of the op: when the file is opened (or when the rows appended by the writer are loaded, see WithTail)
+	// and once a transaction writing the row is committed (see Writer.AppendOp). Returning an error fails the open (or the file).
+	Apply func(payload []byte) error
+	// Reset (optional) clears the state of the feature before the rows of the op are replayed from the start of the file.
+	Reset func()
+	// Compact (optional) returns the payloads of the rows written in place of the rows of the op by compactions
+	// and clones (all rows of the op are dropped if nil).
+	Compact func() [][]byte
+}
+
+// customOp is a row of a custom op staged by a writer.
+type customOp struct {
+	op      byte
+	payload []byte
+}
+
+// isCustomOp reports whether the given op starts a row of a custom op.
+func isCustomOp(op byte) bool { return op >= MinCustomOp }
+
+// AppendOp stages a row of the given custom op (see WithCustomOp), written after the other rows of the transaction.
+// The transaction is aborted with ErrUnknownOp if the file has no handler for the op.
+// Note: custom ops can't be written in batches (see File.Batch) nor in transactions renaming keys (see Writer.Rename).
+func (w *Writer) AppendOp(op byte, payload []byte) {
+	if !w.checkValue(payload) {
+		return
+	}
+	w.ops = append(w.ops, customOp{op: op, payload: payload})
+	w.pendingBytes += customOpHeaderSize + len(payload)
+}
+
+// checkOps reports an error if the rows of custom ops staged by the given writer can't be written.
+func (f *File) checkOps(w *Writer) error {
+	for _, op := range w.ops {
+		if _, ok := f.opts.CustomOps[op.op]; !ok {
+			return fmt.Errorf("%w: %#x", ErrUnknownOp, op.op)
+		}
+	}
+	if len(w.ops) > 0 && len(w.renames) > 0 {
+		return errCustomOpsInBatch // renames are written in a batch frame
+	}
+	return nil
+}
+
+// encodeCustomOp returns the encoded row of the given custom op, in the given format (the format of the file if nil).
+func (f *File) encodeCustomOp(op byte, payload []byte, format Format) ([]byte, error) {
+	if format == nil {
+		format = f.format
+	}
+	if ef, ok := format.(encryptedFormat); ok {
+		payload = sealValue(ef.aead, payload, []byte{op})
+	}
+	if len(payload) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d", ErrValueTooLong, len(payload))
+	}
+	encoded := make([]byte, 0, customOpHeaderSize+len(payload))
+	encoded = append(encoded, op)
+	encoded = binary.BigEndian.AppendUint32(encoded, uint32(len(payload)))
+	return append(encoded, payload...), nil
+}
+
+// readCustomOp reads the row of a custom op from r, it reports the number of bytes read.
+// The payload is returned as stored (see File.applyOp).
+func readCustomOp(r *bufio.Reader) (byte, []byte, int, error) {
+	header := [customOpHeaderSize]byte{}
+	n, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, nil, n, fmt.Errorf("read op header: %w", orUnexpectedEOF(err))
+	}
+	payload, m, err := readFull(r, int(binary.BigEndian.Uint32(header[1:])))
+	n += m
+	if err != nil {
+		return 0, nil, n, fmt.Errorf("read op payload: %w", orUnexpectedEOF(err))
+	}
+	return header[0], payload, n, nil
+}
+
+// applyOp applies the row of a custom op read from the file to its handler.
+func (f *File) applyOp(op byte, payload []byte) error {
+	handler, ok := f.opts.CustomOps[op]
+	if !ok {
+		if f.opts.SkipUnknownOps {
+			return nil
+		}
+		return fmt.Errorf("%w: %#x (see WithCustomOp and WithUnknownOpsSkipped)", ErrUnknownOp, op)
+	}
+	if ef, ok := f.format.(encryptedFormat); ok {
+		opened, err := openValue(ef.aead, payload, []byte{op})
+		if err != nil {
+			return fmt.Errorf("decrypt op %#x: %w", op, err)
+		}
+		payload = opened
+	}
+	return handler.Apply(payload)
+}
+
+// resetOps clears the state of the handlers of custom ops before replaying the file from the start.
+func (f *File) resetOps() {
+	for _, handler := range f.opts.CustomOps {
+		if handler.Reset != nil {
+			handler.Reset()
+		}
+	}
+}
+
+// writeOps appends the staged rows of custom ops and applies them (see Writer.AppendOp).
+func (f *File) writeOps(ops []customOp) error {
+	for _, op := range ops {
+		encoded, err := f.encodeCustomOp(op.op, op.payload, nil)
+		if err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+		n, err := f.store.Append(encoded)
+		f.woffset += n
+		f.tail.Write(encoded[:n])
+		if err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		if err := f.opts.CustomOps[op.op].Apply(op.payload); err != nil {
+			return fmt.Errorf("apply op %#x: %w", op.op, err)
+		}
+	}
+	return nil
+}
+
+// writeCompactedOps writes the rows of custom ops kept by compactions (see OpHandler.Compact) to w,
+// at the given offset. It reports the offset after the written rows.
+func (f *File) writeCompactedOps(w io.Writer, offset int, format Format) (int, error) {
+	ops := make([]byte, 0, len(f.opts.CustomOps))
+	for op := range f.opts.CustomOps {
+		ops = append(ops, op)
+	}
+	slices.Sort(ops)
+	for _, op := range ops {
+		compact := f.opts.CustomOps[op].Compact
+		if compact == nil {
+			continue
+		}
+		for _, payload := range compact() {
+			encoded, err := f.encodeCustomOp(op, payload, format)
+			if err != nil {
+				return offset, fmt.Errorf("encode op %#x: %w", op, err)
+			}
+			n, err := w.Write(encoded)
+			offset += n
+			if err != nil {
+				return offset, fmt.Errorf("write op %#x: %w", op, err)
+			}
+		}
+	}
+	return offset, nil
+}
@@ -0,0 +1,106 @@
+package tridb
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// counterOp persists a counter as rows of a custom op holding increments.
+type counterOp struct{ total uint64 }
+
+const opIncrement = MinCustomOp
+
+func (c *counterOp) handler() OpHandler {
+	return OpHandler{
+		Apply: func(payload []byte) error {
+			if len(payload) != 8 {
+				return errors.New("invalid increment")
+			}
+			c.total += binary.BigEndian.Uint64(payload)
+			return nil
+		},
+		Reset:   func() { c.total = 0 },
+		Compact: func() [][]byte { return [][]byte{binary.BigEndian.AppendUint64(nil, c.total)} },
+	}
+}
+
+func increment(t *testing.T, f *File, n uint64) {
+	t.Helper()
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.AppendOp(opIncrement, binary.BigEndian.AppendUint64(nil, n))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCustomOp(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithEncryption(make([]byte, 32))}} {
+		counter := &counterOp{}
+		f := openTestFile(t, append(opts, WithCustomOp(opIncrement, counter.handler()))...)
+		mustSet(t, f, "a", "1")
+		increment(t, f, 2)
+		increment(t, f, 3)
+		if counter.total != 5 {
+			t.Fatalf("got total %d instead of 5 after commits", counter.total)
+		}
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			w.AppendOp(MinCustomOp+1, nil)
+			return nil
+		})
+		if !errors.Is(err, ErrUnknownOp) {
+			t.Fatalf("got error %v when writing an op without handler", err)
+		}
+
+		// Ops are replayed on open and kept by compactions.
+		for _, compact := range []bool{false, true} {
+			if compact {
+				increment(t, f, 1)
+				if err := f.Compact(); err != nil {
+					t.Fatal(err)
+				}
+				increment(t, f, 4)
+			}
+			want := counter.total
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			f, err = Open(f.Path(), 1, append(opts, WithCustomOp(opIncrement, counter.handler()))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if counter.total != want {
+				t.Fatalf("got total %d instead of %d after reopening (compacted: %v)", counter.total, want, compact)
+			}
+			assertValue(t, f, "a", "1")
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Files with unknown ops only open if they are skipped.
+		if _, err := Open(f.Path(), 1, opts...); !errors.Is(err, ErrUnknownOp) {
+			t.Fatalf("got error %v when opening a file with unknown ops", err)
+		}
+		skipped, err := Open(f.Path(), 1, append(opts, WithUnknownOpsSkipped())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertValue(t, skipped, "a", "1")
+		skipped.Close()
+	}
+}
+
+func TestCustomOpInBatch(t *testing.T) {
+	counter := &counterOp{}
+	f := openTestFile(t, WithCustomOp(opIncrement, counter.handler()))
+	b := f.Batch()
+	b.Set([]byte("a"), []byte("1"))
+	b.AppendOp(opIncrement, binary.BigEndian.AppendUint64(nil, 1))
+	if err := b.Commit(); !errors.Is(err, ErrTxnAborted) {
+		t.Fatalf("got error %v when committing a batch with custom ops", err)
+	}
+	assertValue(t, f, "a", "")
+}
@@ -0,0 +1,191 @@
+package tridb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash/fnv"
+	"io"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// hashValue returns a non-zero hash of the given value (zero means unknown in the keydir).
+func hashValue(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value)
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// isUnchanged reports whether the given row sets a key to its current value.
+func (f *File) isUnchanged(row *Row) bool {
+	if row.IsDeleted || row.IsAlias || row.IsMerge || row.ExpiresAt != 0 || f.hasPrefixTTL(row.Key) {
+		return false
+	}
+	idx := f.idx
+	if IsReservedKey(row.Key) {
+		idx = f.sys
+	}
+	current := idx.Get(f.indexKey(row.Key))
+	if current == nil || current.ExpiresAt != 0 || current.ValueHash != hashValue(row.Value) {
+		return false
+	}
+	stored, err := f.readAndDecodeRow(f.readerAt(), current.Position)
+	return err == nil && !stored.IsDeleted && bytes.Equal(stored.Value, row.Value)
+}
+
+func (f *File) hasPrefixTTL(key []byte) bool {
+	for _, policy := range f.prefixTTLs {
+		if bytes.HasPrefix(key, policy.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// coalesceRows returns the given rows keeping only the last row of each key, in the order of the first row of each key
+// (see WithCoalescedWrites). Merge operands are kept with the rows they apply to.
+func (f *File) coalesceRows(rows []*Row) []*Row {
+	var slots [][]*Row        // rows of each key
+	byKey := map[string]int{} // index of the slot of each key
+	for _, row := range rows {
+		key := string(f.collate(row.Key))
+		i, ok := byKey[key]
+		switch {
+		case !ok:
+			byKey[key] = len(slots)
+			slots = append(slots, []*Row{row})
+		case row.IsMerge:
+			slots[i] = append(slots[i], row)
+		default:
+			slots[i] = []*Row{row}
+		}
+	}
+	coalesced := make([]*Row, 0, len(slots))
+	for _, slot := range slots {
+		coalesced = append(coalesced, slot...)
+	}
+	return coalesced
+}
+
+// valueDigest identifies the values indexed for deduplication (see WithValueDedup).
+type valueDigest [sha256.Size]byte
+
+// digestValue returns the digest of the value of the given row,
+// it reports false if the row is not deduplicated (see WithValueDedup).
+func (f *File) digestValue(row *Row) (valueDigest, bool) {
+	if f.opts.DedupThreshold <= 0 || row.IsDeleted || row.IsAlias || row.IsMerge || row.IsSealed || row.raw != nil ||
+		row.valueRef.Size() != 0 || IsReservedKey(row.Key) {
+		return valueDigest{}, false
+	}
+	value, err := decompressValue(row.Compression, row.Value)
+	if err != nil || len(value) < f.opts.DedupThreshold {
+		return valueDigest{}, false
+	}
+	return sha256.Sum256(value), true
+}
+
+// deduplicate returns the row to write for the given row: a row referencing the row already holding its value (if any).
+// Otherwise, it returns the row itself and reports whether its value must be indexed once written (see indexValue).
+func (f *File) deduplicate(row *Row) (*Row, valueDigest, bool) {
+	digest, ok := f.digestValue(row)
+	if !ok {
+		return row, digest, false
+	}
+	if target, ok := f.values[digest]; ok {
+		return row.referencing(target), digest, false
+	}
+	return row, digest, true
+}
+
+// indexValue records the position of the row holding the value with the given digest.
+func (f *File) indexValue(digest valueDigest, p fidx.Position) {
+	if f.values == nil {
+		f.values = map[valueDigest]fidx.Position{}
+	}
+	f.values[digest] = p
+}
+
+// referencing returns a copy of the row holding no value but the position of the row holding it.
+func (row *Row) referencing(target fidx.Position) *Row {
+	ref := *row
+	ref.Value, ref.Compression, ref.valueRef = nil, NoCompression, target
+	if ref.Checksum != 0 {
+		ref.Checksum = ref.computeChecksum()
+	}
+	return &ref
+}
+
+// resolveValueRef sets the value of the given deduplicated row from the row it references.
+func (f *File) resolveValueRef(ra io.ReaderAt, row *Row) error {
+	target, err := f.decodeRowAt(ra, row.valueRef)
+	if err != nil {
+		return fmt.Errorf("read value of %q: %w", row.Key, err)
+	}
+	if target.IsDeleted || target.IsAlias || target.IsMerge || target.IsSealed || target.valueRef.Size() != 0 {
+		return fmt.Errorf("%w: invalid value reference of %q to offset %d", ErrFileCorruption, row.Key, row.valueRef.Offset())
+	}
+	row.Value, row.Compression, row.valueRef = target.Value, target.Compression, fidx.Position{}
+	if row.Checksum != 0 {
+		row.Checksum = row.computeChecksum()
+	}
+	return nil
+}
+
+// indexValues rebuilds the index of deduplicated values from the rows of the keydir (see WithValueDedup).
+// It must be called with the write lock held.
+func (f *File) indexValues() error {
+	f.values = nil
+	if f.opts.DedupThreshold <= 0 || f.opts.ReadOnly {
+		return nil
+	}
+	ra := f.readerAt()
+	for rowInfo := f.idx.Chronological().Oldest; rowInfo != nil; rowInfo = rowInfo.Next {
+		row, err := f.decodeRowAt(ra, rowInfo.Position)
+		if err != nil {
+			return fmt.Errorf("index values: %w", err)
+		}
+		if digest, ok := f.digestValue(row); ok {
+			f.indexValue(digest, rowInfo.Position)
+		}
+	}
+	return nil
+}
+
+// mayReferenceValue reports whether an encoded row starting with the given op can reference the value of another row.
+func mayReferenceValue(op byte) bool {
+	return op == opSetWithAttrs || op == opEncrypted
+}
+
+// inlineEncodedRow re-encodes the given deduplicated row with the value it references (see File.Compact),
+// other rows are returned as is. It also returns the decoded row, rows that can't reference values
+// are only decoded if decode is set (nil otherwise).
+func (f *File) inlineEncodedRow(ra io.ReaderAt, encodedRow []byte, decode bool) ([]byte, *Row, error) {
+	if !decode && !mayReferenceValue(encodedRow[0]) {
+		return encodedRow, nil, nil
+	}
+	row := &Row{}
+	if _, err := f.format.DecodeFrom(bytes.NewReader(encodedRow), row); err != nil {
+		return nil, nil, fmt.Errorf("decode row: %w", err)
+	}
+	if row.valueRef.Size() == 0 {
+		return encodedRow, row, nil
+	}
+	if err := f.resolveValueRef(ra, row); err != nil {
+		return nil, nil, err
+	}
+	encoded, err := f.format.Encode(row)
+	return encoded, row, err
+}
+
+// encodeValueRef encodes a row referencing the given row instead of holding the value of the given row,
+// in the given format (or in the format of the file if nil).
+func (f *File) encodeValueRef(row *Row, target fidx.Position, format Format) ([]byte, error) {
+	if format == nil {
+		format = f.format
+	}
+	return format.Encode(row.referencing(target))
+}
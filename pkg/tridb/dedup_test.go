@@ -0,0 +1,83 @@
+package tridb
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestValueDedup(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 10, WithValueDedup(16), WithRowChecksums(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := string(bytes.Repeat([]byte("thumbnail"), 100))
+	mustSet(t, f, "a", blob)
+	before := f.Stats().FileSize
+	mustSet(t, f, "b", blob)
+	mustSet(t, f, "small1", "tiny")
+	if grown := f.Stats().FileSize - before; grown >= len(blob) {
+		t.Fatalf("the duplicate value grew the file by %d bytes", grown)
+	}
+	assertValue(t, f, "b", blob)
+
+	// Deduplicated values survive overwrites of the referenced key, compactions and reopening.
+	mustSet(t, f, "a", "other")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "b", blob)
+	mustSet(t, f, "c", blob)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err = Open(fpath, 10, WithValueDedup(16), WithRowChecksums(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	assertValue(t, f, "b", blob)
+	assertValue(t, f, "c", blob)
+	before = f.Stats().FileSize
+	mustSet(t, f, "d", blob)
+	if grown := f.Stats().FileSize - before; grown >= len(blob) {
+		t.Fatalf("the duplicate value grew the reopened file by %d bytes", grown)
+	}
+	report, err := f.Verify(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ChecksumErrors != 0 {
+		t.Fatalf("got %d checksum errors", report.ChecksumErrors)
+	}
+}
+
+func TestValueDedupCompactionKeepsReferences(t *testing.T) {
+	f := openTestFile(t, WithValueDedup(16))
+	blob := string(bytes.Repeat([]byte("x"), 1000))
+	for _, key := range []string{"a", "b", "c"} {
+		mustSet(t, f, key, blob)
+	}
+	mustSet(t, f, "a", "other")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if size := f.Stats().FileSize; size >= 2*len(blob) {
+		t.Fatalf("got a %d bytes file after compaction, the value should be written once", size)
+	}
+	assertValue(t, f, "b", blob)
+	assertValue(t, f, "c", blob)
+
+	// Rows read raw hold their value.
+	err := f.Read(func(r *Reader) error {
+		raw, err := r.GetRaw([]byte("c"))
+		if err == nil && !bytes.Contains(raw, []byte(blob)) {
+			t.Fatal("the raw row doesn't hold its value")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
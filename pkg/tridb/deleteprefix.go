@@ -0,0 +1,73 @@
+package tridb
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// prefixDelete is a staged prefix deletion, resolved on commit before the row staged at the given index
+// (and before the renames staged after it).
+type prefixDelete struct {
+	prefix      []byte
+	at, renames int
+}
+
+// DeletePrefix deletes all keys starting with the given prefix (all keys if empty) in a single call.
+// The keys are listed on commit, with the write lock held, and a tombstone is staged for each of them
+// where DeletePrefix was called: keys set earlier in the transaction are deleted, keys set afterwards are kept.
+//
+// Deleting a prefix in the reserved keyspace aborts the transaction with ErrInvalidKey,
+// and the transaction fails with ErrHashedKeys on commit if keys are hashed in memory (see WithHashedKeys).
+func (w *Writer) DeletePrefix(prefix []byte) {
+	if IsReservedKey(prefix) {
+		if w.err == nil {
+			w.err = fmt.Errorf("%w: %w: %q", ErrInvalidKey, ErrReservedKey, prefix)
+		}
+		return
+	}
+	w.deletes = append(w.deletes, prefixDelete{prefix: prefix, at: len(w.rows), renames: len(w.renames)})
+}
+
+// stagePrefixDelete stages a tombstone for each key starting with the given prefix,
+// in the committed state or in the rows staged by the writer.
+// It must be called with the write lock held.
+func (f *File) stagePrefixDelete(w *Writer, prefix []byte) error {
+	if f.opts.KeySecret != nil {
+		return fmt.Errorf("delete prefix %q: %w", prefix, ErrHashedKeys)
+	}
+	var keys [][]byte
+	seen := map[string]bool{}
+	add := func(key []byte) {
+		if collated := string(f.collate(key)); !seen[collated] {
+			seen[collated] = true
+			keys = append(keys, key)
+		}
+	}
+	start := f.collate(prefix)
+	err := f.idx.WalkRange(start, fidx.PrefixEnd(start), false, func(rowInfo *fidx.RowInfo) error {
+		if f.opts.KeyCollation == nil {
+			add(rowInfo.Key)
+			return nil
+		}
+		row, err := f.readAndDecodeRow(f.readerAt(), rowInfo.Position) // the keydir holds collated keys
+		if err != nil {
+			return err
+		}
+		add(row.Key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete prefix %q: %w", prefix, err)
+	}
+	for _, row := range w.rows {
+		if !row.IsDeleted && bytes.HasPrefix(f.collate(row.Key), start) {
+			add(row.Key)
+		}
+	}
+	for _, key := range keys {
+		w.stage(&Row{IsDeleted: true, Key: key})
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package tridb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDeletePrefix(t *testing.T) {
+	f := openTestFile(t)
+	for _, key := range []string{"a:1", "a:2", "ab", "b:1", "b:2"} {
+		mustSet(t, f, key, key)
+	}
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("a:3"), []byte("a:3"))
+		w.Rename([]byte("b:1"), []byte("a:4")) // staged before: deleted
+		w.DeletePrefix([]byte("a:"))
+		w.Rename([]byte("b:2"), []byte("a:5")) // staged after: kept
+		w.Set([]byte("a:6"), []byte("a:6"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"a:1": "", "a:2": "", "a:3": "", "a:4": "", "a:5": "b:2", "a:6": "a:6", "ab": "ab", "b:1": ""} {
+		assertValue(t, f, key, want)
+	}
+
+	// Rolled back prefix deletions are ignored
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		sp := w.Savepoint()
+		w.DeletePrefix(nil)
+		w.RollbackTo(sp)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "ab", "ab")
+
+	err = f.ReadWrite(func(r *Reader, w *Writer) error { w.DeletePrefix([]byte(ReservedPrefix)); return nil })
+	if !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("got error %v instead of %v", err, ErrReservedKey)
+	}
+}
+
+func TestDeletePrefixCollation(t *testing.T) {
+	f := openTestFile(t, WithKeyCollation(CaseFolding))
+	mustSet(t, f, "User:1", "1")
+	mustSet(t, f, "user:2", "2")
+	mustSet(t, f, "other", "3")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error { w.DeletePrefix([]byte("USER:")); return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "user:1", "")
+	assertValue(t, f, "user:2", "")
+	assertValue(t, f, "other", "3")
+
+	f = openTestFile(t, WithHashedKeys([]byte("secret")))
+	err = f.ReadWrite(func(r *Reader, w *Writer) error { w.DeletePrefix([]byte("user:")); return nil })
+	if !errors.Is(err, ErrHashedKeys) {
+		t.Fatalf("got error %v instead of %v", err, ErrHashedKeys)
+	}
+}
@@ -0,0 +1,54 @@
+package tridb
+
+import (
+	"fmt"
+	"time"
+)
+
+// KeyInfo describes the row holding the current value of a key (see Reader.Describe).
+type KeyInfo struct {
+	Key         []byte
+	Position    RowPosition // Position of the row in the file.
+	ValueSize   int         // Size of the value as stored (compressed and encrypted values differ from their plain size).
+	Op          byte        // Operation of the row (OpSet or OpMerge).
+	ModTime     time.Time   // Write time of the row (zero if written without timestamp, see WithTimestamps).
+	ExpiresAt   time.Time   // Expiration time of the key (zero if it doesn't expire).
+	ContentType string      // Media type of the value (empty if unknown).
+}
+
+// Describe returns information about the row holding the current value of the given key,
+// or nil if the key doesn't exist.
+// Files using the binary format are described without reading the value (see BinaryEncoding).
+func (r *Reader) Describe(key []byte) (*KeyInfo, error) {
+	rowInfo := r.get(key)
+	if rowInfo == nil {
+		return nil, nil
+	}
+	p := rowInfo.Position
+	info := &KeyInfo{Position: RowPosition{Offset: p.Offset(), Size: p.Size()}, Op: OpSet, ContentType: rowInfo.ContentType}
+	if rowInfo.Timestamp != 0 {
+		info.ModTime = time.Unix(0, rowInfo.Timestamp)
+	}
+	if rowInfo.ExpiresAt != 0 {
+		info.ExpiresAt = time.Unix(0, rowInfo.ExpiresAt)
+	}
+
+	var row *Row
+	var err error
+	if _, binary := r.f.format.(binaryFormat); binary {
+		row, info.ValueSize, _, err = r.readRowHead(p.Offset(), p.Size())
+		if err == nil && row.valueRef.Size() != 0 { // deduplicated value (see WithValueDedup)
+			_, info.ValueSize, _, err = r.readRowHead(row.valueRef.Offset(), row.valueRef.Size())
+		}
+	} else if row, err = r.f.readAndDecodeRow(r.ra, p); err == nil {
+		info.ValueSize = len(row.Value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read row at offset %d: %w", p.Offset(), err)
+	}
+	info.Key = row.Key
+	if row.IsMerge {
+		info.Op = OpMerge
+	}
+	return info, nil
+}
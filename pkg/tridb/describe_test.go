@@ -0,0 +1,48 @@
+package tridb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	for _, format := range []Format{BinaryEncoding, TextEncoding} {
+		t.Run(format.Name(), func(t *testing.T) {
+			f := openTestFile(t, WithFormat(format), WithValueDedup(64))
+			value := strings.Repeat("v", 100)
+			mustSet(t, f, "a", value)
+			mustSet(t, f, "b", value) // deduplicated
+			err := f.ReadWrite(func(r *Reader, w *Writer) error {
+				w.SetWithTTL([]byte("c"), []byte("123"), time.Hour)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_ = f.Read(func(r *Reader) error {
+				for key, size := range map[string]int{"a": len(value), "b": len(value), "c": 3} {
+					info, err := r.Describe([]byte(key))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(info.Key) != key || info.ValueSize != size || info.Op != OpSet {
+						t.Fatalf("got key %q, value size %d and op %q instead of %q, %d and %q", info.Key, info.ValueSize, info.Op, key, size, OpSet)
+					}
+					p := r.get([]byte(key)).Position
+					if info.Position != (RowPosition{Offset: p.Offset(), Size: p.Size()}) || info.ModTime.IsZero() {
+						t.Fatalf("got position %+v and write time %s for %q", info.Position, info.ModTime, key)
+					}
+					if got := !info.ExpiresAt.IsZero(); got != (key == "c") {
+						t.Fatalf("got expiration %s for %q", info.ExpiresAt, key)
+					}
+				}
+				if info, err := r.Describe([]byte("missing")); info != nil || err != nil {
+					t.Fatalf("got %+v (%v) for a missing key", info, err)
+				}
+				return nil
+			})
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package tridb
+
+import (
+	"errors"
+	"time"
+)
+
+// errDetached interrupts walks on the live keydir when the reader switches to a snapshot.
+var errDetached = errors.New("detached")
+
+// checkDeadline detaches the reader from the lock if the maximum read duration is exceeded.
+// It must be called from the goroutine executing the transaction, before accessing the keydir.
+func (r *Reader) checkDeadline() {
+	if r.deadline.IsZero() || r.detached != nil || time.Now().Before(r.deadline) {
+		return
+	}
+
+	// Use a dedicated read handle so that rows can still be read after a compaction replaces the file.
+	h, err := r.f.opts.Storage(r.f.fpath, StorageReadOnly)
+	if err != nil {
+		r.deadline = time.Time{} // keep holding the lock
+		return
+	}
+	idx, sys := r.f.cloneKeydirs(r.idx, r.sys)
+	r.idx, r.sys, r.ra, r.detached = idx, sys, h, h
+	r.f.mu.RUnlock()
+}
+
+// release ends a read-only transaction.
+func (r *Reader) release() {
+	if r.detached != nil {
+		r.detached.Close()
+		return
+	}
+	r.f.mu.RUnlock()
+}
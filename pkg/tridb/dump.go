@@ -0,0 +1,156 @@
+package tridb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dump format (one line per key-value pair, keys in lexicographical order):
+//
+//	tridb-dump 1 <number of keys>
+//	"<escaped key>" "<escaped value>" <CRC-32 of the line preceding the checksum, 8 hex chars>
+//
+// Keys and values are escaped with Go string literal syntax (see strconv.Quote),
+// so that dumps are human readable, diffable and safe for binary data.
+const dumpHeader = "tridb-dump 1"
+
+// ErrBadDump is returned when loading an invalid dump.
+var ErrBadDump = errors.New("bad dump")
+
+// Dump writes all key-value pairs to w in a canonical text format (see Load).
+func (f *File) Dump(w io.Writer) error {
+	return f.Read(func(r *Reader) error {
+		bufw := bufio.NewWriter(w)
+		fmt.Fprintf(bufw, "%s %d\n", dumpHeader, r.Count())
+		_, err := r.WalkWithValue(nil, func(key, value []byte) error { return writeDumpLine(bufw, key, value) })
+		if err != nil {
+			return err
+		}
+		return bufw.Flush()
+	})
+}
+
+// DumpClosure writes the given root keys and all the keys they transitively reference to w
+// in the dump format (see Dump and Load), for example to export the data of a single user or tenant.
+//
+// The refs function returns the keys referenced by a key-value pair,
+// referenced keys that don't exist are ignored.
+func (f *File) DumpClosure(w io.Writer, roots [][]byte, refs func(key, value []byte) [][]byte) error {
+	return f.Read(func(r *Reader) error {
+		values := map[string][]byte{}
+		queue := append([][]byte(nil), roots...)
+		for len(queue) > 0 {
+			key := queue[0]
+			queue = queue[1:]
+			if _, ok := values[string(key)]; ok {
+				continue
+			}
+			value, err := r.Get(key)
+			if err != nil {
+				return fmt.Errorf("get %q: %w", key, err)
+			}
+			if value == nil {
+				continue
+			}
+			values[string(key)] = value
+			queue = append(queue, refs(key, value)...)
+		}
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		bufw := bufio.NewWriter(w)
+		fmt.Fprintf(bufw, "%s %d\n", dumpHeader, len(keys))
+		for _, key := range keys {
+			if err := writeDumpLine(bufw, []byte(key), values[key]); err != nil {
+				return err
+			}
+		}
+		return bufw.Flush()
+	})
+}
+
+func writeDumpLine(w io.Writer, key, value []byte) error {
+	line := strconv.Quote(string(key)) + " " + strconv.Quote(string(value))
+	_, err := fmt.Fprintf(w, "%s %08x\n", line, crc32.ChecksumIEEE([]byte(line)))
+	return err
+}
+
+// Load reads a dump (see Dump) and sets all key-value pairs in a single transaction.
+// Nothing is written if the dump is invalid. It reports the number of loaded key-value pairs.
+func (f *File) Load(src io.Reader) (int, error) {
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(nil, math.MaxInt) // escaped values may be longer than MaxValueLength
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("%w: missing header", ErrBadDump)
+	}
+	countStr, ok := strings.CutPrefix(scanner.Text(), dumpHeader+" ")
+	count, err := strconv.Atoi(countStr)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("%w: invalid header %q", ErrBadDump, scanner.Text())
+	}
+
+	var keys, values [][]byte
+	for lineNum := 2; scanner.Scan(); lineNum++ {
+		key, value, err := parseDumpLine(scanner.Text())
+		if err != nil {
+			return 0, fmt.Errorf("%w: line %d: %w", ErrBadDump, lineNum, err)
+		}
+		keys, values = append(keys, key), append(values, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if len(keys) != count {
+		return 0, fmt.Errorf("%w: found %d key-value pairs instead of %d", ErrBadDump, len(keys), count)
+	}
+
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		for i, key := range keys {
+			w.Set(key, values[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func parseDumpLine(line string) ([]byte, []byte, error) {
+	i := strings.LastIndexByte(line, ' ')
+	if i < 0 {
+		return nil, nil, errors.New("missing checksum")
+	}
+	content, checksum := line[:i], line[i+1:]
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(content))) != checksum {
+		return nil, nil, errors.New("checksum mismatch")
+	}
+
+	quotedKey, err := strconv.QuotedPrefix(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid key: %w", err)
+	}
+	quotedValue, ok := strings.CutPrefix(content[len(quotedKey):], " ")
+	if !ok {
+		return nil, nil, errors.New("missing value")
+	}
+	key, err := strconv.Unquote(quotedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid key: %w", err)
+	}
+	value, err := strconv.Unquote(quotedValue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid value: %w", err)
+	}
+	return []byte(key), []byte(value), nil
+}
@@ -0,0 +1,63 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDumpAndLoad(t *testing.T) {
+	src := openTestFile(t)
+	mustSet(t, src, "b", "line 1\nline 2")
+	mustSet(t, src, "a", "\x00\xff binary")
+	mustSet(t, src, "c", "")
+
+	dump := &bytes.Buffer{}
+	if err := src.Dump(dump); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(dump.String()), "\n")
+	if len(lines) != 4 || lines[0] != "tridb-dump 1 3" || !strings.HasPrefix(lines[1], `"a" "\x00\xff binary" `) {
+		t.Fatalf("unexpected dump:\n%s", dump)
+	}
+
+	dst := openTestFile(t)
+	if n, err := dst.Load(bytes.NewReader(dump.Bytes())); err != nil || n != 3 {
+		t.Fatalf("loaded %d key-value pairs (%v) instead of 3", n, err)
+	}
+	assertValue(t, dst, "a", "\x00\xff binary")
+	assertValue(t, dst, "b", "line 1\nline 2")
+
+	// Tampered dumps are rejected
+	tampered := strings.Replace(dump.String(), "line 1", "line 0", 1)
+	if _, err := dst.Load(strings.NewReader(tampered)); !errors.Is(err, ErrBadDump) {
+		t.Fatalf("got error %v instead of %v", err, ErrBadDump)
+	}
+}
+
+func TestDumpClosure(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "users/1", "orders/1 orders/2")
+	mustSet(t, f, "users/2", "orders/3")
+	mustSet(t, f, "orders/1", "products/1")
+	mustSet(t, f, "orders/2", "products/1 products/missing")
+	mustSet(t, f, "orders/3", "products/2")
+	mustSet(t, f, "products/1", "users/1") // cycle
+	mustSet(t, f, "products/2", "")
+
+	dump := &bytes.Buffer{}
+	err := f.DumpClosure(dump, [][]byte{[]byte("users/1")}, func(key, value []byte) [][]byte {
+		return bytes.Fields(value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := openTestFile(t)
+	if n, err := dst.Load(dump); err != nil || n != 4 {
+		t.Fatalf("loaded %d key-value pairs (%v) instead of 4:\n%s", n, err, dump)
+	}
+	assertValue(t, dst, "orders/2", "products/1 products/missing")
+	assertValue(t, dst, "products/1", "users/1")
+	assertValue(t, dst, "users/2", "")
+}
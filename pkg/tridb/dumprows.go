@@ -0,0 +1,77 @@
+package tridb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DumpRows calls do for each row of the log starting at the given offset, in file order,
+// including deletes and overwritten versions (for example to feed a change-data-capture pipeline).
+// do receives the offset of the row, or of the batch frame holding it (see File.Batch),
+// the row must not be retained nor modified.
+//
+// It returns the offset at which to resume: the end of the log, or the offset of the row
+// for which do returned an error (the error is then returned unless it is ErrBreak).
+// Rows committed during the dump are left to the next one.
+// Offsets must be 0 or values returned by DumpRows (or passed to do), compactions rewrite the log and invalidate them.
+func (f *File) DumpRows(from int, do func(offset int, row *Row) error) (int, error) {
+	f.mu.RLock()
+	if err := f.Err(); err != nil {
+		f.mu.RUnlock()
+		return from, err
+	}
+	h, err := f.opts.Storage(f.fpath, StorageReadOnly) // dedicated handle, valid even after a compaction replaces the file
+	size := f.woffset
+	f.mu.RUnlock()
+	if err != nil {
+		return from, fmt.Errorf("open file: %w", err)
+	}
+	defer h.Close()
+	if from < 0 || from > size {
+		return from, fmt.Errorf("invalid offset %d (the log ends at offset %d)", from, size)
+	}
+
+	r := bufio.NewReaderSize(io.NewSectionReader(h, int64(from), int64(size-from)), f.opts.ReadBufferSize)
+	offset := from
+	if offset == 0 {
+		header, ok, err := readFileHeader(r)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			offset = header.size()
+		}
+	}
+	for offset < size {
+		var rows []batchRow
+		var n int
+		if op, _ := r.Peek(1); len(op) == 1 && isCustomOp(op[0]) {
+			_, _, n, err = readCustomOp(r) // not a key-value row (see WithCustomOp)
+		} else if op, _ := r.Peek(1); len(op) == 1 && op[0] == opBatch {
+			rows, n, err = decodeBatchFrom(f.format, r)
+		} else {
+			rows = make([]batchRow, 1)
+			n, err = f.format.DecodeFrom(r, &rows[0].row)
+		}
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF // the log ends at size
+		}
+		if err != nil {
+			return offset, fmt.Errorf("decode row at offset %d: %w", offset, err)
+		}
+		for i := range rows {
+			if rows[i].row.valueRef.Size() != 0 {
+				if err := f.resolveValueRef(h, &rows[i].row); err != nil { // the referenced row may not be dumped
+					return offset, err
+				}
+			}
+			if err := do(offset, &rows[i].row); err != nil {
+				return offset, ignoreBreak(err)
+			}
+		}
+		offset += n
+	}
+	return offset, nil
+}
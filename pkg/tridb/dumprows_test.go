@@ -0,0 +1,109 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDumpRows(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "a", "2")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("a"))
+		w.Set([]byte("b"), []byte("3"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := f.Batch()
+	b.Set([]byte("c"), []byte("4"))
+	b.Set([]byte("d"), []byte("5"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := func(from int) ([]string, []int, int) {
+		t.Helper()
+		var rows []string
+		var offsets []int
+		next, err := f.DumpRows(from, func(offset int, row *Row) error {
+			if row.IsDeleted {
+				rows = append(rows, "-"+string(row.Key))
+			} else {
+				rows = append(rows, string(row.Key)+"="+string(row.Value))
+			}
+			offsets = append(offsets, offset)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows, offsets, next
+	}
+	rows, offsets, next := dump(0)
+	if want := []string{"a=1", "a=2", "-a", "b=3", "c=4", "d=5"}; !reflect.DeepEqual(rows, want) {
+		t.Fatalf("got rows %q instead of %q", rows, want)
+	}
+	if offsets[4] != offsets[5] || next != f.Stats().FileSize {
+		t.Fatalf("got offsets %v and next offset %d", offsets, next)
+	}
+	if rows, _, _ := dump(offsets[4]); !reflect.DeepEqual(rows, []string{"c=4", "d=5"}) {
+		t.Fatalf("got rows %q from the batch offset", rows)
+	}
+	mustSet(t, f, "e", "6")
+	if rows, _, _ := dump(next); !reflect.DeepEqual(rows, []string{"e=6"}) {
+		t.Fatalf("got rows %q after the bookmark", rows)
+	}
+	stop, err := f.DumpRows(0, func(offset int, row *Row) error {
+		if string(row.Key) == "b" {
+			return ErrBreak
+		}
+		return nil
+	})
+	if err != nil || stop != offsets[3] {
+		t.Fatalf("got offset %d (%v) instead of %d after a break", stop, err, offsets[3])
+	}
+
+	fpath := filepath.Join(t.TempDir(), "restored.tridb")
+	err = RestoreFrom(fpath, func(do func(row *Row) error) error {
+		_, err := f.DumpRows(0, func(offset int, row *Row) error { return do(row) })
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	assertValue(t, restored, "a", "")
+	assertValue(t, restored, "b", "3")
+	assertValue(t, restored, "e", "6")
+	if rows, _, _ := dump(0); restored.Stats().Rows != len(rows) {
+		t.Fatalf("restored %d rows instead of %d", restored.Stats().Rows, len(rows))
+	}
+	_ = f.Read(func(r *Reader) error {
+		_ = restored.Read(func(rr *Reader) error {
+			if !r.ModTime([]byte("b")).Equal(rr.ModTime([]byte("b"))) {
+				t.Fatal("the write time of the restored row changed")
+			}
+			return nil
+		})
+		return nil
+	})
+
+	failed := filepath.Join(t.TempDir(), "failed.tridb")
+	errStream := errors.New("stream error")
+	if err := RestoreFrom(failed, func(do func(row *Row) error) error { return errStream }); !errors.Is(err, errStream) {
+		t.Fatalf("got error %v instead of %v", err, errStream)
+	}
+	if _, err := os.Stat(failed); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the file of a failed restore wasn't removed: %v", err)
+	}
+}
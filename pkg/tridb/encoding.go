@@ -4,34 +4,91 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"math"
+
+	"github.com/ejuju/tridb/pkg/fidx"
 )
 
 // Row holds data about a single database operation,
 // more specifically, a 'set' or 'delete' operation.
 // Rows are persisted to a file.
 type Row struct {
-	IsDeleted  bool // To differentiate ('set' and 'delete' ops)
-	Key, Value []byte
+	IsDeleted   bool // To differentiate ('set' and 'delete' ops)
+	Key, Value  []byte
+	Timestamp   int64       // Write time in Unix nanoseconds (0 if unknown).
+	ExpiresAt   int64       // Expiration time in Unix nanoseconds (0 if the key doesn't expire).
+	IsAlias     bool        // The value holds the key this row refers to (see Writer.Alias).
+	IsSealed    bool        // The value is encrypted with the data key of its prefix (see File.EncryptPrefix).
+	IsMerge     bool        // The value is a merge operand (see Writer.Merge).
+	Compression Compression // Codec of the compressed value (see WithCompression).
+	Actor       string      // Who wrote the row (empty if unknown, see Writer.SetActor).
+	ContentType string      // Media type of the value (empty if unknown, see Writer.SetWithContentType).
+	Checksum    uint32      // Non-zero CRC-32C of the key and value (0 if absent, see WithRowChecksums).
+
+	mergeBase mergeLink     // previous row of the key for merge rows (see Writer.Merge)
+	valueRef  fidx.Position // row holding the value of deduplicated rows (see WithValueDedup)
+	raw       []byte        // encoded row written verbatim (see RawWriter.PutRaw)
 }
 
 // Characters used to encode the type of write operations into a row.
 const (
 	opSet    byte = '+'
 	opDelete byte = '-'
+
+	// Same operations for rows with attributes (ex: timestamp).
+	opSetWithAttrs    byte = '*'
+	opDeleteWithAttrs byte = '/'
+
+	// Merge operands (see Writer.Merge), without and with attributes.
+	opMerge          byte = '%'
+	opMergeWithAttrs byte = '&'
+
+	// Deletes without value length (see WithCompactTombstones), without and with attributes.
+	// Only the binary format encodes them.
+	opTombstone          byte = '_'
+	opTombstoneWithAttrs byte = '~'
 )
 
-// Key/value length constraints.
+// Row attributes are encoded as: tag (1 byte), data length (1 byte) and data.
+//
+// Decoders skip unknown tags below attrCritical (optional metadata)
+// and fail on unknown tags above (attributes that change how a row must be interpreted).
+const (
+	attrTimestamp   byte = 0x01 // Unix nanoseconds (8 bytes, big-endian).
+	attrChecksum    byte = 0x02 // CRC-32C of the key and value (4 bytes, big-endian).
+	attrActor       byte = 0x03 // Who wrote the row (up to 255 bytes).
+	attrContentType byte = 0x04 // Media type of the value (up to 255 bytes).
+	attrCritical    byte = 0x80
+	attrExpiresAt   byte = 0x81 // Unix nanoseconds (8 bytes, big-endian).
+	attrAlias       byte = 0x82 // No data, the value is the target key.
+	attrSealed      byte = 0x83 // No data, the value is encrypted (see File.EncryptPrefix).
+	attrMergeBase   byte = 0x84 // Offset (8 bytes), size (4 bytes) and chain depth (2 bytes) of the previous row of the key.
+	attrCompressed  byte = 0x85 // Compression codec of the value (1 byte).
+	attrKeyLength   byte = 0x86 // Length of keys longer than 255 bytes (2 bytes, big-endian), the header then holds 0.
+	attrValueRef    byte = 0x87 // Offset (8 bytes) and size (4 bytes) of the row holding the value, the row holds none.
+)
+
+// ErrUnknownAttribute is returned when decoding a row with an unknown critical attribute.
+var ErrUnknownAttribute = errors.New("unknown row attribute")
+
+// Key/value length constraints (see WithMaxKeyLength and WithMaxValueLength for lower limits).
+//
+// Keys longer than 255 bytes are encoded with a critical attribute, so older versions can't decode them.
 const (
-	MaxKeyLength   = math.MaxUint8  // Maximum allowed key-length.
-	MaxValueLength = math.MaxUint32 // Maximum allowed value-length.
+	MaxKeyLength         = math.MaxUint16 // Maximum allowed key-length.
+	MaxValueLength       = math.MaxUint32 // Maximum allowed value-length.
+	MaxActorLength       = math.MaxUint8  // Maximum allowed actor length (see Writer.SetActor).
+	MaxContentTypeLength = math.MaxUint8  // Maximum allowed content type length (see Writer.SetWithContentType).
+	maxAttrsLength       = math.MaxUint16 // Maximum length of encoded row attributes.
+	maxShortKeyLength    = math.MaxUint8  // Maximum key-length encoded in the row header.
 )
 
 // Key/value length constrains errors.
 var (
-	ErrKeyTooLong   = errors.New("key too long")   // Key-length overflows uint8.
-	ErrValueTooLong = errors.New("value too long") // Value-length overflows uint32.
+	ErrKeyTooLong   = errors.New("key too long")   // Key-length exceeds MaxKeyLength (or the configured limit).
+	ErrValueTooLong = errors.New("value too long") // Value-length exceeds MaxValueLength (or the configured limit).
 )
 
 // Validate reports an error if the row key and/or value is too long.
@@ -42,6 +99,192 @@ func (row *Row) Validate() error {
 	if len(row.Value) > MaxValueLength {
 		return fmt.Errorf("%w: %d", ErrValueTooLong, len(row.Value))
 	}
+	if row.IsMerge && (row.IsDeleted || row.IsAlias) {
+		return errors.New("merge row cannot be a delete or alias row")
+	}
+	if len(row.Actor) > MaxActorLength {
+		return fmt.Errorf("actor too long: %d", len(row.Actor))
+	}
+	if len(row.ContentType) > MaxContentTypeLength {
+		return fmt.Errorf("content type too long: %d", len(row.ContentType))
+	}
+	return nil
+}
+
+// Size of the row header (op, key-length and value-length),
+// rows with attributes have two more bytes for the attributes length.
+// Compact tombstones have no value length (see WithCompactTombstones).
+const (
+	rowHeaderSize       = 1 + 1 + 4
+	tombstoneHeaderSize = 1 + 1
+)
+
+// EncodedSize returns the number of bytes of the encoded row.
+func (row *Row) EncodedSize() int {
+	size := rowHeaderSize + len(row.Key) + len(row.Value)
+	if attrs := row.appendBinaryAttrs(nil); len(attrs) > 0 {
+		size += 2 + len(attrs)
+	}
+	return size
+}
+
+// appendAttrs appends the encoded row attributes to dst.
+func (row *Row) appendAttrs(dst []byte) []byte {
+	if row.Timestamp != 0 {
+		dst = append(dst, attrTimestamp, 8)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(row.Timestamp))
+	}
+	if row.ExpiresAt != 0 {
+		dst = append(dst, attrExpiresAt, 8)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(row.ExpiresAt))
+	}
+	if row.IsAlias {
+		dst = append(dst, attrAlias, 0)
+	}
+	if row.IsSealed {
+		dst = append(dst, attrSealed, 0)
+	}
+	if row.Compression != NoCompression {
+		dst = append(dst, attrCompressed, 1, byte(row.Compression))
+	}
+	if row.mergeBase.size != 0 {
+		dst = append(dst, attrMergeBase, 14)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(row.mergeBase.offset))
+		dst = binary.BigEndian.AppendUint32(dst, uint32(row.mergeBase.size))
+		dst = binary.BigEndian.AppendUint16(dst, uint16(row.mergeBase.depth))
+	}
+	if row.valueRef.Size() != 0 {
+		dst = append(dst, attrValueRef, 12)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(row.valueRef.Offset()))
+		dst = binary.BigEndian.AppendUint32(dst, uint32(row.valueRef.Size()))
+	}
+	if row.Actor != "" {
+		dst = append(dst, attrActor, byte(len(row.Actor)))
+		dst = append(dst, row.Actor...)
+	}
+	if row.ContentType != "" {
+		dst = append(dst, attrContentType, byte(len(row.ContentType)))
+		dst = append(dst, row.ContentType...)
+	}
+	if row.Checksum != 0 {
+		dst = append(dst, attrChecksum, 4)
+		dst = binary.BigEndian.AppendUint32(dst, row.Checksum)
+	}
+	return dst
+}
+
+// appendBinaryAttrs appends the row attributes encoded by the binary format to dst:
+// the length of long keys is not part of the row header.
+func (row *Row) appendBinaryAttrs(dst []byte) []byte {
+	dst = row.appendAttrs(dst)
+	if len(row.Key) > maxShortKeyLength {
+		dst = append(dst, attrKeyLength, 2)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(len(row.Key)))
+	}
+	return dst
+}
+
+// headerKeyLength returns the key length written in the row header (0 for long keys, see attrKeyLength).
+func (row *Row) headerKeyLength() byte {
+	if len(row.Key) > maxShortKeyLength {
+		return 0
+	}
+	return byte(len(row.Key))
+}
+
+// decodeAttrs sets the row attributes from their encoded form.
+// It returns the key length of long keys (0 if the key length is in the row header).
+func (row *Row) decodeAttrs(attrs []byte) (keyLength int, err error) {
+	for len(attrs) > 0 {
+		if len(attrs) < 2 || len(attrs) < 2+int(attrs[1]) {
+			return 0, errors.New("truncated attribute")
+		}
+		tag, data := attrs[0], attrs[2:2+int(attrs[1])]
+		attrs = attrs[2+len(data):]
+		switch {
+		case (tag == attrTimestamp || tag == attrExpiresAt) && len(data) != 8:
+			return 0, fmt.Errorf("invalid attribute 0x%02x length: %d", tag, len(data))
+		case tag == attrChecksum && len(data) != 4:
+			return 0, fmt.Errorf("invalid attribute 0x%02x length: %d", tag, len(data))
+		case tag == attrCompressed && len(data) != 1:
+			return 0, fmt.Errorf("invalid attribute 0x%02x length: %d", tag, len(data))
+		case tag == attrKeyLength && len(data) != 2:
+			return 0, fmt.Errorf("invalid attribute 0x%02x length: %d", tag, len(data))
+		case tag == attrKeyLength:
+			keyLength = int(binary.BigEndian.Uint16(data))
+		case tag == attrCompressed:
+			row.Compression = Compression(data[0])
+		case tag == attrMergeBase && len(data) != 14:
+			return 0, fmt.Errorf("invalid attribute 0x%02x length: %d", tag, len(data))
+		case tag == attrMergeBase:
+			row.mergeBase = mergeLink{
+				offset: int(binary.BigEndian.Uint64(data)),
+				size:   int(binary.BigEndian.Uint32(data[8:])),
+				depth:  int(binary.BigEndian.Uint16(data[12:])),
+			}
+		case tag == attrValueRef && len(data) != 12:
+			return 0, fmt.Errorf("invalid attribute 0x%02x length: %d", tag, len(data))
+		case tag == attrValueRef:
+			row.valueRef = fidx.Position{int(binary.BigEndian.Uint64(data)), int(binary.BigEndian.Uint32(data[8:]))}
+		case tag == attrChecksum:
+			row.Checksum = binary.BigEndian.Uint32(data)
+		case tag == attrActor:
+			row.Actor = string(data)
+		case tag == attrContentType:
+			row.ContentType = string(data)
+		case tag == attrTimestamp:
+			row.Timestamp = int64(binary.BigEndian.Uint64(data))
+		case tag == attrExpiresAt:
+			row.ExpiresAt = int64(binary.BigEndian.Uint64(data))
+		case tag == attrAlias:
+			row.IsAlias = true
+		case tag == attrSealed:
+			row.IsSealed = true
+		case tag >= attrCritical:
+			return 0, fmt.Errorf("%w: 0x%02x", ErrUnknownAttribute, tag)
+		}
+	}
+	return keyLength, nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksumMismatch is returned when reading a row whose checksum doesn't match its content.
+var ErrChecksumMismatch = errors.New("row checksum mismatch")
+
+// byteValues holds all byte values (to get a one-byte slice without allocating).
+var byteValues = func() (values [256]byte) {
+	for i := range values {
+		values[i] = byte(i)
+	}
+	return values
+}()
+
+// computeChecksum returns the non-zero CRC-32C of the row key length, key and value.
+func (row *Row) computeChecksum() uint32 {
+	sum := checksumKeyLength(0, len(row.Key))
+	sum = crc32.Update(sum, castagnoli, row.Key)
+	if sum = crc32.Update(sum, castagnoli, row.Value); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// checksumKeyLength updates the given checksum with the key length: its byte for short keys,
+// or 0 followed by its 2 bytes (big-endian) for long keys (see attrKeyLength).
+func checksumKeyLength(sum uint32, keyLength int) uint32 {
+	if keyLength <= maxShortKeyLength {
+		return crc32.Update(sum, castagnoli, byteValues[keyLength:keyLength+1])
+	}
+	return crc32.Update(sum, castagnoli, []byte{0, byte(keyLength >> 8), byte(keyLength)})
+}
+
+// VerifyChecksum returns an error wrapping ErrChecksumMismatch if the row has a checksum
+// that doesn't match its key and value.
+func (row *Row) VerifyChecksum() error {
+	if row.Checksum != 0 && row.Checksum != row.computeChecksum() {
+		return fmt.Errorf("%w for key %q", ErrChecksumMismatch, row.Key)
+	}
 	return nil
 }
 
@@ -50,15 +293,22 @@ func (row *Row) Encode() ([]byte, error) {
 	if err := row.Validate(); err != nil {
 		return nil, err
 	}
+	attrs := row.appendBinaryAttrs(nil)
+	if len(attrs) > maxAttrsLength {
+		return nil, fmt.Errorf("attributes too long: %d", len(attrs))
+	}
 
 	// Write header (op, key-length and value-length)
-	op := opSet
-	if row.IsDeleted {
-		op = opDelete
-	}
-	encoded := []byte{op, uint8(len(row.Key))}
+	encoded := make([]byte, 0, row.EncodedSize())
+	encoded = append(encoded, row.op(len(attrs) > 0), row.headerKeyLength())
 	encoded = binary.BigEndian.AppendUint32(encoded, uint32(len(row.Value)))
 
+	// Write attributes (length-prefixed)
+	if len(attrs) > 0 {
+		encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(attrs)))
+		encoded = append(encoded, attrs...)
+	}
+
 	// Write key and value
 	encoded = append(encoded, row.Key...)
 	encoded = append(encoded, row.Value...)
@@ -66,6 +316,68 @@ func (row *Row) Encode() ([]byte, error) {
 	return encoded, nil
 }
 
+// encodeTombstone encodes the delete row as a compact tombstone (see WithCompactTombstones):
+// op, key-length, attributes (length-prefixed, if any) and key.
+func (row *Row) encodeTombstone() ([]byte, error) {
+	if err := row.Validate(); err != nil {
+		return nil, err
+	}
+	attrs := row.appendBinaryAttrs(nil)
+	if len(attrs) > maxAttrsLength {
+		return nil, fmt.Errorf("attributes too long: %d", len(attrs))
+	}
+	encoded := make([]byte, 0, row.encodedTombstoneSize())
+	if len(attrs) > 0 {
+		encoded = append(encoded, opTombstoneWithAttrs, row.headerKeyLength())
+		encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(attrs)))
+		encoded = append(encoded, attrs...)
+	} else {
+		encoded = append(encoded, opTombstone, row.headerKeyLength())
+	}
+	return append(encoded, row.Key...), nil
+}
+
+// encodedTombstoneSize returns the number of bytes of the row encoded as a compact tombstone.
+func (row *Row) encodedTombstoneSize() int {
+	return row.EncodedSize() - (rowHeaderSize - tombstoneHeaderSize)
+}
+
+// isTombstoneOp reports whether the op starts a compact tombstone (and whether attributes follow).
+func isTombstoneOp(op byte) (bool, bool) {
+	return op == opTombstone || op == opTombstoneWithAttrs, op == opTombstoneWithAttrs
+}
+
+// op returns the character encoding the row operation.
+func (row *Row) op(withAttrs bool) byte {
+	switch {
+	case row.IsDeleted && withAttrs:
+		return opDeleteWithAttrs
+	case row.IsDeleted:
+		return opDelete
+	case row.IsMerge && withAttrs:
+		return opMergeWithAttrs
+	case row.IsMerge:
+		return opMerge
+	case withAttrs:
+		return opSetWithAttrs
+	}
+	return opSet
+}
+
+// decodeOp returns an empty row for the given op and reports whether attributes follow.
+func decodeOp(op byte) (Row, bool, error) {
+	switch op {
+	case opSet, opDelete, opMerge:
+		return Row{IsDeleted: op == opDelete, IsMerge: op == opMerge}, false, nil
+	case opSetWithAttrs, opDeleteWithAttrs, opMergeWithAttrs:
+		return Row{IsDeleted: op == opDeleteWithAttrs, IsMerge: op == opMergeWithAttrs}, true, nil
+	}
+	if isEncryptedOp(op) {
+		return Row{}, false, fmt.Errorf("%w (missing encryption key)", ErrEncryptedFile)
+	}
+	return Row{}, false, fmt.Errorf("%w: %q", ErrUnknownOp, op)
+}
+
 // DecodeFrom decodes a row from the given reader into the caller.
 // It reports the number of bytes read from the reader and an eventual error.
 //
@@ -73,16 +385,55 @@ func (row *Row) Encode() ([]byte, error) {
 func (row *Row) DecodeFrom(r io.Reader) (int, error) {
 	read := 0
 
-	// Read header (op, key-length and value-length)
-	header := [1 + 1 + 4]byte{}
-	n, err := io.ReadFull(r, header[:])
+	// Read header (op, key-length and value-length, except for compact tombstones)
+	header := [rowHeaderSize]byte{}
+	n, err := io.ReadFull(r, header[:tombstoneHeaderSize])
 	read += n
 	if err != nil {
 		return read, fmt.Errorf("read header: %w", err)
 	}
+	var decoded Row
+	isTombstone, hasAttrs := isTombstoneOp(header[0])
+	if isTombstone {
+		decoded.IsDeleted = true
+	} else {
+		n, err = io.ReadFull(r, header[tombstoneHeaderSize:])
+		read += n
+		if err != nil {
+			return read, fmt.Errorf("read header: %w", orUnexpectedEOF(err))
+		}
+		decoded, hasAttrs, err = decodeOp(header[0])
+		if err != nil {
+			return read, err
+		}
+	}
+
+	// Read attributes
+	keyLength := int(header[1])
+	if hasAttrs {
+		attrsLength := [2]byte{}
+		n, err = io.ReadFull(r, attrsLength[:])
+		read += n
+		if err != nil {
+			return read, fmt.Errorf("read attributes length: %w", err)
+		}
+		attrs := make([]byte, binary.BigEndian.Uint16(attrsLength[:]))
+		n, err = io.ReadFull(r, attrs)
+		read += n
+		if err != nil {
+			return read, fmt.Errorf("read attributes: %w", err)
+		}
+		longKeyLength, err := decoded.decodeAttrs(attrs)
+		if err != nil {
+			return read, fmt.Errorf("decode attributes: %w", err)
+		}
+		if longKeyLength > 0 {
+			keyLength = longKeyLength
+		}
+	}
 
 	// Read key
-	key := make([]byte, uint8(header[1]))
+	key := make([]byte, keyLength)
 	n, err = io.ReadFull(r, key)
 	read += n
 	if err != nil {
@@ -90,15 +441,61 @@ func (row *Row) DecodeFrom(r io.Reader) (int, error) {
 	}
 
 	// Read value
-	value := make([]byte, binary.BigEndian.Uint32(header[2:]))
-	n, err = io.ReadFull(r, value)
+	value, n, err := readFull(r, int(binary.BigEndian.Uint32(header[2:])))
 	read += n
 	if err != nil {
 		return read, fmt.Errorf("read value: %w", err)
 	}
 
-	row.IsDeleted = header[0] == opDelete
-	row.Key = key
-	row.Value = value
+	decoded.Key = key
+	decoded.Value = value
+	*row = decoded
 	return read, nil
 }
+
+// decodeInPlace decodes the binary encoded row (see Row.Encode) without copying:
+// the key and value of the row reference the encoded row.
+func (row *Row) decodeInPlace(encoded []byte) error {
+	if len(encoded) < tombstoneHeaderSize {
+		return io.ErrUnexpectedEOF
+	}
+	var decoded Row
+	var rest []byte
+	isTombstone, hasAttrs := isTombstoneOp(encoded[0])
+	if isTombstone {
+		decoded.IsDeleted, rest = true, encoded[tombstoneHeaderSize:]
+	} else if len(encoded) < rowHeaderSize {
+		return io.ErrUnexpectedEOF
+	} else {
+		var err error
+		decoded, hasAttrs, err = decodeOp(encoded[0])
+		if err != nil {
+			return err
+		}
+		rest = encoded[rowHeaderSize:]
+	}
+	keyLength, valueLength := int(encoded[1]), 0
+	if hasAttrs {
+		if len(rest) < 2 || len(rest) < 2+int(binary.BigEndian.Uint16(rest)) {
+			return io.ErrUnexpectedEOF
+		}
+		attrsLength := int(binary.BigEndian.Uint16(rest))
+		longKeyLength, err := decoded.decodeAttrs(rest[2 : 2+attrsLength])
+		if err != nil {
+			return fmt.Errorf("decode attributes: %w", err)
+		}
+		if longKeyLength > 0 {
+			keyLength = longKeyLength
+		}
+		rest = rest[2+attrsLength:]
+	}
+	if !isTombstone {
+		valueLength = int(binary.BigEndian.Uint32(encoded[2:]))
+	}
+	if len(rest) != keyLength+valueLength {
+		return fmt.Errorf("got %d bytes for key and value instead of %d", len(rest), keyLength+valueLength)
+	}
+	decoded.Key, decoded.Value = rest[:keyLength], rest[keyLength:]
+	*row = decoded
+	return nil
+}
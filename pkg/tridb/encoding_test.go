@@ -2,6 +2,10 @@ package tridb
 
 import (
 	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"path/filepath"
 	"testing"
 )
 
@@ -26,6 +30,24 @@ func TestEncoding(t *testing.T) {
 			row:     &Row{IsDeleted: true, Key: []byte("Key")},
 			encoded: []byte{opDelete, 3, 0, 0, 0, 0, 'K', 'e', 'y'},
 		},
+		{
+			desc: "encode set row with timestamp",
+			row:  &Row{Key: []byte("K"), Value: []byte("V"), Timestamp: 258},
+			encoded: []byte{
+				opSetWithAttrs, 1, 0, 0, 0, 1, // header
+				0, 10, attrTimestamp, 8, 0, 0, 0, 0, 0, 0, 1, 2, // attributes
+				'K', 'V',
+			},
+		},
+		{
+			desc: "encode set row with expiration time",
+			row:  &Row{Key: []byte("K"), Value: []byte("V"), ExpiresAt: 3},
+			encoded: []byte{
+				opSetWithAttrs, 1, 0, 0, 0, 1, // header
+				0, 10, attrExpiresAt, 8, 0, 0, 0, 0, 0, 0, 0, 3, // attributes
+				'K', 'V',
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -43,15 +65,165 @@ func TestEncoding(t *testing.T) {
 			if err != nil {
 				t.Fatalf("decode: %s", err)
 			}
+			if size := test.row.EncodedSize(); size != len(test.encoded) {
+				t.Fatalf("got encoded size %d instead of %d", size, len(test.encoded))
+			}
 			if n != len(test.encoded) {
 				t.Fatalf("got decoding read size %d instead of %d", n, len(test.encoded))
 			}
 			isSameOp := gotDecoded.IsDeleted == test.row.IsDeleted
 			isSameKey := bytes.Equal(gotDecoded.Key, test.row.Key)
 			isSameValue := bytes.Equal(gotDecoded.Value, test.row.Value)
-			if !isSameOp || !isSameKey || !isSameValue {
+			isSameTimestamp := gotDecoded.Timestamp == test.row.Timestamp
+			if !isSameOp || !isSameKey || !isSameValue || !isSameTimestamp {
 				t.Fatalf("got decoded row %+v instead of %+v", gotDecoded, test.row)
 			}
 		})
 	}
 }
+
+func TestCompactTombstones(t *testing.T) {
+	for _, row := range []*Row{
+		{IsDeleted: true, Key: []byte("key")},
+		{IsDeleted: true, Key: []byte("key"), Timestamp: 1},
+	} {
+		encoded, err := row.encodeTombstone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		classic, _ := row.Encode()
+		if len(encoded) != len(classic)-4 || len(encoded) != row.encodedTombstoneSize() {
+			t.Fatalf("got %d bytes instead of %d", len(encoded), len(classic)-4)
+		}
+		for _, decode := range []func(*Row) error{
+			func(got *Row) error { _, err := got.DecodeFrom(bytes.NewReader(encoded)); return err },
+			func(got *Row) error { return got.decodeInPlace(encoded) },
+		} {
+			got := &Row{}
+			if err := decode(got); err != nil {
+				t.Fatal(err)
+			}
+			if !got.IsDeleted || string(got.Key) != "key" || len(got.Value) != 0 || got.Timestamp != row.Timestamp {
+				t.Fatalf("got row %+v", got)
+			}
+		}
+	}
+
+	// Files written with compact tombstones are read without the option.
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1, WithCompactTombstones(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "b", "2")
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("a"))
+		if want := (&Row{IsDeleted: true, Key: []byte("a"), Timestamp: 1}).encodedTombstoneSize(); w.PendingBytes() != want {
+			t.Fatalf("got %d pending bytes instead of %d", w.PendingBytes(), want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	f, err = Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	assertValue(t, f, "a", "")
+	assertValue(t, f, "b", "2")
+}
+
+func TestLongKeys(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 1000)
+	for _, row := range []*Row{
+		{Key: key, Value: []byte("value")},
+		{Key: key, Value: []byte("value"), Timestamp: 1, Checksum: 2},
+		{IsDeleted: true, Key: key},
+	} {
+		encoders := []func() ([]byte, error){row.Encode}
+		if row.IsDeleted {
+			encoders = append(encoders, row.encodeTombstone)
+		}
+		for _, encode := range encoders {
+			encoded, err := encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoded[1] != 0 {
+				t.Fatalf("got header key length %d instead of 0", encoded[1])
+			}
+			for _, decode := range []func(*Row) error{
+				func(got *Row) error { _, err := got.DecodeFrom(bytes.NewReader(encoded)); return err },
+				func(got *Row) error { return got.decodeInPlace(encoded) },
+			} {
+				got := &Row{}
+				if err := decode(got); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Key, key) || !bytes.Equal(got.Value, row.Value) || got.Timestamp != row.Timestamp {
+					t.Fatalf("got row %+v", got)
+				}
+			}
+		}
+	}
+
+	// Long keys are persisted, and the configured limits are enforced on write.
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1, WithMaxKeyLength(1000), WithMaxValueLength(10), WithRowChecksums(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, string(key), "value")
+	for _, test := range []struct {
+		key, value string
+		want       error
+	}{
+		{string(key) + "k", "value", ErrKeyTooLong},
+		{"key", "value too long", ErrValueTooLong},
+	} {
+		err := f.ReadWrite(func(r *Reader, w *Writer) error { w.Set([]byte(test.key), []byte(test.value)); return nil })
+		if !errors.Is(err, test.want) {
+			t.Fatalf("got error %v instead of %v", err, test.want)
+		}
+	}
+	f.Close()
+	if _, err := Open(fpath, 1, WithMaxKeyLength(MaxKeyLength+1)); !errors.Is(err, ErrKeyTooLong) {
+		t.Fatalf("got error %v instead of %v", err, ErrKeyTooLong)
+	}
+	f, err = Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	assertValue(t, f, string(key), "value")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, string(key), "value")
+}
+
+func TestLongKeyChecksum(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 300)
+	row := &Row{Key: key, Value: []byte("value")}
+	want := crc32.Update(0, castagnoli, []byte{0, 1, 44}) // header byte, then the length attribute
+	want = crc32.Update(crc32.Update(want, castagnoli, key), castagnoli, row.Value)
+	if got := row.computeChecksum(); got != want {
+		t.Fatalf("got checksum %x instead of %x", got, want)
+	}
+
+	// Streamed values are verified with the same checksum.
+	f := openTestFile(t, WithMaxKeyLength(len(key)), WithRowChecksums(true))
+	mustSet(t, f, string(key), "value")
+	vr, err := f.GetReader(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vr.Close()
+	if got, err := io.ReadAll(vr); err != nil || string(got) != "value" {
+		t.Fatalf("got value %q (%v) instead of %q", got, err, "value")
+	}
+}
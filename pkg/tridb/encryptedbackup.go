@@ -0,0 +1,216 @@
+package tridb
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Encrypted backups (see File.BackupEncrypted) are encoded as: a header (magic, version and random stream ID)
+// followed by frames holding chunks of the datafile, each frame is encoded as: flags (1 byte), length of
+// the sealed chunk (4 bytes) and sealed chunk (random nonce, chunk encrypted with AES-GCM and authentication tag).
+//
+// The stream ID, the index of the frame and its flags are authenticated with each chunk so that frames
+// can't be reordered, dropped or spliced from another backup. The last frame holds the SHA-256 checksum of the datafile.
+const (
+	encryptedBackupMagic      = "TRIDBBAK"
+	encryptedBackupVersion    = 1
+	encryptedBackupIDSize     = 16
+	encryptedBackupHeaderSize = len(encryptedBackupMagic) + 1 + encryptedBackupIDSize
+	encryptedBackupChunkSize  = 64 * 1024
+	encryptedBackupFrameLast  = 1 << 0
+)
+
+// ErrInvalidBackup is returned when restoring an encrypted backup that is truncated, tampered with
+// or encrypted with another key (see RestoreEncrypted).
+var ErrInvalidBackup = errors.New("invalid encrypted backup")
+
+// BackupEncrypted copies the datafile to dst as an encrypted and authenticated stream
+// (see RestoreEncrypted), so that backups stored off-site don't leak data and tampering is detected on restore.
+// The key must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256).
+//
+// Like CopyTo, the backup holds the rows committed when it starts and doesn't block writers.
+// It reports the number of bytes written to dst.
+func (f *File) BackupEncrypted(dst io.Writer, key []byte) (int, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return 0, fmt.Errorf("invalid backup key: %w", err)
+	}
+	w := &encryptedBackupWriter{dst: dst, aead: aead, checksum: sha256.New()}
+	if err := w.writeHeader(); err != nil {
+		return w.n, err
+	}
+	if _, err := f.CopyTo(w); err != nil {
+		return w.n, err
+	}
+	if err := w.Close(); err != nil {
+		return w.n, err
+	}
+	return w.n, nil
+}
+
+// encryptedBackupWriter encrypts the data written to it in frames (see File.BackupEncrypted).
+type encryptedBackupWriter struct {
+	dst      io.Writer
+	aead     cipher.AEAD
+	id       [encryptedBackupIDSize]byte
+	index    uint64
+	chunk    []byte    // pending data (sealed once full)
+	checksum hash.Hash // of the datafile
+	n        int       // bytes written to dst
+}
+
+func (w *encryptedBackupWriter) writeHeader() error {
+	if _, err := rand.Read(w.id[:]); err != nil {
+		return fmt.Errorf("read random stream ID: %w", err)
+	}
+	header := make([]byte, 0, encryptedBackupHeaderSize)
+	header = append(header, encryptedBackupMagic...)
+	header = append(header, encryptedBackupVersion)
+	header = append(header, w.id[:]...)
+	n, err := w.dst.Write(header)
+	w.n += n
+	if err != nil {
+		return fmt.Errorf("write backup header: %w", err)
+	}
+	return nil
+}
+
+func (w *encryptedBackupWriter) Write(p []byte) (int, error) {
+	w.checksum.Write(p)
+	written := 0
+	for len(p) > 0 {
+		m := min(len(p), encryptedBackupChunkSize-len(w.chunk))
+		w.chunk = append(w.chunk, p[:m]...)
+		p, written = p[m:], written+m
+		if len(w.chunk) == encryptedBackupChunkSize {
+			if err := w.writeFrame(0, w.chunk); err != nil {
+				return written, err
+			}
+			w.chunk = w.chunk[:0]
+		}
+	}
+	return written, nil
+}
+
+// Close writes the pending data and the last frame (holding the checksum of the datafile).
+func (w *encryptedBackupWriter) Close() error {
+	if len(w.chunk) > 0 {
+		if err := w.writeFrame(0, w.chunk); err != nil {
+			return err
+		}
+		w.chunk = w.chunk[:0]
+	}
+	return w.writeFrame(encryptedBackupFrameLast, w.checksum.Sum(nil))
+}
+
+func (w *encryptedBackupWriter) writeFrame(flags byte, data []byte) error {
+	sealed := sealValue(w.aead, data, encryptedBackupFrameData(w.id, w.index, flags))
+	frame := make([]byte, 0, 1+4+len(sealed))
+	frame = append(frame, flags)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(sealed)))
+	frame = append(frame, sealed...)
+	n, err := w.dst.Write(frame)
+	w.n += n
+	if err != nil {
+		return fmt.Errorf("write backup frame %d: %w", w.index, err)
+	}
+	w.index++
+	return nil
+}
+
+// encryptedBackupFrameData returns the additional data authenticated with a frame.
+func encryptedBackupFrameData(id [encryptedBackupIDSize]byte, index uint64, flags byte) []byte {
+	data := make([]byte, 0, encryptedBackupIDSize+8+1)
+	data = append(data, id[:]...)
+	data = binary.BigEndian.AppendUint64(data, index)
+	return append(data, flags)
+}
+
+// RestoreEncrypted creates a new database file at fpath holding the datafile of the given encrypted backup
+// (see File.BackupEncrypted), decrypted with the given key.
+//
+// ErrInvalidBackup is returned if the backup is truncated, was tampered with or was encrypted with another key,
+// the file is then removed.
+func RestoreEncrypted(src io.Reader, fpath string, key []byte) (err error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return fmt.Errorf("invalid backup key: %w", err)
+	}
+	dst, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return fmt.Errorf("create restored file: %w", err)
+	}
+	defer func() {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(fpath)
+		}
+	}()
+	if err := decryptBackup(src, dst, aead); err != nil {
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		return fmt.Errorf("sync restored file: %w", err)
+	}
+	return nil
+}
+
+// decryptBackup writes the datafile of the given encrypted backup to dst.
+func decryptBackup(src io.Reader, dst io.Writer, aead cipher.AEAD) error {
+	header := [encryptedBackupHeaderSize]byte{}
+	if _, err := io.ReadFull(src, header[:]); err != nil {
+		return fmt.Errorf("%w: read header: %w", ErrInvalidBackup, err)
+	}
+	if !bytes.HasPrefix(header[:], []byte(encryptedBackupMagic)) {
+		return fmt.Errorf("%w: not an encrypted backup", ErrInvalidBackup)
+	}
+	if version := header[len(encryptedBackupMagic)]; version != encryptedBackupVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidBackup, version)
+	}
+	id := [encryptedBackupIDSize]byte(header[len(encryptedBackupMagic)+1:])
+
+	checksum := sha256.New()
+	maxSealedSize := aead.NonceSize() + encryptedBackupChunkSize + aead.Overhead()
+	for index := uint64(0); ; index++ {
+		frameHeader := [1 + 4]byte{}
+		if _, err := io.ReadFull(src, frameHeader[:]); err != nil {
+			return fmt.Errorf("%w: read frame %d: %w", ErrInvalidBackup, index, orUnexpectedEOF(err))
+		}
+		flags, size := frameHeader[0], int(binary.BigEndian.Uint32(frameHeader[1:]))
+		if size > maxSealedSize {
+			return fmt.Errorf("%w: frame %d is too long (%d bytes)", ErrInvalidBackup, index, size)
+		}
+		sealed := make([]byte, size)
+		if _, err := io.ReadFull(src, sealed); err != nil {
+			return fmt.Errorf("%w: read frame %d: %w", ErrInvalidBackup, index, orUnexpectedEOF(err))
+		}
+		data, err := openValue(aead, sealed, encryptedBackupFrameData(id, index, flags))
+		if err != nil {
+			return fmt.Errorf("%w: decrypt frame %d: %w", ErrInvalidBackup, index, err)
+		}
+		if flags&encryptedBackupFrameLast == 0 {
+			checksum.Write(data)
+			if _, err := dst.Write(data); err != nil {
+				return fmt.Errorf("write restored file: %w", err)
+			}
+			continue
+		}
+		if !bytes.Equal(data, checksum.Sum(nil)) {
+			return fmt.Errorf("%w: checksum mismatch", ErrInvalidBackup)
+		}
+		if _, err := io.ReadFull(src, make([]byte, 1)); err != io.EOF {
+			return fmt.Errorf("%w: unexpected data after the last frame", ErrInvalidBackup)
+		}
+		return nil
+	}
+}
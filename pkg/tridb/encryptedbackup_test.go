@@ -0,0 +1,59 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedBackup(t *testing.T) {
+	f := openTestFile(t)
+	key := bytes.Repeat([]byte{1}, 32)
+	mustSet(t, f, "a", "secret")
+	mustSet(t, f, "b", strings.Repeat("x", 3*encryptedBackupChunkSize/2)) // spans several frames
+
+	backup := &bytes.Buffer{}
+	if _, err := f.BackupEncrypted(backup, key); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(backup.Bytes(), []byte("secret")) {
+		t.Fatal("the backup holds plaintext values")
+	}
+
+	// The backup can be restored with its key.
+	fpath := filepath.Join(t.TempDir(), "restored.tridb")
+	if err := RestoreEncrypted(bytes.NewReader(backup.Bytes()), fpath, key); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	assertValue(t, restored, "a", "secret")
+
+	// Tampered, truncated or extended backups (and other keys) are rejected.
+	tampered := bytes.Clone(backup.Bytes())
+	tampered[encryptedBackupHeaderSize+10] ^= 1
+	otherKey := bytes.Repeat([]byte{2}, 32)
+	for name, test := range map[string]struct {
+		backup []byte
+		key    []byte
+	}{
+		"tampered":  {tampered, key},
+		"truncated": {backup.Bytes()[:backup.Len()-1], key},
+		"no last":   {backup.Bytes()[:encryptedBackupHeaderSize+5+12+encryptedBackupChunkSize+16], key},
+		"extended":  {append(bytes.Clone(backup.Bytes()), 0), key},
+		"other key": {backup.Bytes(), otherKey},
+	} {
+		fpath := filepath.Join(t.TempDir(), "restored.tridb")
+		if err := RestoreEncrypted(bytes.NewReader(test.backup), fpath, test.key); !errors.Is(err, ErrInvalidBackup) {
+			t.Fatalf("%s: got error %v instead of %v", name, err, ErrInvalidBackup)
+		}
+		if _, err := Open(fpath, 1, WithReadOnly()); err == nil {
+			t.Fatalf("%s: the restored file wasn't removed", name)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package tridb
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// expiryIndex is a min-heap of the rows of the keydir that have an expiration time, ordered by expiration time,
+// so that expiring keys are found without scanning all keys (see Reader.WalkExpiringBefore).
+// Rows expiring because of prefix TTL policies are not indexed (their prefixes are walked instead).
+type expiryIndex struct {
+	rows      []*fidx.RowInfo
+	positions map[*fidx.RowInfo]int // index of rows in the heap
+}
+
+func (x *expiryIndex) Len() int           { return len(x.rows) }
+func (x *expiryIndex) Less(i, j int) bool { return x.rows[i].ExpiresAt < x.rows[j].ExpiresAt }
+func (x *expiryIndex) Swap(i, j int) {
+	x.rows[i], x.rows[j] = x.rows[j], x.rows[i]
+	x.positions[x.rows[i]], x.positions[x.rows[j]] = i, j
+}
+func (x *expiryIndex) Push(v any) {
+	x.positions[v.(*fidx.RowInfo)] = len(x.rows)
+	x.rows = append(x.rows, v.(*fidx.RowInfo))
+}
+func (x *expiryIndex) Pop() any {
+	row := x.rows[len(x.rows)-1]
+	x.rows[len(x.rows)-1], x.rows = nil, x.rows[:len(x.rows)-1]
+	delete(x.positions, row)
+	return row
+}
+
+// update indexes the given row according to its (possibly updated) expiration time.
+func (x *expiryIndex) update(row *fidx.RowInfo) {
+	if x.positions == nil {
+		x.positions = map[*fidx.RowInfo]int{}
+	}
+	i, indexed := x.positions[row]
+	switch {
+	case indexed && row.ExpiresAt == 0:
+		heap.Remove(x, i)
+	case indexed:
+		heap.Fix(x, i)
+	case row.ExpiresAt != 0:
+		heap.Push(x, row)
+	}
+}
+
+// remove removes the given row (deleted from the keydir) from the index.
+func (x *expiryIndex) remove(row *fidx.RowInfo) {
+	if i, indexed := x.positions[row]; indexed {
+		heap.Remove(x, i)
+	}
+}
+
+// reset indexes the rows of the given keydir.
+func (x *expiryIndex) reset(idx fidx.Keydir) {
+	x.rows, x.positions = nil, map[*fidx.RowInfo]int{}
+	for row := idx.Chronological().Oldest; row != nil; row = row.Next {
+		if row.ExpiresAt != 0 {
+			x.positions[row] = len(x.rows)
+			x.rows = append(x.rows, row)
+		}
+	}
+	heap.Init(x)
+}
+
+// walk calls do for each indexed row expiring before the given time, in expiration order, without modifying the heap:
+// the heap nodes to visit next are kept in a second heap (a node expires after its parent).
+func (x *expiryIndex) walk(before int64, do func(row *fidx.RowInfo) error) error {
+	next := &heapNodes{x: x}
+	if len(x.rows) > 0 {
+		next.nodes = []int{0}
+	}
+	for len(next.nodes) > 0 {
+		i := heap.Pop(next).(int)
+		if x.rows[i].ExpiresAt >= before {
+			continue // so are its children
+		}
+		if err := do(x.rows[i]); err != nil {
+			return err
+		}
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(x.rows) {
+				heap.Push(next, child)
+			}
+		}
+	}
+	return nil
+}
+
+// heapNodes is a min-heap of nodes of an expiry index (see expiryIndex.walk).
+type heapNodes struct {
+	x     *expiryIndex
+	nodes []int
+}
+
+func (h *heapNodes) Len() int           { return len(h.nodes) }
+func (h *heapNodes) Less(i, j int) bool { return h.x.Less(h.nodes[i], h.nodes[j]) }
+func (h *heapNodes) Swap(i, j int)      { h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i] }
+func (h *heapNodes) Push(v any)         { h.nodes = append(h.nodes, v.(int)) }
+func (h *heapNodes) Pop() any {
+	i := h.nodes[len(h.nodes)-1]
+	h.nodes = h.nodes[:len(h.nodes)-1]
+	return i
+}
+
+// NextExpiry returns the earliest expiration time of the keys (see SetWithTTL and SetPrefixTTL),
+// it reports false if no key expires.
+func (r *Reader) NextExpiry() (time.Time, bool) {
+	r.checkDeadline()
+	next := int64(0)
+	_ = r.walkExpiring(math.MaxInt64, func(row *fidx.RowInfo, expiresAt int64) error {
+		if expiresAt <= r.now {
+			return nil // expired keys are hidden
+		}
+		next = expiresAt
+		return ErrBreak
+	})
+	if next == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, next), true
+}
+
+// WalkExpiringBefore calls do for each key expiring before the given time (see SetWithTTL and SetPrefixTTL),
+// in expiration order (see Walk for errors). Expired keys are not visited.
+//
+// Keys with an expiration time are indexed by expiration time,
+// keys expiring because of prefix TTL policies are found by walking the prefixes of the policies.
+func (r *Reader) WalkExpiringBefore(t time.Time, do func(key []byte) error) error {
+	if r.f.opts.KeySecret != nil {
+		return ErrHashedKeys
+	}
+	r.checkDeadline()
+	err := r.walkExpiring(t.UnixNano(), func(row *fidx.RowInfo, expiresAt int64) error {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		if expiresAt <= r.now {
+			return nil
+		}
+		key, err := r.rowKey(row)
+		if err != nil {
+			return err
+		}
+		return do(key)
+	})
+	return ignoreBreak(err)
+}
+
+// expiringRow is a row with its expiration time (see Reader.expiresAt).
+type expiringRow struct {
+	row       *fidx.RowInfo
+	expiresAt int64
+}
+
+// walkExpiring calls do for each key expiring before the given time in Unix nanoseconds, in expiration order,
+// including expired keys.
+func (r *Reader) walkExpiring(before int64, do func(row *fidx.RowInfo, expiresAt int64) error) error {
+	// Keys expiring by policy are collected and sorted, then merged with the indexed keys.
+	var byPolicy []expiringRow
+	r.walkPolicies(func(row *fidx.RowInfo) {
+		if expiresAt := r.expiresAt(row); expiresAt < before && expiresAt != row.ExpiresAt {
+			byPolicy = append(byPolicy, expiringRow{row, expiresAt})
+		}
+	})
+	sort.Slice(byPolicy, func(i, j int) bool { return byPolicy[i].expiresAt < byPolicy[j].expiresAt })
+	err := r.walkExpirationTimes(before, func(row *fidx.RowInfo) error {
+		if r.expiresAt(row) != row.ExpiresAt {
+			return nil // expires earlier by policy
+		}
+		for ; len(byPolicy) > 0 && byPolicy[0].expiresAt <= row.ExpiresAt; byPolicy = byPolicy[1:] {
+			if err := do(byPolicy[0].row, byPolicy[0].expiresAt); err != nil {
+				return err
+			}
+		}
+		return do(row, row.ExpiresAt)
+	})
+	if err != nil {
+		return err
+	}
+	for _, expiring := range byPolicy {
+		if err := do(expiring.row, expiring.expiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkExpirationTimes calls do for each key with an expiration time before the given time, in expiration order.
+// The expiry index is only maintained for the keydir of the file, other keydirs (ex: snapshots) are scanned.
+func (r *Reader) walkExpirationTimes(before int64, do func(row *fidx.RowInfo) error) error {
+	if r.expiring == 0 {
+		return nil
+	}
+	if r.idx == r.f.idx {
+		return r.f.expiries.walk(before, do)
+	}
+	var rows []*fidx.RowInfo
+	for row := r.idx.Chronological().Oldest; row != nil; row = row.Next {
+		if row.ExpiresAt != 0 && row.ExpiresAt < before {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ExpiresAt < rows[j].ExpiresAt })
+	for _, row := range rows {
+		if err := do(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package tridb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+func TestWalkExpiringBefore(t *testing.T) {
+	f := openTestFile(t, WithKeydir(KeydirTrie))
+	if err := f.SetPrefixTTL([]byte("cache/"), 3*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetWithDeadline([]byte("expired"), []byte("0"), now.Add(-time.Hour))
+		w.SetWithDeadline([]byte("session/b"), []byte("1"), now.Add(2*time.Hour))
+		w.SetWithDeadline([]byte("session/a"), []byte("2"), now.Add(time.Hour))
+		w.SetWithDeadline([]byte("session/c"), []byte("3"), now.Add(5*time.Hour))
+		w.Set([]byte("cache/x"), []byte("4"))
+		w.SetWithDeadline([]byte("cache/y"), []byte("5"), now.Add(4*time.Hour)) // expires earlier by policy
+		w.Set([]byte("forever"), []byte("6"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "session/b", "overwritten") // no longer expires
+
+	check := func(read func(do func(r *Reader) error) error) {
+		t.Helper()
+		_ = read(func(r *Reader) error {
+			var got []string
+			err := r.WalkExpiringBefore(now.Add(4*time.Hour), func(key []byte) error {
+				got = append(got, string(key))
+				return nil
+			})
+			if want := []string{"session/a", "cache/x", "cache/y"}; err != nil || !reflect.DeepEqual(got, want) {
+				t.Fatalf("got expiring keys %q (%v) instead of %q", got, err, want)
+			}
+			if next, ok := r.NextExpiry(); !ok || next.Sub(now) <= 0 || next.Sub(now) > time.Hour {
+				t.Fatalf("got next expiry in %s (%v) instead of an hour", next.Sub(now), ok)
+			}
+			return nil
+		})
+	}
+	check(f.Read)
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check(f.Read)
+
+	snap, err := f.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	check(snap.Read) // the keydir of snapshots is scanned
+	if n, err := f.SweepExpired(); err != nil || n != 0 {
+		t.Fatalf("swept %d keys (%v), the expired key should have been dropped by compaction", n, err)
+	}
+}
+
+func TestExpiryIndex(t *testing.T) {
+	x := &expiryIndex{}
+	rows := map[int64]*fidx.RowInfo{}
+	for _, expiresAt := range []int64{5, 3, 9, 1, 7, 4} {
+		rows[expiresAt] = &fidx.RowInfo{ExpiresAt: expiresAt}
+		x.update(rows[expiresAt])
+	}
+	rows[9].ExpiresAt = 2
+	x.update(rows[9])
+	rows[3].ExpiresAt = 0
+	x.update(rows[3])
+	x.remove(rows[5])
+
+	var got []int64
+	_ = x.walk(8, func(row *fidx.RowInfo) error {
+		got = append(got, row.ExpiresAt)
+		return nil
+	})
+	if want := []int64{1, 2, 4, 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got expiration times %v instead of %v", got, want)
+	}
+	if x.Len() != 4 || len(x.positions) != 4 {
+		t.Fatalf("got %d indexed rows instead of 4", x.Len())
+	}
+}
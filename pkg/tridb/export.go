@@ -0,0 +1,263 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// ExportFormat identifies the format of File.ExportTo and ImportFrom.
+type ExportFormat uint8
+
+// Available export formats.
+const (
+	// ExportJSONL writes one JSON object per key-value pair, for example:
+	//	{"key":"users/1","value":"alice"}
+	ExportJSONL ExportFormat = iota
+	// ExportCSV writes a "key,value" header followed by one record per key-value pair.
+	ExportCSV
+)
+
+// ExportBase64 can be combined with an export format (ex: ExportCSV|ExportBase64)
+// to encode keys and values in base64 (standard encoding with padding), for binary data.
+// Otherwise, keys and values must be valid UTF-8.
+const ExportBase64 ExportFormat = 1 << 7
+
+// ErrBadImport is returned when importing invalid data.
+var ErrBadImport = errors.New("bad import")
+
+// exportRecord is a key-value pair of the JSONL format.
+type exportRecord struct {
+	Key   *string `json:"key"`
+	Value *string `json:"value"`
+}
+
+var csvHeader = []string{"key", "value"}
+
+// ExportTo writes all key-value pairs to w in the given format, keys in lexicographical order.
+func (f *File) ExportTo(w io.Writer, format ExportFormat) error {
+	encode, kind := exportEncoder(format)
+	if kind != ExportJSONL && kind != ExportCSV {
+		return fmt.Errorf("unknown export format: %d", format)
+	}
+	return f.Read(func(r *Reader) error {
+		bufw := bufio.NewWriter(w)
+		csvw := csv.NewWriter(bufw)
+		jsonw := json.NewEncoder(bufw)
+		jsonw.SetEscapeHTML(false)
+		if kind == ExportCSV {
+			if err := csvw.Write(csvHeader); err != nil {
+				return err
+			}
+		}
+		_, err := r.WalkWithValue(nil, func(key, value []byte) error {
+			encodedKey, err := encode(key)
+			if err != nil {
+				return fmt.Errorf("encode key %q: %w", key, err)
+			}
+			encodedValue, err := encode(value)
+			if err != nil {
+				return fmt.Errorf("encode value of %q: %w", key, err)
+			}
+			if kind == ExportCSV {
+				return csvw.Write([]string{encodedKey, encodedValue})
+			}
+			return jsonw.Encode(exportRecord{Key: &encodedKey, Value: &encodedValue})
+		})
+		if err != nil {
+			return err
+		}
+		if csvw.Flush(); csvw.Error() != nil {
+			return csvw.Error()
+		}
+		return bufw.Flush()
+	})
+}
+
+// ImportFrom reads key-value pairs written in the given format (see File.ExportTo)
+// and sets them in a single transaction. Nothing is written if the input is invalid.
+// It reports the number of imported key-value pairs.
+//
+// Existing keys are overwritten, see ImportWithOptions for other conflict strategies.
+func ImportFrom(src io.Reader, f *File, format ExportFormat) (int, error) {
+	report, err := ImportWithOptions(src, f, format, ImportOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return report.Imported + report.Overwritten + report.Unchanged, nil
+}
+
+// ImportConflict defines how imports handle keys that already exist in the destination with a different value.
+type ImportConflict uint8
+
+// Available conflict strategies.
+const (
+	ImportOverwrite ImportConflict = iota // Replace the existing value (default).
+	ImportSkip                            // Keep the existing value.
+	ImportFail                            // Abort the import (nothing is written) with ErrImportConflict.
+	ImportMerge                           // Set the value returned by ImportOptions.Merge.
+)
+
+// ErrImportConflict is returned when importing a key that already exists with ImportFail.
+var ErrImportConflict = errors.New("import conflict")
+
+// ImportOptions configures ImportWithOptions.
+type ImportOptions struct {
+	OnConflict ImportConflict
+	// Merge returns the value to set for a key that exists in the destination (with ImportMerge),
+	// returning an error aborts the import.
+	Merge func(key, existing, imported []byte) ([]byte, error)
+}
+
+// ImportReport summarizes the decisions applied to the imported keys.
+type ImportReport struct {
+	Imported    int // Number of keys that didn't exist in the destination.
+	Unchanged   int // Number of existing keys with the same value (not written again).
+	Overwritten int
+	Skipped     int
+	Merged      int
+}
+
+// ImportWithOptions is like ImportFrom but resolves conflicts with existing keys with the given strategy
+// and reports the decisions applied. Keys are compared with the destination as it was before the import.
+func ImportWithOptions(src io.Reader, f *File, format ExportFormat, opts ImportOptions) (ImportReport, error) {
+	if opts.OnConflict == ImportMerge && opts.Merge == nil {
+		return ImportReport{}, errors.New("missing merge function")
+	}
+	var keys, values [][]byte
+	err := scanExported(src, format, func(line int, key, value []byte) error {
+		keys, values = append(keys, key), append(values, value)
+		return nil
+	})
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	var report ImportReport
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		report = ImportReport{}
+		for i, key := range keys {
+			value := values[i]
+			existing, err := r.Get(key)
+			if err != nil {
+				return fmt.Errorf("get %q: %w", key, err)
+			}
+			switch {
+			case existing == nil:
+				report.Imported++
+			case bytes.Equal(existing, value):
+				report.Unchanged++
+				continue
+			case opts.OnConflict == ImportSkip:
+				report.Skipped++
+				continue
+			case opts.OnConflict == ImportFail:
+				return fmt.Errorf("%w: %q", ErrImportConflict, key)
+			case opts.OnConflict == ImportMerge:
+				value, err = opts.Merge(key, existing, value)
+				if err != nil {
+					return fmt.Errorf("merge %q: %w", key, err)
+				}
+				report.Merged++
+			default:
+				report.Overwritten++
+			}
+			w.Set(key, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportReport{}, err
+	}
+	return report, nil
+}
+
+// scanExported calls do for each key-value pair read from src in the given format (see File.ExportTo),
+// it stops at the first invalid line or error returned by do.
+func scanExported(src io.Reader, format ExportFormat, do func(line int, key, value []byte) error) error {
+	_, kind := exportEncoder(format)
+	add := func(line int, key, value string) error {
+		decodedKey, err := decodeExported(format, key)
+		if err != nil {
+			return fmt.Errorf("%w: line %d: invalid key: %w", ErrBadImport, line, err)
+		}
+		decodedValue, err := decodeExported(format, value)
+		if err != nil {
+			return fmt.Errorf("%w: line %d: invalid value: %w", ErrBadImport, line, err)
+		}
+		return do(line, decodedKey, decodedValue)
+	}
+
+	switch kind {
+	case ExportJSONL:
+		scanner := bufio.NewScanner(src)
+		scanner.Buffer(nil, math.MaxInt) // encoded values may be longer than MaxValueLength
+		for line := 1; scanner.Scan(); line++ {
+			record := exportRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return fmt.Errorf("%w: line %d: %w", ErrBadImport, line, err)
+			}
+			if record.Key == nil || record.Value == nil {
+				return fmt.Errorf("%w: line %d: missing key or value", ErrBadImport, line)
+			}
+			if err := add(line, *record.Key, *record.Value); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	case ExportCSV:
+		csvr := csv.NewReader(src)
+		csvr.FieldsPerRecord = len(csvHeader)
+		csvr.ReuseRecord = true
+		header, err := csvr.Read()
+		if err != nil || header[0] != csvHeader[0] || header[1] != csvHeader[1] {
+			return fmt.Errorf("%w: missing %q header", ErrBadImport, csvHeader)
+		}
+		for {
+			record, err := csvr.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrBadImport, err)
+			}
+			line, _ := csvr.FieldPos(0)
+			if err := add(line, record[0], record[1]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown export format: %d", format)
+	}
+	return nil
+}
+
+// exportEncoder returns the function encoding keys and values for the given format,
+// and the format without the base64 flag.
+func exportEncoder(format ExportFormat) (func([]byte) (string, error), ExportFormat) {
+	if format&ExportBase64 != 0 {
+		return func(b []byte) (string, error) { return base64.StdEncoding.EncodeToString(b), nil }, format &^ ExportBase64
+	}
+	return func(b []byte) (string, error) {
+		if !utf8.Valid(b) {
+			return "", errors.New("invalid UTF-8 (see ExportBase64)")
+		}
+		return string(b), nil
+	}, format
+}
+
+func decodeExported(format ExportFormat, s string) ([]byte, error) {
+	if format&ExportBase64 != 0 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
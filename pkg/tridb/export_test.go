@@ -0,0 +1,108 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExportAndImport(t *testing.T) {
+	src := openTestFile(t)
+	mustSet(t, src, "b", "line 1\nline 2, \"quoted\"")
+	mustSet(t, src, "a", "<html>")
+	mustSet(t, src, "c", "")
+
+	for name, test := range map[string]struct {
+		format ExportFormat
+		want   string
+	}{
+		"jsonl":        {ExportJSONL, `{"key":"a","value":"<html>"}` + "\n"},
+		"csv":          {ExportCSV, "key,value\na,<html>\n"},
+		"jsonl base64": {ExportJSONL | ExportBase64, `{"key":"YQ==","value":"PGh0bWw+"}` + "\n"},
+		"csv base64":   {ExportCSV | ExportBase64, "key,value\nYQ==,PGh0bWw+\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			exported := &bytes.Buffer{}
+			if err := src.ExportTo(exported, test.format); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(exported.String(), test.want) {
+				t.Fatalf("unexpected export:\n%s", exported)
+			}
+
+			dst := openTestFile(t)
+			if n, err := ImportFrom(bytes.NewReader(exported.Bytes()), dst, test.format); err != nil || n != 3 {
+				t.Fatalf("imported %d key-value pairs (%v) instead of 3", n, err)
+			}
+			assertValue(t, dst, "a", "<html>")
+			assertValue(t, dst, "b", "line 1\nline 2, \"quoted\"")
+			assertValue(t, dst, "c", "")
+		})
+	}
+
+	// Binary values need base64
+	mustSet(t, src, "d", "\xff")
+	if err := src.ExportTo(&bytes.Buffer{}, ExportCSV); err == nil {
+		t.Fatal("binary value exported without base64")
+	}
+	exported := &bytes.Buffer{}
+	if err := src.ExportTo(exported, ExportCSV|ExportBase64); err != nil {
+		t.Fatal(err)
+	}
+	dst := openTestFile(t)
+	if _, err := ImportFrom(exported, dst, ExportCSV|ExportBase64); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, dst, "d", "\xff")
+
+	// Invalid inputs are rejected (and nothing is written)
+	for _, input := range []struct {
+		format ExportFormat
+		data   string
+	}{
+		{ExportJSONL, `{"key":"x","value":"1"}` + "\n" + `{"key":"y"}`},
+		{ExportCSV, "k,v\nx,1\n"},
+		{ExportCSV, "key,value\nx,1,2\n"},
+		{ExportCSV | ExportBase64, "key,value\nx,!\n"},
+	} {
+		dst := openTestFile(t)
+		if _, err := ImportFrom(strings.NewReader(input.data), dst, input.format); !errors.Is(err, ErrBadImport) {
+			t.Fatalf("got error %v instead of %v for %q", err, ErrBadImport, input.data)
+		}
+		assertValue(t, dst, "x", "")
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	input := "key,value\na,new\nb,same\nc,new\n"
+	for name, test := range map[string]struct {
+		opts  ImportOptions
+		want  ImportReport
+		wantA string
+		err   error
+	}{
+		"overwrite": {ImportOptions{}, ImportReport{Imported: 1, Unchanged: 1, Overwritten: 1}, "new", nil},
+		"skip":      {ImportOptions{OnConflict: ImportSkip}, ImportReport{Imported: 1, Unchanged: 1, Skipped: 1}, "old", nil},
+		"fail":      {ImportOptions{OnConflict: ImportFail}, ImportReport{}, "old", ErrImportConflict},
+		"merge": {ImportOptions{OnConflict: ImportMerge, Merge: func(key, existing, imported []byte) ([]byte, error) {
+			return append(append(existing, '+'), imported...), nil
+		}}, ImportReport{Imported: 1, Unchanged: 1, Merged: 1}, "old+new", nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst := openTestFile(t)
+			mustSet(t, dst, "a", "old")
+			mustSet(t, dst, "b", "same")
+			report, err := ImportWithOptions(strings.NewReader(input), dst, ExportCSV, test.opts)
+			if !errors.Is(err, test.err) || report != test.want {
+				t.Fatalf("got report %+v (%v) instead of %+v (%v)", report, err, test.want, test.err)
+			}
+			assertValue(t, dst, "a", test.wantA)
+			if test.err != nil {
+				assertValue(t, dst, "c", "")
+			} else {
+				assertValue(t, dst, "c", "new")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+//go:build tridb_expvar
+
+package tridb
+
+import (
+	"expvar"
+	"strconv"
+	"time"
+)
+
+// Built with the tridb_expvar build tag (ex: go build -tags tridb_expvar), the measurements of every file
+// (see Metrics and LockMetrics) are added up and published with expvar under "tridb",
+// without depending on package tridbmetrics.
+
+// expvarBuckets are the upper bounds of the latency histogram buckets published with expvar.
+var expvarBuckets = []time.Duration{
+	100 * time.Microsecond, time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second, 10 * time.Second,
+}
+
+func init() {
+	vars := expvar.NewMap("tridb")
+	m := &expvarMetrics{
+		commits:        new(expvar.Int),
+		rows:           new(expvar.Int),
+		bytes:          new(expvar.Int),
+		compacted:      new(expvar.Int),
+		syncs:          newExpvarHistogram(),
+		reads:          newExpvarHistogram(),
+		compactions:    newExpvarHistogram(),
+		readLockWaits:  newExpvarHistogram(),
+		writeLockWaits: newExpvarHistogram(),
+	}
+	vars.Set("written_commits", m.commits)
+	vars.Set("written_rows", m.rows)
+	vars.Set("written_bytes", m.bytes)
+	vars.Set("compacted_bytes", m.compacted)
+	vars.Set("syncs", m.syncs)
+	vars.Set("reads", m.reads)
+	vars.Set("compactions", m.compactions)
+	vars.Set("read_lock_waits", m.readLockWaits)
+	vars.Set("write_lock_waits", m.writeLockWaits)
+	builtinMetrics = m
+}
+
+// expvarMetrics implements Metrics and LockMetrics with expvar counters and histograms.
+type expvarMetrics struct {
+	commits, rows, bytes, compacted *expvar.Int
+	syncs, reads, compactions       *expvar.Map
+	readLockWaits, writeLockWaits   *expvar.Map
+}
+
+// newExpvarHistogram returns a latency histogram: the number of observations less than or equal to each bucket bound
+// (ex: "le_0.001" for 1ms), the total number of observations ("count") and their sum ("sum_seconds").
+func newExpvarHistogram() *expvar.Map {
+	h := new(expvar.Map).Init()
+	for _, bound := range expvarBuckets {
+		h.Set(expvarBucket(bound), new(expvar.Int))
+	}
+	h.Set("count", new(expvar.Int))
+	h.Set("sum_seconds", new(expvar.Float))
+	return h
+}
+
+func expvarBucket(bound time.Duration) string {
+	return "le_" + strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+}
+
+func observe(h *expvar.Map, d time.Duration) {
+	for _, bound := range expvarBuckets {
+		if d <= bound {
+			h.Add(expvarBucket(bound), 1)
+		}
+	}
+	h.Add("count", 1)
+	h.AddFloat("sum_seconds", d.Seconds())
+}
+
+func (m *expvarMetrics) Written(rows, bytes int) {
+	m.commits.Add(1)
+	m.rows.Add(int64(rows))
+	m.bytes.Add(int64(bytes))
+}
+
+func (m *expvarMetrics) Synced(d time.Duration) { observe(m.syncs, d) }
+
+func (m *expvarMetrics) Read(d time.Duration) { observe(m.reads, d) }
+
+func (m *expvarMetrics) Compacted(d time.Duration, before, after int) {
+	observe(m.compactions, d)
+	if before > after {
+		m.compacted.Add(int64(before - after))
+	}
+}
+
+func (m *expvarMetrics) LockWaited(write bool, d time.Duration) {
+	if write {
+		observe(m.writeLockWaits, d)
+	} else {
+		observe(m.readLockWaits, d)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build tridb_expvar
+
+package tridb
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestExpvarMetrics(t *testing.T) {
+	var before, after struct {
+		WrittenRows   int `json:"written_rows"`
+		ReadLockWaits struct {
+			Count int `json:"count"`
+		} `json:"read_lock_waits"`
+		Syncs map[string]float64 `json:"syncs"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("tridb").String()), &before); err != nil {
+		t.Fatal(err)
+	}
+	f := openTestFile(t)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("a"), []byte("1"))
+		w.Set([]byte("b"), []byte("2"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Read(func(r *Reader) error { return nil })
+	if err := json.Unmarshal([]byte(expvar.Get("tridb").String()), &after); err != nil {
+		t.Fatal(err)
+	}
+	if after.WrittenRows-before.WrittenRows != 2 || after.ReadLockWaits.Count-before.ReadLockWaits.Count != 1 {
+		t.Fatalf("got expvar metrics %+v (before: %+v)", after, before)
+	}
+	if after.Syncs["count"] <= before.Syncs["count"] || after.Syncs["le_10"] != after.Syncs["count"] {
+		t.Fatalf("got sync histogram %v", after.Syncs)
+	}
+}
@@ -3,77 +3,535 @@ package tridb
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ejuju/tridb/pkg/fidx"
 )
 
 // File holds key-value pairs.
 type File struct {
-	mu         sync.RWMutex
-	fpath      string
-	numBuckets int
-	idx        *fidx.LHTIndex
-	r, w       *os.File
-	woffset    int
+	mu           sync.RWMutex
+	freeze       sync.RWMutex // held for reading by commits and compactions (see FreezeWrites)
+	compacting   sync.Mutex   // serializes compactions
+	fpath        string
+	numBuckets   int
+	idx          fidx.Keydir
+	sys          fidx.Keydir // keydir for keys in the reserved keyspace
+	store        Storage     // rows of the file (see WithStorage)
+	mapping      *mapping    // memory mapping of the file (see WithMmapReads)
+	lock         *os.File    // lock file held while the file is open for writing (see LockFileExtension)
+	woffset      int
+	numRows      int // number of rows in the file (including overwritten and deleted ones)
+	opts         Options
+	given        Options // options given to OpenWithOptions (see Reopen)
+	format       Format  // format of the rows (detected when opening an existing file)
+	prefixTTLs   []prefixTTL
+	frozen       [][]byte // frozen prefixes (see FreezePrefix)
+	quotas       []*prefixQuota
+	expiring     int                          // number of keys with an expiration time
+	expiries     expiryIndex                  // keys with an expiration time (see Reader.WalkExpiringBefore)
+	contentTypes map[string]*contentTypeCount // statistics of keys by content type
+	liveBytes    int                          // size of the rows holding the current value of keys
+	headerSize   int                          // size of the file header (0 for files predating it)
+	commits      int                          // number of committed transactions and batches since the file was opened
+	seqBase      int                          // number of rows discarded by compactions (see File.Seq)
+	seqHorizon   uint64                       // sequence of the last compaction, earlier states are discarded (see File.ReadAt)
+	compactions  int                          // number of compactions since the file was opened
+	compactedAt  time.Time                    // end of the last compaction (zero if none since the file was opened)
+	compactRate  float64                      // bytes of source file compacted per second by the last compaction
+	appended     broadcast                    // notified when rows are appended or the file is replaced (see ServeReplication)
+	failMu       sync.Mutex
+	failure      error         // set when a corruption was recovered by SafeReadWrite
+	dirty        bool          // written but not synced yet (see SyncInterval)
+	stopLoop     chan struct{} // stops the background loop (see SyncInterval and WithTail)
+	loopDone     chan struct{}
+	stopSweep    chan struct{} // stops the expired keys sweeper (see WithExpirySweeper)
+	sweepDone    chan struct{}
+	group        groupCommit
+	syncs        syncStats // durations of recent syncs (see Stats.SyncLatency)
+	watchers     []*watcher
+	keyring      *keyring // data keys of encrypted prefixes (nil without WithPrefixEncryption)
+	indexes      map[string]*secondaryIndex
+	checkpoint   int           // end of the bytes verified when the file was opened (or compacted)
+	tail         hash.Hash64   // hash of the bytes appended since the checkpoint (see CleanShutdownFileExtension)
+	merge        MergeOperator // resolves merge rows (see SetMergeOperator)
+	hooks        atomic.Pointer[keyHooks]
+	values       map[valueDigest]fidx.Position // rows holding the deduplicated values (see WithValueDedup)
 }
 
-// Open opens the database file.
-func Open(fpath string, numBuckets int) (*File, error) {
-	if numBuckets <= 0 {
-		numBuckets = 1
+// DefaultNumBuckets is the number of buckets of the hash keydir when none is configured.
+const DefaultNumBuckets = 1024
+
+// DefaultBufferSize is the size of the read and write buffers when none is configured (see WithBufferSizes).
+const DefaultBufferSize = 4096
+
+// ErrReadOnly is returned when writing to a file opened in read-only mode.
+var ErrReadOnly = errors.New("read-only file")
+
+// ErrClosed is returned when using a closed file.
+var ErrClosed = errors.New("file closed")
+
+// ErrTxnAborted wraps the errors aborting a transaction (or batch) before anything is written:
+// the error returned by the callback, invalid writes, failed conditions (see Writer.SetIf) or exceeded quotas.
+var ErrTxnAborted = errors.New("transaction aborted")
+
+// abort wraps an error aborting a transaction.
+func abort(err error) error { return fmt.Errorf("%w: %w", ErrTxnAborted, err) }
+
+// Open opens the database file with the given initial number of hash keydir buckets and options
+// (buckets are added as keys are, see fidx.MaxLoadFactor).
+func Open(fpath string, numBuckets int, opts ...Option) (*File, error) {
+	o := &Options{NumBuckets: numBuckets}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return OpenWithOptions(fpath, o)
+}
+
+// OpenWithRecovery is like Open but recovers from a corrupt end of file (see WithCorruptTailRepair),
+// the dropped bytes are logged (unless a recovery hook is given, see WithRecoveryHook).
+func OpenWithRecovery(fpath string, numBuckets int, opts ...Option) (*File, error) {
+	o := &Options{NumBuckets: numBuckets, RecoveryHook: logRecovery}
+	for _, opt := range append(opts, WithCorruptTailRepair(true)) {
+		opt(o)
+	}
+	return OpenWithOptions(fpath, o)
+}
+
+// OpenReadOnly opens an existing database file in read-only mode (see WithReadOnly).
+// The file is never created nor truncated, so multiple processes can open it concurrently
+// (for example, to run reports against a live backup).
+func OpenReadOnly(fpath string, opts ...Option) (*File, error) {
+	return Open(fpath, 0, append(opts, WithReadOnly())...)
+}
+
+// OpenWithOptions opens the database file with the given options (nil means default options).
+func OpenWithOptions(fpath string, opts *Options) (_ *File, err error) {
+	f := &File{fpath: fpath, contentTypes: map[string]*contentTypeCount{}}
+	if opts != nil {
+		f.opts = *opts
+	}
+	f.given = f.opts
+	if f.opts.NumBuckets <= 0 {
+		f.opts.NumBuckets = DefaultNumBuckets
+	}
+	if f.opts.Compression != NoCompression && f.opts.CompressionThreshold <= 0 {
+		f.opts.CompressionThreshold = DefaultCompressionThreshold
+	}
+	if f.opts.ReadBufferSize <= 0 {
+		f.opts.ReadBufferSize = DefaultBufferSize
+	}
+	if f.opts.WriteBufferSize <= 0 {
+		f.opts.WriteBufferSize = DefaultBufferSize
+	}
+	if f.opts.Sync == SyncInterval && f.opts.SyncInterval <= 0 {
+		f.opts.SyncInterval = DefaultSyncInterval
+	}
+	if f.opts.Sync == SyncGroup && f.opts.SyncInterval <= 0 {
+		f.opts.SyncInterval = DefaultGroupCommitLatency
+	}
+	if builtinMetrics != nil && f.opts.Metrics != nil {
+		f.opts.Metrics = teeMetrics{builtinMetrics, f.opts.Metrics}
+	} else if builtinMetrics != nil {
+		f.opts.Metrics = builtinMetrics
+	}
+	if f.opts.SlowSyncThreshold <= 0 {
+		f.opts.SlowSyncThreshold = DefaultSlowSyncThreshold
+	}
+	if f.opts.LongTransactionHandler == nil {
+		f.opts.LongTransactionHandler = logLongTransaction
+	}
+	if f.opts.MaxKeyLength <= 0 {
+		f.opts.MaxKeyLength = MaxKeyLength
+	} else if f.opts.MaxKeyLength > MaxKeyLength {
+		return nil, fmt.Errorf("%w: max key length %d exceeds %d", ErrKeyTooLong, f.opts.MaxKeyLength, MaxKeyLength)
+	}
+	if f.opts.MaxValueLength <= 0 {
+		f.opts.MaxValueLength = MaxValueLength
+	} else if f.opts.MaxValueLength > MaxValueLength {
+		return nil, fmt.Errorf("%w: max value length %d exceeds %d", ErrValueTooLong, f.opts.MaxValueLength, MaxValueLength)
+	}
+	for op, handler := range f.opts.CustomOps {
+		if !isCustomOp(op) || handler.Apply == nil {
+			return nil, fmt.Errorf("invalid custom op %#x: ops start at %#x and must have an Apply handler", op, MinCustomOp)
+		}
+	}
+	f.group.cond = sync.NewCond(&f.group.mu)
+	if f.opts.MasterKey != nil {
+		f.keyring, err = loadKeyring(fpath+KeyringFileExtension, f.opts.MasterKey)
+		if err != nil {
+			return nil, fmt.Errorf("load keyring: %w", err)
+		}
+	}
+	f.numBuckets = f.opts.NumBuckets
+	f.idx, f.sys = f.newKeydir(), fidx.NewTrieIndex()
+
+	if f.opts.Storage == nil {
+		f.opts.Storage = OpenFileStorage
+	}
+	if f.opts.ReadOnly {
+		// Only open a read handle (the file must exist)
+		f.store, err = f.opts.Storage(f.fpath, StorageReadOnly)
+		if err != nil {
+			return nil, fmt.Errorf("open datafile: %w", err)
+		}
+	} else {
+		// Make sure no other process writes to the file
+		if !f.opts.DisableLocking {
+			f.lock, err = lockFile(f.fpath, f.opts.LockTimeout)
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err != nil {
+					f.lock.Close()
+				}
+			}()
+		}
+
+		// Remove file possibly left over from a crash during last compaction (unless it can be resumed).
+		if _, err := f.storageSize(f.fpath + compactionManifestExtension); err != nil {
+			err = f.EnsureNoCompactingFile()
+			if err != nil {
+				return nil, fmt.Errorf("ensure no compacting file: %w", err)
+			}
+		}
+
+		// Open two file handlers (one in read-only, one in write-only)
+		f.store, err = f.opts.Storage(f.fpath, StorageReadWrite)
+		if err != nil {
+			return nil, fmt.Errorf("open datafile: %w", err)
+		}
+	}
+	defer func() {
+		if err != nil {
+			f.store.Close()
+		}
+	}()
+
+	// Detect row format (the configured format is only used for new files and to resolve ambiguities)
+	err = f.detectFormat(io.NewSectionReader(f.store, 0, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+	if !f.opts.ReadOnly {
+		err = f.initHeader()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = f.loadHeaderSize()
+	if err != nil {
+		return nil, err
 	}
-	f := &File{fpath: fpath, idx: fidx.NewLHTIndex(numBuckets), numBuckets: numBuckets}
 
-	// Remove file possibly left over from a crash during last compaction.
-	err := f.EnsureNoCompactingFile()
+	// Reconstruct in-memory state (= keydir: where keys/rows are located in the file),
+	// from the clean shutdown marker if the file was properly closed,
+	// or from the keydir snapshot and the rows appended since (see WithKeydirSnapshot).
+	if !f.usesCleanShutdownMarker() || !f.loadCleanShutdownMarker() {
+		offset, numRows := 0, 0
+		if f.opts.KeydirSnapshot && f.usesCleanShutdownMarker() && f.loadKeydirSnapshot() {
+			offset, numRows = f.woffset, f.numRows
+		}
+		f.resetOps()
+		f.woffset, f.numRows, err = f.replayWithOps(f.store, offset, f.idx, f.sys)
+		f.numRows += numRows
+		if err != nil && offset > 0 {
+			// Fall back to a full replay
+			f.idx, f.sys, f.liveBytes, f.expiring = f.newKeydir(), fidx.NewTrieIndex(), 0, 0
+			f.expiries.reset(f.idx)
+			clear(f.contentTypes)
+			f.resetOps()
+			f.woffset, f.numRows, err = f.replayWithOps(f.store, 0, f.idx, f.sys)
+		}
+		if err != nil && f.opts.RepairCorruptTail && !f.opts.ReadOnly && f.woffset > 0 {
+			err = nil // the undecodable bytes are handled like a torn tail
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !f.opts.ReadOnly {
+		err = f.removeCleanShutdownMarker()
+		if err != nil {
+			return nil, err
+		}
+	}
+	f.resetCheckpoint()
+
+	// Handle eventual torn row or batch (see WithRecoveryHook)
+	if !f.opts.ReadOnly {
+		err = f.recoverTail()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = f.loadPolicies()
 	if err != nil {
-		return nil, fmt.Errorf("ensure no compacting file: %w", err)
+		return nil, err
+	}
+	err = f.loadSeq()
+	if err != nil {
+		return nil, err
 	}
+	f.updateMapping()
+	err = f.indexValues()
+	if err != nil {
+		return nil, err
+	}
+
+	if f.opts.Sync == SyncInterval && !f.opts.ReadOnly {
+		f.stopLoop, f.loopDone = make(chan struct{}), make(chan struct{})
+		go f.syncLoop()
+	} else if f.opts.ReadOnly && f.opts.TailInterval > 0 {
+		f.stopLoop, f.loopDone = make(chan struct{}), make(chan struct{})
+		go f.tailLoop()
+	}
+	if f.opts.SweepInterval > 0 && !f.opts.ReadOnly {
+		f.stopSweep, f.sweepDone = make(chan struct{}), make(chan struct{})
+		go f.sweepLoop()
+	}
+	return f, nil
+}
 
-	// Open two file handlers (one in read-only, one in write-only)
-	f.r, f.w, err = openFileRW(f.fpath)
+// detectFormat sets the row format from the start of the given file.
+// Files with a header must use the configured format (if any), unless their format is upgraded (see WithFormatUpgrade).
+func (f *File) detectFormat(r io.Reader) error {
+	if f.opts.EncryptionKey != nil {
+		f.opts.Format = BinaryEncoding // rows are encrypted in binary format (see WithEncryption)
+	}
+	probe, err := io.ReadAll(io.LimitReader(r, formatProbeSize))
+	if err != nil {
+		return fmt.Errorf("detect format: %w", err)
+	}
+	h, ok, err := readFileHeader(bufio.NewReader(bytes.NewReader(probe)))
+	if err != nil {
+		return fmt.Errorf("detect format: %w", err)
+	}
+	if ok && f.opts.Format != nil && f.opts.UpgradeFormat == nil && h.format != f.opts.Format.Name() {
+		return fmt.Errorf("%w: file uses the %q format instead of %q", ErrBadFileFormat, h.format, f.opts.Format.Name())
+	}
+	format, err := DetectFormat(bytes.NewReader(probe), f.opts.Format)
+	if f.opts.EncryptionKey != nil && (errors.Is(err, ErrEncryptedFile) || (err == nil && format == BinaryEncoding)) {
+		format, err = newEncryptedFormat(f.opts.EncryptionKey)
+	} else if f.opts.EncryptionKey != nil && err == nil {
+		err = fmt.Errorf("encryption requires the binary format, got %q", format.Name())
+	}
 	if err != nil {
-		return nil, fmt.Errorf("open datafile: %w", err)
+		return fmt.Errorf("detect format: %w", err)
+	}
+	if format == BinaryEncoding && f.opts.CompactTombstones {
+		format = binaryFormat{compactTombstones: true}
 	}
+	f.format = format
+	return nil
+}
 
-	// Reconstruct in-memory state (= keydir: where keys/rows are located in the file)
-	bufr := bufio.NewReader(f.r)
-	row := Row{}
-	for {
-		n, err := row.DecodeFrom(bufr)
-		f.woffset += n
+// replay decodes rows from r (positioned at the given offset) and applies them to the given keydirs,
+// until the end of the reader or until maxRows rows are applied (if maxRows is not negative).
+// It reports the offset after the last applied row and the number of rows applied.
+//
+// Replay stops before a torn row or batch frame at the end of the reader.
+func (f *File) replay(r *bufio.Reader, offset, maxRows int, idx, sys fidx.Keydir) (int, int, error) {
+	return f.scanRows(r, offset, maxRows, func(row *Row, p fidx.Position) { f.applyRow(row, p, idx, sys) })
+}
+
+// scanRows is like replay but calls do for each row (the row must not be retained).
+func (f *File) scanRows(r *bufio.Reader, offset, maxRows int, do func(row *Row, p fidx.Position)) (int, int, error) {
+	return f.scanRowsWhile(r, offset, maxRows, func(row *Row, p fidx.Position) bool { do(row, p); return true })
+}
+
+// scanRowsWhile is like scanRows but stops before the first row for which do reports false.
+// Scanning then stops at the start of the row, or at the start of the batch frame holding it.
+func (f *File) scanRowsWhile(r *bufio.Reader, offset, maxRows int, do func(row *Row, p fidx.Position) bool) (int, int, error) {
+	return f.scanFrames(r, offset, maxRows, do, nil)
+}
+
+// scanFrames is like scanRowsWhile but also calls doOp with the rows of custom ops (skipped if nil, see WithCustomOp).
+func (f *File) scanFrames(r *bufio.Reader, offset, maxRows int, do func(row *Row, p fidx.Position) bool, doOp func(op byte, payload []byte) error) (int, int, error) {
+	row, numRows := Row{}, 0
+	if offset == 0 {
+		h, ok, err := readFileHeader(r)
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok {
+			offset = h.size()
+		}
+	}
+	for maxRows < 0 || numRows < maxRows {
+		if op, err := r.Peek(1); err == nil && isCustomOp(op[0]) {
+			op, payload, n, err := readCustomOp(r)
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				break // torn row
+			}
+			if err != nil {
+				return offset, numRows, fmt.Errorf("decode op at offset %d: %w", offset, err)
+			}
+			if doOp != nil {
+				if err := doOp(op, payload); err != nil {
+					return offset, numRows, fmt.Errorf("apply op at offset %d: %w", offset, err)
+				}
+			}
+			offset += n
+			continue
+		}
+		if op, err := r.Peek(1); err == nil && op[0] == opBatch {
+			rows, n, err := decodeBatchFrom(f.format, r)
+			if errors.Is(err, errTornBatch) {
+				break
+			}
+			if err != nil {
+				return offset, numRows, fmt.Errorf("decode batch at offset %d: %w", offset, err)
+			}
+			end, stopped := offset+n, false
+			for _, batchRow := range rows {
+				if maxRows >= 0 && numRows >= maxRows {
+					end = offset + batchRow.position.Offset()
+					break
+				}
+				p := batchRow.position
+				if !do(&batchRow.row, fidx.Position{offset + p.Offset(), p.Size()}) {
+					end, stopped = offset, true
+					break
+				}
+				numRows++
+			}
+			if stopped {
+				return end, numRows, nil
+			}
+			offset = end
+			continue
+		}
+
+		n, err := f.format.DecodeFrom(r, &row)
 		if n == 0 && errors.Is(err, io.EOF) {
 			break // OK, we reached the end of the row (and it didn't happen in the middle of a row)
 		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break // torn row
+		}
 		if err != nil {
-			return nil, fmt.Errorf("decode row at offset %d: %w", f.woffset, err)
+			return offset, numRows, fmt.Errorf("decode row at offset %d: %w", offset, err)
 		}
-		if row.IsDeleted {
-			f.idx.Delete(row.Key)
-		} else {
-			f.idx.Put(row.Key, fidx.Position{f.woffset - n, n})
+		if !do(&row, fidx.Position{offset, n}) {
+			break
 		}
+		offset += n
+		numRows++
 	}
+	return offset, numRows, nil
+}
 
-	return f, nil
+// applyRow updates the keydir (or the reserved keydir) with the given row.
+func (f *File) applyRow(row *Row, p fidx.Position, idx, sys fidx.Keydir) {
+	key := f.indexKey(row.Key)
+	if IsReservedKey(row.Key) {
+		idx = sys
+	} else if idx == f.idx {
+		for _, index := range f.indexes {
+			index.update(f, row)
+		}
+	}
+	live := idx == f.idx || idx == f.sys // statistics are only kept for the keydirs of the file
+	if row.IsDeleted {
+		if deleted := idx.Delete(key); deleted != nil && live {
+			f.liveBytes -= deleted.Position.Size()
+			if idx == f.idx {
+				f.expiring -= boolToInt(deleted.ExpiresAt != 0)
+				f.expiries.remove(deleted)
+				f.countContentType(deleted, -1)
+			}
+		}
+		return
+	}
+	if live {
+		if previous := idx.Get(key); previous != nil {
+			f.liveBytes -= previous.Position.Size()
+			f.countContentType(previous, -1)
+		}
+		f.liveBytes += p.Size()
+	}
+	rowInfo := idx.Put(key, p)
+	if idx == f.idx {
+		f.expiring += boolToInt(row.ExpiresAt != 0) - boolToInt(rowInfo.ExpiresAt != 0)
+	}
+	rowInfo.Timestamp, rowInfo.ExpiresAt, rowInfo.ContentType = row.Timestamp, row.ExpiresAt, row.ContentType
+	if idx == f.idx {
+		f.countContentType(rowInfo, 1)
+		f.expiries.update(rowInfo)
+	}
+	if f.opts.SkipUnchangedWrites && !row.IsAlias && !row.IsMerge && row.valueRef.Size() == 0 {
+		rowInfo.ValueHash = hashValue(row.Value)
+	}
 }
 
-// Close gracefully closes the underlying file handlers.
+// Close gracefully closes the underlying file handlers, the file then returns ErrClosed.
 func (f *File) Close() error {
+	if errors.Is(f.Err(), ErrClosed) {
+		return ErrClosed
+	}
+	if f.stopLoop != nil {
+		close(f.stopLoop)
+		<-f.loopDone
+		f.stopLoop = nil
+	}
+	if f.stopSweep != nil {
+		close(f.stopSweep)
+		<-f.sweepDone
+		f.stopSweep = nil
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
-
-	return closeFileRW(f.r, f.w)
+	for len(f.watchers) > 0 {
+		f.removeWatcher(f.watchers[0])
+	}
+	var err error
+	if !f.opts.ReadOnly && f.Err() == nil && f.usesCleanShutdownMarker() {
+		err = f.store.Sync()
+		if err == nil {
+			err = f.writeCleanShutdownMarker()
+		}
+	} else if f.dirty {
+		if syncErr := f.store.Sync(); syncErr != nil {
+			err = fmt.Errorf("flush: %w", syncErr)
+		}
+	}
+	if !f.opts.ReadOnly && f.Err() == nil && f.opts.KeydirSnapshot && f.usesCleanShutdownMarker() {
+		err = errors.Join(err, f.writeKeydirSnapshot())
+	}
+	if closeErr := f.store.Close(); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("close datafile: %w", closeErr))
+	}
+	f.unmap()
+	if f.lock != nil {
+		err = errors.Join(err, f.lock.Close()) // releases the lock
+	}
+	f.failMu.Lock()
+	f.failure = ErrClosed
+	f.failMu.Unlock()
+	return err
 }
 
+// ErrInconsistent is returned when a commit fails after writing to the file (for example, when the file can't be synced):
+// the file is marked as failed and all subsequent transactions fail with ErrFailed.
+// The application can then decide whether to crash, to close and reopen the file or to fail over.
+var ErrInconsistent = errors.New("inconsistent state")
+
 var (
 	ErrMemoryCorruption = errors.New("memory corruption")
 	ErrFileCorruption   = errors.New("file corruption")
+	ErrIndexMismatch    = errors.New("keydir does not match file content") // Reported by paranoid checks.
 )
 
 // File extension added to file during compaction process.
@@ -82,112 +540,426 @@ const CompactingFileExtension = ".compacting"
 // Removes any remaining ".compacting" file left from an eventual past failed compaction.
 // Does not fail if the file is not present.
 func (f *File) EnsureNoCompactingFile() error {
-	err := os.Remove(f.fpath + CompactingFileExtension)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+	for _, ext := range []string{CompactingFileExtension, compactionManifestExtension} {
+		if err := f.removeStorage(f.fpath + ext); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 // Compact removes deleted keys and rewrites rows (in lexicographical order) to a new file.
+//
+// Writers are only blocked while switching to the new file: rows committed during the compaction
+// are then copied to the new file, most of them beforehand without holding the lock.
+// Concurrent compactions of the file are serialized.
+//
+// Progress is regularly saved in a manifest next to the compacting file: if the compaction is interrupted
+// (by an error or a crash), the next compaction resumes where it left off, provided the file wasn't written to since.
+//
+// With WithFormatUpgrade, rows are rewritten in the new format (and progress is not saved).
 func (f *File) Compact() error {
+	f.compacting.Lock()
+	defer f.compacting.Unlock()
+	waitStart := time.Now()
 	f.mu.Lock()
-	defer f.mu.Unlock()
+	f.lockWaited(true, waitStart)
+	if err := f.checkWritable(); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	start, before, sourceSize, ttls := time.Now(), f.woffset, f.woffset, f.prefixTTLs
 
-	// Remove any previous failed compaction file.
-	err := f.EnsureNoCompactingFile()
+	// Resume an interrupted compaction of the file, or remove any previous failed compaction file.
+	upgrade := f.upgradeFormat()
+	progress, err := f.loadCompactionProgress()
+	if err == nil && (upgrade != nil || f.opts.HistoryRetention > 0 || f.opts.KeepVersions > 1) && progress.offset > 0 {
+		progress, err = compactionProgress{}, f.EnsureNoCompactingFile()
+	}
+	f.mu.Unlock()
 	if err != nil {
-		return fmt.Errorf("ensure no compacting file: %w", err)
+		return err
 	}
 
 	// Init new file
-	cleanIdx := fidx.NewLHTIndex(f.numBuckets)
-	cleanOffset := 0
-	cleanR, cleanW, err := openFileRW(f.fpath + CompactingFileExtension)
+	cleanIdx, cleanSys := f.newKeydir(), fidx.NewTrieIndex()
+	clean, err := f.opts.Storage(f.fpath+CompactingFileExtension, StorageReadWrite)
 	if err != nil {
 		return fmt.Errorf("open new datafile: %w", err)
 	}
-
-	// Write rows to new file
-	for row := f.idx.Oldest; row != nil; row = row.Next {
-		encodedRow := make([]byte, row.Position.Size())
-		_, err := f.r.ReadAt(encodedRow, int64(row.Position.Offset()))
+	if err := clean.Truncate(int64(progress.offset)); err != nil { // drop the rows written after the last checkpoint
+		clean.Close()
+		return fmt.Errorf("truncate new datafile: %w", err)
+	}
+	if progress.offset > 0 {
+		_, _, err = f.replay(bufio.NewReaderSize(io.NewSectionReader(clean, 0, int64(progress.offset)), f.opts.ReadBufferSize), 0, -1, cleanIdx, cleanSys)
 		if err != nil {
-			return fmt.Errorf("read row: %w", err)
+			clean.Close()
+			return fmt.Errorf("replay compacted rows: %w", err)
 		}
-		n, err := cleanW.Write(encodedRow)
-		cleanOffset += n
+	}
+
+	// Write rows to new file (writers append rows after the source size in the meantime)
+	throttled := &throttledWriter{w: storageWriter{clean}, limiter: f.newRateLimiter()}
+	bufw := bufio.NewWriterSize(throttled, f.opts.WriteBufferSize)
+	src := compactionSource{idx: f.idx, sys: f.sys, end: sourceSize, lock: f.mu.RLocker(), now: start.UnixNano()}
+	checkpoint := func(p compactionProgress) error {
+		if err := bufw.Flush(); err != nil {
+			return err
+		}
+		if err := clean.Sync(); err != nil {
+			return err
+		}
+		return f.saveCompactionManifest(sourceSize, p)
+	}
+	if upgrade != nil {
+		checkpoint = nil // the compacted rows can't be replayed in the format of the file
+	}
+	if f.opts.HistoryRetention > 0 {
+		// Write the state at the history horizon, followed by the rows written since (see WithHistoryRetention).
+		src, err = f.historySource(start.Add(-f.opts.HistoryRetention), sourceSize)
 		if err != nil {
-			return fmt.Errorf("write to new file: %w", err)
+			clean.Close()
+			return err
+		}
+		checkpoint = nil // the horizon moves between compactions
+	}
+	var cleanOffset, cleanRows int
+	if f.opts.KeepVersions > 1 {
+		// Write the last versions of each key instead of its latest row only (see WithKeepVersions).
+		expired := (&Reader{f: f, now: src.now, ttls: ttls}).expired
+		cleanOffset, cleanRows, err = f.writeVersions(bufw, src.end, f.opts.KeepVersions, expired, cleanIdx, cleanSys, upgrade)
+	} else {
+		cleanOffset, err = f.writeCompacted(bufw, src, cleanIdx, cleanSys, upgrade, progress, checkpoint)
+		cleanRows = cleanIdx.Chronological().Count + cleanSys.Chronological().Count
+	}
+	if err != nil {
+		clean.Close()
+		return err
+	}
+	if f.opts.ArchivePath != "" {
+		// Archive the rows dropped from the source (see WithCompactionArchive)
+		if err := f.archiveRows(src.end, cleanIdx, cleanSys); err != nil {
+			clean.Close()
+			return err
 		}
-		cleanIdx.Put(row.Key, fidx.Position{cleanOffset - n, n})
+	}
+
+	// Catch up with the rows committed so far without blocking writers
+	f.mu.RLock()
+	caughtUp := f.woffset
+	f.mu.RUnlock()
+	cleanOffset, numRows, err := f.copyCommittedRows(bufw, cleanOffset, src.end, caughtUp, cleanIdx, cleanSys, upgrade)
+	if err != nil {
+		clean.Close()
+		return fmt.Errorf("catch up: %w", err)
+	}
+	cleanRows += numRows
+
+	// Block writers to copy the last committed rows and switch files (without throttling, see WithMaintenanceRateLimit)
+	throttled.limiter = nil
+	waitStart = time.Now()
+	f.freeze.RLock()
+	defer f.freeze.RUnlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lockWaited(true, waitStart)
+	if err := f.checkWritable(); err != nil {
+		clean.Close()
+		return err
+	}
+	cleanOffset, numRows, err = f.copyCommittedRows(bufw, cleanOffset, caughtUp, f.woffset, cleanIdx, cleanSys, upgrade)
+	if err != nil {
+		clean.Close()
+		return fmt.Errorf("catch up: %w", err)
+	}
+	cleanRows += numRows
+	if f.seqBase+f.numRows != cleanRows {
+		// Rows were discarded, record the sequences (see File.Seq)
+		if cleanOffset, err = f.writeSeq(bufw, cleanOffset, cleanRows+1, cleanSys, upgrade); err != nil {
+			clean.Close()
+			return err
+		}
+		cleanRows++
+	}
+	if cleanOffset, err = f.writeCompactedOps(bufw, cleanOffset, upgrade); err != nil {
+		clean.Close()
+		return err
 	}
 
 	// Sync new file
-	err = cleanW.Sync()
+	if err = bufw.Flush(); err != nil {
+		clean.Close()
+		return fmt.Errorf("write to new file: %w", err)
+	}
+	err = clean.Sync()
 	if err != nil {
+		clean.Close()
+		f.EnsureNoCompactingFile() // the compacted rows may not be durable, don't resume from them
 		return fmt.Errorf("sync: %w", err)
 	}
 
 	// Close old file
-	err = closeFileRW(f.r, f.w)
+	err = f.store.Close()
 	if err != nil {
+		clean.Close()
 		return fmt.Errorf("close old file: %w", err)
 	}
 
-	// Replace old file with new
-	err = os.Rename(cleanR.Name(), f.fpath)
+	// Replace old file with new (the storage syncs the rename, see fsutil.Rename)
+	err = clean.Rename(f.fpath)
 	if err != nil {
+		// Keep using the old file
+		clean.Close()
+		store, reopenErr := f.opts.Storage(f.fpath, StorageReadWrite)
+		if reopenErr != nil {
+			f.fail(fmt.Errorf("%w: reopen old file: %w", ErrInconsistent, reopenErr))
+			return fmt.Errorf("swap: %w: %w", err, reopenErr)
+		}
+		f.store = store
+		f.updateMapping()
 		return fmt.Errorf("swap: %w", err)
 	}
-	f.idx = cleanIdx
-	f.r, f.w = cleanR, cleanW
+	f.removeStorage(f.fpath + compactionManifestExtension)
+	f.idx, f.sys = cleanIdx, cleanSys
+	f.store = clean
 	f.woffset = cleanOffset
-	return nil
+	if upgrade != nil {
+		f.format = upgrade
+	}
+	if err := f.loadHeaderSize(); err != nil {
+		return err
+	}
+	f.updateMapping()
+	f.resetCheckpoint() // the compacted file was written from verified rows
+	f.dirty = false
+	f.numRows = cleanRows
+	if err := f.loadSeq(); err != nil {
+		return err
+	}
+	f.compactions++
+	f.compactedAt = time.Now()
+	f.compactRate = float64(before) / f.compactedAt.Sub(start).Seconds()
+	f.appended.notify()
+	f.countKeys()
+	if f.opts.KeydirSnapshot && f.usesCleanShutdownMarker() && f.writeKeydirSnapshot() != nil {
+		os.Remove(f.fpath + KeydirSnapshotFileExtension) // stale, it would be rejected on open anyway
+	}
+	if f.opts.Metrics != nil {
+		f.opts.Metrics.Compacted(time.Since(start), before, f.woffset)
+	}
+	if err := f.indexValues(); err != nil {
+		return err
+	}
+	return f.rebuildIndexes()
 }
 
-func (f *File) readAndDecodeRow(position fidx.Position) (*Row, error) {
-	encodedRow := make([]byte, position.Size())
-	_, err := f.r.ReadAt(encodedRow, int64(position.Offset()))
+// readAndDecodeRow reads the row at the given position, with the value it references if it was deduplicated.
+func (f *File) readAndDecodeRow(ra io.ReaderAt, position fidx.Position) (*Row, error) {
+	row, err := f.decodeRowAt(ra, position)
+	if err == nil && row.valueRef.Size() != 0 {
+		err = f.resolveValueRef(ra, row)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("read row: %w", err)
+		return nil, err
+	}
+	return row, nil
+}
+
+// decodeRowAt reads the row at the given position as stored (see readAndDecodeRow).
+func (f *File) decodeRowAt(ra io.ReaderAt, position fidx.Position) (*Row, error) {
+	// Rows are sliced from the memory mapping of the file if any (see WithMmapReads).
+	var encodedRow []byte
+	if m, ok := ra.(*mapping); ok {
+		encodedRow, _ = m.slice(position.Offset(), position.Size())
+	}
+	if encodedRow == nil {
+		// Decoding copies the key and value, so the encoded row buffer can be reused.
+		bufp := rowBuffers.Get().(*[]byte)
+		defer rowBuffers.Put(bufp)
+		if cap(*bufp) < position.Size() {
+			*bufp = make([]byte, position.Size())
+		}
+		encodedRow = (*bufp)[:position.Size()]
+		_, err := ra.ReadAt(encodedRow, int64(position.Offset()))
+		if err != nil {
+			return nil, fmt.Errorf("read row at offset %d: %w", position.Offset(), err)
+		}
 	}
 	row := &Row{}
-	_, err = row.DecodeFrom(bytes.NewReader(encodedRow))
+	n, err := f.format.DecodeFrom(bytes.NewReader(encodedRow), row)
 	if err != nil {
-		return nil, fmt.Errorf("decode row: %w", err)
+		return nil, fmt.Errorf("decode row at offset %d: %w", position.Offset(), err)
+	}
+	if err := row.VerifyChecksum(); err != nil {
+		return nil, fmt.Errorf("%w at offset %d", err, position.Offset())
+	}
+	if f.opts.ParanoidChecks && n != position.Size() {
+		return nil, fmt.Errorf("%w: decoded %d bytes instead of %d at offset %d", ErrIndexMismatch, n, position.Size(), position.Offset())
 	}
 	return row, nil
 }
 
+// readValue reads the value at the given position (resolving aliases) and applies the eventual read transform.
+func (r *Reader) readValue(key []byte, position fidx.Position) ([]byte, error) {
+	f := r.f
+	row, err := r.readRow(key, position)
+	if err != nil {
+		return nil, err
+	}
+	for hops := 0; row.IsAlias; hops++ {
+		if hops == maxAliasHops {
+			return nil, fmt.Errorf("%w: %q", ErrAliasCycle, key)
+		}
+		target := r.get(row.Value)
+		if target == nil {
+			return nil, nil // dangling alias
+		}
+		key = row.Value
+		row, err = r.readRow(key, target.Position)
+		if err != nil {
+			return nil, err
+		}
+	}
+	value, err := f.storedValue(r.ra, row)
+	if err != nil {
+		return nil, err
+	}
+	if f.opts.ReadTransform != nil {
+		value, err = f.opts.ReadTransform(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("transform value: %w", err)
+		}
+	}
+	return f.afterGet(key, value)
+}
+
+// readRow reads the row at the given position.
+func (r *Reader) readRow(key []byte, position fidx.Position) (*Row, error) {
+	row, err := r.f.readAndDecodeRow(r.ra, position)
+	if err != nil {
+		return nil, err
+	}
+	if r.f.opts.ParanoidChecks || r.f.opts.KeySecret != nil {
+		if row.IsDeleted {
+			return nil, fmt.Errorf("%w: found delete row for %q at offset %d", ErrIndexMismatch, key, position.Offset())
+		}
+		if !r.f.sameKey(row.Key, key) {
+			return nil, fmt.Errorf("%w: found key %q instead of %q at offset %d", ErrIndexMismatch, row.Key, key, position.Offset())
+		}
+	}
+	return row, nil
+}
+
+// transformEncodedRow re-encodes the given row with its transformed value.
+func (f *File) transformEncodedRow(encodedRow []byte) ([]byte, error) {
+	row := &Row{}
+	_, err := f.format.DecodeFrom(bytes.NewReader(encodedRow), row)
+	if err != nil {
+		return nil, fmt.Errorf("decode row: %w", err)
+	}
+	if row.IsAlias || row.IsSealed {
+		return encodedRow, nil // the target row is transformed instead, sealed values are transformed on read only
+	}
+	value, err := decompressValue(row.Compression, row.Value)
+	if err != nil {
+		return nil, err
+	}
+	row.Value, err = f.opts.ReadTransform(row.Key, value)
+	if err != nil {
+		return nil, err
+	}
+	row.Compression = NoCompression
+	f.compressRow(row)
+	if row.Checksum != 0 {
+		row.Checksum = row.computeChecksum()
+	}
+	return f.format.Encode(row)
+}
+
 // Copies the datafile to the given writer.
 // Can be used to backup the datafile to another file or to a HTTP response writer for example.
+//
+// The copy holds the rows committed when it starts: the size of the file is recorded with the read lock held,
+// then the rows are streamed from a dedicated read handle (valid even after a compaction replaces the file)
+// without blocking writers.
 func (f *File) CopyTo(dst io.Writer) (int, error) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
+	f.mu.RLock()
+	if err := f.Err(); err != nil {
+		f.mu.RUnlock()
+		return 0, err
+	}
+	h, err := f.opts.Storage(f.fpath, StorageReadOnly)
+	size := f.woffset
+	f.mu.RUnlock()
+	if err != nil {
+		return 0, fmt.Errorf("open read handle: %w", err)
+	}
+	defer h.Close()
 
-	f.r.Seek(0, io.SeekStart)
-	n, err := io.Copy(dst, f.r)
-	return int(n), err
+	return f.copyThrottled(dst, io.NewSectionReader(h, 0, int64(size)))
 }
 
 // Path returns the path with which the database file was opened.
 func (f *File) Path() string { return f.fpath }
 
-func openFileRW(fpath string) (*os.File, *os.File, error) {
-	r, err := os.OpenFile(fpath, os.O_RDONLY|os.O_CREATE, 0666)
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// openFileRW opens a read and a write handle on the given file, creating it if needed
+// (if exclusive, it fails if the file exists).
+func openFileRW(fpath string, exclusive bool) (*os.File, *os.File, error) {
+	flag := os.O_RDONLY | os.O_CREATE
+	if exclusive {
+		flag |= os.O_EXCL
+	}
+	r, err := os.OpenFile(fpath, flag, 0666)
 	if err != nil {
 		return nil, nil, err
 	}
 	w, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
+		r.Close()
 		return nil, nil, err
 	}
 	return r, w, nil
 }
 
+// lockCtx calls lock unless the context is done first (lock then completes in the background and is undone with unlock).
+func lockCtx(ctx context.Context, lock, unlock func()) error {
+	if ctx.Done() == nil {
+		lock()
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	locked := make(chan struct{})
+	go func() {
+		lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
 func closeFileRW(r, w *os.File) error {
-	rerr, werr := r.Close(), w.Close()
+	rerr, werr := r.Close(), error(nil)
+	if w != nil {
+		werr = w.Close()
+	}
 	if rerr != nil || werr != nil {
 		return fmt.Errorf("close file (r/w): %w, %w", rerr, werr)
 	}
@@ -196,175 +968,533 @@ func closeFileRW(r, w *os.File) error {
 
 // Read-write executes a read-write transaction.
 //
-// The transaction can be aborted by returning a non-nil error in the callback.
+// The transaction can be aborted by returning a non-nil error in the callback,
+// the error is then returned wrapped in ErrTxnAborted.
 func (f *File) ReadWrite(do func(r *Reader, w *Writer) error) error {
-	f.mu.Lock()
+	return f.ReadWriteCtx(context.Background(), do)
+}
+
+// ReadWriteCtx is like ReadWrite but returns the context error when the context is done
+// while waiting for the lock, walking keys (see Reader.Walk) or before the rows are written,
+// the transaction is then aborted. Once rows are being written, the commit completes.
+func (f *File) ReadWriteCtx(ctx context.Context, do func(r *Reader, w *Writer) error) error {
+	return f.readWrite(ctx, do, nil)
+}
+
+// CommitReceipt describes the rows written by a read-write transaction (see ReadWriteResult).
+//
+// Commit sequences are the sequences of the file after the commits (see File.Seq), the states they left can be read with File.ReadAt.
+// They increase by the number of rows written with each commit writing rows (transactions and batches),
+// and survive compactions and reopening.
+type CommitReceipt struct {
+	Offset int    // offset of the first byte written
+	Size   int    // number of bytes written
+	Rows   int    // number of rows written (0 if the transaction didn't write anything)
+	Seq    uint64 // sequence of the commit (unchanged if no rows were written)
+}
+
+// ReadWriteResult is like ReadWrite but also returns where the transaction's rows were written,
+// so that replication, watchers and tests can refer to this specific commit.
+func (f *File) ReadWriteResult(do func(r *Reader, w *Writer) error) (CommitReceipt, error) {
+	return f.ReadWriteResultCtx(context.Background(), do)
+}
+
+// ReadWriteResultCtx is like ReadWriteResult but accepts a context (see ReadWriteCtx).
+func (f *File) ReadWriteResultCtx(ctx context.Context, do func(r *Reader, w *Writer) error) (CommitReceipt, error) {
+	var receipt CommitReceipt
+	err := f.readWrite(ctx, do, &receipt)
+	if err != nil {
+		return CommitReceipt{}, err
+	}
+	return receipt, nil
+}
+
+// readWrite executes a read-write transaction and fills the given receipt if not nil.
+func (f *File) readWrite(ctx context.Context, do func(r *Reader, w *Writer) error, receipt *CommitReceipt) (err error) {
+	durable := 0 // commit that must be synced before returning (see SyncGroup)
+	defer func() {
+		if err == nil && durable > 0 {
+			err = f.waitDurable(durable)
+		}
+	}()
+	waitStart := time.Now()
+	if err := lockCtx(ctx, f.freeze.RLock, f.freeze.RUnlock); err != nil {
+		return err
+	}
+	defer f.freeze.RUnlock()
+	if err := lockCtx(ctx, f.mu.Lock, f.mu.Unlock); err != nil {
+		return err
+	}
 	defer f.mu.Unlock()
+	f.lockWaited(true, waitStart)
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	startOffset, startRows := f.woffset, f.numRows
+	if receipt != nil {
+		defer func() {
+			*receipt = CommitReceipt{startOffset, f.woffset - startOffset, f.numRows - startRows, uint64(f.seqBase + f.numRows)}
+		}()
+	}
+	ctx, stopWatch := f.watchTransaction(ctx)
+	defer stopWatch()
 
 	// Execute callback
-	r, w := &Reader{f: f}, &Writer{}
-	err := do(r, w)
+	r, w := f.newReader(), f.newWriter()
+	r.ctx, w.committed = ctx, r
+	err = do(r, w)
 	if err != nil {
-		return err // aborts on error
+		return abort(withCause(ctx, err))
+	}
+	if w.err != nil {
+		return abort(w.err)
+	}
+	if err := f.resolveDeferred(w); err != nil {
+		return abort(err)
+	}
+	if f.opts.CoalesceWrites {
+		w.rows = f.coalesceRows(w.rows)
+	}
+	if f.opts.PreCommitHook != nil {
+		err = f.opts.PreCommitHook(w)
+		if err != nil {
+			return abort(fmt.Errorf("pre-commit hook: %w", err))
+		}
+	}
+	if err := f.checkConditions(w); err != nil {
+		return abort(err)
+	}
+	if err := f.checkMergeOperator(w.rows); err != nil {
+		return abort(err)
+	}
+	if err := f.checkFrozen(w.rows); err != nil {
+		return abort(err)
+	}
+	if err := f.checkOps(w); err != nil {
+		return abort(err)
+	}
+	rows := w.rows
+	if len(w.renames) > 0 {
+		// Renames are written in a single batch frame (see Writer.Rename)
+		if err := f.collapseMerges(rows); err != nil {
+			return abort(err)
+		}
+		rows = f.changedRows(rows)
+	}
+	quotaDeltas, err := f.checkQuotas(rows)
+	if err != nil {
+		return abort(err)
+	}
+	if err := f.checkFileSize(w, rows, w.pendingBytes); err != nil {
+		return abort(err)
+	}
+	if err := context.Cause(ctx); err != nil {
+		return abort(err)
+	}
+	if len(w.renames) > 0 {
+		durable, err = f.writeBatch(rows, quotaDeltas, w.deferSync)
+		return err
 	}
 
 	// Write rows to file
-	startOffset := f.woffset
+	var written []*Row // rows to record and notify (see WithRecorder and Watch)
 	for _, row := range w.rows {
-		// Encode row
-		encoded, err := row.Encode()
+		// Skip rows that wouldn't change the database state
+		if f.opts.SkipUnchangedWrites && f.isUnchanged(row) {
+			continue
+		}
+		if f.opts.Recorder != nil || len(f.watchers) > 0 || f.hooks.Load() != nil {
+			written = append(written, row)
+		}
+
+		// Encode row (unless it was written encoded, see RawWriter.PutRaw)
+		encoded, err := row.raw, f.linkMerge(row)
+		if err != nil {
+			if f.woffset != startOffset {
+				return f.handleCorruption(err, startOffset)
+			}
+			return err
+		}
+		var digest valueDigest
+		var isNewValue bool
+		if encoded == nil {
+			var stored *Row // references an identical value if any (see WithValueDedup)
+			stored, digest, isNewValue = f.deduplicate(row)
+			encoded, err = f.format.Encode(stored)
+		}
 		if err != nil {
 			err = fmt.Errorf("encode: %w", err)
 			if f.woffset != startOffset {
-				f.handleCorruption(err, startOffset)
+				return f.handleCorruption(err, startOffset)
 			}
 			return err
 		}
 
 		// Write row
-		n, err := f.w.Write(encoded)
+		n, err := f.store.Append(encoded)
 		f.woffset += n
+		f.tail.Write(encoded[:n])
 		if err != nil {
 			err = fmt.Errorf("write: %w", err)
 			if f.woffset != startOffset {
-				f.handleCorruption(err, startOffset)
+				return f.handleCorruption(err, startOffset)
 			}
 			return err
 		}
 
 		// Update memstate
-		if row.IsDeleted {
-			f.idx.Delete(row.Key)
-		} else {
-			f.idx.Put(row.Key, fidx.Position{f.woffset - n, n})
+		f.applyRow(row, fidx.Position{f.woffset - n, n}, f.idx, f.sys)
+		f.numRows++
+		if isNewValue {
+			f.indexValue(digest, fidx.Position{f.woffset - n, n})
+		}
+	}
+	if err := f.writeOps(w.ops); err != nil {
+		if f.woffset != startOffset {
+			return f.handleCorruption(err, startOffset)
 		}
+		return err
 	}
+	f.updateMapping()
 
 	// Sync file
-	err = f.w.Sync()
-	if err != nil {
-		f.handleCorruption(fmt.Errorf("sync: %w", err), startOffset)
+	if w.deferSync {
+		f.dirty = true
+	} else if err = f.sync(); err != nil {
+		return f.handleCorruption(fmt.Errorf("sync: %w", err), startOffset)
 	}
-	return nil
+	f.applyQuotas(quotaDeltas)
+	f.commits++
+	f.appended.notify()
+	if f.opts.Metrics != nil {
+		f.opts.Metrics.Written(f.numRows-startRows, f.woffset-startOffset)
+	}
+	if f.opts.Sync == SyncGroup && !w.deferSync {
+		durable = f.commits
+	}
+	f.notify(written)
+	f.afterCommit(written)
+	return f.record(written)
 }
 
-// Called when writing more than one row when data was already written to file and memstate updated,
-// we need to recover from the file corruption and panic to restart the server
-// with a clean memstate.
-func (f *File) handleCorruption(err error, size int) {
-	truncErr := os.Truncate(f.fpath, int64(size))
-	if truncErr != nil {
+// handleCorruption is called when a commit fails after rows were written to the file (and applied to the keydir):
+// the file is truncated back to the given size and marked as failed since the keydir doesn't match it anymore.
+// The returned error wraps ErrInconsistent, and ErrFileCorruption if the truncation failed
+// (ErrMemoryCorruption otherwise).
+func (f *File) handleCorruption(err error, size int) error {
+	if truncErr := f.store.Truncate(int64(size)); truncErr != nil {
 		// Failed truncation, file is corrupted.
-		panic(fmt.Errorf("%w (%d): %w: %w", ErrFileCorruption, f.woffset-size, err, truncErr))
+		err = fmt.Errorf("%w: %w (%d): %w: %w", ErrInconsistent, ErrFileCorruption, f.woffset-size, err, truncErr)
+	} else {
+		// Truncation succeeded, avoided file corruption but memstate is corrupted, only need to reopen.
+		err = fmt.Errorf("%w: %w: %w", ErrInconsistent, ErrMemoryCorruption, err)
 	}
-	// Truncation succeeded, avoided file corruption but memstate is corrupted, only need restart.
-	panic(fmt.Errorf("%w: %w", ErrMemoryCorruption, err))
+	f.fail(err)
+	return err
 }
 
 // Note: In a read-only transaction,
 // the returned error can only originate from the callback, therefore it can be ignored if the
 // callback never fails (for example, when using `r.Has`, `r.Walk` or `r.Count`).
 func (f *File) Read(do func(r *Reader) error) error {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
+	return f.ReadCtx(context.Background(), do)
+}
+
+// ReadCtx is like Read but returns the context error when the context is done
+// while waiting for the lock or walking keys (see Reader.Walk).
+func (f *File) ReadCtx(ctx context.Context, do func(r *Reader) error) error {
+	waitStart := time.Now()
+	if err := lockCtx(ctx, f.mu.RLock, f.mu.RUnlock); err != nil {
+		return err
+	}
+	f.lockWaited(false, waitStart)
+	if err := f.Err(); err != nil {
+		f.mu.RUnlock()
+		return err
+	}
 
-	r := &Reader{f: f}
+	r := f.newReader()
+	r.ctx = ctx
+	if f.opts.MaxReadDuration > 0 {
+		r.deadline = time.Now().Add(f.opts.MaxReadDuration)
+	}
+	defer r.release()
+	if f.opts.Metrics != nil {
+		defer func(start time.Time) { f.opts.Metrics.Read(time.Since(start)) }(time.Now())
+	}
 	return do(r)
 }
 
 // Writer holds write operations executed in a write transaction.
 type Writer struct {
-	rows []*Row
+	rows         []*Row
+	pendingBytes int
+	now          time.Time
+	format       Format
+	checksums    bool
+	timestamp    int64 // write time of staged rows (0 if timestamps are disabled)
+	keyring      *keyring
+	compression  Compression      // codec of staged values (see WithCompression)
+	minCompress  int              // minimum size of compressed values
+	conditions   []writeCondition // checked on commit (see SetIf)
+	actor        string           // recorded in staged rows (see SetActor)
+	maxKey       int              // maximum key length (see WithMaxKeyLength)
+	maxValue     int              // maximum value length (see WithMaxValueLength)
+	renames      []rename         // resolved on commit (see Rename)
+	deletes      []prefixDelete   // resolved on commit (see DeletePrefix)
+	ops          []customOp       // written after the rows (see AppendOp)
+	hooks        *keyHooks        // see File.OnBeforeSet
+	deferSync    bool             // the commit is not synced (see NoSync)
+	committed    *Reader          // state of the file in transactions (nil in batches, see SetNew)
+	savepoints   []uint64         // generations of the savepoints not discarded by a rollback, in order
+	savepointGen uint64           // generation of the last savepoint
+	err          error            // aborts the transaction on commit
 }
 
+func (f *File) newWriter() *Writer {
+	w := &Writer{
+		now:         time.Now(),
+		format:      f.format,
+		checksums:   f.opts.RowChecksums,
+		keyring:     f.keyring,
+		compression: f.opts.Compression,
+		minCompress: f.opts.CompressionThreshold,
+		maxKey:      f.opts.MaxKeyLength,
+		maxValue:    f.opts.MaxValueLength,
+		hooks:       f.hooks.Load(),
+	}
+	if !f.opts.DisableTimestamps {
+		w.timestamp = w.now.UnixNano()
+	}
+	return w
+}
+
+func (w *Writer) stage(row *Row) {
+	row.Timestamp, row.Actor = w.timestamp, w.actor
+	compressRow(row, w.compression, w.minCompress)
+	w.keyring.sealRow(row)
+	if w.checksums {
+		row.Checksum = row.computeChecksum()
+	}
+	w.rows = append(w.rows, row)
+	if _, encrypted := w.format.(encryptedFormat); encrypted {
+		w.pendingBytes += encryptedHeaderSize + row.EncodedSize() + encryptedOverhead
+	} else if bf, ok := w.format.(binaryFormat); ok {
+		w.pendingBytes += bf.encodedSize(row)
+	} else if encoded, err := w.format.Encode(row); err == nil {
+		w.pendingBytes += len(encoded)
+	}
+}
+
+// PendingRows returns the number of rows staged in the transaction.
+func (w *Writer) PendingRows() int { return len(w.rows) }
+
+// PendingBytes returns the encoded size of the rows staged in the transaction
+// (the number of bytes that will be appended to the file on commit).
+func (w *Writer) PendingBytes() int { return w.pendingBytes }
+
 // Set adds a new key-value pair to the database.
 // Any previous key-value will be overwritten during the next compaction.
+//
+// Setting an invalid key (for example, in the reserved keyspace) aborts the transaction with ErrInvalidKey,
+// and setting a value longer than the configured limit with ErrValueTooLong (see WithMaxValueLength).
 func (w *Writer) Set(key, value []byte) {
-	w.rows = append(w.rows, &Row{Key: key, Value: value})
+	if value, ok := w.beforeSet(key, value); ok && w.checkValue(value) {
+		w.stage(&Row{Key: key, Value: value})
+	}
 }
 
 // Delete removes a key-value pair from the database.
 //
 // If the key does not exist, delete as no impact on the database state.
 func (w *Writer) Delete(key []byte) {
-	w.rows = append(w.rows, &Row{IsDeleted: true, Key: key})
+	if w.checkKey(key) {
+		w.stage(&Row{IsDeleted: true, Key: key})
+	}
+}
+
+// ErrInvalidKey is returned when writing an empty key, a key longer than the configured limit (see WithMaxKeyLength)
+// or a key in the reserved keyspace (the error then also wraps ErrKeyTooLong or ErrReservedKey).
+var ErrInvalidKey = errors.New("invalid key")
+
+// checkKey reports whether the given key can be written, otherwise the transaction is aborted with ErrInvalidKey.
+func (w *Writer) checkKey(key []byte) bool {
+	var err error
+	switch {
+	case len(key) == 0:
+		err = fmt.Errorf("%w: empty key", ErrInvalidKey)
+	case len(key) > w.maxKey:
+		err = fmt.Errorf("%w: %w: %d", ErrInvalidKey, ErrKeyTooLong, len(key))
+	case IsReservedKey(key):
+		err = fmt.Errorf("%w: %w: %q", ErrInvalidKey, ErrReservedKey, key)
+	}
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return err == nil
+}
+
+// checkValue reports whether the given value can be written, otherwise the transaction is aborted with ErrValueTooLong.
+func (w *Writer) checkValue(value []byte) bool {
+	if len(value) <= w.maxValue {
+		return true
+	}
+	if w.err == nil {
+		w.err = fmt.Errorf("%w: %d", ErrValueTooLong, len(value))
+	}
+	return false
 }
 
 // Reader can read rows from the database in a read transaction.
 type Reader struct {
-	f *File
+	f        *File
+	idx, sys fidx.Keydir     // view of the database (current, historical or snapshot)
+	ra       io.ReaderAt     // where rows are read from
+	size     int             // size of the file at the start of the transaction (see Reader.At)
+	ctx      context.Context // interrupts walks when done (see ReadCtx)
+	deadline time.Time       // when to detach from the lock (see WithMaxReadDuration)
+	detached Storage         // dedicated read handle once detached from the lock
+	now      int64           // start of the transaction in Unix nanoseconds (used for expiration)
+	ttls     []prefixTTL     // prefix TTL policies at the start of the transaction
+	expiring int             // number of keys with an expiration time (at the start of the transaction)
+}
+
+func (f *File) newReader() *Reader {
+	return &Reader{
+		f:        f,
+		ctx:      context.Background(),
+		idx:      f.idx,
+		sys:      f.sys,
+		ra:       f.readerAt(),
+		size:     f.woffset,
+		now:      time.Now().UnixNano(),
+		ttls:     f.prefixTTLs,
+		expiring: f.expiring,
+	}
+}
+
+// get returns the row info for the given key, or nil if the key is not found or expired.
+func (r *Reader) get(key []byte) *fidx.RowInfo {
+	r.checkDeadline()
+	row := r.idx.Get(r.f.indexKey(key))
+	if row == nil || r.expired(row) {
+		return nil
+	}
+	return row
 }
 
 // Has reports whether a key is known.
-func (r *Reader) Has(key []byte) bool { return r.f.idx.Get(key) != nil }
+func (r *Reader) Has(key []byte) bool { return r.get(key) != nil }
+
+// ModTime returns the write time of the current value of the given key
+// (zero if the key doesn't exist or was written without timestamp, see WithTimestamps).
+func (r *Reader) ModTime(key []byte) time.Time {
+	if rowInfo := r.get(key); rowInfo != nil && rowInfo.Timestamp != 0 {
+		return time.Unix(0, rowInfo.Timestamp)
+	}
+	return time.Time{}
+}
 
 // Count returns the number of unique keys in the database.
-func (r *Reader) Count() int { return r.f.idx.Count }
+func (r *Reader) Count() int {
+	r.checkDeadline()
+	return r.idx.Chronological().Count - r.countExpired()
+}
 
 // Get returns the eventual value associated with the given key,
 // if the key is not found, a nil value is returned.
 // If an error is returned, it is internal (failed OS read or decoding).
 func (r *Reader) Get(key []byte) ([]byte, error) {
-	rowInfo := r.f.idx.Get(key)
+	rowInfo := r.get(key)
 	if rowInfo == nil {
 		return nil, nil
 	}
-	row, err := r.f.readAndDecodeRow(rowInfo.Position)
-	if err != nil {
-		return nil, err
+	return r.readValue(key, rowInfo.Position)
+}
+
+// ErrKeyNotFound is returned by GetExisting when the key is not found.
+var ErrKeyNotFound = errors.New("key not found")
+
+// GetExisting is like Get but returns ErrKeyNotFound if the key is not found,
+// so that missing keys can be told apart from empty values.
+func (r *Reader) GetExisting(key []byte) ([]byte, error) {
+	rowInfo := r.get(key)
+	if rowInfo == nil {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
 	}
-	return row.Value, nil
+	return r.readValue(key, rowInfo.Position)
 }
 
 type RowReader struct {
 	r       *Reader
+	idx     fidx.Keydir // keydir the current row belongs to
 	current *fidx.RowInfo
+	prefix  []byte // bounds lexicographic moves (see SeekPrefix)
 }
 
-func (r *Reader) Oldest() *RowReader {
-	oldest := r.f.idx.Oldest
-	if oldest == nil {
+// newRowReader returns a row reader on the first row that is not expired,
+// starting from current and moving forward (or backward).
+func (r *Reader) newRowReader(current *fidx.RowInfo, backward bool) *RowReader {
+	for current != nil && r.expired(current) {
+		if backward {
+			current = current.Previous
+		} else {
+			current = current.Next
+		}
+	}
+	if current == nil {
 		return nil
 	}
-	return &RowReader{r: r, current: oldest}
+	return &RowReader{r: r, idx: r.idx, current: current}
+}
+
+func (r *Reader) Oldest() *RowReader {
+	r.checkDeadline()
+	return r.newRowReader(r.idx.Chronological().Oldest, false)
 }
 
 func (r *Reader) Latest() *RowReader {
-	latest := r.f.idx.Latest
-	if latest == nil {
-		return nil
-	}
-	return &RowReader{r: r, current: latest}
+	r.checkDeadline()
+	return r.newRowReader(r.idx.Chronological().Latest, true)
 }
 
 func (r *Reader) Seek(key []byte) *RowReader {
-	rinfo := r.f.idx.Get(key)
-	if rinfo == nil {
+	row := r.get(key)
+	if row == nil {
 		return nil
 	}
-	return &RowReader{r: r, current: rinfo}
+	return r.newRowReader(row, false)
 }
 
-func (c *RowReader) Key() []byte { return c.current.Key }
+// Key returns the key of the current row (or nil if it can't be read, see WithHashedKeys).
+func (c *RowReader) Key() []byte {
+	key, _ := c.r.rowKey(c.current)
+	return key
+}
 
 func (c *RowReader) Value() ([]byte, error) {
-	row, err := c.r.f.readAndDecodeRow(c.current.Position)
+	c.sync()
+	key, err := c.r.rowKey(c.current)
 	if err != nil {
 		return nil, err
 	}
-	return row.Value, nil
+	return c.r.readValue(key, c.current.Position)
 }
 
 func (c *RowReader) Previous() *RowReader {
-	if c.current.Previous == nil {
-		return nil
-	}
-	return &RowReader{r: c.r, current: c.current.Previous}
+	c.sync()
+	return c.r.newRowReader(c.current.Previous, true)
 }
 
 func (c *RowReader) Next() *RowReader {
-	if c.current.Next == nil {
-		return nil
+	c.sync()
+	return c.r.newRowReader(c.current.Next, false)
+}
+
+// sync resolves the current row in the reader keydir if the reader switched to a snapshot.
+func (c *RowReader) sync() {
+	c.r.checkDeadline()
+	if c.idx != c.r.idx {
+		c.idx, c.current = c.r.idx, c.r.idx.Get(c.current.Key)
 	}
-	return &RowReader{r: c.r, current: c.current.Next}
 }
@@ -0,0 +1,712 @@
+package tridb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func openTestFile(t *testing.T, opts ...Option) *File {
+	t.Helper()
+	f, err := Open(filepath.Join(t.TempDir(), "test.tridb"), 10, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func mustSet(t *testing.T, f *File, key, value string) {
+	t.Helper()
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte(key), []byte(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertValue(t *testing.T, f *File, key, want string) {
+	t.Helper()
+	_ = f.Read(func(r *Reader) error {
+		got, err := r.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, []byte(want)) {
+			t.Fatalf("got value %q instead of %q for %q", got, want, key)
+		}
+		return nil
+	})
+}
+
+func TestReadTransform(t *testing.T) {
+	upgrade := func(key, value []byte) ([]byte, error) {
+		if bytes.HasPrefix(value, []byte("v1:")) {
+			return append([]byte("v2:"), value[3:]...), nil
+		}
+		return value, nil
+	}
+	f := openTestFile(t, WithReadTransform(upgrade), WithTransformOnCompact(true))
+
+	mustSet(t, f, "key", "v1:value")
+	assertValue(t, f, "key", "v2:value")
+
+	// Check that the transformed value is persisted on compaction.
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	f.opts.ReadTransform = nil
+	assertValue(t, f, "key", "v2:value")
+}
+
+func TestCompact(t *testing.T) {
+	f := openTestFile(t)
+	for i := 0; i < 1000; i++ {
+		mustSet(t, f, string(rune('a'+i%26)), string(rune('A'+i%26)))
+	}
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("b"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	var gotKeys []byte
+	_ = f.Read(func(r *Reader) error {
+		for rr := r.Oldest(); rr != nil; rr = rr.Next() {
+			gotKeys = append(gotKeys, rr.Key()...)
+		}
+		return nil
+	})
+	if want := "acdefghijklmnopqrstuvwxyz"; string(gotKeys) != want {
+		t.Fatalf("got keys %q instead of %q", gotKeys, want)
+	}
+	assertValue(t, f, "z", "Z")
+	assertValue(t, f, "b", "")
+}
+
+func TestSafeReadWrite(t *testing.T) {
+	f := openTestFile(t)
+
+	// Panics in callbacks are converted to errors
+	err := f.SafeRead(func(r *Reader) error { panic("oops") })
+	if !errors.Is(err, ErrPanic) {
+		t.Fatalf("got error %v instead of %v", err, ErrPanic)
+	}
+	if err := f.Err(); err != nil {
+		t.Fatalf("file should not be marked as failed: %v", err)
+	}
+
+	// Corruption panics mark the file as failed
+	err = f.SafeReadWrite(func(r *Reader, w *Writer) error { panic(ErrMemoryCorruption) })
+	if !errors.Is(err, ErrMemoryCorruption) {
+		t.Fatalf("got error %v instead of %v", err, ErrMemoryCorruption)
+	}
+	err = f.ReadWrite(func(r *Reader, w *Writer) error { return nil })
+	if !errors.Is(err, ErrFailed) {
+		t.Fatalf("got error %v instead of %v", err, ErrFailed)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveCursor("consumer", 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, err = Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if offset, err := f.LoadCursor("consumer"); err != nil || offset != 42 {
+		t.Fatalf("got cursor offset %d (%v) instead of 42", offset, err)
+	}
+	_ = f.Read(func(r *Reader) error {
+		if n := r.Count(); n != 0 {
+			t.Fatalf("reserved keys should not be visible, got count %d", n)
+		}
+		return nil
+	})
+
+	// Writing reserved keys directly is not allowed
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte(ReservedPrefix+"cursor/consumer"), nil)
+		return nil
+	})
+	if !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("got error %v instead of %v", err, ErrReservedKey)
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	f := openTestFile(t)
+	receipt, err := f.ReadWriteResult(func(r *Reader, w *Writer) error {
+		w.Set([]byte("key"), []byte("v1"))
+		w.Set([]byte("other"), []byte("1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq := receipt.Seq
+	mustSet(t, f, "key", "v2")
+
+	err = f.ReadAt(seq, func(r *Reader) error {
+		v, err := r.Get([]byte("key"))
+		if string(v) != "v1" || !r.Has([]byte("other")) {
+			t.Fatalf("got value %q instead of %q at seq %d", v, "v1", seq)
+		}
+		mustSet(t, f, "unrelated", "1") // replaying doesn't hold the file lock
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.ReadAt(seq+10, func(r *Reader) error { return nil }); !errors.Is(err, ErrSeqOutOfRange) {
+		t.Fatalf("got error %v instead of %v", err, ErrSeqOutOfRange)
+	}
+
+	// Sequences are kept across compactions, states before the last one are out of range.
+	latest := f.Seq()
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Seq() != latest {
+		t.Fatalf("got seq %d after compaction instead of %d", f.Seq(), latest)
+	}
+	if err := f.ReadAt(seq, func(r *Reader) error { return nil }); !errors.Is(err, ErrSeqOutOfRange) {
+		t.Fatalf("got error %v instead of %v", err, ErrSeqOutOfRange)
+	}
+	mustSet(t, f, "key", "v3")
+	err = f.ReadAt(f.Seq()-1, func(r *Reader) error {
+		v, err := r.Get([]byte("key"))
+		if string(v) != "v2" {
+			t.Fatalf("got value %q instead of %q after compaction", v, "v2")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadWriteResult(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "a", "1")
+	prev := f.Stats().FileSize
+
+	commits := []func(r *Reader, w *Writer) error{
+		func(r *Reader, w *Writer) error {
+			w.Set([]byte("b"), []byte("2"))
+			w.Set([]byte("c"), []byte("3"))
+			return nil
+		},
+		func(r *Reader, w *Writer) error { w.Rename([]byte("a"), []byte("d")); return nil }, // batch frame
+		func(r *Reader, w *Writer) error { return nil },
+	}
+	for i, do := range commits {
+		receipt, err := f.ReadWriteResult(do)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := f.Stats().FileSize
+		if receipt.Offset != prev || receipt.Size != size-prev {
+			t.Fatalf("commit %d: got receipt %+v, file went from %d to %d bytes", i, receipt, prev, size)
+		}
+		if want := []int{2, 2, 0}[i]; receipt.Rows != want {
+			t.Fatalf("commit %d: got %d rows instead of %d", i, receipt.Rows, want)
+		}
+		if want := []uint64{3, 5, 5}[i]; receipt.Seq != want || receipt.Seq != f.Seq() { // commit of "a" is 1, empty commits don't count
+			t.Fatalf("commit %d: got seq %d instead of %d", i, receipt.Seq, want)
+		}
+		prev = size
+	}
+
+	_, err := f.ReadWriteResult(func(r *Reader, w *Writer) error { return errors.New("abort") })
+	if !errors.Is(err, ErrTxnAborted) {
+		t.Fatalf("got error %v instead of %v", err, ErrTxnAborted)
+	}
+
+	// Commit sequences keep increasing across compactions and reopening, without skipping numbers.
+	set := func(r *Reader, w *Writer) error { w.Set([]byte("e"), []byte("5")); return nil }
+	last := uint64(5)
+	for i := 0; i < 3; i++ {
+		switch i {
+		case 0:
+			err = f.Compact()
+		case 1:
+			err = f.Close()
+			if err == nil {
+				f, err = Open(f.fpath, 10)
+				t.Cleanup(func() { f.Close() })
+			}
+		case 2:
+			err = f.Compact()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		receipt, err := f.ReadWriteResult(set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if receipt.Seq != last+1 {
+			t.Fatalf("step %d: got seq %d instead of %d", i, receipt.Seq, last+1)
+		}
+		last = receipt.Seq
+	}
+}
+
+func TestParanoidChecks(t *testing.T) {
+	f := openTestFile(t, WithParanoidChecks(true))
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "b", "2")
+	assertValue(t, f, "a", "1")
+
+	// Corrupt keydir by pointing "a" to the row of "b"
+	f.idx.Put([]byte("a"), f.idx.Get([]byte("b")).Position)
+	_ = f.Read(func(r *Reader) error {
+		if _, err := r.Get([]byte("a")); !errors.Is(err, ErrIndexMismatch) {
+			t.Fatalf("got error %v instead of %v", err, ErrIndexMismatch)
+		}
+		return nil
+	})
+}
+
+func TestPreCommitHook(t *testing.T) {
+	errTooBig := errors.New("transaction too big")
+	f := openTestFile(t, WithPreCommitHook(func(w *Writer) error {
+		if w.PendingRows() > 1 || w.PendingBytes() > 30 {
+			return errTooBig
+		}
+		return nil
+	}))
+
+	mustSet(t, f, "a", "1") // 1 row of 20 bytes (with timestamp)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("b"), []byte("2"))
+		w.Set([]byte("c"), []byte("3"))
+		return nil
+	})
+	if !errors.Is(err, errTooBig) {
+		t.Fatalf("got error %v instead of %v", err, errTooBig)
+	}
+	assertValue(t, f, "b", "")
+}
+
+func TestMaxReadDuration(t *testing.T) {
+	for name, storage := range map[string]StorageOpener{"file": OpenFileStorage, "memory": NewMemoryStorage()} {
+		t.Run(name, func(t *testing.T) {
+			f := openTestFile(t, WithMaxReadDuration(time.Millisecond), WithStorage(storage))
+			mustSet(t, f, "a", "1")
+			mustSet(t, f, "b", "2")
+
+			var visited []string
+			err := f.Read(func(r *Reader) error {
+				_, err := r.WalkWithValue(nil, func(key, value []byte) error {
+					visited = append(visited, string(key))
+					if string(key) == "a" {
+						// The walk exceeds its maximum duration, a writer should be able to commit
+						time.Sleep(5 * time.Millisecond)
+						done := make(chan struct{})
+						go func() { mustSet(t, f, "a2", "new"); close(done) }()
+						r.Has(key) // detaches the reader
+						<-done
+					}
+					return nil
+				})
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(visited, ","); got != "a,b" {
+				t.Fatalf("visited %q instead of %q", got, "a,b")
+			}
+			assertValue(t, f, "a2", "new")
+		})
+	}
+}
+
+func TestSkipUnchangedWrites(t *testing.T) {
+	f := openTestFile(t, WithSkipUnchangedWrites(true))
+	mustSet(t, f, "key", "value")
+	size := f.woffset
+	mustSet(t, f, "key", "value")
+	if f.woffset != size {
+		t.Fatalf("unchanged write appended %d bytes", f.woffset-size)
+	}
+
+	// Sets are compared against the previous rows of the same transaction
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("key"), []byte("other"))
+		w.Set([]byte("key"), []byte("value"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "key", "value")
+}
+
+func TestCoalescedWrites(t *testing.T) {
+	f := openTestFile(t, WithCoalescedWrites(true))
+	f.SetMergeOperator(func(key, existing []byte, operands [][]byte) ([]byte, error) {
+		return append(existing, bytes.Join(operands, nil)...), nil
+	})
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("a"), []byte("1"))
+		w.Set([]byte("b"), []byte("1"))
+		w.Set([]byte("a"), []byte("2"))
+		w.Delete([]byte("b"))
+		w.Set([]byte("c"), []byte("x"))
+		w.Merge([]byte("c"), []byte("y"))
+		w.Set([]byte("a"), []byte("3"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Stats().Rows; got != 4 {
+		t.Fatalf("got %d rows written instead of 4", got)
+	}
+	assertValue(t, f, "a", "3")
+	assertValue(t, f, "b", "")
+	assertValue(t, f, "c", "xy")
+}
+
+func TestCompactResume(t *testing.T) {
+	defer func(size int) { compactionCheckpointSize = size }(compactionCheckpointSize)
+	compactionCheckpointSize = 1
+
+	for name, storage := range map[string]StorageOpener{"file": OpenFileStorage, "memory": NewMemoryStorage()} {
+		t.Run(name, func(t *testing.T) {
+			failOn, transformed := "k50", atomic.Int64{}
+			f := openTestFile(t, WithStorage(storage), WithTransformOnCompact(true), WithReadTransform(func(key, value []byte) ([]byte, error) {
+				if string(key) == failOn {
+					return nil, errors.New("interrupted")
+				}
+				transformed.Add(1)
+				return value, nil
+			}))
+			for i := 0; i < 100; i++ {
+				mustSet(t, f, fmt.Sprintf("k%d", i), "v")
+			}
+			if err := f.Compact(); err == nil {
+				t.Fatal("compaction should have been interrupted")
+			}
+			if _, err := f.storageSize(f.Path() + compactionManifestExtension); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(f.Path() + compactionManifestExtension); name == "memory" && !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("the manifest was written to disk: %v", err)
+			}
+
+			failOn = ""
+			transformed.Store(0)
+			if err := f.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			if n := transformed.Load(); n > 60 {
+				t.Fatalf("transformed %d rows, compaction was not resumed", n)
+			}
+			if n := f.Stats().Rows; n != 100 {
+				t.Fatalf("got %d rows instead of 100", n)
+			}
+			assertValue(t, f, "k0", "v")
+			assertValue(t, f, "k99", "v")
+			if _, err := f.storageSize(f.Path() + compactionManifestExtension); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("manifest should have been removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestCompactConcurrentWrites(t *testing.T) {
+	// Pause the compaction while rewriting a row.
+	paused, resume, pause := make(chan struct{}), make(chan struct{}), sync.Once{}
+	f := openTestFile(t, WithTransformOnCompact(true), WithReadTransform(func(key, value []byte) ([]byte, error) {
+		if string(key) == "k50" {
+			pause.Do(func() { close(paused); <-resume })
+		}
+		return value, nil
+	}))
+	f.SetMergeOperator(func(key, existing []byte, operands [][]byte) ([]byte, error) {
+		return append(existing, bytes.Join(operands, nil)...), nil
+	})
+	for i := 0; i < 100; i++ {
+		mustSet(t, f, fmt.Sprintf("k%d", i), "v")
+	}
+	mustSet(t, f, "m", "a")
+	compacted := make(chan error)
+	go func() { compacted <- f.Compact() }()
+	<-paused
+
+	// Write while rows are being compacted.
+	written := make(chan error)
+	go func() {
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			w.Set([]byte("k0"), []byte("new"))
+			w.Delete([]byte("k1"))
+			w.Set([]byte("late"), []byte("x"))
+			return nil
+		})
+		if err == nil {
+			err = f.ReadWrite(func(r *Reader, w *Writer) error { w.Merge([]byte("m"), []byte("b")); return nil })
+		}
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes blocked by the compaction")
+	}
+	close(resume)
+	if err := <-compacted; err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the rows written during the compaction were copied to the new file.
+	check := func(f *File) {
+		t.Helper()
+		assertValue(t, f, "k0", "new")
+		assertValue(t, f, "k1", "")
+		assertValue(t, f, "k99", "v")
+		assertValue(t, f, "late", "x")
+		assertValue(t, f, "m", "ab")
+		if n := f.Stats().Keys; n != 101 {
+			t.Fatalf("got %d keys instead of 101", n)
+		}
+	}
+	check(f)
+	opts := f.opts
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenWithOptions(f.Path(), &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.SetMergeOperator(func(key, existing []byte, operands [][]byte) ([]byte, error) {
+		return nil, errors.New("unexpected merge")
+	})
+	check(f)
+}
+
+func TestTypedErrors(t *testing.T) {
+	f, err := Open(filepath.Join(t.TempDir(), "test.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "empty", "")
+	errCallback := errors.New("callback failed")
+	for _, test := range []struct {
+		do   func(r *Reader, w *Writer) error
+		want []error
+	}{
+		{func(r *Reader, w *Writer) error { return errCallback }, []error{ErrTxnAborted, errCallback}},
+		{func(r *Reader, w *Writer) error { w.Set(nil, nil); return nil }, []error{ErrTxnAborted, ErrInvalidKey}},
+		{func(r *Reader, w *Writer) error { w.Delete(make([]byte, MaxKeyLength+1)); return nil }, []error{ErrInvalidKey, ErrKeyTooLong}},
+		{func(r *Reader, w *Writer) error { w.Set([]byte(ReservedPrefix+"x"), nil); return nil }, []error{ErrInvalidKey, ErrReservedKey}},
+		{func(r *Reader, w *Writer) error { w.SetIfAbsent([]byte("empty"), nil); return nil }, []error{ErrTxnAborted, ErrConditionFailed}},
+	} {
+		err := f.ReadWrite(test.do)
+		for _, want := range test.want {
+			if !errors.Is(err, want) {
+				t.Fatalf("got error %v instead of %v", err, want)
+			}
+		}
+	}
+	b := f.Batch()
+	b.Set(nil, nil)
+	if err := b.Commit(); !errors.Is(err, ErrTxnAborted) || !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("got error %v committing an invalid batch", err)
+	}
+
+	_ = f.Read(func(r *Reader) error {
+		if value, err := r.GetExisting([]byte("empty")); err != nil || len(value) != 0 {
+			t.Fatalf("got value %q (%v) for empty value", value, err)
+		}
+		if _, err := r.GetExisting([]byte("missing")); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("got error %v instead of %v", err, ErrKeyNotFound)
+		}
+		return nil
+	})
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Read(func(r *Reader) error { return nil }); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got error %v instead of %v", err, ErrClosed)
+	}
+	if err := f.ReadWrite(func(r *Reader, w *Writer) error { return nil }); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got error %v instead of %v", err, ErrClosed)
+	}
+	if err := f.Compact(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got error %v instead of %v", err, ErrClosed)
+	}
+	if err := f.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got error %v closing twice", err)
+	}
+}
+
+func TestContextTransactions(t *testing.T) {
+	f := openTestFile(t)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		for i := 0; i < 10; i++ {
+			w.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Canceled walks
+	ctx, cancel := context.WithCancel(context.Background())
+	visited := 0
+	err = f.ReadCtx(ctx, func(r *Reader) error {
+		_, err := r.Walk(nil, func(key []byte) error {
+			if visited++; visited == 3 {
+				cancel()
+			}
+			return nil
+		})
+		return err
+	})
+	if !errors.Is(err, context.Canceled) || visited != 3 {
+		t.Fatalf("got error %v after visiting %d keys", err, visited)
+	}
+
+	// Canceled commits
+	ctx, cancel = context.WithCancel(context.Background())
+	err = f.ReadWriteCtx(ctx, func(r *Reader, w *Writer) error {
+		w.Set([]byte("canceled"), []byte("value"))
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrTxnAborted) {
+		t.Fatalf("got error %v", err)
+	}
+	_ = f.Read(func(r *Reader) error {
+		if r.Has([]byte("canceled")) {
+			t.Fatal("canceled transaction was committed")
+		}
+		return nil
+	})
+
+	// Deadline while waiting for the lock
+	locked, unlock := make(chan struct{}), make(chan struct{})
+	go f.ReadWrite(func(r *Reader, w *Writer) error {
+		close(locked)
+		<-unlock
+		return nil
+	})
+	<-locked
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.ReadCtx(ctx, func(r *Reader) error { return nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v while waiting for the lock", err)
+	}
+	if err := f.ReadWriteCtx(ctx, func(r *Reader, w *Writer) error { return nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v while waiting for the lock", err)
+	}
+	close(unlock)
+	if err := f.ReadCtx(context.Background(), func(r *Reader) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInconsistentCommit(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "a", "1")
+	size := f.Stats().FileSize
+	mustSet(t, f, "b", "2")
+
+	// Failed commits return an error and mark the file as failed instead of panicking
+	f.mu.Lock()
+	err := f.handleCorruption(errors.New("sync failed"), size)
+	f.mu.Unlock()
+	if !errors.Is(err, ErrInconsistent) || !errors.Is(err, ErrMemoryCorruption) {
+		t.Fatalf("got error %v instead of %v", err, ErrInconsistent)
+	}
+	if got, _ := os.Stat(f.fpath); got.Size() != int64(size) {
+		t.Fatalf("got file size %d instead of %d", got.Size(), size)
+	}
+	if err := f.Read(func(r *Reader) error { return nil }); !errors.Is(err, ErrFailed) || !errors.Is(err, ErrInconsistent) {
+		t.Fatalf("got error %v instead of %v", err, ErrFailed)
+	}
+	if err := f.ReadWrite(func(r *Reader, w *Writer) error { return nil }); !errors.Is(err, ErrFailed) {
+		t.Fatalf("got error %v instead of %v", err, ErrFailed)
+	}
+}
+
+// writeHookWriter calls hook before its first write.
+type writeHookWriter struct {
+	buf  bytes.Buffer
+	hook func()
+}
+
+func (w *writeHookWriter) Write(p []byte) (int, error) {
+	if w.hook != nil {
+		w.hook()
+		w.hook = nil
+	}
+	return w.buf.Write(p)
+}
+
+func TestCopyToConcurrentWrites(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "a", "1")
+
+	// Writes and compactions don't block on (nor affect) a copy in progress.
+	backup := &writeHookWriter{hook: func() {
+		mustSet(t, f, "a", "updated")
+		mustSet(t, f, "b", "2")
+		if err := f.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	if _, err := f.CopyTo(backup); err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(t.TempDir(), "backup.tridb")
+	if err := os.WriteFile(fpath, backup.buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	assertValue(t, restored, "a", "1")
+	assertValue(t, restored, "b", "")
+	assertValue(t, f, "a", "updated")
+}
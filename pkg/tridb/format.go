@@ -0,0 +1,414 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Format encodes and decodes rows persisted in the datafile.
+type Format interface {
+	// Name identifies the format (see FormatFromString).
+	Name() string
+	// Encode returns the encoded row or an error if the row is not valid.
+	Encode(row *Row) ([]byte, error)
+	// DecodeFrom decodes a row from the given reader into row.
+	// It reports the number of bytes read from the reader and an eventual error.
+	DecodeFrom(r io.Reader, row *Row) (int, error)
+}
+
+// Available formats.
+var (
+	// BinaryEncoding is the default compact format (see Row.Encode).
+	BinaryEncoding Format = binaryFormat{}
+	// TextEncoding is a line-based format with explicit key and value lengths, for example:
+	//	+ 3 5 key value
+	// Keys and values are written verbatim (binary-safe thanks to the lengths).
+	TextEncoding Format = textFormat{}
+	// TextAutoLengthEncoding is a line-based format with quoted keys and values (no lengths), for example:
+	//	+ "key" "value"
+	// It is the easiest format to read and edit by hand.
+	TextAutoLengthEncoding Format = textAutoLengthFormat{}
+)
+
+// Formats lists the available formats.
+var Formats = []Format{BinaryEncoding, TextEncoding, TextAutoLengthEncoding}
+
+// Format returns the row format of the file (as read from its header or detected, see WithFormat),
+// it changes when a compaction upgrades the format (see WithFormatUpgrade).
+func (f *File) Format() Format {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.format
+}
+
+// FormatFromString returns the format with the given name.
+func FormatFromString(name string) (Format, error) {
+	for _, format := range Formats {
+		if format.Name() == name {
+			return format, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown format: %q", name)
+}
+
+// binaryFormat decodes both delete encodings, compact tombstones are only encoded when enabled.
+type binaryFormat struct {
+	compactTombstones bool // see WithCompactTombstones
+}
+
+func (binaryFormat) Name() string                                  { return "binary" }
+func (binaryFormat) DecodeFrom(r io.Reader, row *Row) (int, error) { return row.DecodeFrom(r) }
+
+func (bf binaryFormat) Encode(row *Row) ([]byte, error) {
+	if bf.compactTombstones && row.IsDeleted {
+		return row.encodeTombstone()
+	}
+	return row.Encode()
+}
+
+// encodedSize returns the number of bytes of the encoded row.
+func (bf binaryFormat) encodedSize(row *Row) int {
+	if bf.compactTombstones && row.IsDeleted {
+		return row.encodedTombstoneSize()
+	}
+	return row.EncodedSize()
+}
+
+type textFormat struct{}
+
+func (textFormat) Name() string { return "text" }
+
+func (textFormat) Encode(row *Row) ([]byte, error) {
+	if err := row.Validate(); err != nil {
+		return nil, err
+	}
+	encoded := appendTextOp(nil, row)
+	encoded = strconv.AppendInt(append(encoded, ' '), int64(len(row.Key)), 10)
+	encoded = strconv.AppendInt(append(encoded, ' '), int64(len(row.Value)), 10)
+	encoded = append(append(encoded, ' '), row.Key...)
+	encoded = append(append(encoded, ' '), row.Value...)
+	return append(encoded, '\n'), nil
+}
+
+func (textFormat) DecodeFrom(r io.Reader, row *Row) (int, error) {
+	tr := &textReader{r: r}
+	decoded, err := tr.readOp()
+	if err != nil {
+		return tr.n, err
+	}
+	keyLength, err := tr.readInt(MaxKeyLength)
+	if err != nil {
+		return tr.n, fmt.Errorf("read key length: %w", err)
+	}
+	valueLength, err := tr.readInt(MaxValueLength)
+	if err == nil {
+		err = checkRowSize(valueLength)
+	}
+	if err != nil {
+		return tr.n, fmt.Errorf("read value length: %w", err)
+	}
+	decoded.Key, err = tr.readN(keyLength, ' ')
+	if err != nil {
+		return tr.n, fmt.Errorf("read key: %w", err)
+	}
+	decoded.Value, err = tr.readN(valueLength, '\n')
+	if err != nil {
+		return tr.n, fmt.Errorf("read value: %w", err)
+	}
+	*row = decoded
+	return tr.n, nil
+}
+
+type textAutoLengthFormat struct{}
+
+func (textAutoLengthFormat) Name() string { return "text-auto-length" }
+
+func (textAutoLengthFormat) Encode(row *Row) ([]byte, error) {
+	if err := row.Validate(); err != nil {
+		return nil, err
+	}
+	encoded := appendTextOp(nil, row)
+	encoded = strconv.AppendQuote(append(encoded, ' '), string(row.Key))
+	if !row.IsDeleted {
+		encoded = strconv.AppendQuote(append(encoded, ' '), string(row.Value))
+	}
+	return append(encoded, '\n'), nil
+}
+
+func (textAutoLengthFormat) DecodeFrom(r io.Reader, row *Row) (int, error) {
+	tr := &textReader{r: r}
+	decoded, err := tr.readOp()
+	if err != nil {
+		return tr.n, err
+	}
+	line, err := tr.readUntil('\n', -1)
+	if err != nil {
+		return tr.n, fmt.Errorf("read line: %w", err)
+	}
+	fields := make([][]byte, 0, 2)
+	for rest := string(line); rest != ""; {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return tr.n, fmt.Errorf("read quoted string: %w", err)
+		}
+		unquoted, _ := strconv.Unquote(quoted)
+		fields = append(fields, []byte(unquoted))
+		rest = rest[len(quoted):]
+		if rest != "" {
+			if rest[0] != ' ' {
+				return tr.n, fmt.Errorf("unexpected character %q after quoted string", rest[0])
+			}
+			rest = rest[1:]
+		}
+	}
+	want := 2
+	if decoded.IsDeleted {
+		want = 1
+	}
+	if len(fields) != want {
+		return tr.n, fmt.Errorf("got %d quoted strings instead of %d", len(fields), want)
+	}
+	decoded.Key = fields[0]
+	if !decoded.IsDeleted {
+		decoded.Value = fields[1]
+	}
+	if err := decoded.Validate(); err != nil {
+		return tr.n, err
+	}
+	*row = decoded
+	return tr.n, nil
+}
+
+// appendTextOp appends the op (and the hex-encoded attributes for rows that have some).
+func appendTextOp(dst []byte, row *Row) []byte {
+	attrs := row.appendAttrs(nil)
+	if len(attrs) == 0 {
+		return append(dst, row.op(false))
+	}
+	dst = append(dst, row.op(true), ' ')
+	return append(dst, hex.EncodeToString(attrs)...)
+}
+
+// textReader reads text rows byte by byte (so that it never reads past the end of a row).
+type textReader struct {
+	r io.Reader
+	n int // number of bytes read
+}
+
+func (tr *textReader) readByte() (byte, error) {
+	if br, ok := tr.r.(io.ByteReader); ok {
+		c, err := br.ReadByte()
+		if err == nil {
+			tr.n++
+		}
+		return c, err
+	}
+	b := [1]byte{}
+	n, err := io.ReadFull(tr.r, b[:])
+	tr.n += n
+	return b[0], err
+}
+
+// readUntil reads bytes until the given delimiter (excluded), failing after max bytes (if not negative).
+func (tr *textReader) readUntil(delim byte, max int) ([]byte, error) {
+	var out []byte
+	for {
+		c, err := tr.readByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if c == delim {
+			return out, nil
+		}
+		if max >= 0 && len(out) == max {
+			return nil, fmt.Errorf("missing delimiter %q", delim)
+		}
+		out = append(out, c)
+	}
+}
+
+// readOp reads the op (and eventual attributes) followed by a space.
+func (tr *textReader) readOp() (Row, error) {
+	op, err := tr.readByte()
+	if err != nil {
+		return Row{}, fmt.Errorf("read op: %w", err)
+	}
+	decoded, hasAttrs, err := decodeOp(op)
+	if err != nil {
+		return Row{}, err
+	}
+	if c, err := tr.readByte(); err != nil || c != ' ' {
+		return Row{}, fmt.Errorf("read space after op: %w", orUnexpected(err, c))
+	}
+	if hasAttrs {
+		encodedAttrs, err := tr.readUntil(' ', 2*maxAttrsLength)
+		if err != nil {
+			return Row{}, fmt.Errorf("read attributes: %w", err)
+		}
+		attrs, err := hex.DecodeString(string(encodedAttrs))
+		if err != nil {
+			return Row{}, fmt.Errorf("decode attributes: %w", err)
+		}
+		_, err = decoded.decodeAttrs(attrs)
+		if err != nil {
+			return Row{}, fmt.Errorf("decode attributes: %w", err)
+		}
+	}
+	return decoded, nil
+}
+
+// readInt reads a decimal integer in [0, max] followed by a space.
+func (tr *textReader) readInt(max int) (int, error) {
+	digits, err := tr.readUntil(' ', len(strconv.Itoa(max)))
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(string(digits))
+	if err != nil || n < 0 || n > max || (len(digits) > 1 && digits[0] == '0') {
+		return 0, fmt.Errorf("invalid length %q", digits)
+	}
+	return n, nil
+}
+
+// readN reads n bytes followed by the given delimiter.
+func (tr *textReader) readN(n int, delim byte) ([]byte, error) {
+	out := make([]byte, 0, min(n, 4096))
+	for len(out) < n {
+		c, err := tr.readByte()
+		if err != nil {
+			return nil, orUnexpected(err, 0)
+		}
+		out = append(out, c)
+	}
+	if c, err := tr.readByte(); err != nil || c != delim {
+		return nil, fmt.Errorf("read delimiter: %w", orUnexpected(err, c))
+	}
+	return out, nil
+}
+
+// orUnexpected returns err (io.EOF being converted to io.ErrUnexpectedEOF) or an unexpected character error.
+func orUnexpected(err error, c byte) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("unexpected character %q", c)
+}
+
+// Format detection decodes the first entries of the file with each format.
+const (
+	formatProbeSize = 64 << 10
+	formatProbeRows = 8
+)
+
+// Format detection errors.
+var (
+	ErrUnknownFormat   = errors.New("unknown file format")
+	ErrAmbiguousFormat = errors.New("ambiguous file format")
+)
+
+// DetectFormat returns the format of the rows at the start of the given reader.
+// Files starting with a header are identified by it, the format of older files is guessed from their first rows:
+// the preferred format (if not nil) is returned when the content is empty
+// or when it is one of several formats matching the content.
+//
+// Content that is not a tridb datafile is reported with an error wrapping ErrBadFileFormat.
+func DetectFormat(r io.Reader, preferred Format) (Format, error) {
+	probe, err := io.ReadAll(io.LimitReader(r, formatProbeSize))
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(bytes.NewReader(probe))
+	if h, ok, err := readFileHeader(br); err != nil {
+		return nil, err
+	} else if ok {
+		if op, _ := br.Peek(1); len(op) == 1 && isEncryptedOp(op[0]) {
+			return nil, ErrEncryptedFile // rows encrypted after the file was created (see WithEncryption)
+		}
+		return h.rowFormat(preferred)
+	}
+	if preferred == nil {
+		preferred = BinaryEncoding
+	}
+	if len(probe) == 0 {
+		return preferred, nil
+	}
+	if isEncryptedOp(probe[0]) {
+		return nil, ErrEncryptedFile
+	}
+	// Formats decoding complete rows are preferred over formats only decoding the start of a torn row.
+	var matches, partialMatches []Format
+	for _, format := range Formats {
+		switch probeFormat(format, probe) {
+		case probeMatch:
+			matches = append(matches, format)
+		case probePartialMatch:
+			partialMatches = append(partialMatches, format)
+		}
+	}
+	if len(matches) == 0 {
+		matches = partialMatches
+	}
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) == 0:
+		return nil, fmt.Errorf("%w: %w", ErrBadFileFormat, ErrUnknownFormat)
+	}
+	names := make([]string, len(matches))
+	for i, format := range matches {
+		if format == preferred {
+			return format, nil
+		}
+		names[i] = format.Name()
+	}
+	return nil, fmt.Errorf("%w: could be %q", ErrAmbiguousFormat, names)
+}
+
+// Results of probeFormat.
+const (
+	probeMismatch = iota
+	probePartialMatch
+	probeMatch
+)
+
+// probeFormat reports whether the first rows of the probe can be decoded with the given format
+// (the end of the probe may cut a row). Formats decoding the first rows before failing only partially match.
+func probeFormat(format Format, probe []byte) int {
+	r := bufio.NewReader(bytes.NewReader(probe))
+	row := Row{}
+	for numRows := 0; numRows < formatProbeRows; numRows++ {
+		var err error
+		if op, _ := r.Peek(1); len(op) == 1 && isEncryptedOp(op[0]) {
+			return probeMatch // rows encrypted after the first ones (see WithEncryption)
+		} else if len(op) == 1 && op[0] == opBatch {
+			_, _, err = decodeBatchFrom(format, r)
+		} else {
+			_, err = format.DecodeFrom(r, &row)
+		}
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errTornBatch):
+			if numRows == 0 {
+				return probePartialMatch
+			}
+			return probeMatch
+		case numRows > 0:
+			return probePartialMatch // corrupt row after valid ones (see WithCorruptTailRepair)
+		default:
+			return probeMismatch
+		}
+	}
+	return probeMatch
+}
@@ -0,0 +1,133 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	rows := []*Row{
+		{Key: []byte("key"), Value: []byte("value")},
+		{Key: []byte("with space"), Value: []byte("line\nbreak \"quoted\" \x00\xff")},
+		{IsDeleted: true, Key: []byte("key"), Timestamp: 42, Checksum: 123},
+		{Key: []byte("alias"), Value: []byte("key"), IsAlias: true, ExpiresAt: 7},
+	}
+	for _, format := range Formats {
+		t.Run(format.Name(), func(t *testing.T) {
+			encoded := []byte{}
+			for _, row := range rows {
+				b, err := format.Encode(row)
+				if err != nil {
+					t.Fatal(err)
+				}
+				encoded = append(encoded, b...)
+			}
+			r := bytes.NewReader(encoded)
+			for _, want := range rows {
+				got := &Row{}
+				if _, err := format.DecodeFrom(r, got); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Value, want.Value) || !reflect.DeepEqual(got.withValue(nil), want.withValue(nil)) {
+					t.Fatalf("got row %+v instead of %+v", got, want)
+				}
+			}
+
+			// The format is detected when re-opening the file.
+			fpath := filepath.Join(t.TempDir(), "test.tridb")
+			f, err := Open(fpath, 1, WithFormat(format))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustSet(t, f, "a", "1")
+			b := f.Batch()
+			b.Set([]byte("b"), []byte("2\n"))
+			b.Delete([]byte("a"))
+			if err := b.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			if f, err = Open(fpath, 1); err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if f.Format() != format {
+				t.Fatalf("detected format %q instead of %q", f.Format().Name(), format.Name())
+			}
+			assertValue(t, f, "a", "")
+			assertValue(t, f, "b", "2\n")
+			if err := f.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			assertValue(t, f, "b", "2\n")
+		})
+	}
+}
+
+func (row Row) withValue(value []byte) Row {
+	row.Value = value
+	return row
+}
+
+func TestDetectFormatUnknown(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	if err := os.WriteFile(fpath, []byte("hello world"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(fpath, 1); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("got error %v instead of %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestFormatUpgrade(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 10, WithFormat(TextEncoding))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "b", "2")
+	mustSet(t, f, "a", "3")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = Open(fpath, 10, WithFormatUpgrade(BinaryEncoding))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.format != TextEncoding {
+		t.Fatalf("got format %q before compaction", f.format.Name())
+	}
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if f.format.Name() != BinaryEncoding.Name() {
+		t.Fatalf("got format %q after compaction", f.format.Name())
+	}
+	mustSet(t, f, "c", "4")
+	assertValue(t, f, "a", "3")
+	assertValue(t, f, "b", "2")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format, err := DetectFormat(bytes.NewReader(content), nil); err != nil || format != BinaryEncoding {
+		t.Fatalf("got format %v (%v) after upgrade", format, err)
+	}
+	f, err = Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	assertValue(t, f, "a", "3")
+	assertValue(t, f, "c", "4")
+}
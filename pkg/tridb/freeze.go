@@ -0,0 +1,31 @@
+package tridb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FreezeWrites blocks new commits (transactions, batches and compactions) until release is called,
+// so that external tools (ex: LVM, ZFS or EBS snapshots) can capture a crash-consistent image of the file.
+//
+// It waits for in-flight commits (unless the context is done first, the context error is then returned),
+// syncs the file and returns the durable offset: the size of the file as captured by the snapshot.
+// Reads are not blocked, writers block until release is called (or their context is done, see ReadWriteCtx).
+func (f *File) FreezeWrites(ctx context.Context) (release func(), offset int, err error) {
+	if err := lockCtx(ctx, f.freeze.Lock, f.freeze.Unlock); err != nil {
+		return nil, 0, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.checkWritable(); err != nil {
+		f.freeze.Unlock()
+		return nil, 0, err
+	}
+	if err := f.syncFile(); err != nil {
+		f.freeze.Unlock()
+		return nil, 0, fmt.Errorf("sync: %w", err)
+	}
+	f.dirty = false
+	return sync.OnceFunc(f.freeze.Unlock), f.woffset, nil
+}
@@ -0,0 +1,62 @@
+package tridb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFreezeWrites(t *testing.T) {
+	f := openTestFile(t, WithSync(SyncInterval, time.Hour))
+	set := func(ctx context.Context, key string) error {
+		return f.ReadWriteCtx(ctx, func(r *Reader, w *Writer) error {
+			w.Set([]byte(key), []byte("value"))
+			return nil
+		})
+	}
+	if err := set(context.Background(), "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	release, offset, err := f.FreezeWrites(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := f.Stats(); offset != stats.FileSize {
+		t.Fatalf("got offset %d instead of %d", offset, stats.FileSize)
+	}
+
+	// Commits are blocked, reads are not
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := set(ctx, "canceled"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v while frozen", err)
+	}
+	if err := f.Read(func(r *Reader) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- set(context.Background(), "after") }()
+	select {
+	case err := <-done:
+		t.Fatalf("commit returned while frozen: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Only one freeze at a time
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := f.FreezeWrites(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v when freezing twice", err)
+	}
+
+	release()
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if stats := f.Stats(); stats.FileSize <= offset {
+		t.Fatalf("got file size %d after release (frozen at %d)", stats.FileSize, offset)
+	}
+}
@@ -0,0 +1,93 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Frozen prefixes are stored in the reserved keyspace.
+const frozenPrefixKeyPrefix = "frozen-prefix/"
+
+// ErrFrozenPrefix is returned when committing a write to a key under a frozen prefix (see File.FreezePrefix).
+var ErrFrozenPrefix = errors.New("frozen prefix")
+
+// FreezePrefix makes the keys starting with the given prefix read-only:
+// commits setting, deleting or merging such keys fail with ErrFrozenPrefix until the prefix is unfrozen.
+// Frozen keys can still be read, and expire as usual (see SetPrefixTTL).
+//
+// The frozen prefix is persisted in the file.
+func (f *File) FreezePrefix(prefix []byte) error {
+	return f.setFrozenPrefix(prefix, true)
+}
+
+// UnfreezePrefix makes the keys starting with the given prefix writable again (see FreezePrefix).
+// Keys under another frozen prefix (for example, a shorter one) remain read-only.
+func (f *File) UnfreezePrefix(prefix []byte) error {
+	return f.setFrozenPrefix(prefix, false)
+}
+
+func (f *File) setFrozenPrefix(prefix []byte, frozen bool) error {
+	name := frozenPrefixKeyPrefix + string(prefix)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		if frozen {
+			w.setReserved(name, nil)
+		} else {
+			w.deleteReserved(name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.loadFrozenPrefixes()
+}
+
+// FrozenPrefixes returns the frozen prefixes in lexicographical order.
+func (f *File) FrozenPrefixes() [][]byte {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	prefixes := make([][]byte, len(f.frozen))
+	for i, prefix := range f.frozen {
+		prefixes[i] = bytes.Clone(prefix)
+	}
+	return prefixes
+}
+
+// loadFrozenPrefixes loads the frozen prefixes from the reserved keyspace.
+func (f *File) loadFrozenPrefixes() error {
+	var prefixes [][]byte
+	keyPrefix := reservedKey(frozenPrefixKeyPrefix)
+	err := f.sys.WalkRange(keyPrefix, fidx.PrefixEnd(keyPrefix), false, func(rowInfo *fidx.RowInfo) error {
+		prefixes = append(prefixes, bytes.TrimPrefix(rowInfo.Key, keyPrefix))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load frozen prefixes: %w", err)
+	}
+	f.frozen = prefixes
+	return nil
+}
+
+// checkFrozen returns an error wrapping ErrFrozenPrefix if one of the given rows writes a key under a frozen prefix.
+func (f *File) checkFrozen(rows []*Row) error {
+	if len(f.frozen) == 0 {
+		return nil
+	}
+	for _, row := range rows {
+		if IsReservedKey(row.Key) {
+			continue
+		}
+		for _, prefix := range f.frozen {
+			if bytes.HasPrefix(row.Key, prefix) {
+				return fmt.Errorf("%w: %q starts with %q", ErrFrozenPrefix, row.Key, prefix)
+			}
+		}
+	}
+	return nil
+}
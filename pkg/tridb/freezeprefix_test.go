@@ -0,0 +1,51 @@
+package tridb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFreezePrefix(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "archive/2023", "done")
+	if err := f.FreezePrefix([]byte("archive/")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes under the prefix are rejected (with transactions and batches), other keys are writable.
+	err := f.ReadWrite(func(r *Reader, w *Writer) error { w.Delete([]byte("archive/2023")); return nil })
+	if !errors.Is(err, ErrFrozenPrefix) || !errors.Is(err, ErrTxnAborted) {
+		t.Fatalf("got error %v instead of %v", err, ErrFrozenPrefix)
+	}
+	b := f.Batch()
+	b.Set([]byte("archive/2024"), []byte("new"))
+	if err := b.Commit(); !errors.Is(err, ErrFrozenPrefix) {
+		t.Fatalf("got error %v instead of %v", err, ErrFrozenPrefix)
+	}
+	mustSet(t, f, "live/2024", "ongoing")
+	assertValue(t, f, "archive/2023", "done")
+
+	// The frozen prefix is persisted.
+	opts := f.opts
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err = OpenWithOptions(f.Path(), &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if prefixes := f.FrozenPrefixes(); len(prefixes) != 1 || string(prefixes[0]) != "archive/" {
+		t.Fatalf("got frozen prefixes %q", prefixes)
+	}
+	err = f.ReadWrite(func(r *Reader, w *Writer) error { w.Set([]byte("archive/2023"), nil); return nil })
+	if !errors.Is(err, ErrFrozenPrefix) {
+		t.Fatalf("got error %v instead of %v", err, ErrFrozenPrefix)
+	}
+
+	if err := f.UnfreezePrefix([]byte("archive/")); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "archive/2024", "new")
+	assertValue(t, f, "archive/2024", "new")
+}
@@ -0,0 +1,54 @@
+package tridb
+
+import "time"
+
+// DeadBytes returns the size of the overwritten and deleted rows (and tombstones), reclaimed by compaction.
+func (f *File) DeadBytes() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.woffset - f.headerSize - f.liveBytes
+}
+
+// GarbageRatio returns the ratio of dead bytes to the size of the rows of the file (0 for an empty file).
+func (f *File) GarbageRatio() float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.woffset == f.headerSize {
+		return 0
+	}
+	return float64(f.woffset-f.headerSize-f.liveBytes) / float64(f.woffset-f.headerSize)
+}
+
+// CompactionEstimate is the expected outcome of a compaction (see File.EstimateCompaction).
+type CompactionEstimate struct {
+	Size      int // Expected size of the file after compaction.
+	Reclaimed int // Expected number of bytes reclaimed.
+	// Duration is extrapolated from the throughput of the last compaction,
+	// it is zero if the file wasn't compacted since it was opened.
+	Duration time.Duration
+}
+
+// EstimateCompaction returns the expected outcome of compacting the file now, so that compactions
+// can be scheduled (ex: when enough bytes would be reclaimed, in a window long enough).
+//
+// The size doesn't account for the rows that compaction keeps (see WithHistoryRetention and WithKeepVersions)
+// or removes (expired keys), nor for a format upgrade (see WithFormatUpgrade).
+func (f *File) EstimateCompaction() CompactionEstimate {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	size := newFileHeader(f.format).size() + f.liveBytes
+	rows := f.idx.Chronological().Count + f.sys.Chronological().Count
+	if seq := f.sys.Get(reservedKey(seqKey)); seq != nil {
+		size, rows = size-seq.Position.Size(), rows-1 // written again if needed (see File.writeSeq)
+	}
+	if f.seqBase+f.numRows != rows {
+		if _, encoded, err := f.encodeSeq(rows+1, f.format); err == nil {
+			size += len(encoded)
+		}
+	}
+	estimate := CompactionEstimate{Size: size, Reclaimed: max(0, f.woffset-size)}
+	if f.compactRate > 0 {
+		estimate.Duration = time.Duration(float64(f.woffset) / f.compactRate * float64(time.Second))
+	}
+	return estimate
+}
@@ -0,0 +1,37 @@
+package tridb
+
+import "testing"
+
+func TestEstimateCompaction(t *testing.T) {
+	f := openTestFile(t)
+	if ratio := f.GarbageRatio(); ratio != 0 {
+		t.Fatalf("got garbage ratio %f for an empty file", ratio)
+	}
+	for _, value := range []string{"1", "2", "3", "4"} {
+		mustSet(t, f, "a", value)
+	}
+	mustSet(t, f, "b", "1")
+	if dead, stats := f.DeadBytes(), f.Stats(); dead != stats.DeadBytes || dead == 0 {
+		t.Fatalf("got %d dead bytes, stats report %d", dead, stats.DeadBytes)
+	}
+	if ratio := f.GarbageRatio(); ratio < 0.5 || ratio >= 1 {
+		t.Fatalf("got garbage ratio %f with 3 overwritten rows out of 5", ratio)
+	}
+	estimate := f.EstimateCompaction()
+	if estimate.Duration != 0 {
+		t.Fatalf("got estimated duration %s before any compaction", estimate.Duration)
+	}
+	before := f.Stats().FileSize
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if after := f.Stats().FileSize; estimate.Size != after || estimate.Reclaimed != before-after {
+		t.Fatalf("got estimate %+v, compaction went from %d to %d bytes", estimate, before, after)
+	}
+	if estimate := f.EstimateCompaction(); estimate.Reclaimed != 0 || estimate.Duration <= 0 {
+		t.Fatalf("got estimate %+v after compaction", estimate)
+	}
+	if ratio := f.GarbageRatio(); ratio != 0 {
+		t.Fatalf("got garbage ratio %f after compaction", ratio)
+	}
+}
@@ -0,0 +1,155 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Geo keys are 12-character geohashes (with a precision of a few centimeters):
+// nearby locations share a common prefix, so bounding boxes can be queried with a few prefix walks.
+const (
+	GeoKeyLength  = 12
+	geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+	maxGeoCells   = 32 // maximum number of prefix walks for a bounding box query
+)
+
+// ErrInvalidCoordinates is returned for latitudes outside [-90, 90] or longitudes outside [-180, 180].
+var ErrInvalidCoordinates = errors.New("invalid coordinates")
+
+// EncodeGeoKey returns the geohash of the given location.
+// A suffix (ex: an ID) can be appended to store multiple records at the same location.
+func EncodeGeoKey(lat, lon float64) ([]byte, error) {
+	if err := validateCoordinates(lat, lon); err != nil {
+		return nil, err
+	}
+	return []byte(geohash(lat, lon, GeoKeyLength)), nil
+}
+
+// DecodeGeoKey returns the location encoded at the start of the given key (see EncodeGeoKey).
+func DecodeGeoKey(key []byte) (lat, lon float64, err error) {
+	if len(key) < GeoKeyLength {
+		return 0, 0, fmt.Errorf("invalid geo key length: %d", len(key))
+	}
+	minLat, maxLat, minLon, maxLon := -90.0, 90.0, -180.0, 180.0
+	for i, c := range key[:GeoKeyLength] {
+		v := strings.IndexByte(geohashBase32, c)
+		if v < 0 {
+			return 0, 0, fmt.Errorf("invalid geohash character %q", c)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			isLonBit := (i*5+4-bit)%2 == 0
+			set := v&(1<<bit) != 0
+			switch {
+			case isLonBit && set:
+				minLon = (minLon + maxLon) / 2
+			case isLonBit:
+				maxLon = (minLon + maxLon) / 2
+			case set:
+				minLat = (minLat + maxLat) / 2
+			default:
+				maxLat = (minLat + maxLat) / 2
+			}
+		}
+	}
+	return (minLat + maxLat) / 2, (minLon + maxLon) / 2, nil
+}
+
+// geohash returns the geohash of the given location with the given number of characters.
+func geohash(lat, lon float64, precision int) string {
+	minLat, maxLat, minLon, maxLon := -90.0, 90.0, -180.0, 180.0
+	hash := make([]byte, precision)
+	for i := range hash {
+		v := 0
+		for bit := 4; bit >= 0; bit-- {
+			if (i*5+4-bit)%2 == 0 {
+				mid := (minLon + maxLon) / 2
+				if lon >= mid {
+					v, minLon = v|1<<bit, mid
+				} else {
+					maxLon = mid
+				}
+			} else {
+				mid := (minLat + maxLat) / 2
+				if lat >= mid {
+					v, minLat = v|1<<bit, mid
+				} else {
+					maxLat = mid
+				}
+			}
+		}
+		hash[i] = geohashBase32[v]
+	}
+	return string(hash)
+}
+
+func validateCoordinates(lat, lon float64) error {
+	if !(lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180) {
+		return fmt.Errorf("%w: (%v, %v)", ErrInvalidCoordinates, lat, lon)
+	}
+	return nil
+}
+
+// WalkBoundingBox calls do for each geo key (see EncodeGeoKey) following the given prefix,
+// whose location is within the given bounding box (inclusive).
+//
+// The box is covered by up to 32 geohash cells which are walked in lexicographical order.
+// Boxes crossing the antimeridian must be queried in two parts.
+func (r *Reader) WalkBoundingBox(prefix []byte, minLat, minLon, maxLat, maxLon float64, do func(key []byte, lat, lon float64) error) error {
+	if err := validateCoordinates(minLat, minLon); err != nil {
+		return err
+	}
+	if err := validateCoordinates(maxLat, maxLon); err != nil {
+		return err
+	}
+	if minLat > maxLat || minLon > maxLon {
+		return fmt.Errorf("%w: empty bounding box", ErrInvalidCoordinates)
+	}
+
+	stopped := false // by ErrBreak, the remaining cells are skipped
+	for _, cell := range geohashCells(minLat, minLon, maxLat, maxLon) {
+		_, err := r.Walk(append(append([]byte{}, prefix...), cell...), func(key []byte) error {
+			lat, lon, err := DecodeGeoKey(key[len(prefix):])
+			if err != nil || lat < minLat || lat > maxLat || lon < minLon || lon > maxLon {
+				return nil // skip keys outside of the box (or that aren't geo keys)
+			}
+			err = do(key, lat, lon)
+			stopped = errors.Is(err, ErrBreak)
+			return err
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// geohashCells returns the sorted geohashes of the cells covering the given bounding box,
+// using the highest precision for which the box is covered by at most maxGeoCells cells.
+func geohashCells(minLat, minLon, maxLat, maxLon float64) []string {
+	cellIndex := func(v, min, size float64, n int) int {
+		return int(math.Min(math.Floor((v-min)/size), float64(n-1)))
+	}
+	var cells []string
+	for precision := 1; precision <= GeoKeyLength; precision++ {
+		lonBits, latBits := (5*precision+1)/2, 5*precision/2
+		numLon, numLat := 1<<lonBits, 1<<latBits
+		width, height := 360/float64(numLon), 180/float64(numLat)
+		lon0, lon1 := cellIndex(minLon, -180, width, numLon), cellIndex(maxLon, -180, width, numLon)
+		lat0, lat1 := cellIndex(minLat, -90, height, numLat), cellIndex(maxLat, -90, height, numLat)
+		if precision > 1 && (lon1-lon0+1)*(lat1-lat0+1) > maxGeoCells {
+			break
+		}
+		cells = cells[:0]
+		for i := lat0; i <= lat1; i++ {
+			for j := lon0; j <= lon1; j++ {
+				lat, lon := -90+(float64(i)+0.5)*height, -180+(float64(j)+0.5)*width
+				cells = append(cells, geohash(lat, lon, precision))
+			}
+		}
+	}
+	sort.Strings(cells)
+	return cells
+}
@@ -0,0 +1,49 @@
+package tridb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// rowBuffers holds buffers used to read encoded rows.
+var rowBuffers = sync.Pool{New: func() any { return new([]byte) }}
+
+// GetAppend appends the value of the given key to dst and returns the extended buffer
+// (dst is returned as is if the key doesn't exist).
+//
+// Unlike Get, it doesn't allocate (besides growing dst) when reading plain values from files using the binary format.
+// Aliases, encrypted or compressed values, merge operands and transformed values (see WithReadTransform) are read with Get.
+func (r *Reader) GetAppend(dst, key []byte) ([]byte, error) {
+	rowInfo := r.get(key)
+	if rowInfo == nil {
+		return dst, nil
+	}
+	f := r.f
+	if _, binary := f.format.(binaryFormat); !binary || f.opts.ReadTransform != nil || f.hasGetHooks() || f.opts.ParanoidChecks || f.opts.KeySecret != nil {
+		value, err := r.readValue(key, rowInfo.Position)
+		return append(dst, value...), err
+	}
+
+	bufp := rowBuffers.Get().(*[]byte)
+	defer rowBuffers.Put(bufp)
+	if cap(*bufp) < rowInfo.Position.Size() {
+		*bufp = make([]byte, rowInfo.Position.Size())
+	}
+	buf := (*bufp)[:rowInfo.Position.Size()]
+	_, err := r.ra.ReadAt(buf, int64(rowInfo.Position.Offset()))
+	if err != nil {
+		return dst, fmt.Errorf("read row: %w", err)
+	}
+	row := Row{}
+	if err := row.decodeInPlace(buf); err != nil {
+		return dst, fmt.Errorf("decode row: %w", err)
+	}
+	if err := row.VerifyChecksum(); err != nil {
+		return dst, fmt.Errorf("%w at offset %d", err, rowInfo.Position.Offset())
+	}
+	if row.IsAlias || row.IsSealed || row.IsMerge || row.Compression != NoCompression {
+		value, err := r.readValue(key, rowInfo.Position)
+		return append(dst, value...), err
+	}
+	return append(dst, row.Value...), nil
+}
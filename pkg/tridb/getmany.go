@@ -0,0 +1,43 @@
+package tridb
+
+import (
+	"slices"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// GetMany returns the values of the given keys in the same order (nil for keys that don't exist, like Get).
+// The positions of all keys are resolved first, then values are read by increasing offset in the file,
+// so that fetching many keys reads the file forward instead of at random.
+func (r *Reader) GetMany(keys [][]byte) ([][]byte, error) {
+	rows := make([]*fidx.RowInfo, len(keys))
+	order := make([]int, 0, len(keys)) // indexes of the existing keys, by offset
+	for i, key := range keys {
+		if rows[i] = r.get(key); rows[i] != nil {
+			order = append(order, i)
+		}
+	}
+	slices.SortFunc(order, func(i, j int) int { return rows[i].Position.Offset() - rows[j].Position.Offset() })
+
+	values := make([][]byte, len(keys))
+	for _, i := range order {
+		if err := r.ctx.Err(); err != nil {
+			return nil, err
+		}
+		value, err := r.readValue(keys[i], rows[i].Position)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// GetMany returns the values of the given keys in a single read transaction (see Reader.GetMany).
+func (f *File) GetMany(keys [][]byte) (values [][]byte, err error) {
+	err = f.Read(func(r *Reader) error {
+		values, err = r.GetMany(keys)
+		return err
+	})
+	return values, err
+}
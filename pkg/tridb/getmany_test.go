@@ -0,0 +1,22 @@
+package tridb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetMany(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "b", "2")
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "c", "3")
+	mustSet(t, f, "b", "22")
+
+	values, err := f.GetMany([][]byte{[]byte("c"), []byte("missing"), []byte("b"), []byte("a"), []byte("c")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{[]byte("3"), nil, []byte("22"), []byte("1"), []byte("3")}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("got values %q instead of %q", values, want)
+	}
+}
@@ -0,0 +1,34 @@
+package tridb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// ErrHashedKeys is returned by walks when keys are hashed in memory (see WithHashedKeys).
+var ErrHashedKeys = errors.New("walks are disabled with hashed keys")
+
+// indexKey returns the key under which the given user key is indexed in the keydir.
+func (f *File) indexKey(key []byte) []byte {
+	if key = f.collate(key); f.opts.KeySecret == nil || IsReservedKey(key) {
+		return key
+	}
+	mac := hmac.New(sha256.New, f.opts.KeySecret)
+	mac.Write(key)
+	return mac.Sum(nil)
+}
+
+// rowKey returns the user key of the given row (as written, see WithKeyCollation).
+func (r *Reader) rowKey(rowInfo *fidx.RowInfo) ([]byte, error) {
+	if r.f.opts.KeySecret == nil && r.f.opts.KeyCollation == nil {
+		return rowInfo.Key, nil
+	}
+	row, err := r.f.readAndDecodeRow(r.ra, rowInfo.Position)
+	if err != nil {
+		return nil, err
+	}
+	return row.Key, nil
+}
@@ -0,0 +1,33 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestHashedKeys(t *testing.T) {
+	f := openTestFile(t, WithHashedKeys([]byte("secret")))
+	mustSet(t, f, "ssn/123-45-6789", "alice")
+	mustSet(t, f, "ssn/987-65-4321", "bob")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "ssn/123-45-6789", "alice")
+	assertValue(t, f, "ssn/000-00-0000", "")
+
+	for row := f.idx.Chronological().Oldest; row != nil; row = row.Next {
+		if bytes.Contains(row.Key, []byte("ssn/")) {
+			t.Fatalf("found plain key %q in keydir", row.Key)
+		}
+	}
+	_ = f.Read(func(r *Reader) error {
+		if _, err := r.Walk(nil, func(key []byte) error { return nil }); !errors.Is(err, ErrHashedKeys) {
+			t.Fatalf("got error %v instead of %v", err, ErrHashedKeys)
+		}
+		if got := string(r.Latest().Key()); got != "ssn/987-65-4321" {
+			t.Fatalf("got latest key %q", got)
+		}
+		return nil
+	})
+}
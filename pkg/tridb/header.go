@@ -0,0 +1,138 @@
+package tridb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Datafiles start with a header identifying them: magic bytes, file format version, flags
+// and the name of the row format (length-prefixed), followed by a newline (so text files remain line-based).
+//
+// Files written by versions predating the header have none, their row format is detected (see DetectFormat).
+const (
+	fileMagic         = "\x89TDB"
+	fileFormatVersion = 1
+
+	headerCompactTombstones byte = 0x01 // deletes may be compact tombstones (see WithCompactTombstones)
+	headerEncrypted         byte = 0x02 // rows are encrypted at rest (see WithEncryption)
+)
+
+// ErrBadFileFormat is returned when opening a file that is not a tridb datafile,
+// that was written with a newer file format version, or with another row format than the configured one (see WithFormat).
+var ErrBadFileFormat = errors.New("bad file format")
+
+// fileHeader is the decoded header of a datafile.
+type fileHeader struct {
+	version byte
+	flags   byte
+	format  string // name of the row format (see Format.Name)
+}
+
+// newFileHeader returns the header of new files using the given row format.
+func newFileHeader(format Format) fileHeader {
+	h := fileHeader{version: fileFormatVersion, format: format.Name()}
+	switch format := format.(type) {
+	case binaryFormat:
+		if format.compactTombstones {
+			h.flags |= headerCompactTombstones
+		}
+	case encryptedFormat:
+		h.format, h.flags = BinaryEncoding.Name(), headerEncrypted
+	}
+	return h
+}
+
+// encode returns the encoded header.
+func (h fileHeader) encode() []byte {
+	encoded := append([]byte(fileMagic), h.version, h.flags, byte(len(h.format)))
+	encoded = append(encoded, h.format...)
+	return append(encoded, '\n')
+}
+
+// size returns the number of bytes of the encoded header.
+func (h fileHeader) size() int { return len(fileMagic) + 3 + len(h.format) + 1 }
+
+// rowFormat returns the row format named in the header (the preferred format if it has the same name).
+func (h fileHeader) rowFormat(preferred Format) (Format, error) {
+	if h.flags&headerEncrypted != 0 {
+		return nil, ErrEncryptedFile
+	}
+	if preferred != nil && preferred.Name() == h.format {
+		return preferred, nil
+	}
+	format, err := FormatFromString(h.format)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadFileFormat, err)
+	}
+	return format, nil
+}
+
+// readFileHeader reads the header at the start of r (or nothing if r doesn't start with the magic bytes).
+// It reports whether there was a header.
+func readFileHeader(r *bufio.Reader) (fileHeader, bool, error) {
+	prefix, err := r.Peek(len(fileMagic) + 3)
+	if string(prefix[:min(len(prefix), len(fileMagic))]) != fileMagic {
+		return fileHeader{}, false, nil
+	} else if err != nil {
+		return fileHeader{}, true, fmt.Errorf("read header: %w", orUnexpectedEOF(err))
+	}
+	h := fileHeader{version: prefix[len(fileMagic)], flags: prefix[len(fileMagic)+1]}
+	if h.version > fileFormatVersion {
+		return fileHeader{}, true, fmt.Errorf("%w: unsupported file format version %d", ErrBadFileFormat, h.version)
+	}
+	encoded := make([]byte, len(prefix)+int(prefix[len(fileMagic)+2])+1)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return fileHeader{}, true, fmt.Errorf("read header: %w", orUnexpectedEOF(err))
+	}
+	if encoded[len(encoded)-1] != '\n' {
+		return fileHeader{}, true, fmt.Errorf("%w: missing newline after header", ErrBadFileFormat)
+	}
+	h.format = string(encoded[len(prefix) : len(encoded)-1])
+	return h, true, nil
+}
+
+// writeHeader writes the header of files using the given row format (the format of the file if nil) to w.
+// It returns the number of bytes written.
+func (f *File) writeHeader(w io.Writer, format Format) (int, error) {
+	if format == nil {
+		format = f.format
+	}
+	n, err := w.Write(newFileHeader(format).encode())
+	if err != nil {
+		return n, fmt.Errorf("write header: %w", err)
+	}
+	return n, nil
+}
+
+// initHeader writes the header of a new (empty) file.
+func (f *File) initHeader() error {
+	size, err := f.store.Size()
+	if err != nil {
+		return fmt.Errorf("stat datafile: %w", err)
+	}
+	if size > 0 {
+		return nil
+	}
+	if _, err := f.writeHeader(storageWriter{f.store}, nil); err != nil {
+		return err
+	}
+	if err := f.store.Sync(); err != nil {
+		return fmt.Errorf("sync header: %w", err)
+	}
+	return nil
+}
+
+// loadHeaderSize sets the size of the header of the file (0 if it has none).
+func (f *File) loadHeaderSize() error {
+	h, ok, err := readFileHeader(bufio.NewReader(io.NewSectionReader(f.store, 0, formatProbeSize)))
+	if err != nil {
+		return err
+	}
+	f.headerSize = 0
+	if ok {
+		f.headerSize = h.size()
+	}
+	return nil
+}
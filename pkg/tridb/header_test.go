@@ -0,0 +1,86 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileHeader(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1, WithFormat(TextEncoding))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	f.Close()
+	content, err := os.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x89TDB\x01\x00\x04text\n"; !bytes.HasPrefix(content, []byte(want)) {
+		t.Fatalf("got file content %q, want header %q", content, want)
+	}
+
+	// The format is read from the header, and must match the configured one.
+	f, err = Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "a", "1")
+	f.Close()
+	if _, err := Open(fpath, 1, WithFormat(BinaryEncoding)); !errors.Is(err, ErrBadFileFormat) {
+		t.Fatalf("got error %v instead of %v", err, ErrBadFileFormat)
+	}
+
+	// Files from newer versions and other files are rejected.
+	for name, content := range map[string][]byte{
+		"newer.tridb": append([]byte(fileMagic), fileFormatVersion+1, 0, 0, '\n'),
+		"other.tridb": []byte("\x7fELF\x02\x01\x01\x00\x00\x00"),
+	} {
+		fpath := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(fpath, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(fpath, 1); !errors.Is(err, ErrBadFileFormat) {
+			t.Fatalf("%s: got error %v instead of %v", name, err, ErrBadFileFormat)
+		}
+	}
+}
+
+func TestFileHeaderLegacy(t *testing.T) {
+	// Files predating the header are still read, compactions add the header.
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	var content []byte
+	for _, row := range []*Row{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("a"), Value: []byte("2")}} {
+		encoded, err := row.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, encoded...)
+	}
+	if err := os.WriteFile(fpath, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.headerSize != 0 {
+		t.Fatalf("got header size %d for a legacy file", f.headerSize)
+	}
+	assertValue(t, f, "a", "2")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if f.headerSize == 0 {
+		t.Fatal("got no header after compaction")
+	}
+	assertValue(t, f, "a", "2")
+	if dead := f.Stats().DeadBytes; dead != 0 {
+		t.Fatalf("got %d dead bytes after compaction", dead)
+	}
+}
@@ -0,0 +1,258 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// ErrSeqOutOfRange is returned when reading at a sequence that is not retained in the file.
+var ErrSeqOutOfRange = errors.New("sequence out of range")
+
+// Seq returns the current sequence of the file: the number of rows written to it,
+// which is also the sequence of the last commit writing rows (see CommitReceipt).
+//
+// Every row counts as one, a transaction writing multiple rows thus spans several sequence numbers.
+// Sequences keep increasing across compactions and reopening, but compactions discard history:
+// the states before the last compaction can't be read anymore (see ReadAt).
+func (f *File) Seq() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return uint64(f.seqBase + f.numRows)
+}
+
+// ReadAt executes a read-only transaction on the state of the database as it was
+// after the given commit sequence (see CommitReceipt and Seq), by replaying the history retained in the file.
+// Sequence 0 is the empty database. Sequences before the last compaction are out of range (ErrSeqOutOfRange).
+// Sequences between two commit sequences may show part of a transaction.
+//
+// Replaying doesn't hold the file lock (like snapshots, see Snapshot), writes and compactions proceed concurrently.
+//
+// Note: replaying is O(n) in the number of rows, it is meant for time-travel queries and debugging, not hot paths.
+func (f *File) ReadAt(seq uint64, do func(r *Reader) error) error {
+	r, h, rows, err := f.historyReader(seq)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+	src := bufio.NewReader(io.NewSectionReader(h, 0, int64(r.size)))
+	_, _, err = f.replay(src, 0, rows, r.idx, r.sys)
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	return do(r)
+}
+
+// historyReader returns an empty reader on a dedicated read handle of the file (see Snapshot),
+// along with the number of rows of the file to replay to reach the given sequence (see ReadAt).
+func (f *File) historyReader(seq uint64) (*Reader, Storage, int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if err := f.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+	if latest := uint64(f.seqBase + f.numRows); seq > latest {
+		return nil, nil, 0, fmt.Errorf("%w: %d (latest is %d)", ErrSeqOutOfRange, seq, latest)
+	}
+	if seq < f.seqHorizon {
+		return nil, nil, 0, fmt.Errorf("%w: %d (compacted, earliest is %d)", ErrSeqOutOfRange, seq, f.seqHorizon)
+	}
+	h, err := f.opts.Storage(f.fpath, StorageReadOnly)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("open read handle: %w", err)
+	}
+	r := f.newReader()
+	r.ra, r.idx, r.sys = h, f.newKeydir(), fidx.NewTrieIndex()
+	r.expiring = 1 // unknown, expired keys are always counted
+	return r, h, int(seq) - f.seqBase, nil
+}
+
+// seqKey is the reserved key holding the sequences at the last compaction discarding rows:
+// the sequence and the number of rows of the compacted file (up to this row included).
+// Compactions don't copy it, they write it again after the other rows if needed (see writeSeq).
+const seqKey = "seq"
+
+// isSeqKey reports whether the given key is the reserved key holding the sequences.
+func isSeqKey(key []byte) bool { return string(key) == ReservedPrefix+seqKey }
+
+// loadSeq sets the sequences of the file from the ones recorded by the last compaction (see writeSeq).
+func (f *File) loadSeq() error {
+	rowInfo := f.sys.Get(reservedKey(seqKey))
+	if rowInfo == nil {
+		f.seqBase, f.seqHorizon = 0, 0
+		return nil
+	}
+	row, err := f.readAndDecodeRow(f.store, rowInfo.Position)
+	if err != nil {
+		return fmt.Errorf("load sequences: %w", err)
+	}
+	if len(row.Value) != 16 {
+		return fmt.Errorf("load sequences: invalid value of length %d", len(row.Value))
+	}
+	seq, rows := binary.BigEndian.Uint64(row.Value), int(binary.BigEndian.Uint64(row.Value[8:]))
+	f.seqBase, f.seqHorizon = int(seq)-rows, seq
+	return nil
+}
+
+// writeSeq writes the row recording the current sequences to the compacted file (see loadSeq),
+// rows is the number of rows of the compacted file including this one. It reports the offset after the row.
+// It must be called with the write lock held.
+func (f *File) writeSeq(w io.Writer, offset, rows int, sys fidx.Keydir, format Format) (int, error) {
+	if format == nil {
+		format = f.format
+	}
+	row, encoded, err := f.encodeSeq(rows, format)
+	if err != nil {
+		return offset, err
+	}
+	n, err := w.Write(encoded)
+	if err != nil {
+		return offset + n, fmt.Errorf("write sequences: %w", err)
+	}
+	f.applyRow(row, fidx.Position{offset, n}, sys, sys)
+	return offset + n, nil
+}
+
+// encodeSeq returns the row recording the current sequences, for a compacted file with the given number of rows.
+func (f *File) encodeSeq(rows int, format Format) (*Row, []byte, error) {
+	value := binary.BigEndian.AppendUint64(nil, uint64(f.seqBase+f.numRows))
+	value = binary.BigEndian.AppendUint64(value, uint64(rows))
+	row := &Row{Key: reservedKey(seqKey), Value: value}
+	if f.opts.RowChecksums {
+		row.Checksum = row.computeChecksum()
+	}
+	encoded, err := format.Encode(row)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode sequences: %w", err)
+	}
+	return row, encoded, nil
+}
+
+// At returns a reader on the state of the database at the given time, by replaying the rows written up to it:
+// replaying stops at the first row timestamped after t (rows without timestamp are always replayed, see WithTimestamps).
+// Keys are also expired as of t.
+//
+// Compactions discard history, except for the rows written within the retention duration (see WithHistoryRetention):
+// times before the horizon of the last compaction may not see the overwritten and deleted keys.
+//
+// Note: like File.ReadAt, replaying is O(n) in the size of the file.
+func (r *Reader) At(t time.Time) (*Reader, error) {
+	r.checkDeadline()
+	past := *r
+	past.idx, past.sys = r.f.newKeydir(), fidx.NewTrieIndex()
+	past.now, past.deadline, past.expiring = t.UnixNano(), time.Time{}, 1 // unknown, expired keys are always counted
+	_, err := r.f.replayUntil(r.ra, r.size, t.UnixNano(), past.idx, past.sys)
+	if err != nil {
+		return nil, err
+	}
+	return &past, nil
+}
+
+// GetAt returns the value the given key had at the given time (see At).
+func (r *Reader) GetAt(key []byte, t time.Time) ([]byte, error) {
+	past, err := r.At(t)
+	if err != nil {
+		return nil, err
+	}
+	return past.Get(key)
+}
+
+// WalkAt is like WalkWithValue on the state of the database at the given time (see At).
+func (r *Reader) WalkAt(t time.Time, prefix []byte, do func(key, value []byte) error) (int, error) {
+	past, err := r.At(t)
+	if err != nil {
+		return 0, err
+	}
+	return past.WalkWithValue(prefix, do)
+}
+
+// replayUntil applies the rows of the first size bytes of ra to the given keydirs,
+// until the first row timestamped after t (in Unix nanoseconds).
+// It reports the offset it stopped at (see scanRowsWhile).
+func (f *File) replayUntil(ra io.ReaderAt, size int, t int64, idx, sys fidx.Keydir) (int, error) {
+	src := bufio.NewReader(io.NewSectionReader(ra, 0, int64(size)))
+	end, _, err := f.scanRowsWhile(src, 0, -1, func(row *Row, p fidx.Position) bool {
+		if row.Timestamp > t {
+			return false
+		}
+		f.applyRow(row, p, idx, sys)
+		return true
+	})
+	if err != nil {
+		return end, fmt.Errorf("replay: %w", err)
+	}
+	return end, nil
+}
+
+// historySource returns the state of the first size bytes of the file at the given horizon,
+// compactions write it before copying the rows written since (see WithHistoryRetention).
+func (f *File) historySource(horizon time.Time, size int) (compactionSource, error) {
+	src := compactionSource{idx: f.newKeydir(), sys: fidx.NewTrieIndex(), now: horizon.UnixNano()}
+	end, err := f.replayUntil(f.store, size, src.now, src.idx, src.sys)
+	if err != nil {
+		return compactionSource{}, fmt.Errorf("history: %w", err)
+	}
+	src.end = end
+	return src, nil
+}
+
+// writeVersions writes the last n rows of each key among the first end bytes of the file to w (in file order)
+// and applies them to the given keydirs (see WithKeepVersions). It reports the size of w and the number of rows written.
+// Keys expired (according to expired) keep no rows, deleted keys only keep their rows if they have older versions.
+func (f *File) writeVersions(w io.Writer, end, n int, expired func(row *fidx.RowInfo) bool, idx, sys fidx.Keydir, format Format) (int, int, error) {
+	type keyRows struct {
+		latest      fidx.RowInfo // latest row (used to check expiration)
+		deleted     bool         // the latest row deletes the key
+		count, keep int          // number of rows and number of (latest) rows to keep
+		seen        int          // number of rows visited while copying
+	}
+
+	// Count the rows of each key
+	keys := map[string]*keyRows{}
+	src := bufio.NewReaderSize(io.NewSectionReader(f.store, 0, int64(end)), f.opts.ReadBufferSize)
+	_, _, err := f.scanRows(src, 0, -1, func(row *Row, p fidx.Position) {
+		k := keys[string(row.Key)]
+		if k == nil {
+			k = &keyRows{latest: fidx.RowInfo{Key: bytes.Clone(row.Key)}}
+			keys[string(row.Key)] = k
+		}
+		k.count++
+		k.deleted, k.latest.Timestamp, k.latest.ExpiresAt = row.IsDeleted, row.Timestamp, row.ExpiresAt
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("count versions: %w", err)
+	}
+	for _, k := range keys {
+		k.keep = min(k.count, n)
+		if IsReservedKey(k.latest.Key) {
+			k.keep = 1
+		}
+		if isSeqKey(k.latest.Key) {
+			k.keep = 0 // see writeSeq
+		}
+		if (k.deleted && k.keep == 1) || (!k.deleted && expired(&k.latest)) {
+			k.keep = 0
+		}
+	}
+
+	// Copy the rows to keep
+	offset, err := f.writeHeader(w, format)
+	if err != nil {
+		return offset, 0, err
+	}
+	offset, numRows, err := f.copyRows(w, offset, 0, end, idx, sys, format, func(row *Row, p fidx.Position) bool {
+		k := keys[string(row.Key)]
+		k.seen++
+		return k.seen > k.count-k.keep
+	})
+	if err != nil {
+		return offset, numRows, fmt.Errorf("copy versions: %w", err)
+	}
+	return offset, numRows, nil
+}
@@ -0,0 +1,180 @@
+package tridb
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mustSetAndMark sets the key and returns a time between this write and the next one.
+func mustSetAndMark(t *testing.T, f *File, key, value string) time.Time {
+	t.Helper()
+	mustSet(t, f, key, value)
+	time.Sleep(time.Millisecond)
+	defer time.Sleep(time.Millisecond)
+	return time.Now()
+}
+
+func TestReaderAt(t *testing.T) {
+	f := openTestFile(t)
+	t1 := mustSetAndMark(t, f, "a", "1")
+	t2 := mustSetAndMark(t, f, "b", "1")
+	mustSet(t, f, "a", "2")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error { w.Delete([]byte("b")); return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Read(func(r *Reader) error {
+		for _, test := range []struct {
+			at         time.Time
+			key, value string
+		}{{t1, "a", "1"}, {t1, "b", ""}, {t2, "b", "1"}, {time.Now(), "a", "2"}, {time.Now(), "b", ""}} {
+			got, err := r.GetAt([]byte(test.key), test.at)
+			if err != nil {
+				return err
+			}
+			if string(got) != test.value {
+				t.Fatalf("got %q instead of %q for %q", got, test.value, test.key)
+			}
+		}
+		var got []string
+		n, err := r.WalkAt(t2, nil, func(key, value []byte) error {
+			got = append(got, string(key)+"="+string(value))
+			return nil
+		})
+		if err != nil || n != 2 || got[0] != "a=1" || got[1] != "b=1" {
+			t.Fatalf("got %v (%d keys) and error %v walking at t2", got, n, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	retention := 200 * time.Millisecond
+	f := openTestFile(t, WithHistoryRetention(retention))
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "b", "1")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error { w.Delete([]byte("b")); return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t1 := mustSetAndMark(t, f, "a", "2")
+	time.Sleep(retention)
+	t2 := mustSetAndMark(t, f, "a", "3")
+	mustSet(t, f, "a", "4")
+
+	// History before the horizon is collapsed, later rows are kept.
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.Stats().Rows; n != 4 {
+		t.Fatalf("got %d rows instead of 4 (a=2 at the horizon, then a=3 and a=4, and the sequences)", n)
+	}
+	err = f.Read(func(r *Reader) error {
+		for _, test := range []struct {
+			at    time.Time
+			value string
+		}{{t1, "2"}, {t2, "3"}, {time.Now(), "4"}} {
+			got, err := r.GetAt([]byte("a"), test.at)
+			if err != nil {
+				return err
+			}
+			if string(got) != test.value {
+				t.Fatalf("got %q instead of %q", got, test.value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "a", "4")
+	assertValue(t, f, "b", "")
+}
+
+// historyValues returns the values written to the given key ("-" for deletes, "+" before merge operands).
+func historyValues(t *testing.T, f *File, key string) []string {
+	t.Helper()
+	var values []string
+	err := f.Read(func(r *Reader) error {
+		changes, err := r.History([]byte(key))
+		for _, c := range changes {
+			switch {
+			case c.IsDeleted:
+				values = append(values, "-")
+			case c.IsMerge:
+				values = append(values, "+"+string(c.Value))
+			default:
+				values = append(values, string(c.Value))
+			}
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestReaderHistory(t *testing.T) {
+	f := openTestFile(t)
+	f.SetMergeOperator(func(key, existing []byte, operands [][]byte) ([]byte, error) {
+		return append(existing, bytes.Join(operands, nil)...), nil
+	})
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "b", "x")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error { w.Merge([]byte("a"), []byte("2")); return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.ReadWrite(func(r *Reader, w *Writer) error { w.Delete([]byte("a")); return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "3")
+
+	if got, want := historyValues(t, f, "a"), []string{"1", "+2", "-", "3"}; !slices.Equal(got, want) {
+		t.Fatalf("got history %q instead of %q", got, want)
+	}
+	if got := historyValues(t, f, "missing"); len(got) != 0 {
+		t.Fatalf("got history %q for a missing key", got)
+	}
+}
+
+func TestKeepVersions(t *testing.T) {
+	f := openTestFile(t, WithKeepVersions(2))
+	for _, value := range []string{"1", "2", "3"} {
+		mustSet(t, f, "a", value)
+	}
+	mustSet(t, f, "b", "1")
+	mustSet(t, f, "c", "1")
+	mustSet(t, f, "c", "2")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("b"))
+		w.Delete([]byte("c"))
+		w.SetWithTTL([]byte("expired"), []byte("1"), -time.Second)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string][]string{"a": {"2", "3"}, "b": {"1", "-"}, "c": {"2", "-"}, "expired": nil} {
+		if got := historyValues(t, f, key); !slices.Equal(got, want) {
+			t.Fatalf("got history %q instead of %q for %q", got, want, key)
+		}
+	}
+	if n := f.Stats().Rows; n != 7 {
+		t.Fatalf("got %d rows instead of 7 (6 versions and the sequences)", n)
+	}
+	assertValue(t, f, "a", "3")
+	assertValue(t, f, "b", "")
+}
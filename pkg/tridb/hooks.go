@@ -0,0 +1,127 @@
+package tridb
+
+import (
+	"fmt"
+	"slices"
+)
+
+// keyHooks holds the hooks registered on a file (see File.OnBeforeSet), called in registration order.
+// It is replaced on registration so that writers can use it without holding the file lock (see File.Batch).
+type keyHooks struct {
+	beforeSet []func(key, value []byte) ([]byte, error)
+	afterSet  []func(key, value []byte)
+	delete    []func(key []byte)
+	get       []func(key, value []byte) ([]byte, error)
+}
+
+// OnBeforeSet registers a hook called when a value is set in a transaction (see Writer.Set),
+// before it is staged: it returns the value to write (for example, encrypted) or an error aborting the transaction.
+// Hooks are not called for merge operands, aliases and renamed values.
+func (f *File) OnBeforeSet(hook func(key, value []byte) ([]byte, error)) {
+	f.registerHook(func(h *keyHooks) { h.beforeSet = append(h.beforeSet, hook) })
+}
+
+// OnAfterSet registers a hook called for each value set by a committed transaction, with the value as read.
+// It is called with the write lock held and must not use the file.
+func (f *File) OnAfterSet(hook func(key, value []byte)) {
+	f.registerHook(func(h *keyHooks) { h.afterSet = append(h.afterSet, hook) })
+}
+
+// OnDelete registers a hook called for each key deleted by a committed transaction.
+// It is called with the write lock held and must not use the file.
+func (f *File) OnDelete(hook func(key []byte)) {
+	f.registerHook(func(h *keyHooks) { h.delete = append(h.delete, hook) })
+}
+
+// OnGet registers a hook called when a value is read (see Reader.Get), after the read transform (see WithReadTransform):
+// it returns the value to return to the caller (for example, decrypted) or an error failing the read.
+func (f *File) OnGet(hook func(key, value []byte) ([]byte, error)) {
+	f.registerHook(func(h *keyHooks) { h.get = append(h.get, hook) })
+}
+
+// registerHook replaces the hooks of the file with an updated copy.
+func (f *File) registerHook(update func(h *keyHooks)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := &keyHooks{}
+	if current := f.hooks.Load(); current != nil {
+		*h = *current
+		h.beforeSet, h.afterSet = slices.Clip(h.beforeSet), slices.Clip(h.afterSet) // appends copy the hooks
+		h.delete, h.get = slices.Clip(h.delete), slices.Clip(h.get)
+	}
+	update(h)
+	f.hooks.Store(h)
+}
+
+// beforeSet checks the given key and applies the OnBeforeSet hooks to the value,
+// it reports false if the transaction is aborted.
+func (w *Writer) beforeSet(key, value []byte) ([]byte, bool) {
+	if !w.checkKey(key) {
+		return nil, false
+	}
+	return w.applySetHooks(key, value)
+}
+
+// applySetHooks applies the OnBeforeSet hooks to the given value, it reports false if the transaction is aborted.
+func (w *Writer) applySetHooks(key, value []byte) ([]byte, bool) {
+	if w.hooks == nil {
+		return value, true
+	}
+	for _, hook := range w.hooks.beforeSet {
+		var err error
+		if value, err = hook(key, value); err != nil {
+			if w.err == nil {
+				w.err = fmt.Errorf("before set hook: %w", err)
+			}
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// afterCommit calls the OnAfterSet and OnDelete hooks with the given committed rows.
+func (f *File) afterCommit(rows []*Row) {
+	h := f.hooks.Load()
+	if h == nil {
+		return
+	}
+	for _, row := range rows {
+		switch {
+		case IsReservedKey(row.Key) || row.IsAlias:
+			continue
+		case row.IsDeleted:
+			for _, hook := range h.delete {
+				hook(row.Key)
+			}
+		case len(h.afterSet) > 0:
+			value, err := f.storedValue(f.store, row)
+			if err != nil {
+				continue // the value can't be read back (ex: missing merge operator)
+			}
+			for _, hook := range h.afterSet {
+				hook(row.Key, value)
+			}
+		}
+	}
+}
+
+// afterGet applies the OnGet hooks to the given value read.
+func (f *File) afterGet(key, value []byte) ([]byte, error) {
+	h := f.hooks.Load()
+	if h == nil {
+		return value, nil
+	}
+	for _, hook := range h.get {
+		var err error
+		if value, err = hook(key, value); err != nil {
+			return nil, fmt.Errorf("get hook: %w", err)
+		}
+	}
+	return value, nil
+}
+
+// hasGetHooks reports whether OnGet hooks are registered (values must then be read with Reader.Get).
+func (f *File) hasGetHooks() bool {
+	h := f.hooks.Load()
+	return h != nil && len(h.get) > 0
+}
@@ -0,0 +1,67 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	f := openTestFile(t)
+	secret := []byte("secret/")
+	xor := func(key, value []byte) ([]byte, error) {
+		if !bytes.HasPrefix(key, secret) {
+			return value, nil
+		}
+		out := make([]byte, len(value))
+		for i := range value {
+			out[i] = value[i] ^ 0xff
+		}
+		return out, nil
+	}
+	f.OnBeforeSet(xor)
+	f.OnBeforeSet(func(key, value []byte) ([]byte, error) {
+		if len(value) == 0 {
+			return nil, errors.New("empty value")
+		}
+		return value, nil
+	})
+	f.OnGet(xor)
+	var events []string
+	f.OnAfterSet(func(key, value []byte) { events = append(events, "set "+string(key)) })
+	f.OnDelete(func(key []byte) { events = append(events, "delete "+string(key)) })
+
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("secret/a"), []byte("1"))
+		w.Set([]byte("b"), []byte("2"))
+		w.Delete([]byte("b"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "secret/a", "1")
+	_ = f.Read(func(r *Reader) error {
+		raw, err := r.GetRaw([]byte("secret/a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		row := &Row{}
+		if _, err := row.DecodeFrom(bytes.NewReader(raw)); err != nil || string(row.Value) == "1" {
+			t.Fatalf("the value was not transformed before being written: %q (%v)", row.Value, err)
+		}
+		return nil
+	})
+	if want := []string{"set secret/a", "set b", "delete b"}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("got events %q instead of %q", events, want)
+	}
+
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("c"), nil)
+		return nil
+	})
+	if !errors.Is(err, ErrTxnAborted) {
+		t.Fatalf("got error %v instead of %v", err, ErrTxnAborted)
+	}
+}
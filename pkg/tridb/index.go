@@ -0,0 +1,129 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrUnknownIndex is returned when looking up an index that wasn't created.
+var ErrUnknownIndex = errors.New("unknown index")
+
+// secondaryIndex maps terms extracted from values to the keys holding them.
+type secondaryIndex struct {
+	extract func(key, value []byte) [][]byte
+	keys    map[string]map[string]struct{} // keys by term
+	terms   map[string][]string            // terms by key
+}
+
+// CreateIndex creates (or replaces) the secondary index with the given name:
+// extract returns the terms under which a key-value pair can be found with Reader.LookupIndex.
+//
+// The index is held in memory: it is built from the current content of the file,
+// then updated on every set and delete, and rebuilt after each compaction.
+// Indexes are not persisted, they must be created again after opening the file.
+// The extract function receives values as written (decrypted but not transformed, see WithReadTransform),
+// aliases are not indexed (see Writer.Alias).
+func (f *File) CreateIndex(name string, extract func(key, value []byte) [][]byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.opts.KeySecret != nil {
+		return ErrHashedKeys
+	}
+	index := &secondaryIndex{extract: extract}
+	err := f.buildIndex(index)
+	if err != nil {
+		return fmt.Errorf("build index %q: %w", name, err)
+	}
+	if f.indexes == nil {
+		f.indexes = map[string]*secondaryIndex{}
+	}
+	f.indexes[name] = index
+	return nil
+}
+
+// DropIndex removes the secondary index with the given name.
+func (f *File) DropIndex(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.indexes, name)
+}
+
+// LookupIndex returns the keys (in lexicographical order) under which
+// the given term was extracted by the index with the given name (see File.CreateIndex).
+//
+// Readers switched to a snapshot (see WithMaxReadDuration) look up the live index
+// (only keys that exist in the snapshot are returned).
+func (r *Reader) LookupIndex(name string, term []byte) ([][]byte, error) {
+	r.checkDeadline()
+	if r.detached != nil {
+		r.f.mu.RLock()
+		defer r.f.mu.RUnlock()
+	}
+	index, ok := r.f.indexes[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownIndex, name)
+	}
+	keys := make([][]byte, 0, len(index.keys[string(term)]))
+	for key := range index.keys[string(term)] {
+		if r.get([]byte(key)) != nil {
+			keys = append(keys, []byte(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return keys, nil
+}
+
+// buildIndex indexes all keys of the file.
+// It must be called with the write lock held.
+func (f *File) buildIndex(index *secondaryIndex) error {
+	index.keys, index.terms = map[string]map[string]struct{}{}, map[string][]string{}
+	for rowInfo := f.idx.Chronological().Oldest; rowInfo != nil; rowInfo = rowInfo.Next {
+		row, err := f.readAndDecodeRow(f.readerAt(), rowInfo.Position)
+		if err != nil {
+			return err
+		}
+		index.update(f, row)
+	}
+	return nil
+}
+
+// rebuildIndexes rebuilds all secondary indexes (after compaction).
+// It must be called with the write lock held.
+func (f *File) rebuildIndexes() error {
+	for name, index := range f.indexes {
+		if err := f.buildIndex(index); err != nil {
+			return fmt.Errorf("rebuild index %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// update replaces the terms of the row key by the ones extracted from its value.
+func (index *secondaryIndex) update(f *File, row *Row) {
+	key := string(row.Key)
+	for _, term := range index.terms[key] {
+		delete(index.keys[term], key)
+		if len(index.keys[term]) == 0 {
+			delete(index.keys, term)
+		}
+	}
+	delete(index.terms, key)
+	if row.IsDeleted || row.IsAlias {
+		return
+	}
+	value, err := f.storedValue(f.store, row)
+	if err != nil {
+		return // unreadable values (see File.Shred) are not indexed
+	}
+	for _, term := range index.extract(row.Key, value) {
+		if index.keys[string(term)] == nil {
+			index.keys[string(term)] = map[string]struct{}{}
+		} else if _, ok := index.keys[string(term)][key]; ok {
+			continue // duplicate term
+		}
+		index.keys[string(term)][key] = struct{}{}
+		index.terms[key] = append(index.terms[key], string(term))
+	}
+}
@@ -0,0 +1,59 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSecondaryIndex(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "users/1", "paris")
+	mustSet(t, f, "users/2", "london")
+	byCity := func(key, value []byte) [][]byte { return [][]byte{value} }
+	if err := f.CreateIndex("city", byCity); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "users/3", "paris")
+	mustSet(t, f, "users/1", "london") // moves from "paris" to "london"
+	b := f.Batch()
+	b.Delete([]byte("users/2"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(term string) string {
+		t.Helper()
+		var keys [][]byte
+		err := f.Read(func(r *Reader) (err error) {
+			keys, err = r.LookupIndex("city", []byte(term))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bytes.Join(keys, []byte(" ")))
+	}
+	check := func() {
+		t.Helper()
+		if got := lookup("paris"); got != "users/3" {
+			t.Fatalf("got %q for paris", got)
+		}
+		if got := lookup("london"); got != "users/1" {
+			t.Fatalf("got %q for london", got)
+		}
+	}
+	check()
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	err := f.Read(func(r *Reader) error {
+		_, err := r.LookupIndex("missing", nil)
+		return err
+	})
+	if !errors.Is(err, ErrUnknownIndex) {
+		t.Fatalf("got error %v instead of %v", err, ErrUnknownIndex)
+	}
+}
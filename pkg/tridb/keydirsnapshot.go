@@ -0,0 +1,106 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// KeydirSnapshotFileExtension is added to the path of a database file to get the path of its keydir snapshot
+// (see WithKeydirSnapshot).
+const KeydirSnapshotFileExtension = ".idx"
+
+// Keydir snapshot format:
+//
+//	tridb-keydir 1 <file size> <number of rows> <FNV-1a 64 hash of the first and last bytes of the file> <keydir snapshot length>
+//
+// followed by the keydir snapshot and the reserved keydir snapshot (see fidx.EncodeSnapshot).
+// Only the first and last keydirSampleSize bytes of the file are hashed: they identify the file content
+// (ex: after a compaction replaced the file) without reading it all.
+const keydirSnapshotHeader = "tridb-keydir 1"
+
+// keydirSampleSize is the number of bytes hashed at the start and at the end of the file (see keydirSnapshotHeader).
+const keydirSampleSize = 4096
+
+// sampleHash returns the hash of the first and last bytes of the first size bytes of the file.
+func sampleHash(r io.ReaderAt, size int) (uint64, error) {
+	h := fnv.New64a()
+	head := min(size, keydirSampleSize)
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, int64(head))); err != nil {
+		return 0, err
+	}
+	tail := max(head, size-keydirSampleSize)
+	if _, err := io.Copy(h, io.NewSectionReader(r, int64(tail), int64(size-tail))); err != nil {
+		return 0, err
+	}
+	return h.Sum64(), nil
+}
+
+// WriteKeydirSnapshot writes the keydir snapshot of the file (see WithKeydirSnapshot),
+// it is written by Close and Compact but can also be written periodically to bound the replay after a crash.
+func (f *File) WriteKeydirSnapshot() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if err := f.Err(); err != nil {
+		return err
+	}
+	return f.writeKeydirSnapshot()
+}
+
+// writeKeydirSnapshot writes the keydir snapshot, it must be called with the lock held.
+func (f *File) writeKeydirSnapshot() error {
+	if !f.usesCleanShutdownMarker() {
+		return errors.New("keydir snapshots are not supported with hashed keys or encryption at rest")
+	}
+	hash, err := sampleHash(f.store, f.woffset)
+	if err != nil {
+		return fmt.Errorf("hash datafile: %w", err)
+	}
+	snapshots, idxLength, err := f.encodeKeydirs()
+	if err != nil {
+		return err
+	}
+	content := fmt.Appendf(nil, "%s %d %d %016x %d\n", keydirSnapshotHeader, f.woffset, f.numRows, hash, idxLength)
+	err = writeFileAtomic(f.fpath+KeydirSnapshotFileExtension, append(content, snapshots...))
+	if err != nil {
+		return fmt.Errorf("write keydir snapshot: %w", err)
+	}
+	return nil
+}
+
+// loadKeydirSnapshot loads the keydirs from the keydir snapshot (if any), along with the size and number of rows
+// of the file when the snapshot was written: the rows appended since must then be replayed.
+// It reports false if there is no valid snapshot for the current content of the file.
+func (f *File) loadKeydirSnapshot() bool {
+	content, err := os.ReadFile(f.fpath + KeydirSnapshotFileExtension)
+	if err != nil {
+		return false
+	}
+	header, snapshots, ok := bytes.Cut(content, []byte("\n"))
+	if !ok {
+		return false
+	}
+	var size, numRows, idxLength int
+	var hash uint64
+	_, err = fmt.Sscanf(string(header), keydirSnapshotHeader+" %d %d %016x %d", &size, &numRows, &hash, &idxLength)
+	if err != nil {
+		return false
+	}
+	stored, err := f.store.Size()
+	if err != nil || stored < int64(size) {
+		return false
+	}
+	if got, err := sampleHash(f.store, size); err != nil || got != hash {
+		return false
+	}
+	idx, sys, ok := f.decodeKeydirs(snapshots, idxLength)
+	if !ok {
+		return false
+	}
+	f.idx, f.sys, f.woffset, f.numRows = idx, sys, size, numRows
+	f.countKeys()
+	return true
+}
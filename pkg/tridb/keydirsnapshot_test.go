@@ -0,0 +1,90 @@
+package tridb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeydirSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	fpath, crashed := filepath.Join(dir, "test.tridb"), filepath.Join(dir, "crashed.tridb")
+	open := func(fpath string) *File {
+		t.Helper()
+		f, err := Open(fpath, 10, WithFormat(TextEncoding), WithKeydirSnapshot(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	copyFile := func(src, dst string) {
+		t.Helper()
+		content, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dst, content, 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hasKey := func(f *File, key string) (ok bool) {
+		_ = f.Read(func(r *Reader) error { ok = r.Has([]byte(key)); return nil })
+		return ok
+	}
+
+	// The snapshot is written on close, then rows are appended before a crash (no clean shutdown marker).
+	f := open(fpath)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		for i := 0; i < 500; i++ {
+			w.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("value"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f = open(fpath)
+	mustSet(t, f, "b", "2")
+	copyFile(fpath, crashed)
+	copyFile(fpath+KeydirSnapshotFileExtension, crashed+KeydirSnapshotFileExtension)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows in the middle of the snapshotted part are not read again: the keydir comes from the snapshot.
+	content, err := os.ReadFile(crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(crashed, bytes.Replace(content, []byte("k250"), []byte("x250"), 1), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	f = open(crashed)
+	if !hasKey(f, "k250") || hasKey(f, "x250") || !hasKey(f, "b") || f.Stats().Rows != 501 {
+		t.Fatalf("keydir should be loaded from the snapshot and the appended rows (%d rows)", f.Stats().Rows)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stale snapshots are ignored (ex: the start of the file changed).
+	os.Remove(crashed + CleanShutdownFileExtension)
+	copyFile(fpath+KeydirSnapshotFileExtension, crashed+KeydirSnapshotFileExtension)
+	content, err = os.ReadFile(crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(crashed, bytes.Replace(content, []byte("k000"), []byte("x000"), 1), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	f = open(crashed)
+	defer f.Close()
+	if hasKey(f, "k000") || !hasKey(f, "x000") || !hasKey(f, "b") {
+		t.Fatal("stale snapshot should be ignored")
+	}
+}
@@ -0,0 +1,59 @@
+package tridb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// EncodeUint64Key returns an 8-byte big-endian key, so that lexicographical order matches numeric order.
+func EncodeUint64Key(n uint64) []byte { return binary.BigEndian.AppendUint64(nil, n) }
+
+// DecodeUint64Key decodes a key encoded with EncodeUint64Key.
+func DecodeUint64Key(key []byte) (uint64, error) {
+	if len(key) != 8 {
+		return 0, fmt.Errorf("invalid uint64 key length: %d", len(key))
+	}
+	return binary.BigEndian.Uint64(key), nil
+}
+
+// EncodeTimeKey returns an 8-byte key for the given time (with nanosecond precision),
+// so that lexicographical order matches chronological order.
+//
+// Note: times must be between the years 1678 and 2262 (see time.Time.UnixNano).
+func EncodeTimeKey(t time.Time) []byte { return EncodeUint64Key(uint64(t.UnixNano()) ^ (1 << 63)) }
+
+// DecodeTimeKey decodes a key encoded with EncodeTimeKey.
+func DecodeTimeKey(key []byte) (time.Time, error) {
+	n, err := DecodeUint64Key(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(n^(1<<63))), nil
+}
+
+// WalkRangeUint64 calls do for each uint64 key (see EncodeUint64Key) in [min, max], in numeric order.
+func (r *Reader) WalkRangeUint64(min, max uint64, do func(key []byte, n uint64) error) error {
+	var end []byte
+	if max < math.MaxUint64 {
+		end = EncodeUint64Key(max + 1)
+	}
+	return r.WalkRange(EncodeUint64Key(min), end, func(key []byte) error {
+		n, err := DecodeUint64Key(key)
+		if err != nil {
+			return nil // skip keys that are not uint64 keys
+		}
+		return do(key, n)
+	})
+}
+
+// WalkRangeTime calls do for each time key (see EncodeTimeKey) in [from, to], in chronological order.
+func (r *Reader) WalkRangeTime(from, to time.Time, do func(key []byte, t time.Time) error) error {
+	min, _ := DecodeUint64Key(EncodeTimeKey(from))
+	max, _ := DecodeUint64Key(EncodeTimeKey(to))
+	return r.WalkRangeUint64(min, max, func(key []byte, n uint64) error {
+		t, _ := DecodeTimeKey(key)
+		return do(key, t)
+	})
+}
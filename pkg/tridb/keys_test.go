@@ -0,0 +1,388 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWalkRangeUint64(t *testing.T) {
+	for _, keydir := range []KeydirType{KeydirHash, KeydirTrie, KeydirAdaptive} {
+		t.Run(string(keydir), func(t *testing.T) {
+			f := openTestFile(t, WithKeydir(keydir))
+			for _, n := range []uint64{300, 1, 256, 2, 1 << 40} {
+				mustSet(t, f, string(EncodeUint64Key(n)), "")
+			}
+			mustSet(t, f, "not-a-number", "")
+
+			var got []uint64
+			_ = f.Read(func(r *Reader) error {
+				return r.WalkRangeUint64(2, 300, func(key []byte, n uint64) error {
+					got = append(got, n)
+					return nil
+				})
+			})
+			if want := []uint64{2, 256, 300}; len(got) != len(want) || got[0] != 2 || got[1] != 256 || got[2] != 300 {
+				t.Fatalf("got %v instead of %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFirstLastKey(t *testing.T) {
+	for _, keydir := range []KeydirType{KeydirHash, KeydirTrie, KeydirAdaptive} {
+		t.Run(string(keydir), func(t *testing.T) {
+			f := openTestFile(t, WithKeydir(keydir))
+			base := time.Unix(1000, 0)
+			for _, device := range []string{"a", "b"} {
+				for i := 0; i < 10; i++ {
+					mustSet(t, f, "device:"+device+":"+string(EncodeTimeKey(base.Add(time.Duration(i)*time.Second))), "")
+				}
+			}
+			mustSet(t, f, "other", "")
+			err := f.ReadWrite(func(r *Reader, w *Writer) error {
+				w.Delete([]byte("device:b:" + string(EncodeTimeKey(base.Add(9*time.Second)))))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_ = f.Read(func(r *Reader) error {
+				for _, tc := range []struct {
+					prefix      string
+					first, last string
+				}{
+					{"device:a:", "device:a:" + string(EncodeTimeKey(base)), "device:a:" + string(EncodeTimeKey(base.Add(9*time.Second)))},
+					{"device:b:", "device:b:" + string(EncodeTimeKey(base)), "device:b:" + string(EncodeTimeKey(base.Add(8*time.Second)))},
+					{"", "device:a:" + string(EncodeTimeKey(base)), "other"},
+					{"device:c:", "", ""},
+				} {
+					first, err := r.FirstKey([]byte(tc.prefix))
+					if err != nil || string(first) != tc.first {
+						t.Fatalf("got first key %q (%v) instead of %q for prefix %q", first, err, tc.first, tc.prefix)
+					}
+					last, err := r.LastKey([]byte(tc.prefix))
+					if err != nil || string(last) != tc.last {
+						t.Fatalf("got last key %q (%v) instead of %q for prefix %q", last, err, tc.last, tc.prefix)
+					}
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestTimeKey(t *testing.T) {
+	before, after := time.Unix(-10, 5), time.Unix(10, 0)
+	if string(EncodeTimeKey(before)) >= string(EncodeTimeKey(after)) {
+		t.Fatal("time keys are not ordered chronologically")
+	}
+	got, err := DecodeTimeKey(EncodeTimeKey(before))
+	if err != nil || !got.Equal(before) {
+		t.Fatalf("got time %s (%v) instead of %s", got, err, before)
+	}
+}
+
+func TestGeoKeys(t *testing.T) {
+	key, err := EncodeGeoKey(57.64911, 10.40744)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "u4pruydqqvj8" {
+		t.Fatalf("got geohash %q instead of %q", key, "u4pruydqqvj8")
+	}
+	lat, lon, err := DecodeGeoKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(lat-57.64911) > 1e-6 || math.Abs(lon-10.40744) > 1e-6 {
+		t.Fatalf("got location (%v, %v)", lat, lon)
+	}
+
+	f := openTestFile(t)
+	places := map[string][2]float64{
+		"paris":      {48.8566, 2.3522},
+		"versailles": {48.8049, 2.1204},
+		"london":     {51.5074, -0.1278},
+		"sydney":     {-33.8688, 151.2093},
+	}
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		for name, loc := range places {
+			key, err := EncodeGeoKey(loc[0], loc[1])
+			if err != nil {
+				return err
+			}
+			w.Set(append(append([]byte("places/"), key...), "/"+name...), nil)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found []string
+	_ = f.Read(func(r *Reader) error {
+		return r.WalkBoundingBox([]byte("places/"), 48, 1, 49.5, 3, func(key []byte, lat, lon float64) error {
+			found = append(found, string(key[len("places/")+GeoKeyLength+1:]))
+			return nil
+		})
+	})
+	sort.Strings(found)
+	if strings.Join(found, ",") != "paris,versailles" {
+		t.Fatalf("got %q in bounding box", found)
+	}
+}
+
+func TestSeekPrefix(t *testing.T) {
+	for _, keydir := range []KeydirType{KeydirHash, KeydirTrie, KeydirAdaptive} {
+		t.Run(string(keydir), func(t *testing.T) {
+			f := openTestFile(t, WithKeydir(keydir))
+			for _, key := range []string{"users/2", "orders/1", "users/10", "users/1", "zzz"} {
+				mustSet(t, f, key, "")
+			}
+			_ = f.Read(func(r *Reader) error {
+				var got []string
+				var last *RowReader
+				for c := r.SeekPrefix([]byte("users/")); c != nil; c = c.NextLex() {
+					got = append(got, string(c.Key()))
+					last = c
+				}
+				if want := "users/1 users/10 users/2"; strings.Join(got, " ") != want {
+					t.Fatalf("got %q instead of %q", got, want)
+				}
+				got = nil
+				for c := last; c != nil; c = c.PreviousLex() {
+					got = append(got, string(c.Key()))
+				}
+				if want := "users/2 users/10 users/1"; strings.Join(got, " ") != want {
+					t.Fatalf("got %q instead of %q", got, want)
+				}
+				if r.SeekPrefix([]byte("missing/")) != nil {
+					t.Fatal("expected nil cursor for missing prefix")
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestWalkKeysAppend(t *testing.T) {
+	f := openTestFile(t, WithKeydir(KeydirTrie))
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		for i := 0; i < 1000; i++ {
+			w.Set(fmt.Appendf(nil, "users/%04d", i), nil)
+		}
+		w.Set([]byte("zzz"), nil)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Read(func(r *Reader) error {
+		buf, count := make([]byte, 0, 16), 0
+		want := make([]string, 1000)
+		for i := range want {
+			want[i] = fmt.Sprintf("users/%04d", i)
+		}
+		allocs := testing.AllocsPerRun(10, func() {
+			count = 0
+			err := r.WalkKeysAppend([]byte("users/"), buf, func(key []byte) error {
+				if string(key) != want[count] {
+					t.Fatalf("got key %q instead of %q", key, want[count])
+				}
+				count++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+		if count != 1000 {
+			t.Fatalf("got %d keys instead of 1000", count)
+		}
+		if allocs > 10 {
+			t.Fatalf("got %g allocations for 1000 keys", allocs)
+		}
+		return nil
+	})
+}
+
+func TestCountPrefix(t *testing.T) {
+	for _, keydir := range []KeydirType{KeydirHash, KeydirTrie, KeydirAdaptive} {
+		t.Run(string(keydir), func(t *testing.T) {
+			f := openTestFile(t, WithKeydir(keydir))
+			err := f.ReadWrite(func(r *Reader, w *Writer) error {
+				for i := 0; i < 100; i++ {
+					w.Set(fmt.Appendf(nil, "users/%03d", i), nil)
+				}
+				w.Delete([]byte("users/000"))
+				w.SetWithTTL([]byte("users/expired"), nil, -time.Second)
+				w.Set([]byte("posts/1"), nil)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertCount := func(prefix string, want int) {
+				t.Helper()
+				_ = f.Read(func(r *Reader) error {
+					got, err := r.CountPrefix([]byte(prefix))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != want {
+						t.Fatalf("got count %d instead of %d for prefix %q", got, want, prefix)
+					}
+					return nil
+				})
+			}
+			assertCount("users/", 99)
+			assertCount("users/01", 10)
+			assertCount("", 100)
+			assertCount("none", 0)
+
+			// Without expiring keys (the trie keydir counts without walking)
+			if err := f.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			assertCount("users/", 99)
+			assertCount("users/09", 10)
+			assertCount("users/099", 1)
+			assertCount("users/0990", 0)
+		})
+	}
+}
+
+func TestWalkShuffle(t *testing.T) {
+	f := openTestFile(t)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		for i := 0; i < 100; i++ {
+			w.Set(fmt.Appendf(nil, "k%03d", i), nil)
+		}
+		w.Set([]byte("other"), nil)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walk := func(opts WalkOptions) (keys []string) {
+		t.Helper()
+		_ = f.Read(func(r *Reader) error {
+			_, err := r.WalkWithOptions([]byte("k"), opts, func(key []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			})
+			return err
+		})
+		return keys
+	}
+
+	sorted := walk(WalkOptions{})
+	shuffled := walk(WalkOptions{Shuffle: true, Seed: 1})
+	if len(shuffled) != 100 || sort.StringsAreSorted(shuffled) {
+		t.Fatalf("got unexpected shuffled keys: %q", shuffled)
+	}
+	if got := walk(WalkOptions{Shuffle: true, Seed: 1}); strings.Join(got, ",") != strings.Join(shuffled, ",") {
+		t.Fatal("shuffled walks with the same seed visit keys in a different order")
+	}
+	sort.Strings(shuffled)
+	if strings.Join(sorted, ",") != strings.Join(shuffled, ",") {
+		t.Fatalf("got shuffled keys %q instead of %q", shuffled, sorted)
+	}
+}
+
+func TestWalkBreak(t *testing.T) {
+	f := openTestFile(t)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		mustSet(t, f, key, key)
+	}
+	_ = f.Read(func(r *Reader) error {
+		var keys []string
+		n, err := r.Walk(nil, func(key []byte) error {
+			keys = append(keys, string(key))
+			if len(keys) == 2 {
+				return ErrBreak
+			}
+			return nil
+		})
+		if err != nil || n != 2 || strings.Join(keys, ",") != "a,b" {
+			t.Fatalf("visited %d keys %q (error: %v)", n, keys, err)
+		}
+		n, err = r.WalkWithValue(nil, func(key, value []byte) error { return nil })
+		if err != nil || n != 4 {
+			t.Fatalf("visited %d keys (error: %v)", n, err)
+		}
+		errFailed := errors.New("failed")
+		n, err = r.WalkWithValue([]byte("c"), func(key, value []byte) error { return errFailed })
+		if !errors.Is(err, errFailed) || n != 1 {
+			t.Fatalf("visited %d keys (error: %v)", n, err)
+		}
+		_, err = r.WalkWithOptions(nil, WalkOptions{Shuffle: true}, func(key []byte) error { return ErrBreak })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	})
+}
+
+func TestWalkPagination(t *testing.T) {
+	for _, keydir := range []KeydirType{KeydirHash, KeydirTrie, KeydirAdaptive} {
+		t.Run(string(keydir), func(t *testing.T) {
+			f := openTestFile(t, WithKeydir(keydir))
+			for _, key := range []string{"a", "k1", "k2", "k3", "k4", "k5", "z"} {
+				mustSet(t, f, key, "")
+			}
+			paginate := func(opts WalkOptions) (pages []string) {
+				t.Helper()
+				_ = f.Read(func(r *Reader) error {
+					for {
+						var page []string
+						last, err := r.WalkWithOptions([]byte("k"), opts, func(key []byte) error {
+							page = append(page, string(key))
+							return nil
+						})
+						if err != nil {
+							t.Fatal(err)
+						}
+						if last == nil {
+							return nil
+						}
+						pages, opts.StartAfter = append(pages, strings.Join(page, ",")), last
+					}
+				})
+				return pages
+			}
+			if got := paginate(WalkOptions{Limit: 2}); strings.Join(got, " ") != "k1,k2 k3,k4 k5" {
+				t.Fatalf("got pages %q", got)
+			}
+			if got := paginate(WalkOptions{Limit: 2, Reverse: true}); strings.Join(got, " ") != "k5,k4 k3,k2 k1" {
+				t.Fatalf("got reverse pages %q", got)
+			}
+			if got := paginate(WalkOptions{Limit: 4, StartAfter: []byte("a")}); strings.Join(got, " ") != "k1,k2,k3,k4 k5" {
+				t.Fatalf("got pages %q", got)
+			}
+			if got := paginate(WalkOptions{StartAfter: []byte("k2")}); strings.Join(got, " ") != "k3,k4,k5" {
+				t.Fatalf("got pages %q", got)
+			}
+			if got := paginate(WalkOptions{StartAfter: []byte("z"), Reverse: true, Limit: 10}); strings.Join(got, " ") != "k5,k4,k3,k2,k1" {
+				t.Fatalf("got pages %q", got)
+			}
+			_ = f.Read(func(r *Reader) error {
+				visited := 0
+				_, err := r.WalkWithOptions([]byte("k"), WalkOptions{Shuffle: true, Limit: 3}, func(key []byte) error {
+					visited++
+					return nil
+				})
+				if err != nil || visited != 3 {
+					t.Fatalf("visited %d keys (error: %v)", visited, err)
+				}
+				return nil
+			})
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package tridb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LeaseFileExtension is added to the path of a database file to get the path of its writer lease (see OpenShared).
+const LeaseFileExtension = ".lease"
+
+// DefaultLeaseDuration is the duration of writer leases (see OpenShared).
+const DefaultLeaseDuration = 10 * time.Second
+
+// ErrLeaseExpired is returned when committing with a writer lease that expired (see OpenShared).
+var ErrLeaseExpired = errors.New("writer lease expired")
+
+// The lease file holds the owner of the lease and its expiration time:
+//
+//	tridb-lease 1 <owner> <expiration time in Unix nanoseconds>
+const leaseHeader = "tridb-lease 1"
+
+// lease is the content of a lease file, invalid lease files are considered expired.
+type lease struct {
+	owner     string
+	expiresAt int64
+}
+
+// SharedFile is a database file shared by several processes: one process holds the writer lease
+// and opens the file for writing, the others open it in read-only mode (and follow the writes with WithTail).
+//
+// Leases are renewed in the background, when the writer stops renewing its lease (crash, stall or Close),
+// another process acquires it once expired and reopens the file for writing.
+// A writer that couldn't renew its lease in time fails commits with ErrLeaseExpired and becomes a reader.
+//
+// Lease expiration relies on the clocks of the processes, which must thus run on the same host
+// (or on hosts with synchronized clocks and a shared filesystem with atomic renames and links).
+// State set with File methods (ex: File.SetMergeOperator) is not kept when the role of the process changes.
+// Writers don't take the lock of the file (see LockFileExtension), the file must only be written to through SharedFile.
+type SharedFile struct {
+	fpath     string
+	opts      Options
+	owner     string
+	duration  time.Duration
+	expiresAt atomic.Int64 // expiration of the writer lease held by the process (0 for readers)
+
+	mu   sync.RWMutex
+	file *File
+
+	stop, done chan struct{}
+}
+
+// OpenShared opens the database file as writer if the writer lease (of the given duration) is available,
+// in read-only mode otherwise (see SharedFile).
+func OpenShared(fpath string, leaseDuration time.Duration, opts ...Option) (*SharedFile, error) {
+	if leaseDuration <= 0 {
+		leaseDuration = DefaultLeaseDuration
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("generate lease owner: %w", err)
+	}
+	sf := &SharedFile{
+		fpath:    fpath,
+		owner:    strconv.Itoa(os.Getpid()) + "-" + hex.EncodeToString(id),
+		duration: leaseDuration,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&sf.opts)
+	}
+
+	acquired, err := sf.acquire()
+	if err != nil {
+		return nil, fmt.Errorf("acquire lease: %w", err)
+	}
+	if acquired {
+		sf.file, err = sf.openWriter()
+	} else {
+		sf.file, err = sf.openReader()
+	}
+	if err != nil {
+		if acquired {
+			sf.release()
+		}
+		return nil, err
+	}
+	go sf.loop()
+	return sf, nil
+}
+
+// File returns the current database file, it changes when the role of the process changes.
+func (sf *SharedFile) File() *File {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.file
+}
+
+// IsWriter reports whether the process holds the writer lease.
+func (sf *SharedFile) IsWriter() bool { return sf.expiresAt.Load() != 0 }
+
+// Read executes a read-only transaction on the current file (see File.Read).
+func (sf *SharedFile) Read(do func(r *Reader) error) error {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.file.Read(do)
+}
+
+// ReadWrite executes a read-write transaction on the current file (see File.ReadWrite),
+// it fails with ErrReadOnly if the process isn't the writer.
+func (sf *SharedFile) ReadWrite(do func(r *Reader, w *Writer) error) error {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.file.ReadWrite(do)
+}
+
+// Close closes the file and releases the writer lease (if held), so that another process can take over right away.
+func (sf *SharedFile) Close() error {
+	close(sf.stop)
+	<-sf.done
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	err := sf.file.Close()
+	if sf.IsWriter() {
+		sf.release()
+	}
+	return err
+}
+
+func (sf *SharedFile) openWriter() (*File, error) {
+	opts := sf.opts
+	opts.ReadOnly = false
+	opts.DisableLocking = true // writers are fenced by the lease, so that stalled writers can be taken over
+	hook := opts.PreCommitHook
+	opts.PreCommitHook = func(w *Writer) error {
+		if time.Now().UnixNano() >= sf.expiresAt.Load() {
+			return ErrLeaseExpired
+		}
+		if hook != nil {
+			return hook(w)
+		}
+		return nil
+	}
+	return OpenWithOptions(sf.fpath, &opts)
+}
+
+func (sf *SharedFile) openReader() (*File, error) {
+	// Create the file if the writer didn't yet (read-only files must exist).
+	created, err := os.OpenFile(sf.fpath, os.O_RDONLY|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("create datafile: %w", err)
+	}
+	created.Close()
+	opts := sf.opts
+	opts.ReadOnly = true
+	return OpenWithOptions(sf.fpath, &opts)
+}
+
+// loop renews the writer lease (or tries to acquire it) periodically.
+func (sf *SharedFile) loop() {
+	defer close(sf.done)
+	ticker := time.NewTicker(sf.duration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sf.stop:
+			return
+		case <-ticker.C:
+		}
+		if sf.IsWriter() {
+			if err := sf.renew(); err != nil {
+				sf.switchRole(false)
+			}
+		} else if acquired, err := sf.acquire(); err == nil && acquired {
+			sf.switchRole(true)
+		}
+	}
+}
+
+// switchRole reopens the file as writer or reader, a process that can't reopen the file as writer gives up the lease.
+func (sf *SharedFile) switchRole(writer bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var f *File
+	var err error
+	if writer {
+		f, err = sf.openWriter()
+	} else {
+		sf.expiresAt.Store(0)
+		f, err = sf.openReader()
+	}
+	if err != nil {
+		if writer {
+			sf.release()
+		}
+		return // a reader keeps the writer file (whose commits fail with ErrLeaseExpired) until the next try
+	}
+	sf.file.Close()
+	sf.file = f
+}
+
+// acquire takes the writer lease if it is available or expired.
+func (sf *SharedFile) acquire() (bool, error) {
+	path := sf.fpath + LeaseFileExtension
+	now := time.Now()
+	ours := lease{owner: sf.owner, expiresAt: now.Add(sf.duration).UnixNano()}
+	err := sf.createLease(ours)
+	if err == nil {
+		sf.expiresAt.Store(ours.expiresAt)
+		return true, nil
+	} else if !errors.Is(err, os.ErrExist) {
+		return false, err
+	}
+
+	current, err := readLease(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil // released meanwhile, retried on the next tick
+	} else if err != nil {
+		return false, err
+	}
+	if current.expiresAt > now.UnixNano() {
+		return false, nil
+	}
+
+	// Move the expired lease aside and check that it wasn't replaced meanwhile (by another process taking over).
+	aside := path + "." + sf.owner
+	if err := os.Rename(path, aside); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	moved, err := readLease(aside)
+	if err != nil || moved != current {
+		os.Link(aside, path) // restore the lease of the other process
+		os.Remove(aside)
+		return false, err
+	}
+	os.Remove(aside)
+	if err := sf.createLease(ours); errors.Is(err, os.ErrExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	sf.expiresAt.Store(ours.expiresAt)
+	return true, nil
+}
+
+// renew extends the writer lease, it fails if the lease expired or was taken by another process.
+func (sf *SharedFile) renew() error {
+	path := sf.fpath + LeaseFileExtension
+	now := time.Now().UnixNano()
+	current, err := readLease(path)
+	if err != nil {
+		return err
+	}
+	if current.owner != sf.owner || current.expiresAt <= now || sf.expiresAt.Load() <= now {
+		return ErrLeaseExpired
+	}
+	ours := lease{owner: sf.owner, expiresAt: now + int64(sf.duration)}
+	tmp, err := sf.writeTempLease(ours)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	sf.expiresAt.Store(ours.expiresAt)
+	return nil
+}
+
+// release removes the writer lease if the process still holds it.
+func (sf *SharedFile) release() {
+	sf.expiresAt.Store(0)
+	path := sf.fpath + LeaseFileExtension
+	if current, err := readLease(path); err == nil && current.owner == sf.owner {
+		os.Remove(path)
+	}
+}
+
+// createLease creates the lease file, it fails with os.ErrExist if it already exists.
+// The lease is written to a temporary file first so that the lease file is never partially written.
+func (sf *SharedFile) createLease(l lease) error {
+	tmp, err := sf.writeTempLease(l)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Link(tmp, sf.fpath+LeaseFileExtension)
+}
+
+func (sf *SharedFile) writeTempLease(l lease) (string, error) {
+	tmp := sf.fpath + LeaseFileExtension + ".tmp-" + sf.owner
+	content := fmt.Sprintf("%s %s %d\n", leaseHeader, l.owner, l.expiresAt)
+	if err := os.WriteFile(tmp, []byte(content), 0o666); err != nil {
+		return "", fmt.Errorf("write lease: %w", err)
+	}
+	return tmp, nil
+}
+
+func readLease(path string) (lease, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return lease{}, err
+	}
+	fields := strings.Fields(strings.TrimPrefix(string(content), leaseHeader+" "))
+	if !strings.HasPrefix(string(content), leaseHeader+" ") || len(fields) != 2 {
+		return lease{}, nil
+	}
+	expiresAt, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return lease{}, nil
+	}
+	return lease{owner: fields[0], expiresAt: expiresAt}, nil
+}
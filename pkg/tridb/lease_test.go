@@ -0,0 +1,97 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSharedFile(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	const leaseDuration = 60 * time.Millisecond
+	a, err := OpenShared(fpath, leaseDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenShared(fpath, leaseDuration, WithTail(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if !a.IsWriter() || b.IsWriter() {
+		t.Fatalf("got writers %v and %v instead of true and false", a.IsWriter(), b.IsWriter())
+	}
+	set := func(sf *SharedFile, key, value string) error {
+		return sf.ReadWrite(func(r *Reader, w *Writer) error {
+			w.Set([]byte(key), []byte(value))
+			return nil
+		})
+	}
+	if err := set(b, "k", "v"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("got error %v instead of %v", err, ErrReadOnly)
+	}
+
+	// The reader follows the writes of the writer.
+	if err := set(a, "k", "1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return sharedValue(b, "k") == "1" })
+	if err := a.File().Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := set(a, "k", "2"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return sharedValue(b, "k") == "2" })
+
+	// The reader becomes the writer once the lease is released.
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, b.IsWriter)
+	if err := set(b, "k", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sharedValue(b, "k"); got != "3" {
+		t.Fatalf("got %q instead of %q", got, "3")
+	}
+
+	// Expired leases are taken over.
+	b.stop <- struct{}{} // stop renewing the lease (as a stalled process)
+	c, err := OpenShared(fpath, leaseDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitFor(t, c.IsWriter)
+	if err := set(b, "k", "stale"); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("got error %v instead of %v", err, ErrLeaseExpired)
+	}
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	go b.loop()
+	waitFor(t, func() bool { return !b.IsWriter() })
+	if _, err := os.Stat(fpath + LeaseFileExtension); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sharedValue(sf *SharedFile, key string) (value string) {
+	_ = sf.Read(func(r *Reader) error {
+		v, _ := r.Get([]byte(key))
+		value = string(v)
+		return nil
+	})
+	return value
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LockFileExtension is added to the path of a database file to get the path of its lock file.
+//
+// Files opened for writing hold an exclusive advisory lock on the lock file (flock on Unix, LockFileEx on Windows),
+// so that two processes can't write to the same file (see ErrDatabaseLocked).
+// The lock file is kept after Close (removing it would let two processes lock different files).
+const LockFileExtension = ".lock"
+
+// ErrDatabaseLocked is returned when opening a file for writing while another process (or File) has it open for writing.
+var ErrDatabaseLocked = errors.New("database is locked by another process")
+
+// lockRetryInterval is the time between two attempts to acquire a lock held by another process (see WithLockTimeout).
+const lockRetryInterval = 10 * time.Millisecond
+
+// lockFile acquires the lock of the given database file, waiting up to the given duration if it is held.
+func lockFile(fpath string, timeout time.Duration) (*os.File, error) {
+	lf, err := os.OpenFile(fpath+LockFileExtension, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(lf)
+		if err != nil {
+			lf.Close()
+			return nil, fmt.Errorf("lock: %w", err)
+		}
+		if locked {
+			return lf, nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			lf.Close()
+			return nil, fmt.Errorf("%w: %s", ErrDatabaseLocked, fpath)
+		}
+		time.Sleep(min(wait, lockRetryInterval))
+	}
+}
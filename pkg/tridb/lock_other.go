@@ -0,0 +1,8 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package tridb
+
+import "os"
+
+// tryLock always succeeds: advisory locks are not supported on this platform.
+func tryLock(lf *os.File) (bool, error) { return true, nil }
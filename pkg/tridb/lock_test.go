@@ -0,0 +1,50 @@
+package tridb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLock(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one File can write to the database file
+	if _, err := Open(fpath, 10); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("got error %v instead of %v", err, ErrDatabaseLocked)
+	}
+	readOnly, err := OpenReadOnly(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly.Close()
+	unlocked, err := Open(fpath, 10, WithLocking(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlocked.Close()
+
+	// Waiting for the lock
+	start := time.Now()
+	if _, err := Open(fpath, 10, WithLockTimeout(30*time.Millisecond)); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("got error %v instead of %v", err, ErrDatabaseLocked)
+	} else if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("gave up after %s", elapsed)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		f.Close()
+	}()
+	f, err = Open(fpath, 10, WithLockTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package tridb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately               = 0x1
+	lockfileExclusiveLock                 = 0x2
+	errorLockViolation      syscall.Errno = 33
+)
+
+// tryLock locks the first byte of the given file with LockFileEx, it reports false if another handle holds it.
+func tryLock(lf *os.File) (bool, error) {
+	overlapped := &syscall.Overlapped{}
+	ok, _, err := procLockFileEx.Call(lf.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if ok != 0 {
+		return true, nil
+	}
+	if errors.Is(err, errorLockViolation) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,73 @@
+package tridb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ErrTransactionTooLong is returned by read-write transactions aborted because they held the write lock
+// longer than the maximum transaction duration (see WithMaxTransactionDuration).
+var ErrTransactionTooLong = errors.New("transaction held the write lock too long")
+
+// LongTransaction describes a read-write transaction holding the write lock longer than the maximum
+// transaction duration (see WithMaxTransactionDuration).
+type LongTransaction struct {
+	Held  time.Duration // How long the transaction held the write lock when it was reported.
+	Stack string        // Stack of the goroutine that started the transaction.
+}
+
+// logLongTransaction is the default handler of long transactions.
+func logLongTransaction(tx LongTransaction) {
+	log.Printf("tridb: transaction holding the write lock for %s, started at:\n%s", tx.Held, tx.Stack)
+}
+
+// watchTransaction reports the read-write transaction starting now (with the write lock held)
+// if it's still running after the maximum transaction duration, and cancels the returned context
+// with ErrTransactionTooLong if long transactions are aborted.
+// The returned function must be called before releasing the write lock.
+func (f *File) watchTransaction(ctx context.Context) (context.Context, func()) {
+	if f.opts.MaxTransactionDuration <= 0 {
+		return ctx, func() {}
+	}
+	start := time.Now()
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(4, pcs)] // skip runtime.Callers, watchTransaction, readWrite and ReadWriteCtx
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(f.opts.MaxTransactionDuration, func() {
+		f.opts.LongTransactionHandler(LongTransaction{Held: time.Since(start), Stack: formatStack(pcs)})
+		if f.opts.AbortLongTransactions {
+			cancel(ErrTransactionTooLong)
+		}
+	})
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// formatStack formats the given program counters like a goroutine stack trace.
+func formatStack(pcs []uintptr) string {
+	sb := &strings.Builder{}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			return sb.String()
+		}
+	}
+}
+
+// withCause adds the cause of the cancellation of the given context to an error returned because it was canceled
+// (for example, ErrTransactionTooLong).
+func withCause(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(err, context.Canceled) && cause != nil && !errors.Is(err, cause) {
+		return fmt.Errorf("%w: %w", err, cause)
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package tridb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxTransactionDuration(t *testing.T) {
+	for _, abort := range []bool{false, true} {
+		reported := make(chan LongTransaction, 1)
+		f := openTestFile(t, WithMaxTransactionDuration(10*time.Millisecond, abort, func(tx LongTransaction) { reported <- tx }))
+		mustSet(t, f, "fast", "1")
+		select {
+		case tx := <-reported:
+			t.Fatalf("a fast transaction was reported after %s", tx.Held)
+		default:
+		}
+
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			w.Set([]byte("slow"), []byte("1"))
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		})
+		tx := <-reported
+		if tx.Held < 10*time.Millisecond || !strings.Contains(tx.Stack, "TestMaxTransactionDuration") {
+			t.Fatalf("got long transaction held for %s with stack:\n%s", tx.Held, tx.Stack)
+		}
+		if abort {
+			if !errors.Is(err, ErrTransactionTooLong) || !errors.Is(err, ErrTxnAborted) {
+				t.Fatalf("got error %v instead of %v", err, ErrTransactionTooLong)
+			}
+			assertValue(t, f, "slow", "")
+		} else {
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertValue(t, f, "slow", "1")
+		}
+	}
+}
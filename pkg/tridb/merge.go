@@ -0,0 +1,227 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// MergeOperator combines the existing value of a key (nil if the key doesn't exist)
+// with the merge operands written since (oldest first), it returns the new value of the key.
+type MergeOperator func(key, existing []byte, operands [][]byte) ([]byte, error)
+
+// ErrNoMergeOperator is returned when writing or reading merge operands without merge operator.
+var ErrNoMergeOperator = errors.New("no merge operator")
+
+// maxMergeDepth is the maximum number of merge rows read to resolve a value,
+// the merge row that would exceed it is written with the resolved value instead.
+const maxMergeDepth = 64
+
+// mergeLink is the position of the previous row of a key, referenced by merge rows.
+type mergeLink struct {
+	offset, size int
+	depth        int // number of merge rows in the chain (including the merge row)
+}
+
+// SetMergeOperator sets the function resolving merge operands (see Writer.Merge).
+// The same operator must be set every time the file is opened.
+func (f *File) SetMergeOperator(merge MergeOperator) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.merge = merge
+}
+
+// Merge records an operand that the merge operator combines with the current value of the key
+// (see File.SetMergeOperator), without reading the value in the transaction.
+// Operands are resolved when the key is read and collapsed into a plain value on compaction.
+//
+// Merging into an alias replaces it (the alias target is not used as existing value).
+func (w *Writer) Merge(key, operand []byte) {
+	if w.checkKey(key) && w.checkValue(operand) {
+		w.stage(&Row{Key: key, Value: operand, IsMerge: true})
+	}
+}
+
+// storedValue returns the plain value of the given row (nil for delete and alias rows).
+func (f *File) storedValue(ra io.ReaderAt, row *Row) ([]byte, error) {
+	switch {
+	case row.IsDeleted || row.IsAlias:
+		return nil, nil
+	case row.IsMerge:
+		return f.mergedValue(ra, row)
+	}
+	return f.plainValue(row)
+}
+
+// mergedValue resolves the value of a merge row by following its chain of merge rows back to a plain value.
+func (f *File) mergedValue(ra io.ReaderAt, row *Row) ([]byte, error) {
+	if f.merge == nil {
+		return nil, fmt.Errorf("%w for key %q", ErrNoMergeOperator, row.Key)
+	}
+	var existing []byte
+	var operands [][]byte
+	for current := row; ; {
+		if !current.IsMerge {
+			value, err := f.storedValue(ra, current)
+			if err != nil {
+				return nil, err
+			}
+			existing = value
+			break
+		}
+		operand, err := f.plainValue(current)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		if current.mergeBase.size == 0 {
+			break
+		}
+		base, err := f.readAndDecodeRow(ra, fidx.Position{current.mergeBase.offset, current.mergeBase.size})
+		if err != nil {
+			return nil, fmt.Errorf("read merge base of %q: %w", row.Key, err)
+		}
+		current = base
+	}
+	slices.Reverse(operands)
+	value, err := f.merge(row.Key, existing, operands)
+	if err != nil {
+		return nil, fmt.Errorf("merge %q: %w", row.Key, err)
+	}
+	return value, nil
+}
+
+// currentRow returns the row holding the current value of the given key (nil if the key doesn't exist).
+func (f *File) currentRow(key []byte) (*Row, error) {
+	rowInfo := f.idx.Get(f.indexKey(key))
+	if rowInfo == nil || f.newReader().expired(rowInfo) {
+		return nil, nil
+	}
+	return f.readAndDecodeRow(f.readerAt(), rowInfo.Position)
+}
+
+// checkMergeOperator returns an error if merge rows are written without merge operator.
+func (f *File) checkMergeOperator(rows []*Row) error {
+	if f.merge != nil {
+		return nil
+	}
+	for _, row := range rows {
+		if row.IsMerge {
+			return fmt.Errorf("%w for key %q", ErrNoMergeOperator, row.Key)
+		}
+	}
+	return nil
+}
+
+// linkMerge makes the given merge row reference the current row of its key, before it is written.
+// Once the chain reaches maxMergeDepth, the row is collapsed into a plain row instead.
+func (f *File) linkMerge(row *Row) error {
+	if !row.IsMerge {
+		return nil
+	}
+	row.mergeBase = mergeLink{}
+	rowInfo := f.idx.Get(f.indexKey(row.Key))
+	if rowInfo == nil || f.newReader().expired(rowInfo) {
+		return nil
+	}
+	row.ContentType = rowInfo.ContentType // merge rows keep the content type of the key
+	base, err := f.readAndDecodeRow(f.readerAt(), rowInfo.Position)
+	if err != nil {
+		return fmt.Errorf("read merge base of %q: %w", row.Key, err)
+	}
+	depth := 1
+	if base.IsMerge {
+		depth = base.mergeBase.depth + 1
+	}
+	if depth < maxMergeDepth {
+		row.mergeBase = mergeLink{offset: rowInfo.Position.Offset(), size: rowInfo.Position.Size(), depth: depth}
+		return nil
+	}
+	existing, err := f.storedValue(f.store, base)
+	if err != nil {
+		return err
+	}
+	return f.collapseMerge(row, existing)
+}
+
+// collapseMerges replaces the merge rows of a batch by plain rows holding the resolved values,
+// since rows of a batch cannot reference each other.
+func (f *File) collapseMerges(rows []*Row) error {
+	if err := f.checkMergeOperator(rows); err != nil {
+		return err
+	}
+	latest := map[string]*Row{} // latest row of keys written in the batch
+	for _, row := range rows {
+		if row.IsMerge {
+			base, ok := latest[string(row.Key)]
+			if !ok {
+				var err error
+				if base, err = f.currentRow(row.Key); err != nil {
+					return fmt.Errorf("read merge base of %q: %w", row.Key, err)
+				}
+			}
+			var existing []byte
+			if base != nil {
+				row.ContentType = base.ContentType
+				var err error
+				if existing, err = f.storedValue(f.store, base); err != nil {
+					return err
+				}
+			}
+			if err := f.collapseMerge(row, existing); err != nil {
+				return err
+			}
+		}
+		latest[string(row.Key)] = row
+	}
+	return nil
+}
+
+// collapseMerge turns the given merge row into a plain row holding the merge of its operand with the existing value.
+func (f *File) collapseMerge(row *Row, existing []byte) error {
+	operand, err := f.plainValue(row)
+	if err != nil {
+		return err
+	}
+	value, err := f.merge(row.Key, existing, [][]byte{operand})
+	if err != nil {
+		return fmt.Errorf("merge %q: %w", row.Key, err)
+	}
+	f.setResolvedValue(row, value)
+	return nil
+}
+
+// setResolvedValue turns the given merge row into a plain row with the given value.
+func (f *File) setResolvedValue(row *Row, value []byte) {
+	row.Value, row.IsMerge, row.IsSealed, row.Compression, row.mergeBase = value, false, false, NoCompression, mergeLink{}
+	f.compressRow(row)
+	f.keyring.sealRow(row)
+	if row.Checksum != 0 {
+		row.Checksum = row.computeChecksum()
+	}
+}
+
+// isMergeOp reports whether the given op (the first byte of encoded rows with all formats) is a merge op.
+// Rows encrypted at rest are identified as merge rows by their op too (see WithEncryption).
+func isMergeOp(op byte) bool {
+	return op == opMerge || op == opMergeWithAttrs || op == opEncryptedMerge
+}
+
+// collapseEncodedRow re-encodes the given merge row as a plain row holding its resolved value (see File.Compact).
+func (f *File) collapseEncodedRow(encodedRow []byte) ([]byte, error) {
+	row := &Row{}
+	_, err := f.format.DecodeFrom(bytes.NewReader(encodedRow), row)
+	if err != nil {
+		return nil, fmt.Errorf("decode row: %w", err)
+	}
+	value, err := f.mergedValue(f.store, row)
+	if err != nil {
+		return nil, err
+	}
+	f.setResolvedValue(row, value)
+	return f.format.Encode(row)
+}
@@ -0,0 +1,90 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// appendMerge appends operands to the existing value.
+func appendMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	return append(bytes.Clone(existing), bytes.Join(operands, nil)...), nil
+}
+
+func TestMerge(t *testing.T) {
+	for _, format := range []Format{BinaryEncoding, TextEncoding, TextAutoLengthEncoding} {
+		t.Run(format.Name(), func(t *testing.T) {
+			fpath := filepath.Join(t.TempDir(), "test.tridb")
+			f, err := Open(fpath, 10, WithFormat(format))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { f.Close() }()
+			merge := func(key, operand string) error {
+				return f.ReadWrite(func(r *Reader, w *Writer) error {
+					w.Merge([]byte(key), []byte(operand))
+					return nil
+				})
+			}
+			if err := merge("a", "x"); !errors.Is(err, ErrNoMergeOperator) {
+				t.Fatalf("got error %v instead of %v", err, ErrNoMergeOperator)
+			}
+			f.SetMergeOperator(appendMerge)
+
+			// Merge into missing and existing keys, within and across transactions.
+			mustSet(t, f, "a", "base:")
+			want := "base:"
+			for i := 0; i < 2*maxMergeDepth; i++ {
+				want += string(rune('a' + i%26))
+				if err := merge("a", want[len(want)-1:]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = f.ReadWrite(func(r *Reader, w *Writer) error {
+				w.Merge([]byte("b"), []byte("1"))
+				w.Merge([]byte("b"), []byte("2"))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := f.Batch()
+			b.Merge([]byte("b"), []byte("3"))
+			b.Merge([]byte("b"), []byte("4"))
+			if err := b.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			assertValue(t, f, "a", want)
+			assertValue(t, f, "b", "1234")
+
+			// Merge rows are collapsed on compaction.
+			if err := f.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(fpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, numRows, err := f.scanRows(bufio.NewReader(bytes.NewReader(content)), 0, -1, func(row *Row, p fidx.Position) {
+				if row.IsMerge {
+					t.Errorf("merge row left for %q after compaction", row.Key)
+				}
+			})
+			if err != nil || numRows != 3 {
+				t.Fatalf("got %d rows (%v) instead of 3 (a, b and the sequences)", numRows, err)
+			}
+			f.Close()
+			f, err = Open(fpath, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertValue(t, f, "a", want)
+			assertValue(t, f, "b", "1234")
+		})
+	}
+}
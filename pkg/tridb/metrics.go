@@ -0,0 +1,69 @@
+package tridb
+
+import "time"
+
+// Metrics receives measurements of the operations of a file (see WithMetrics and package tridbmetrics),
+// state such as the number of keys or dead bytes is available with File.Stats.
+//
+// Methods are called synchronously (some with the file lock held), they must be fast and safe for concurrent use.
+type Metrics interface {
+	// Written is called after each commit with the number of rows and bytes appended to the file.
+	Written(rows, bytes int)
+	// Synced is called after each sync of the file to disk with its duration.
+	Synced(d time.Duration)
+	// Read is called after each read-only transaction with its duration (including the callback).
+	Read(d time.Duration)
+	// Compacted is called after each successful compaction with its duration and the size of the file before and after.
+	Compacted(d time.Duration, before, after int)
+}
+
+// LockMetrics can be implemented by Metrics to also receive the time spent waiting for the file lock
+// by transactions (write is false for read-only transactions), batches and compactions.
+type LockMetrics interface {
+	LockWaited(write bool, d time.Duration)
+}
+
+// lockWaited reports the time spent waiting for the lock since start (see LockMetrics).
+func (f *File) lockWaited(write bool, start time.Time) {
+	if m, ok := f.opts.Metrics.(LockMetrics); ok {
+		m.LockWaited(write, time.Since(start))
+	}
+}
+
+// builtinMetrics receives the measurements of every file when set (see the tridb_expvar build tag in expvar.go).
+var builtinMetrics Metrics
+
+// teeMetrics reports measurements to several metrics.
+type teeMetrics []Metrics
+
+func (t teeMetrics) Written(rows, bytes int) {
+	for _, m := range t {
+		m.Written(rows, bytes)
+	}
+}
+
+func (t teeMetrics) Synced(d time.Duration) {
+	for _, m := range t {
+		m.Synced(d)
+	}
+}
+
+func (t teeMetrics) Read(d time.Duration) {
+	for _, m := range t {
+		m.Read(d)
+	}
+}
+
+func (t teeMetrics) Compacted(d time.Duration, before, after int) {
+	for _, m := range t {
+		m.Compacted(d, before, after)
+	}
+}
+
+func (t teeMetrics) LockWaited(write bool, d time.Duration) {
+	for _, m := range t {
+		if lm, ok := m.(LockMetrics); ok {
+			lm.LockWaited(write, d)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package tridb
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMetrics records the measurements reported by a file.
+type testMetrics struct {
+	mu                                 sync.Mutex
+	rows, bytes, syncs, reads, compact int
+	compactedSize                      int
+}
+
+func (m *testMetrics) Written(rows, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rows, m.bytes = m.rows+rows, m.bytes+bytes
+}
+
+func (m *testMetrics) Synced(d time.Duration) { m.mu.Lock(); m.syncs++; m.mu.Unlock() }
+func (m *testMetrics) Read(d time.Duration)   { m.mu.Lock(); m.reads++; m.mu.Unlock() }
+
+func (m *testMetrics) Compacted(d time.Duration, before, after int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.compact, m.compactedSize = m.compact+1, after
+}
+
+func TestMetrics(t *testing.T) {
+	m := &testMetrics{}
+	f := openTestFile(t, WithMetrics(m))
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "a", "2")
+	b := f.Batch()
+	b.Set([]byte("b"), []byte("1"))
+	b.Delete([]byte("c"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Read(func(r *Reader) error { return nil })
+	if m.rows != 4 || m.bytes != f.Stats().FileSize-f.headerSize || m.syncs != 3 || m.reads != 1 {
+		t.Fatalf("got metrics %+v", m)
+	}
+
+	assertDeadBytes := func() {
+		t.Helper()
+		report, err := f.Verify(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Stats().DeadBytes; got != report.DeadBytes {
+			t.Fatalf("got %d dead bytes instead of %d", got, report.DeadBytes)
+		}
+	}
+	assertDeadBytes()
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertDeadBytes()
+	if m.compact != 1 || m.compactedSize != f.Stats().FileSize {
+		t.Fatalf("got metrics %+v", m)
+	}
+}
@@ -0,0 +1,76 @@
+package tridb
+
+import "io"
+
+// minMappingSize is the minimum size of the memory mapping of the file (see WithMmapReads).
+const minMappingSize = 1 << 20
+
+// mapping is a read-only memory mapping of the file (see WithMmapReads).
+//
+// The mapping is larger than the file so that appended rows can be read without remapping,
+// only the first size bytes (written rows) are read from it, other reads fall back to the file.
+type mapping struct {
+	data []byte
+	size int
+	file Storage // mapped storage (reads fall back to it)
+}
+
+// ReadAt implements io.ReaderAt.
+func (m *mapping) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(m.size) {
+		return m.file.ReadAt(p, off)
+	}
+	return copy(p, m.data[off:]), nil
+}
+
+// slice returns the mapped bytes at the given position without copying them, it reports false if they are not mapped.
+func (m *mapping) slice(off, size int) ([]byte, bool) {
+	if off < 0 || off+size > m.size {
+		return nil, false
+	}
+	return m.data[off : off+size : off+size], true
+}
+
+// readerAt returns where the rows of the file are read from: the memory mapping if any, the read handle otherwise.
+// It must be called with the lock held.
+func (f *File) readerAt() io.ReaderAt {
+	if f.mapping != nil {
+		return f.mapping
+	}
+	return f.store
+}
+
+// updateMapping maps the written rows after they were appended or the file was replaced (see WithMmapReads),
+// a larger mapping is created when the file outgrows the current one.
+// If the file can't be mapped (ex: on platforms without mmap), rows are read from the read handle.
+// It must be called with the write lock held.
+func (f *File) updateMapping() {
+	if !f.opts.MmapReads {
+		return
+	}
+	m := f.mapping
+	if m != nil && m.file == f.store && f.woffset <= len(m.data) {
+		m.size = f.woffset
+		return
+	}
+	f.unmap()
+	size := minMappingSize
+	for size < 2*f.woffset {
+		size *= 2
+	}
+	fs, ok := f.store.(*fileStorage)
+	if !ok {
+		return // only files can be mapped
+	}
+	if data, err := mmapFile(fs.r, size); err == nil {
+		f.mapping = &mapping{data: data, size: f.woffset, file: f.store}
+	}
+}
+
+// unmap removes the memory mapping of the file (if any).
+func (f *File) unmap() {
+	if f.mapping != nil {
+		munmap(f.mapping.data)
+		f.mapping = nil
+	}
+}
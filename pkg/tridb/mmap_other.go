@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// mmapFile always fails: memory mappings are not supported on this platform (rows are read from the file).
+func mmapFile(fh *os.File, size int) ([]byte, error) {
+	return nil, fmt.Errorf("mmap: %w", errors.ErrUnsupported)
+}
+
+func munmap(data []byte) error { return nil }
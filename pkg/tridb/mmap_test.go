@@ -0,0 +1,68 @@
+package tridb
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func TestMmapReads(t *testing.T) {
+	f := openTestFile(t, WithMmapReads(true))
+	if runtime.GOOS == "linux" && f.mapping == nil {
+		t.Fatal("file not mapped")
+	}
+	value := bytes.Repeat([]byte("v"), 1024)
+	set := func(from, to int) {
+		t.Helper()
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			for i := from; i < to; i++ {
+				w.Set([]byte(fmt.Sprintf("key%04d", i)), value)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(n int) {
+		t.Helper()
+		err := f.Read(func(r *Reader) error {
+			for i := 0; i < n; i++ {
+				got, err := r.Get([]byte(fmt.Sprintf("key%04d", i)))
+				if err != nil || !bytes.Equal(got, value) {
+					t.Fatalf("got %d bytes (%v) for key %d", len(got), err, i)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The mapping grows with the file
+	set(0, 10)
+	check(10)
+	set(10, 3000)
+	if f.mapping != nil && len(f.mapping.data) < f.woffset {
+		t.Fatalf("got mapping of %d bytes for file of %d bytes", len(f.mapping.data), f.woffset)
+	}
+	check(3000)
+
+	// The mapping follows compactions
+	set(0, 3000)
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if f.mapping != nil && f.mapping.file != f.store {
+		t.Fatal("compacted file not mapped")
+	}
+	check(3000)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f.mapping != nil {
+		t.Fatal("file still mapped after close")
+	}
+}
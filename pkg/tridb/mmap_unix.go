@@ -0,0 +1,21 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package tridb
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mmapFile maps size bytes of the file in memory (read-only, shared with the writes to the file).
+// Mapped bytes past the end of the file must not be accessed until the file grows.
+func mmapFile(fh *os.File, size int) ([]byte, error) {
+	data, err := syscall.Mmap(int(fh.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+	return data, nil
+}
+
+func munmap(data []byte) error { return syscall.Munmap(data) }
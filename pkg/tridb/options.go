@@ -0,0 +1,531 @@
+package tridb
+
+import (
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Option configures how a database file is opened.
+type Option func(*Options)
+
+// Options holds the configuration of a database file.
+type Options struct {
+	// NumBuckets is the number of buckets of the hash keydir (defaults to DefaultNumBuckets).
+	NumBuckets int
+	// Sync defines when writes are synced to disk (defaults to SyncAlways).
+	Sync SyncMode
+	// SyncInterval is the period of background syncs with SyncInterval (defaults to DefaultSyncInterval)
+	// or the maximum latency added to commits with SyncGroup (defaults to DefaultGroupCommitLatency).
+	SyncInterval time.Duration
+	// ReadOnly opens the file without a write handle, writes fail with ErrReadOnly.
+	ReadOnly bool
+	// DisableLocking disables the lock preventing other processes from writing to the file (see WithLocking).
+	DisableLocking bool
+	// LockTimeout is how long opening a file for writing waits for the lock held by another process (see WithLockTimeout).
+	LockTimeout time.Duration
+	// Keydir selects the in-memory index implementation (defaults to KeydirHash).
+	Keydir KeydirType
+	// KeyHash hashes keys in the hash keydir (defaults to fidx.HashFNV1a, see WithKeyHash).
+	KeyHash fidx.HashFunc
+	// Format is the row format of new files (defaults to BinaryEncoding), existing files use their detected format.
+	Format Format
+	// UpgradeFormat is the row format existing files are rewritten in by compactions (see WithFormatUpgrade).
+	UpgradeFormat Format
+
+	// ReadTransform is applied to values returned by reads (can be used for lazy migrations).
+	ReadTransform func(key, value []byte) ([]byte, error)
+	// TransformOnCompact makes compaction persist the transformed values.
+	TransformOnCompact bool
+	// CompactionFilter drops or rewrites key-value pairs during compactions (see WithCompactionFilter).
+	CompactionFilter CompactionFilter
+	// RowChecksums makes new rows carry a checksum of their key and value, verified on every read.
+	RowChecksums bool
+	// ParanoidChecks makes reads verify that the row found in the file matches the keydir.
+	ParanoidChecks bool
+	// MaxReadDuration is the duration after which read-only transactions switch to a snapshot.
+	MaxReadDuration time.Duration
+	// LongTransactionHandler is called when a read-write transaction holds the write lock longer than
+	// MaxTransactionDuration, which aborts it if AbortLongTransactions is set (see WithMaxTransactionDuration).
+	LongTransactionHandler func(tx LongTransaction)
+	MaxTransactionDuration time.Duration
+	AbortLongTransactions  bool
+	// SkipUnchangedWrites skips writing rows that set a key to its current value.
+	SkipUnchangedWrites bool
+	// DedupThreshold is the minimum length of deduplicated values (0 disables deduplication, see WithValueDedup).
+	DedupThreshold int
+	// CoalesceWrites only writes the last row of each key written by a transaction (see WithCoalescedWrites).
+	CoalesceWrites bool
+	// DisableTimestamps disables recording the write time in rows.
+	DisableTimestamps bool
+	// HistoryRetention is how long compactions keep overwritten and deleted rows (see WithHistoryRetention).
+	HistoryRetention time.Duration
+	// KeepVersions is the number of rows compactions keep for each key (see WithKeepVersions).
+	KeepVersions int
+	// MaxKeyLength is the maximum length of written keys (defaults to MaxKeyLength, see WithMaxKeyLength).
+	MaxKeyLength int
+	// MaxValueLength is the maximum length of written values (defaults to MaxValueLength, see WithMaxValueLength).
+	MaxValueLength int
+	// MaxFileSize is the size above which commits are rejected with ErrDatabaseFull (0 means no limit, see WithMaxFileSize).
+	MaxFileSize int
+	// PreCommitHook is called before committing a read-write transaction, returning an error aborts it.
+	PreCommitHook func(w *Writer) error
+	// KeySecret makes the keydir hold HMACs of keys instead of the keys (see WithHashedKeys).
+	KeySecret []byte
+	// Storage opens the storage of the rows of the file (defaults to OpenFileStorage, see WithStorage).
+	Storage StorageOpener
+	// ReadBufferSize is the size of the buffer used to scan rows, for example when replaying the file on open
+	// (defaults to DefaultBufferSize, see WithBufferSizes).
+	ReadBufferSize int
+	// WriteBufferSize is the size of the buffer used to write compacted files and clones
+	// (defaults to DefaultBufferSize, see WithBufferSizes).
+	WriteBufferSize int
+	// KeyCollation normalizes keys in the keydir (see WithKeyCollation).
+	KeyCollation func(key []byte) []byte
+	// RecoveryHook decides what to do with torn bytes found at the end of the file on open.
+	RecoveryHook func(offset int, partial []byte) RecoveryAction
+	// CompactTombstones encodes deletes without value length in the binary format (see WithCompactTombstones).
+	CompactTombstones bool
+	// RepairCorruptTail handles rows that can't be decoded at the end of the file like torn bytes (see WithCorruptTailRepair).
+	RepairCorruptTail bool
+	// Recorder receives every committed transaction (see WithRecorder).
+	Recorder io.Writer
+	// Compression is the codec of compressed values (see WithCompression).
+	Compression Compression
+	// CompressionThreshold is the minimum size of compressed values (defaults to DefaultCompressionThreshold).
+	CompressionThreshold int
+	// EncryptionKey encrypts rows at rest (see WithEncryption).
+	EncryptionKey []byte
+	// TailInterval is the period at which read-only files load the rows appended by the writer (see WithTail).
+	TailInterval time.Duration
+	// SweepInterval is the period at which expired keys are deleted in the background (see WithExpirySweeper).
+	SweepInterval time.Duration
+	// MasterKey wraps the data keys of encrypted prefixes (see WithPrefixEncryption).
+	MasterKey []byte
+	// Metrics receives measurements of the file operations (see WithMetrics).
+	Metrics Metrics
+	// SlowSyncHandler is called when a sync takes longer than SlowSyncThreshold (see WithSlowSyncHandler).
+	SlowSyncHandler   func(d time.Duration)
+	SlowSyncThreshold time.Duration
+	// MmapReads reads rows from a memory mapping of the file (see WithMmapReads).
+	MmapReads bool
+	// KeydirSnapshot loads the keydir from its snapshot on open (see WithKeydirSnapshot).
+	KeydirSnapshot bool
+	// CustomOps holds the handlers of the custom ops of the file, by op (see WithCustomOp).
+	CustomOps map[byte]OpHandler
+	// SkipUnknownOps skips the rows of custom ops without handler instead of failing (see WithUnknownOpsSkipped).
+	SkipUnknownOps bool
+	// ReplayWorkers is the number of goroutines decoding the rows of the file when it is opened (see WithParallelReplay).
+	ReplayWorkers int
+	// MaintenanceRate is the maximum number of bytes per second written by compactions and backups (see WithMaintenanceRateLimit).
+	MaintenanceRate int
+	// ArchivePath is the file compactions append the rows they drop to (see WithCompactionArchive).
+	ArchivePath string
+}
+
+// WithReadTransform sets a function applied to every value before it is returned to the caller,
+// values written in an old application format can thus be upgraded lazily.
+func WithReadTransform(transform func(key, value []byte) ([]byte, error)) Option {
+	return func(o *Options) { o.ReadTransform = transform }
+}
+
+// WithTransformOnCompact makes the next compactions rewrite rows with the transformed values
+// (see WithReadTransform), so that the migration is eventually persisted.
+func WithTransformOnCompact(enabled bool) Option {
+	return func(o *Options) { o.TransformOnCompact = enabled }
+}
+
+// WithCompactionFilter sets a filter consulted for each key-value pair rewritten by compactions (and clones),
+// so that stale records (ex: expired sessions, soft-deleted users) are dropped or rewritten without a transaction.
+// Dropped keys disappear once the compaction completes, without delete row nor notification (see Watch).
+//
+// Only the latest value of keys is filtered: neither aliases, reserved keys nor rows committed during the compaction,
+// nor versions kept by WithKeepVersions.
+func WithCompactionFilter(filter CompactionFilter) Option {
+	return func(o *Options) { o.CompactionFilter = filter }
+}
+
+// WithRowChecksums makes new rows carry a CRC-32C of their key and value (6 more bytes per row),
+// verified when rows are read (an error wrapping ErrChecksumMismatch is returned on mismatch)
+// and by the scrubber (see File.StartScrubber). Existing rows without checksum remain readable.
+func WithRowChecksums(enabled bool) Option {
+	return func(o *Options) { o.RowChecksums = enabled }
+}
+
+// WithParanoidChecks makes every read verify that the decoded row matches the keydir
+// (same key, not a delete row and same encoded size), an error wrapping ErrIndexMismatch
+// is returned otherwise. It helps catching keydir bugs early at the cost of a few comparisons per read.
+func WithParanoidChecks(enabled bool) Option {
+	return func(o *Options) { o.ParanoidChecks = enabled }
+}
+
+// WithPreCommitHook sets a function called before committing each read-write transaction
+// (after the callback succeeded). Returning an error aborts the transaction,
+// it can be used to enforce size policies with Writer.PendingRows and Writer.PendingBytes.
+func WithPreCommitHook(hook func(w *Writer) error) Option {
+	return func(o *Options) { o.PreCommitHook = hook }
+}
+
+// WithMaxReadDuration limits how long a read-only transaction can block writers.
+// Once the duration is exceeded, the transaction copies the keydir to a private snapshot
+// and releases the lock, it then keeps reading the state of the database as it was at that time.
+//
+// The switch happens on the next reader method call (including between two keys of a walk),
+// callbacks that don't use the reader keep holding the lock.
+func WithMaxReadDuration(d time.Duration) Option {
+	return func(o *Options) { o.MaxReadDuration = d }
+}
+
+// WithMaxTransactionDuration reports the read-write transactions holding the write lock (and thus blocking
+// all readers and writers) longer than d, with the stack of their caller, to handler (or to the standard logger if nil).
+// The handler is called in its own goroutine while the transaction is still running, it must not use the file.
+//
+// If abort is set, the context of the transaction is also canceled: the readers of the transaction return
+// an error and the transaction is aborted with ErrTransactionTooLong instead of being committed.
+func WithMaxTransactionDuration(d time.Duration, abort bool, handler func(tx LongTransaction)) Option {
+	return func(o *Options) {
+		o.MaxTransactionDuration, o.AbortLongTransactions, o.LongTransactionHandler = d, abort, handler
+	}
+}
+
+// WithTimestamps enables (default) or disables recording the write time of each row.
+// Rows without timestamps are smaller (by 12 bytes) but never expire with prefix TTL policies.
+func WithTimestamps(enabled bool) Option {
+	return func(o *Options) { o.DisableTimestamps = !enabled }
+}
+
+// WithHistoryRetention makes compactions keep the rows written during the given duration before the compaction
+// (including overwritten and deleted ones), so the state of the database can be read as of any time within it (see Reader.At).
+// Older history is collapsed into the state at the horizon. It requires timestamps (see WithTimestamps).
+func WithHistoryRetention(d time.Duration) Option {
+	return func(o *Options) { o.HistoryRetention = d }
+}
+
+// WithKeepVersions makes compactions keep the last n rows of each key (including overwritten values and deletes),
+// instead of its latest row only, so they remain available with Reader.History.
+// Keys that expired keep no rows. Counting versions holds all keys in memory during compactions.
+func WithKeepVersions(n int) Option {
+	return func(o *Options) { o.KeepVersions = n }
+}
+
+// WithMaxKeyLength limits the length of written keys (up to MaxKeyLength, the default),
+// writing a longer key aborts the transaction with ErrInvalidKey (wrapping ErrKeyTooLong).
+// Keys longer than 255 bytes can't be read by versions that limited keys to 255 bytes.
+func WithMaxKeyLength(n int) Option {
+	return func(o *Options) { o.MaxKeyLength = n }
+}
+
+// WithMaxValueLength limits the length of written values (up to MaxValueLength, the default),
+// writing a longer value aborts the transaction with ErrValueTooLong.
+func WithMaxValueLength(n int) Option {
+	return func(o *Options) { o.MaxValueLength = n }
+}
+
+// WithMaxFileSize rejects the commits that would grow the file above the given size (in bytes) with ErrDatabaseFull,
+// instead of filling the disk. The size of a commit is estimated from its staged rows (see Writer.PendingBytes).
+// Commits that only delete keys are always accepted, so that space can be reclaimed with a compaction (see Stats.Headroom).
+func WithMaxFileSize(size int) Option {
+	return func(o *Options) { o.MaxFileSize = size }
+}
+
+// WithSkipUnchangedWrites makes commits skip rows setting a key to its current value
+// (ex: idempotent sync jobs constantly rewriting the same values), reducing the file growth.
+// Value hashes are kept in memory and compared first, the stored value is only read on hash matches.
+//
+// Keys under a prefix TTL policy are always rewritten (so that their write time is refreshed).
+func WithSkipUnchangedWrites(enabled bool) Option {
+	return func(o *Options) { o.SkipUnchangedWrites = enabled }
+}
+
+// WithValueDedup makes commits write values of at least threshold bytes only once (ex: content-addressed blobs):
+// rows setting a value identical to one already in the file reference the row holding it instead.
+// References are resolved transparently on reads and kept by compactions.
+//
+// Values are indexed by SHA-256 digest in memory, the index is built from the keydir when the file is opened
+// (reading every row once) and after each compaction. Rows written in batch frames (see File.Batch and Writer.Rename),
+// merge operands, aliases and sealed values (see File.EncryptPrefix) are not deduplicated.
+func WithValueDedup(threshold int) Option {
+	return func(o *Options) { o.DedupThreshold = threshold }
+}
+
+// WithCoalescedWrites makes commits only write the last row of each key written by the transaction
+// (ex: upserts setting the same key repeatedly), in the order of the first write of each key.
+// Merge operands are written along with the rows they apply to.
+//
+// Watchers and recorders (see Watch and WithRecorder) then only see the coalesced rows.
+func WithCoalescedWrites(enabled bool) Option {
+	return func(o *Options) { o.CoalesceWrites = enabled }
+}
+
+// WithRecoveryHook sets a function deciding what to do with the torn bytes found at the end of the file
+// (at the given offset) when opening it. Without hook, torn bytes are truncated.
+//
+// Decisions are recorded in the file (see File.Recoveries), so that discarded bytes never go unnoticed.
+func WithRecoveryHook(hook func(offset int, partial []byte) RecoveryAction) Option {
+	return func(o *Options) { o.RecoveryHook = hook }
+}
+
+// WithCorruptTailRepair makes Open handle the bytes starting at the first row that can't be decoded
+// (for example, a row partially overwritten by a crash) like a torn tail (see WithRecoveryHook),
+// instead of failing. Files without any valid row are never repaired.
+//
+// Note: all the bytes after the first invalid row are dropped, use a recovery hook to abort or quarantine them.
+func WithCorruptTailRepair(enabled bool) Option {
+	return func(o *Options) { o.RepairCorruptTail = enabled }
+}
+
+// WithCompactTombstones encodes deletes as compact tombstones (op, key length and key) in the binary format,
+// saving the 4 bytes of the value length in delete-heavy logs. Both delete encodings are always decoded.
+//
+// Note: files with compact tombstones can't be read by versions of tridb predating them.
+// The option is ignored for text formats and encrypted files (see WithEncryption).
+func WithCompactTombstones(enabled bool) Option {
+	return func(o *Options) { o.CompactTombstones = enabled }
+}
+
+// WithFormat sets the row format used when creating a new file.
+// The format of existing files is read from their header when opening them, opening a file written
+// with another format fails with ErrBadFileFormat (unless it is upgraded, see WithFormatUpgrade).
+// The format of files predating the header is detected (see DetectFormat),
+// the configured format is then only used if the content could be decoded with several formats.
+func WithFormat(format Format) Option {
+	return func(o *Options) { o.Format = format }
+}
+
+// WithFormatUpgrade makes compactions rewrite existing files in the given row format (if they use another one),
+// so that files are upgraded as part of routine maintenance instead of an offline migration.
+// Compactions upgrading the format don't resume after an interruption (see File.Compact).
+// Files encrypted at rest (see WithEncryption) keep their format.
+//
+// Note: snapshots (see File.Snapshot) and detached readers (see WithMaxReadDuration) that outlive
+// the upgrading compaction can't decode the rows of the replaced file.
+func WithFormatUpgrade(format Format) Option {
+	return func(o *Options) { o.UpgradeFormat = format }
+}
+
+// WithHashedKeys makes the keydir hold the HMAC-SHA256 of keys (with the given secret) instead of the keys,
+// so that a memory dump doesn't reveal key material (keys remain in plain text in the file).
+//
+// Point lookups hash the requested key and verify the key of the row read from the file
+// (a hash collision is reported as an error wrapping ErrIndexMismatch).
+// Walks return ErrHashedKeys, RowReader.Key reads the key from the file
+// and prefix TTL policies don't apply to user keys.
+func WithHashedKeys(secret []byte) Option {
+	return func(o *Options) { o.KeySecret = secret }
+}
+
+// WithStorage makes the file keep its rows in the storages opened with the given function
+// (for example NewMemoryStorage, or a wrapper of OpenFileStorage to instrument it),
+// the storage of compactions is opened at the path of the file with CompactingFileExtension.
+//
+// Note: the other files (lock, clean shutdown marker, keydir snapshot, keyring...) remain on the local file system
+// next to the path of the file and memory mappings (see WithMmapReads) are only available for local files.
+func WithStorage(open StorageOpener) Option {
+	return func(o *Options) { o.Storage = open }
+}
+
+// WithBufferSizes sets the size of the buffers used to scan rows (when replaying the file on open,
+// compacting, verifying...) and to write compacted files and clones (0 keeps DefaultBufferSize).
+// Larger buffers make fewer system calls, which speeds up the replay of large files on fast disks.
+func WithBufferSizes(read, write int) Option {
+	return func(o *Options) { o.ReadBufferSize, o.WriteBufferSize = read, write }
+}
+
+// WithKeyCollation makes the keydir index keys normalized with the given function (for example CaseFolding),
+// so that keys with the same normalized form are the same key for lookups, writes and walk bounds.
+// Rows keep the key bytes as written: Walk and RowReader.Key return them (reading each key from the file)
+// and walks visit keys in the order of their normalized form.
+//
+// The function must be deterministic, idempotent and preserve prefixes, it must not change between opens.
+func WithKeyCollation(collate func(key []byte) []byte) Option {
+	return func(o *Options) { o.KeyCollation = collate }
+}
+
+// WithSync sets when writes are synced to disk, the interval is only used with SyncInterval
+// (period of background syncs) and SyncGroup (maximum latency added to commits).
+// SyncInterval and SyncNever trade durability (of the last commits before a crash) for write throughput,
+// SyncGroup keeps commits durable but syncs concurrent commits together.
+func WithSync(mode SyncMode, interval time.Duration) Option {
+	return func(o *Options) { o.Sync, o.SyncInterval = mode, interval }
+}
+
+// WithReadOnly opens the file in read-only mode: the file must exist,
+// torn tails are ignored (not truncated) and writes fail with ErrReadOnly.
+func WithReadOnly() Option {
+	return func(o *Options) { o.ReadOnly = true }
+}
+
+// KeydirType identifies an in-memory index implementation.
+type KeydirType string
+
+// Available keydir implementations.
+const (
+	KeydirHash KeydirType = "hash" // Linked hash table, fast point lookups but walks need to sort keys.
+	KeydirTrie KeydirType = "trie" // Radix trie (binary-safe), keys are kept in lexicographical order.
+	// Linked hash table building tries for the prefixes that are walked (see fidx.AdaptiveIndex),
+	// for workloads mostly made of point reads and writes with a few ordered walks.
+	KeydirAdaptive KeydirType = "adaptive"
+)
+
+// WithRecorder records every committed transaction (rows with their timestamps) to w,
+// so that the exact sequence of writes can be re-applied to a fresh file with File.ApplyRecording.
+// If recording fails, the transaction is committed anyway and an error wrapping ErrRecording is returned.
+func WithRecorder(w io.Writer) Option {
+	return func(o *Options) { o.Recorder = w }
+}
+
+// WithPrefixEncryption enables per-prefix value encryption (see File.EncryptPrefix and File.Shred),
+// the 32-byte master key wraps the data keys stored in the keyring file (see KeyringFileExtension).
+// Keys, timestamps and other row attributes are not encrypted.
+func WithPrefixEncryption(masterKey []byte) Option {
+	return func(o *Options) { o.MasterKey = masterKey }
+}
+
+// WithCompression makes new rows hold values compressed with the given codec when they are at least threshold bytes long
+// (DefaultCompressionThreshold if threshold isn't positive) and compression makes them smaller.
+// Values are decompressed transparently on reads, existing rows are compressed on compaction.
+// Values in the reserved keyspace (including buckets) are not compressed.
+func WithCompression(codec Compression, threshold int) Option {
+	return func(o *Options) { o.Compression, o.CompressionThreshold = codec, threshold }
+}
+
+// WithEncryption encrypts new rows at rest with AES-GCM and the given 16, 24 or 32-byte key
+// (with a random nonce per row), rows are decrypted when they are read from the file.
+// Encrypted files always use the binary format, existing rows are encrypted on compaction.
+//
+// Compaction and backups copy encrypted rows as is, without decrypting them
+// (unless they need to be rewritten, ex: with WithTransformOnCompact).
+func WithEncryption(key []byte) Option {
+	return func(o *Options) { o.EncryptionKey = key }
+}
+
+// WithLocking enables (default) or disables the lock taken on files opened for writing (see LockFileExtension).
+// Without lock, nothing prevents two processes from writing to the file (and corrupting it).
+func WithLocking(enabled bool) Option {
+	return func(o *Options) { o.DisableLocking = !enabled }
+}
+
+// WithLockTimeout makes opening a file for writing wait up to the given duration
+// for the lock held by another process, instead of failing right away with ErrDatabaseLocked.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *Options) { o.LockTimeout = timeout }
+}
+
+// WithTail makes a read-only file load the rows appended by another process every interval (see File.Refresh).
+func WithTail(interval time.Duration) Option {
+	return func(o *Options) { o.TailInterval = interval }
+}
+
+// WithExpirySweeper deletes the expired keys every interval in the background (see File.SweepExpired),
+// so that their keydir entries are freed before the next compaction. Failed sweeps are retried on the next tick.
+func WithExpirySweeper(interval time.Duration) Option {
+	return func(o *Options) { o.SweepInterval = interval }
+}
+
+// WithKeydir selects the in-memory index implementation.
+func WithKeydir(keydir KeydirType) Option {
+	return func(o *Options) { o.Keydir = keydir }
+}
+
+// WithKeyHash sets the hash function of the hash keydir,
+// use fidx.NewSeededHash when keys are chosen by untrusted users (see fidx.NewLHTIndexWithHash).
+func WithKeyHash(hash fidx.HashFunc) Option {
+	return func(o *Options) { o.KeyHash = hash }
+}
+
+func (f *File) newKeydir() fidx.Keydir {
+	if f.opts.Keydir == KeydirTrie {
+		return fidx.NewTrieIndex()
+	}
+	hash := f.opts.KeyHash
+	if hash == nil {
+		hash = fidx.HashFNV1a
+	}
+	if f.opts.Keydir == KeydirAdaptive {
+		return fidx.NewAdaptiveIndex(fidx.NewLHTIndexWithHash(f.numBuckets, hash))
+	}
+	return fidx.NewLHTIndexWithHash(f.numBuckets, hash)
+}
+
+// WithMetrics reports measurements of the file operations (commits, syncs, reads and compactions) to m.
+func WithMetrics(m Metrics) Option {
+	return func(o *Options) { o.Metrics = m }
+}
+
+// WithSlowSyncHandler calls handler when a sync to disk takes longer than threshold
+// (DefaultSlowSyncThreshold if threshold isn't positive), so that failing disks or saturated volumes are noticed early.
+// It is called once per slow sync, as soon as the threshold is exceeded (in its own goroutine)
+// with the time elapsed so far, so that stalled writes are reported even if the sync never returns.
+func WithSlowSyncHandler(threshold time.Duration, handler func(d time.Duration)) Option {
+	return func(o *Options) { o.SlowSyncThreshold, o.SlowSyncHandler = threshold, handler }
+}
+
+// WithMmapReads reads rows from a read-only memory mapping of the file instead of a read syscall per row,
+// which speeds up read-heavy workloads on large files. The mapping grows with the file and follows compactions.
+// Rows are read from the file on platforms without memory mappings (or if the file can't be mapped).
+//
+// Note: reading rows truncated by another process (ex: a writer process recovering from a failed write,
+// see WithReadOnly) crashes the process instead of returning an error.
+func WithMmapReads(enabled bool) Option {
+	return func(o *Options) { o.MmapReads = enabled }
+}
+
+// WithKeydirSnapshot writes a snapshot of the keydir next to the file (see KeydirSnapshotFileExtension)
+// on Close and after each compaction (or with File.WriteKeydirSnapshot), so that Open loads the keydir
+// and only replays the rows appended since, even after a crash. Stale or corrupt snapshots are ignored.
+// Files with hashed keys (see WithHashedKeys) or encrypted at rest (see WithEncryption) don't use snapshots.
+func WithKeydirSnapshot(enabled bool) Option {
+	return func(o *Options) { o.KeydirSnapshot = enabled }
+}
+
+// WithCustomOp registers the handler of a custom op (at least MinCustomOp), so that higher-level features
+// can persist their own operations in the file (see Writer.AppendOp). The rows of the op are applied
+// to the handler when the file is opened, which then always replays the whole file (see CleanShutdownFileExtension).
+//
+// Opening a file holding rows of an op without handler fails with ErrUnknownOp (see WithUnknownOpsSkipped).
+func WithCustomOp(op byte, handler OpHandler) Option {
+	return func(o *Options) {
+		if o.CustomOps == nil {
+			o.CustomOps = map[byte]OpHandler{}
+		}
+		o.CustomOps[op] = handler
+	}
+}
+
+// WithUnknownOpsSkipped skips the rows of custom ops without handler (see WithCustomOp) when opening the file,
+// instead of failing with ErrUnknownOp. Note: the rows of such ops are dropped by compactions.
+func WithUnknownOpsSkipped() Option {
+	return func(o *Options) { o.SkipUnknownOps = true }
+}
+
+// WithParallelReplay decodes the rows of the file across the given number of goroutines when it is opened
+// (GOMAXPROCS if workers <= 0), for faster startup of large files. The rows are still applied to the keydir
+// in file order, so the outcome is the same as with a sequential replay.
+// Only files in a binary format (see BinaryEncoding and WithEncryption) are replayed in parallel.
+func WithParallelReplay(workers int) Option {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return func(o *Options) { o.ReplayWorkers = workers }
+}
+
+// WithMaintenanceRateLimit limits the number of bytes per second written by compactions (see File.Compact)
+// and copied by backups (see File.CopyTo, File.BackupSince and Snapshot.CopyTo), so that maintenance operations
+// don't saturate the disk on live systems (0 means unlimited).
+// Compactions aren't throttled while writers are blocked (to copy the last committed rows).
+func WithMaintenanceRateLimit(bytesPerSecond int) Option {
+	return func(o *Options) { o.MaintenanceRate = bytesPerSecond }
+}
+
+// WithCompactionArchive makes compactions append the rows they drop (overwritten values, deletes,
+// expired and filtered keys) to the datafile at the given path, instead of discarding them,
+// so that compactions reclaim space without destroying history (for example, for compliance).
+// The archive is written in the format of the file: it can be opened (read-only) to inspect its rows (see Reader.History).
+//
+// Note: the rows kept by WithKeepVersions and WithHistoryRetention, and the rows overwritten during the compaction,
+// are archived as well.
+func WithCompactionArchive(path string) Option {
+	return func(o *Options) { o.ArchivePath = path }
+}
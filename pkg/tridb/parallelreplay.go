@@ -0,0 +1,214 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// replayChunkSize is the number of bytes of rows decoded at once by the workers of a parallel replay (see WithParallelReplay).
+const replayChunkSize = 4 << 20
+
+// replayChunk holds consecutive rows of the file decoded by a worker of a parallel replay.
+type replayChunk struct {
+	offset  int
+	data    []byte
+	entries []replayEntry
+	end     int // offset after the last decoded row (before the end of data if a row couldn't be decoded)
+	numRows int
+	done    chan struct{}
+}
+
+// replayEntry is a decoded row, or the row of a custom op (if op isn't zero).
+type replayEntry struct {
+	row     Row
+	p       fidx.Position
+	op      byte
+	payload []byte
+}
+
+// replayWithOps is like replay (from the given offset to the end of the file) but also applies the rows of custom ops.
+// Files in a binary format are decoded by several goroutines if enabled (see WithParallelReplay).
+func (f *File) replayWithOps(ra io.ReaderAt, offset int, idx, sys fidx.Keydir) (int, int, error) {
+	numRows := 0
+	if f.opts.ReplayWorkers > 1 && isBinaryFormat(f.format) {
+		var err error
+		offset, numRows, err = f.replayParallel(ra, offset, idx, sys)
+		if err != nil {
+			return offset, numRows, err
+		}
+	}
+
+	// Replay the rest of the file (from the first row the parallel replay couldn't delimit, if any)
+	r := bufio.NewReaderSize(io.NewSectionReader(ra, int64(offset), math.MaxInt64-int64(offset)), f.opts.ReadBufferSize)
+	end, n, err := f.scanFrames(r, offset, -1, func(row *Row, p fidx.Position) bool { f.applyRow(row, p, idx, sys); return true }, f.applyOp)
+	return end, numRows + n, err
+}
+
+// replayParallel decodes the rows of the file in chunks across workers and applies them in file order.
+// It reports the offset after the last applied row, which is before the end of the file
+// if a row can't be delimited (torn or corrupt rows are left to a sequential replay).
+func (f *File) replayParallel(ra io.ReaderAt, offset int, idx, sys fidx.Keydir) (int, int, error) {
+	workers := f.opts.ReplayWorkers
+	ordered, jobs, stop := make(chan *replayChunk, 2*workers), make(chan *replayChunk), make(chan struct{})
+	defer close(stop)
+	go f.chunkRows(ra, offset, ordered, jobs, stop)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for c := range jobs {
+				f.decodeChunk(c)
+			}
+		}()
+	}
+
+	numRows := 0
+	for c := range ordered {
+		<-c.done
+		for i := range c.entries {
+			e := &c.entries[i]
+			if e.op == 0 {
+				f.applyRow(&e.row, e.p, idx, sys)
+			} else if err := f.applyOp(e.op, e.payload); err != nil {
+				return c.offset, numRows, fmt.Errorf("apply op in rows from offset %d: %w", c.offset, err)
+			}
+		}
+		offset, numRows = c.end, numRows+c.numRows
+		if c.end != c.offset+len(c.data) {
+			break // the rest of the chunk is replayed sequentially
+		}
+	}
+	return offset, numRows, nil
+}
+
+// chunkRows reads the rows of the file from the given offset and sends them in chunks to the workers (jobs)
+// and to the applier (ordered), until the end of the file or the first row that can't be delimited.
+func (f *File) chunkRows(ra io.ReaderAt, offset int, ordered, jobs chan<- *replayChunk, stop <-chan struct{}) {
+	defer close(ordered)
+	defer close(jobs)
+	r := bufio.NewReaderSize(io.NewSectionReader(ra, int64(offset), math.MaxInt64-int64(offset)), max(f.opts.ReadBufferSize, maxBinaryFramePeek))
+	if offset == 0 {
+		h, ok, err := readFileHeader(r)
+		if err != nil {
+			return
+		}
+		if ok {
+			offset = h.size()
+		}
+	}
+	c := &replayChunk{offset: offset, done: make(chan struct{})}
+	send := func() bool {
+		select {
+		case ordered <- c:
+		case <-stop:
+			return false
+		}
+		select {
+		case jobs <- c:
+		case <-stop:
+			return false
+		}
+		c = &replayChunk{offset: c.offset + len(c.data), done: make(chan struct{})}
+		return true
+	}
+	for {
+		size, err := binaryFrameSize(r)
+		if err != nil {
+			break
+		}
+		frame, _, err := readFull(r, size)
+		if err != nil {
+			break
+		}
+		c.data = append(c.data, frame...)
+		if len(c.data) >= replayChunkSize && !send() {
+			return
+		}
+	}
+	if len(c.data) > 0 {
+		send()
+	}
+}
+
+// decodeChunk decodes the rows of the given chunk.
+func (f *File) decodeChunk(c *replayChunk) {
+	defer close(c.done)
+	r := bufio.NewReaderSize(bytes.NewReader(c.data), f.opts.ReadBufferSize)
+	c.end, c.numRows, _ = f.scanFrames(r, c.offset, -1, func(row *Row, p fidx.Position) bool {
+		c.entries = append(c.entries, replayEntry{row: *row, p: p})
+		return true
+	}, func(op byte, payload []byte) error {
+		c.entries = append(c.entries, replayEntry{op: op, payload: payload})
+		return nil
+	})
+}
+
+// maxBinaryFramePeek is the number of bytes needed to delimit any row of the binary formats (see binaryFrameSize).
+const maxBinaryFramePeek = rowHeaderSize + 2 + math.MaxUint16
+
+// binaryFrameSize returns the size of the row (or frame) at the start of the given reader, in a binary format,
+// without consuming it.
+func binaryFrameSize(r *bufio.Reader) (int, error) {
+	op, err := r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case isCustomOp(op[0]):
+		header, err := r.Peek(customOpHeaderSize)
+		if err != nil {
+			return 0, err
+		}
+		return customOpHeaderSize + int(binary.BigEndian.Uint32(header[1:])), nil
+	case op[0] == opBatch:
+		header, err := r.Peek(batchHeaderSize)
+		if err != nil {
+			return 0, err
+		}
+		return batchHeaderSize + int(binary.BigEndian.Uint32(header[5:])), nil
+	case isEncryptedOp(op[0]):
+		header, err := r.Peek(encryptedHeaderSize)
+		if err != nil {
+			return 0, err
+		}
+		return encryptedHeaderSize + int(binary.BigEndian.Uint32(header[1:])), nil
+	}
+
+	headerSize, valueLength := rowHeaderSize, 0
+	isTombstone, hasAttrs := isTombstoneOp(op[0])
+	if isTombstone {
+		headerSize = tombstoneHeaderSize
+	} else if _, hasAttrs, err = decodeOp(op[0]); err != nil {
+		return 0, err
+	}
+	header, err := r.Peek(headerSize + 2*boolToInt(hasAttrs))
+	if err != nil {
+		return 0, err
+	}
+	keyLength := int(header[1])
+	if !isTombstone {
+		valueLength = int(binary.BigEndian.Uint32(header[2:]))
+	}
+	size := headerSize + keyLength + valueLength
+	if !hasAttrs {
+		return size, nil
+	}
+	attrsLength := int(binary.BigEndian.Uint16(header[headerSize:]))
+	size += 2 + attrsLength
+	if keyLength == 0 { // long keys have their length in an attribute
+		header, err = r.Peek(headerSize + 2 + attrsLength)
+		if err != nil {
+			return 0, err
+		}
+		for attrs := header[headerSize+2:]; len(attrs) >= 2 && len(attrs) >= 2+int(attrs[1]); attrs = attrs[2+int(attrs[1]):] {
+			if attrs[0] == attrKeyLength && attrs[1] == 2 {
+				size += int(binary.BigEndian.Uint16(attrs[2:]))
+			}
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,91 @@
+package tridb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParallelReplay(t *testing.T) {
+	for _, opts := range [][]Option{{WithFormat(BinaryEncoding)}, {WithEncryption(make([]byte, 32))}} {
+		dir := t.TempDir()
+		fpath := filepath.Join(dir, "test.tridb")
+		counter := &counterOp{}
+		f, err := Open(fpath, 1, append(opts, WithCustomOp(opIncrement, counter.handler()))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			mustSet(t, f, "large"+strconv.Itoa(i), strings.Repeat("v", 2<<20)) // rows spanning several chunks
+		}
+		for i := 0; i < 1000; i++ {
+			mustSet(t, f, "key"+strconv.Itoa(i%300), strconv.Itoa(i))
+			if i%100 == 0 {
+				increment(t, f, uint64(i))
+			}
+		}
+		mustSet(t, f, strings.Repeat("k", 300), "long key")
+		b := f.Batch()
+		b.Set([]byte("batched"), []byte("1"))
+		b.Delete([]byte("key1"))
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		err = f.ReadWrite(func(r *Reader, w *Writer) error { w.Delete([]byte("large1")); return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		// Append a torn row
+		fw, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte{opSet, 1, 0})
+		fw.Close()
+		data, err := os.ReadFile(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Open copies of the file with a sequential and a parallel replay
+		open := func(name string, extra ...Option) (*File, *counterOp, string) {
+			t.Helper()
+			p := filepath.Join(dir, name)
+			if err := os.WriteFile(p, data, 0666); err != nil {
+				t.Fatal(err)
+			}
+			c := &counterOp{}
+			f, err := Open(p, 1, append(append(opts, WithCustomOp(opIncrement, c.handler())), extra...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dump := &bytes.Buffer{}
+			if err := f.Dump(dump); err != nil {
+				t.Fatal(err)
+			}
+			return f, c, dump.String()
+		}
+		seq, seqCounter, seqDump := open("sequential.tridb")
+		defer seq.Close()
+		par, parCounter, parDump := open("parallel.tridb", WithParallelReplay(4))
+		defer par.Close()
+		if par.woffset != seq.woffset || par.numRows != seq.numRows || par.liveBytes != seq.liveBytes {
+			t.Fatalf("got offset %d, %d rows and %d live bytes instead of %d, %d and %d",
+				par.woffset, par.numRows, par.liveBytes, seq.woffset, seq.numRows, seq.liveBytes)
+		}
+		if parCounter.total != seqCounter.total || parCounter.total != counter.total {
+			t.Fatalf("got counter %d instead of %d", parCounter.total, seqCounter.total)
+		}
+		if parDump != seqDump {
+			t.Fatal("got different content with a parallel replay")
+		}
+		assertValue(t, par, "batched", "1")
+		assertValue(t, par, "key1", "")
+		assertValue(t, par, strings.Repeat("k", 300), "long key")
+	}
+}
@@ -0,0 +1,386 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// PartitionSeparator separates the segments of keys that determine their partition (see OpenPartitioned).
+const PartitionSeparator = '/'
+
+// Partition files are named after their partition: "part-" + escaped partition name + ".tridb",
+// keys with fewer segments than the partition depth are in the default partition file ("default.tridb").
+const (
+	partitionFilePrefix    = "part-"
+	partitionFileExtension = ".tridb"
+	defaultPartitionName   = "default"
+)
+
+// ErrCrossPartition is returned when a transaction on a partition writes keys of other partitions.
+var ErrCrossPartition = errors.New("key of another partition")
+
+// PartitionedFile is a database split into a directory of files: one per top-level key prefix
+// (the first depth segments of keys separated by PartitionSeparator, ex: "users" for "users/42" with a depth of 1).
+// Each partition is compacted and backed up on its own (see Partition), huge datasets are thus not a single monolithic file.
+//
+// Transactions are limited to a single partition, walks visit all partitions (in lexicographical order)
+// but each partition is read in its own transaction.
+type PartitionedFile struct {
+	dir        string
+	depth      int
+	numBuckets int
+	opts       []Option
+	mu         sync.Mutex
+	partitions map[string]*File // by partition name ("" for the default partition)
+	closed     bool
+}
+
+// OpenPartitioned opens the partitioned database in the given directory (created if needed),
+// partitions are opened with the given number of hash keydir buckets and options.
+func OpenPartitioned(dir string, depth, numBuckets int, opts ...Option) (_ *PartitionedFile, err error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("invalid partition depth: %d", depth)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+	p := &PartitionedFile{dir: dir, depth: depth, numBuckets: numBuckets, opts: opts, partitions: map[string]*File{}}
+	defer func() {
+		if err != nil {
+			p.Close()
+		}
+	}()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+	for _, entry := range entries {
+		name, ok := partitionName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := p.open(name); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// partitionName returns the name of the partition stored in the given file, it reports false for other files.
+func partitionName(fname string) (string, bool) {
+	if fname == defaultPartitionName+partitionFileExtension {
+		return "", true
+	}
+	escaped, ok := strings.CutPrefix(fname, partitionFilePrefix)
+	if !ok || !strings.HasSuffix(escaped, partitionFileExtension) {
+		return "", false
+	}
+	name, err := url.PathUnescape(strings.TrimSuffix(escaped, partitionFileExtension))
+	return name, err == nil && name != ""
+}
+
+// partitionPath returns the path of the file of the given partition.
+func (p *PartitionedFile) partitionPath(name string) string {
+	if name == "" {
+		return filepath.Join(p.dir, defaultPartitionName+partitionFileExtension)
+	}
+	return filepath.Join(p.dir, partitionFilePrefix+url.PathEscape(name)+partitionFileExtension)
+}
+
+// open opens (or creates) the file of the given partition, it must be called with the mutex held.
+func (p *PartitionedFile) open(name string) (*File, error) {
+	if f, ok := p.partitions[name]; ok {
+		return f, nil
+	}
+	f, err := Open(p.partitionPath(name), p.numBuckets, p.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("open partition %q: %w", name, err)
+	}
+	p.partitions[name] = f
+	return f, nil
+}
+
+// PartitionOf returns the name of the partition of the given key: its first depth segments
+// (or "" for the default partition if the key has fewer segments).
+func (p *PartitionedFile) PartitionOf(key []byte) string { return keyPrefix(key, p.depth) }
+
+// keyPrefix returns the first depth segments of the key (or "" if the key has fewer segments).
+func keyPrefix(key []byte, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		j := bytes.IndexByte(key[end:], PartitionSeparator)
+		if j < 0 {
+			return ""
+		}
+		end += j + 1
+	}
+	return string(key[:end-1])
+}
+
+// PrefixStats holds statistics about the keys starting with a given prefix (see Reader.PrefixHistogram).
+type PrefixStats struct {
+	Prefix string // First segments of the keys ("" for keys with fewer segments).
+	Keys   int    // Number of keys.
+	Bytes  int    // Size of the rows holding the current value of the keys.
+}
+
+// PrefixHistogram returns the number of keys and their size by prefix: the first depth segments
+// of keys separated by PartitionSeparator (sorted by prefix), as partitioned by OpenPartitioned.
+// It helps choosing shard boundaries from the actual distribution of keys.
+func (r *Reader) PrefixHistogram(depth int) ([]PrefixStats, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("invalid prefix depth: %d", depth)
+	}
+	byPrefix := map[string]*PrefixStats{}
+	err := r.walkRange(nil, nil, false, func(row *fidx.RowInfo) error {
+		prefix := keyPrefix(row.Key, depth)
+		stats, ok := byPrefix[prefix]
+		if !ok {
+			stats = &PrefixStats{Prefix: prefix}
+			byPrefix[prefix] = stats
+		}
+		stats.Keys++
+		stats.Bytes += row.Position.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	histogram := make([]PrefixStats, 0, len(byPrefix))
+	for _, stats := range byPrefix {
+		histogram = append(histogram, *stats)
+	}
+	sort.Slice(histogram, func(i, j int) bool { return histogram[i].Prefix < histogram[j].Prefix })
+	return histogram, nil
+}
+
+// Partition returns the file of the given partition (created if needed), for example to compact or back it up.
+func (p *PartitionedFile) Partition(name string) (*File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	return p.open(name)
+}
+
+// Partitions returns the names of the existing partitions in lexicographical order of their keys.
+func (p *PartitionedFile) Partitions() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.partitions))
+	for name := range p.partitions {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i]+string(PartitionSeparator) < names[j]+string(PartitionSeparator) })
+	return names
+}
+
+// existing returns the file of the given partition, or nil if it doesn't exist.
+func (p *PartitionedFile) existing(name string) (*File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	return p.partitions[name], nil
+}
+
+// Read executes a read-only transaction on the partition of the given key.
+// The reader doesn't see the keys of other partitions. If the partition doesn't exist, do isn't called.
+func (p *PartitionedFile) Read(key []byte, do func(r *Reader) error) error {
+	f, err := p.existing(p.PartitionOf(key))
+	if err != nil || f == nil {
+		return err
+	}
+	return f.Read(do)
+}
+
+// ReadWrite executes a read-write transaction on the partition of the given key (created if needed),
+// the transaction is aborted with ErrCrossPartition if it writes keys of other partitions.
+func (p *PartitionedFile) ReadWrite(key []byte, do func(r *Reader, w *Writer) error) error {
+	name := p.PartitionOf(key)
+	f, err := p.Partition(name)
+	if err != nil {
+		return err
+	}
+	return f.ReadWrite(func(r *Reader, w *Writer) error {
+		if err := do(r, w); err != nil {
+			return err
+		}
+		for _, row := range w.rows {
+			if !IsReservedKey(row.Key) && p.PartitionOf(row.Key) != name {
+				return fmt.Errorf("%w: %q (partition %q)", ErrCrossPartition, row.Key, name)
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns the value of the given key (nil if not found).
+func (p *PartitionedFile) Get(key []byte) (value []byte, err error) {
+	err = p.Read(key, func(r *Reader) error {
+		value, err = r.Get(key)
+		return err
+	})
+	return value, err
+}
+
+// Set sets the value of the given key.
+func (p *PartitionedFile) Set(key, value []byte) error {
+	return p.ReadWrite(key, func(r *Reader, w *Writer) error {
+		w.Set(key, value)
+		return nil
+	})
+}
+
+// Delete deletes the given key.
+func (p *PartitionedFile) Delete(key []byte) error {
+	if f, err := p.existing(p.PartitionOf(key)); err != nil || f == nil {
+		return err
+	}
+	return p.ReadWrite(key, func(r *Reader, w *Writer) error {
+		w.Delete(key)
+		return nil
+	})
+}
+
+// walkPageSize is the number of keys of the default partition read at once by walks.
+const walkPageSize = 256
+
+// Walk calls do for each key starting with the given prefix and its value, in lexicographical order
+// (see Reader.Walk for errors). Partitions are read in their own transactions, one after the other.
+func (p *PartitionedFile) Walk(prefix []byte, do func(key, value []byte) error) error {
+	// Keys of a partition all start with its name and a separator, and are thus contiguous in lexicographical order.
+	// Keys of the default partition (read by pages) are interleaved between partitions.
+	var partitions []*File
+	var starts [][]byte
+	for _, name := range p.Partitions() {
+		start := []byte(name + string(PartitionSeparator))
+		if name == "" || !(bytes.HasPrefix(start, prefix) || bytes.HasPrefix(prefix, start)) {
+			continue
+		}
+		f, err := p.existing(name)
+		if err != nil {
+			return err
+		}
+		partitions, starts = append(partitions, f), append(starts, start)
+	}
+	defaults, err := p.existing("")
+	if err != nil {
+		return err
+	}
+	broke := false // ErrBreak only stops the walk of the current partition
+	visit := func(key, value []byte) error {
+		err := do(key, value)
+		broke = errors.Is(err, ErrBreak)
+		return err
+	}
+	pages := &walkPages{f: defaults, prefix: prefix}
+	for i, f := range partitions {
+		if err := pages.walkBefore(starts[i], visit); err != nil || broke {
+			return ignoreBreak(err)
+		}
+		err := f.Read(func(r *Reader) error {
+			_, err := r.WalkWithValue(prefix, visit)
+			return err
+		})
+		if err != nil || broke {
+			return err
+		}
+	}
+	return ignoreBreak(pages.walkBefore(nil, visit))
+}
+
+// walkPages walks the keys of a file by pages (each page is read in its own transaction).
+type walkPages struct {
+	f      *File
+	prefix []byte
+	keys   [][]byte
+	values [][]byte
+	after  []byte // last key of the previous page
+	done   bool
+}
+
+// walkBefore calls do for the next keys lower than end (all the remaining keys if end is nil).
+func (w *walkPages) walkBefore(end []byte, do func(key, value []byte) error) error {
+	for {
+		key, err := w.peek()
+		if err != nil || key == nil || (end != nil && bytes.Compare(key, end) >= 0) {
+			return err
+		}
+		if err := do(w.keys[0], w.values[0]); err != nil {
+			return err
+		}
+		w.keys, w.values = w.keys[1:], w.values[1:]
+	}
+}
+
+// peek returns the next key (nil once all keys were walked), reading the next page if needed.
+func (w *walkPages) peek() ([]byte, error) {
+	if w.f == nil {
+		return nil, nil
+	}
+	if len(w.keys) == 0 && !w.done {
+		if err := w.next(); err != nil {
+			return nil, err
+		}
+	}
+	if len(w.keys) == 0 {
+		return nil, nil
+	}
+	return w.keys[0], nil
+}
+
+// next reads the next page.
+func (w *walkPages) next() error {
+	return w.f.Read(func(r *Reader) error {
+		last, err := r.WalkWithOptions(w.prefix, WalkOptions{Limit: walkPageSize, StartAfter: w.after}, func(key []byte) error {
+			value, err := r.Get(key)
+			w.keys, w.values = append(w.keys, key), append(w.values, value)
+			return err
+		})
+		w.after, w.done = last, len(w.keys) < walkPageSize
+		return err
+	})
+}
+
+// Compact compacts every partition (see File.Compact), one after the other.
+func (p *PartitionedFile) Compact() error {
+	for _, name := range p.Partitions() {
+		f, err := p.existing(name)
+		if err != nil {
+			return err
+		}
+		if err := f.Compact(); err != nil {
+			return fmt.Errorf("compact partition %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Close closes every partition, it returns ErrClosed if the file was already closed.
+func (p *PartitionedFile) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	p.closed = true
+	var errs []error
+	for name, f := range p.partitions {
+		if err := f.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close partition %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPartitionedFile(t *testing.T) {
+	dir := t.TempDir()
+	p, err := OpenPartitioned(dir, 1, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"users/1", "users/2", "user", "users", "orders/a/1", "orders/b", "a", "z", "users.old/1"}
+	for _, key := range keys {
+		if err := p.Set([]byte(key), []byte("v:"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := p.Partitions(), []string{"", "orders", "users.old", "users"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got partitions %q instead of %q", got, want)
+	}
+	if got := p.PartitionOf([]byte("orders/a/1")); got != "orders" {
+		t.Fatalf("got partition %q", got)
+	}
+
+	// Walks visit all partitions in lexicographical order
+	walk := func(prefix string) []string {
+		t.Helper()
+		var got []string
+		err := p.Walk([]byte(prefix), func(key, value []byte) error {
+			if string(value) != "v:"+string(key) {
+				t.Fatalf("got value %q for key %q", value, key)
+			}
+			got = append(got, string(key))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	want := append([]string(nil), keys...)
+	sort.Strings(want)
+	if got := walk(""); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got keys %q instead of %q", got, want)
+	}
+	if got := walk("user"); !reflect.DeepEqual(got, []string{"user", "users", "users.old/1", "users/1", "users/2"}) {
+		t.Fatalf("got keys %q", got)
+	}
+	visited := 0
+	_ = p.Walk(nil, func(key, value []byte) error {
+		visited++
+		return ErrBreak
+	})
+	if visited != 1 {
+		t.Fatalf("visited %d keys after break", visited)
+	}
+
+	// Transactions are limited to a partition
+	err = p.ReadWrite([]byte("users/3"), func(r *Reader, w *Writer) error {
+		w.Set([]byte("users/3"), []byte("v"))
+		w.Set([]byte("orders/c"), []byte("v"))
+		return nil
+	})
+	if !errors.Is(err, ErrCrossPartition) {
+		t.Fatalf("got error %v", err)
+	}
+	if err := p.Delete([]byte("users/1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Partitions are compacted on their own and reopened
+	if err := p.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p.partitionPath("orders")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p, err = OpenPartitioned(dir, 1, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if value, err := p.Get([]byte("orders/a/1")); err != nil || string(value) != "v:orders/a/1" {
+		t.Fatalf("got value %q (%v)", value, err)
+	}
+	if value, err := p.Get([]byte("users/1")); err != nil || value != nil {
+		t.Fatalf("got value %q (%v) for deleted key", value, err)
+	}
+	if value, err := p.Get([]byte("missing/1")); err != nil || value != nil {
+		t.Fatalf("got value %q (%v) for missing partition", value, err)
+	}
+}
+
+func TestPrefixHistogram(t *testing.T) {
+	f := openTestFile(t)
+	for _, key := range []string{"users/1", "users/2", "user", "orders/a/1", "orders/b/2", "orders/b/3"} {
+		mustSet(t, f, key, "value")
+	}
+	size := func(keys ...string) int {
+		n := 0
+		_ = f.Read(func(r *Reader) error {
+			for _, key := range keys {
+				n += r.get([]byte(key)).Position.Size()
+			}
+			return nil
+		})
+		return n
+	}
+	for depth, want := range map[int][]PrefixStats{
+		1: {{"", 1, size("user")}, {"orders", 3, size("orders/a/1", "orders/b/2", "orders/b/3")}, {"users", 2, size("users/1", "users/2")}},
+		2: {{"", 3, size("user", "users/1", "users/2")}, {"orders/a", 1, size("orders/a/1")}, {"orders/b", 2, size("orders/b/2", "orders/b/3")}},
+	} {
+		var got []PrefixStats
+		err := f.Read(func(r *Reader) (err error) { got, err = r.PrefixHistogram(depth); return err })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("depth %d: got %+v instead of %+v", depth, got, want)
+		}
+	}
+	if err := f.Read(func(r *Reader) error { _, err := r.PrefixHistogram(0); return err }); err == nil {
+		t.Fatal("expected an error for an invalid depth")
+	}
+}
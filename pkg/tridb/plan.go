@@ -0,0 +1,67 @@
+package tridb
+
+import "fmt"
+
+// PlannedRow is a row that a transaction would write (see File.Plan).
+type PlannedRow struct {
+	Row
+	Size int // Encoded size of the row in the file format.
+}
+
+// Plan runs the callback of a read-write transaction and returns the rows that would be written,
+// without writing them: the file, the keydir and the watchers are left untouched.
+// Conditional writes, quotas and the pre-commit hook are checked as on commit, and unchanged rows are skipped
+// (see WithSkipUnchangedWrites).
+//
+// Values are returned as they would be written (ex: encrypted or compressed).
+func (f *File) Plan(do func(r *Reader, w *Writer) error) ([]PlannedRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.checkWritable(); err != nil {
+		return nil, err
+	}
+	r, w := f.newReader(), f.newWriter()
+	w.committed = r
+	if err := do(r, w); err != nil {
+		return nil, err
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	if err := f.resolveDeferred(w); err != nil {
+		return nil, err
+	}
+	if f.opts.CoalesceWrites {
+		w.rows = f.coalesceRows(w.rows)
+	}
+	if f.opts.PreCommitHook != nil {
+		if err := f.opts.PreCommitHook(w); err != nil {
+			return nil, fmt.Errorf("pre-commit hook: %w", err)
+		}
+	}
+	if err := f.checkConditions(w); err != nil {
+		return nil, err
+	}
+	if err := f.checkMergeOperator(w.rows); err != nil {
+		return nil, err
+	}
+	if err := f.checkFrozen(w.rows); err != nil {
+		return nil, err
+	}
+	if _, err := f.checkQuotas(w.rows); err != nil {
+		return nil, err
+	}
+
+	planned := make([]PlannedRow, 0, len(w.rows))
+	for _, row := range w.rows {
+		if f.opts.SkipUnchangedWrites && f.isUnchanged(row) {
+			continue
+		}
+		encoded, err := f.format.Encode(row)
+		if err != nil {
+			return nil, fmt.Errorf("encode: %w", err)
+		}
+		planned = append(planned, PlannedRow{Row: *row, Size: len(encoded)})
+	}
+	return planned, nil
+}
@@ -0,0 +1,42 @@
+package tridb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	f := openTestFile(t, WithSkipUnchangedWrites(true))
+	mustSet(t, f, "a", "1")
+	size := f.Stats().FileSize
+	planned, err := f.Plan(func(r *Reader, w *Writer) error {
+		w.Set([]byte("a"), []byte("1")) // unchanged
+		w.Set([]byte("b"), []byte("2"))
+		w.Delete([]byte("a"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(planned) != 2 || string(planned[0].Key) != "b" || !planned[1].IsDeleted {
+		t.Fatalf("got unexpected planned rows: %+v", planned)
+	}
+	for _, row := range planned {
+		if row.Size != row.EncodedSize() {
+			t.Fatalf("got size %d instead of %d for %q", row.Size, row.EncodedSize(), row.Key)
+		}
+	}
+	if f.Stats().FileSize != size {
+		t.Fatal("plan wrote to the file")
+	}
+	assertValue(t, f, "a", "1")
+	assertValue(t, f, "b", "")
+
+	_, err = f.Plan(func(r *Reader, w *Writer) error {
+		w.SetIfAbsent([]byte("a"), []byte("2"))
+		return nil
+	})
+	if !errors.Is(err, ErrConditionFailed) {
+		t.Fatalf("got error %v instead of %v", err, ErrConditionFailed)
+	}
+}
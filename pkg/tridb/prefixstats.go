@@ -0,0 +1,91 @@
+package tridb
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// PrefixStats returns the number of keys and their size for every prefix of up to depth segments
+// (separated by PartitionSeparator), as a tree: each prefix is followed by its sub-prefixes, sorted segment by segment.
+// The first entry has the empty prefix and holds the totals of all keys.
+// Keys are counted in each of their prefixes, a key with fewer segments than depth is only counted in its shorter prefixes.
+//
+// Unlike PrefixHistogram, keys are aggregated at every level of the tree (see TopPrefixes to find the largest ones).
+func (r *Reader) PrefixStats(depth int) ([]PrefixStats, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("invalid prefix depth: %d", depth)
+	}
+	root := &PrefixStats{}
+	byPrefix := map[string]*PrefixStats{"": root}
+	err := r.walkRange(nil, nil, false, func(row *fidx.RowInfo) error {
+		key, err := r.rowKey(row)
+		if err != nil {
+			return err
+		}
+		root.Keys++
+		root.Bytes += row.Position.Size()
+		end := 0
+		for i := 0; i < depth; i++ {
+			j := bytes.IndexByte(key[end:], PartitionSeparator)
+			if j < 0 {
+				break
+			}
+			end += j + 1
+			stats, ok := byPrefix[string(key[:end-1])]
+			if !ok {
+				stats = &PrefixStats{Prefix: string(key[:end-1])}
+				byPrefix[stats.Prefix] = stats
+			}
+			stats.Keys++
+			stats.Bytes += row.Position.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	tree := make([]PrefixStats, 0, len(byPrefix))
+	for _, stats := range byPrefix {
+		tree = append(tree, *stats)
+	}
+	sep := string(PartitionSeparator)
+	sort.Slice(tree, func(i, j int) bool {
+		return tree[i].Prefix == "" || (tree[j].Prefix != "" &&
+			slices.Compare(strings.Split(tree[i].Prefix, sep), strings.Split(tree[j].Prefix, sep)) < 0)
+	})
+	return tree, nil
+}
+
+// TopPrefixes returns the n prefixes of the given statistics with the most bytes (the most keys on ties),
+// the empty prefix of totals (see Reader.PrefixStats) is left out.
+func TopPrefixes(stats []PrefixStats, n int) []PrefixStats {
+	top := make([]PrefixStats, 0, len(stats))
+	for _, s := range stats {
+		if s.Prefix != "" {
+			top = append(top, s)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		if top[i].Bytes != top[j].Bytes {
+			return top[i].Bytes > top[j].Bytes
+		}
+		return top[i].Keys > top[j].Keys
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// PrefixDepth returns the number of segments of the given prefix (0 for the empty prefix).
+func PrefixDepth(prefix string) int {
+	if prefix == "" {
+		return 0
+	}
+	return strings.Count(prefix, string(PartitionSeparator)) + 1
+}
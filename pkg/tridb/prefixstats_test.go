@@ -0,0 +1,40 @@
+package tridb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrefixStats(t *testing.T) {
+	f := openTestFile(t, WithKeydir(KeydirTrie))
+	for _, key := range []string{"users/1", "users/2", "user", "orders/a/1", "orders/b/2", "orders/b/3", "orders-archive/1"} {
+		mustSet(t, f, key, "value")
+	}
+	var tree []PrefixStats
+	err := f.Read(func(r *Reader) (err error) { tree, err = r.PrefixStats(2); return err })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	keys := map[string]int{}
+	for _, s := range tree {
+		got, keys[s.Prefix] = append(got, s.Prefix), s.Keys
+	}
+	if want := []string{"", "orders", "orders/a", "orders/b", "orders-archive", "users"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got prefixes %q instead of %q", got, want)
+	}
+	if want := map[string]int{"": 7, "orders": 3, "orders/a": 1, "orders/b": 2, "orders-archive": 1, "users": 2}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got key counts %v instead of %v", keys, want)
+	}
+	if depth := PrefixDepth("orders/b"); depth != 2 {
+		t.Fatalf("got depth %d", depth)
+	}
+
+	top := TopPrefixes(tree, 2)
+	if len(top) != 2 || top[0].Prefix != "orders" || top[1].Prefix != "orders/b" && top[1].Prefix != "users" {
+		t.Fatalf("got top prefixes %+v", top)
+	}
+	if err := f.Read(func(r *Reader) error { _, err := r.PrefixStats(0); return err }); err == nil {
+		t.Fatal("expected an error for an invalid depth")
+	}
+}
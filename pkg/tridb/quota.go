@@ -0,0 +1,167 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// ErrQuotaExceeded is returned when a commit would exceed the hard threshold of a quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrDatabaseFull is returned when a commit would grow the file above its maximum size (see WithMaxFileSize).
+var ErrDatabaseFull = errors.New("database full")
+
+// checkFileSize returns ErrDatabaseFull if writing the given rows of the writer (of the given total size)
+// would exceed the maximum file size.
+func (f *File) checkFileSize(w *Writer, rows []*Row, size int) error {
+	if f.opts.MaxFileSize <= 0 || f.woffset+size <= f.opts.MaxFileSize {
+		return nil
+	}
+	onlyDeletes := len(w.ops) == 0
+	for _, row := range rows {
+		onlyDeletes = onlyDeletes && row.IsDeleted
+	}
+	if onlyDeletes {
+		return nil // deletes make room once compacted
+	}
+	return fmt.Errorf("%w: the commit would grow the file to %d bytes (limit is %d)", ErrDatabaseFull, f.woffset+size, f.opts.MaxFileSize)
+}
+
+// Quota limits the total size of the values of keys starting with a given prefix.
+type Quota struct {
+	// Soft is the number of value bytes above which OnSoftLimit is called (zero means no soft threshold).
+	Soft int
+	// Hard is the number of value bytes above which commits are rejected with ErrQuotaExceeded
+	// (zero means no hard threshold). Commits that don't grow the usage are always accepted.
+	Hard int
+	// OnSoftLimit is called (in its own goroutine) when a commit makes the usage cross the soft threshold.
+	OnSoftLimit func(prefix []byte, used int)
+}
+
+type prefixQuota struct {
+	prefix []byte
+	Quota
+	used int // total size of the values under the prefix
+}
+
+// SetQuota sets the quota of the given prefix (replacing any previous quota of the same prefix).
+// A quota without thresholds removes it. The current usage is computed by reading the values under the prefix,
+// it is then updated incrementally on each commit (so enforcement costs O(rows in the transaction)).
+//
+// Quotas are not persisted in the file, they must be set again after opening it.
+func (f *File) SetQuota(prefix []byte, q Quota) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.opts.KeySecret != nil {
+		return ErrHashedKeys
+	}
+	quotas := make([]*prefixQuota, 0, len(f.quotas)+1)
+	for _, other := range f.quotas {
+		if !bytes.Equal(other.prefix, prefix) {
+			quotas = append(quotas, other)
+		}
+	}
+	if q.Soft <= 0 && q.Hard <= 0 {
+		f.quotas = quotas
+		return nil
+	}
+
+	quota := &prefixQuota{prefix: bytes.Clone(prefix), Quota: q}
+	err := f.idx.WalkRange(prefix, fidx.PrefixEnd(prefix), false, func(rowInfo *fidx.RowInfo) error {
+		row, err := f.readAndDecodeRow(f.store, rowInfo.Position)
+		quota.used += len(row.Value)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("compute quota usage: %w", err)
+	}
+	f.quotas = append(quotas, quota)
+	return nil
+}
+
+// checkQuotas returns how the given rows change the usage of each quota,
+// or ErrQuotaExceeded if a hard threshold would be exceeded.
+func (f *File) checkQuotas(rows []*Row) ([]int, error) {
+	if len(f.quotas) == 0 {
+		return nil, nil
+	}
+	deltas := make([]int, len(f.quotas))
+	staged := map[string]int{} // value size of keys already written in the transaction
+	for _, row := range rows {
+		if IsReservedKey(row.Key) {
+			continue
+		}
+		size, ok := staged[string(row.Key)]
+		if !ok {
+			size = f.valueSize(row.Key)
+		}
+		newSize := len(row.Value)
+		if row.IsDeleted {
+			newSize = 0
+		} else if row.IsMerge {
+			newSize = size + len(row.Value) // estimate, the merged value is only known once written
+		}
+		staged[string(row.Key)] = newSize
+		for i, quota := range f.quotas {
+			if bytes.HasPrefix(row.Key, quota.prefix) {
+				deltas[i] += newSize - size
+			}
+		}
+	}
+	for i, quota := range f.quotas {
+		if quota.Hard > 0 && deltas[i] > 0 && quota.used+deltas[i] > quota.Hard {
+			return nil, fmt.Errorf("%w: prefix %q would use %d bytes (limit is %d)", ErrQuotaExceeded, quota.prefix, quota.used+deltas[i], quota.Hard)
+		}
+	}
+	return deltas, nil
+}
+
+// applyQuotas updates the quota usages once rows are committed (see checkQuotas).
+func (f *File) applyQuotas(deltas []int) {
+	for i, delta := range deltas {
+		quota := f.quotas[i]
+		before := quota.used
+		quota.used += delta
+		if quota.Soft > 0 && quota.OnSoftLimit != nil && before <= quota.Soft && quota.used > quota.Soft {
+			go quota.OnSoftLimit(bytes.Clone(quota.prefix), quota.used)
+		}
+	}
+}
+
+// valueSize returns the size of the current value of the given key (zero if it doesn't exist).
+func (f *File) valueSize(key []byte) int {
+	rowInfo := f.idx.Get(f.indexKey(key))
+	if rowInfo == nil {
+		return 0
+	}
+	row, err := f.readAndDecodeRow(f.store, rowInfo.Position)
+	if err != nil {
+		return 0
+	}
+	return len(row.Value)
+}
+
+// Quotas returns the current usage (in value bytes) of each prefix with a quota.
+func (f *File) Quotas() map[string]int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	usages := make(map[string]int, len(f.quotas))
+	for _, quota := range f.quotas {
+		usages[string(quota.prefix)] = quota.used
+	}
+	return usages
+}
+
+// sortedQuotaPrefixes returns the prefixes of the given usages, sorted.
+func sortedQuotaPrefixes(usages map[string]int) []string {
+	prefixes := make([]string, 0, len(usages))
+	for prefix := range usages {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
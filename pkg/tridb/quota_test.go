@@ -0,0 +1,102 @@
+package tridb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "users/1", "alice")
+
+	crossed := make(chan int, 1)
+	err := f.SetQuota([]byte("users/"), Quota{Soft: 8, Hard: 12, OnSoftLimit: func(prefix []byte, used int) { crossed <- used }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Quotas()["users/"]; got != 5 {
+		t.Fatalf("got usage %d instead of 5", got)
+	}
+
+	// Crossing the soft threshold
+	mustSet(t, f, "users/2", "bob")
+	mustSet(t, f, "users/2", "bobby")
+	if used := <-crossed; used != 10 {
+		t.Fatalf("soft limit called with %d instead of 10", used)
+	}
+
+	// Exceeding the hard threshold (in a batch)
+	b := f.Batch()
+	b.Set([]byte("users/3"), []byte("carol"))
+	b.Set([]byte("orders/1"), []byte("not counted"))
+	if err := b.Commit(); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got error %v instead of ErrQuotaExceeded", err)
+	}
+	assertValue(t, f, "orders/1", "")
+
+	// Writes that free space are accepted
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("users/1"))
+		w.Set([]byte("users/3"), []byte("carol"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Quotas()["users/"]; got != 10 {
+		t.Fatalf("got usage %d instead of 10", got)
+	}
+
+	if err := f.SetQuota([]byte("users/"), Quota{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Quotas()) != 0 {
+		t.Fatal("quota not removed")
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	f := openTestFile(t, WithMaxFileSize(100))
+	mustSet(t, f, "a", "1")
+	if headroom := f.Stats().Headroom; headroom <= 0 || headroom >= 100 {
+		t.Fatalf("got headroom %d", headroom)
+	}
+
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("b"), make([]byte, 100))
+		return nil
+	})
+	if !errors.Is(err, ErrDatabaseFull) {
+		t.Fatalf("got error %v instead of %v", err, ErrDatabaseFull)
+	}
+	b := f.Batch()
+	b.Set([]byte("b"), make([]byte, 100))
+	if err := b.Commit(); !errors.Is(err, ErrDatabaseFull) {
+		t.Fatalf("got error %v instead of %v in a batch", err, ErrDatabaseFull)
+	}
+	assertValue(t, f, "b", "")
+
+	// Deletes are accepted past the limit, compactions reclaim their space.
+	for f.Stats().Headroom > 0 {
+		err = f.ReadWrite(func(r *Reader, w *Writer) error {
+			w.Set([]byte("a"), []byte("1"))
+			return nil
+		})
+		if errors.Is(err, ErrDatabaseFull) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Delete([]byte("a"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "b", "2")
+}
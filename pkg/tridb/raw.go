@@ -0,0 +1,77 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// GetRaw returns the row of the given key as stored in the file, or nil if the key doesn't exist:
+// encoded in the row format of the file, without decrypting or decompressing its value nor resolving aliases.
+// It can be written verbatim to another file with RawWriter.PutRaw (deduplicated values are inlined, see WithValueDedup).
+func (r *Reader) GetRaw(key []byte) ([]byte, error) {
+	rowInfo := r.get(key)
+	if rowInfo == nil {
+		return nil, nil
+	}
+	if r.f.opts.ParanoidChecks || r.f.opts.KeySecret != nil {
+		if _, err := r.readRow(key, rowInfo.Position); err != nil {
+			return nil, err // the row is not the one of the key
+		}
+	}
+	encoded := make([]byte, rowInfo.Position.Size())
+	if _, err := r.ra.ReadAt(encoded, int64(rowInfo.Position.Offset())); err != nil {
+		return nil, fmt.Errorf("read row: %w", err)
+	}
+	encoded, _, err := r.f.inlineEncodedRow(r.ra, encoded, false)
+	if err != nil {
+		return nil, fmt.Errorf("inline row: %w", err)
+	}
+	return encoded, nil
+}
+
+// RawWriter is a writer that can also write encoded rows verbatim (see File.ReadWriteRaw).
+type RawWriter struct{ *Writer }
+
+// ReadWriteRaw is like ReadWrite but the writer can also write encoded rows verbatim,
+// for example to move rows between files without decoding and re-encoding their values.
+func (f *File) ReadWriteRaw(do func(r *Reader, w *RawWriter) error) error {
+	return f.ReadWrite(func(r *Reader, w *Writer) error { return do(r, &RawWriter{w}) })
+}
+
+// ErrRawMerge is returned when writing a raw merge operand (it refers to the previous rows of its file).
+var ErrRawMerge = errors.New("raw merge operand")
+
+// PutRaw writes the given encoded row (see Reader.GetRaw) verbatim, it is only decoded to be validated and indexed.
+// The row must be encoded in the row format of the file, with the same encryption keys (see WithEncryption
+// and File.EncryptPrefix) as the file it was read from.
+//
+// Invalid rows, rows of reserved keys and merge operands abort the transaction.
+func (w *RawWriter) PutRaw(encoded []byte) {
+	row := &Row{}
+	n, err := w.format.DecodeFrom(bytes.NewReader(encoded), row)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("decode raw row: %w", err)
+	case n != len(encoded):
+		err = fmt.Errorf("decode raw row: %d trailing bytes", len(encoded)-n)
+	case row.IsMerge:
+		err = fmt.Errorf("%w: %q", ErrRawMerge, row.Key)
+	case row.valueRef.Size() != 0:
+		err = fmt.Errorf("raw row of %q references the value of another row", row.Key)
+	default:
+		err = row.VerifyChecksum()
+	}
+	if err != nil {
+		if w.err == nil {
+			w.err = err
+		}
+		return
+	}
+	if !w.checkKey(row.Key) || !w.checkValue(row.Value) {
+		return
+	}
+	row.raw = bytes.Clone(encoded)
+	w.rows = append(w.rows, row)
+	w.pendingBytes += len(row.raw)
+}
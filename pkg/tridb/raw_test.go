@@ -0,0 +1,84 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRawRows(t *testing.T) {
+	opts := []Option{WithCompression(CompressionDeflate, 1), WithRowChecksums(true)}
+	src, dst := openTestFile(t, opts...), openTestFile(t, opts...)
+	value := bytes.Repeat([]byte("compressed "), 100)
+	mustSet(t, src, "a", string(value))
+	err := src.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetWithContentType([]byte("b"), []byte("{}"), "application/json")
+		w.Alias([]byte("c"), []byte("a"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows are moved verbatim.
+	raws := map[string][]byte{}
+	err = src.Read(func(r *Reader) error {
+		for _, key := range []string{"a", "b", "c", "missing"} {
+			raw, err := r.GetRaw([]byte(key))
+			if err != nil {
+				return err
+			}
+			raws[key] = raw
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raws["missing"] != nil {
+		t.Fatalf("got raw row %q for a missing key", raws["missing"])
+	}
+	if len(raws["a"]) >= len(value) {
+		t.Fatalf("got %d bytes for the compressed row", len(raws["a"]))
+	}
+	err = dst.ReadWriteRaw(func(r *Reader, w *RawWriter) error {
+		for _, key := range []string{"a", "b", "c"} {
+			w.PutRaw(raws[key])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, dst, "a", string(value))
+	assertValue(t, dst, "c", string(value))
+	err = dst.Read(func(r *Reader) error {
+		if got := r.ContentType([]byte("b")); got != "application/json" {
+			t.Fatalf("got content type %q", got)
+		}
+		raw, err := r.GetRaw([]byte("a"))
+		if !bytes.Equal(raw, raws["a"]) {
+			t.Fatalf("got raw row %q instead of %q", raw, raws["a"])
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid rows and merge operands abort the transaction.
+	merge, _ := (&Row{Key: []byte("m"), Value: []byte("1"), IsMerge: true}).Encode()
+	for _, test := range []struct {
+		raw  []byte
+		want error
+	}{
+		{append(bytes.Clone(raws["b"]), 0), nil},
+		{raws["b"][:len(raws["b"])-1], nil},
+		{merge, ErrRawMerge},
+	} {
+		err := dst.ReadWriteRaw(func(r *Reader, w *RawWriter) error { w.PutRaw(test.raw); return nil })
+		if !errors.Is(err, ErrTxnAborted) || (test.want != nil && !errors.Is(err, test.want)) {
+			t.Fatalf("got error %v for raw row %q", err, test.raw)
+		}
+	}
+}
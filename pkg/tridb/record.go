@@ -0,0 +1,60 @@
+package tridb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrRecording is returned when a committed transaction couldn't be recorded (see WithRecorder).
+var ErrRecording = errors.New("record transaction")
+
+// record writes the committed rows to the recorder (if any),
+// each transaction is recorded as a binary batch frame.
+func (f *File) record(rows []*Row) error {
+	if f.opts.Recorder == nil || len(rows) == 0 {
+		return nil
+	}
+	frame, err := encodeBatch(BinaryEncoding, rows)
+	if err == nil {
+		_, err = f.opts.Recorder.Write(frame.encoded)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRecording, err)
+	}
+	return nil
+}
+
+// ApplyRecording commits the transactions recorded with WithRecorder, in order,
+// keeping their original timestamps. It reports the number of applied transactions.
+//
+// Applied to a fresh file, it reproduces the exact state (and rows) of the recorded file.
+func (f *File) ApplyRecording(src io.Reader) (int, error) {
+	r := bufio.NewReader(src)
+	count := 0
+	for {
+		if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+			break
+		}
+		frame, _, err := decodeBatchFrom(BinaryEncoding, r)
+		if err != nil {
+			return count, fmt.Errorf("decode transaction %d: %w", count, err)
+		}
+		b := f.Batch()
+		for _, row := range frame {
+			row := row.row
+			b.rows = append(b.rows, &row)
+		}
+		err = b.Commit()
+		if err != nil {
+			return count, fmt.Errorf("apply transaction %d: %w", count, err)
+		}
+		count++
+	}
+
+	// Recorded transactions may have changed policies stored in the reserved keyspace.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return count, f.loadPolicies()
+}
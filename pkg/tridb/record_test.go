@@ -0,0 +1,40 @@
+package tridb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	recording := &bytes.Buffer{}
+	f := openTestFile(t, WithRecorder(recording))
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "b", "2")
+	b := f.Batch()
+	b.Set([]byte("c"), []byte("3"))
+	b.Delete([]byte("a"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := openTestFile(t)
+	n, err := replayed.ApplyRecording(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatalf("applied %d transactions instead of 3", n)
+	}
+	want, got := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := f.Dump(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := replayed.Dump(got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Fatalf("got state:\n%s\ninstead of:\n%s", got, want)
+	}
+	if got, want := replayed.idx.Get([]byte("b")).Timestamp, f.idx.Get([]byte("b")).Timestamp; got != want {
+		t.Fatalf("got timestamp %d instead of %d", got, want)
+	}
+}
@@ -0,0 +1,164 @@
+package tridb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// RecoveryAction is the decision taken when opening a file ending with a torn row or batch
+// (bytes left by a crash in the middle of a write).
+type RecoveryAction uint8
+
+const (
+	RecoveryTruncate   RecoveryAction = iota // Discard the torn bytes (default).
+	RecoveryAbort                            // Fail to open the file, leaving it untouched.
+	RecoveryQuarantine                       // Move the torn bytes to a quarantine file, then truncate.
+)
+
+func (action RecoveryAction) String() string {
+	switch action {
+	case RecoveryTruncate:
+		return "truncate"
+	case RecoveryAbort:
+		return "abort"
+	case RecoveryQuarantine:
+		return "quarantine"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(action))
+}
+
+// ErrTornTail is returned by Open when the recovery hook aborts.
+var ErrTornTail = errors.New("torn tail")
+
+// QuarantineFileExtension is added to the file path (followed by the offset of the torn bytes)
+// to name quarantine files.
+const QuarantineFileExtension = ".quarantine"
+
+// Recovery decisions are recorded in the reserved keyspace.
+const recoveryKeyPrefix = "recovery/"
+
+// Recovery describes a recovery decision taken when opening the file.
+type Recovery struct {
+	Time   time.Time
+	Offset int // Offset of the torn bytes.
+	Size   int // Number of torn bytes.
+	Action RecoveryAction
+}
+
+// logRecovery truncates torn bytes and logs them (see OpenWithRecovery).
+func logRecovery(offset int, partial []byte) RecoveryAction {
+	log.Printf("tridb: dropping %d bytes at offset %d: %q", len(partial), offset, partial[:min(len(partial), 64)])
+	return RecoveryTruncate
+}
+
+// recoverTail handles the torn bytes found after the replayed rows (if any).
+func (f *File) recoverTail() error {
+	size, err := f.store.Size()
+	if err != nil {
+		return fmt.Errorf("stat datafile: %w", err)
+	}
+	if size <= int64(f.woffset) {
+		return nil
+	}
+	partial := make([]byte, size-int64(f.woffset))
+	_, err = f.store.ReadAt(partial, int64(f.woffset))
+	if err != nil {
+		return fmt.Errorf("read torn tail: %w", err)
+	}
+
+	action := RecoveryTruncate
+	if f.opts.RecoveryHook != nil {
+		action = f.opts.RecoveryHook(f.woffset, partial)
+	}
+	switch action {
+	case RecoveryTruncate:
+	case RecoveryAbort:
+		return fmt.Errorf("%w: %d bytes at offset %d", ErrTornTail, len(partial), f.woffset)
+	case RecoveryQuarantine:
+		qpath := fmt.Sprintf("%s%s.%d", f.fpath, QuarantineFileExtension, f.woffset)
+		err = os.WriteFile(qpath, partial, 0666)
+		if err != nil {
+			return fmt.Errorf("write quarantine file: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown recovery action: %s", action)
+	}
+	err = f.store.Truncate(int64(f.woffset))
+	if err != nil {
+		return fmt.Errorf("truncate torn tail: %w", err)
+	}
+
+	// Record decision
+	now := time.Now()
+	value := binary.BigEndian.AppendUint64(nil, uint64(f.woffset))
+	value = binary.BigEndian.AppendUint64(value, uint64(len(partial)))
+	value = append(value, byte(action))
+	row := &Row{Key: append(reservedKey(recoveryKeyPrefix), EncodeTimeKey(now)...), Value: value}
+	if !f.opts.DisableTimestamps {
+		row.Timestamp = now.UnixNano()
+	}
+	encoded, err := f.format.Encode(row)
+	if err != nil {
+		return fmt.Errorf("encode recovery record: %w", err)
+	}
+	n, err := f.store.Append(encoded)
+	f.tail.Write(encoded[:n])
+	if err == nil {
+		err = f.store.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("write recovery record: %w", err)
+	}
+	f.applyRow(row, fidx.Position{f.woffset, n}, f.idx, f.sys)
+	f.woffset += n
+	f.numRows++
+	return nil
+}
+
+// Recoveries returns the recovery decisions recorded in the file, in chronological order.
+//
+// Note: records are kept by compaction, they can be removed with ClearRecoveries.
+func (f *File) Recoveries() ([]Recovery, error) {
+	var recoveries []Recovery
+	err := f.Read(func(r *Reader) error {
+		prefix := reservedKey(recoveryKeyPrefix)
+		return r.sys.WalkRange(prefix, fidx.PrefixEnd(prefix), false, func(rowInfo *fidx.RowInfo) error {
+			t, err := DecodeTimeKey(rowInfo.Key[len(prefix):])
+			if err != nil {
+				return err
+			}
+			v, err := r.getReserved(string(rowInfo.Key[len(ReservedPrefix):]))
+			if err != nil {
+				return err
+			}
+			if len(v) != 17 {
+				return fmt.Errorf("invalid recovery record of length %d", len(v))
+			}
+			recoveries = append(recoveries, Recovery{
+				Time:   t,
+				Offset: int(binary.BigEndian.Uint64(v)),
+				Size:   int(binary.BigEndian.Uint64(v[8:])),
+				Action: RecoveryAction(v[16]),
+			})
+			return nil
+		})
+	})
+	return recoveries, err
+}
+
+// ClearRecoveries removes the recorded recovery decisions.
+func (f *File) ClearRecoveries() error {
+	return f.ReadWrite(func(r *Reader, w *Writer) error {
+		prefix := reservedKey(recoveryKeyPrefix)
+		return r.sys.WalkRange(prefix, fidx.PrefixEnd(prefix), false, func(rowInfo *fidx.RowInfo) error {
+			w.deleteReserved(string(rowInfo.Key[len(ReservedPrefix):]))
+			return nil
+		})
+	})
+}
@@ -0,0 +1,125 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecoveryHook(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	f.Close()
+	stat, err := os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of a row write.
+	torn, _ := (&Row{Key: []byte("b"), Value: []byte("2")}).Encode()
+	torn = torn[:len(torn)-1]
+	fw, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(torn); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+
+	// Abort leaves the file untouched.
+	hook := func(action RecoveryAction) Option {
+		return WithRecoveryHook(func(offset int, partial []byte) RecoveryAction {
+			if offset != int(stat.Size()) || !bytes.Equal(partial, torn) {
+				t.Fatalf("got torn bytes %q at offset %d", partial, offset)
+			}
+			return action
+		})
+	}
+	if _, err := Open(fpath, 1, hook(RecoveryAbort)); !errors.Is(err, ErrTornTail) {
+		t.Fatalf("got error %v instead of %v", err, ErrTornTail)
+	}
+
+	// Quarantine moves the torn bytes to a separate file and records the decision.
+	f, err = Open(fpath, 1, hook(RecoveryQuarantine))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	quarantined, err := os.ReadFile(fmt.Sprintf("%s%s.%d", fpath, QuarantineFileExtension, stat.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(quarantined, torn) {
+		t.Fatalf("got quarantined bytes %q instead of %q", quarantined, torn)
+	}
+	assertValue(t, f, "a", "1")
+	recoveries, err := f.Recoveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveries) != 1 || recoveries[0].Action != RecoveryQuarantine || recoveries[0].Size != len(torn) {
+		t.Fatalf("got recoveries %+v", recoveries)
+	}
+	if err := f.ClearRecoveries(); err != nil {
+		t.Fatal(err)
+	}
+	if recoveries, _ := f.Recoveries(); len(recoveries) != 0 {
+		t.Fatalf("got recoveries %+v after clearing them", recoveries)
+	}
+}
+
+func TestCorruptTailRepair(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	offset := f.Stats().FileSize
+	mustSet(t, f, "b", "2")
+	f.Close()
+
+	// Simulate a crash overwriting the op of the last row.
+	content, err := os.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[offset] = 'Z'
+	if err := os.WriteFile(fpath, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(fpath, 1); err == nil {
+		t.Fatal("opened file with corrupt tail without repair")
+	}
+
+	var dropped []byte
+	f, err = OpenWithRecovery(fpath, 1, WithRecoveryHook(func(gotOffset int, partial []byte) RecoveryAction {
+		if gotOffset != offset {
+			t.Fatalf("got corrupt bytes at offset %d instead of %d", gotOffset, offset)
+		}
+		dropped = partial
+		return RecoveryTruncate
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if !bytes.Equal(dropped, content[offset:]) {
+		t.Fatalf("got dropped bytes %q instead of %q", dropped, content[offset:])
+	}
+	assertValue(t, f, "a", "1")
+	assertValue(t, f, "b", "")
+	if recoveries, _ := f.Recoveries(); len(recoveries) != 1 || recoveries[0].Offset != offset {
+		t.Fatalf("got recoveries %+v", recoveries)
+	}
+	mustSet(t, f, "b", "3")
+	assertValue(t, f, "b", "3")
+}
@@ -0,0 +1,92 @@
+package tridb
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// rename is a staged rename, resolved on commit before the row staged at the given index.
+type rename struct {
+	from, to []byte
+	at       int
+}
+
+// Rename atomically moves the value of the old key to the new key (overwriting it) and deletes the old key.
+// The value is read on commit (along with its expiration and content type), so no write can interleave,
+// and the transaction is written in a single batch frame, so a crash cannot leave it half-applied.
+//
+// The transaction fails with ErrKeyNotFound on commit if the old key doesn't exist.
+func (w *Writer) Rename(oldKey, newKey []byte) {
+	if w.checkKey(oldKey) && w.checkKey(newKey) {
+		w.renames = append(w.renames, rename{from: oldKey, to: newKey, at: len(w.rows)})
+	}
+}
+
+// resolveDeferred stages the rows of the renames and prefix deletions (see Writer.DeletePrefix) of the given writer
+// where they were staged, renamed values are read from the rows staged before them or from the committed state.
+// It must be called with the write lock held.
+func (f *File) resolveDeferred(w *Writer) error {
+	if len(w.renames) == 0 && len(w.deletes) == 0 {
+		return nil
+	}
+	staged, next, deletes := w.rows, 0, w.deletes
+	w.rows = make([]*Row, 0, len(staged)+2*len(w.renames))
+	for i, rn := range w.renames {
+		for ; len(deletes) > 0 && deletes[0].renames <= i; deletes = deletes[1:] {
+			w.rows, next = append(w.rows, staged[next:deletes[0].at]...), deletes[0].at
+			if err := f.stagePrefixDelete(w, deletes[0].prefix); err != nil {
+				return err
+			}
+		}
+		w.rows, next = append(w.rows, staged[next:rn.at]...), rn.at
+		source, err := f.latestRow(w.rows, rn.from)
+		if err != nil {
+			return fmt.Errorf("rename %q: %w", rn.from, err)
+		}
+		if source == nil {
+			return fmt.Errorf("rename %q: %w", rn.from, ErrKeyNotFound)
+		}
+		if bytes.Equal(rn.from, rn.to) {
+			continue
+		}
+		row := &Row{Key: rn.to, ExpiresAt: source.ExpiresAt, ContentType: source.ContentType, IsAlias: source.IsAlias}
+		if row.Value = source.Value; !row.IsAlias {
+			if row.Value, err = f.storedValue(f.readerAt(), source); err != nil {
+				return fmt.Errorf("rename %q: %w", rn.from, err)
+			}
+		}
+		w.stage(row)
+		if !f.sameKey(rn.from, rn.to) { // only the case changes with a case-folding collation for example
+			w.stage(&Row{IsDeleted: true, Key: rn.from})
+		}
+	}
+	for _, pd := range deletes {
+		w.rows, next = append(w.rows, staged[next:pd.at]...), pd.at
+		if err := f.stagePrefixDelete(w, pd.prefix); err != nil {
+			return err
+		}
+	}
+	w.rows = append(w.rows, staged[next:]...)
+	return nil
+}
+
+// latestRow returns the latest row of the given key staged in the given rows, or its committed row
+// (nil if the key doesn't exist).
+func (f *File) latestRow(staged []*Row, key []byte) (*Row, error) {
+	for i := len(staged) - 1; i >= 0; i-- {
+		row := staged[i]
+		if !f.sameKey(row.Key, key) {
+			continue
+		}
+		if row.IsDeleted {
+			return nil, nil
+		}
+		if row.IsMerge {
+			if err := f.collapseMerges(staged); err != nil { // staged merges have no base to resolve from
+				return nil, err
+			}
+		}
+		return row, nil
+	}
+	return f.currentRow(key)
+}
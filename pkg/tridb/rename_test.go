@@ -0,0 +1,75 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRename(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { f.Close() }()
+
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetWithContentType([]byte("a"), []byte("1"), "text/plain")
+		w.SetWithTTL([]byte("b"), []byte("2"), time.Hour)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := f.Stats().FileSize
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Rename([]byte("a"), []byte("c"))
+		w.Set([]byte("b"), []byte("3"))
+		w.Rename([]byte("b"), []byte("d")) // renames the value staged before
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(fpath); err != nil || data[before] != opBatch {
+		t.Fatalf("the renames were not written in a batch frame (%v)", err)
+	}
+
+	b := f.Batch()
+	b.Rename([]byte("c"), []byte("e"))
+	b.Rename([]byte("e"), []byte("e"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set([]byte("f"), []byte("4"))
+		w.Rename([]byte("missing"), []byte("g"))
+		return nil
+	})
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got error %v instead of %v", err, ErrKeyNotFound)
+	}
+
+	// Renamed rows are read back from their batch frames
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = Open(fpath, 1); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"a": "", "b": "", "c": "", "d": "3", "e": "1", "f": ""} {
+		assertValue(t, f, key, want)
+	}
+	_ = f.Read(func(r *Reader) error {
+		if got := r.ContentType([]byte("e")); got != "text/plain" {
+			t.Fatalf("got content type %q instead of %q", got, "text/plain")
+		}
+		if _, ok := r.TTL([]byte("d")); ok {
+			t.Fatal("the TTL of the overwritten value was kept")
+		}
+		return nil
+	})
+}
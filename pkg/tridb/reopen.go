@@ -0,0 +1,61 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reopen closes the file (ignoring errors, the file may have failed) and opens it again with the same options,
+// so that an application recovers from ErrInconsistent or ErrFailed without restarting:
+// the keydir is rebuilt from the file content (replayed unless a clean shutdown marker was written, see Close).
+//
+// The merge operator, the hooks and the secondary indexes are carried over to the returned file (see SetMergeOperator,
+// OnBeforeSet and CreateIndex), but not the watchers (see Watch). The file must not be used afterwards.
+func (f *File) Reopen() (*File, error) {
+	f.mu.RLock()
+	merge, hooks, indexes := f.merge, f.hooks.Load(), make(map[string]*secondaryIndex, len(f.indexes))
+	for name, index := range f.indexes {
+		indexes[name] = index
+	}
+	f.mu.RUnlock()
+
+	closeErr := f.Close()
+	if errors.Is(closeErr, ErrClosed) {
+		closeErr = nil
+	}
+	opts := f.given
+	g, err := OpenWithOptions(f.fpath, &opts)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("reopen: %w", err), closeErr)
+	}
+	g.merge = merge
+	if hooks != nil {
+		g.hooks.Store(hooks)
+	}
+	for name, index := range indexes {
+		if err := g.CreateIndex(name, index.extract); err != nil {
+			g.Close()
+			return nil, fmt.Errorf("reopen: %w", err)
+		}
+	}
+	return g, nil
+}
+
+// OpenWithRetry is like Open (with the default number of buckets) but retries failed opens (for example, on a flaky network filesystem) up to the given
+// number of attempts, waiting the given backoff after the first failure and doubling it after each failure.
+// The error of the last attempt is returned.
+func OpenWithRetry(fpath string, attempts int, backoff time.Duration, opts ...Option) (*File, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var f *File
+		if f, err = Open(fpath, 0, opts...); err == nil {
+			return f, nil
+		}
+		if attempt >= attempts {
+			return nil, fmt.Errorf("open after %d attempts: %w", attempt, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,66 @@
+package tridb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReopen(t *testing.T) {
+	f := openTestFile(t)
+	f.SetMergeOperator(appendMerge)
+	if err := f.CreateIndex("values", func(key, value []byte) [][]byte { return [][]byte{value} }); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	f.fail(ErrFileCorruption)
+	if err := f.ReadWrite(func(r *Reader, w *Writer) error { return nil }); !errors.Is(err, ErrFailed) {
+		t.Fatalf("got %v instead of ErrFailed", err)
+	}
+
+	f, err := f.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Merge([]byte("a"), []byte("2"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "a", "12")
+	err = f.Read(func(r *Reader) error {
+		keys, err := r.LookupIndex("values", []byte("12"))
+		if err == nil && len(keys) != 1 {
+			t.Fatalf("got keys %q instead of [a]", keys)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenWithRetry(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	failures := 2
+	flaky := WithStorage(func(path string, mode StorageMode) (Storage, error) {
+		if path == fpath && failures > 0 { // the datafile is unavailable
+			failures--
+			return nil, errors.New("unavailable")
+		}
+		return OpenFileStorage(path, mode)
+	})
+	if _, err := OpenWithRetry(fpath, 2, time.Millisecond, flaky); err == nil {
+		t.Fatal("expected an error after 2 attempts")
+	}
+	failures = 2
+	f, err := OpenWithRetry(fpath, 3, time.Millisecond, flaky)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
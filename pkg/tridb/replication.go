@@ -0,0 +1,474 @@
+package tridb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fsutil"
+)
+
+// ReplicationHeartbeatInterval is the interval of the heartbeats sent to idle followers (see ServeReplication),
+// followers reconnect when the primary stays silent for three intervals.
+const ReplicationHeartbeatInterval = time.Second
+
+// FollowerRetryInterval is the delay before a follower reconnects to its primary (see OpenFollower).
+const FollowerRetryInterval = time.Second
+
+// Followers send a handshake line with the size of their file and the fingerprint of its last bytes:
+//
+//	tridb-replicate 1 <offset> <fingerprint>
+//
+// The primary then streams frames made of a type byte and big-endian integers:
+//
+//	'D' <offset uint64> <primary size uint64> <length uint32> <bytes>
+//	'R' <size uint64>
+//	'H' <primary size uint64>
+//
+// Data frames hold the bytes appended at the given offset, a reset frame is sent when the file of the follower
+// diverged (or the primary was compacted): the data frames that follow rebuild the file of the given size from offset 0.
+const replicationHeader = "tridb-replicate 1"
+
+const (
+	frameData      = 'D'
+	frameReset     = 'R'
+	frameHeartbeat = 'H'
+)
+
+const (
+	replicationChunkSize       = 1 << 20 // maximum number of bytes in a data frame
+	replicationFingerprintSize = 4 << 10 // number of bytes hashed to compare the files of the follower and primary
+	replicationTimeout         = 10 * time.Second
+)
+
+// resyncFileExtension is added to the file path of a follower to get the path of the file
+// rebuilt after a reset (it replaces the file once complete).
+const resyncFileExtension = ".resync"
+
+// ErrReplicationOffset is returned by a follower receiving bytes that don't follow its file.
+var ErrReplicationOffset = errors.New("unexpected replication offset")
+
+// ServeReplication streams the rows committed to the file to the followers connecting to the listener (see OpenFollower).
+// Followers resume from the end of their file, or are sent the whole file if it doesn't match the primary
+// (for example, after a compaction).
+//
+// The file must be open for writing. ServeReplication returns when the listener is closed, once the followers are disconnected.
+func (f *File) ServeReplication(l net.Listener) error {
+	if f.opts.ReadOnly {
+		return fmt.Errorf("serve replication: %w", ErrReadOnly)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	conns := map[net.Conn]struct{}{}
+	defer func() {
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("accept: %w", err)
+		}
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.replicate(conn) // the follower reconnects on error
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// replicate streams the file to a follower until the connection is closed.
+func (f *File) replicate(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(replicationTimeout))
+	br := bufio.NewReader(conn)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("read handshake: %w", err)
+	}
+	var offset int
+	var want uint64
+	if _, err := fmt.Sscanf(line, replicationHeader+" %d %016x\n", &offset, &want); err != nil {
+		return fmt.Errorf("parse handshake: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// Followers send nothing after the handshake, reading only detects closed connections.
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, br)
+		close(closed)
+	}()
+
+	var h Storage // read handle, reopened when the file is replaced by a compaction
+	defer func() {
+		if h != nil {
+			h.Close()
+		}
+	}()
+	heartbeat := time.NewTicker(ReplicationHeartbeatInterval)
+	defer heartbeat.Stop()
+	bw := bufio.NewWriterSize(conn, 64<<10)
+	compactions, resume := -1, true
+	for {
+		f.mu.RLock()
+		end, replaced, appended := f.woffset, f.compactions != compactions, f.appended.wait()
+		if replaced {
+			if h != nil {
+				h.Close()
+			}
+			h, err = f.opts.Storage(f.fpath, StorageReadOnly)
+			compactions = f.compactions
+		}
+		f.mu.RUnlock()
+		if err != nil {
+			return fmt.Errorf("open read handle: %w", err)
+		}
+
+		// Send the whole file if the file of the follower doesn't match (or was compacted)
+		if replaced {
+			matches := resume && offset <= end
+			if matches {
+				got, err := fingerprint(h, offset)
+				if err != nil {
+					return fmt.Errorf("fingerprint: %w", err)
+				}
+				matches = got == want
+			}
+			if !matches {
+				offset = 0
+				writeFrame(bw, frameReset, uint64(end))
+			}
+			resume = false
+		}
+
+		// Send the appended bytes
+		for offset < end {
+			n := min(end-offset, replicationChunkSize)
+			writeFrame(bw, frameData, uint64(offset), uint64(end), uint64(n))
+			if _, err := io.Copy(bw, io.NewSectionReader(h, int64(offset), int64(n))); err != nil {
+				return fmt.Errorf("send rows: %w", err)
+			}
+			offset += n
+		}
+		if err := bw.Flush(); err != nil {
+			return fmt.Errorf("send rows: %w", err)
+		}
+
+		select {
+		case <-appended:
+		case <-heartbeat.C:
+			writeFrame(bw, frameHeartbeat, uint64(end))
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+// writeFrame writes the type of a frame followed by its integers (uint64 except the length of data frames).
+func writeFrame(w *bufio.Writer, typ byte, values ...uint64) {
+	w.WriteByte(typ)
+	for i, v := range values {
+		if typ == frameData && i == 2 {
+			w.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+			continue
+		}
+		w.Write(binary.BigEndian.AppendUint64(nil, v))
+	}
+}
+
+// fingerprint hashes the bytes preceding the given offset (see replicationFingerprintSize).
+func fingerprint(r io.ReaderAt, offset int) (uint64, error) {
+	start := max(0, offset-replicationFingerprintSize)
+	h := fnv.New64a()
+	n, err := io.Copy(h, io.NewSectionReader(r, int64(start), int64(offset-start)))
+	if err == nil && n != int64(offset-start) {
+		err = io.ErrUnexpectedEOF
+	}
+	return h.Sum64(), err
+}
+
+// Follower is a read-only replica of a file served by another process (see OpenFollower).
+type Follower struct {
+	fpath string
+	addr  string
+	opts  []Option
+	file  *File    // read-only file refreshed when bytes are received
+	w     *os.File // append handle of the replicated bytes
+
+	mu            sync.Mutex
+	conn          net.Conn
+	offset        int   // size of the local file
+	primaryOffset int   // size of the primary file as of the last frame
+	err           error // last replication error (nil while connected)
+	closed        bool
+
+	stop, done chan struct{}
+}
+
+// OpenFollower opens a replica of the file served by ServeReplication at the given address.
+// The local file is created if needed and opened in read-only mode (with the given options),
+// rows are loaded as they are received (watchers are notified, see Watch).
+//
+// The follower reconnects when the connection is lost (see FollowerRetryInterval),
+// it resumes from the end of its file or receives the whole file if it diverged from the primary.
+func OpenFollower(fpath, primaryAddr string, opts ...Option) (*Follower, error) {
+	w, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open datafile: %w", err)
+	}
+	info, err := w.Stat()
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("stat datafile: %w", err)
+	}
+	f, err := OpenReadOnly(fpath, opts...)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	fw := &Follower{
+		fpath:  fpath,
+		addr:   primaryAddr,
+		opts:   opts,
+		file:   f,
+		w:      w,
+		offset: int(info.Size()),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	fw.primaryOffset = fw.offset
+	go fw.run()
+	return fw, nil
+}
+
+// File returns the read-only file of the follower.
+func (fw *Follower) File() *File { return fw.file }
+
+// Lag reports the number of bytes of the primary file that weren't received yet
+// (as of the last message of the primary) and the last replication error (nil while connected).
+func (fw *Follower) Lag() (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.primaryOffset - fw.offset, fw.err
+}
+
+// Promote stops the replication and reopens the file for writing (with the options given to OpenFollower),
+// so that the follower can take over from its primary. The follower is closed.
+func (fw *Follower) Promote() (*File, error) {
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return Open(fw.fpath, 0, fw.opts...)
+}
+
+// Close stops the replication and closes the file (closing a closed follower has no effect).
+func (fw *Follower) Close() error {
+	fw.mu.Lock()
+	if fw.closed {
+		fw.mu.Unlock()
+		return nil
+	}
+	fw.closed = true
+	close(fw.stop)
+	if fw.conn != nil {
+		fw.conn.Close()
+	}
+	fw.mu.Unlock()
+	<-fw.done
+	return errors.Join(fw.w.Sync(), fw.w.Close(), fw.file.Close())
+}
+
+// run follows the primary until the follower is closed.
+func (fw *Follower) run() {
+	defer close(fw.done)
+	for {
+		err := fw.follow()
+		fw.mu.Lock()
+		fw.conn, fw.err = nil, err
+		fw.mu.Unlock()
+		select {
+		case <-fw.stop:
+			return
+		case <-time.After(FollowerRetryInterval):
+		}
+	}
+}
+
+// follow connects to the primary and applies the received bytes until the connection fails.
+func (fw *Follower) follow() error {
+	conn, err := net.DialTimeout("tcp", fw.addr, replicationTimeout)
+	if err != nil {
+		return fmt.Errorf("dial primary: %w", err)
+	}
+	defer conn.Close()
+	fw.mu.Lock()
+	select {
+	case <-fw.stop:
+		fw.mu.Unlock()
+		return nil
+	default:
+	}
+	fw.conn, fw.err = conn, nil
+	offset := fw.offset
+	fw.mu.Unlock()
+
+	r, err := os.Open(fw.fpath)
+	if err != nil {
+		return fmt.Errorf("open datafile: %w", err)
+	}
+	sum, err := fingerprint(r, offset)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("fingerprint: %w", err)
+	}
+	if _, err := fmt.Fprintf(conn, replicationHeader+" %d %016x\n", offset, sum); err != nil {
+		return fmt.Errorf("send handshake: %w", err)
+	}
+
+	// Bytes are appended to the local file, or to the resync file after a reset.
+	var resync *os.File
+	resyncSize := 0
+	defer func() {
+		if resync != nil {
+			resync.Close()
+			os.Remove(resync.Name())
+		}
+	}()
+	dst := fw.w
+	br := bufio.NewReaderSize(conn, 64<<10)
+	header := make([]byte, 20)
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * ReplicationHeartbeatInterval))
+		typ, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("read frame: %w", err)
+		}
+		switch typ {
+		case frameHeartbeat:
+			if _, err := io.ReadFull(br, header[:8]); err != nil {
+				return fmt.Errorf("read heartbeat: %w", err)
+			}
+			fw.mu.Lock()
+			fw.primaryOffset = int(binary.BigEndian.Uint64(header))
+			fw.mu.Unlock()
+			continue
+		case frameReset:
+			if _, err := io.ReadFull(br, header[:8]); err != nil {
+				return fmt.Errorf("read reset: %w", err)
+			}
+			if resync != nil {
+				resync.Close()
+			}
+			resync, err = os.Create(fw.fpath + resyncFileExtension)
+			if err != nil {
+				return fmt.Errorf("create resync file: %w", err)
+			}
+			dst, offset, resyncSize = resync, 0, int(binary.BigEndian.Uint64(header))
+		case frameData:
+			if _, err := io.ReadFull(br, header); err != nil {
+				return fmt.Errorf("read data frame: %w", err)
+			}
+			if got := int(binary.BigEndian.Uint64(header)); got != offset {
+				return fmt.Errorf("%w: got %d instead of %d", ErrReplicationOffset, got, offset)
+			}
+			n := int(binary.BigEndian.Uint32(header[16:]))
+			if _, err := io.CopyN(dst, br, int64(n)); err != nil {
+				return fmt.Errorf("write rows: %w", err)
+			}
+			offset += n
+			fw.mu.Lock()
+			fw.primaryOffset = int(binary.BigEndian.Uint64(header[8:]))
+			if resync == nil {
+				fw.offset = offset
+			}
+			fw.mu.Unlock()
+		default:
+			return fmt.Errorf("unknown frame type %q", typ)
+		}
+
+		// Replace the file once the resync file is complete
+		if resync != nil && offset >= resyncSize {
+			if err := fw.swapResync(resync); err != nil {
+				return err
+			}
+			resync, dst = nil, fw.w
+		}
+		if resync == nil {
+			if err := fw.file.Refresh(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// swapResync replaces the local file with the complete resync file.
+func (fw *Follower) swapResync(resync *os.File) error {
+	err := resync.Sync()
+	if err == nil {
+		err = resync.Close()
+	}
+	if err == nil {
+		err = fsutil.Rename(resync.Name(), fw.fpath)
+	}
+	if err != nil {
+		return fmt.Errorf("replace datafile: %w", err)
+	}
+	w, err := os.OpenFile(fw.fpath, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("open datafile: %w", err)
+	}
+	info, err := w.Stat()
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("stat datafile: %w", err)
+	}
+	fw.w.Close()
+	fw.mu.Lock()
+	fw.w, fw.offset = w, int(info.Size())
+	fw.mu.Unlock()
+	return nil
+}
+
+// broadcast wakes up the goroutines waiting for an event (see File.appended).
+type broadcast struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel closed on the next notification.
+func (b *broadcast) wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ch == nil {
+		b.ch = make(chan struct{})
+	}
+	return b.ch
+}
+
+// notify wakes up the waiting goroutines.
+func (b *broadcast) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ch != nil {
+		close(b.ch)
+		b.ch = nil
+	}
+}
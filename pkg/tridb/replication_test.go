@@ -0,0 +1,94 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplication(t *testing.T) {
+	dir := t.TempDir()
+	primary, err := Open(filepath.Join(dir, "primary.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- primary.ServeReplication(l) }()
+	for i := 0; i < 100; i++ {
+		mustSet(t, primary, fmt.Sprintf("k%03d", i), "v1")
+	}
+
+	// Followers catch up then receive the new commits
+	fw, err := OpenFollower(filepath.Join(dir, "follower.tridb"), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { fw.Close() }()
+	synced := func(key, value string) func() bool {
+		return func() bool {
+			lag, _ := fw.Lag()
+			return lag == 0 && getValue(fw.File(), key) == value
+		}
+	}
+	waitFor(t, synced("k099", "v1"))
+	mustSet(t, primary, "k000", "v2")
+	waitFor(t, synced("k000", "v2"))
+	if err := fw.File().ReadWrite(func(r *Reader, w *Writer) error { return nil }); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("got error %v instead of %v", err, ErrReadOnly)
+	}
+
+	// Compactions and diverging followers resend the whole file
+	if err := primary.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, primary, "k001", "v2")
+	waitFor(t, synced("k001", "v2"))
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	diverged, err := Open(filepath.Join(dir, "follower.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, diverged, "diverged", "")
+	if err := diverged.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fw, err = OpenFollower(filepath.Join(dir, "follower.tridb"), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, primary, "k002", "v2")
+	waitFor(t, func() bool { return synced("k002", "v2")() && getValue(fw.File(), "diverged") == "" })
+
+	// Promoted followers are writable
+	l.Close()
+	if err := <-served; err == nil {
+		t.Fatal("expected error after closing listener")
+	}
+	promoted, err := fw.Promote()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer promoted.Close()
+	mustSet(t, promoted, "k003", "v2")
+	if got := getValue(promoted, "k099"); got != "v1" {
+		t.Fatalf("got value %q after promotion", got)
+	}
+}
+
+func getValue(f *File, key string) (value string) {
+	_ = f.Read(func(r *Reader) error {
+		v, _ := r.Get([]byte(key))
+		value = string(v)
+		return nil
+	})
+	return value
+}
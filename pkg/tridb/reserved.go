@@ -0,0 +1,47 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ReservedPrefix is the key prefix reserved for internal state (ex: cursors).
+// Keys in the reserved keyspace are indexed separately: they are not visible to readers
+// and can't be written with Writer.Set or Writer.Delete.
+const ReservedPrefix = "\x00tridb/"
+
+// ErrReservedKey is returned when trying to write a key in the reserved keyspace.
+var ErrReservedKey = errors.New("reserved key")
+
+// IsReservedKey reports whether the given key belongs to the reserved keyspace.
+func IsReservedKey(key []byte) bool { return bytes.HasPrefix(key, []byte(ReservedPrefix)) }
+
+func reservedKey(name string) []byte { return []byte(ReservedPrefix + name) }
+
+func (w *Writer) setReserved(name string, value []byte) {
+	w.stage(&Row{Key: reservedKey(name), Value: value})
+}
+
+func (w *Writer) deleteReserved(name string) {
+	w.stage(&Row{IsDeleted: true, Key: reservedKey(name)})
+}
+
+func (r *Reader) getReserved(name string) ([]byte, error) {
+	rowInfo := r.sys.Get(reservedKey(name))
+	if rowInfo == nil {
+		return nil, nil
+	}
+	row, err := r.f.readAndDecodeRow(r.ra, rowInfo.Position)
+	if err != nil {
+		return nil, err
+	}
+	return row.Value, nil
+}
+
+// loadPolicies loads the policies stored in the reserved keyspace (prefix TTLs and frozen prefixes).
+func (f *File) loadPolicies() error {
+	if err := f.loadPrefixTTLs(); err != nil {
+		return err
+	}
+	return f.loadFrozenPrefixes()
+}
@@ -0,0 +1,200 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archive layout used by RestoreToTime: a base copy of the datafile (see Snapshot.CopyTo)
+// and log segments holding the bytes appended to the datafile after the base,
+// each named after the offset at which it starts (see ArchiveSegmentName).
+const (
+	ArchiveBaseName         = "base.tridb"
+	ArchiveSegmentExtension = ".segment"
+)
+
+// ArchiveSegmentName returns the name of the archived log segment starting at the given offset.
+func ArchiveSegmentName(offset int) string {
+	return fmt.Sprintf("%020d%s", offset, ArchiveSegmentExtension)
+}
+
+// ErrArchiveGap is returned when archived log segments are not contiguous.
+var ErrArchiveGap = errors.New("gap in archived log segments")
+
+// RestoreToTime creates a new database file at fpath holding the state of the archived database
+// as of the given time: the base followed by the archived rows written at or before that time.
+//
+// Rows are restored up to the first one written after the given time (rows without timestamp are kept),
+// batches are restored entirely or not at all.
+// Note: the archive must be continuous, a compaction of the source file requires a new base.
+func RestoreToTime(archiveDir, fpath string, t time.Time) error {
+	log, err := readArchive(archiveDir)
+	if err != nil {
+		return err
+	}
+	format, err := DetectFormat(bytes.NewReader(log), nil)
+	if err != nil {
+		return fmt.Errorf("detect format: %w", err)
+	}
+
+	// Find the end of the last row written at or before the given time
+	end, r, row := 0, bufio.NewReader(bytes.NewReader(log)), Row{}
+	if h, ok, err := readFileHeader(r); err != nil {
+		return err
+	} else if ok {
+		end = h.size()
+	}
+	for {
+		var n int
+		var timestamp int64
+		if op, _ := r.Peek(1); len(op) == 1 && isCustomOp(op[0]) {
+			_, _, n, err = readCustomOp(r)
+		} else if op, _ := r.Peek(1); len(op) == 1 && op[0] == opBatch {
+			var rows []batchRow
+			rows, n, err = decodeBatchFrom(format, r)
+			if err == nil && len(rows) > 0 {
+				timestamp = rows[len(rows)-1].row.Timestamp
+			}
+		} else {
+			n, err = format.DecodeFrom(r, &row)
+			timestamp = row.Timestamp
+		}
+		if n == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errTornBatch) {
+			break // torn tail
+		}
+		if err != nil {
+			return fmt.Errorf("decode archive at offset %d: %w", end, err)
+		}
+		if timestamp > t.UnixNano() {
+			break
+		}
+		end += n
+	}
+
+	dst, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return fmt.Errorf("create restored file: %w", err)
+	}
+	_, err = dst.Write(log[:end])
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write restored file: %w", err)
+	}
+	return nil
+}
+
+// RestoreFrom creates a new database file at fpath (opened with the given options) holding the rows
+// of the given stream in order, for example the rows of another file (see File.DumpRows):
+//
+//	err := tridb.RestoreFrom(fpath, func(do func(row *tridb.Row) error) error {
+//		_, err := src.DumpRows(0, func(offset int, row *tridb.Row) error { return do(row) })
+//		return err
+//	})
+//
+// Rows are written as they are (with their timestamps, actors and compressed values), in batches of DefaultBulkBatchSize rows.
+// Sealed values can only be read with the keyring of their source (see File.EncryptPrefix)
+// and merge operands can't be restored (the commit fails with ErrNoMergeOperator).
+// The file is removed if the restore fails.
+func RestoreFrom(fpath string, rows func(do func(row *Row) error) error, opts ...Option) (err error) {
+	if _, err := os.Stat(fpath); err == nil {
+		return fmt.Errorf("create restored file: %w", os.ErrExist)
+	}
+	f, err := Open(fpath, 0, opts...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+		if err != nil {
+			os.Remove(fpath)
+			os.Remove(fpath + CleanShutdownFileExtension)
+		}
+	}()
+
+	var pending []*Row
+	flush := func() error {
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			w.NoSync()
+			w.rows = append(w.rows, pending...) // not staged, rows keep their attributes
+			return nil
+		})
+		pending = pending[:0]
+		return err
+	}
+	err = rows(func(row *Row) error {
+		restored := *row
+		restored.Key, restored.Value, restored.raw = bytes.Clone(row.Key), bytes.Clone(row.Value), nil
+		if pending = append(pending, &restored); len(pending) == DefaultBulkBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && len(pending) > 0 {
+		err = flush()
+	}
+	if err != nil {
+		return fmt.Errorf("restore rows: %w", err)
+	}
+	return f.Flush()
+}
+
+// readArchive returns the content of the archived datafile (base and log segments).
+func readArchive(archiveDir string) ([]byte, error) {
+	log, err := os.ReadFile(filepath.Join(archiveDir, ArchiveBaseName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read base: %w", err)
+	}
+
+	entries, err := os.ReadDir(archiveDir)
+	if err != nil {
+		return nil, fmt.Errorf("read archive directory: %w", err)
+	}
+	type segment struct {
+		name   string
+		offset int
+	}
+	var segments []segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ArchiveSegmentExtension) {
+			continue
+		}
+		offset, err := strconv.Atoi(strings.TrimSuffix(name, ArchiveSegmentExtension))
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment name %q", name)
+		}
+		segments = append(segments, segment{name: name, offset: offset})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].offset < segments[j].offset })
+
+	for _, segment := range segments {
+		if segment.offset > len(log) {
+			return nil, fmt.Errorf("%w: %q starts at offset %d but archive ends at %d", ErrArchiveGap, segment.name, segment.offset, len(log))
+		}
+		content, err := os.ReadFile(filepath.Join(archiveDir, segment.name))
+		if err != nil {
+			return nil, fmt.Errorf("read segment: %w", err)
+		}
+		if overlap := len(log) - segment.offset; overlap < len(content) {
+			log = append(log, content[overlap:]...)
+		}
+	}
+	return log, nil
+}
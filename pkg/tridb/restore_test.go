@@ -0,0 +1,84 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreToTime(t *testing.T) {
+	archiveDir := t.TempDir()
+	f := openTestFile(t)
+	mustSet(t, f, "a", "1")
+
+	// Archive base
+	base := &bytes.Buffer{}
+	if _, err := f.CopyTo(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(archiveDir, ArchiveBaseName), base.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Archive log segment
+	mustSet(t, f, "b", "2")
+	time.Sleep(time.Millisecond)
+	restorePoint := time.Now()
+	time.Sleep(time.Millisecond)
+	mustSet(t, f, "a", "updated")
+	content, err := os.ReadFile(f.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	segment := content[base.Len():]
+	if err := os.WriteFile(filepath.Join(archiveDir, ArchiveSegmentName(base.Len())), segment, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	fpath := filepath.Join(t.TempDir(), "restored.tridb")
+	if err := RestoreToTime(archiveDir, fpath, restorePoint); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	assertValue(t, restored, "a", "1")
+	assertValue(t, restored, "b", "2")
+}
+
+func TestBackupSince(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "a", "1")
+	backup := &bytes.Buffer{}
+	offset, err := f.BackupSince(0, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "b", "2")
+	if offset, err = f.BackupSince(offset, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	fpath := filepath.Join(t.TempDir(), "backup.tridb")
+	if err := os.WriteFile(fpath, backup.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	assertValue(t, restored, "b", "2")
+
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.BackupSince(offset+1, backup); !errors.Is(err, ErrBackupOffset) {
+		t.Fatalf("got error %v instead of %v", err, ErrBackupOffset)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 )
 
+// RandID is a random identifier (see package tridbid for time-sortable identifiers).
 type RandID []byte
 
 func NewRandID(length int) (RandID, error) {
@@ -0,0 +1,63 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrPanic  = errors.New("recovered panic") // Wraps panics recovered by SafeRead and SafeReadWrite.
+	ErrFailed = errors.New("file failed")     // Returned when using a file marked as failed.
+)
+
+// SafeReadWrite is like ReadWrite but recovers panics raised by the callback and returns them as errors wrapping ErrPanic.
+//
+// When the recovered panic is caused by a corruption (ErrMemoryCorruption or ErrFileCorruption),
+// the file is marked as failed and all subsequent transactions fail with ErrFailed.
+// The file should then be closed and re-opened.
+func (f *File) SafeReadWrite(do func(r *Reader, w *Writer) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = f.recovered(v)
+		}
+	}()
+	return f.ReadWrite(do)
+}
+
+// SafeRead is like Read but recovers panics raised by the callback and returns them as errors wrapping ErrPanic.
+func (f *File) SafeRead(do func(r *Reader) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = f.recovered(v)
+		}
+	}()
+	return f.Read(do)
+}
+
+// Err returns the error that caused the file to be marked as failed, ErrClosed once the file is closed (or nil).
+func (f *File) Err() error {
+	f.failMu.Lock()
+	defer f.failMu.Unlock()
+	return f.failure
+}
+
+func (f *File) recovered(v any) error {
+	cause, ok := v.(error)
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrPanic, v)
+	}
+	err := fmt.Errorf("%w: %w", ErrPanic, cause)
+	if errors.Is(cause, ErrMemoryCorruption) || errors.Is(cause, ErrFileCorruption) {
+		f.fail(cause)
+	}
+	return err
+}
+
+// fail marks the file as failed (if it isn't already).
+func (f *File) fail(cause error) {
+	f.failMu.Lock()
+	defer f.failMu.Unlock()
+	if f.failure == nil {
+		f.failure = fmt.Errorf("%w: %w", ErrFailed, cause)
+	}
+}
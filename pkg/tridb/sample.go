@@ -0,0 +1,72 @@
+package tridb
+
+import (
+	"math/rand"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// RandomKey returns a key chosen uniformly at random, or nil if there are no keys (see Sample).
+func (r *Reader) RandomKey() ([]byte, error) {
+	keys, err := r.Sample(1)
+	if len(keys) == 0 {
+		return nil, err
+	}
+	return keys[0], err
+}
+
+// Sample returns n distinct keys chosen uniformly at random (or all keys if there are fewer), in random order,
+// for example to probe a large database or to check the quality of its data. Expired keys are never returned.
+//
+// Note: with the trie keydir (see WithKeydir), keys are picked by rank thanks to the subtree counts,
+// in O(n * key depth) as long as n is at most half of the keys. Otherwise, all keys are walked.
+func (r *Reader) Sample(n int) ([][]byte, error) {
+	if r.f.opts.KeySecret != nil {
+		return nil, ErrHashedKeys
+	}
+	r.checkDeadline()
+	if n <= 0 {
+		return nil, nil
+	}
+	var rows []*fidx.RowInfo
+	count := r.idx.Chronological().Count
+	if selector, ok := r.idx.(fidx.KeySelector); ok && n <= count/2 {
+		// Draw distinct ranks, expired keys are drawn again
+		drawn := map[int]bool{}
+		for len(rows) < n && len(drawn) < count {
+			i := rand.Intn(count)
+			if drawn[i] {
+				continue
+			}
+			drawn[i] = true
+			if row := selector.Nth(i); row != nil && !r.expired(row) {
+				rows = append(rows, row)
+			}
+		}
+	} else {
+		// Reservoir sampling
+		visited := 0
+		err := r.walkRange(nil, nil, false, func(row *fidx.RowInfo) error {
+			if visited++; len(rows) < n {
+				rows = append(rows, row)
+			} else if i := rand.Intn(visited); i < n {
+				rows[i] = row
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		rand.Shuffle(len(rows), func(i, j int) { rows[i], rows[j] = rows[j], rows[i] })
+	}
+
+	keys := make([][]byte, len(rows))
+	for i, row := range rows {
+		key, err := r.rowKey(row)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
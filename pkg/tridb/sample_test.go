@@ -0,0 +1,72 @@
+package tridb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSample(t *testing.T) {
+	for _, keydir := range []KeydirType{KeydirHash, KeydirTrie} {
+		f := openTestFile(t, WithKeydir(keydir))
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			for i := 0; i < 100; i++ {
+				w.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("v"))
+			}
+			w.SetWithTTL([]byte("expired"), []byte("v"), time.Nanosecond)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+
+		seen := map[string]int{}
+		err = f.Read(func(r *Reader) error {
+			for _, n := range []int{10, 80, 200} {
+				keys, err := r.Sample(n)
+				if err != nil {
+					return err
+				}
+				if want := min(n, 100); len(keys) != want {
+					t.Fatalf("got %d keys instead of %d", len(keys), want)
+				}
+				distinct := map[string]bool{}
+				for _, key := range keys {
+					if distinct[string(key)] || string(key) == "expired" {
+						t.Fatalf("got duplicate or expired key %q", key)
+					}
+					distinct[string(key)] = true
+				}
+			}
+			for i := 0; i < 1000; i++ {
+				key, err := r.RandomKey()
+				if err != nil {
+					return err
+				}
+				seen[string(key)]++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(seen) < 90 || seen["expired"] > 0 {
+			t.Fatalf("random keys are not uniform (%d distinct keys in 1000 draws)", len(seen))
+		}
+	}
+}
+
+func TestRandomKeyEmpty(t *testing.T) {
+	f := openTestFile(t, WithKeydir(KeydirTrie))
+	err := f.Read(func(r *Reader) error {
+		key, err := r.RandomKey()
+		if key != nil {
+			t.Fatalf("got %q in an empty file", key)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,43 @@
+package tridb
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrInvalidSavepoint aborts transactions rolled back to a savepoint of another writer
+// or to a savepoint discarded by an earlier rollback (see Writer.RollbackTo).
+var ErrInvalidSavepoint = errors.New("invalid savepoint")
+
+// Savepoint is the state of a writer restored by Writer.RollbackTo.
+type Savepoint struct {
+	w                                                     *Writer
+	gen                                                   uint64 // see Writer.savepoints
+	rows, pendingBytes, conditions, renames, deletes, ops int
+	err                                                   error
+}
+
+// Savepoint returns the current state of the writer, so that the operations staged afterwards can be undone
+// with RollbackTo (ex: after a failed validation of one record in a loop) without aborting the whole transaction.
+func (w *Writer) Savepoint() Savepoint {
+	w.savepointGen++
+	w.savepoints = append(w.savepoints, w.savepointGen)
+	return Savepoint{w: w, gen: w.savepointGen, rows: len(w.rows), pendingBytes: w.pendingBytes, conditions: len(w.conditions), renames: len(w.renames), deletes: len(w.deletes), ops: len(w.ops), err: w.err}
+}
+
+// RollbackTo undoes the operations staged since the given savepoint, including the invalid ones that would abort the transaction
+// (ex: a value too long). The writer settings (ex: SetActor and NoSync) are kept.
+// Savepoints taken after the given one are discarded.
+func (w *Writer) RollbackTo(sp Savepoint) {
+	i, found := slices.BinarySearch(w.savepoints, sp.gen)
+	if sp.w != w || !found {
+		if w.err == nil {
+			w.err = ErrInvalidSavepoint
+		}
+		return
+	}
+	w.savepoints = w.savepoints[:i+1]
+	clear(w.rows[sp.rows:]) // staged values can be released
+	w.rows, w.pendingBytes, w.err = w.rows[:sp.rows], sp.pendingBytes, sp.err
+	w.conditions, w.renames, w.deletes, w.ops = w.conditions[:sp.conditions], w.renames[:sp.renames], w.deletes[:sp.deletes], w.ops[:sp.ops]
+}
@@ -0,0 +1,76 @@
+package tridb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSavepoint(t *testing.T) {
+	f := openTestFile(t, WithMaxValueLength(8))
+	mustSet(t, f, "c", "3")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		for _, record := range []struct{ key, value string }{{"a", "1"}, {"b", "too long value"}} {
+			sp := w.Savepoint()
+			w.SetIfAbsent([]byte("c"), []byte(record.key)) // fails on commit
+			w.Set([]byte(record.key), []byte(record.value))
+			w.RollbackTo(sp)
+			w.Set([]byte(record.key), []byte(record.value))
+			if len(record.value) > 8 {
+				w.RollbackTo(sp)
+			}
+		}
+		if w.PendingRows() != 1 {
+			t.Fatalf("got %d pending rows instead of 1", w.PendingRows())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "a", "1")
+	assertValue(t, f, "c", "3")
+	err = f.Read(func(r *Reader) error {
+		if v, err := r.Get([]byte("b")); err != nil || v != nil {
+			t.Fatalf("got %q, %v for a rolled back key", v, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSavepointDiscarded(t *testing.T) {
+	f := openTestFile(t)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		first := w.Savepoint()
+		w.Set([]byte("a"), []byte("1"))
+		second := w.Savepoint()
+		w.Set([]byte("b"), []byte("2"))
+		w.RollbackTo(first)
+		w.RollbackTo(second)
+		return nil
+	})
+	if !errors.Is(err, ErrInvalidSavepoint) {
+		t.Fatalf("got %v instead of ErrInvalidSavepoint", err)
+	}
+
+	// The rows staged after the rollback must not make the discarded savepoint valid again.
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		first := w.Savepoint()
+		w.Set([]byte("a"), []byte("1"))
+		second := w.Savepoint()
+		w.Set([]byte("b"), []byte("2"))
+		w.RollbackTo(first)
+		w.Set([]byte("c"), []byte("3"))
+		w.Set([]byte("d"), []byte("4"))
+		w.RollbackTo(second)
+		if w.PendingRows() != 2 {
+			t.Fatalf("got %d pending rows instead of 2", w.PendingRows())
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrInvalidSavepoint) {
+		t.Fatalf("got %v instead of ErrInvalidSavepoint", err)
+	}
+}
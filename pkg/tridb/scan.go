@@ -0,0 +1,84 @@
+package tridb
+
+import (
+	"io"
+	"slices"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// scanReadAhead is the size of the reads of Reader.ScanWithValue.
+const scanReadAhead = 1 << 20
+
+// ScanWithValue is like WalkWithValue but visits keys in the order their rows were written (by offset in the file)
+// instead of lexicographical order, so that the file is read sequentially by large reads instead of one read per key.
+// It is meant for full-table scans (ex: analytics jobs) of files that don't fit in the page cache.
+//
+// Note: the positions of the matching keys are collected (and sorted) before reading their values.
+func (r *Reader) ScanWithValue(prefix []byte, do func(key, value []byte) error) (int, error) {
+	var rows []*fidx.RowInfo
+	err := r.walkRange(prefix, fidx.PrefixEnd(prefix), false, func(row *fidx.RowInfo) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	slices.SortFunc(rows, func(a, b *fidx.RowInfo) int { return a.Position.Offset() - b.Position.Offset() })
+
+	defer func() {
+		if ahead, ok := r.ra.(*readAhead); ok {
+			r.ra = ahead.ra
+		}
+	}()
+	visited := 0
+	for _, row := range rows {
+		if err := r.ctx.Err(); err != nil {
+			return visited, err
+		}
+		// Mapped files are read from memory, the reader may detach from the lock during the scan (see checkDeadline).
+		r.checkDeadline()
+		switch r.ra.(type) {
+		case *readAhead, *mapping:
+		default:
+			r.ra = &readAhead{ra: r.ra, buf: make([]byte, min(scanReadAhead, r.size))}
+		}
+		key, err := r.rowKey(row)
+		if err != nil {
+			return visited, err
+		}
+		value, err := r.readValue(key, row.Position)
+		if err != nil {
+			return visited, err
+		}
+		visited++
+		if err := do(key, value); err != nil {
+			return visited, ignoreBreak(err)
+		}
+	}
+	return visited, nil
+}
+
+// readAhead serves reads from a window read ahead of them,
+// so that reads by increasing offsets issue a few large reads instead of one read each.
+type readAhead struct {
+	ra     io.ReaderAt
+	buf    []byte // holds the window
+	window []byte
+	offset int64 // offset of the window
+}
+
+func (a *readAhead) ReadAt(p []byte, off int64) (int, error) {
+	if off >= a.offset && off+int64(len(p)) <= a.offset+int64(len(a.window)) {
+		return copy(p, a.window[off-a.offset:]), nil
+	}
+	if off < a.offset || len(p) > len(a.buf) {
+		return a.ra.ReadAt(p, off) // backward read (ex: alias target) or row larger than the window
+	}
+	n, err := a.ra.ReadAt(a.buf, off)
+	a.window, a.offset = a.buf[:n], off
+	if n < len(p) {
+		return copy(p, a.window), err
+	}
+	return copy(p, a.window), nil
+}
@@ -0,0 +1,57 @@
+package tridb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestScanWithValue(t *testing.T) {
+	for name, opts := range map[string][]Option{"default": nil, "mmap": {WithMmapReads(true)}} {
+		t.Run(name, func(t *testing.T) {
+			f := openTestFile(t, opts...)
+			mustSet(t, f, "k/c", "3")
+			mustSet(t, f, "k/a", "1")
+			mustSet(t, f, "other", "x")
+			mustSet(t, f, "k/b", "2")
+			mustSet(t, f, "k/c", "4") // moves to the end of the file
+			err := f.ReadWrite(func(r *Reader, w *Writer) error {
+				w.Alias([]byte("k/d"), []byte("k/a")) // its target was written before
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			err = f.Read(func(r *Reader) error {
+				_, err := r.ScanWithValue([]byte("k/"), func(key, value []byte) error {
+					got = append(got, string(key)+"="+string(value))
+					return nil
+				})
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"k/a=1", "k/b=2", "k/c=4", "k/d=1"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %q instead of %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	ahead := &readAhead{ra: bytes.NewReader(content), buf: make([]byte, 8)}
+	for _, read := range []struct{ off, size int }{
+		{0, 3}, {3, 5}, {8, 4}, {2, 3}, {10, 10}, {16, 4}, {18, 4},
+	} {
+		p := make([]byte, read.size)
+		n, _ := ahead.ReadAt(p, int64(read.off))
+		want := content[read.off:min(len(content), read.off+read.size)]
+		if !bytes.Equal(p[:n], want) {
+			t.Fatalf("read %d bytes at %d: got %q instead of %q", read.size, read.off, p[:n], want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package tridb
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Scrubber defaults.
+const (
+	DefaultScrubRate         = 100 // rows per second
+	DefaultScrubPassInterval = time.Minute
+)
+
+// ScrubOptions configures a background scrubber.
+type ScrubOptions struct {
+	RowsPerSecond int           // Maximum number of rows checked per second (defaults to DefaultScrubRate).
+	PassInterval  time.Duration // Pause between two passes over all rows (defaults to DefaultScrubPassInterval).
+
+	// OnCorruption is called (from the scrubber goroutine) for each corrupted live row.
+	// With hashed keys (see WithHashedKeys), the key is the HMAC if the row can't be decoded.
+	OnCorruption func(key []byte, err error)
+	// Repair optionally returns the correct value of a corrupted key (for example, from a replica or a backup),
+	// the value is then rewritten. A nil value means the key can't be repaired.
+	Repair func(key []byte) ([]byte, error)
+}
+
+// ScrubStats holds the progress of a scrubber.
+type ScrubStats struct {
+	Passes      int // Number of complete passes over all rows.
+	RowsChecked int
+	Corruptions int
+	Repairs     int
+}
+
+// Scrubber continuously verifies live rows in the background (see File.StartScrubber).
+type Scrubber struct {
+	f     *File
+	opts  ScrubOptions
+	stop  chan struct{}
+	done  chan struct{}
+	mu    sync.Mutex
+	stats ScrubStats
+}
+
+// StartScrubber starts a low-priority background goroutine iterating over live rows at a limited rate,
+// verifying that they can be decoded, that they match the keydir and that their checksum is valid
+// (see WithRowChecksums), so silent corruption is found before a user read hits it.
+//
+// Each pass iterates over a snapshot of the keydir, it doesn't block writers.
+// The scrubber must be stopped before closing the file.
+func (f *File) StartScrubber(opts ScrubOptions) *Scrubber {
+	if opts.RowsPerSecond <= 0 {
+		opts.RowsPerSecond = DefaultScrubRate
+	}
+	if opts.PassInterval <= 0 {
+		opts.PassInterval = DefaultScrubPassInterval
+	}
+	s := &Scrubber{f: f, opts: opts, stop: make(chan struct{}), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+// Stop stops the scrubber and waits for it to return.
+func (s *Scrubber) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// Stats returns the progress of the scrubber.
+func (s *Scrubber) Stats() ScrubStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *Scrubber) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Second / time.Duration(s.opts.RowsPerSecond))
+	defer ticker.Stop()
+	for {
+		if !s.pass(ticker) {
+			return
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(s.opts.PassInterval):
+		}
+	}
+}
+
+// pass checks all rows of a snapshot, it reports false if the scrubber was stopped.
+func (s *Scrubber) pass(ticker *time.Ticker) bool {
+	snap, err := s.f.Snapshot()
+	if err != nil {
+		return true // the file failed, retry on next pass
+	}
+	defer snap.Close()
+	for _, idx := range [...]fidx.Keydir{snap.sys, snap.idx} {
+		for rowInfo := idx.Chronological().Oldest; rowInfo != nil; rowInfo = rowInfo.Next {
+			select {
+			case <-s.stop:
+				return false
+			case <-ticker.C:
+			}
+			s.check(snap, idx == snap.sys, rowInfo)
+		}
+	}
+	s.mu.Lock()
+	s.stats.Passes++
+	s.mu.Unlock()
+	return true
+}
+
+// check verifies a single row and reports (and repairs) it if it is corrupted.
+func (s *Scrubber) check(snap *Snapshot, reserved bool, rowInfo *fidx.RowInfo) {
+	f := snap.f
+	key := rowInfo.Key
+	row, err := f.readAndDecodeRow(snap.h, rowInfo.Position)
+	if err == nil {
+		key = row.Key
+		if row.IsDeleted || !bytes.Equal(f.indexKey(row.Key), rowInfo.Key) {
+			err = fmt.Errorf("%w: found row for %q at offset %d", ErrIndexMismatch, row.Key, rowInfo.Position.Offset())
+		}
+	}
+	s.mu.Lock()
+	s.stats.RowsChecked++
+	s.mu.Unlock()
+	if err == nil {
+		return
+	}
+
+	// Ignore rows overwritten since the snapshot was taken
+	f.mu.RLock()
+	live := f.idx
+	if reserved {
+		live = f.sys
+	}
+	current := live.Get(rowInfo.Key)
+	f.mu.RUnlock()
+	if current == nil || current.Position != rowInfo.Position {
+		return
+	}
+
+	s.mu.Lock()
+	s.stats.Corruptions++
+	s.mu.Unlock()
+	if s.opts.OnCorruption != nil {
+		s.opts.OnCorruption(key, err)
+	}
+	if s.opts.Repair == nil || reserved || (f.opts.KeySecret != nil && row == nil) {
+		return
+	}
+	value, err := s.opts.Repair(key)
+	if err != nil || value == nil {
+		return
+	}
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Set(key, value)
+		return nil
+	})
+	if err == nil {
+		s.mu.Lock()
+		s.stats.Repairs++
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,58 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestScrubber(t *testing.T) {
+	f := openTestFile(t, WithRowChecksums(true))
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "b", "2")
+
+	// Corrupt the value of "b" on disk.
+	var offset int
+	_ = f.Read(func(r *Reader) error {
+		p := r.get([]byte("b")).Position
+		offset = p.Offset() + p.Size() - 1
+		return nil
+	})
+	fw, err := os.OpenFile(f.Path(), os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.WriteAt([]byte("X"), int64(offset)); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+	_ = f.Read(func(r *Reader) error {
+		if _, err := r.Get([]byte("b")); !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("got error %v instead of %v", err, ErrChecksumMismatch)
+		}
+		return nil
+	})
+
+	corrupted := make(chan string, 1)
+	s := f.StartScrubber(ScrubOptions{
+		RowsPerSecond: 1000,
+		OnCorruption:  func(key []byte, err error) { corrupted <- string(key) },
+		Repair:        func(key []byte) ([]byte, error) { return []byte("2"), nil },
+	})
+	select {
+	case key := <-corrupted:
+		if key != "b" {
+			t.Fatalf("got corrupted key %q instead of %q", key, "b")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("corruption not found by scrubber")
+	}
+	for deadline := time.Now().Add(5 * time.Second); s.Stats().Repairs == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("corruption not repaired by scrubber")
+		}
+	}
+	s.Stop()
+	assertValue(t, f, "b", "2")
+}
@@ -0,0 +1,187 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Shard files are named after their index: "shard-" + index (3 digits at least) + ".tridb".
+const shardFileFormat = "shard-%03d.tridb"
+
+// ErrCrossShard is returned when a transaction on a shard writes keys of other shards.
+var ErrCrossShard = errors.New("key of another shard")
+
+// ShardedFile is a database split into a fixed number of files (shards) in a directory, keys are assigned to shards
+// by hash. Shards are written, synced and compacted independently, so write throughput and compactions scale
+// with the number of cores.
+//
+// Transactions are limited to a single shard, walks merge all shards in lexicographical order
+// (each shard is read by pages in its own transactions).
+type ShardedFile struct {
+	dir    string
+	shards []*File
+	mu     sync.Mutex
+	closed bool
+}
+
+// OpenSharded opens the sharded database in the given directory (created if needed) with the given number of shards,
+// shards are opened with the given number of hash keydir buckets and options.
+// The number of shards can't be changed once the database is created.
+func OpenSharded(dir string, numShards, numBuckets int, opts ...Option) (_ *ShardedFile, err error) {
+	if numShards < 1 {
+		return nil, fmt.Errorf("invalid number of shards: %d", numShards)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "shard-*.tridb"))
+	if err != nil {
+		return nil, fmt.Errorf("list shards: %w", err)
+	}
+	if len(existing) > 0 && len(existing) != numShards {
+		return nil, fmt.Errorf("found %d shards instead of %d (keys are assigned to shards by hash)", len(existing), numShards)
+	}
+	s := &ShardedFile{dir: dir, shards: make([]*File, 0, numShards)}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+	for i := 0; i < numShards; i++ {
+		f, err := Open(filepath.Join(dir, fmt.Sprintf(shardFileFormat, i)), numBuckets, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("open shard %d: %w", i, err)
+		}
+		s.shards = append(s.shards, f)
+	}
+	return s, nil
+}
+
+// ShardOf returns the index of the shard of the given key: the FNV-1a hash of the key modulo the number of shards.
+func (s *ShardedFile) ShardOf(key []byte) int {
+	h := fnv.New64a()
+	h.Write(key)
+	return int(h.Sum64() % uint64(len(s.shards)))
+}
+
+// Shard returns the file of the shard at the given index, for example to back it up.
+func (s *ShardedFile) Shard(i int) *File { return s.shards[i] }
+
+// NumShards returns the number of shards.
+func (s *ShardedFile) NumShards() int { return len(s.shards) }
+
+// Read executes a read-only transaction on the shard of the given key.
+// The reader doesn't see the keys of other shards.
+func (s *ShardedFile) Read(key []byte, do func(r *Reader) error) error {
+	return s.shards[s.ShardOf(key)].Read(do)
+}
+
+// ReadWrite executes a read-write transaction on the shard of the given key,
+// the transaction is aborted with ErrCrossShard if it writes keys of other shards.
+func (s *ShardedFile) ReadWrite(key []byte, do func(r *Reader, w *Writer) error) error {
+	shard := s.ShardOf(key)
+	return s.shards[shard].ReadWrite(func(r *Reader, w *Writer) error {
+		if err := do(r, w); err != nil {
+			return err
+		}
+		for _, row := range w.rows {
+			if !IsReservedKey(row.Key) && s.ShardOf(row.Key) != shard {
+				return fmt.Errorf("%w: %q (shard %d)", ErrCrossShard, row.Key, shard)
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns the value of the given key (nil if not found).
+func (s *ShardedFile) Get(key []byte) (value []byte, err error) {
+	err = s.Read(key, func(r *Reader) error {
+		value, err = r.Get(key)
+		return err
+	})
+	return value, err
+}
+
+// Set sets the value of the given key.
+func (s *ShardedFile) Set(key, value []byte) error {
+	return s.ReadWrite(key, func(r *Reader, w *Writer) error {
+		w.Set(key, value)
+		return nil
+	})
+}
+
+// Delete deletes the given key.
+func (s *ShardedFile) Delete(key []byte) error {
+	return s.ReadWrite(key, func(r *Reader, w *Writer) error {
+		w.Delete(key)
+		return nil
+	})
+}
+
+// Walk calls do for each key starting with the given prefix and its value, in lexicographical order
+// (see Reader.Walk for errors). The keys of all shards are merged, each shard is read by pages in its own transactions.
+func (s *ShardedFile) Walk(prefix []byte, do func(key, value []byte) error) error {
+	pages := make([]*walkPages, len(s.shards))
+	for i, f := range s.shards {
+		pages[i] = &walkPages{f: f, prefix: prefix}
+	}
+	for {
+		var next *walkPages // shard with the lowest next key
+		var nextKey []byte
+		for _, shard := range pages {
+			key, err := shard.peek()
+			if err != nil {
+				return err
+			}
+			if key != nil && (next == nil || bytes.Compare(key, nextKey) < 0) {
+				next, nextKey = shard, key
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		if err := do(next.keys[0], next.values[0]); err != nil {
+			return ignoreBreak(err)
+		}
+		next.keys, next.values = next.keys[1:], next.values[1:]
+	}
+}
+
+// Compact compacts all shards concurrently (see File.Compact).
+func (s *ShardedFile) Compact() error {
+	errs := make([]error, len(s.shards))
+	wg := sync.WaitGroup{}
+	for i, f := range s.shards {
+		wg.Add(1)
+		go func(i int, f *File) {
+			defer wg.Done()
+			if err := f.Compact(); err != nil {
+				errs[i] = fmt.Errorf("compact shard %d: %w", i, err)
+			}
+		}(i, f)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Close closes every shard, it returns ErrClosed if the file was already closed.
+func (s *ShardedFile) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.closed = true
+	var errs []error
+	for i, f := range s.shards {
+		if err := f.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close shard %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,90 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestShardedFile(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSharded(dir, 4, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for i := 0; i < 3*walkPageSize; i++ {
+		key := fmt.Sprintf("key/%04d", i)
+		keys = append(keys, key)
+		if err := s.Set([]byte(key), []byte("v:"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < s.NumShards(); i++ {
+		if n := s.Shard(i).Stats().Keys; n == 0 || n == len(keys) {
+			t.Fatalf("got %d keys in shard %d", n, i)
+		}
+	}
+	if err := s.Delete([]byte("key/0000")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := s.Get([]byte("key/0001")); err != nil || string(value) != "v:key/0001" {
+		t.Fatalf("got value %q (%v)", value, err)
+	}
+
+	// Walks merge the keys of all shards in lexicographical order
+	var got []string
+	err = s.Walk([]byte("key/"), func(key, value []byte) error {
+		if string(value) != "v:"+string(key) {
+			t.Fatalf("got value %q for key %q", value, key)
+		}
+		got = append(got, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sort.StringsAreSorted(got) || !reflect.DeepEqual(got, keys[1:]) {
+		t.Fatalf("got %d keys instead of %d in order", len(got), len(keys)-1)
+	}
+	visited := 0
+	err = s.Walk(nil, func(key, value []byte) error {
+		if visited++; visited == 10 {
+			return ErrBreak
+		}
+		return nil
+	})
+	if err != nil || visited != 10 {
+		t.Fatalf("visited %d keys (%v) instead of 10", visited, err)
+	}
+
+	err = s.ReadWrite([]byte("key/0001"), func(r *Reader, w *Writer) error {
+		for _, key := range keys {
+			w.Set([]byte(key), nil)
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrCrossShard) {
+		t.Fatalf("got error %v instead of %v", err, ErrCrossShard)
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenSharded(dir, 8, 16); err == nil {
+		t.Fatal("expected an error when opening with another number of shards")
+	}
+	s, err = OpenSharded(dir, 4, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if value, err := s.Get([]byte("key/0002")); err != nil || string(value) != "v:key/0002" {
+		t.Fatalf("got value %q (%v) after reopening", value, err)
+	}
+}
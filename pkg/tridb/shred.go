@@ -0,0 +1,227 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ejuju/tridb/pkg/fsutil"
+)
+
+// KeyringFileExtension is appended to the datafile path to get the path of the keyring file.
+//
+// The keyring holds the data keys of encrypted prefixes (see File.EncryptPrefix),
+// wrapped (AES-256-GCM) with the master key given to WithPrefixEncryption.
+// It is kept out of the datafile so that destroying a data key (see File.Shred)
+// doesn't require rewriting the log, nor the backups of the datafile.
+const KeyringFileExtension = ".keyring"
+
+// Prefix encryption errors.
+var (
+	ErrNoMasterKey = errors.New("prefix encryption is disabled")                   // See WithPrefixEncryption.
+	ErrShredded    = errors.New("value encrypted with a destroyed or unknown key") // See File.Shred.
+)
+
+// keyring holds the data keys of encrypted prefixes.
+type keyring struct {
+	mu      sync.RWMutex
+	fpath   string
+	master  cipher.AEAD
+	keys    map[string]cipher.AEAD // by prefix
+	wrapped map[string][]byte      // encrypted data keys (as stored in the keyring file)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealValue encrypts data with a random nonce (prepended to the ciphertext).
+func sealValue(aead cipher.AEAD, data, additionalData []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Errorf("read random nonce: %w", err))
+	}
+	return aead.Seal(nonce, nonce, data, additionalData)
+}
+
+func openValue(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// loadKeyring reads the keyring file (a missing file is an empty keyring).
+// Each line holds a hex-encoded prefix and the hex-encoded wrapped data key.
+func loadKeyring(fpath string, masterKey []byte) (*keyring, error) {
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	kr := &keyring{fpath: fpath, master: master, keys: map[string]cipher.AEAD{}, wrapped: map[string][]byte{}}
+	content, err := os.ReadFile(fpath)
+	if errors.Is(err, os.ErrNotExist) {
+		return kr, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for i, line := range bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var prefix, wrapped []byte
+		_, err := fmt.Sscanf(string(line), "%x %x", &prefix, &wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		key, err := openValue(master, wrapped, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap data key of prefix %q: %w", prefix, err)
+		}
+		kr.keys[string(prefix)], err = newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data key of prefix %q: %w", prefix, err)
+		}
+		kr.wrapped[string(prefix)] = wrapped
+	}
+	return kr, nil
+}
+
+// save atomically replaces the keyring file at the given path.
+// It must be called with the keyring lock held.
+func (kr *keyring) save(fpath string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fpath), ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	bufw := bufio.NewWriter(tmp)
+	for prefix, wrapped := range kr.wrapped {
+		fmt.Fprintf(bufw, "%x %x\n", prefix, wrapped)
+	}
+	err = bufw.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return fsutil.Rename(tmp.Name(), fpath)
+}
+
+// keyFor returns the data key of the longest encrypted prefix of the given key (or nil).
+func (kr *keyring) keyFor(key []byte) cipher.AEAD {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	var found cipher.AEAD
+	longest := -1
+	for prefix, aead := range kr.keys {
+		if len(prefix) > longest && bytes.HasPrefix(key, []byte(prefix)) {
+			found, longest = aead, len(prefix)
+		}
+	}
+	return found
+}
+
+// sealRow encrypts the row value if the key belongs to an encrypted prefix.
+func (kr *keyring) sealRow(row *Row) {
+	if kr == nil || row.IsDeleted || row.IsAlias || IsReservedKey(row.Key) {
+		return
+	}
+	if aead := kr.keyFor(row.Key); aead != nil {
+		row.Value, row.IsSealed = sealValue(aead, row.Value, row.Key), true
+	}
+}
+
+// openRow returns the decrypted value of a sealed row.
+func (kr *keyring) openRow(row *Row) ([]byte, error) {
+	if kr == nil {
+		return nil, fmt.Errorf("%w: %q is encrypted", ErrNoMasterKey, row.Key)
+	}
+	aead := kr.keyFor(row.Key)
+	if aead == nil {
+		return nil, fmt.Errorf("%w: %q", ErrShredded, row.Key)
+	}
+	value, err := openValue(aead, row.Value, row.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrShredded, row.Key, err)
+	}
+	return value, nil
+}
+
+// EncryptPrefix generates a data key for the given prefix: values of keys starting with the prefix
+// that are written afterwards are encrypted with it (existing values are left as is).
+// Keys matching several encrypted prefixes use the key of the longest one.
+func (f *File) EncryptPrefix(prefix []byte) error {
+	kr := f.keyring
+	if kr == nil {
+		return ErrNoMasterKey
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[string(prefix)]; ok {
+		return fmt.Errorf("prefix %q is already encrypted", prefix)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("generate data key: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	kr.keys[string(prefix)], kr.wrapped[string(prefix)] = aead, sealValue(kr.master, key, prefix)
+	if err := kr.save(kr.fpath); err != nil {
+		delete(kr.keys, string(prefix))
+		delete(kr.wrapped, string(prefix))
+		return fmt.Errorf("save keyring: %w", err)
+	}
+	return nil
+}
+
+// Shred destroys the data key of the given encrypted prefix (crypto-shredding):
+// all the values that were encrypted with it become unreadable (reads fail with ErrShredded),
+// including the ones of overwritten rows not yet removed by compaction and the ones in backups of the datafile.
+// The keys under the prefix are then deleted.
+func (f *File) Shred(prefix []byte) error {
+	kr := f.keyring
+	if kr == nil {
+		return ErrNoMasterKey
+	}
+	kr.mu.Lock()
+	if _, ok := kr.keys[string(prefix)]; !ok {
+		kr.mu.Unlock()
+		return fmt.Errorf("prefix %q is not encrypted", prefix)
+	}
+	wrapped := kr.wrapped[string(prefix)]
+	delete(kr.wrapped, string(prefix))
+	if err := kr.save(kr.fpath); err != nil {
+		kr.wrapped[string(prefix)] = wrapped
+		kr.mu.Unlock()
+		return fmt.Errorf("save keyring: %w", err)
+	}
+	delete(kr.keys, string(prefix))
+	kr.mu.Unlock()
+
+	return f.ReadWrite(func(r *Reader, w *Writer) error {
+		_, err := r.Walk(prefix, func(key []byte) error {
+			w.Delete(bytes.Clone(key))
+			return nil
+		})
+		return err
+	})
+}
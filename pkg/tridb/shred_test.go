@@ -0,0 +1,72 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShred(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	masterKey := bytes.Repeat([]byte{1}, 32)
+	f, err := Open(fpath, 10, WithPrefixEncryption(masterKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.EncryptPrefix([]byte("users/1/")); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "users/1/email", "alice@example.com")
+	mustSet(t, f, "users/2/email", "bob@example.com")
+	content, _ := os.ReadFile(fpath)
+	if bytes.Contains(content, []byte("alice")) {
+		t.Fatal("encrypted value found in plaintext in the datafile")
+	}
+	assertValue(t, f, "users/1/email", "alice@example.com")
+
+	// Values remain readable after reopening
+	f.Close()
+	f, err = Open(fpath, 10, WithPrefixEncryption(masterKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	assertValue(t, f, "users/1/email", "alice@example.com")
+	backup := &bytes.Buffer{}
+	if _, err := f.CopyTo(backup); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Shred([]byte("users/1/")); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "users/1/email", "")
+	assertValue(t, f, "users/2/email", "bob@example.com")
+
+	// Historical rows (in backups) are unreadable
+	restored := filepath.Join(t.TempDir(), "restored.tridb")
+	if err := os.WriteFile(restored, backup.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(fpath + KeyringFileExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(restored+KeyringFileExtension, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := Open(restored, 10, WithPrefixEncryption(masterKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	err = g.Read(func(r *Reader) error {
+		_, err := r.Get([]byte("users/1/email"))
+		return err
+	})
+	if !errors.Is(err, ErrShredded) {
+		t.Fatalf("got error %v instead of %v", err, ErrShredded)
+	}
+}
@@ -0,0 +1,53 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCloseTimeout is returned by CloseWithTimeout when the file couldn't be closed in time.
+var ErrCloseTimeout = errors.New("close timeout")
+
+// Flush syncs the commits that were not synced yet (see SyncInterval, SyncGroup and Writer.NoSync).
+// If the sync fails, the file is marked as failed.
+func (f *File) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Err(); err != nil {
+		return err
+	}
+	if !f.dirty {
+		return nil
+	}
+	if err := f.syncFile(); err != nil {
+		err = fmt.Errorf("%w: flush: %w", ErrFileCorruption, err)
+		f.fail(err)
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+// CloseWithTimeout is a single entry point to shut down gracefully (for example, on SIGTERM):
+// it waits for a running compaction to finish, stops the background goroutines (see WithSync,
+// WithTail and WithExpirySweeper), removes the watchers, flushes unsynced commits and closes the file.
+//
+// If the file isn't closed within the given duration (for example, because of a long transaction),
+// ErrCloseTimeout is returned and the file is closed in the background once possible.
+func (f *File) CloseWithTimeout(timeout time.Duration) error {
+	closed := make(chan error, 1)
+	go func() {
+		f.compacting.Lock() // compactions started later fail with ErrClosed
+		defer f.compacting.Unlock()
+		closed <- f.Close()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-closed:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w after %s", ErrCloseTimeout, timeout)
+	}
+}
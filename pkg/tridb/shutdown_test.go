@@ -0,0 +1,57 @@
+package tridb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFlush(t *testing.T) {
+	f := openTestFile(t)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.NoSync()
+		w.Set([]byte("a"), []byte("1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if f.dirty {
+		t.Fatal("the file should have been synced")
+	}
+	if got := f.Stats().SyncLatency.Samples; got != 1 {
+		t.Fatalf("got %d syncs instead of 1", got)
+	}
+}
+
+func TestCloseWithTimeout(t *testing.T) {
+	f := openTestFile(t, WithExpirySweeper(time.Millisecond))
+	release, locked := make(chan struct{}), make(chan struct{})
+	go f.Read(func(r *Reader) error {
+		close(locked)
+		<-release
+		return nil
+	})
+	<-locked
+	if err := f.CloseWithTimeout(10 * time.Millisecond); !errors.Is(err, ErrCloseTimeout) {
+		t.Fatalf("got error %v instead of %v", err, ErrCloseTimeout)
+	}
+	close(release)
+	for deadline := time.Now().Add(time.Second); !errors.Is(f.Err(), ErrClosed); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the file should have been closed once the transaction ended")
+		}
+	}
+
+	f = openTestFile(t)
+	mustSet(t, f, "a", "1")
+	if err := f.CloseWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Flush(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got error %v instead of %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,81 @@
+package tridb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Snapshot is an immutable view of the database, taken at a given point in time.
+//
+// Reading a snapshot doesn't hold the file lock, so long-running iterations and backups
+// can proceed concurrently with writes (and compactions).
+// Snapshots must be closed to release their file handle.
+type Snapshot struct {
+	f        *File
+	idx, sys fidx.Keydir
+	h        Storage // dedicated read handle (valid even after a compaction replaces the file)
+	size     int     // size of the file when the snapshot was taken
+	now      int64
+	ttls     []prefixTTL
+	expiring int
+}
+
+// Snapshot returns a view of the current state of the database.
+// Taking a snapshot copies the keydir, which is O(n) in the number of keys.
+func (f *File) Snapshot() (*Snapshot, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if err := f.Err(); err != nil {
+		return nil, err
+	}
+	h, err := f.opts.Storage(f.fpath, StorageReadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("open read handle: %w", err)
+	}
+	s := &Snapshot{
+		f:        f,
+		h:        h,
+		size:     f.woffset,
+		now:      time.Now().UnixNano(),
+		ttls:     f.prefixTTLs,
+		expiring: f.expiring,
+	}
+	s.idx, s.sys = f.cloneKeydirs(f.idx, f.sys)
+	return s, nil
+}
+
+// Read executes a read-only transaction on the snapshot.
+// Multiple transactions can be executed concurrently on the same snapshot.
+func (s *Snapshot) Read(do func(r *Reader) error) error {
+	return do(&Reader{
+		f:        s.f,
+		ctx:      context.Background(),
+		idx:      s.idx,
+		sys:      s.sys,
+		ra:       s.h,
+		size:     s.size,
+		now:      s.now,
+		ttls:     s.ttls,
+		expiring: s.expiring,
+	})
+}
+
+// CopyTo copies the datafile (as it was when the snapshot was taken) to the given writer, without blocking writers.
+func (s *Snapshot) CopyTo(dst io.Writer) (int, error) {
+	return s.f.copyThrottled(dst, io.NewSectionReader(s.h, 0, int64(s.size)))
+}
+
+// Close releases the snapshot file handle.
+func (s *Snapshot) Close() error { return s.h.Close() }
+
+// cloneKeydirs returns copies of the given keydirs.
+func (f *File) cloneKeydirs(idx, sys fidx.Keydir) (fidx.Keydir, fidx.Keydir) {
+	idxCopy, sysCopy := f.newKeydir(), fidx.NewTrieIndex()
+	fidx.Copy(idxCopy, idx)
+	fidx.Copy(sysCopy, sys)
+	return idxCopy, sysCopy
+}
@@ -0,0 +1,53 @@
+package tridb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "b", "2")
+
+	s, err := f.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Writes and compactions don't affect the snapshot.
+	mustSet(t, f, "a", "updated")
+	mustSet(t, f, "c", "3")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Read(func(r *Reader) error {
+		if got := r.Count(); got != 2 {
+			t.Fatalf("got count %d instead of 2", got)
+		}
+		if v, err := r.Get([]byte("a")); err != nil || string(v) != "1" {
+			t.Fatalf("got value %q (%v) instead of %q", v, err, "1")
+		}
+		return nil
+	})
+
+	// The snapshot can be used as a backup.
+	backup := &bytes.Buffer{}
+	if _, err := s.CopyTo(backup); err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(t.TempDir(), "backup.tridb")
+	if err := os.WriteFile(fpath, backup.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	assertValue(t, restored, "a", "1")
+	assertValue(t, restored, "c", "")
+}
@@ -0,0 +1,174 @@
+package tridb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Stats holds statistics about a database file.
+type Stats struct {
+	Keys         int // Number of keys (including expired keys not yet removed by compaction).
+	ExpiringKeys int // Number of keys with an expiration time.
+	Rows         int // Number of rows in the file (including overwritten and deleted ones).
+	FileSize     int // Size of the file in bytes.
+	LiveBytes    int // Size of the rows holding the current value of keys.
+	DeadBytes    int // Size of the overwritten and deleted rows (reclaimed by compaction).
+	Headroom     int // Bytes that can be appended before the file is full (-1 without maximum size, see WithMaxFileSize).
+	// AverageRowSize is the average size of the rows holding the current value of keys
+	// (encoded values, including their key and row header), 0 if there are no keys.
+	AverageRowSize int
+	Commits        int // Number of committed transactions since the file was opened.
+	Compactions    int // Number of compactions since the file was opened.
+	// LastCompaction is the end time of the last compaction (zero if none since the file was opened).
+	LastCompaction time.Time
+	// SyncLatency holds percentiles of the duration of recent syncs to disk.
+	SyncLatency SyncLatency
+	// ContentTypes holds statistics by content type, for keys set with one (see Writer.SetWithContentType).
+	ContentTypes map[string]ContentTypeStats
+}
+
+// Stats returns the current statistics of the file.
+func (f *File) Stats() Stats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	contentTypes := make(map[string]ContentTypeStats, len(f.contentTypes))
+	for name, count := range f.contentTypes {
+		contentTypes[name] = count.ContentTypeStats
+	}
+	keys, averageRowSize := f.idx.Chronological().Count, 0
+	if keys > 0 {
+		averageRowSize = f.liveBytes / keys
+	}
+	headroom := -1
+	if f.opts.MaxFileSize > 0 {
+		headroom = max(f.opts.MaxFileSize-f.woffset, 0)
+	}
+	return Stats{
+		ContentTypes:   contentTypes,
+		Keys:           keys,
+		ExpiringKeys:   f.expiring,
+		Rows:           f.numRows,
+		FileSize:       f.woffset,
+		LiveBytes:      f.liveBytes,
+		DeadBytes:      f.woffset - f.headerSize - f.liveBytes,
+		Headroom:       headroom,
+		AverageRowSize: averageRowSize,
+		Commits:        f.commits,
+		Compactions:    f.compactions,
+		LastCompaction: f.compactedAt,
+		SyncLatency:    f.syncs.latency(),
+	}
+}
+
+// BucketStats returns statistics about the buckets of the hash keydir (see WithKeyHash),
+// it reports false if the file uses another keydir.
+func (f *File) BucketStats() (fidx.BucketStats, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	switch idx := f.idx.(type) {
+	case *fidx.LHTIndex:
+		return idx.BucketStats(), true
+	case *fidx.AdaptiveIndex:
+		return idx.BucketStats(), true
+	}
+	return fidx.BucketStats{}, false
+}
+
+// WritePrometheus writes the file statistics in the Prometheus text exposition format,
+// along with the number of keys under each of the given prefixes.
+//
+// Example of /metrics handler:
+//
+//	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) { f.WritePrometheus(w, "users/") })
+func (f *File) WritePrometheus(w io.Writer, prefixes ...string) error {
+	stats := f.Stats()
+	prefixKeys := make([]int, len(prefixes))
+	err := f.Read(func(r *Reader) error {
+		for i, prefix := range prefixes {
+			n, err := r.Walk([]byte(prefix), func(key []byte) error { return nil })
+			prefixKeys[i] = n
+			if err != nil {
+				return fmt.Errorf("count keys with prefix %q: %w", prefix, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bufw := bufio.NewWriter(w)
+	metric := func(name, kind, help string, value int) {
+		fmt.Fprintf(bufw, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+	}
+	metric("tridb_keys", "gauge", "Number of keys.", stats.Keys)
+	metric("tridb_expiring_keys", "gauge", "Number of keys with an expiration time.", stats.ExpiringKeys)
+	metric("tridb_rows", "gauge", "Number of rows in the file.", stats.Rows)
+	metric("tridb_file_size_bytes", "gauge", "Size of the file in bytes.", stats.FileSize)
+	metric("tridb_live_bytes", "gauge", "Size of the rows holding the current value of keys.", stats.LiveBytes)
+	metric("tridb_dead_bytes", "gauge", "Size of the overwritten and deleted rows.", stats.DeadBytes)
+	if stats.Headroom >= 0 {
+		metric("tridb_headroom_bytes", "gauge", "Bytes that can be appended before the file is full.", stats.Headroom)
+	}
+	metric("tridb_commits_total", "counter", "Number of committed transactions.", stats.Commits)
+	metric("tridb_compactions_total", "counter", "Number of compactions.", stats.Compactions)
+	if !stats.LastCompaction.IsZero() {
+		metric("tridb_last_compaction_timestamp_seconds", "gauge", "End time of the last compaction.", int(stats.LastCompaction.Unix()))
+	}
+	fmt.Fprint(bufw, "# HELP tridb_sync_latency_seconds Percentiles of the duration of recent syncs to disk.\n# TYPE tridb_sync_latency_seconds gauge\n")
+	for _, q := range []struct {
+		quantile string
+		d        time.Duration
+	}{{"0.5", stats.SyncLatency.P50}, {"0.99", stats.SyncLatency.P99}, {"1", stats.SyncLatency.Max}} {
+		fmt.Fprintf(bufw, "tridb_sync_latency_seconds{quantile=%q} %g\n", q.quantile, q.d.Seconds())
+	}
+	metric("tridb_slow_syncs_total", "counter", "Number of syncs to disk slower than the slow sync threshold.", stats.SyncLatency.Slow)
+	if buckets, ok := f.BucketStats(); ok {
+		metric("tridb_keydir_max_bucket_depth", "gauge", "Number of keys in the fullest bucket of the hash keydir.", buckets.MaxDepth)
+		metric("tridb_keydir_empty_buckets", "gauge", "Number of empty buckets of the hash keydir.", buckets.Empty)
+	}
+	if len(prefixes) > 0 {
+		fmt.Fprint(bufw, "# HELP tridb_prefix_keys Number of keys with a given prefix.\n# TYPE tridb_prefix_keys gauge\n")
+		for i, prefix := range prefixes {
+			fmt.Fprintf(bufw, "tridb_prefix_keys{prefix=%s} %d\n", quoteLabel(prefix), prefixKeys[i])
+		}
+	}
+	if len(stats.ContentTypes) > 0 {
+		fmt.Fprint(bufw, "# HELP tridb_content_type_keys Number of keys with a given content type.\n# TYPE tridb_content_type_keys gauge\n")
+		names := sortedContentTypes(stats.ContentTypes)
+		for _, name := range names {
+			fmt.Fprintf(bufw, "tridb_content_type_keys{content_type=%s} %d\n", quoteLabel(name), stats.ContentTypes[name].Keys)
+		}
+		fmt.Fprint(bufw, "# HELP tridb_content_type_bytes Size of the rows of keys with a given content type.\n# TYPE tridb_content_type_bytes gauge\n")
+		for _, name := range names {
+			fmt.Fprintf(bufw, "tridb_content_type_bytes{content_type=%s} %d\n", quoteLabel(name), stats.ContentTypes[name].Bytes)
+		}
+	}
+	if quotas := f.Quotas(); len(quotas) > 0 {
+		fmt.Fprint(bufw, "# HELP tridb_quota_used_bytes Size of the values with a given prefix that has a quota.\n# TYPE tridb_quota_used_bytes gauge\n")
+		for _, prefix := range sortedQuotaPrefixes(quotas) {
+			fmt.Fprintf(bufw, "tridb_quota_used_bytes{prefix=%s} %d\n", quoteLabel(prefix), quotas[prefix])
+		}
+	}
+	return bufw.Flush()
+}
+
+// quoteLabel returns the quoted label value (escaping backslashes, double quotes and line feeds).
+func quoteLabel(v string) string {
+	quoted := []byte{'"'}
+	for _, c := range []byte(v) {
+		switch c {
+		case '\\', '"':
+			quoted = append(quoted, '\\', c)
+		case '\n':
+			quoted = append(quoted, '\\', 'n')
+		default:
+			quoted = append(quoted, c)
+		}
+	}
+	return string(append(quoted, '"'))
+}
@@ -0,0 +1,77 @@
+package tridb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "users/1", "alice")
+	mustSet(t, f, "users/2", "bob")
+	mustSet(t, f, "orders/1", "...")
+
+	out := &bytes.Buffer{}
+	if err := f.WritePrometheus(out, "users/", "quo\"te"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"tridb_keys 3\n",
+		"tridb_commits_total 3\n",
+		"# TYPE tridb_commits_total counter\n",
+		"tridb_prefix_keys{prefix=\"users/\"} 2\n",
+		"tridb_prefix_keys{prefix=\"quo\\\"te\"} 0\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	f := openTestFile(t)
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "a", "2")
+	mustSet(t, f, "b", "3")
+	stats := f.Stats()
+	if stats.Keys != 2 || stats.Rows != 3 || !stats.LastCompaction.IsZero() {
+		t.Fatalf("got stats %+v", stats)
+	}
+	if stats.LiveBytes+stats.DeadBytes+f.headerSize != stats.FileSize || stats.AverageRowSize != stats.LiveBytes/2 {
+		t.Fatalf("got inconsistent sizes %+v", stats)
+	}
+
+	before := time.Now()
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	stats = f.Stats()
+	if stats.DeadBytes != 0 || stats.LiveBytes+f.headerSize != stats.FileSize || stats.LastCompaction.Before(before) {
+		t.Fatalf("got stats %+v after compaction", stats)
+	}
+}
+
+func TestBucketStats(t *testing.T) {
+	f := openTestFile(t, WithKeyHash(func(key []byte) uint64 { return 0 }))
+	for _, key := range []string{"a", "b", "c"} {
+		mustSet(t, f, key, "v")
+	}
+	assertValue(t, f, "b", "v")
+	stats, ok := f.BucketStats()
+	if !ok || stats.MaxDepth != 3 || stats.Empty != stats.Buckets-1 {
+		t.Fatalf("got bucket stats %+v (%v) with a constant hash", stats, ok)
+	}
+	out := &bytes.Buffer{}
+	if err := f.WritePrometheus(out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "tridb_keydir_max_bucket_depth 3\n"; !strings.Contains(out.String(), want) {
+		t.Fatalf("missing %q in:\n%s", want, out)
+	}
+
+	if _, ok := openTestFile(t, WithKeydir(KeydirTrie)).BucketStats(); ok {
+		t.Fatal("trie keydir should not report bucket stats")
+	}
+}
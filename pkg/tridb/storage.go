@@ -0,0 +1,292 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ejuju/tridb/pkg/fsutil"
+)
+
+// Storage holds the bytes of a database file: rows are appended to it and read at their offset (see WithStorage).
+// Reads may happen concurrently with appends (of bytes after the ones read).
+type Storage interface {
+	io.ReaderAt
+	// Append writes the given bytes at the end of the storage.
+	Append(p []byte) (int, error)
+	// Sync makes the appended bytes durable.
+	Sync() error
+	// Truncate drops the bytes after the given size (for example a torn write).
+	Truncate(size int64) error
+	// Size returns the number of bytes in the storage.
+	Size() (int64, error)
+	// Rename moves the storage to the given path, replacing the storage found there (see File.Compact).
+	Rename(path string) error
+	// Remove removes the storage from its path, its bytes can still be read until it is closed.
+	Remove() error
+	Close() error
+}
+
+// StorageMode is the mode a storage is opened in (see StorageOpener).
+type StorageMode int
+
+// Storage modes.
+const (
+	StorageReadWrite StorageMode = iota // Opened for appending, created if it doesn't exist.
+	StorageReadOnly                     // Opened read-only, it must exist.
+	StorageCreate                       // Created for appending, it fails with an error wrapping os.ErrExist if it exists.
+)
+
+// StorageOpener opens the storage of the given path in the given mode.
+// Missing storages opened read-only fail with an error wrapping os.ErrNotExist.
+//
+// Read-only storages are also opened as dedicated read handles (for example by snapshots and backups):
+// they must keep reading the same bytes when the storage of their path is replaced with Rename.
+type StorageOpener func(path string, mode StorageMode) (Storage, error)
+
+// OpenFileStorage opens a file of the local file system (the default storage, see WithStorage),
+// with a read handle and an append-only write handle.
+func OpenFileStorage(path string, mode StorageMode) (Storage, error) {
+	if mode == StorageReadOnly {
+		r, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &fileStorage{path: path, r: r}, nil
+	}
+	r, w, err := openFileRW(path, mode == StorageCreate)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStorage{path: path, r: r, w: w}, nil
+}
+
+type fileStorage struct {
+	path string
+	r, w *os.File // w is nil if the storage is read-only
+}
+
+func (s *fileStorage) ReadAt(p []byte, off int64) (int, error) { return s.r.ReadAt(p, off) }
+
+func (s *fileStorage) Append(p []byte) (int, error) {
+	if s.w == nil {
+		return 0, ErrReadOnly
+	}
+	return s.w.Write(p)
+}
+
+func (s *fileStorage) Sync() error {
+	if s.w == nil {
+		return nil
+	}
+	return s.w.Sync()
+}
+
+func (s *fileStorage) Truncate(size int64) error { return os.Truncate(s.path, size) }
+
+func (s *fileStorage) Size() (int64, error) {
+	info, err := s.r.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Rename closes the file first where open files can't be renamed (and reopens it once renamed).
+func (s *fileStorage) Rename(path string) error {
+	if fsutil.CanRenameOpenFiles {
+		if err := fsutil.Rename(s.path, path); err != nil {
+			return err
+		}
+		s.path = path
+		return nil
+	}
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if err := fsutil.Rename(s.path, path); err != nil {
+		return err
+	}
+	s.path = path
+	mode := StorageReadWrite
+	if s.w == nil {
+		mode = StorageReadOnly
+	}
+	reopened, err := OpenFileStorage(path, mode)
+	if err != nil {
+		return fmt.Errorf("reopen renamed file: %w", err)
+	}
+	*s = *reopened.(*fileStorage)
+	return nil
+}
+
+func (s *fileStorage) Remove() error { return os.Remove(s.path) }
+
+func (s *fileStorage) Close() error { return closeFileRW(s.r, s.w) }
+
+// sameStorage reports whether the given storages hold the same bytes (false if unknown).
+func sameStorage(a, b Storage) bool {
+	switch a := a.(type) {
+	case *fileStorage:
+		b, ok := b.(*fileStorage)
+		if !ok {
+			return false
+		}
+		infoA, errA := a.r.Stat()
+		infoB, errB := b.r.Stat()
+		return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+	case *memoryStorage:
+		b, ok := b.(*memoryStorage)
+		return ok && a.file == b.file
+	}
+	return false
+}
+
+// readStorage returns the bytes of the storage of the given path.
+func (f *File) readStorage(path string) ([]byte, error) {
+	s, err := f.opts.Storage(path, StorageReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	size, err := s.Size()
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, size)
+	if _, err := s.ReadAt(content, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return content, nil
+}
+
+// storageSize returns the size of the storage of the given path (an error wrapping os.ErrNotExist if it doesn't exist).
+func (f *File) storageSize(path string) (int64, error) {
+	s, err := f.opts.Storage(path, StorageReadOnly)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	return s.Size()
+}
+
+// removeStorage removes the storage of the given path (if it exists).
+func (f *File) removeStorage(path string) error {
+	s, err := f.opts.Storage(path, StorageReadOnly)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Remove()
+}
+
+// storageWriter appends the bytes written to the storage.
+type storageWriter struct{ s Storage }
+
+func (w storageWriter) Write(p []byte) (int, error) { return w.s.Append(p) }
+
+// NewMemoryStorage returns an opener of storages held in memory (see WithStorage), for example for tests
+// or short-lived caches. Storages opened with the same path share their bytes, until the opener is garbage collected.
+func NewMemoryStorage() StorageOpener {
+	fs := &memoryFS{files: map[string]*memoryFile{}}
+	return fs.open
+}
+
+type memoryFS struct {
+	mu    sync.Mutex
+	files map[string]*memoryFile
+}
+
+type memoryFile struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func (fs *memoryFS) open(path string, mode StorageMode) (Storage, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	file, ok := fs.files[path]
+	switch {
+	case !ok && mode == StorageReadOnly:
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	case ok && mode == StorageCreate:
+		return nil, &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+	case !ok:
+		file = &memoryFile{}
+		fs.files[path] = file
+	}
+	return &memoryStorage{fs: fs, path: path, file: file, readOnly: mode == StorageReadOnly}, nil
+}
+
+type memoryStorage struct {
+	fs       *memoryFS
+	path     string
+	file     *memoryFile
+	readOnly bool
+}
+
+func (s *memoryStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.file.mu.RLock()
+	defer s.file.mu.RUnlock()
+	if off >= int64(len(s.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.file.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *memoryStorage) Append(p []byte) (int, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	s.file.mu.Lock()
+	defer s.file.mu.Unlock()
+	s.file.data = append(s.file.data, p...)
+	return len(p), nil
+}
+
+func (s *memoryStorage) Sync() error { return nil }
+
+func (s *memoryStorage) Truncate(size int64) error {
+	s.file.mu.Lock()
+	defer s.file.mu.Unlock()
+	if size < int64(len(s.file.data)) {
+		s.file.data = s.file.data[:size:size] // appends don't overwrite the bytes read by others
+	}
+	return nil
+}
+
+func (s *memoryStorage) Size() (int64, error) {
+	s.file.mu.RLock()
+	defer s.file.mu.RUnlock()
+	return int64(len(s.file.data)), nil
+}
+
+func (s *memoryStorage) Rename(path string) error {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	if s.fs.files[s.path] == s.file {
+		delete(s.fs.files, s.path)
+	}
+	s.fs.files[path], s.path = s.file, path
+	return nil
+}
+
+func (s *memoryStorage) Remove() error {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	if s.fs.files[s.path] != s.file {
+		return &os.PathError{Op: "remove", Path: s.path, Err: os.ErrNotExist}
+	}
+	delete(s.fs.files, s.path)
+	return nil
+}
+
+func (s *memoryStorage) Close() error { return nil }
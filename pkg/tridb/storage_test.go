@@ -0,0 +1,144 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingStorage counts the appends to the wrapped storage.
+type countingStorage struct {
+	Storage
+	appends *int
+}
+
+func (s countingStorage) Append(p []byte) (int, error) {
+	*s.appends++
+	return s.Storage.Append(p)
+}
+
+func TestMemoryStorage(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	open := NewMemoryStorage()
+	f, err := Open(fpath, 10, WithStorage(open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "a", "2")
+	mustSet(t, f, "b", "3")
+	snap, err := f.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "c", "4")
+	assertValue(t, f, "a", "2")
+	if _, err := os.Stat(fpath); !os.IsNotExist(err) {
+		t.Fatalf("the datafile was written to disk: %v", err)
+	}
+	_ = snap.Read(func(r *Reader) error {
+		if r.Has([]byte("c")) || !r.Has([]byte("b")) {
+			t.Fatal("the snapshot doesn't hold the state it was taken at")
+		}
+		return nil
+	})
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = Open(fpath, 10, WithStorage(open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	assertValue(t, f, "a", "2")
+	assertValue(t, f, "c", "4")
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.tridb"), 10, WithStorage(open), WithReadOnly()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %v when opening a missing file read-only", err)
+	}
+}
+
+func TestStorageWrapper(t *testing.T) {
+	appends := 0
+	f := openTestFile(t, WithStorage(func(path string, mode StorageMode) (Storage, error) {
+		s, err := OpenFileStorage(path, mode)
+		return countingStorage{Storage: s, appends: &appends}, err
+	}))
+	before := appends
+	mustSet(t, f, "a", "1")
+	if appends != before+1 {
+		t.Fatalf("got %d appends instead of 1", appends-before)
+	}
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, f, "a", "1")
+}
+
+// failingStorage fails to sync or rename the wrapped storage.
+type failingStorage struct {
+	Storage
+	sync, rename error
+}
+
+func (s failingStorage) Sync() error {
+	if s.sync != nil {
+		return s.sync
+	}
+	return s.Storage.Sync()
+}
+
+func (s failingStorage) Rename(path string) error {
+	if s.rename != nil {
+		return s.rename
+	}
+	return s.Storage.Rename(path)
+}
+
+func TestCompactStorageFailure(t *testing.T) {
+	errFailing := errors.New("failing storage")
+	for name, failing := range map[string]failingStorage{"sync": {sync: errFailing}, "rename": {rename: errFailing}} {
+		t.Run(name, func(t *testing.T) {
+			fpath := filepath.Join(t.TempDir(), "test.tridb")
+			open := func(path string, mode StorageMode) (Storage, error) {
+				s, err := OpenFileStorage(path, mode)
+				if err != nil || path != fpath+CompactingFileExtension {
+					return s, err
+				}
+				failing.Storage = s
+				return failing, nil
+			}
+			f, err := Open(fpath, 10, WithStorage(open))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { f.Close() })
+			mustSet(t, f, "a", "1")
+			mustSet(t, f, "a", "2")
+			if err := f.Compact(); !errors.Is(err, errFailing) {
+				t.Fatalf("got error %v instead of %v", err, errFailing)
+			}
+
+			// The old file is still used
+			mustSet(t, f, "b", "3")
+			assertValue(t, f, "a", "2")
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			f, err = Open(fpath, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertValue(t, f, "a", "2")
+			assertValue(t, f, "b", "3")
+			if _, err := os.Stat(fpath + CompactingFileExtension); name == "sync" && !os.IsNotExist(err) {
+				t.Fatalf("the compacting file was left after a failed sync: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package tridb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// strictDecoding is set with SetStrictDecoding.
+var strictDecoding atomic.Bool
+
+// maxRowSize is set with SetMaxRowSize.
+var maxRowSize atomic.Int64
+
+// ErrRowTooLarge is returned when decoding a row (or a frame of rows) declaring a length above the limit set with SetMaxRowSize.
+var ErrRowTooLarge = errors.New("row too large")
+
+// strictReadChunkSize is the initial buffer size of reads in strict decoding mode.
+const strictReadChunkSize = 64 << 10
+
+// SetStrictDecoding enables or disables (default) the strict decoding mode for the whole package.
+//
+// Lengths read from rows (ex: the value length) are not trusted in strict mode: the buffers they size
+// grow as bytes are read instead of being allocated upfront, so that decoding a corrupted or malicious file
+// allocates at most about twice the remaining bytes of the file (instead of up to 4 GiB per row).
+// Values above 64 KiB are slightly slower to decode (they may be copied while the buffer grows).
+func SetStrictDecoding(enabled bool) { strictDecoding.Store(enabled) }
+
+// StrictDecoding reports whether the strict decoding mode is enabled (see SetStrictDecoding).
+func StrictDecoding() bool { return strictDecoding.Load() }
+
+// SetMaxRowSize limits the lengths read from rows for the whole package (0, the default, means no limit):
+// values, batch frames, encrypted rows and rows of custom ops declaring a longer length fail to decode
+// with ErrRowTooLarge before anything is allocated, so that a malformed file can't trigger huge allocations.
+// The limit should be above the longest value written (see WithMaxValueLength).
+func SetMaxRowSize(n int) { maxRowSize.Store(int64(n)) }
+
+// MaxRowSize returns the limit set with SetMaxRowSize (0 if there is none).
+func MaxRowSize() int { return int(maxRowSize.Load()) }
+
+// checkRowSize returns ErrRowTooLarge if the given length read from a row is above the limit (see SetMaxRowSize).
+func checkRowSize(n int) error {
+	if max := MaxRowSize(); max > 0 && n > max {
+		return fmt.Errorf("%w: %d bytes (see SetMaxRowSize)", ErrRowTooLarge, n)
+	}
+	return nil
+}
+
+// readFull reads exactly n bytes from r, like io.ReadFull with a buffer of n bytes.
+// It reports the bytes read (even on error) and their number, lengths above the limit are rejected (see SetMaxRowSize).
+// In strict decoding mode, the buffer grows as bytes are read (see SetStrictDecoding).
+func readFull(r io.Reader, n int) ([]byte, int, error) {
+	if err := checkRowSize(n); err != nil {
+		return nil, 0, err
+	}
+	if n <= strictReadChunkSize || !StrictDecoding() {
+		buf := make([]byte, n)
+		m, err := io.ReadFull(r, buf)
+		return buf, m, err
+	}
+	buf := make([]byte, strictReadChunkSize)
+	read := 0
+	for {
+		m, err := io.ReadFull(r, buf[read:])
+		read += m
+		if err != nil {
+			if read > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return buf[:read], read, err
+		}
+		if read == n {
+			return buf, read, nil
+		}
+		grown := make([]byte, min(2*len(buf), n))
+		copy(grown, buf)
+		buf = grown
+	}
+}
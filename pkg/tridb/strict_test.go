@@ -0,0 +1,179 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+func TestStrictDecoding(t *testing.T) {
+	SetStrictDecoding(true)
+	defer SetStrictDecoding(false)
+
+	// A corrupted value length (4 GiB) doesn't allocate more than the available bytes.
+	corrupted := append([]byte{opSet, 1, 0xFF, 0xFF, 0xFF, 0xFF, 'k'}, make([]byte, 100<<10)...)
+	before := runtime.MemStats{}
+	runtime.ReadMemStats(&before)
+	n, err := (&Row{}).DecodeFrom(bytes.NewReader(corrupted))
+	after := runtime.MemStats{}
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || n != len(corrupted) {
+		t.Fatalf("got %d bytes read and error %v", n, err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("allocated %d bytes", allocated)
+	}
+
+	// Values longer than the initial buffer are still decoded.
+	row := &Row{Key: []byte("k"), Value: bytes.Repeat([]byte("v"), 3*strictReadChunkSize+1)}
+	encoded, err := row.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Row{}
+	if _, err := decoded.DecodeFrom(bytes.NewReader(encoded)); err != nil || !bytes.Equal(decoded.Value, row.Value) {
+		t.Fatalf("got %d bytes and error %v", len(decoded.Value), err)
+	}
+
+	// Files with a corrupted length in the last row are opened (the row is handled like a torn tail).
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	f.Close()
+	if err := appendToFile(fpath, corrupted[:1<<10]); err != nil {
+		t.Fatal(err)
+	}
+	f, err = Open(fpath, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	assertValue(t, f, "a", "1")
+}
+
+func TestMaxRowSize(t *testing.T) {
+	SetMaxRowSize(1 << 20)
+	defer SetMaxRowSize(0)
+
+	// Lengths above the limit are rejected before allocating.
+	before := runtime.MemStats{}
+	runtime.ReadMemStats(&before)
+	_, err := (&Row{}).DecodeFrom(bytes.NewReader([]byte{opSet, 1, 0xFF, 0xFF, 0xFF, 0xFF, 'k'}))
+	after := runtime.MemStats{}
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, ErrRowTooLarge) {
+		t.Fatalf("got error %v instead of %v", err, ErrRowTooLarge)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("allocated %d bytes", allocated)
+	}
+	if _, err := TextEncoding.DecodeFrom(strings.NewReader("+ 1 4294967295 k "), &Row{}); !errors.Is(err, ErrRowTooLarge) {
+		t.Fatalf("got error %v instead of %v", err, ErrRowTooLarge)
+	}
+
+	// Opening a file with such a row fails with the offset of the row.
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 10, WithFormat(BinaryEncoding))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	offset := f.woffset
+	f.Close()
+	if err := appendToFile(fpath, append([]byte{opSet, 1, 0xFF, 0xFF, 0xFF, 0xFF, 'k'}, make([]byte, 100)...)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(fpath, 10)
+	if !errors.Is(err, ErrRowTooLarge) || !strings.Contains(err.Error(), fmt.Sprintf("offset %d", offset)) {
+		t.Fatalf("got error %v instead of %v at offset %d", err, ErrRowTooLarge, offset)
+	}
+}
+
+func FuzzDecodeFrom(f *testing.F) {
+	SetStrictDecoding(true)
+	defer SetStrictDecoding(false)
+	for _, row := range []*Row{
+		{Key: []byte("k"), Value: []byte("v")},
+		{Key: []byte("k"), IsDeleted: true, Timestamp: 1},
+		{Key: []byte("k"), Value: []byte("v"), ExpiresAt: 1, ContentType: "text/plain"},
+	} {
+		for _, format := range append(Formats, binaryFormat{compactTombstones: true}) {
+			encoded, err := format.Encode(row)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(encoded)
+		}
+	}
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		for _, format := range append(Formats, binaryFormat{compactTombstones: true}) {
+			row := &Row{}
+			n, err := format.DecodeFrom(bytes.NewReader(encoded), row)
+			if n > len(encoded) {
+				t.Fatalf("%s: read %d bytes from %d", format.Name(), n, len(encoded))
+			}
+			if err == nil {
+				if err := row.Validate(); err != nil {
+					t.Fatalf("%s: decoded invalid row: %v", format.Name(), err)
+				}
+			}
+		}
+	})
+}
+
+func FuzzScanFrames(f *testing.F) {
+	SetMaxRowSize(1 << 20)
+	defer SetMaxRowSize(0)
+	formats := append(Formats, binaryFormat{compactTombstones: true})
+	for _, format := range formats {
+		seed := &bytes.Buffer{}
+		for _, row := range []*Row{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("a"), IsDeleted: true}} {
+			encoded, err := format.Encode(row)
+			if err != nil {
+				f.Fatal(err)
+			}
+			seed.Write(encoded)
+		}
+		frame, err := encodeBatch(format, []*Row{{Key: []byte("b"), Value: []byte("2")}})
+		if err != nil {
+			f.Fatal(err)
+		}
+		seed.Write(frame.encoded)
+		f.Add(seed.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, format := range formats {
+			file := &File{format: format}
+			end, _, _ := file.scanFrames(bufio.NewReader(bytes.NewReader(data)), 0, -1, func(row *Row, p fidx.Position) bool {
+				if p.Offset() < 0 || p.Offset()+p.Size() > len(data) {
+					t.Fatalf("%s: row at %v out of %d bytes", format.Name(), p, len(data))
+				}
+				return true
+			}, nil)
+			if end > len(data) {
+				t.Fatalf("%s: scanned %d bytes from %d", format.Name(), end, len(data))
+			}
+		}
+	})
+}
+
+func appendToFile(fpath string, data []byte) error {
+	fh, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(data)
+	return errors.Join(err, fh.Close())
+}
@@ -0,0 +1,125 @@
+package tridb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SyncMode defines when writes are synced to disk (fsync).
+type SyncMode string
+
+// Available sync modes.
+const (
+	SyncAlways   SyncMode = "always"   // Sync on every commit (default).
+	SyncInterval SyncMode = "interval" // Sync periodically in the background (see Options.SyncInterval).
+	SyncNever    SyncMode = "never"    // Let the OS decide when to write data to disk.
+	SyncGroup    SyncMode = "group"    // Sync concurrent commits together (see Options.SyncInterval).
+)
+
+// DefaultSyncInterval is the sync interval used by SyncInterval when none is configured.
+const DefaultSyncInterval = time.Second
+
+// DefaultGroupCommitLatency is the maximum latency added to commits by SyncGroup when none is configured.
+const DefaultGroupCommitLatency = 2 * time.Millisecond
+
+// sync syncs written data according to the sync mode.
+// It must be called with the write lock held.
+func (f *File) sync() error {
+	switch f.opts.Sync {
+	case SyncNever:
+		return nil
+	case SyncInterval, SyncGroup:
+		f.dirty = true
+		return nil
+	}
+	return f.syncFile()
+}
+
+// NoSync makes the transaction skip the sync on commit whatever the sync mode,
+// for writes that may be lost on a crash (for example, cached values).
+// Its rows are synced along with the next synced commit, or when the file is closed.
+func (w *Writer) NoSync() { w.deferSync = true }
+
+// syncLoop periodically syncs written data until the file is closed.
+func (f *File) syncLoop() {
+	defer close(f.loopDone)
+	ticker := time.NewTicker(f.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stopLoop:
+			return
+		case <-ticker.C:
+		}
+		f.mu.Lock()
+		if f.dirty {
+			if err := f.syncFile(); err != nil {
+				f.fail(fmt.Errorf("%w: background sync: %w", ErrFileCorruption, err))
+			}
+			f.dirty = false
+		}
+		f.mu.Unlock()
+	}
+}
+
+// checkWritable returns an error if the file can't be written to.
+func (f *File) checkWritable() error {
+	if err := f.Err(); err != nil {
+		return err
+	}
+	if f.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// groupCommit tracks the synced part of the file with SyncGroup.
+//
+// Commits write their rows with the write lock held but wait for the sync after releasing it:
+// the first waiting commit becomes the leader, waits up to the configured latency
+// (so that concurrent commits can write their rows) and syncs the file for all of them.
+type groupCommit struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	synced  int  // number of commits (see File.commits) synced
+	syncing bool // whether a leader is waiting to sync
+	err     error
+}
+
+// waitDurable returns once the given commit (see File.commits) is synced.
+func (f *File) waitDurable(commit int) error {
+	g := &f.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.synced < commit {
+		if g.err != nil {
+			return g.err
+		}
+		if g.syncing {
+			g.cond.Wait()
+			continue
+		}
+
+		// Lead the next group
+		g.syncing = true
+		g.mu.Unlock()
+		time.Sleep(f.opts.SyncInterval)
+		f.mu.Lock()
+		target, err := f.commits, f.syncFile()
+		if err != nil {
+			err = fmt.Errorf("%w: group sync: %w", ErrFileCorruption, err)
+			f.fail(err)
+		}
+		f.dirty = false
+		f.mu.Unlock()
+		g.mu.Lock()
+		g.syncing = false
+		g.err = err
+		if err == nil && target > g.synced {
+			g.synced = target
+		}
+		g.cond.Broadcast()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package tridb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenWithOptions(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	if _, err := OpenWithOptions(fpath, &Options{ReadOnly: true}); err == nil {
+		t.Fatal("opening a missing file in read-only mode should fail")
+	}
+
+	f, err := OpenWithOptions(fpath, &Options{Sync: SyncInterval, SyncInterval: time.Millisecond, Keydir: KeydirTrie})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "a", "1")
+	isDirty := func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.dirty
+	}
+	for deadline := time.Now().Add(time.Second); isDirty(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("written data should have been synced in the background")
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = OpenReadOnly(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	other, err := OpenReadOnly(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	assertValue(t, f, "a", "1")
+	assertValue(t, other, "a", "1")
+	if err := f.Batch().Commit(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("got error %v instead of %v", err, ErrReadOnly)
+	}
+	if err := f.ReadWrite(func(r *Reader, w *Writer) error { return nil }); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("got error %v instead of %v", err, ErrReadOnly)
+	}
+	if err := f.Compact(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("got error %v instead of %v", err, ErrReadOnly)
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	f := openTestFile(t, WithSync(SyncGroup, time.Millisecond))
+	errs := make(chan error)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			errs <- f.ReadWrite(func(r *Reader, w *Writer) error {
+				w.Set([]byte{byte(i)}, []byte("value"))
+				return nil
+			})
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every commit returned only once synced.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dirty || f.group.synced != f.commits {
+		t.Fatalf("got %d synced commits out of %d (dirty: %t)", f.group.synced, f.commits, f.dirty)
+	}
+}
+
+func TestNoSync(t *testing.T) {
+	f := openTestFile(t)
+	for _, noSync := range []bool{true, false} {
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			if noSync {
+				w.NoSync()
+			}
+			w.Set([]byte("a"), []byte("1"))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := f.Batch()
+		b.NoSync()
+		b.Set([]byte("b"), []byte("2"))
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		f.mu.Lock()
+		dirty := f.dirty
+		f.mu.Unlock()
+		if !dirty {
+			t.Fatal("the file should not have been synced")
+		}
+	}
+	if got := f.Stats().SyncLatency.Samples; got != 1 {
+		t.Fatalf("got %d syncs instead of 1", got)
+	}
+	assertValue(t, f, "b", "2")
+}
@@ -0,0 +1,86 @@
+package tridb
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultSlowSyncThreshold is the duration after which a sync is reported as slow when none is configured
+// (see WithSlowSyncHandler).
+const DefaultSlowSyncThreshold = 500 * time.Millisecond
+
+// syncSamples is the number of recent sync durations kept to compute latency percentiles (see Stats).
+const syncSamples = 1024
+
+// syncStats tracks the duration of the recent syncs of a file.
+type syncStats struct {
+	mu      sync.Mutex
+	samples []time.Duration // ring buffer of recent sync durations
+	next    int             // index of the next sample once samples is full
+	slow    int             // number of syncs that exceeded the slow sync threshold
+	total   int             // number of syncs
+}
+
+// record adds a sync duration.
+func (s *syncStats) record(d time.Duration, slow bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) < syncSamples {
+		s.samples = append(s.samples, d)
+	} else {
+		s.samples[s.next] = d
+		s.next = (s.next + 1) % syncSamples
+	}
+	if slow {
+		s.slow++
+	}
+	s.total++
+}
+
+// SyncLatency holds percentiles of the duration of recent syncs (see Stats).
+type SyncLatency struct {
+	Samples  int // Number of syncs the percentiles are computed from (up to the 1024 last ones).
+	P50, P99 time.Duration
+	Max      time.Duration
+	Slow     int // Number of syncs that exceeded the slow sync threshold since the file was opened (see WithSlowSyncHandler).
+	Syncs    int // Number of syncs since the file was opened.
+}
+
+// latency returns the percentiles of the recent sync durations.
+func (s *syncStats) latency() SyncLatency {
+	s.mu.Lock()
+	sorted := slices.Clone(s.samples)
+	l := SyncLatency{Samples: len(sorted), Slow: s.slow, Syncs: s.total}
+	s.mu.Unlock()
+	if len(sorted) == 0 {
+		return l
+	}
+	slices.Sort(sorted)
+	percentile := func(p int) time.Duration { return sorted[(len(sorted)-1)*p/100] }
+	l.P50, l.P99, l.Max = percentile(50), percentile(99), sorted[len(sorted)-1]
+	return l
+}
+
+// syncFile syncs the write handle to disk and reports its duration (see WithMetrics).
+//
+// With a slow sync handler (see WithSlowSyncHandler), a sync still running after the threshold
+// is reported right away so that stalled writes are detected before the sync returns (if ever).
+func (f *File) syncFile() error {
+	start := time.Now()
+	var stalled *time.Timer
+	if f.opts.SlowSyncHandler != nil {
+		stalled = time.AfterFunc(f.opts.SlowSyncThreshold, func() { f.opts.SlowSyncHandler(time.Since(start)) })
+	}
+	err := f.store.Sync()
+	d := time.Since(start)
+	slow := d >= f.opts.SlowSyncThreshold
+	if stalled != nil && stalled.Stop() && slow {
+		f.opts.SlowSyncHandler(d) // the timer didn't fire yet
+	}
+	f.syncs.record(d, slow)
+	if f.opts.Metrics != nil {
+		f.opts.Metrics.Synced(d)
+	}
+	return err
+}
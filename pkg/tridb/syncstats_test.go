@@ -0,0 +1,55 @@
+package tridb
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSlowSyncHandler(t *testing.T) {
+	var slow atomic.Int64
+	handler := func(d time.Duration) {
+		if d <= 0 {
+			t.Errorf("got slow sync duration %s", d)
+		}
+		slow.Add(1)
+	}
+	f, err := Open(filepath.Join(t.TempDir(), "test.tridb"), 10, WithSlowSyncHandler(time.Nanosecond, handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 0; i < 3; i++ {
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			w.Set([]byte("key"), []byte("value"))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for slow.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := slow.Load(); n != 3 {
+		t.Fatalf("got %d slow syncs reported instead of 3", n)
+	}
+
+	l := f.Stats().SyncLatency
+	if l.Samples != 3 || l.Slow != 3 || l.Syncs != 3 || l.P50 <= 0 || l.P50 > l.P99 || l.P99 > l.Max {
+		t.Fatalf("got sync latency %+v", l)
+	}
+}
+
+func TestSyncStatsLatency(t *testing.T) {
+	s := &syncStats{}
+	for i := 1; i <= syncSamples+100; i++ {
+		s.record(time.Duration(i), false)
+	}
+	l := s.latency()
+	if l.Samples != syncSamples || l.Syncs != syncSamples+100 || l.Max != syncSamples+100 || l.P50 != 101+(syncSamples-1)/2 || l.Slow != 0 {
+		t.Fatalf("got sync latency %+v", l)
+	}
+}
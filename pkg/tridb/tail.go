@@ -0,0 +1,114 @@
+package tridb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Refresh loads the rows appended to a read-only file since it was opened (or last refreshed),
+// so that a reader process follows the writes of the writer process. Rows being written are loaded on the next refresh.
+//
+// If the file was replaced (after a compaction by the writer) or truncated, it is reopened and replayed from the start
+// (as is a file that was empty, since its format is only known once rows are written). Watchers are notified of the loaded rows (see Watch).
+func (f *File) Refresh() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.opts.ReadOnly {
+		return fmt.Errorf("refresh: %w", ErrNotReadOnly)
+	}
+	defer f.updateMapping()
+	latest, err := f.opts.Storage(f.fpath, StorageReadOnly)
+	if err != nil {
+		return fmt.Errorf("open datafile: %w", err)
+	}
+	size, err := latest.Size()
+	if err != nil {
+		latest.Close()
+		return fmt.Errorf("stat datafile: %w", err)
+	}
+	if !sameStorage(f.store, latest) || size < int64(f.woffset) || (f.woffset == 0 && size > 0) {
+		return f.reload(latest)
+	}
+	latest.Close()
+	if size == int64(f.woffset) {
+		return nil
+	}
+
+	var rows []*Row // rows to notify
+	var resolveErr error
+	src := bufio.NewReaderSize(io.NewSectionReader(f.store, int64(f.woffset), math.MaxInt64-int64(f.woffset)), f.opts.ReadBufferSize)
+	offset, numRows, err := f.scanFrames(src, f.woffset, -1, func(row *Row, p fidx.Position) bool {
+		if row.valueRef.Size() != 0 && resolveErr == nil {
+			resolveErr = f.resolveValueRef(f.store, row) // see WithValueDedup
+		}
+		f.applyRow(row, p, f.idx, f.sys)
+		if len(f.watchers) > 0 {
+			copied := *row // decoding allocates new keys and values, only the row is reused
+			rows = append(rows, &copied)
+		}
+		return true
+	}, f.applyOp)
+	f.woffset, f.numRows = offset, f.numRows+numRows
+	if err = errors.Join(err, resolveErr); err != nil {
+		return fmt.Errorf("refresh: %w", err)
+	}
+	f.notify(rows)
+	if err := f.loadSeq(); err != nil {
+		return err
+	}
+	return f.loadPolicies()
+}
+
+// ErrNotReadOnly is returned when refreshing a file opened for writing.
+var ErrNotReadOnly = errors.New("file is not read-only")
+
+// reload replaces the storage of the read-only file with the given one and replays it from the start.
+func (f *File) reload(r Storage) error {
+	format := f.format
+	if err := f.detectFormat(io.NewSectionReader(r, 0, math.MaxInt64)); err != nil {
+		r.Close()
+		return err
+	}
+	idx, sys := f.newKeydir(), fidx.NewTrieIndex()
+	f.resetOps()
+	woffset, numRows, err := f.replayWithOps(r, 0, idx, sys)
+	if err != nil {
+		r.Close()
+		f.format = format
+		return fmt.Errorf("replay: %w", err)
+	}
+	f.store.Close()
+	f.store, f.idx, f.sys, f.woffset, f.numRows = r, idx, sys, woffset, numRows
+	f.countKeys()
+	if err := f.loadHeaderSize(); err != nil {
+		return err
+	}
+	if err := f.loadSeq(); err != nil {
+		return err
+	}
+	if err := f.loadPolicies(); err != nil {
+		return err
+	}
+	return f.rebuildIndexes()
+}
+
+// tailLoop refreshes the read-only file periodically (see WithTail).
+func (f *File) tailLoop() {
+	defer close(f.loopDone)
+	ticker := time.NewTicker(f.opts.TailInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stopLoop:
+			return
+		case <-ticker.C:
+		}
+		f.Refresh() // failed refreshes are retried on the next tick
+	}
+}
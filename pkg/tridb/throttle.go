@@ -0,0 +1,54 @@
+package tridb
+
+import (
+	"io"
+	"time"
+)
+
+// rateLimiter paces the I/O of maintenance operations to a number of bytes per second (see WithMaintenanceRateLimit).
+// A nil limiter doesn't limit.
+type rateLimiter struct {
+	rate  int // bytes per second
+	start time.Time
+	n     int // bytes transferred since start
+}
+
+// newRateLimiter returns the limiter of a maintenance operation (nil if the rate is unlimited).
+func (f *File) newRateLimiter() *rateLimiter {
+	if f.opts.MaintenanceRate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: f.opts.MaintenanceRate, start: time.Now()}
+}
+
+// wait records that n bytes were transferred and sleeps until the rate is honoured.
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.n += n
+	if ahead := time.Duration(float64(l.n)/float64(l.rate)*float64(time.Second)) - time.Since(l.start); ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
+
+// throttledWriter paces the writes to w with the given limiter.
+type throttledWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.limiter.wait(n)
+	return n, err
+}
+
+// copyThrottled copies src to dst at the maintenance rate of the file (see WithMaintenanceRateLimit).
+func (f *File) copyThrottled(dst io.Writer, src io.Reader) (int, error) {
+	if limiter := f.newRateLimiter(); limiter != nil {
+		dst = &throttledWriter{w: dst, limiter: limiter}
+	}
+	n, err := io.Copy(dst, src)
+	return int(n), err
+}
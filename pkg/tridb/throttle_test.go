@@ -0,0 +1,36 @@
+package tridb
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceRateLimit(t *testing.T) {
+	const rate = 1 << 20
+	f := openTestFile(t, WithMaintenanceRateLimit(rate))
+	for i := 0; i < 100; i++ {
+		mustSet(t, f, "key"+strconv.Itoa(i), strings.Repeat("v", 2048))
+	}
+	want := time.Duration(float64(f.woffset) / rate * float64(time.Second))
+
+	start := time.Now()
+	n, err := f.CopyTo(io.Discard)
+	if err != nil || n != f.woffset {
+		t.Fatalf("copied %d bytes (%v) instead of %d", n, err, f.woffset)
+	}
+	if elapsed := time.Since(start); elapsed < want*9/10 {
+		t.Fatalf("copy took %s instead of at least %s", elapsed, want)
+	}
+
+	start = time.Now()
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < want/2 { // the last buffered rows are written without throttling
+		t.Fatalf("compaction took %s instead of at least %s", elapsed, want/2)
+	}
+	assertValue(t, f, "key99", strings.Repeat("v", 2048))
+}
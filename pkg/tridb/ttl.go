@@ -0,0 +1,246 @@
+package tridb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// Prefix TTL policies are stored in the reserved keyspace.
+const prefixTTLKeyPrefix = "prefix-ttl/"
+
+type prefixTTL struct {
+	prefix []byte
+	ttl    time.Duration
+}
+
+// SetPrefixTTL makes all keys starting with the given prefix expire ttl after they were written.
+// Expired keys are hidden from readers and dropped on the next compaction.
+// When several policies match a key, the one with the longest prefix applies.
+// A zero (or negative) TTL removes the policy.
+//
+// The policy is persisted in the file. Rows written without timestamps never expire (see WithTimestamps).
+func (f *File) SetPrefixTTL(prefix []byte, ttl time.Duration) error {
+	name := prefixTTLKeyPrefix + string(prefix)
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		if ttl <= 0 {
+			w.deleteReserved(name)
+		} else {
+			w.setReserved(name, binary.BigEndian.AppendUint64(nil, uint64(ttl)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.loadPrefixTTLs()
+}
+
+// PrefixTTLs returns the prefix TTL policies.
+func (f *File) PrefixTTLs() map[string]time.Duration {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	policies := make(map[string]time.Duration, len(f.prefixTTLs))
+	for _, policy := range f.prefixTTLs {
+		policies[string(policy.prefix)] = policy.ttl
+	}
+	return policies
+}
+
+// loadPrefixTTLs loads the prefix TTL policies from the reserved keyspace.
+// The policies are sorted by decreasing prefix length (so the first match is the longest).
+func (f *File) loadPrefixTTLs() error {
+	var policies []prefixTTL
+	keyPrefix := reservedKey(prefixTTLKeyPrefix)
+	err := f.sys.WalkRange(keyPrefix, fidx.PrefixEnd(keyPrefix), false, func(rowInfo *fidx.RowInfo) error {
+		row, err := f.readAndDecodeRow(f.store, rowInfo.Position)
+		if err != nil {
+			return err
+		}
+		if len(row.Value) != 8 {
+			return fmt.Errorf("invalid TTL value of length %d", len(row.Value))
+		}
+		prefix := bytes.TrimPrefix(rowInfo.Key, keyPrefix)
+		policies = append(policies, prefixTTL{prefix: prefix, ttl: time.Duration(binary.BigEndian.Uint64(row.Value))})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load prefix TTL policies: %w", err)
+	}
+	sort.Slice(policies, func(i, j int) bool { return len(policies[i].prefix) > len(policies[j].prefix) })
+	f.prefixTTLs = policies
+	return nil
+}
+
+// SetWithTTL is like Set but the key expires after the given duration.
+// Expired keys are hidden from readers and dropped on the next compaction.
+func (w *Writer) SetWithTTL(key, value []byte, ttl time.Duration) {
+	w.SetWithDeadline(key, value, w.now.Add(ttl))
+}
+
+// SetWithDeadline is like Set but the key expires at the given time.
+func (w *Writer) SetWithDeadline(key, value []byte, deadline time.Time) {
+	if value, ok := w.beforeSet(key, value); ok && w.checkValue(value) {
+		w.stage(&Row{Key: key, Value: value, ExpiresAt: deadline.UnixNano()})
+	}
+}
+
+// SetExpiring sets the given key-value pairs (values[i] is the value of keys[i]) expiring after the given duration,
+// all at the same time.
+func (w *Writer) SetExpiring(keys, values [][]byte, ttl time.Duration) {
+	if len(keys) != len(values) {
+		if w.err == nil {
+			w.err = fmt.Errorf("set expiring: %d keys for %d values", len(keys), len(values))
+		}
+		return
+	}
+	deadline := w.now.Add(ttl)
+	for i, key := range keys {
+		w.SetWithDeadline(key, values[i], deadline)
+	}
+}
+
+// TTL returns the remaining lifetime of the given key (according to its expiration time and prefix TTL policies),
+// it reports false if the key doesn't exist or doesn't expire.
+func (r *Reader) TTL(key []byte) (time.Duration, bool) {
+	row := r.get(key)
+	if row == nil {
+		return 0, false
+	}
+	expiresAt := r.expiresAt(row)
+	if expiresAt == 0 {
+		return 0, false
+	}
+	return time.Duration(expiresAt - r.now), true
+}
+
+// expiresAt returns the expiration time of the given row in Unix nanoseconds (0 if it doesn't expire),
+// the earliest of its expiration time and the one of the prefix TTL policy applying to it.
+func (r *Reader) expiresAt(row *fidx.RowInfo) int64 {
+	expiresAt := row.ExpiresAt
+	if len(r.ttls) == 0 || row.Timestamp == 0 {
+		return expiresAt
+	}
+	for _, policy := range r.ttls {
+		if bytes.HasPrefix(row.Key, policy.prefix) {
+			if byPolicy := row.Timestamp + int64(policy.ttl); expiresAt == 0 || byPolicy < expiresAt {
+				expiresAt = byPolicy
+			}
+			break
+		}
+	}
+	return expiresAt
+}
+
+// expired reports whether the given row expired (according to its expiration time or prefix TTL policies).
+func (r *Reader) expired(row *fidx.RowInfo) bool {
+	expiresAt := r.expiresAt(row)
+	return expiresAt != 0 && r.now >= expiresAt
+}
+
+// countExpired returns the number of expired keys (not yet removed by compaction).
+func (r *Reader) countExpired() int {
+	count := 0
+	r.walkExpired(func(row *fidx.RowInfo) { count++ })
+	return count
+}
+
+// walkExpired calls do for each expired key (not yet removed by compaction or the sweeper).
+func (r *Reader) walkExpired(do func(row *fidx.RowInfo)) {
+	_ = r.walkExpiring(r.now+1, func(row *fidx.RowInfo, expiresAt int64) error {
+		do(row)
+		return nil
+	})
+}
+
+// walkPolicies calls do for each key matching a prefix TTL policy.
+func (r *Reader) walkPolicies(do func(row *fidx.RowInfo)) {
+	for i, policy := range r.ttls {
+		// Skip policies nested in another policy (their keys are already walked).
+		isNested := false
+		for j, other := range r.ttls {
+			if i != j && len(other.prefix) < len(policy.prefix) && bytes.HasPrefix(policy.prefix, other.prefix) {
+				isNested = true
+			}
+		}
+		if isNested {
+			continue
+		}
+		_ = r.idx.WalkRange(policy.prefix, fidx.PrefixEnd(policy.prefix), false, func(row *fidx.RowInfo) error {
+			do(row)
+			return nil
+		})
+	}
+}
+
+// sweepBatchSize is the maximum number of expired keys deleted per transaction by File.SweepExpired.
+const sweepBatchSize = 1024
+
+// SweepExpired deletes the expired keys (see SetWithTTL and SetPrefixTTL), so that their keydir entries are freed
+// before the next compaction. Keys are deleted by batches of transactions. It reports the number of deleted keys.
+//
+// Note: with hashed keys (see WithHashedKeys), the rows of expired keys are read to get their key.
+func (f *File) SweepExpired() (int, error) {
+	var keys [][]byte
+	err := f.Read(func(r *Reader) error {
+		var err error
+		r.walkExpired(func(row *fidx.RowInfo) {
+			key, rowErr := r.rowKey(row)
+			if rowErr != nil {
+				err = errors.Join(err, rowErr)
+				return
+			}
+			keys = append(keys, key)
+		})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("collect expired keys: %w", err)
+	}
+
+	swept := 0
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), sweepBatchSize)]
+		keys = keys[len(batch):]
+		deleted := 0
+		err := f.ReadWrite(func(r *Reader, w *Writer) error {
+			deleted = 0
+			for _, key := range batch {
+				// The key may have been written since it was collected.
+				if row := r.idx.Get(r.f.indexKey(key)); row != nil && r.expired(row) {
+					w.Delete(key)
+					deleted++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return swept, err
+		}
+		swept += deleted
+	}
+	return swept, nil
+}
+
+// sweepLoop deletes the expired keys periodically until the file is closed (see WithExpirySweeper).
+func (f *File) sweepLoop() {
+	defer close(f.sweepDone)
+	ticker := time.NewTicker(f.opts.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stopSweep:
+			return
+		case <-ticker.C:
+		}
+		f.SweepExpired() // failed sweeps are retried on the next tick
+	}
+}
@@ -0,0 +1,167 @@
+package tridb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrefixTTL(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { f.Close() }()
+
+	if err := f.SetPrefixTTL([]byte("cache/"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetPrefixTTL([]byte("cache/short/"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, f, "cache/a", "1")
+	mustSet(t, f, "cache/short/b", "2")
+	mustSet(t, f, "other", "3")
+	time.Sleep(2 * time.Millisecond)
+
+	assertVisibleKeys := func(want int) {
+		t.Helper()
+		_ = f.Read(func(r *Reader) error {
+			if r.Has([]byte("cache/short/b")) {
+				t.Fatal("expired key should not be visible")
+			}
+			walked, _ := r.Walk(nil, func(key []byte) error { return nil })
+			if got := r.Count(); got != want || walked != want {
+				t.Fatalf("got count %d and walked %d keys instead of %d", got, walked, want)
+			}
+			return nil
+		})
+	}
+	assertVisibleKeys(2)
+	assertValue(t, f, "cache/a", "1")
+
+	// Policies are persisted and expired rows are dropped by compaction.
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	f, err = Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.PrefixTTLs()["cache/short/"]; got != time.Millisecond {
+		t.Fatalf("got TTL %s instead of %s", got, time.Millisecond)
+	}
+	if got := f.idx.Chronological().Count; got != 2 {
+		t.Fatalf("got %d rows after compaction instead of 2", got)
+	}
+	assertVisibleKeys(2)
+}
+
+func TestSetWithTTL(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.tridb")
+	f, err := Open(fpath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { f.Close() }()
+
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetWithTTL([]byte("short"), []byte("1"), time.Millisecond)
+		w.SetWithTTL([]byte("long"), []byte("2"), time.Hour)
+		w.Set([]byte("forever"), []byte("3"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	assertVisible := func() {
+		t.Helper()
+		_ = f.Read(func(r *Reader) error {
+			if v, _ := r.Get([]byte("short")); r.Has([]byte("short")) || v != nil {
+				t.Fatal("expired key should not be visible")
+			}
+			if got := r.Count(); got != 2 {
+				t.Fatalf("got count %d instead of 2", got)
+			}
+			return nil
+		})
+		assertValue(t, f, "long", "2")
+	}
+	assertVisible()
+
+	// Expiration times are persisted and expired keys are dropped by compaction.
+	f.Close()
+	if f, err = Open(fpath, 1); err != nil {
+		t.Fatal(err)
+	}
+	assertVisible()
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.idx.Chronological().Count; got != 2 {
+		t.Fatalf("got %d rows after compaction instead of 2", got)
+	}
+	assertVisible()
+}
+
+func TestTTL(t *testing.T) {
+	f := openTestFile(t)
+	if err := f.SetPrefixTTL([]byte("cache/"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetExpiring([][]byte{[]byte("a"), []byte("cache/b")}, [][]byte{[]byte("1"), []byte("2")}, time.Minute)
+		w.Set([]byte("cache/c"), []byte("3"))
+		w.Set([]byte("d"), []byte("4"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Read(func(r *Reader) error {
+		for key, want := range map[string]time.Duration{"a": time.Minute, "cache/b": time.Minute, "cache/c": time.Hour} {
+			if ttl, ok := r.TTL([]byte(key)); !ok || ttl <= want-time.Second || ttl > want {
+				t.Fatalf("%s: got TTL %s (%v) instead of about %s", key, ttl, ok, want)
+			}
+		}
+		for _, key := range []string{"d", "missing"} {
+			if ttl, ok := r.TTL([]byte(key)); ok {
+				t.Fatalf("%s: got TTL %s for a key that doesn't expire", key, ttl)
+			}
+		}
+		return nil
+	})
+
+	err = f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetExpiring([][]byte{[]byte("a")}, nil, time.Minute)
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected an error with more keys than values")
+	}
+}
+
+func TestSweepExpired(t *testing.T) {
+	f := openTestFile(t, WithExpirySweeper(time.Millisecond))
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.SetWithDeadline([]byte("expired"), []byte("1"), time.Now().Add(-time.Second))
+		w.SetWithTTL([]byte("alive"), []byte("2"), time.Hour)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); f.Stats().Keys != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d keys in the keydir, the expired key was not swept", f.Stats().Keys)
+		}
+	}
+	assertValue(t, f, "alive", "2")
+	if n, err := f.SweepExpired(); err != nil || n != 0 {
+		t.Fatalf("swept %d keys (%v) with no expired keys", n, err)
+	}
+}
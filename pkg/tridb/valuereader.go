@@ -0,0 +1,138 @@
+package tridb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ValueReader streams a value (see File.GetReader).
+type ValueReader struct {
+	src      io.Reader
+	size     int
+	handle   Storage // dedicated read handle (nil if the value was read in memory)
+	key      []byte
+	checksum uint32 // expected checksum of the row (0 if absent)
+	sum      uint32 // checksum of the key and the value read so far
+}
+
+// GetReader returns a reader streaming the value of the given key, or nil if the key doesn't exist.
+// The reader must be closed.
+//
+// The file is only locked while looking up the key: the value is then read from a dedicated handle
+// holding the row, so slow readers of large values don't block writers and compactions don't remove the row mid-stream.
+//
+// Plain values of files using the binary format are streamed from disk (their checksum is verified once fully read).
+// Aliases, encrypted or compressed values, merge operands and transformed values (see WithReadTransform) are read with Get.
+func (f *File) GetReader(key []byte) (*ValueReader, error) {
+	return f.GetReaderCtx(context.Background(), key)
+}
+
+// GetReaderCtx is like GetReader but gives up waiting for the lock when ctx is done.
+func (f *File) GetReaderCtx(ctx context.Context, key []byte) (vr *ValueReader, err error) {
+	err = f.ReadCtx(ctx, func(r *Reader) error {
+		rowInfo := r.get(key)
+		if rowInfo == nil {
+			return nil
+		}
+		vr, err = r.streamValue(key, rowInfo.Position.Offset(), rowInfo.Position.Size())
+		if err != nil || vr != nil {
+			return err
+		}
+		value, err := r.readValue(key, rowInfo.Position)
+		if err != nil {
+			return err
+		}
+		vr = &ValueReader{src: bytes.NewReader(value), size: len(value)}
+		return nil
+	})
+	return vr, err
+}
+
+// streamValue returns a reader streaming the plain value of the row at the given position from a dedicated handle,
+// or nil if the value must be read in memory (see File.GetReader).
+func (r *Reader) streamValue(key []byte, offset, size int) (*ValueReader, error) {
+	f := r.f
+	if _, binary := f.format.(binaryFormat); !binary || r.detached != nil || f.opts.ReadTransform != nil || f.hasGetHooks() || f.opts.ParanoidChecks || f.opts.KeySecret != nil {
+		return nil, nil
+	}
+
+	row, valueLength, headLength, err := r.readRowHead(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	if row.IsAlias || row.IsSealed || row.IsMerge || row.Compression != NoCompression || row.valueRef.Size() != 0 {
+		return nil, nil
+	}
+
+	// The handle is opened with the lock held, so it refers to the file holding the row
+	// even if a compaction replaces it later on.
+	h, err := f.opts.Storage(f.fpath, StorageReadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("open read handle: %w", err)
+	}
+	vr := &ValueReader{
+		src:      io.NewSectionReader(h, int64(offset+headLength), int64(valueLength)),
+		size:     valueLength,
+		handle:   h,
+		key:      row.Key,
+		checksum: row.Checksum,
+	}
+	if vr.checksum != 0 {
+		vr.sum = checksumKeyLength(0, len(row.Key))
+		vr.sum = crc32.Update(vr.sum, castagnoli, row.Key)
+	}
+	return vr, nil
+}
+
+// readRowHead decodes the row at the given position of a file using the binary format without reading its value,
+// it reports the length of the value and the number of bytes of the row before the value.
+func (r *Reader) readRowHead(offset, size int) (*Row, int, int, error) {
+	// Decode the row without its value (the value length is zeroed in the header).
+	header := [rowHeaderSize]byte{}
+	if _, err := r.ra.ReadAt(header[:], int64(offset)); err != nil {
+		return nil, 0, 0, fmt.Errorf("read row header: %w", err)
+	}
+	valueLength := int(binary.BigEndian.Uint32(header[2:]))
+	if valueLength > size-rowHeaderSize {
+		return nil, 0, 0, fmt.Errorf("%w: value length %d exceeds row size %d at offset %d", ErrIndexMismatch, valueLength, size, offset)
+	}
+	encoded := make([]byte, size-valueLength)
+	if _, err := r.ra.ReadAt(encoded, int64(offset)); err != nil {
+		return nil, 0, 0, fmt.Errorf("read row: %w", err)
+	}
+	binary.BigEndian.PutUint32(encoded[2:], 0)
+	row := &Row{}
+	if err := row.decodeInPlace(encoded); err != nil {
+		return nil, 0, 0, fmt.Errorf("decode row: %w", err)
+	}
+	return row, valueLength, len(encoded), nil
+}
+
+// Size returns the size of the value in bytes.
+func (vr *ValueReader) Size() int { return vr.size }
+
+// Read reads the next bytes of the value.
+// Once the value is fully read, it returns an error wrapping ErrChecksumMismatch if its checksum doesn't match.
+func (vr *ValueReader) Read(p []byte) (int, error) {
+	n, err := vr.src.Read(p)
+	if vr.checksum == 0 {
+		return n, err
+	}
+	vr.sum = crc32.Update(vr.sum, castagnoli, p[:n])
+	if err == io.EOF && vr.sum != vr.checksum && !(vr.sum == 0 && vr.checksum == 1) {
+		return n, fmt.Errorf("%w for key %q", ErrChecksumMismatch, vr.key)
+	}
+	return n, err
+}
+
+// Close releases the read handle.
+func (vr *ValueReader) Close() error {
+	if vr.handle == nil {
+		return nil
+	}
+	return vr.handle.Close()
+}
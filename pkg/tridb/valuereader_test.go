@@ -0,0 +1,93 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestGetReader(t *testing.T) {
+	f := openTestFile(t, WithRowChecksums(true))
+	value := bytes.Repeat([]byte("0123456789"), 10000)
+	mustSet(t, f, "large", string(value))
+	mustSet(t, f, "target", "aliased")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error { w.Alias([]byte("alias"), []byte("target")); return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes and compactions are not blocked by an open reader.
+	vr, err := f.GetReader([]byte("large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vr.Close()
+	if vr.Size() != len(value) {
+		t.Fatalf("got size %d instead of %d", vr.Size(), len(value))
+	}
+	mustSet(t, f, "large", "overwritten")
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(vr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Fatalf("got %d bytes instead of the value at the time of GetReader", len(got))
+	}
+
+	// Aliases are resolved and missing keys report a nil reader.
+	vr, err = f.GetReader([]byte("alias"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(vr); string(got) != "aliased" {
+		t.Fatalf("got %q instead of %q", got, "aliased")
+	}
+	vr.Close()
+	if vr, err := f.GetReader([]byte("missing")); vr != nil || err != nil {
+		t.Fatalf("got reader %v and error %v for a missing key", vr, err)
+	}
+}
+
+func TestGetReaderDedup(t *testing.T) {
+	f := openTestFile(t, WithValueDedup(64))
+	value := string(bytes.Repeat([]byte("v"), 100))
+	mustSet(t, f, "a", value)
+	mustSet(t, f, "b", value) // references the value of "a"
+
+	vr, err := f.GetReader([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vr.Close()
+	if got, err := io.ReadAll(vr); string(got) != value || vr.Size() != len(value) || err != nil {
+		t.Fatalf("got %q (size %d, %v) instead of %q", got, vr.Size(), err, value)
+	}
+}
+
+func TestGetReaderChecksumMismatch(t *testing.T) {
+	f := openTestFile(t, WithRowChecksums(true))
+	mustSet(t, f, "key", "value")
+	vr, err := f.GetReader([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vr.Close()
+
+	// Corrupt the value on disk.
+	h, err := os.OpenFile(f.Path(), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if _, err := h.WriteAt([]byte("V"), int64(f.Stats().FileSize-len("value"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(vr); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got error %v instead of %v", err, ErrChecksumMismatch)
+	}
+}
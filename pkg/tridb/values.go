@@ -0,0 +1,101 @@
+package tridb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrValueType is returned when decoding a typed value of the wrong size.
+var ErrValueType = errors.New("invalid typed value")
+
+// EncodeInt64 returns an 8-byte encoding of n whose lexicographical order matches numeric order
+// (big-endian with the sign bit flipped), it can be used for keys and values.
+func EncodeInt64(n int64) []byte { return binary.BigEndian.AppendUint64(nil, uint64(n)^(1<<63)) }
+
+// DecodeInt64 decodes a value encoded with EncodeInt64.
+func DecodeInt64(v []byte) (int64, error) {
+	if len(v) != 8 {
+		return 0, fmt.Errorf("%w: int64 of length %d", ErrValueType, len(v))
+	}
+	return int64(binary.BigEndian.Uint64(v) ^ (1 << 63)), nil
+}
+
+// EncodeFloat64 returns an 8-byte encoding of x whose lexicographical order matches numeric order
+// (the sign bit of positive numbers is flipped, all bits of negative numbers are flipped).
+// NaNs are ordered after positive infinity.
+func EncodeFloat64(x float64) []byte {
+	bits := math.Float64bits(x)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits ^= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+// DecodeFloat64 decodes a value encoded with EncodeFloat64.
+func DecodeFloat64(v []byte) (float64, error) {
+	if len(v) != 8 {
+		return 0, fmt.Errorf("%w: float64 of length %d", ErrValueType, len(v))
+	}
+	bits := binary.BigEndian.Uint64(v)
+	if bits&(1<<63) != 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), nil
+}
+
+// SetInt64 sets the key to the given integer (see EncodeInt64).
+func (w *Writer) SetInt64(key []byte, n int64) { w.Set(key, EncodeInt64(n)) }
+
+// SetFloat64 sets the key to the given float (see EncodeFloat64).
+func (w *Writer) SetFloat64(key []byte, x float64) { w.Set(key, EncodeFloat64(x)) }
+
+// GetInt64 returns the integer value of the key (see SetInt64) and reports whether the key exists.
+func (r *Reader) GetInt64(key []byte) (int64, bool, error) {
+	v, err := r.Get(key)
+	if err != nil || v == nil {
+		return 0, false, err
+	}
+	n, err := DecodeInt64(v)
+	return n, err == nil, err
+}
+
+// GetFloat64 returns the float value of the key (see SetFloat64) and reports whether the key exists.
+func (r *Reader) GetFloat64(key []byte) (float64, bool, error) {
+	v, err := r.Get(key)
+	if err != nil || v == nil {
+		return 0, false, err
+	}
+	x, err := DecodeFloat64(v)
+	return x, err == nil, err
+}
+
+// IncrementInt64 atomically adds delta to the integer value of the key (a missing key counts as zero)
+// and returns the new value. It fails with ErrValueType if the current value is not an int64.
+func (f *File) IncrementInt64(key []byte, delta int64) (int64, error) {
+	var n int64
+	err := f.ReadWrite(func(r *Reader, w *Writer) (err error) {
+		n, _, err = r.GetInt64(key)
+		n += delta
+		w.SetInt64(key, n)
+		return err
+	})
+	return n, err
+}
+
+// IncrementFloat64 is like IncrementInt64 for float values.
+func (f *File) IncrementFloat64(key []byte, delta float64) (float64, error) {
+	var x float64
+	err := f.ReadWrite(func(r *Reader, w *Writer) (err error) {
+		x, _, err = r.GetFloat64(key)
+		x += delta
+		w.SetFloat64(key, x)
+		return err
+	})
+	return x, err
+}
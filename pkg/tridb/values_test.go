@@ -0,0 +1,78 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestTypedValues(t *testing.T) {
+	ints := []int64{math.MinInt64, -300, -1, 0, 1, 256, math.MaxInt64}
+	for i, n := range ints {
+		if got, err := DecodeInt64(EncodeInt64(n)); err != nil || got != n {
+			t.Fatalf("got %d (%v) instead of %d", got, err, n)
+		}
+		if i > 0 && bytes.Compare(EncodeInt64(ints[i-1]), EncodeInt64(n)) >= 0 {
+			t.Fatalf("%d and %d are not ordered", ints[i-1], n)
+		}
+	}
+	floats := []float64{math.Inf(-1), -1e10, -0.5, 0, 1e-10, 3.14, math.Inf(1)}
+	for i, x := range floats {
+		if got, err := DecodeFloat64(EncodeFloat64(x)); err != nil || got != x {
+			t.Fatalf("got %g (%v) instead of %g", got, err, x)
+		}
+		if i > 0 && bytes.Compare(EncodeFloat64(floats[i-1]), EncodeFloat64(x)) >= 0 {
+			t.Fatalf("%g and %g are not ordered", floats[i-1], x)
+		}
+	}
+
+	f := openTestFile(t)
+	for i := 0; i < 3; i++ {
+		if _, err := f.IncrementInt64([]byte("hits"), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := f.IncrementInt64([]byte("hits"), -1); err != nil || n != 5 {
+		t.Fatalf("got %d (%v) instead of 5", n, err)
+	}
+	if x, err := f.IncrementFloat64([]byte("temperature"), -1.5); err != nil || x != -1.5 {
+		t.Fatalf("got %g (%v) instead of -1.5", x, err)
+	}
+	mustSet(t, f, "text", "not a number")
+	if _, err := f.IncrementInt64([]byte("text"), 1); !errors.Is(err, ErrValueType) {
+		t.Fatalf("got error %v instead of %v", err, ErrValueType)
+	}
+	assertValue(t, f, "text", "not a number")
+}
+
+func TestGetAppend(t *testing.T) {
+	f := openTestFile(t, WithRowChecksums(true))
+	mustSet(t, f, "a", "value")
+	err := f.ReadWrite(func(r *Reader, w *Writer) error {
+		w.Alias([]byte("b"), []byte("a"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Read(func(r *Reader) error {
+		buf := []byte("prefix:")
+		buf, err := r.GetAppend(buf, []byte("a"))
+		if err != nil || string(buf) != "prefix:value" {
+			t.Fatalf("got %q (%v)", buf, err)
+		}
+		if buf, _ = r.GetAppend(buf[:0], []byte("missing")); len(buf) != 0 {
+			t.Fatalf("got %q for missing key", buf)
+		}
+		if buf, _ = r.GetAppend(buf[:0], []byte("b")); string(buf) != "value" {
+			t.Fatalf("got %q for alias", buf)
+		}
+		key := []byte("a")
+		allocs := testing.AllocsPerRun(100, func() { buf, _ = r.GetAppend(buf[:0], key) })
+		if allocs > 0 {
+			t.Fatalf("got %g allocations per read", allocs)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,121 @@
+package tridb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// DefaultVerifyMaxProblems is the number of problems reported by File.Verify when none is configured.
+const DefaultVerifyMaxProblems = 100
+
+// VerifyOptions configures File.Verify.
+type VerifyOptions struct {
+	SkipKeydir  bool // Only verify the structure of rows (don't cross-check the keydir).
+	MaxProblems int  // Maximum number of reported problems (defaults to DefaultVerifyMaxProblems).
+}
+
+// VerifyProblem is a corruption found by File.Verify.
+type VerifyProblem struct {
+	Offset int    // Offset of the row in the file.
+	Key    []byte // Key of the row (nil if the row can't be decoded).
+	Err    error
+}
+
+// VerifyReport is the result of File.Verify.
+type VerifyReport struct {
+	FileSize       int
+	Rows           int     // Number of decoded rows.
+	LiveRows       int     // Number of rows referenced by the keydir.
+	DeadBytes      int     // Bytes of overwritten or deleted rows (and batch headers), reclaimed by compaction.
+	DeadRatio      float64 // DeadBytes / FileSize.
+	ChecksumErrors int
+	// FirstCorruption is the offset of the first corrupted row (or -1 if none).
+	FirstCorruption int
+	// Problems lists the corruptions found (up to VerifyOptions.MaxProblems), ordered by offset
+	// for rows of the file, followed by keydir entries that don't match the file.
+	Problems []VerifyProblem
+}
+
+// OK reports whether no corruption was found.
+func (r *VerifyReport) OK() bool { return r.FirstCorruption < 0 }
+
+func (r *VerifyReport) addProblem(max, offset int, key []byte, err error) {
+	if r.FirstCorruption < 0 || offset < r.FirstCorruption {
+		r.FirstCorruption = offset
+	}
+	if len(r.Problems) < max {
+		r.Problems = append(r.Problems, VerifyProblem{Offset: offset, Key: bytes.Clone(key), Err: err})
+	}
+}
+
+// Verify scans the whole datafile, validates the structure (and checksum, if any) of every row,
+// and cross-checks the keydir against the content of the file (like fsck).
+// Corruptions are reported, the returned error is only about failing to run the verification.
+//
+// Note: Verify holds the read lock while scanning the file, it is meant for admin tooling, not hot paths.
+func (f *File) Verify(opts *VerifyOptions) (*VerifyReport, error) {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	maxProblems := opts.MaxProblems
+	if maxProblems <= 0 {
+		maxProblems = DefaultVerifyMaxProblems
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if err := f.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{FileSize: f.woffset, FirstCorruption: -1}
+	liveBytes := 0
+	src := bufio.NewReaderSize(io.NewSectionReader(f.store, 0, int64(f.woffset)), f.opts.ReadBufferSize)
+	end, _, err := f.scanRows(src, 0, -1, func(row *Row, p fidx.Position) {
+		report.Rows++
+		if err := row.VerifyChecksum(); err != nil {
+			report.ChecksumErrors++
+			report.addProblem(maxProblems, p.Offset(), row.Key, err)
+		}
+		idx := f.idx
+		if IsReservedKey(row.Key) {
+			idx = f.sys
+		}
+		if rowInfo := idx.Get(f.indexKey(row.Key)); rowInfo != nil && rowInfo.Position == p {
+			report.LiveRows++
+			liveBytes += p.Size()
+		}
+	})
+	if err != nil {
+		report.addProblem(maxProblems, end, nil, err)
+	} else if end < f.woffset {
+		report.addProblem(maxProblems, end, nil, fmt.Errorf("%w: %d bytes missing at the end of the file", io.ErrUnexpectedEOF, f.woffset-end))
+	}
+	report.DeadBytes = f.woffset - f.headerSize - liveBytes
+	if f.woffset > 0 {
+		report.DeadRatio = float64(report.DeadBytes) / float64(f.woffset)
+	}
+	if opts.SkipKeydir {
+		return report, nil
+	}
+
+	// Cross-check keydir entries that didn't match a decoded row.
+	if report.LiveRows != f.idx.Chronological().Count+f.sys.Chronological().Count {
+		for _, idx := range [...]fidx.Keydir{f.sys, f.idx} {
+			for rowInfo := idx.Chronological().Oldest; rowInfo != nil; rowInfo = rowInfo.Next {
+				row, err := f.readAndDecodeRow(f.store, rowInfo.Position)
+				if err == nil && (row.IsDeleted || !bytes.Equal(f.indexKey(row.Key), rowInfo.Key)) {
+					err = fmt.Errorf("%w: found row for %q", ErrIndexMismatch, row.Key)
+				}
+				if err != nil && !errors.Is(err, ErrChecksumMismatch) { // checksums were verified by the scan
+					report.addProblem(maxProblems, rowInfo.Position.Offset(), rowInfo.Key, fmt.Errorf("keydir entry: %w", err))
+				}
+			}
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,59 @@
+package tridb
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	f := openTestFile(t, WithRowChecksums(true))
+	mustSet(t, f, "a", "1")
+	mustSet(t, f, "a", "2")
+	offset := f.Stats().FileSize
+	mustSet(t, f, "b", "value")
+	mustSet(t, f, "c", "3")
+
+	report, err := f.Verify(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Rows != 4 || report.LiveRows != 3 || report.DeadBytes != (offset-f.headerSize)/2 {
+		t.Fatalf("got unexpected report: %+v", report)
+	}
+
+	corrupt := func(offset int, b byte) {
+		t.Helper()
+		fw, err := os.OpenFile(f.Path(), os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fw.Close()
+		if _, err := fw.WriteAt([]byte{b}, int64(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Corrupted value
+	corrupt(f.Stats().FileSize-1, 'x')
+	report, err = f.Verify(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || report.ChecksumErrors != 1 || len(report.Problems) != 1 || !errors.Is(report.Problems[0].Err, ErrChecksumMismatch) {
+		t.Fatalf("got unexpected report: %+v", report)
+	}
+
+	// Corrupted row structure
+	corrupt(offset, 'Z')
+	report, err = f.Verify(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FirstCorruption != offset || report.Rows != 2 {
+		t.Fatalf("got unexpected report: %+v", report)
+	}
+	if got := report.Problems[len(report.Problems)-1]; string(got.Key) != "b" || got.Offset != offset {
+		t.Fatalf("got unexpected keydir problem: %+v", got)
+	}
+}
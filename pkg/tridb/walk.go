@@ -0,0 +1,277 @@
+package tridb
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+
+	"github.com/ejuju/tridb/pkg/fidx"
+)
+
+// ErrBreak can be returned by the function called by walks to stop walking early,
+// the walk then returns a nil error.
+var ErrBreak = errors.New("break walk")
+
+// Walk calls do for each key starting with the given prefix, in lexicographical order.
+// Walking stops when do returns an error, the error is then returned (unless it is ErrBreak).
+// It reports the number of keys visited (do calls).
+//
+// Note: walks are efficient with the trie keydir (see WithKeydir),
+// with the default hash keydir, matching keys are sorted before being walked.
+func (r *Reader) Walk(prefix []byte, do func(key []byte) error) (int, error) {
+	visited := 0
+	err := r.walkRange(prefix, fidx.PrefixEnd(prefix), false, func(row *fidx.RowInfo) error {
+		key, err := r.rowKey(row)
+		if err != nil {
+			return err
+		}
+		visited++
+		return do(key)
+	})
+	return visited, err
+}
+
+// WalkWithValue is like Walk but also reads the value associated with each key.
+func (r *Reader) WalkWithValue(prefix []byte, do func(key, value []byte) error) (int, error) {
+	visited := 0
+	err := r.walkRange(prefix, fidx.PrefixEnd(prefix), false, func(row *fidx.RowInfo) error {
+		key, err := r.rowKey(row)
+		if err != nil {
+			return err
+		}
+		value, err := r.readValue(key, row.Position)
+		if err != nil {
+			return err
+		}
+		visited++
+		return do(key, value)
+	})
+	return visited, err
+}
+
+// WalkRange calls do for each key in [start, end) in lexicographical order (see Walk for errors).
+// A nil start or end means the range is unbounded on that side.
+func (r *Reader) WalkRange(start, end []byte, do func(key []byte) error) error {
+	return r.walkRange(start, end, false, func(row *fidx.RowInfo) error {
+		key, err := r.rowKey(row)
+		if err != nil {
+			return err
+		}
+		return do(key)
+	})
+}
+
+// FirstKey returns the smallest key starting with the given prefix (nil if there's none).
+// With the trie keydir (see WithKeydir), it descends to the key in O(key length) instead of walking the prefix.
+func (r *Reader) FirstKey(prefix []byte) ([]byte, error) { return r.edgeKey(prefix, false) }
+
+// LastKey returns the largest key starting with the given prefix (nil if there's none), see FirstKey.
+func (r *Reader) LastKey(prefix []byte) ([]byte, error) { return r.edgeKey(prefix, true) }
+
+// edgeKey returns the first key starting with the given prefix, in the given order.
+func (r *Reader) edgeKey(prefix []byte, reverse bool) ([]byte, error) {
+	var found []byte
+	err := r.walkRange(prefix, fidx.PrefixEnd(prefix), reverse, func(row *fidx.RowInfo) error {
+		key, err := r.rowKey(row)
+		if err != nil {
+			return err
+		}
+		found = key
+		return ErrBreak
+	})
+	return found, err
+}
+
+// WalkOptions configures Reader.WalkWithOptions.
+type WalkOptions struct {
+	// Shuffle visits keys in a pseudo-random order (determined by Seed) instead of lexicographical order,
+	// for example to warm caches or run load tests without the locality of sorted keys.
+	Shuffle bool
+	Seed    int64
+
+	// Limit is the maximum number of keys visited (0 means no limit).
+	Limit int
+	// StartAfter only visits the keys after the given key (before it if Reverse is set),
+	// the last key visited by a walk resumes it on the next page.
+	StartAfter []byte
+	// Reverse visits keys in reverse lexicographical order.
+	Reverse bool
+}
+
+// WalkWithOptions is like Walk but with the given options,
+// it returns the last key visited (nil if none) to resume the walk with WalkOptions.StartAfter.
+//
+// Note: shuffled walks collect the matching keys (after StartAfter) before visiting them,
+// they can't be resumed since keys are shuffled again.
+func (r *Reader) WalkWithOptions(prefix []byte, opts WalkOptions, do func(key []byte) error) ([]byte, error) {
+	start, end := prefix, fidx.PrefixEnd(prefix)
+	if opts.StartAfter != nil && !opts.Reverse {
+		if after := append(bytes.Clone(opts.StartAfter), 0); bytes.Compare(after, start) > 0 {
+			start = after
+		}
+	} else if opts.StartAfter != nil && (end == nil || bytes.Compare(opts.StartAfter, end) < 0) {
+		end = opts.StartAfter
+	}
+
+	var last []byte
+	visited := 0
+	if !opts.Shuffle {
+		err := r.walkRange(start, end, opts.Reverse, func(row *fidx.RowInfo) error {
+			key, err := r.rowKey(row)
+			if err != nil {
+				return err
+			}
+			visited, last = visited+1, key
+			if err := do(key); err != nil {
+				return err
+			}
+			if visited == opts.Limit {
+				return ErrBreak
+			}
+			return nil
+		})
+		return last, err
+	}
+	var keys [][]byte
+	err := r.walkRange(start, end, false, func(row *fidx.RowInfo) error {
+		key, err := r.rowKey(row)
+		keys = append(keys, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	rng.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	if opts.Limit > 0 && len(keys) > opts.Limit {
+		keys = keys[:opts.Limit]
+	}
+	for _, key := range keys {
+		last = key
+		if err := do(key); err != nil {
+			return last, ignoreBreak(err)
+		}
+	}
+	return last, nil
+}
+
+// WalkKeysAppend is like Walk but materializes each key into buf (grown as needed and reused between keys),
+// so that giant walks don't allocate per key: the key passed to do is only valid until do returns.
+//
+// Keys are kept whole in the keydir (front coding is only used by keydir snapshots, see fidx.EncodeSnapshot),
+// each key is thus copied from the keydir rather than decoded.
+func (r *Reader) WalkKeysAppend(prefix, buf []byte, do func(key []byte) error) error {
+	return r.walkRange(prefix, fidx.PrefixEnd(prefix), false, func(row *fidx.RowInfo) error {
+		key, err := r.rowKey(row)
+		if err != nil {
+			return err
+		}
+		buf = append(buf[:0], key...)
+		return do(buf)
+	})
+}
+
+// CountPrefix returns the number of keys starting with the given prefix.
+//
+// Note: with the trie keydir (see WithKeydir), keys are counted in O(len(prefix))
+// unless some keys may have expired (keys with an expiration time or prefix TTL policies),
+// otherwise matching keys are walked.
+func (r *Reader) CountPrefix(prefix []byte) (int, error) {
+	if r.f.opts.KeySecret != nil {
+		return 0, ErrHashedKeys
+	}
+	r.checkDeadline()
+	if counter, ok := r.idx.(fidx.PrefixCounter); ok && r.expiring == 0 && len(r.ttls) == 0 {
+		return counter.CountPrefix(r.f.collate(prefix)), nil
+	}
+	return r.Walk(prefix, func([]byte) error { return nil })
+}
+
+// walkRange walks the reader keydir, if the reader detaches from the lock during the walk
+// (see WithMaxReadDuration), the walk resumes on the snapshot after the last visited key.
+// ErrBreak returned by do stops the walk without error.
+func (r *Reader) walkRange(start, end []byte, reverse bool, do func(row *fidx.RowInfo) error) error {
+	if r.f.opts.KeySecret != nil {
+		return ErrHashedKeys
+	}
+	r.checkDeadline()
+	start, end = r.f.collate(start), r.f.collate(end)
+	var last []byte
+	idx := r.idx
+	err := idx.WalkRange(start, end, reverse, func(row *fidx.RowInfo) error {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		if r.checkDeadline(); r.idx != idx {
+			return errDetached
+		}
+		last = row.Key
+		if r.expired(row) {
+			return nil
+		}
+		return do(row)
+	})
+	if err != errDetached {
+		return ignoreBreak(err)
+	}
+	if last != nil && !reverse {
+		start = append(bytes.Clone(last), 0)
+	} else if last != nil {
+		end = last
+	}
+	err = r.idx.WalkRange(start, end, reverse, func(row *fidx.RowInfo) error {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		if r.expired(row) {
+			return nil
+		}
+		return do(row)
+	})
+	return ignoreBreak(err)
+}
+
+// ignoreBreak returns nil for ErrBreak, err otherwise.
+func ignoreBreak(err error) error {
+	if errors.Is(err, ErrBreak) {
+		return nil
+	}
+	return err
+}
+
+// SeekPrefix returns a row reader on the first key (in lexicographical order) starting with the given prefix,
+// or nil if there is none. NextLex and PreviousLex then move in lexicographical order within the prefix.
+//
+// Each move is a lookup in the keydir, so keys are paged through lazily with the trie keydir (see WithKeydir),
+// with the default hash keydir, every move sorts the matching keys.
+// It returns nil when keys are hashed (see WithHashedKeys).
+func (r *Reader) SeekPrefix(prefix []byte) *RowReader {
+	return r.firstInRange(prefix, prefix, fidx.PrefixEnd(prefix), false)
+}
+
+// NextLex returns a row reader on the next key in lexicographical order (within the prefix given to SeekPrefix),
+// or nil if there is none.
+func (c *RowReader) NextLex() *RowReader {
+	c.sync()
+	return c.r.firstInRange(c.prefix, append(bytes.Clone(c.current.Key), 0), fidx.PrefixEnd(c.prefix), false)
+}
+
+// PreviousLex returns a row reader on the previous key in lexicographical order
+// (within the prefix given to SeekPrefix), or nil if there is none.
+func (c *RowReader) PreviousLex() *RowReader {
+	c.sync()
+	return c.r.firstInRange(c.prefix, c.prefix, c.current.Key, true)
+}
+
+// firstInRange returns a row reader on the first key that is not expired in [start, end) (or the last one if reverse).
+func (r *Reader) firstInRange(prefix, start, end []byte, reverse bool) *RowReader {
+	var found *fidx.RowInfo
+	err := r.walkRange(start, end, reverse, func(row *fidx.RowInfo) error {
+		found = row
+		return ErrBreak
+	})
+	if found == nil || err != nil {
+		return nil
+	}
+	return &RowReader{r: r, idx: r.idx, current: found, prefix: prefix}
+}
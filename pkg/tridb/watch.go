@@ -0,0 +1,85 @@
+package tridb
+
+import "bytes"
+
+// WatchBufferSize is the number of events buffered for each watcher.
+const WatchBufferSize = 256
+
+// EventKind is the kind of change reported by an event.
+type EventKind string
+
+// Available event kinds.
+const (
+	EventSet    EventKind = "set"
+	EventDelete EventKind = "delete"
+)
+
+// Event reports a committed change of a key.
+type Event struct {
+	Kind  EventKind
+	Key   []byte
+	Value []byte // nil for deletes and aliases (see Writer.Alias)
+}
+
+type watcher struct {
+	prefix []byte
+	events chan Event
+}
+
+// Watch returns a channel receiving an event for each committed set or delete of a key starting with the given prefix
+// (in commit order), and a function to stop watching. Keys in the reserved keyspace are never reported.
+//
+// Events are sent without blocking commits: if the watcher falls WatchBufferSize events behind,
+// its channel is closed and the watcher must resynchronize (by reading the keys again and watching again).
+// The channel is also closed when stop is called or when the file is closed.
+func (f *File) Watch(prefix []byte) (<-chan Event, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &watcher{prefix: bytes.Clone(prefix), events: make(chan Event, WatchBufferSize)}
+	f.watchers = append(f.watchers, w)
+	stop := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.removeWatcher(w)
+	}
+	return w.events, stop
+}
+
+// removeWatcher closes the watcher channel (if it wasn't removed already).
+// It must be called with the write lock held.
+func (f *File) removeWatcher(w *watcher) {
+	for i, other := range f.watchers {
+		if other == w {
+			f.watchers = append(f.watchers[:i], f.watchers[i+1:]...)
+			close(w.events)
+			return
+		}
+	}
+}
+
+// notify sends the events of the committed rows to the watchers.
+// It must be called with the write lock held.
+func (f *File) notify(rows []*Row) {
+	for _, w := range append([]*watcher(nil), f.watchers...) {
+	rows:
+		for _, row := range rows {
+			if IsReservedKey(row.Key) || !bytes.HasPrefix(row.Key, w.prefix) {
+				continue
+			}
+			event := Event{Kind: EventSet, Key: bytes.Clone(row.Key)}
+			if row.IsDeleted {
+				event.Kind = EventDelete
+			} else if row.IsSealed || row.IsMerge || row.Compression != NoCompression {
+				event.Value, _ = f.storedValue(f.store, row)
+			} else if !row.IsAlias {
+				event.Value = bytes.Clone(row.Value)
+			}
+			select {
+			case w.events <- event:
+			default:
+				f.removeWatcher(w) // too slow, see Watch
+				break rows
+			}
+		}
+	}
+}
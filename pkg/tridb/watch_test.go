@@ -0,0 +1,40 @@
+package tridb
+
+import "testing"
+
+func TestWatch(t *testing.T) {
+	f := openTestFile(t)
+	events, stop := f.Watch([]byte("users/"))
+	mustSet(t, f, "users/1", "alice")
+	mustSet(t, f, "orders/1", "...")
+	b := f.Batch()
+	b.Delete([]byte("users/1"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []Event{{EventSet, []byte("users/1"), []byte("alice")}, {EventDelete, []byte("users/1"), nil}} {
+		got := <-events
+		if got.Kind != want.Kind || string(got.Key) != string(want.Key) || string(got.Value) != string(want.Value) {
+			t.Fatalf("got event %+v instead of %+v", got, want)
+		}
+	}
+	stop()
+	if _, ok := <-events; ok {
+		t.Fatal("channel should be closed")
+	}
+	stop() // no-op
+
+	// Slow watchers are dropped
+	events, _ = f.Watch(nil)
+	for i := 0; i <= WatchBufferSize; i++ {
+		mustSet(t, f, "a", "")
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != WatchBufferSize {
+		t.Fatalf("got %d events instead of %d", n, WatchBufferSize)
+	}
+}
@@ -0,0 +1,246 @@
+// Package tridbfs exposes the values of a tridb database file as a read-only file system (see io/fs),
+// keys under a prefix being slash-separated file paths. It can serve small sites and assets straight out of the file:
+//
+//	http.Handle("/", tridbfs.New(f, "site/").FileServer())
+//
+// Directories are implied by the paths of the files they contain, they can't be empty.
+// Keys that are not valid paths (see fs.ValidPath) are ignored, and a key that is also the
+// parent directory of other keys is a file.
+package tridbfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// FS is a read-only file system over the keys of a file starting with a prefix.
+// It implements fs.FS, fs.ReadFileFS, fs.ReadDirFS and fs.StatFS (use http.FS to get an http.FileSystem).
+type FS struct {
+	f      *tridb.File
+	prefix string
+}
+
+// New returns a file system over the keys starting with the given prefix (the prefix is not part of file names).
+func New(f *tridb.File, prefix string) *FS {
+	return &FS{f: f, prefix: prefix}
+}
+
+// WriteFile sets the content of the file with the given name, along with its content type (optional).
+func (fsys *FS) WriteFile(name string, data []byte, contentType string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	return fsys.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		w.SetWithContentType([]byte(fsys.prefix+name), data, contentType)
+		return nil
+	})
+}
+
+// Open opens the named file or directory.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	var file fs.File
+	err := fsys.f.Read(func(r *tridb.Reader) error {
+		info, content, err := fsys.readFile(r, name)
+		if info != nil {
+			file = &openFile{info: info, content: bytes.NewReader(content)}
+			return err
+		}
+		info, entries, err := fsys.readDir(r, name)
+		if info != nil {
+			file = &openDir{info: info, entries: entries}
+		}
+		return err
+	})
+	return file, fsys.pathError("open", name, file != nil, err)
+}
+
+// ReadFile reads the named file.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	var content []byte
+	var found bool
+	err := fsys.f.Read(func(r *tridb.Reader) error {
+		info, value, err := fsys.readFile(r, name)
+		content, found = value, info != nil
+		return err
+	})
+	return content, fsys.pathError("read", name, found, err)
+}
+
+// ReadDir reads the named directory and returns its entries sorted by file name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	var found bool
+	err := fsys.f.Read(func(r *tridb.Reader) error {
+		info, dirEntries, err := fsys.readDir(r, name)
+		entries, found = dirEntries, info != nil
+		return err
+	})
+	return entries, fsys.pathError("readdir", name, found, err)
+}
+
+// Stat returns information about the named file or directory.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: errors.Unwrap(err)}
+	}
+	return file.Stat()
+}
+
+// FileServer returns a handler serving the files like http.FileServer,
+// using the content type stored with files when there is one (see WriteFile).
+func (fsys *FS) FileServer() http.Handler {
+	files := http.FileServer(http.FS(fsys))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name != "" {
+			var contentType string
+			_ = fsys.f.Read(func(r *tridb.Reader) error {
+				contentType = r.ContentType([]byte(fsys.prefix + name))
+				return nil
+			})
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// readFile returns information about the named file and its content, or nil if there is no such file.
+func (fsys *FS) readFile(r *tridb.Reader, name string) (*fileInfo, []byte, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, nil, nil
+	}
+	key := []byte(fsys.prefix + name)
+	value, err := r.Get(key)
+	if err != nil || value == nil {
+		return nil, nil, err
+	}
+	info := &fileInfo{name: path.Base(name), size: len(value), modTime: r.ModTime(key), contentType: r.ContentType(key)}
+	return info, value, nil
+}
+
+// readDir returns information about the named directory and its entries, or nil if there is no such directory.
+func (fsys *FS) readDir(r *tridb.Reader, name string) (*fileInfo, []fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, nil
+	}
+	dirPrefix := fsys.prefix
+	if name != "." {
+		dirPrefix += name + "/"
+	}
+	children := map[string]*fileInfo{}
+	_, err := r.WalkWithValue([]byte(dirPrefix), func(key, value []byte) error {
+		rel := strings.TrimPrefix(string(key), dirPrefix)
+		if !fs.ValidPath(rel) {
+			return nil
+		}
+		child, _, isDir := strings.Cut(rel, "/")
+		if existing, ok := children[child]; ok && (isDir || !existing.IsDir()) {
+			return nil // a file shadows the directory of the same name
+		}
+		if isDir {
+			children[child] = &fileInfo{name: child, dir: true}
+		} else {
+			children[child] = &fileInfo{name: child, size: len(value), modTime: r.ModTime(key), contentType: r.ContentType(key)}
+		}
+		return nil
+	})
+	if err != nil || (len(children) == 0 && name != ".") {
+		return nil, nil, err
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(child))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return &fileInfo{name: path.Base(name), dir: true}, entries, nil
+}
+
+// pathError returns the error of an operation on the named file (fs.ErrNotExist if it wasn't found).
+func (fsys *FS) pathError(op, name string, found bool, err error) error {
+	if err == nil && !found {
+		err = fs.ErrNotExist
+	}
+	if err == nil {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// fileInfo describes a file or directory.
+type fileInfo struct {
+	name        string
+	size        int
+	modTime     time.Time
+	contentType string
+	dir         bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return int64(fi.size) }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+
+// Sys returns the content type of the file (empty if unknown).
+func (fi *fileInfo) Sys() any { return fi.contentType }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// openFile is an open file, its content was read when opening it.
+type openFile struct {
+	info    *fileInfo
+	content *bytes.Reader
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error)              { return f.info, nil }
+func (f *openFile) Read(p []byte) (int, error)              { return f.content.Read(p) }
+func (f *openFile) ReadAt(p []byte, off int64) (int, error) { return f.content.ReadAt(p, off) }
+func (f *openFile) Seek(offset int64, whence int) (int64, error) {
+	return f.content.Seek(offset, whence)
+}
+func (f *openFile) Close() error { return nil }
+
+// openDir is an open directory, its entries were listed when opening it.
+type openDir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *openDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *openDir) Close() error               { return nil }
+
+func (d *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries of the directory (all remaining entries if n <= 0), see fs.ReadDirFile.
+func (d *openDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
@@ -0,0 +1,93 @@
+package tridbfs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+func TestFS(t *testing.T) {
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fsys := New(f, "site/")
+	files := map[string]string{
+		"index.html":        "<h1>Home</h1>",
+		"about/index.html":  "<h1>About</h1>",
+		"assets/style.css":  "body {}",
+		"assets/js/app.js":  "console.log(1)",
+		"assets/data.json":  "{}",
+		"assets/empty.txt":  "",
+		"assets/js/lib.mjs": "export {}",
+	}
+	for name, content := range files {
+		if err := fsys.WriteFile(name, []byte(content), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Keys outside of the prefix and invalid paths are ignored.
+	err = f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		w.Set([]byte("other/file.txt"), []byte("hidden"))
+		w.Set([]byte("site//invalid"), []byte("hidden"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make([]string, 0, len(files))
+	for name := range files {
+		expected = append(expected, name)
+	}
+	if err := fstest.TestFS(fsys, expected...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Open("other/file.txt"); err == nil {
+		t.Fatal("expected an error opening a key outside of the prefix")
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fsys := New(f, "")
+	if err := fsys.WriteFile("index.html", []byte("<h1>Home</h1>"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.WriteFile("data", []byte("{}"), "application/json"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(fsys.FileServer())
+	defer srv.Close()
+
+	get := func(path, wantContentType, wantBody string) {
+		t.Helper()
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got status %d (%s)", path, res.StatusCode, b)
+		}
+		if got := res.Header.Get("Content-Type"); got != wantContentType {
+			t.Fatalf("%s: got content type %q instead of %q", path, got, wantContentType)
+		}
+		if string(b) != wantBody {
+			t.Fatalf("%s: got body %q instead of %q", path, b, wantBody)
+		}
+	}
+	get("/", "text/html; charset=utf-8", "<h1>Home</h1>")
+	get("/data", "application/json", "{}")
+}
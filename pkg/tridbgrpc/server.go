@@ -0,0 +1,301 @@
+// Package tridbgrpc serves a tridb database file over gRPC, so that services written in other languages
+// can use it with clients generated from tridb.proto.
+//
+// Methods (service tridb.v1.Tridb):
+//
+//	Get, Set, Delete  read and write a key
+//	Scan              streams the keys with a prefix (and their values) in lexicographical order
+//	Count             returns the number of keys with a prefix
+//	Compact           compacts the file
+//	Backup            streams a copy of the datafile (without blocking writers)
+//
+// The server is an http.Handler implementing the gRPC protocol over HTTP/2 (without compression),
+// it must be served over TLS since HTTP/2 is only negotiated on TLS connections by net/http:
+//
+//	srv := &http.Server{Addr: ":8443", Handler: tridbgrpc.NewServer(f)}
+//	srv.ListenAndServeTLS("cert.pem", "key.pem")
+package tridbgrpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// ServiceName is the fully-qualified name of the gRPC service (see tridb.proto).
+const ServiceName = "tridb.v1.Tridb"
+
+// DefaultMaxMessageSize is the maximum size of messages sent by clients when none is configured.
+const DefaultMaxMessageSize = 32 << 20
+
+// Scans read keys by pages (the file lock is not held while sending them),
+// backups are streamed in chunks.
+const (
+	scanPageSize    = 256
+	backupChunkSize = 64 << 10
+)
+
+// Server serves a database file to gRPC clients.
+type Server struct {
+	f              *tridb.File
+	token          string
+	maxMessageSize int
+}
+
+// Option configures a server.
+type Option func(*Server)
+
+// WithToken requires calls to be authenticated with the given bearer token
+// (metadata "authorization: Bearer <token>").
+func WithToken(token string) Option { return func(s *Server) { s.token = token } }
+
+// WithMaxMessageSize limits the size of messages sent by clients (larger ones fail with RESOURCE_EXHAUSTED).
+func WithMaxMessageSize(size int) Option { return func(s *Server) { s.maxMessageSize = size } }
+
+// NewServer returns a server for the given file.
+func NewServer(f *tridb.File, opts ...Option) *Server {
+	s := &Server{f: f, maxMessageSize: DefaultMaxMessageSize}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// method handles a call: it decodes the request message and sends the response messages (one for unary methods).
+type method func(s *Server, ctx context.Context, req fields, send func(msg []byte) error) error
+
+var methods = map[string]method{
+	"Get":     (*Server).get,
+	"Set":     (*Server).set,
+	"Delete":  (*Server).delete,
+	"Scan":    (*Server).scan,
+	"Count":   (*Server).count,
+	"Compact": (*Server).compact,
+	"Backup":  (*Server).backup,
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); r.Method != http.MethodPost || !strings.HasPrefix(contentType, "application/grpc") {
+		http.Error(w, "expected a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK) // the status of the call is sent in trailers
+	code, msg := status(s.call(w, r))
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeStatusMessage(msg))
+	}
+}
+
+// call handles the gRPC call of the given request.
+func (s *Server) call(w http.ResponseWriter, r *http.Request) error {
+	if s.token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			return &statusError{codeUnauthenticated, "invalid token"}
+		}
+	}
+	name, ok := strings.CutPrefix(r.URL.Path, "/"+ServiceName+"/")
+	handle := methods[name]
+	if !ok || handle == nil {
+		return &statusError{codeUnimplemented, "unknown method " + r.URL.Path}
+	}
+	ctx := r.Context()
+	if timeout := r.Header.Get("Grpc-Timeout"); timeout != "" {
+		d, err := parseTimeout(timeout)
+		if err != nil {
+			return &statusError{codeInvalidArgument, err.Error()}
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	body := bufio.NewReader(r.Body)
+	msg, err := readMessage(body, s.maxMessageSize)
+	if errors.Is(err, io.EOF) {
+		return &statusError{codeInvalidArgument, "missing request message"}
+	} else if err != nil {
+		return err
+	}
+	if _, err := readMessage(body, s.maxMessageSize); !errors.Is(err, io.EOF) {
+		return &statusError{codeInvalidArgument, "expected a single request message"}
+	}
+	req, err := decodeFields(msg)
+	if err != nil {
+		return err
+	}
+	flusher, _ := w.(http.Flusher)
+	return handle(s, ctx, req, func(msg []byte) error {
+		if err := writeMessage(w, msg); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush() // stream messages as they are sent
+		}
+		return ctx.Err()
+	})
+}
+
+func (s *Server) get(ctx context.Context, req fields, send func(msg []byte) error) error {
+	var value []byte
+	err := s.f.ReadCtx(ctx, func(r *tridb.Reader) (err error) {
+		value, err = r.GetExisting(req.bytes(1))
+		return err
+	})
+	if errors.Is(err, tridb.ErrKeyNotFound) {
+		return send(nil)
+	} else if err != nil {
+		return err
+	}
+	return send(appendVarintField(appendBytesField(nil, 1, value), 2, 1))
+}
+
+func (s *Server) set(ctx context.Context, req fields, send func(msg []byte) error) error {
+	err := s.f.ReadWriteCtx(ctx, func(r *tridb.Reader, w *tridb.Writer) error {
+		w.Set(req.bytes(1), req.bytes(2))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return send(nil)
+}
+
+func (s *Server) delete(ctx context.Context, req fields, send func(msg []byte) error) error {
+	err := s.f.ReadWriteCtx(ctx, func(r *tridb.Reader, w *tridb.Writer) error {
+		w.Delete(req.bytes(1))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return send(nil)
+}
+
+func (s *Server) scan(ctx context.Context, req fields, send func(msg []byte) error) error {
+	prefix, keysOnly, limit := req.bytes(1), req.bool(2), int(req.uint(3))
+	opts := tridb.WalkOptions{Limit: scanPageSize}
+	for sent := 0; limit == 0 || sent < limit; {
+		if limit > 0 {
+			opts.Limit = min(scanPageSize, limit-sent)
+		}
+		var page [][]byte // encoded responses
+		err := s.f.ReadCtx(ctx, func(r *tridb.Reader) (err error) {
+			opts.StartAfter, err = r.WalkWithOptions(prefix, opts, func(key []byte) error {
+				msg := appendBytesField(nil, 1, key)
+				if !keysOnly {
+					value, err := r.Get(key)
+					if err != nil {
+						return err
+					}
+					msg = appendBytesField(msg, 2, value)
+				}
+				page = append(page, msg)
+				return nil
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, msg := range page {
+			if err := send(msg); err != nil {
+				return err
+			}
+		}
+		if sent += len(page); len(page) < opts.Limit {
+			break // no more keys
+		}
+	}
+	return nil
+}
+
+func (s *Server) count(ctx context.Context, req fields, send func(msg []byte) error) error {
+	count := 0
+	err := s.f.ReadCtx(ctx, func(r *tridb.Reader) (err error) {
+		if prefix := req.bytes(1); len(prefix) > 0 {
+			count, err = r.CountPrefix(prefix)
+			return err
+		}
+		count = r.Count()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return send(appendVarintField(nil, 1, uint64(count)))
+}
+
+func (s *Server) compact(ctx context.Context, req fields, send func(msg []byte) error) error {
+	if err := s.f.Compact(); err != nil {
+		return err
+	}
+	return send(nil)
+}
+
+func (s *Server) backup(ctx context.Context, req fields, send func(msg []byte) error) error {
+	snap, err := s.f.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+	chunks := bufio.NewWriterSize(chunkWriter(func(chunk []byte) error { return send(appendBytesField(nil, 1, chunk)) }), backupChunkSize)
+	if _, err := snap.CopyTo(chunks); err != nil {
+		return err
+	}
+	return chunks.Flush()
+}
+
+// chunkWriter sends each write as a message of at most backupChunkSize bytes.
+type chunkWriter func(chunk []byte) error
+
+func (send chunkWriter) Write(p []byte) (int, error) {
+	for n := 0; n < len(p); n += backupChunkSize {
+		if err := send(p[n:min(len(p), n+backupChunkSize)]); err != nil {
+			return n, err
+		}
+	}
+	return len(p), nil
+}
+
+// status returns the gRPC status code and message of the given error.
+func status(err error) (int, string) {
+	if err == nil {
+		return codeOK, ""
+	}
+	code := codeInternal
+	var statusErr *statusError
+	switch {
+	case errors.As(err, &statusErr):
+		code = statusErr.code
+	case errors.Is(err, errBadMessage), errors.Is(err, tridb.ErrInvalidKey),
+		errors.Is(err, tridb.ErrKeyTooLong), errors.Is(err, tridb.ErrValueTooLong):
+		code = codeInvalidArgument
+	case errors.Is(err, tridb.ErrReadOnly), errors.Is(err, tridb.ErrFrozenPrefix):
+		code = codePermissionDenied
+	case errors.Is(err, tridb.ErrQuotaExceeded):
+		code = codeResourceExhausted
+	case errors.Is(err, tridb.ErrHashedKeys):
+		code = codeUnimplemented
+	case errors.Is(err, tridb.ErrClosed):
+		code = codeUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codeDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codeCanceled
+	}
+	return code, err.Error()
+}
@@ -0,0 +1,117 @@
+package tridbgrpc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+func TestServer(t *testing.T) {
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	srv := httptest.NewUnstartedServer(NewServer(f, WithToken("secret")))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	call := func(method, token string, req []byte) ([]fields, int) {
+		t.Helper()
+		body := &bytes.Buffer{}
+		writeMessage(body, req)
+		httpReq, err := http.NewRequest(http.MethodPost, srv.URL+"/"+ServiceName+"/"+method, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq.Header.Set("Content-Type", "application/grpc")
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+		resp, err := srv.Client().Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var msgs []fields
+		r := bufio.NewReader(resp.Body)
+		for {
+			msg, err := readMessage(r, DefaultMaxMessageSize)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			m, err := decodeFields(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msgs = append(msgs, m)
+		}
+		code, err := strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+		if err != nil {
+			t.Fatalf("%s: invalid grpc-status trailer %q", method, resp.Trailer.Get("Grpc-Status"))
+		}
+		return msgs, code
+	}
+
+	for _, kv := range [][2]string{{"users/1", "alice"}, {"users/2", "bob"}, {"orders/1", "..."}} {
+		req := appendBytesField(appendBytesField(nil, 1, []byte(kv[0])), 2, []byte(kv[1]))
+		if _, code := call("Set", "secret", req); code != codeOK {
+			t.Fatalf("set: got status %d", code)
+		}
+	}
+	if msgs, code := call("Get", "secret", appendBytesField(nil, 1, []byte("users/1"))); code != codeOK || string(msgs[0].bytes(1)) != "alice" || !msgs[0].bool(2) {
+		t.Fatalf("get: got %v (status %d)", msgs, code)
+	}
+	if _, code := call("Delete", "secret", appendBytesField(nil, 1, []byte("orders/1"))); code != codeOK {
+		t.Fatalf("delete: got status %d", code)
+	}
+	if msgs, code := call("Get", "secret", appendBytesField(nil, 1, []byte("orders/1"))); code != codeOK || msgs[0].bool(2) {
+		t.Fatalf("get deleted key: got %v (status %d)", msgs, code)
+	}
+	if msgs, code := call("Count", "secret", appendBytesField(nil, 1, []byte("users/"))); code != codeOK || msgs[0].uint(1) != 2 {
+		t.Fatalf("count: got %v (status %d)", msgs, code)
+	}
+
+	msgs, code := call("Scan", "secret", appendVarintField(appendBytesField(nil, 1, []byte("users/")), 3, 10))
+	got := []string{}
+	for _, m := range msgs {
+		got = append(got, string(m.bytes(1))+"="+string(m.bytes(2)))
+	}
+	if want := []string{"users/1=alice", "users/2=bob"}; code != codeOK || !reflect.DeepEqual(got, want) {
+		t.Fatalf("scan: got %q (status %d) instead of %q", got, code, want)
+	}
+	if msgs, code := call("Scan", "secret", appendVarintField(appendVarintField(nil, 2, 1), 3, 1)); code != codeOK || len(msgs) != 1 || msgs[0].bytes(2) != nil {
+		t.Fatalf("scan keys with a limit: got %v (status %d)", msgs, code)
+	}
+
+	if _, code := call("Compact", "secret", nil); code != codeOK {
+		t.Fatalf("compact: got status %d", code)
+	}
+	msgs, code = call("Backup", "secret", nil)
+	backup := []byte{}
+	for _, m := range msgs {
+		backup = append(backup, m.bytes(1)...)
+	}
+	if code != codeOK || len(backup) != f.Stats().FileSize {
+		t.Fatalf("backup: got %d bytes (status %d) instead of %d", len(backup), code, f.Stats().FileSize)
+	}
+
+	if _, code := call("Get", "wrong", appendBytesField(nil, 1, []byte("users/1"))); code != codeUnauthenticated {
+		t.Fatalf("got status %d with a wrong token", code)
+	}
+	if _, code := call("Unknown", "secret", nil); code != codeUnimplemented {
+		t.Fatalf("got status %d for an unknown method", code)
+	}
+	if _, code := call("Set", "secret", appendBytesField(nil, 2, []byte("no key"))); code != codeInvalidArgument {
+		t.Fatalf("got status %d for an invalid key", code)
+	}
+}
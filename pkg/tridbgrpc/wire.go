@@ -0,0 +1,184 @@
+package tridbgrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protobuf wire types (see https://protobuf.dev/programming-guides/encoding).
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// fields holds the fields of a decoded protobuf message by field number (the last occurrence wins).
+// Varint fields are decoded into varint, length-delimited fields into bytes.
+type fields map[int]field
+
+type field struct {
+	varint uint64
+	bytes  []byte
+}
+
+func (m fields) bytes(num int) []byte { return m[num].bytes }
+func (m fields) uint(num int) uint64  { return m[num].varint }
+func (m fields) bool(num int) bool    { return m[num].varint != 0 }
+
+var errBadMessage = errors.New("bad protobuf message")
+
+// decodeFields decodes a protobuf message, fields of unknown wire types are rejected.
+func decodeFields(b []byte) (fields, error) {
+	m := fields{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 || tag>>3 == 0 || tag>>3 > 1<<29-1 {
+			return nil, fmt.Errorf("%w: invalid tag", errBadMessage)
+		}
+		b = b[n:]
+		num := int(tag >> 3)
+		switch tag & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("%w: invalid varint of field %d", errBadMessage, num)
+			}
+			m[num], b = field{varint: v}, b[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return nil, fmt.Errorf("%w: invalid length of field %d", errBadMessage, num)
+			}
+			m[num], b = field{bytes: b[n : n+int(length)]}, b[n+int(length):]
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("%w: truncated field %d", errBadMessage, num)
+			}
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("%w: truncated field %d", errBadMessage, num)
+			}
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("%w: unsupported wire type %d of field %d", errBadMessage, tag&7, num)
+		}
+	}
+	return m, nil
+}
+
+// appendBytesField appends a length-delimited field (omitted if empty, like proto3 default values).
+func appendBytesField(b []byte, num int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendVarintField appends a varint field (omitted if zero, like proto3 default values).
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+// readMessage reads a length-prefixed gRPC message: a compression flag and the big-endian length of the message.
+// It returns io.EOF if there are no more messages.
+func readMessage(r io.Reader, maxSize int) ([]byte, error) {
+	prefix := [5]byte{}
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: truncated message prefix", errBadMessage)
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, &statusError{codeUnimplemented, "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if uint64(size) > uint64(maxSize) {
+		return nil, &statusError{codeResourceExhausted, fmt.Sprintf("message of %d bytes exceeds %d bytes", size, maxSize)}
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("%w: truncated message: %w", errBadMessage, err)
+	}
+	return msg, nil
+}
+
+// writeMessage writes a length-prefixed gRPC message (see readMessage).
+func writeMessage(w io.Writer, msg []byte) error {
+	framed := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(framed[1:], uint32(len(msg)))
+	_, err := w.Write(append(framed, msg...))
+	return err
+}
+
+// gRPC status codes (see https://grpc.github.io/grpc/core/md_doc_statuscodes.html).
+const (
+	codeOK                = 0
+	codeCanceled          = 1
+	codeInvalidArgument   = 3
+	codeDeadlineExceeded  = 4
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+// statusError is an error with a gRPC status code.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (err *statusError) Error() string { return err.msg }
+
+// encodeStatusMessage percent-encodes the given status message (grpc-message header).
+func encodeStatusMessage(msg string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c >= 0x20 && c <= 0x7e && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// parseTimeout parses the value of a grpc-timeout header: at most 8 digits followed by a unit
+// (H for hours, M for minutes, S for seconds, m for milliseconds, u for microseconds, n for nanoseconds).
+func parseTimeout(v string) (time.Duration, error) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, fmt.Errorf("invalid timeout %q", v)
+	}
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q", v)
+	}
+	unit, ok := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}[v[len(v)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid timeout unit %q", v)
+	}
+	if time.Duration(n) > math.MaxInt64/unit {
+		return math.MaxInt64, nil // longer than anyone will wait
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,237 @@
+// Package tridbhttp exposes a tridb database file over HTTP.
+//
+// Endpoints:
+//
+//	GET    /keys/{key}      returns the value (404 if the key doesn't exist)
+//	PUT    /keys/{key}      sets the value to the request body
+//	DELETE /keys/{key}      deletes the key
+//	GET    /keys?prefix=... lists the keys with the given prefix (JSON array of strings)
+//	GET    /count           returns the number of keys (JSON object)
+//	GET    /prefixes        returns the number of keys and bytes by prefix (?depth=...&top=..., see tridb.Reader.PrefixStats)
+//	POST   /compact         compacts the file
+//	GET    /backup          streams a copy of the datafile (without blocking writers)
+package tridbhttp
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// DefaultMaxValueSize is the maximum size of request bodies when none is configured.
+const DefaultMaxValueSize = 32 << 20
+
+// Handler serves a database file over HTTP.
+type Handler struct {
+	f            *tridb.File
+	token        string
+	maxValueSize int64
+}
+
+// Option configures a handler.
+type Option func(*Handler)
+
+// WithToken requires requests to be authenticated with the given bearer token
+// (header "Authorization: Bearer <token>").
+func WithToken(token string) Option { return func(h *Handler) { h.token = token } }
+
+// WithMaxValueSize limits the size of values set with PUT requests.
+func WithMaxValueSize(size int64) Option { return func(h *Handler) { h.maxValueSize = size } }
+
+// NewHandler returns a handler serving the given file.
+func NewHandler(f *tridb.File, opts ...Option) *Handler {
+	h := &Handler{f: f, maxValueSize: DefaultMaxValueSize}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	switch path := r.URL.Path; {
+	case strings.HasPrefix(path, "/keys/"):
+		h.serveKey(w, r, []byte(strings.TrimPrefix(path, "/keys/")))
+	case path == "/keys" && r.Method == http.MethodGet:
+		h.serveList(w, r)
+	case path == "/count" && r.Method == http.MethodGet:
+		h.serveCount(w)
+	case path == "/prefixes" && r.Method == http.MethodGet:
+		h.servePrefixes(w, r)
+	case path == "/compact" && r.Method == http.MethodPost:
+		if err := h.f.Compact(); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/backup" && r.Method == http.MethodGet:
+		h.serveBackup(w)
+	case path == "/keys" || path == "/count" || path == "/prefixes" || path == "/compact" || path == "/backup":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveKey(w http.ResponseWriter, r *http.Request, key []byte) {
+	switch r.Method {
+	case http.MethodGet:
+		// Values are streamed without holding the file lock (slow clients don't block writers).
+		value, err := h.f.GetReaderCtx(r.Context(), key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if value == nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer value.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(value.Size()))
+		io.Copy(w, value)
+	case http.MethodPut:
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxValueSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		err = h.f.ReadWriteCtx(r.Context(), func(tr *tridb.Reader, tw *tridb.Writer) error {
+			tw.Set(key, value)
+			return nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		err := h.f.ReadWriteCtx(r.Context(), func(tr *tridb.Reader, tw *tridb.Writer) error {
+			tw.Delete(key)
+			return nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) serveList(w http.ResponseWriter, r *http.Request) {
+	keys := []string{}
+	err := h.f.ReadCtx(r.Context(), func(tr *tridb.Reader) error {
+		_, err := tr.Walk([]byte(r.URL.Query().Get("prefix")), func(key []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, keys)
+}
+
+func (h *Handler) serveCount(w http.ResponseWriter) {
+	count := 0
+	_ = h.f.Read(func(tr *tridb.Reader) error {
+		count = tr.Count()
+		return nil
+	})
+	writeJSON(w, map[string]int{"count": count})
+}
+
+// servePrefixes responds with the prefix tree of the requested depth (1 by default),
+// or its largest prefixes if top is set.
+func (h *Handler) servePrefixes(w http.ResponseWriter, r *http.Request) {
+	depth, top := 1, 0
+	var err error
+	if v := r.URL.Query().Get("depth"); v != "" {
+		if depth, err = strconv.Atoi(v); err != nil || depth < 1 {
+			http.Error(w, "invalid depth", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("top"); v != "" {
+		if top, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid top", http.StatusBadRequest)
+			return
+		}
+	}
+	var stats []tridb.PrefixStats
+	err = h.f.ReadCtx(r.Context(), func(tr *tridb.Reader) (err error) {
+		stats, err = tr.PrefixStats(depth)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if top > 0 {
+		stats = tridb.TopPrefixes(stats, top)
+	}
+	type prefix struct {
+		Prefix string `json:"prefix"`
+		Keys   int    `json:"keys"`
+		Bytes  int    `json:"bytes"`
+	}
+	prefixes := make([]prefix, 0, len(stats))
+	for _, s := range stats {
+		prefixes = append(prefixes, prefix(s))
+	}
+	writeJSON(w, prefixes)
+}
+
+func (h *Handler) serveBackup(w http.ResponseWriter) {
+	snap, err := h.f.Snapshot()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer snap.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	snap.CopyTo(w)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with a status code matching the given error.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, tridb.ErrInvalidKey), errors.Is(err, tridb.ErrKeyTooLong), errors.Is(err, tridb.ErrValueTooLong):
+		status = http.StatusBadRequest
+	case errors.Is(err, tridb.ErrKeyNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, tridb.ErrReadOnly), errors.Is(err, tridb.ErrFrozenPrefix):
+		status = http.StatusForbidden
+	case errors.Is(err, tridb.ErrHashedKeys):
+		status = http.StatusNotImplemented
+	case errors.Is(err, tridb.ErrClosed):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,64 @@
+package tridbhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+func TestHandler(t *testing.T) {
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	srv := httptest.NewServer(NewHandler(f, WithToken("secret")))
+	defer srv.Close()
+
+	do := func(method, path, body, token string, wantStatus int) string {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		if res.StatusCode != wantStatus {
+			t.Fatalf("%s %s: got status %d instead of %d (%s)", method, path, res.StatusCode, wantStatus, b)
+		}
+		return string(b)
+	}
+
+	do(http.MethodGet, "/count", "", "wrong", http.StatusUnauthorized)
+	do(http.MethodPut, "/keys/users/1", "alice", "secret", http.StatusNoContent)
+	do(http.MethodPut, "/keys/users/2", "bob", "secret", http.StatusNoContent)
+	if got := do(http.MethodGet, "/keys/users/1", "", "secret", http.StatusOK); got != "alice" {
+		t.Fatalf("got value %q", got)
+	}
+	if got := do(http.MethodGet, "/keys?prefix=users/", "", "secret", http.StatusOK); got != "[\"users/1\",\"users/2\"]\n" {
+		t.Fatalf("got keys %q", got)
+	}
+	do(http.MethodDelete, "/keys/users/1", "", "secret", http.StatusNoContent)
+	do(http.MethodGet, "/keys/users/1", "", "secret", http.StatusNotFound)
+	if got := do(http.MethodGet, "/count", "", "secret", http.StatusOK); got != "{\"count\":1}\n" {
+		t.Fatalf("got count %q", got)
+	}
+	if got := do(http.MethodGet, "/prefixes?depth=1", "", "secret", http.StatusOK); !strings.Contains(got, "{\"prefix\":\"users\",\"keys\":1,") {
+		t.Fatalf("got prefixes %q", got)
+	}
+	do(http.MethodGet, "/prefixes?depth=0", "", "secret", http.StatusBadRequest)
+	do(http.MethodPost, "/compact", "", "secret", http.StatusNoContent)
+	if got := do(http.MethodGet, "/backup", "", "secret", http.StatusOK); !strings.Contains(got, "bob") {
+		t.Fatalf("backup doesn't contain value: %q", got)
+	}
+}
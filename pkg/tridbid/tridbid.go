@@ -0,0 +1,157 @@
+// Package tridbid generates time-sortable identifiers (ULIDs and KSUIDs),
+// keys built with them sort chronologically in lexicographical order (for example, in the trie keydir):
+//
+//	w.Set(tridbid.NewKey("events/"), event) // events/01HZX3...
+package tridbid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet used to encode ULIDs, it is in ASCII order.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// base62 is the alphabet used to encode KSUIDs, it is in ASCII order.
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// ULID is a 48-bit Unix timestamp in milliseconds followed by 80 random bits.
+type ULID [16]byte
+
+var monotonic struct {
+	sync.Mutex
+	last ULID
+}
+
+// NewULID returns a new ULID.
+// ULIDs generated by the process are strictly increasing: within the same millisecond (or if the clock goes back),
+// the previous ULID is incremented instead of drawing new random bits.
+func NewULID() (ULID, error) {
+	var id ULID
+	ms := time.Now().UnixMilli()
+	binary.BigEndian.PutUint16(id[:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	if _, err := rand.Read(id[6:]); err != nil {
+		return ULID{}, err
+	}
+
+	monotonic.Lock()
+	defer monotonic.Unlock()
+	if string(id[:6]) <= string(monotonic.last[:6]) {
+		id = monotonic.last
+		for i := len(id) - 1; i >= 0; i-- {
+			id[i]++
+			if id[i] != 0 {
+				break
+			}
+		}
+	}
+	monotonic.last = id
+	return id, nil
+}
+
+// MustNewULID is like NewULID but panics on error.
+func MustNewULID() ULID {
+	id, err := NewULID()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// Time returns the timestamp of the ULID.
+func (id ULID) Time() time.Time {
+	ms := int64(binary.BigEndian.Uint16(id[:2]))<<32 | int64(binary.BigEndian.Uint32(id[2:6]))
+	return time.UnixMilli(ms)
+}
+
+// String returns the ULID encoded in 26 Crockford base32 characters.
+func (id ULID) String() string {
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	encoded := make([]byte, 26)
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(encoded)
+}
+
+// ParseULID decodes a ULID encoded by ULID.String (lowercase letters are accepted).
+func ParseULID(s string) (ULID, error) {
+	if len(s) != 26 || !validFirstChar(s[0]) {
+		return ULID{}, fmt.Errorf("invalid ULID: %q", s)
+	}
+	var hi, lo uint64
+	for i := 0; i < len(s); i++ {
+		v := indexCrockford(s[i])
+		if v < 0 {
+			return ULID{}, fmt.Errorf("invalid ULID: %q", s)
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+	var id ULID
+	binary.BigEndian.PutUint64(id[:8], hi)
+	binary.BigEndian.PutUint64(id[8:], lo)
+	return id, nil
+}
+
+// validFirstChar reports whether c can start an encoded ULID (the first character only holds 3 bits).
+func validFirstChar(c byte) bool { return c >= '0' && c <= '7' }
+
+func indexCrockford(c byte) int {
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	for i := 0; i < len(crockford); i++ {
+		if crockford[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// KSUIDEpoch is the time KSUID timestamps count from.
+var KSUIDEpoch = time.Unix(1_400_000_000, 0)
+
+// KSUID is a 32-bit timestamp in seconds since KSUIDEpoch followed by 128 random bits.
+// Unlike ULIDs, KSUIDs generated within the same second are not ordered.
+type KSUID [20]byte
+
+// NewKSUID returns a new KSUID.
+func NewKSUID() (KSUID, error) {
+	var id KSUID
+	binary.BigEndian.PutUint32(id[:4], uint32(time.Now().Unix()-KSUIDEpoch.Unix()))
+	if _, err := rand.Read(id[4:]); err != nil {
+		return KSUID{}, err
+	}
+	return id, nil
+}
+
+// Time returns the timestamp of the KSUID.
+func (id KSUID) Time() time.Time {
+	return time.Unix(KSUIDEpoch.Unix()+int64(binary.BigEndian.Uint32(id[:4])), 0)
+}
+
+// String returns the KSUID encoded in 27 base62 characters.
+func (id KSUID) String() string {
+	n, base, digit := new(big.Int).SetBytes(id[:]), big.NewInt(62), new(big.Int)
+	encoded := make([]byte, 27)
+	for i := len(encoded) - 1; i >= 0; i-- {
+		n.DivMod(n, base, digit)
+		encoded[i] = base62[digit.Int64()]
+	}
+	return string(encoded)
+}
+
+// NewKey returns the given prefix followed by a new ULID (see NewULID),
+// keys returned by NewKey with the same prefix sort in the order they were generated.
+// It panics if no random bits can be read.
+func NewKey(prefix string) []byte {
+	return append([]byte(prefix), MustNewULID().String()...)
+}
@@ -0,0 +1,66 @@
+package tridbid
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestULID(t *testing.T) {
+	start := time.Now().Truncate(time.Millisecond)
+	prev := MustNewULID()
+	for i := 0; i < 10_000; i++ {
+		id := MustNewULID()
+		if s := id.String(); len(s) != 26 || s <= prev.String() {
+			t.Fatalf("got %q after %q", s, prev)
+		}
+		prev = id
+	}
+	if got := prev.Time(); got.Before(start) || got.After(time.Now()) {
+		t.Fatalf("got time %s (started at %s)", got, start)
+	}
+
+	parsed, err := ParseULID(strings.ToLower(prev.String()))
+	if err != nil || parsed != prev {
+		t.Fatalf("parsed %v (%v) instead of %v", parsed, err, prev)
+	}
+	for _, s := range []string{"", "8ZZZZZZZZZZZZZZZZZZZZZZZZZ", "0000000000000000000000000U"} {
+		if _, err := ParseULID(s); err == nil {
+			t.Fatalf("parsed invalid ULID %q", s)
+		}
+	}
+	if max := (ULID{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}); max.String() != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Fatalf("got %q for the maximum ULID", max)
+	}
+}
+
+func TestKSUID(t *testing.T) {
+	id, err := NewKSUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Since(id.Time()); got < 0 || got > time.Minute {
+		t.Fatalf("got time %s", id.Time())
+	}
+	if got := (KSUID{}).String(); got != strings.Repeat("0", 27) {
+		t.Fatalf("got %q for the zero KSUID", got)
+	}
+	var max KSUID
+	for i := range max {
+		max[i] = 0xFF
+	}
+	if got := max.String(); got != "aWgEPTl1tmebfsQzFP4bxwgy80V" {
+		t.Fatalf("got %q for the maximum KSUID", got)
+	}
+	if a, b := (KSUID{1}), (KSUID{2}); a.String() >= b.String() {
+		t.Fatalf("got %q >= %q", a, b)
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	a, b := NewKey("events/"), NewKey("events/")
+	if !bytes.HasPrefix(a, []byte("events/")) || bytes.Compare(a, b) >= 0 {
+		t.Fatalf("got keys %q and %q", a, b)
+	}
+}
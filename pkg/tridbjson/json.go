@@ -0,0 +1,76 @@
+// Package tridbjson stores JSON documents in a tridb database file.
+//
+// Documents are written with the "application/json" content type (see tridb.Writer.SetWithContentType),
+// the helpers taking a reader or a writer can be combined with other operations in a transaction.
+package tridbjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// ContentType is the content type of the documents written by the package.
+const ContentType = "application/json"
+
+// Store reads and writes JSON documents in a database file.
+type Store struct{ f *tridb.File }
+
+// New returns a store for the given file.
+func New(f *tridb.File) *Store { return &Store{f: f} }
+
+// PutJSON sets the key to the JSON encoding of v.
+func (s *Store) PutJSON(key []byte, v any) error {
+	return s.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error { return Put(w, key, v) })
+}
+
+// GetJSON decodes the document of the given key into dst,
+// it returns an error wrapping tridb.ErrKeyNotFound if the key doesn't exist.
+func (s *Store) GetJSON(key []byte, dst any) error {
+	return s.f.Read(func(r *tridb.Reader) error { return Get(r, key, dst) })
+}
+
+// WalkJSON calls do with a decoder of the document of each key starting with the given prefix,
+// in lexicographical order (see tridb.Reader.Walk for errors). It reports the number of keys visited.
+//
+// Documents are decoded from the values read by the walk (without copying them),
+// the decoder must not be used once do returns.
+func (s *Store) WalkJSON(prefix []byte, do func(key []byte, dec *json.Decoder) error) (visited int, err error) {
+	err = s.f.Read(func(r *tridb.Reader) error {
+		visited, err = Walk(r, prefix, do)
+		return err
+	})
+	return visited, err
+}
+
+// Put stages the JSON encoding of v as the value of the key in the transaction of the given writer.
+func Put(w *tridb.Writer, key []byte, v any) error {
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %q: %w", key, err)
+	}
+	w.SetWithContentType(key, doc, ContentType)
+	return nil
+}
+
+// Get decodes the document of the given key into dst in the transaction of the given reader,
+// it returns an error wrapping tridb.ErrKeyNotFound if the key doesn't exist.
+func Get(r *tridb.Reader, key []byte, dst any) error {
+	doc, err := r.GetExisting(key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(doc, dst); err != nil {
+		return fmt.Errorf("unmarshal %q: %w", key, err)
+	}
+	return nil
+}
+
+// Walk is like Store.WalkJSON in the transaction of the given reader.
+func Walk(r *tridb.Reader, prefix []byte, do func(key []byte, dec *json.Decoder) error) (int, error) {
+	return r.WalkWithValue(prefix, func(key, value []byte) error {
+		return do(key, json.NewDecoder(bytes.NewReader(value)))
+	})
+}
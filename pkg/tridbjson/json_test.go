@@ -0,0 +1,58 @@
+package tridbjson
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestStore(t *testing.T) {
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := New(f)
+	for key, u := range map[string]user{"users/1": {"alice", 30}, "users/2": {"bob", 40}} {
+		if err := s.PutJSON([]byte(key), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.PutJSON([]byte("invalid"), func() {}); err == nil {
+		t.Fatal("expected an error for a value that can't be marshaled")
+	}
+
+	got := user{}
+	if err := s.GetJSON([]byte("users/1"), &got); err != nil || got != (user{"alice", 30}) {
+		t.Fatalf("got %+v (%v)", got, err)
+	}
+	if err := s.GetJSON([]byte("users/3"), &got); !errors.Is(err, tridb.ErrKeyNotFound) {
+		t.Fatalf("got error %v instead of %v", err, tridb.ErrKeyNotFound)
+	}
+	_ = f.Read(func(r *tridb.Reader) error {
+		if ct := r.ContentType([]byte("users/2")); ct != ContentType {
+			t.Fatalf("got content type %q", ct)
+		}
+		return nil
+	})
+
+	var names []string
+	n, err := s.WalkJSON([]byte("users/"), func(key []byte, dec *json.Decoder) error {
+		u := user{}
+		err := dec.Decode(&u)
+		names = append(names, u.Name)
+		return err
+	})
+	if err != nil || n != 2 || !reflect.DeepEqual(names, []string{"alice", "bob"}) {
+		t.Fatalf("walked %d documents: %q (%v)", n, names, err)
+	}
+}
@@ -0,0 +1,174 @@
+// Package tridbmetrics collects the measurements of tridb database files (see tridb.WithMetrics and tridb.LockMetrics)
+// and exposes them in the Prometheus text exposition format and with expvar.
+//
+// Programs built with the tridb_expvar build tag publish similar measurements with expvar without this package.
+//
+// Example:
+//
+//	c := tridbmetrics.NewCollector()
+//	f, err := tridb.Open("main.tridb", 1024, tridb.WithMetrics(c))
+//	...
+//	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+//		f.WritePrometheus(w) // state of the file (keys, dead bytes...)
+//		c.WritePrometheus(w) // operations (writes, syncs, reads and compactions)
+//	})
+package tridbmetrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the latency histogram buckets.
+var DefaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60}
+
+// Collector implements tridb.Metrics with counters and latency histograms, it is safe for concurrent use.
+// A collector can be shared by several files, their measurements are then added up.
+type Collector struct {
+	commits      atomic.Uint64
+	rowsWritten  atomic.Uint64
+	bytesWritten atomic.Uint64
+	compacted    atomic.Uint64 // bytes reclaimed by compactions
+	syncs        *histogram
+	reads        *histogram
+	compactions  *histogram
+	readLocks    *histogram // time waited for the lock by read-only transactions
+	writeLocks   *histogram // time waited for the lock by read-write transactions, batches and compactions
+}
+
+var (
+	_ tridb.Metrics     = (*Collector)(nil)
+	_ tridb.LockMetrics = (*Collector)(nil)
+)
+
+// NewCollector returns a collector with the default histogram buckets.
+func NewCollector() *Collector {
+	return &Collector{
+		syncs:       newHistogram(DefaultBuckets),
+		reads:       newHistogram(DefaultBuckets),
+		compactions: newHistogram(DefaultBuckets),
+		readLocks:   newHistogram(DefaultBuckets),
+		writeLocks:  newHistogram(DefaultBuckets),
+	}
+}
+
+// Written implements tridb.Metrics.
+func (c *Collector) Written(rows, bytes int) {
+	c.commits.Add(1)
+	c.rowsWritten.Add(uint64(rows))
+	c.bytesWritten.Add(uint64(bytes))
+}
+
+// Synced implements tridb.Metrics.
+func (c *Collector) Synced(d time.Duration) { c.syncs.observe(d) }
+
+// Read implements tridb.Metrics.
+func (c *Collector) Read(d time.Duration) { c.reads.observe(d) }
+
+// Compacted implements tridb.Metrics.
+func (c *Collector) Compacted(d time.Duration, before, after int) {
+	c.compactions.observe(d)
+	if before > after {
+		c.compacted.Add(uint64(before - after))
+	}
+}
+
+// LockWaited implements tridb.LockMetrics.
+func (c *Collector) LockWaited(write bool, d time.Duration) {
+	if write {
+		c.writeLocks.observe(d)
+	} else {
+		c.readLocks.observe(d)
+	}
+}
+
+// WritePrometheus writes the collected metrics in the Prometheus text exposition format.
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	bufw := bufio.NewWriter(w)
+	counter := func(name, help string, value uint64) {
+		fmt.Fprintf(bufw, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	counter("tridb_written_commits_total", "Number of commits that wrote rows.", c.commits.Load())
+	counter("tridb_written_rows_total", "Number of rows written.", c.rowsWritten.Load())
+	counter("tridb_written_bytes_total", "Number of bytes written.", c.bytesWritten.Load())
+	counter("tridb_compacted_bytes_total", "Number of bytes reclaimed by compactions.", c.compacted.Load())
+	c.syncs.write(bufw, "tridb_sync_duration_seconds", "Duration of syncs to disk.")
+	c.reads.write(bufw, "tridb_read_duration_seconds", "Duration of read-only transactions.")
+	c.compactions.write(bufw, "tridb_compaction_duration_seconds", "Duration of compactions.")
+	c.readLocks.write(bufw, "tridb_read_lock_wait_seconds", "Time waited for the lock by read-only transactions.")
+	c.writeLocks.write(bufw, "tridb_write_lock_wait_seconds", "Time waited for the lock by writes and compactions.")
+	return bufw.Flush()
+}
+
+// Publish publishes the collected metrics with expvar under the given name (expvar panics if the name is already used).
+func (c *Collector) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return map[string]any{
+			"written_commits":  c.commits.Load(),
+			"written_rows":     c.rowsWritten.Load(),
+			"written_bytes":    c.bytesWritten.Load(),
+			"compacted_bytes":  c.compacted.Load(),
+			"syncs":            c.syncs.snapshot(),
+			"reads":            c.reads.snapshot(),
+			"compactions":      c.compactions.snapshot(),
+			"read_lock_waits":  c.readLocks.snapshot(),
+			"write_lock_waits": c.writeLocks.snapshot(),
+		}
+	}))
+}
+
+// histogram counts durations in buckets (with cumulative counts computed when written).
+type histogram struct {
+	bounds []float64       // upper bounds in seconds
+	counts []atomic.Uint64 // number of observations per bucket (the last one has no upper bound)
+	sum    atomic.Uint64   // sum of the observations in nanoseconds
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d.Seconds() > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(uint64(max(d, 0)))
+}
+
+// histogramSnapshot is the expvar representation of a histogram.
+type histogramSnapshot struct {
+	Count      uint64  `json:"count"`
+	SumSeconds float64 `json:"sum_seconds"`
+}
+
+func (h *histogram) snapshot() histogramSnapshot {
+	s := histogramSnapshot{SumSeconds: time.Duration(h.sum.Load()).Seconds()}
+	for i := range h.counts {
+		s.Count += h.counts[i].Load()
+	}
+	return s
+}
+
+func (h *histogram) write(w *bufio.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	cumulative := uint64(0)
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, cumulative)
+	}
+	sum := strconv.FormatFloat(time.Duration(h.sum.Load()).Seconds(), 'g', -1, 64)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, sum, name, cumulative)
+}
@@ -0,0 +1,73 @@
+package tridbmetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 10, tridb.WithMetrics(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 0; i < 3; i++ {
+		err := f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+			w.Set([]byte("key"), []byte("value"))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = f.Read(func(r *tridb.Reader) error { return nil })
+	if err := f.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	c.Read(2 * time.Second)
+
+	out := &bytes.Buffer{}
+	if err := c.WritePrometheus(out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"tridb_written_rows_total 3\n",
+		"# TYPE tridb_sync_duration_seconds histogram\n",
+		"tridb_sync_duration_seconds_count 3\n",
+		"tridb_read_duration_seconds_bucket{le=\"1\"} 1\n",
+		"tridb_read_duration_seconds_bucket{le=\"5\"} 2\n",
+		"tridb_read_duration_seconds_bucket{le=\"+Inf\"} 2\n",
+		"tridb_compaction_duration_seconds_count 1\n",
+		"tridb_read_lock_wait_seconds_count 1\n",
+		"tridb_write_lock_wait_seconds_count 5\n", // 3 commits and 2 for the compaction (start and switch)
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+	if stats := f.Stats(); !strings.Contains(out.String(), "tridb_written_bytes_total ") || stats.DeadBytes != 0 {
+		t.Fatalf("got %d dead bytes after compaction", stats.DeadBytes)
+	}
+
+	c.Publish("tridb_test")
+	var vars struct {
+		WrittenRows int `json:"written_rows"`
+		Reads       struct {
+			Count int `json:"count"`
+		} `json:"reads"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("tridb_test").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.WrittenRows != 3 || vars.Reads.Count != 2 {
+		t.Fatalf("got expvar %+v", vars)
+	}
+}
@@ -0,0 +1,282 @@
+// Package tridbprims provides synchronization primitives persisted in a tridb database file:
+// leases with expiry, mutexes, token buckets and idempotency keys.
+//
+// All primitives are evaluated inside a read-write transaction,
+// the check and the update are therefore atomic for a single process.
+// Leases and idempotency keys are written as expiring keys (see tridb.Writer.SetWithDeadline),
+// the database thus drops them once expired (and compactions reclaim their rows).
+package tridbprims
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// DefaultPrefix is the key prefix under which primitives are stored by default.
+const DefaultPrefix = "tridbprims/"
+
+// Store persists primitives in a database file.
+type Store struct {
+	f      *tridb.File
+	prefix string
+	now    func() time.Time
+}
+
+// New returns a store using the default key prefix.
+func New(f *tridb.File) *Store { return &Store{f: f, prefix: DefaultPrefix, now: time.Now} }
+
+// WithPrefix returns a copy of the store using the given key prefix.
+func (s *Store) WithPrefix(prefix string) *Store {
+	return &Store{f: s.f, prefix: prefix, now: s.now}
+}
+
+func (s *Store) key(kind, name string) []byte { return []byte(s.prefix + kind + "/" + name) }
+
+// Lease value layout: deadline (unix nanoseconds, 8 bytes) followed by the holder.
+func encodeLease(deadline time.Time, holder string) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(deadline.UnixNano())), holder...)
+}
+
+func decodeLease(v []byte) (time.Time, string, error) {
+	if len(v) < 8 {
+		return time.Time{}, "", fmt.Errorf("invalid lease value of length %d", len(v))
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), string(v[8:]), nil
+}
+
+// AcquireLease acquires (or renews) the named lease for the given holder until now + ttl.
+// It reports false if the lease is currently held by another holder.
+func (s *Store) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	key := s.key("lease", name)
+	acquired := false
+	err := s.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		now := s.now()
+		v, err := r.Get(key)
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			deadline, currentHolder, err := decodeLease(v)
+			if err != nil {
+				return err
+			}
+			if currentHolder != holder && now.Before(deadline) {
+				return nil
+			}
+		}
+		deadline := now.Add(ttl)
+		w.SetWithDeadline(key, encodeLease(deadline, holder), deadline)
+		acquired = true
+		return nil
+	})
+	return acquired, err
+}
+
+// ReleaseLease releases the named lease if it is held by the given holder.
+func (s *Store) ReleaseLease(name, holder string) error {
+	key := s.key("lease", name)
+	return s.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		v, err := r.Get(key)
+		if err != nil || v == nil {
+			return err
+		}
+		_, currentHolder, err := decodeLease(v)
+		if err != nil {
+			return err
+		}
+		if currentHolder == holder {
+			w.DeleteIf(key, v)
+		}
+		return nil
+	})
+}
+
+// LeaseHolder returns the current holder of the named lease,
+// or an empty string if the lease is free or expired.
+func (s *Store) LeaseHolder(name string) (string, error) {
+	holder := ""
+	err := s.f.Read(func(r *tridb.Reader) error {
+		v, err := r.Get(s.key("lease", name))
+		if err != nil || v == nil {
+			return err
+		}
+		deadline, currentHolder, err := decodeLease(v)
+		if err != nil {
+			return err
+		}
+		if s.now().Before(deadline) {
+			holder = currentHolder
+		}
+		return nil
+	})
+	return holder, err
+}
+
+// ErrNotLocked is returned when unlocking a mutex that is not held.
+var ErrNotLocked = errors.New("mutex not locked")
+
+// Mutex is a mutual exclusion lock backed by a lease.
+// The lease expires after the given TTL so that a crashed holder doesn't keep the lock forever.
+type Mutex struct {
+	s            *Store
+	name, holder string
+	ttl          time.Duration
+	PollInterval time.Duration // Used by Lock to retry acquiring the lease.
+}
+
+// NewMutex returns a new mutex with a unique holder ID.
+func (s *Store) NewMutex(name string, ttl time.Duration) *Mutex {
+	return &Mutex{
+		s:            s,
+		name:         name,
+		holder:       string(tridb.MustNewRandID(16).Hex()),
+		ttl:          ttl,
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+// TryLock tries to acquire the mutex without waiting.
+// Calling TryLock when the mutex is already held by the caller extends the lease.
+func (m *Mutex) TryLock() (bool, error) { return m.s.AcquireLease(m.name, m.holder, m.ttl) }
+
+// Lock waits until the mutex is acquired or the context is done.
+func (m *Mutex) Lock(ctx context.Context) error {
+	for {
+		ok, err := m.TryLock()
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.PollInterval):
+		}
+	}
+}
+
+// Unlock releases the mutex, it fails with ErrNotLocked if the lease isn't held by the mutex (anymore).
+// The holder is checked in the same transaction as the release.
+func (m *Mutex) Unlock() error {
+	key := m.s.key("lease", m.name)
+	return m.s.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		v, err := r.Get(key)
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			deadline, holder, err := decodeLease(v)
+			if err != nil {
+				return err
+			}
+			if holder == m.holder && m.s.now().Before(deadline) {
+				w.DeleteIf(key, v)
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %q", ErrNotLocked, m.name)
+	})
+}
+
+// Token bucket value layout: available tokens (float64 bits) and last refill time (unix nanoseconds).
+func encodeBucket(tokens float64, at time.Time) []byte {
+	v := binary.BigEndian.AppendUint64(nil, math.Float64bits(tokens))
+	return binary.BigEndian.AppendUint64(v, uint64(at.UnixNano()))
+}
+
+func decodeBucket(v []byte) (float64, time.Time, error) {
+	if len(v) != 16 {
+		return 0, time.Time{}, fmt.Errorf("invalid token bucket value of length %d", len(v))
+	}
+	tokens := math.Float64frombits(binary.BigEndian.Uint64(v))
+	return tokens, time.Unix(0, int64(binary.BigEndian.Uint64(v[8:]))), nil
+}
+
+// ErrInvalidBucket is returned by Allow for a token bucket rate or burst that isn't positive.
+var ErrInvalidBucket = errors.New("invalid token bucket")
+
+// Allow takes a token from the named token bucket and reports whether one was available.
+// The bucket holds at most burst tokens and is refilled at the given rate (tokens per second),
+// both must be positive (ErrInvalidBucket).
+func (s *Store) Allow(name string, rate float64, burst int) (bool, error) {
+	if !(rate > 0) || burst <= 0 {
+		return false, fmt.Errorf("%w: rate %v, burst %d", ErrInvalidBucket, rate, burst)
+	}
+	key := s.key("bucket", name)
+	allowed := false
+	err := s.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		now := s.now()
+		tokens := float64(burst)
+		v, err := r.Get(key)
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			var last time.Time
+			tokens, last, err = decodeBucket(v)
+			if err != nil {
+				return err
+			}
+			tokens = math.Min(float64(burst), tokens+now.Sub(last).Seconds()*rate)
+		}
+		if tokens >= 1 {
+			tokens--
+			allowed = true
+		}
+		w.Set(key, encodeBucket(tokens, now))
+		return nil
+	})
+	return allowed, err
+}
+
+// Idempotent records the given idempotency key for the given TTL.
+// It reports true the first time the key is seen (or after the previous record expired),
+// and false for duplicates.
+func (s *Store) Idempotent(idempotencyKey string, ttl time.Duration) (bool, error) {
+	key := s.key("idempotency", idempotencyKey)
+	first := false
+	err := s.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		now := s.now()
+		v, err := r.Get(key)
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			deadline, _, err := decodeLease(v)
+			if err != nil {
+				return err
+			}
+			if now.Before(deadline) {
+				return nil
+			}
+		}
+		deadline := now.Add(ttl)
+		w.SetWithDeadline(key, encodeLease(deadline, ""), deadline)
+		first = true
+		return nil
+	})
+	return first, err
+}
+
+// Once executes do only if the given idempotency key was not seen within the TTL.
+// If do fails, the idempotency key is released so that the operation can be retried.
+func (s *Store) Once(idempotencyKey string, ttl time.Duration, do func() error) (bool, error) {
+	first, err := s.Idempotent(idempotencyKey, ttl)
+	if err != nil || !first {
+		return false, err
+	}
+	if err := do(); err != nil {
+		key := s.key("idempotency", idempotencyKey)
+		releaseErr := s.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+			w.Delete(key)
+			return nil
+		})
+		return true, errors.Join(err, releaseErr)
+	}
+	return true, nil
+}
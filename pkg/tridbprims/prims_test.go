@@ -0,0 +1,122 @@
+package tridbprims
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+func newTestStore(t *testing.T) (*Store, *time.Time) {
+	t.Helper()
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	now := time.Now() // the database also expires leases and idempotency keys
+	s := New(f)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestLease(t *testing.T) {
+	s, now := newTestStore(t)
+
+	mustAcquire := func(holder string, want bool) {
+		t.Helper()
+		got, err := s.AcquireLease("lease", holder, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("%q acquired lease: got %v instead of %v", holder, got, want)
+		}
+	}
+
+	mustAcquire("a", true)
+	mustAcquire("b", false)
+	mustAcquire("a", true) // renew
+	*now = now.Add(2 * time.Minute)
+	mustAcquire("b", true) // previous lease expired
+	if err := s.ReleaseLease("lease", "b"); err != nil {
+		t.Fatal(err)
+	}
+	mustAcquire("a", true)
+}
+
+func TestTokenBucket(t *testing.T) {
+	s, now := newTestStore(t)
+
+	for i := 0; i < 3; i++ {
+		if ok, err := s.Allow("bucket", 1, 3); err != nil || !ok {
+			t.Fatalf("token %d: got %v, %v", i, ok, err)
+		}
+	}
+	if ok, _ := s.Allow("bucket", 1, 3); ok {
+		t.Fatal("bucket should be empty")
+	}
+	*now = now.Add(time.Second)
+	if ok, _ := s.Allow("bucket", 1, 3); !ok {
+		t.Fatal("bucket should have been refilled")
+	}
+	for _, params := range []struct {
+		rate  float64
+		burst int
+	}{{0, 3}, {-1, 3}, {1, 0}, {1, -1}} {
+		if _, err := s.Allow("bucket", params.rate, params.burst); !errors.Is(err, ErrInvalidBucket) {
+			t.Fatalf("got error %v for %+v instead of %v", err, params, ErrInvalidBucket)
+		}
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	s, now := newTestStore(t)
+
+	if first, _ := s.Idempotent("req-1", time.Minute); !first {
+		t.Fatal("first request should not be a duplicate")
+	}
+	if first, _ := s.Idempotent("req-1", time.Minute); first {
+		t.Fatal("second request should be a duplicate")
+	}
+	*now = now.Add(2 * time.Minute)
+	if first, _ := s.Idempotent("req-1", time.Minute); !first {
+		t.Fatal("expired request should not be a duplicate")
+	}
+}
+
+func TestMutex(t *testing.T) {
+	s, now := newTestStore(t)
+	a, b := s.NewMutex("mutex", time.Minute), s.NewMutex("mutex", time.Minute)
+
+	if err := a.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); err != nil || ok {
+		t.Fatalf("got %v (%v) when locking a held mutex", ok, err)
+	}
+	if err := b.Unlock(); !errors.Is(err, ErrNotLocked) {
+		t.Fatalf("got error %v instead of %v", err, ErrNotLocked)
+	}
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Unlock(); !errors.Is(err, ErrNotLocked) {
+		t.Fatalf("got error %v instead of %v", err, ErrNotLocked)
+	}
+
+	// An expired lease can't be unlocked, it can be acquired by another holder
+	if ok, err := b.TryLock(); err != nil || !ok {
+		t.Fatalf("got %v (%v) when locking a free mutex", ok, err)
+	}
+	*now = now.Add(2 * time.Minute)
+	if err := b.Unlock(); !errors.Is(err, ErrNotLocked) {
+		t.Fatalf("got error %v instead of %v", err, ErrNotLocked)
+	}
+	if ok, err := a.TryLock(); err != nil || !ok {
+		t.Fatalf("got %v (%v) when locking an expired mutex", ok, err)
+	}
+}
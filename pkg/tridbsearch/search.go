@@ -0,0 +1,203 @@
+// Package tridbsearch provides a full-text inverted index over the values of a tridb database file.
+//
+// Only keys under the configured source prefixes are indexed.
+// Postings are stored in the same file (under the index prefix),
+// so they are updated atomically with the indexed values when using Set and Delete.
+package tridbsearch
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+// DefaultPrefix is the key prefix under which postings are stored by default.
+const DefaultPrefix = "tridbsearch/"
+
+// Index maintains an inverted index of the terms found in values.
+type Index struct {
+	f       *tridb.File
+	prefix  []byte
+	sources [][]byte
+}
+
+// New returns an index over the values of keys starting with one of the given prefixes,
+// no prefix means all keys (except the postings themselves) are indexed.
+func New(f *tridb.File, sources ...string) *Index {
+	ix := &Index{f: f, prefix: []byte(DefaultPrefix)}
+	for _, source := range sources {
+		ix.sources = append(ix.sources, []byte(source))
+	}
+	return ix
+}
+
+// WithPrefix returns a copy of the index storing its postings under the given key prefix.
+func (ix *Index) WithPrefix(prefix string) *Index {
+	return &Index{f: ix.f, prefix: []byte(prefix), sources: ix.sources}
+}
+
+// Indexes reports whether the given key is indexed.
+func (ix *Index) Indexes(key []byte) bool {
+	if bytes.HasPrefix(key, ix.prefix) {
+		return false
+	}
+	if len(ix.sources) == 0 {
+		return true
+	}
+	for _, source := range ix.sources {
+		if bytes.HasPrefix(key, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// Posting key layout: prefix + term + 0x00 + key.
+func (ix *Index) termPrefix(term string) []byte {
+	return append(append(append([]byte{}, ix.prefix...), term...), 0)
+}
+
+func (ix *Index) postingKey(term string, key []byte) []byte {
+	return append(ix.termPrefix(term), key...)
+}
+
+// Set stages the given key-value pair along with its postings.
+// Postings of the previous value (as seen by the reader) are removed.
+func (ix *Index) Set(r *tridb.Reader, w *tridb.Writer, key, value []byte) error {
+	if err := ix.unindex(r, w, key); err != nil {
+		return err
+	}
+	w.Set(key, value)
+	if ix.Indexes(key) {
+		for _, term := range Tokenize(string(value)) {
+			w.Set(ix.postingKey(term, key), nil)
+		}
+	}
+	return nil
+}
+
+// Delete stages the deletion of the given key along with its postings.
+func (ix *Index) Delete(r *tridb.Reader, w *tridb.Writer, key []byte) error {
+	if err := ix.unindex(r, w, key); err != nil {
+		return err
+	}
+	w.Delete(key)
+	return nil
+}
+
+func (ix *Index) unindex(r *tridb.Reader, w *tridb.Writer, key []byte) error {
+	if !ix.Indexes(key) {
+		return nil
+	}
+	previous, err := r.Get(key)
+	if err != nil {
+		return err
+	}
+	for _, term := range Tokenize(string(previous)) {
+		w.Delete(ix.postingKey(term, key))
+	}
+	return nil
+}
+
+// Rebuild removes all postings and indexes all existing values in a single transaction.
+func (ix *Index) Rebuild() error {
+	return ix.f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		_, err := r.Walk(ix.prefix, func(key []byte) error {
+			w.Delete(append([]byte{}, key...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		_, err = r.WalkWithValue(nil, func(key, value []byte) error {
+			if !ix.Indexes(key) {
+				return nil
+			}
+			for _, term := range Tokenize(string(value)) {
+				w.Set(ix.postingKey(term, key), nil)
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+// Search returns the keys whose value contains all terms of the query, in lexicographical order.
+// A limit <= 0 means no limit.
+func (ix *Index) Search(query string, limit int) ([]string, error) {
+	terms := Tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	var matches []string
+	err := ix.f.Read(func(r *tridb.Reader) error {
+		for i, term := range terms {
+			keys, err := ix.lookup(r, term)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				matches = keys
+			} else {
+				matches = intersect(matches, keys)
+			}
+			if len(matches) == 0 {
+				return nil
+			}
+		}
+		return nil
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, err
+}
+
+// lookup returns the sorted keys containing the given term.
+func (ix *Index) lookup(r *tridb.Reader, term string) ([]string, error) {
+	termPrefix := ix.termPrefix(term)
+	var keys []string
+	_, err := r.Walk(termPrefix, func(key []byte) error {
+		keys = append(keys, string(key[len(termPrefix):]))
+		return nil
+	})
+	sort.Strings(keys) // walk order depends on the keydir implementation
+	return keys, err
+}
+
+// intersect returns the elements present in both sorted slices.
+func intersect(a, b []string) []string {
+	var out []string
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// Tokenize splits the given text into unique lowercase terms made of letters and digits.
+func Tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+	seen := make(map[string]bool, len(fields))
+	terms := fields[:0]
+	for _, field := range fields {
+		if !seen[field] {
+			seen[field] = true
+			terms = append(terms, field)
+		}
+	}
+	return terms
+}
@@ -0,0 +1,70 @@
+package tridbsearch
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ejuju/tridb/pkg/tridb"
+)
+
+func TestSearch(t *testing.T) {
+	f, err := tridb.Open(filepath.Join(t.TempDir(), "test.tridb"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ix := New(f, "doc/")
+
+	set := func(key, value string) {
+		t.Helper()
+		err := f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+			return ix.Set(r, w, []byte(key), []byte(value))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSearch := func(query string, limit int, want ...string) {
+		t.Helper()
+		got, err := ix.Search(query, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 || len(want) != 0 {
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("search %q: got %q instead of %q", query, got, want)
+			}
+		}
+	}
+
+	set("doc/1", "The quick brown fox")
+	set("doc/2", "A quick, lazy dog!")
+	set("other/3", "quick")
+	assertSearch("QUICK", 0, "doc/1", "doc/2")
+	assertSearch("quick", 1, "doc/1")
+	assertSearch("quick dog", 0, "doc/2")
+	assertSearch("cat", 0)
+
+	// Updates and deletions remove stale postings.
+	set("doc/1", "slow brown fox")
+	assertSearch("quick", 0, "doc/2")
+	err = f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error { return ix.Delete(r, w, []byte("doc/2")) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSearch("quick", 0)
+
+	// Values written without the index are picked up on rebuild.
+	err = f.ReadWrite(func(r *tridb.Reader, w *tridb.Writer) error {
+		w.Set([]byte("doc/4"), []byte("brown bear"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	assertSearch("brown", 0, "doc/1", "doc/4")
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ejuju/tridb/pkg/redcompat"
+	"github.com/ejuju/tridb/pkg/tridb"
+	"github.com/ejuju/tridb/pkg/tridbgrpc"
+	"github.com/ejuju/tridb/pkg/tridbhttp"
+	"github.com/ejuju/tridb/pkg/tridbmetrics"
+)
+
+const serveUsage = `usage: tridb serve [flags] <database file>
+
+Serves the database over HTTP (see package tridbhttp) with Prometheus metrics on /metrics,
+and optionally over the Redis protocol and gRPC (see packages redcompat and tridbgrpc),
+until interrupted (SIGINT or SIGTERM).
+
+Each flag can also be set with an environment variable (ex: -addr with TRIDB_ADDR),
+flags take precedence over environment variables.
+
+Flags:
+`
+
+// serveConfig holds the configuration of the serve command.
+type serveConfig struct {
+	fpath           string
+	addr            string
+	respAddr        string // address of the Redis protocol server (empty disables it)
+	replicationAddr string // address followers connect to (empty disables replication)
+	grpcAddr        string // address of the gRPC server (empty disables it)
+	tlsCert, tlsKey string // certificate and key files of the gRPC server (HTTP/2 requires TLS)
+	token           string
+	keydir          string
+	format          string // row format of new files (empty for the default one)
+	buckets         int
+	metricsPrefixes string
+	compactInterval time.Duration // how often the compaction policy is checked (0 disables auto-compaction)
+	compactMinSize  int           // minimum file size before compacting
+	compactRatio    float64       // minimum ratio of rows to keys before compacting
+	snapshotDir     string        // where snapshots are written (empty disables auto-snapshots)
+	snapshotEvery   time.Duration
+	snapshotKeep    int
+	shutdownTimeout time.Duration
+	lockTimeout     time.Duration // how long to wait for another process to close the file
+	slowSync        time.Duration // syncs slower than this are logged
+}
+
+// parseServeConfig parses the flags of the serve command (and the corresponding environment variables).
+func parseServeConfig(args []string) (*serveConfig, error) {
+	c := &serveConfig{}
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), serveUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&c.addr, "addr", ":8080", "address to listen on")
+	fs.StringVar(&c.respAddr, "resp-addr", "", "address to serve the Redis protocol on (empty disables it, see package redcompat)")
+	fs.StringVar(&c.grpcAddr, "grpc-addr", "", "address to serve gRPC on (empty disables it, see package tridbgrpc)")
+	fs.StringVar(&c.tlsCert, "tls-cert", "", "TLS certificate file of the gRPC server")
+	fs.StringVar(&c.tlsKey, "tls-key", "", "TLS key file of the gRPC server")
+	fs.StringVar(&c.replicationAddr, "replication-addr", "", "address followers connect to (empty disables replication, connections are not authenticated)")
+	fs.StringVar(&c.token, "token", "", "bearer token required by the HTTP API (except /metrics), and password of Redis clients")
+	fs.StringVar(&c.keydir, "keydir", string(tridb.KeydirHash), "in-memory index: hash, trie or adaptive")
+	fs.StringVar(&c.format, "format", "", "row format of new files: binary, text or text-autolength (existing files keep theirs)")
+	fs.IntVar(&c.buckets, "buckets", 1<<20, "number of buckets of the hash keydir")
+	fs.StringVar(&c.metricsPrefixes, "metrics-prefixes", "", "comma-separated key prefixes to count in /metrics")
+	fs.DurationVar(&c.compactInterval, "compact-interval", time.Minute, "how often to check the compaction policy (0 disables auto-compaction)")
+	fs.IntVar(&c.compactMinSize, "compact-min-size", 64<<20, "minimum file size (in bytes) before compacting")
+	fs.Float64Var(&c.compactRatio, "compact-ratio", 2, "minimum ratio of rows to live keys before compacting")
+	fs.StringVar(&c.snapshotDir, "snapshot-dir", "", "directory where snapshots of the file are written (empty disables auto-snapshots)")
+	fs.DurationVar(&c.snapshotEvery, "snapshot-interval", time.Hour, "time between two snapshots")
+	fs.IntVar(&c.snapshotKeep, "snapshot-keep", 24, "number of snapshots to keep")
+	fs.DurationVar(&c.shutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on shutdown")
+	fs.DurationVar(&c.lockTimeout, "lock-timeout", 0, "how long to wait for another process writing to the file to close it")
+	fs.DurationVar(&c.slowSync, "slow-sync", tridb.DefaultSlowSyncThreshold, "log syncs to disk slower than this duration")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Flags not set on the command line fall back to environment variables.
+	set := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	var err error
+	fs.VisitAll(func(fl *flag.Flag) {
+		name := "TRIDB_" + strings.ToUpper(strings.ReplaceAll(fl.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok && !set[fl.Name] && err == nil {
+			if setErr := fs.Set(fl.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", value, name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.fpath = fs.Arg(0)
+	if c.fpath == "" {
+		c.fpath = os.Getenv("TRIDB_FILE")
+	}
+	switch {
+	case c.fpath == "" || fs.NArg() > 1:
+		fs.Usage()
+		return nil, errors.New("expected a single database file path (or TRIDB_FILE)")
+	case c.keydir != string(tridb.KeydirHash) && c.keydir != string(tridb.KeydirTrie) && c.keydir != string(tridb.KeydirAdaptive):
+		return nil, fmt.Errorf("unknown keydir: %q", c.keydir)
+	case c.grpcAddr != "" && (c.tlsCert == "" || c.tlsKey == ""):
+		return nil, errors.New("the gRPC server requires a TLS certificate and key")
+	case c.format != "" && !isFormat(c.format):
+		return nil, fmt.Errorf("unknown format: %q", c.format)
+	case c.snapshotDir != "" && (c.snapshotEvery <= 0 || c.snapshotKeep <= 0):
+		return nil, errors.New("snapshot interval and number of snapshots to keep must be positive")
+	}
+	return c, nil
+}
+
+// isFormat reports whether the given name is the name of a row format (see tridb.Formats).
+func isFormat(name string) bool {
+	_, err := tridb.FormatFromString(name)
+	return err == nil
+}
+
+// runServe runs the serve command until interrupted, the file is then closed gracefully.
+func runServe(args []string) error {
+	c, err := parseServeConfig(args)
+	if err != nil {
+		return err
+	}
+	collector := tridbmetrics.NewCollector()
+	fileOpts := []tridb.Option{
+		tridb.WithKeydir(tridb.KeydirType(c.keydir)), tridb.WithLockTimeout(c.lockTimeout), tridb.WithMetrics(collector),
+		tridb.WithSlowSyncHandler(c.slowSync, func(d time.Duration) { log.Printf("slow sync to disk: %s", d) }),
+	}
+	if c.format != "" {
+		format, _ := tridb.FormatFromString(c.format) // validated by parseServeConfig
+		fileOpts = append(fileOpts, tridb.WithFormat(format))
+	}
+	f, err := tridb.Open(c.fpath, c.buckets, fileOpts...)
+	if err != nil {
+		return err
+	}
+
+	var prefixes []string
+	if c.metricsPrefixes != "" {
+		prefixes = strings.Split(c.metricsPrefixes, ",")
+	}
+	var opts []tridbhttp.Option
+	if c.token != "" {
+		opts = append(opts, tridbhttp.WithToken(c.token))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", tridbhttp.NewHandler(f, opts...))
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := f.WritePrometheus(w, prefixes...); err != nil {
+			log.Println("metrics:", err)
+		} else if err := collector.WritePrometheus(w); err != nil {
+			log.Println("metrics:", err)
+		}
+	})
+	srv := &http.Server{Addr: c.addr, Handler: mux}
+	var respSrv *redcompat.Server
+	if c.respAddr != "" {
+		var respOpts []redcompat.Option
+		if c.token != "" {
+			respOpts = append(respOpts, redcompat.WithPassword(c.token))
+		}
+		respSrv = redcompat.NewServer(f, respOpts...)
+	}
+	var grpcSrv *http.Server
+	if c.grpcAddr != "" {
+		var grpcOpts []tridbgrpc.Option
+		if c.token != "" {
+			grpcOpts = append(grpcOpts, tridbgrpc.WithToken(c.token))
+		}
+		grpcSrv = &http.Server{Addr: c.grpcAddr, Handler: tridbgrpc.NewServer(f, grpcOpts...)}
+	}
+
+	// Background jobs
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	if c.compactInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			every(c.compactInterval, stop, func() { autoCompact(f, c) })
+		}()
+	}
+	if c.snapshotDir != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			every(c.snapshotEvery, stop, func() {
+				if err := writeSnapshot(f, c.snapshotDir, c.snapshotKeep); err != nil {
+					log.Println("snapshot:", err)
+				}
+			})
+		}()
+	}
+
+	// Serve until interrupted
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	serveErr := make(chan error, 4)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	log.Printf("serving %q on %s", f.Path(), c.addr)
+	if respSrv != nil {
+		go func() { serveErr <- respSrv.ListenAndServe(c.respAddr) }()
+		log.Printf("serving the Redis protocol on %s", c.respAddr)
+	}
+	if grpcSrv != nil {
+		go func() { serveErr <- grpcSrv.ListenAndServeTLS(c.tlsCert, c.tlsKey) }()
+		log.Printf("serving gRPC on %s", c.grpcAddr)
+	}
+	var replicationListener net.Listener
+	if c.replicationAddr != "" {
+		replicationListener, err = net.Listen("tcp", c.replicationAddr)
+		if err != nil {
+			serveErr <- fmt.Errorf("listen for followers: %w", err)
+		} else {
+			go func() { serveErr <- f.ServeReplication(replicationListener) }()
+			log.Printf("serving followers on %s", c.replicationAddr)
+		}
+	}
+	select {
+	case err = <-serveErr:
+		srv.Close()
+		if grpcSrv != nil {
+			grpcSrv.Close()
+		}
+	case sig := <-interrupt:
+		log.Printf("received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+		err = srv.Shutdown(ctx)
+		if grpcSrv != nil {
+			err = errors.Join(err, grpcSrv.Shutdown(ctx))
+		}
+		cancel()
+	}
+	if respSrv != nil {
+		err = errors.Join(err, respSrv.Close())
+	}
+	if replicationListener != nil {
+		replicationListener.Close() // followers are disconnected, they reconnect to the next primary
+	}
+	close(stop)
+	wg.Wait()
+	if closeErr := f.CloseWithTimeout(c.shutdownTimeout); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("close file: %w", closeErr))
+	}
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, redcompat.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// every calls do at the given interval until stop is closed.
+func every(interval time.Duration, stop <-chan struct{}, do func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			do()
+		}
+	}
+}
+
+// autoCompact compacts the file once it is big enough and holds enough overwritten or deleted rows.
+func autoCompact(f *tridb.File, c *serveConfig) {
+	stats := f.Stats()
+	if stats.FileSize < c.compactMinSize || float64(stats.Rows) < c.compactRatio*float64(stats.Keys) {
+		return
+	}
+	start := time.Now()
+	if err := f.Compact(); err != nil {
+		log.Println("compact:", err)
+		return
+	}
+	log.Printf("compacted %d bytes to %d bytes in %s", stats.FileSize, f.Stats().FileSize, time.Since(start))
+}
+
+// writeSnapshot writes a copy of the file in the given directory (named after the file and the current time),
+// without blocking writers (see tridb.File.Snapshot), then removes the oldest snapshots beyond the given number.
+func writeSnapshot(f *tridb.File, dir string, keep int) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	snap, err := f.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+	base := filepath.Base(f.Path())
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = snap.CopyTo(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	name := filepath.Join(dir, base+"."+time.Now().UTC().Format("20060102T150405Z"))
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// Timestamps sort lexicographically
+	snapshots, err := filepath.Glob(filepath.Join(dir, base+".*T*Z"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(snapshots)
+	for len(snapshots) > keep {
+		if err := os.Remove(snapshots[0]); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}